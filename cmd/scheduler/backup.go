@@ -0,0 +1,100 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"github.com/spf13/cobra"
+	"io"
+	"os"
+	"slices"
+	"strings"
+)
+
+// scheduler backup and scheduler restore, a logical export of the application data as JSON lines.
+// Files ending in .gz are compressed, - is stdout or stdin.
+func backupCommands() []*cobra.Command {
+	var out string
+	backup := &cobra.Command{
+		Use:   "backup",
+		Short: "Export the application data to a file",
+		Long:  "Export the application data from one consistent snapshot as JSON lines. Restoring needs the same master keys when field encryption is on.",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			a, err := setup()
+			if err != nil {
+				return err
+			}
+			defer a.close()
+
+			var w io.Writer = os.Stdout
+			if out != "-" {
+				file, err := os.Create(out)
+				if err != nil {
+					return err
+				}
+				defer file.Close()
+				w = file
+			}
+			if strings.HasSuffix(out, ".gz") {
+				compressed := gzip.NewWriter(w)
+				defer compressed.Close()
+				w = compressed
+			}
+
+			counts, err := a.db.Backup(cmd.Context(), w)
+			printCounts(counts)
+			return err
+		},
+	}
+	backup.Flags().StringVarP(&out, "out", "o", "-", "file to write, .gz to compress")
+
+	restore := &cobra.Command{
+		Use:   "restore <file>",
+		Short: "Load a backup into an empty, migrated database",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			a, err := setup()
+			if err != nil {
+				return err
+			}
+			defer a.close()
+
+			var r io.Reader = os.Stdin
+			if args[0] != "-" {
+				file, err := os.Open(args[0])
+				if err != nil {
+					return err
+				}
+				defer file.Close()
+				r = file
+			}
+			if strings.HasSuffix(args[0], ".gz") {
+				r, err = gzip.NewReader(r)
+				if err != nil {
+					return err
+				}
+			}
+
+			counts, err := a.db.Restore(cmd.Context(), r)
+			if err != nil {
+				return err
+			}
+			printCounts(counts)
+			return nil
+		},
+	}
+
+	return []*cobra.Command{backup, restore}
+}
+
+// on stderr, so a backup to stdout stays clean
+func printCounts(counts map[string]int) {
+	tables := make([]string, 0, len(counts))
+	for table := range counts {
+		tables = append(tables, table)
+	}
+	slices.Sort(tables)
+	for _, table := range tables {
+		fmt.Fprintf(os.Stderr, "%s: %d rows\n", table, counts[table])
+	}
+}
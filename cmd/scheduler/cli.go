@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/spf13/cobra"
+	api "kode_test/internal/http"
+	"kode_test/internal/schedule"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// one-off runs of the background jobs
+var adminJobs = map[string]func(ctx context.Context, a *app, dryRun bool) error{
+	"reminders": func(ctx context.Context, a *app, dryRun bool) error {
+		sent, err := a.server.SendDueReminders(ctx, time.Now())
+		if err != nil {
+			return err
+		}
+		retried, err := a.server.RetryFailedReminders(ctx)
+		if err == nil {
+			fmt.Printf("%d reminders sent, %d failed ones delivered on retry\n", sent, retried)
+		}
+		return err
+	},
+	// only the failed deliveries that are due, without sending new reminders
+	"reminder-retry": func(ctx context.Context, a *app, dryRun bool) error {
+		retried, err := a.server.RetryFailedReminders(ctx)
+		if err == nil {
+			fmt.Printf("%d failed reminders delivered on retry\n", retried)
+		}
+		return err
+	},
+	// reminders that failed every delivery go back to the reminder worker
+	"dead-letter-replay": func(ctx context.Context, a *app, dryRun bool) error {
+		replayed, err := a.server.ReplayDeadLetters(ctx, dryRun)
+		if err == nil {
+			fmt.Printf("%d dead reminders replayed (dry run: %t)\n", replayed, dryRun)
+		}
+		return err
+	},
+	"completion": func(ctx context.Context, a *app, dryRun bool) error {
+		completed, err := a.server.CompleteFinishedSchedules(ctx)
+		if err == nil {
+			fmt.Printf("%d schedules completed\n", completed)
+		}
+		return err
+	},
+	"recalls": func(ctx context.Context, a *app, dryRun bool) error {
+		return a.server.CheckRecalls(ctx)
+	},
+	"partitions": func(ctx context.Context, a *app, dryRun bool) error {
+		return a.server.MaintainPartitions(ctx)
+	},
+	"retention": func(ctx context.Context, a *app, dryRun bool) error {
+		reports, err := a.server.ApplyRetention(ctx, dryRun)
+		for _, report := range reports {
+			fmt.Printf("%s %s before %s: %d rows (dry run: %t)\n", report.Action, report.Target, report.Cutoff.Format(time.RFC3339), report.Affected, report.DryRun)
+		}
+		return err
+	},
+}
+
+// scheduler serves the API, scheduler admin runs operations against the same configuration
+func rootCommand() *cobra.Command {
+	var mock bool
+	var mockSeed int64
+	root := &cobra.Command{
+		Use:          "scheduler",
+		Short:        "Medicine schedule service",
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if mock {
+				return serveMock(mockSeed)
+			}
+			return serve()
+		},
+	}
+	root.Flags().BoolVar(&mock, "mock", false, "serve seeded schedules from memory and documented examples, without Postgres or sign in")
+	root.Flags().Int64Var(&mockSeed, "mock-seed", 1, "seed of the mock data, the same seed gives the same data")
+
+	// set up by the commands that need the database
+	var a *app
+
+	options := SeedOptions{}
+	seed := &cobra.Command{
+		Use:   "seed",
+		Short: "Generate fake users, schedules and intake history",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if options.Users < 0 || options.Schedules < 0 || options.Days < 0 || options.Adherence < 0 || options.Adherence > 1 {
+				return fmt.Errorf("counts must not be negative and adherence must be between 0 and 1")
+			}
+			a, err := setup()
+			if err != nil {
+				return err
+			}
+			defer a.close()
+
+			return seedData(cmd.Context(), a, options)
+		},
+	}
+	seed.Flags().IntVar(&options.Users, "users", 10, "number of users")
+	seed.Flags().IntVar(&options.Schedules, "schedules", 3, "schedules per user")
+	seed.Flags().IntVar(&options.Days, "days", 30, "days of history before today")
+	seed.Flags().Int64Var(&options.Seed, "seed", 1, "seed of the generator, the same seed gives the same data")
+	seed.Flags().Float64Var(&options.Adherence, "adherence", 0.85, "share of doses with a logged intake")
+	root.AddCommand(seed)
+	root.AddCommand(backupCommands()...)
+
+	admin := &cobra.Command{
+		Use:   "admin",
+		Short: "Operations on the scheduler database",
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			var err error
+			a, err = setup()
+			if err != nil {
+				return err
+			}
+			cobra.OnFinalize(a.close)
+			return nil
+		},
+	}
+	root.AddCommand(admin)
+
+	var baseline string
+	migrate := &cobra.Command{
+		Use:   "migrate",
+		Short: "Apply pending migrations",
+		Long:  "Apply pending migrations. A database with times written before they were stored in UTC needs MIGRATE_LEGACY_TIMEZONE set to the zone of the server that wrote them, like Europe/Berlin.",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			applied, err := a.db.Migrate(cmd.Context(), baseline)
+			for _, version := range applied {
+				fmt.Println("applied", version)
+			}
+			if err == nil && len(applied) == 0 {
+				fmt.Println("nothing to apply")
+			}
+			return err
+		},
+	}
+	migrate.Flags().StringVar(&baseline, "baseline", "", "mark migrations up to this version (e.g. 0018_field_encryption) as applied without running them")
+	admin.AddCommand(migrate)
+
+	var credentials api.Credentials
+	createUser := &cobra.Command{
+		Use:   "create-user",
+		Short: "Create a user account",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if message := api.ValidateSignup(&credentials); message != "" {
+				return fmt.Errorf("%s", message)
+			}
+			userID, err := a.server.CreateUser(cmd.Context(), credentials)
+			if err == nil {
+				fmt.Println(userID)
+			}
+			return err
+		},
+	}
+	createUser.Flags().StringVar(&credentials.Email, "email", "", "email of the user")
+	createUser.Flags().StringVar(&credentials.Password, "password", "", "password of at least 8 characters")
+	createUser.Flags().StringVar(&credentials.Timezone, "timezone", "UTC", "IANA timezone of the user")
+	createUser.MarkFlagRequired("email")
+	createUser.MarkFlagRequired("password")
+	admin.AddCommand(createUser)
+
+	schedules := &cobra.Command{Use: "schedules", Short: "Inspect and delete schedules"}
+	admin.AddCommand(schedules)
+
+	var userID, status string
+	var tags []string
+	list := &cobra.Command{
+		Use:   "list",
+		Short: "List the schedules of a user",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			wanted, err := schedule.NormalizeTags(tags)
+			if err != nil {
+				return err
+			}
+			userSchedules, err := a.server.ListUserSchedules(cmd.Context(), userID, status, time.Time{}, wanted)
+			if err != nil {
+				return err
+			}
+
+			out := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(out, "ID\tUUID\tMEDICINE\tFREQUENCY\tDURATION\tSTATUS\tTAGS\tCREATED")
+			for _, s := range userSchedules {
+				fmt.Fprintf(out, "%d\t%s\t%s\t%d\t%d\t%s\t%s\t%s\n", s.ID, s.UUID, s.Medicine, s.Frequency, s.Duration, s.Status, strings.Join(s.Tags, ","), s.CreatedAt.Format(time.RFC3339))
+			}
+			return out.Flush()
+		},
+	}
+	list.Flags().StringVar(&userID, "user", "", "id of the user")
+	list.Flags().StringVar(&status, "status", "", "only schedules with this status")
+	list.Flags().StringSliceVar(&tags, "tag", nil, "only schedules with these tags")
+	list.MarkFlagRequired("user")
+	schedules.AddCommand(list)
+
+	schedules.AddCommand(&cobra.Command{
+		Use:   "delete <id or uuid>",
+		Short: "Delete a schedule with its intakes",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			scheduleID, err := a.server.ResolveScheduleID(cmd.Context(), args[0])
+			if err != nil {
+				return err
+			}
+			tag, err := a.db.Exec(cmd.Context(), "DELETE FROM schedule WHERE id = $1", scheduleID)
+			if err != nil {
+				return err
+			}
+			if tag.RowsAffected() == 0 {
+				return fmt.Errorf("schedule %s not found", args[0])
+			}
+			fmt.Println("deleted schedule", scheduleID)
+			return nil
+		},
+	})
+
+	var dryRun bool
+	jobNames := make([]string, 0, len(adminJobs))
+	for name := range adminJobs {
+		jobNames = append(jobNames, name)
+	}
+	slices.Sort(jobNames)
+	run := &cobra.Command{
+		Use:       "run <job>",
+		Short:     "Run a background job once",
+		Long:      "Run a background job once: " + strings.Join(jobNames, ", "),
+		Args:      cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+		ValidArgs: jobNames,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return adminJobs[args[0]](cmd.Context(), a, dryRun)
+		},
+	}
+	run.Flags().BoolVar(&dryRun, "dry-run", false, "only report what the job would change, where the job supports it")
+	admin.AddCommand(run)
+
+	return root
+}
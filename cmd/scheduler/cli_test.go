@@ -0,0 +1,21 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestAdminRunJobs(t *testing.T) {
+	run, _, err := rootCommand().Find([]string{"admin", "run"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, job := range []string{"dead-letter-replay", "reminder-retry", "reminders"} {
+		if !slices.Contains(run.ValidArgs, job) {
+			t.Errorf("admin run does not accept %s, jobs: %v", job, run.ValidArgs)
+		}
+	}
+	if err = run.Args(run, []string{"webhooks"}); err == nil {
+		t.Error("admin run accepted an unknown job")
+	}
+}
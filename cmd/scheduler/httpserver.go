@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// the write timeout covers whole responses, so it stays above the one minute next dose long poll
+var serverTimeouts = []struct {
+	name     string
+	fallback time.Duration
+	set      func(server *http.Server, timeout time.Duration)
+}{
+	{"HTTP_READ_HEADER_TIMEOUT", 10 * time.Second, func(s *http.Server, t time.Duration) { s.ReadHeaderTimeout = t }},
+	{"HTTP_READ_TIMEOUT", time.Minute, func(s *http.Server, t time.Duration) { s.ReadTimeout = t }},
+	{"HTTP_WRITE_TIMEOUT", 90 * time.Second, func(s *http.Server, t time.Duration) { s.WriteTimeout = t }},
+	{"HTTP_IDLE_TIMEOUT", 2 * time.Minute, func(s *http.Server, t time.Duration) { s.IdleTimeout = t }},
+}
+
+// the HTTP server with its timeouts, header limit and idle keep-alive limit from the environment,
+// durations like 30s, 0 turns a timeout off
+func newHTTPServer(address string, handler http.Handler) (*http.Server, error) {
+	server := &http.Server{Addr: address, Handler: handler, MaxHeaderBytes: 64 << 10}
+
+	for _, timeout := range serverTimeouts {
+		value := os.Getenv(timeout.name)
+		if value == "" {
+			timeout.set(server, timeout.fallback)
+			continue
+		}
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed < 0 {
+			return nil, fmt.Errorf("invalid %s %q, expected a duration like 30s", timeout.name, value)
+		}
+		timeout.set(server, parsed)
+	}
+
+	if value := os.Getenv("HTTP_MAX_HEADER_BYTES"); value != "" {
+		size, err := strconv.Atoi(value)
+		if err != nil || size <= 0 {
+			return nil, fmt.Errorf("invalid HTTP_MAX_HEADER_BYTES %q", value)
+		}
+		server.MaxHeaderBytes = size
+	}
+
+	if value := os.Getenv("HTTP_MAX_IDLE_CONNECTIONS"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 0 {
+			return nil, fmt.Errorf("invalid HTTP_MAX_IDLE_CONNECTIONS %q", value)
+		}
+		idle := &idleLimit{max: limit, conns: map[net.Conn]struct{}{}}
+		server.ConnState = idle.track
+	}
+
+	return server, nil
+}
+
+// closes keep-alive connections that go idle while max others already are, 0 disables keep-alive
+type idleLimit struct {
+	mu    sync.Mutex
+	max   int
+	conns map[net.Conn]struct{}
+}
+
+func (l *idleLimit) track(conn net.Conn, state http.ConnState) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if state != http.StateIdle {
+		delete(l.conns, conn)
+		return
+	}
+	if len(l.conns) >= l.max {
+		conn.Close()
+		return
+	}
+	l.conns[conn] = struct{}{}
+}
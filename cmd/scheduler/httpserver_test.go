@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestNewHTTPServer(t *testing.T) {
+	server, err := newHTTPServer(":0", http.NotFoundHandler())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if server.ReadHeaderTimeout != 10*time.Second || server.ReadTimeout != time.Minute || server.WriteTimeout != 90*time.Second ||
+		server.IdleTimeout != 2*time.Minute || server.MaxHeaderBytes != 64<<10 || server.ConnState != nil {
+		t.Errorf("defaults %+v", server)
+	}
+
+	t.Setenv("HTTP_WRITE_TIMEOUT", "0")
+	t.Setenv("HTTP_IDLE_TIMEOUT", "15s")
+	t.Setenv("HTTP_MAX_HEADER_BYTES", "8192")
+	t.Setenv("HTTP_MAX_IDLE_CONNECTIONS", "100")
+	server, err = newHTTPServer(":0", http.NotFoundHandler())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if server.WriteTimeout != 0 || server.IdleTimeout != 15*time.Second || server.MaxHeaderBytes != 8192 || server.ConnState == nil {
+		t.Errorf("configured %+v", server)
+	}
+
+	for name, value := range map[string]string{"HTTP_READ_TIMEOUT": "-1s", "HTTP_IDLE_TIMEOUT": "30", "HTTP_MAX_HEADER_BYTES": "0", "HTTP_MAX_IDLE_CONNECTIONS": "many"} {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv(name, value)
+			if _, err := newHTTPServer(":0", http.NotFoundHandler()); err == nil {
+				t.Errorf("%s=%q accepted", name, value)
+			}
+		})
+	}
+}
+
+// a connection that knows whether it was closed
+type trackedConn struct {
+	net.Conn
+	closed bool
+}
+
+func (c *trackedConn) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestIdleLimit(t *testing.T) {
+	limit := &idleLimit{max: 2, conns: map[net.Conn]struct{}{}}
+	conns := []*trackedConn{{}, {}, {}}
+
+	for _, conn := range conns {
+		limit.track(conn, http.StateActive)
+		limit.track(conn, http.StateIdle)
+	}
+	if conns[0].closed || conns[1].closed || !conns[2].closed {
+		t.Errorf("closed %v %v %v, want only the third", conns[0].closed, conns[1].closed, conns[2].closed)
+	}
+
+	// a connection in use again frees its idle slot
+	limit.track(conns[0], http.StateActive)
+	fourth := &trackedConn{}
+	limit.track(fourth, http.StateIdle)
+	if fourth.closed || len(limit.conns) != 2 {
+		t.Errorf("fourth closed %v with %d idle, want open with 2", fourth.closed, len(limit.conns))
+	}
+
+	off := &idleLimit{conns: map[net.Conn]struct{}{}}
+	conn := &trackedConn{}
+	off.track(conn, http.StateIdle)
+	if !conn.closed {
+		t.Error("HTTP_MAX_IDLE_CONNECTIONS=0 kept a connection alive")
+	}
+}
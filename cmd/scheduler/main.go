@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/joho/godotenv"
+	api "kode_test/internal/http"
+	"kode_test/internal/notify"
+	"kode_test/internal/objectstore"
+	"kode_test/internal/pii"
+	"kode_test/internal/prescription"
+	"kode_test/internal/storage"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// the dependencies shared by the server and the admin commands
+type app struct {
+	db     *storage.DB
+	cipher *storage.Cipher
+	server *api.Server
+}
+
+func main() {
+	err := rootCommand().Execute()
+	if err != nil {
+		os.Exit(1)
+	}
+}
+
+// loads the configuration and opens the databases, shared by the server and the admin commands
+func setup() (*app, error) {
+	// nothing personal reaches the logs unredacted
+	log.SetOutput(pii.Writer{Out: os.Stderr})
+
+	err := godotenv.Load(".env")
+	if err != nil {
+		return nil, errors.New("Error loading .env file")
+	}
+
+	source, err := secretSource()
+	if err != nil {
+		return nil, err
+	}
+	if source != nil {
+		err = loadSecrets(context.Background(), source)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load secrets: %w", err)
+		}
+		go runSecretsRefresh(context.Background(), source)
+	}
+	err = storage.CheckFieldEncryption()
+	if err != nil {
+		return nil, err
+	}
+
+	objects, err := objectstore.FromEnv()
+	if err != nil {
+		return nil, err
+	}
+	extractor, err := prescription.FromEnv()
+	if err != nil {
+		return nil, err
+	}
+	caller, err := notify.CallerFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	// chat and push notifiers look users up through the server, which needs the notifier first
+	var server *api.Server
+	notifier, err := notify.FromEnv(func(ctx context.Context, userID string) (string, error) {
+		return server.ChatAddress(ctx, userID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	// verification, reset and invite links only go out by email, there is no fallback to the log
+	relay, err := notify.EmailFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	if relay != nil {
+		notifier = notify.Email{Notifier: notifier, Relay: relay}
+	}
+
+	db, err := storage.Open()
+	if err != nil {
+		return nil, err
+	}
+	go db.RunReplicaHealthCheck(context.Background())
+
+	cipher := storage.NewCipher(db)
+
+	// users with an ntfy or Gotify target get their push notifications there
+	notifier = notify.SelfHostedPush{Notifier: notifier, Targets: func(ctx context.Context, userID string) (*notify.PushTarget, error) {
+		return server.PushTarget(ctx, userID)
+	}}
+	server, err = api.NewServer(db, cipher, notify.WithBreakers(notifier), caller, objects, extractor)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &app{db: db, cipher: cipher, server: server}, nil
+}
+
+func (a *app) close() {
+	a.db.Close()
+}
+
+func serve() error {
+	a, err := setup()
+	if err != nil {
+		return err
+	}
+	defer a.close()
+
+	err = api.CheckClusterConfig(context.Background())
+	if err != nil {
+		return err
+	}
+
+	server, err := newHTTPServer("localhost:3333", a.server.Handler())
+	if err != nil {
+		return err
+	}
+
+	err = a.server.EnsureLocalUser(context.Background())
+	if err != nil {
+		return err
+	}
+
+	// SIGTERM from a deploy stops new work, what was started is finished before exit
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var jobs sync.WaitGroup
+	for _, job := range []func(context.Context){
+		a.server.RunCompletionJob,
+		a.server.RunReminderJob,
+		a.server.RunRecallJob,
+		a.server.RunCaregiverSummaryJob,
+		a.server.RunPartitionJob,
+		a.server.RunRetentionJob,
+		a.server.RunArchiveJob,
+		a.server.RunReportJob,
+		a.server.RunRosterJob,
+		a.server.RunInvalidationListener,
+		a.server.RunUsageJob,
+		a.server.RunAccessLogJob,
+		a.cipher.EncryptSchedules,
+	} {
+		jobs.Add(1)
+		go func() {
+			defer jobs.Done()
+			job(ctx)
+		}()
+	}
+
+	fmt.Println("starting ...")
+
+	served := make(chan error, 1)
+	go func() {
+		served <- server.ListenAndServe()
+	}()
+	select {
+	case err = <-served:
+		stop()
+	case <-ctx.Done():
+	}
+
+	log.Printf("shutting down, draining requests and jobs for up to %s", shutdownTimeout())
+	drainCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout())
+	defer cancel()
+	shutdownErr := server.Shutdown(drainCtx)
+	if shutdownErr != nil {
+		log.Printf("shutdown: %v", shutdownErr)
+	}
+
+	drained := make(chan struct{})
+	go func() {
+		jobs.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-drainCtx.Done():
+		// claims left behind are taken over by another instance once they are stale
+		log.Printf("shutdown: jobs did not finish in time")
+	}
+
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// the API on the same address from memory, for frontend development without Postgres, secrets or sign in.
+// No jobs run and nothing is kept after exit.
+func serveMock(seed int64) error {
+	log.SetOutput(pii.Writer{Out: os.Stderr})
+	// a .env is optional here, it can point OPENAPI_SPEC at the document to mock
+	godotenv.Load(".env")
+
+	mock := api.NewMockServer(mockSchedules(seed, 8))
+	server, err := newHTTPServer("localhost:3333", mock.Handler())
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	fmt.Printf("starting mock server, schedules belong to user_id=%s ...\n", api.MockUserID)
+	served := make(chan error, 1)
+	go func() {
+		served <- server.ListenAndServe()
+	}()
+	select {
+	case err = <-served:
+	case <-ctx.Done():
+		err = server.Shutdown(context.Background())
+	}
+
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// SHUTDOWN_TIMEOUT bounds how long requests and jobs get to finish, 30s by default
+func shutdownTimeout() time.Duration {
+	timeout, err := time.ParseDuration(os.Getenv("SHUTDOWN_TIMEOUT"))
+	if err != nil || timeout <= 0 {
+		return 30 * time.Second
+	}
+
+	return timeout
+}
@@ -0,0 +1,15 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestShutdownTimeout(t *testing.T) {
+	for value, want := range map[string]time.Duration{"": 30 * time.Second, "45s": 45 * time.Second, "0": 30 * time.Second, "-5s": 30 * time.Second, "soon": 30 * time.Second} {
+		t.Setenv("SHUTDOWN_TIMEOUT", value)
+		if got := shutdownTimeout(); got != want {
+			t.Errorf("SHUTDOWN_TIMEOUT=%q = %s, want %s", value, got, want)
+		}
+	}
+}
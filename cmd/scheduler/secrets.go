@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// how often secrets are fetched again to pick up rotated credentials
+const defaultSecretsRefreshInterval = 5 * time.Minute
+
+// a store of secrets that are put into the environment, so every os.Getenv
+// of DATABASE_URL, JWT_SECRET, provider keys and so on sees the current value
+type SecretSource interface {
+	Fetch(ctx context.Context) (map[string]string, error)
+}
+
+var secretsClient = &http.Client{Timeout: 10 * time.Second}
+
+// the source chosen by SECRETS_PROVIDER, nil when secrets only come from the environment
+func secretSource() (SecretSource, error) {
+	switch provider := os.Getenv("SECRETS_PROVIDER"); provider {
+	case "":
+		return nil, nil
+	case "vault":
+		return vaultSource{addr: os.Getenv("VAULT_ADDR"), token: os.Getenv("VAULT_TOKEN"), path: os.Getenv("VAULT_SECRET_PATH")}, nil
+	case "aws":
+		return awsSecretSource{
+			region:       os.Getenv("AWS_REGION"),
+			accessKeyID:  os.Getenv("AWS_ACCESS_KEY_ID"),
+			secretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+			secretID:     os.Getenv("AWS_SECRET_ID"),
+			// the SDKs' name for a service endpoint other than AWS, like LocalStack
+			endpoint: os.Getenv("AWS_ENDPOINT_URL_SECRETS_MANAGER"),
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown SECRETS_PROVIDER %q, expected vault or aws", provider)
+	}
+}
+
+func loadSecrets(ctx context.Context, source SecretSource) error {
+	secrets, err := source.Fetch(ctx)
+	if err != nil {
+		return err
+	}
+
+	for name, value := range secrets {
+		os.Setenv(name, value)
+	}
+
+	return nil
+}
+
+// fetches the secrets again every SECRETS_REFRESH_INTERVAL, keeping the old values on errors
+func runSecretsRefresh(ctx context.Context, source SecretSource) {
+	interval := defaultSecretsRefreshInterval
+	if value := os.Getenv("SECRETS_REFRESH_INTERVAL"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed <= 0 {
+			log.Printf("secrets: invalid SECRETS_REFRESH_INTERVAL %q, using %s", value, interval)
+		} else {
+			interval = parsed
+		}
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		err := loadSecrets(ctx, source)
+		if err != nil {
+			log.Printf("secrets: refresh: %v", err)
+		}
+	}
+}
+
+// reads a KV version 2 secret, VAULT_SECRET_PATH is like secret/data/scheduler
+type vaultSource struct {
+	addr, token, path string
+}
+
+func (v vaultSource) Fetch(ctx context.Context) (map[string]string, error) {
+	if v.addr == "" || v.token == "" || v.path == "" {
+		return nil, fmt.Errorf("VAULT_ADDR, VAULT_TOKEN and VAULT_SECRET_PATH are required")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(v.addr, "/")+"/v1/"+strings.TrimPrefix(v.path, "/"), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", v.token)
+
+	resp, err := secretsClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("vault: unexpected status %s", resp.Status)
+	}
+
+	var body struct {
+		Data struct {
+			Data map[string]string `json:"data"`
+		} `json:"data"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&body)
+	if err != nil {
+		return nil, fmt.Errorf("vault: %w", err)
+	}
+
+	return body.Data.Data, nil
+}
+
+// reads a Secrets Manager secret whose value is a JSON object of names to values
+type awsSecretSource struct {
+	region, accessKeyID, secretKey, sessionToken, secretID, endpoint string
+}
+
+func (a awsSecretSource) Fetch(ctx context.Context) (map[string]string, error) {
+	if a.region == "" || a.accessKeyID == "" || a.secretKey == "" || a.secretID == "" {
+		return nil, fmt.Errorf("AWS_REGION, AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SECRET_ID are required")
+	}
+
+	payload, err := json.Marshal(map[string]string{"SecretId": a.secretID})
+	if err != nil {
+		return nil, err
+	}
+	endpoint := a.endpoint
+	if endpoint == "" {
+		endpoint = "https://secretsmanager." + a.region + ".amazonaws.com"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(endpoint, "/")+"/", bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+	a.sign(req, payload, time.Now().UTC())
+
+	resp, err := secretsClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("secrets manager: unexpected status %s: %s", resp.Status, message)
+	}
+
+	var body struct {
+		SecretString string `json:"SecretString"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&body)
+	if err != nil {
+		return nil, fmt.Errorf("secrets manager: %w", err)
+	}
+
+	secrets := map[string]string{}
+	err = json.Unmarshal([]byte(body.SecretString), &secrets)
+	if err != nil {
+		return nil, fmt.Errorf("secrets manager: secret %s is not a JSON object of strings", a.secretID)
+	}
+
+	return secrets, nil
+}
+
+// adds an AWS Signature Version 4 to the request
+func (a awsSecretSource) sign(req *http.Request, payload []byte, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	if a.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", a.sessionToken)
+	}
+
+	headers := []string{"content-type", "host", "x-amz-date"}
+	if a.sessionToken != "" {
+		headers = append(headers, "x-amz-security-token")
+	}
+	headers = append(headers, "x-amz-target")
+
+	var canonicalHeaders strings.Builder
+	for _, name := range headers {
+		value := req.Header.Get(name)
+		if name == "host" {
+			value = req.URL.Host
+		}
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(value) + "\n")
+	}
+	signedHeaders := strings.Join(headers, ";")
+
+	payloadHash := sha256.Sum256(payload)
+	canonicalRequest := strings.Join([]string{"POST", "/", "", canonicalHeaders.String(), signedHeaders, hex.EncodeToString(payloadHash[:])}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	scope := date + "/" + a.region + "/secretsmanager/aws4_request"
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, hex.EncodeToString(requestHash[:])}, "\n")
+
+	signature := hex.EncodeToString(hmacSHA256(signingKey(a.secretKey, date, a.region, "secretsmanager"), stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", a.accessKeyID, scope, signedHeaders, signature))
+}
+
+// the key of one day, region and service derived from the secret access key
+func signingKey(secretKey string, date string, region string, service string) []byte {
+	key := []byte("AWS4" + secretKey)
+	for _, part := range []string{date, region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+
+	return key
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+
+	return mac.Sum(nil)
+}
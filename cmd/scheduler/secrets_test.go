@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"testing"
+)
+
+func TestSigningKey(t *testing.T) {
+	// the example of deriving a signing key in the AWS Signature Version 4 documentation
+	key := signingKey("wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", "20120215", "us-east-1", "iam")
+	if got, want := hex.EncodeToString(key), "f4780e2d9f65fa895f9c67b32ce1baf0b0d8a43505a000a1a9e090d414db404d"; got != want {
+		t.Errorf("signingKey = %s, want %s", got, want)
+	}
+}
+
+func TestSecretSource(t *testing.T) {
+	tests := []struct {
+		provider string
+		want     string
+		wantErr  bool
+	}{
+		{"", "<nil>", false},
+		{"vault", "main.vaultSource", false},
+		{"aws", "main.awsSecretSource", false},
+		{"gcp", "<nil>", true},
+	}
+	for _, test := range tests {
+		t.Setenv("SECRETS_PROVIDER", test.provider)
+		source, err := secretSource()
+		if got := fmt.Sprintf("%T", source); got != test.want || (err != nil) != test.wantErr {
+			t.Errorf("SECRETS_PROVIDER=%q = %s, %v", test.provider, got, err)
+		}
+	}
+}
+
+func TestVaultSource(t *testing.T) {
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "root" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if r.URL.Path != "/v1/secret/data/scheduler" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprint(w, `{"data": {"data": {"JWT_SECRET": "from-vault"}, "metadata": {"version": 3}}}`)
+	}))
+	defer vault.Close()
+
+	secrets, err := vaultSource{addr: vault.URL + "/", token: "root", path: "/secret/data/scheduler"}.Fetch(context.Background())
+	if err != nil || secrets["JWT_SECRET"] != "from-vault" || len(secrets) != 1 {
+		t.Errorf("Fetch = %v, %v", secrets, err)
+	}
+
+	_, err = vaultSource{addr: vault.URL, token: "guess", path: "secret/data/scheduler"}.Fetch(context.Background())
+	if err == nil {
+		t.Error("Fetch with a wrong token succeeded")
+	}
+	_, err = vaultSource{addr: vault.URL, path: "secret/data/scheduler"}.Fetch(context.Background())
+	if err == nil {
+		t.Error("Fetch without a token succeeded")
+	}
+}
+
+func TestAWSSecretSource(t *testing.T) {
+	authorization := regexp.MustCompile(`^AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/\d{8}/eu-west-1/secretsmanager/aws4_request, ` +
+		`SignedHeaders=content-type;host;x-amz-date;x-amz-security-token;x-amz-target, Signature=[0-9a-f]{64}$`)
+	manager := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		switch {
+		case !authorization.MatchString(r.Header.Get("Authorization")):
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprintf(w, "authorization %q", r.Header.Get("Authorization"))
+		case r.Header.Get("X-Amz-Security-Token") != "session" || r.Header.Get("X-Amz-Target") != "secretsmanager.GetSecretValue":
+			w.WriteHeader(http.StatusBadRequest)
+		case string(body) == `{"SecretId":"scheduler/prod"}`:
+			fmt.Fprint(w, `{"Name": "scheduler/prod", "SecretString": "{\"DATABASE_URL\": \"postgres://db\"}"}`)
+		default:
+			fmt.Fprint(w, `{"Name": "other", "SecretString": "not json"}`)
+		}
+	}))
+	defer manager.Close()
+
+	source := awsSecretSource{region: "eu-west-1", accessKeyID: "AKIDEXAMPLE", secretKey: "secret", sessionToken: "session", secretID: "scheduler/prod", endpoint: manager.URL}
+	secrets, err := source.Fetch(context.Background())
+	if err != nil || secrets["DATABASE_URL"] != "postgres://db" || len(secrets) != 1 {
+		t.Errorf("Fetch = %v, %v", secrets, err)
+	}
+
+	source.secretID = "other"
+	_, err = source.Fetch(context.Background())
+	if err == nil {
+		t.Error("Fetch of a secret that is no JSON object succeeded")
+	}
+
+	source.sessionToken = ""
+	_, err = source.Fetch(context.Background())
+	if err == nil {
+		t.Error("Fetch without the session token succeeded")
+	}
+}
+
+type staticSource map[string]string
+
+func (s staticSource) Fetch(ctx context.Context) (map[string]string, error) {
+	return s, nil
+}
+
+func TestLoadSecrets(t *testing.T) {
+	t.Setenv("JWT_SECRET", "from-env")
+
+	err := loadSecrets(context.Background(), staticSource{"JWT_SECRET": "rotated"})
+	if err != nil || os.Getenv("JWT_SECRET") != "rotated" {
+		t.Errorf("JWT_SECRET = %q, %v", os.Getenv("JWT_SECRET"), err)
+	}
+}
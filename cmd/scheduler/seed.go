@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"golang.org/x/crypto/bcrypt"
+	api "kode_test/internal/http"
+	"kode_test/internal/schedule"
+	"math/rand"
+	"time"
+)
+
+// password of every seeded user
+const seedPassword = "seed-password"
+
+var seedMedicines = []string{
+	"Amoxicillin", "Ibuprofen", "Paracetamol", "Metformin", "Lisinopril", "Atorvastatin",
+	"Omeprazole", "Amlodipine", "Levothyroxine", "Cetirizine", "Prednisone", "Azithromycin",
+}
+
+var seedTimezones = []string{"UTC", "Europe/Berlin", "America/New_York", "Asia/Jakarta", "Australia/Sydney"}
+
+// course lengths in days, 0 never ends
+var seedFrequencies = []int{0, 5, 7, 10, 14, 30, 90}
+
+type SeedOptions struct {
+	Users     int
+	Schedules int
+	// days of intake history before today
+	Days int
+	Seed int64
+	// share of doses with a logged intake
+	Adherence float64
+}
+
+// generates users with schedules and intake history, the same seed gives the same data
+// relative to the current day. Users that exist already are skipped.
+func seedData(ctx context.Context, a *app, options SeedOptions) error {
+	rng := rand.New(rand.NewSource(options.Seed))
+	now := time.Now()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(seedPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	_, err = a.db.Exec(ctx, "SELECT ensure_intake_partitions($1::date, 3)", now.AddDate(0, 0, -options.Days))
+	if err != nil {
+		return err
+	}
+
+	created := 0
+	for u := 0; u < options.Users; u++ {
+		userID := fmt.Sprintf("%016x%016x", rng.Uint64(), rng.Uint64())
+		email := fmt.Sprintf("seed-%d-%d@example.com", options.Seed, u)
+		timezone := seedTimezones[rng.Intn(len(seedTimezones))]
+		loc, err := time.LoadLocation(timezone)
+		if err != nil {
+			return err
+		}
+
+		var schedules []schedule.Schedule
+		for i := 0; i < options.Schedules; i++ {
+			schedules = append(schedules, schedule.Schedule{
+				Medicine:  seedMedicines[rng.Intn(len(seedMedicines))],
+				Frequency: seedFrequencies[rng.Intn(len(seedFrequencies))],
+				Duration:  1 + rng.Intn(4),
+				UserID:    userID,
+				Status:    "active",
+				CreatedAt: now.AddDate(0, 0, -rng.Intn(options.Days+1)),
+			})
+		}
+
+		err = a.db.InTx(ctx, func(tx pgx.Tx) error {
+			query := "INSERT INTO users (id, email, password_hash, timezone) VALUES ($1, $2, $3, $4) ON CONFLICT DO NOTHING"
+			tag, err := tx.Exec(ctx, query, userID, email, string(hash), timezone)
+			if err != nil || tag.RowsAffected() == 0 {
+				return err
+			}
+			created++
+
+			for _, s := range schedules {
+				medicine, medicineHash, err := a.cipher.SealMedicine(s.Medicine)
+				if err != nil {
+					return err
+				}
+				if s.Frequency > 0 && !schedule.CheckDay(s, now, loc) {
+					s.Status = "completed"
+				}
+
+				query := "INSERT INTO schedule (medicine, medicine_hash, frequency, duration, user_id, status, created_at) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id"
+				err = tx.QueryRow(ctx, query, medicine, medicineHash, s.Frequency, s.Duration, userID, s.Status, s.CreatedAt).Scan(&s.ID)
+				if err != nil {
+					return err
+				}
+
+				intakes := seedIntakes(rng, s, now, loc, options.Adherence)
+				_, err = tx.CopyFrom(ctx, pgx.Identifier{"intake_log"}, []string{"schedule_id", "user_id", "taken_at"}, pgx.CopyFromRows(intakes))
+				if err != nil {
+					return err
+				}
+			}
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("seeded %d users (%d existed already), password %q\n", created, options.Users-created, seedPassword)
+	return nil
+}
+
+// intakes of the doses from the start of the schedule until now, a few minutes off the planned time
+func seedIntakes(rng *rand.Rand, s schedule.Schedule, now time.Time, loc *time.Location, adherence float64) [][]interface{} {
+	var intakes [][]interface{}
+	for day := schedule.LocalDate(s.CreatedAt, loc); !day.After(schedule.LocalDate(now, loc)); day = day.AddDate(0, 0, 1) {
+		start := time.Date(day.Year(), day.Month(), day.Day(), 8, 0, 0, 0, loc)
+		if !schedule.CheckDay(s, start, loc) {
+			break
+		}
+
+		interval := time.Duration(0)
+		if s.Duration > 1 {
+			interval = 14 * time.Hour / time.Duration(s.Duration-1)
+		}
+		for dose := 0; dose < s.Duration; dose++ {
+			takenAt := start.Add(time.Duration(dose)*interval + time.Duration(rng.Intn(41)-20)*time.Minute)
+			if rng.Float64() >= adherence || takenAt.Before(s.CreatedAt) || takenAt.After(now) {
+				continue
+			}
+			intakes = append(intakes, []interface{}{s.ID, s.UserID, takenAt})
+		}
+	}
+
+	return intakes
+}
+
+// the schedules mock mode starts with, the same seed gives the same schedules relative to the current day
+func mockSchedules(seed int64, count int) []schedule.Schedule {
+	rng := rand.New(rand.NewSource(seed))
+	today := schedule.LocalDate(time.Now(), time.UTC)
+
+	schedules := make([]schedule.Schedule, count)
+	for i := range schedules {
+		createdAt := today.AddDate(0, 0, -rng.Intn(30)).Add(7 * time.Hour)
+		schedules[i] = schedule.Schedule{
+			ID:        i + 1,
+			UUID:      fmt.Sprintf("00000000-0000-4000-8000-%012d", i+1),
+			Medicine:  seedMedicines[rng.Intn(len(seedMedicines))],
+			Frequency: seedFrequencies[rng.Intn(len(seedFrequencies))],
+			Duration:  1 + rng.Intn(4),
+			UserID:    api.MockUserID,
+			Status:    "active",
+			Version:   1,
+			CreatedAt: createdAt,
+			UpdatedAt: createdAt,
+		}
+		if !schedule.CheckDay(schedules[i], time.Now(), time.UTC) {
+			schedules[i].Status = "completed"
+		}
+	}
+
+	return schedules
+}
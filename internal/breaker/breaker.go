@@ -0,0 +1,145 @@
+// Package breaker stops calling a third party that keeps failing, so a slow or broken
+// provider fails fast instead of holding up the jobs that depend on it.
+package breaker
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	Closed   = "closed"
+	Open     = "open"
+	HalfOpen = "half_open"
+)
+
+var ErrOpen = errors.New("circuit breaker open")
+
+// opens after Threshold failures in a row and lets a single trial call through once
+// Cooldown is over. Every call gets Timeout, a timed out call counts as a failure.
+type Breaker struct {
+	Name      string
+	Threshold int
+	Cooldown  time.Duration
+	Timeout   time.Duration
+
+	mu       sync.Mutex
+	state    string
+	failures int
+	openedAt time.Time
+	trial    bool
+	rejected int64
+}
+
+// the state of a breaker as exported in the runtime stats
+type Stats struct {
+	Name     string     `json:"name"`
+	State    string     `json:"state"`
+	Failures int        `json:"consecutive_failures"`
+	OpenedAt *time.Time `json:"opened_at,omitempty"`
+	Rejected int64      `json:"rejected"`
+}
+
+var (
+	registryMu sync.Mutex
+	registry   []*Breaker
+)
+
+// a breaker registered for the stats
+func New(name string, threshold int, cooldown time.Duration, timeout time.Duration) *Breaker {
+	b := &Breaker{Name: name, Threshold: threshold, Cooldown: cooldown, Timeout: timeout, state: Closed}
+
+	registryMu.Lock()
+	registry = append(registry, b)
+	registryMu.Unlock()
+
+	return b
+}
+
+// runs call unless the breaker is open, the error of the call is returned as is
+func (b *Breaker) Do(ctx context.Context, call func(ctx context.Context) error) error {
+	if !b.allow() {
+		return ErrOpen
+	}
+
+	if b.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, b.Timeout)
+		defer cancel()
+	}
+	err := call(ctx)
+	b.record(err)
+
+	return err
+}
+
+func (b *Breaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch {
+	case b.state == Closed:
+		return true
+	case b.state == Open && time.Since(b.openedAt) >= b.Cooldown:
+		b.state, b.trial = HalfOpen, true
+		return true
+	case b.state == HalfOpen && !b.trial:
+		b.trial = true
+		return true
+	}
+	b.rejected++
+
+	return false
+}
+
+func (b *Breaker) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.trial = false
+	// the caller giving up says nothing about the provider
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+	if err == nil {
+		b.state, b.failures = Closed, 0
+		return
+	}
+
+	b.failures++
+	if b.state == HalfOpen || b.failures >= b.Threshold {
+		b.state, b.openedAt = Open, time.Now()
+	}
+}
+
+func (b *Breaker) Stats() Stats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	stats := Stats{Name: b.Name, State: b.state, Failures: b.failures, Rejected: b.rejected}
+	if b.state != Closed {
+		openedAt := b.openedAt
+		stats.OpenedAt = &openedAt
+	}
+
+	return stats
+}
+
+// the stats of every breaker, by name
+func All() []Stats {
+	registryMu.Lock()
+	breakers := slices.Clone(registry)
+	registryMu.Unlock()
+
+	stats := make([]Stats, len(breakers))
+	for i, b := range breakers {
+		stats[i] = b.Stats()
+	}
+	slices.SortFunc(stats, func(a, b Stats) int { return strings.Compare(a.Name, b.Name) })
+
+	return stats
+}
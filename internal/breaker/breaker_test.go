@@ -0,0 +1,53 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	b := &Breaker{Name: "test", Threshold: 2, Cooldown: 20 * time.Millisecond, state: Closed}
+	fail := func(ctx context.Context) error { return errors.New("provider down") }
+	succeed := func(ctx context.Context) error { return nil }
+
+	for range 2 {
+		if err := b.Do(context.Background(), fail); errors.Is(err, ErrOpen) {
+			t.Fatalf("breaker open before the threshold")
+		}
+	}
+	if err := b.Do(context.Background(), succeed); !errors.Is(err, ErrOpen) {
+		t.Fatalf("Do after %d failures = %v, want ErrOpen", b.Threshold, err)
+	}
+
+	// a failed trial opens the breaker again
+	time.Sleep(b.Cooldown)
+	b.Do(context.Background(), fail)
+	if got := b.Stats().State; got != Open {
+		t.Fatalf("state after failed trial = %s, want %s", got, Open)
+	}
+
+	time.Sleep(b.Cooldown)
+	if err := b.Do(context.Background(), succeed); err != nil {
+		t.Fatalf("trial call = %v, want nil", err)
+	}
+	if stats := b.Stats(); stats.State != Closed || stats.Failures != 0 || stats.Rejected != 1 {
+		t.Errorf("stats after successful trial = %+v", stats)
+	}
+}
+
+func TestBreakerTimeout(t *testing.T) {
+	b := &Breaker{Name: "test", Threshold: 1, Cooldown: time.Minute, Timeout: 10 * time.Millisecond, state: Closed}
+
+	err := b.Do(context.Background(), func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Do = %v, want deadline exceeded", err)
+	}
+	if got := b.Stats().State; got != Open {
+		t.Errorf("state after timeout = %s, want %s", got, Open)
+	}
+}
@@ -0,0 +1,518 @@
+// Package contract checks request and response bodies against an OpenAPI 3 document, so drift
+// between the handlers and the published API shows up in development and staging instead of in clients.
+// It understands the JSON Schema keywords the API documents use: type, nullable, required, properties,
+// additionalProperties, items, enum, the length and range limits, allOf, anyOf, oneOf and local $refs.
+package contract
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"math"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// one way a body breaks the document. Path is the JSON path of the value like pill.shape or
+// tags[2], empty for the whole body. Rule is the keyword it broke.
+type Violation struct {
+	Path    string
+	Rule    string
+	Message string
+}
+
+func (v Violation) String() string {
+	if v.Path == "" {
+		return v.Message
+	}
+
+	return v.Path + ": " + v.Message
+}
+
+type Document struct {
+	raw        []byte
+	schemas    map[string]*Schema
+	operations []*Operation
+}
+
+// a method on a path of the document with the schemas of its JSON bodies
+type Operation struct {
+	doc      *Document
+	Method   string
+	Path     string
+	segments []string
+	// nil when the body is not described
+	request         *Schema
+	requestRequired bool
+	// by status code, range like 2XX or default
+	responses map[string]*Schema
+	statuses  []string
+}
+
+type Schema struct {
+	Ref                  string             `json:"$ref"`
+	Type                 string             `json:"type"`
+	Format               string             `json:"format"`
+	Example              json.RawMessage    `json:"example"`
+	Nullable             bool               `json:"nullable"`
+	Required             []string           `json:"required"`
+	Properties           map[string]*Schema `json:"properties"`
+	AdditionalProperties json.RawMessage    `json:"additionalProperties"`
+	Items                *Schema            `json:"items"`
+	Enum                 []any              `json:"enum"`
+	Minimum              *float64           `json:"minimum"`
+	Maximum              *float64           `json:"maximum"`
+	MinLength            *int               `json:"minLength"`
+	MaxLength            *int               `json:"maxLength"`
+	MinItems             *int               `json:"minItems"`
+	MaxItems             *int               `json:"maxItems"`
+	AllOf                []*Schema          `json:"allOf"`
+	AnyOf                []*Schema          `json:"anyOf"`
+	OneOf                []*Schema          `json:"oneOf"`
+}
+
+type mediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+type document struct {
+	OpenAPI    string                                `json:"openapi"`
+	Paths      map[string]map[string]json.RawMessage `json:"paths"`
+	Components struct {
+		Schemas map[string]*Schema `json:"schemas"`
+	} `json:"components"`
+}
+
+type operation struct {
+	RequestBody *struct {
+		Required bool                 `json:"required"`
+		Content  map[string]mediaType `json:"content"`
+	} `json:"requestBody"`
+	Responses map[string]struct {
+		Content map[string]mediaType `json:"content"`
+	} `json:"responses"`
+}
+
+var methods = []string{"get", "put", "post", "delete", "patch", "head", "options"}
+
+// reads an OpenAPI 3 document in JSON
+func Load(path string) (*Document, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return Parse(data)
+}
+
+func Parse(data []byte) (*Document, error) {
+	var parsed document
+	err := json.Unmarshal(data, &parsed)
+	if err != nil {
+		return nil, fmt.Errorf("invalid OpenAPI document: %w", err)
+	}
+	if !strings.HasPrefix(parsed.OpenAPI, "3.") {
+		return nil, fmt.Errorf("unsupported OpenAPI version %q, expected 3.x", parsed.OpenAPI)
+	}
+
+	doc := &Document{raw: data, schemas: parsed.Components.Schemas}
+	for path, items := range parsed.Paths {
+		for method, raw := range items {
+			if !slices.Contains(methods, method) {
+				continue
+			}
+			var op operation
+			err := json.Unmarshal(raw, &op)
+			if err != nil {
+				return nil, fmt.Errorf("invalid operation %s %s: %w", strings.ToUpper(method), path, err)
+			}
+			parsedOp := &Operation{doc: doc, Method: strings.ToUpper(method), Path: path, segments: strings.Split(strings.Trim(path, "/"), "/"), responses: map[string]*Schema{}}
+			if op.RequestBody != nil {
+				parsedOp.request = jsonSchema(op.RequestBody.Content)
+				parsedOp.requestRequired = op.RequestBody.Required
+			}
+			for status, response := range op.Responses {
+				parsedOp.statuses = append(parsedOp.statuses, strings.ToUpper(status))
+				if schema := jsonSchema(response.Content); schema != nil {
+					parsedOp.responses[strings.ToUpper(status)] = schema
+				}
+			}
+			doc.operations = append(doc.operations, parsedOp)
+		}
+	}
+
+	return doc, nil
+}
+
+// the schema of the JSON media type of a body, nil when there is none
+func jsonSchema(content map[string]mediaType) *Schema {
+	for contentType, media := range content {
+		if strings.HasPrefix(contentType, "application/json") || strings.HasSuffix(contentType, "+json") {
+			return media.Schema
+		}
+	}
+
+	return nil
+}
+
+// the document as it was loaded, for serving it
+func (d *Document) Raw() []byte {
+	return d.raw
+}
+
+// the operation of the method on the path, a path with fewer parameters wins so
+// /v1/schedules/search is not taken for /v1/schedules/{id}
+func (d *Document) Find(method string, path string) (*Operation, bool) {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	var found *Operation
+	fewest := 0
+	for _, op := range d.operations {
+		if op.Method != method || len(op.segments) != len(segments) {
+			continue
+		}
+		params, ok := 0, true
+		for i, segment := range op.segments {
+			if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+				params++
+			} else if segment != segments[i] {
+				ok = false
+				break
+			}
+		}
+		if ok && (found == nil || params < fewest) {
+			found, fewest = op, params
+		}
+	}
+
+	return found, found != nil
+}
+
+// checks a JSON request body, an empty body only breaks a required one
+func (op *Operation) CheckRequest(body []byte) []Violation {
+	if len(bytes.TrimSpace(body)) == 0 {
+		if op.requestRequired {
+			return []Violation{{Rule: "required", Message: "request body is required"}}
+		}
+		return nil
+	}
+	if op.request == nil {
+		return nil
+	}
+
+	return op.check(op.request, body)
+}
+
+// checks a JSON response body against the schema of its status, of its range like 2XX or the default
+func (op *Operation) CheckResponse(status int, body []byte) []Violation {
+	code := strconv.Itoa(status)
+	schema, ok := op.responses[code]
+	if !ok {
+		schema, ok = op.responses[code[:1]+"XX"]
+	}
+	if !ok {
+		schema = op.responses["DEFAULT"]
+	}
+	if schema == nil {
+		return nil
+	}
+
+	return op.check(schema, body)
+}
+
+// a response the operation documents for mock servers: its first success status and a body
+// from the examples of the schema, made up from the types where there are none. The body is
+// nil without a JSON schema, the same document always gives the same response.
+func (op *Operation) Example() (int, []byte, bool) {
+	statuses := slices.Clone(op.statuses)
+	slices.Sort(statuses)
+	for _, status := range statuses {
+		if !strings.HasPrefix(status, "2") {
+			continue
+		}
+		code, err := strconv.Atoi(strings.Replace(status, "XX", "00", 1))
+		if err != nil {
+			continue
+		}
+		schema := op.responses[status]
+		if schema == nil {
+			return code, nil, true
+		}
+		body, err := json.Marshal(op.doc.example(schema, 0))
+		return code, body, err == nil
+	}
+
+	return 0, nil, false
+}
+
+// deep enough for the nesting of the API, recursive schemas stop here
+const maxExampleDepth = 8
+
+func (d *Document) example(schema *Schema, depth int) any {
+	schema = d.resolve(schema)
+	if schema == nil || depth > maxExampleDepth {
+		return nil
+	}
+	if len(schema.Example) > 0 {
+		var value any
+		if json.Unmarshal(schema.Example, &value) == nil {
+			return value
+		}
+	}
+	if len(schema.Enum) > 0 {
+		return schema.Enum[0]
+	}
+	if len(schema.AllOf) > 0 {
+		merged := map[string]any{}
+		for _, part := range schema.AllOf {
+			if object, ok := d.example(part, depth+1).(map[string]any); ok {
+				maps.Copy(merged, object)
+			}
+		}
+		return merged
+	}
+	for _, alternatives := range [][]*Schema{schema.OneOf, schema.AnyOf} {
+		if len(alternatives) > 0 {
+			return d.example(alternatives[0], depth+1)
+		}
+	}
+
+	switch {
+	case schema.Type == "object" || (schema.Type == "" && len(schema.Properties) > 0):
+		object := map[string]any{}
+		for name, property := range schema.Properties {
+			object[name] = d.example(property, depth+1)
+		}
+		return object
+	case schema.Type == "array":
+		return []any{d.example(schema.Items, depth+1)}
+	case schema.Type == "integer" || schema.Type == "number":
+		if schema.Minimum != nil {
+			return *schema.Minimum
+		}
+		return 1
+	case schema.Type == "boolean":
+		return true
+	case schema.Type == "string":
+		return exampleString(schema)
+	}
+
+	return nil
+}
+
+func exampleString(schema *Schema) string {
+	value := "string"
+	switch schema.Format {
+	case "date-time":
+		value = "2025-01-01T08:00:00Z"
+	case "date":
+		value = "2025-01-01"
+	case "uuid":
+		value = "00000000-0000-4000-8000-000000000000"
+	case "email":
+		value = "user@example.com"
+	case "uri":
+		value = "https://example.com"
+	}
+	if schema.MinLength != nil && len(value) < *schema.MinLength {
+		value += strings.Repeat("x", *schema.MinLength-len(value))
+	}
+	if schema.MaxLength != nil && len(value) > *schema.MaxLength {
+		value = value[:*schema.MaxLength]
+	}
+
+	return value
+}
+
+func (op *Operation) check(schema *Schema, body []byte) []Violation {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var value any
+	err := decoder.Decode(&value)
+	if err != nil {
+		return []Violation{{Rule: "type", Message: "body is not valid JSON"}}
+	}
+
+	var violations []Violation
+	op.doc.validate(schema, value, "", &violations)
+	return violations
+}
+
+func (d *Document) resolve(schema *Schema) *Schema {
+	for depth := 0; schema != nil && schema.Ref != "" && depth < 32; depth++ {
+		name, ok := strings.CutPrefix(schema.Ref, "#/components/schemas/")
+		if !ok {
+			return nil
+		}
+		schema = d.schemas[name]
+	}
+
+	return schema
+}
+
+func (d *Document) validate(schema *Schema, value any, path string, violations *[]Violation) {
+	schema = d.resolve(schema)
+	if schema == nil {
+		return
+	}
+	add := func(rule string, format string, args ...any) {
+		*violations = append(*violations, Violation{Path: path, Rule: rule, Message: fmt.Sprintf(format, args...)})
+	}
+
+	for _, part := range schema.AllOf {
+		d.validate(part, value, path, violations)
+	}
+	for _, alternatives := range [][]*Schema{schema.AnyOf, schema.OneOf} {
+		if len(alternatives) > 0 && !slices.ContainsFunc(alternatives, func(alternative *Schema) bool {
+			var found []Violation
+			d.validate(alternative, value, path, &found)
+			return len(found) == 0
+		}) {
+			add("type", "matches none of the allowed schemas")
+		}
+	}
+
+	if value == nil {
+		if !schema.Nullable && schema.Type != "" {
+			add("type", "must be %s, not null", withArticle(schema.Type))
+		}
+		return
+	}
+	if schema.Type != "" && !hasType(value, schema.Type) {
+		add("type", "must be %s", withArticle(schema.Type))
+		return
+	}
+	if len(schema.Enum) > 0 && !slices.ContainsFunc(schema.Enum, func(allowed any) bool { return sameValue(allowed, value) }) {
+		add("enum", "must be one of %s", enumList(schema.Enum))
+	}
+
+	switch v := value.(type) {
+	case string:
+		length := len([]rune(v))
+		if schema.MinLength != nil && length < *schema.MinLength {
+			add("min_length", "must be at least %d characters", *schema.MinLength)
+		}
+		if schema.MaxLength != nil && length > *schema.MaxLength {
+			add("max_length", "must be at most %d characters", *schema.MaxLength)
+		}
+		if !validFormat(schema.Format, v) {
+			add("format", "must be a %s", schema.Format)
+		}
+	case json.Number:
+		number, _ := v.Float64()
+		if schema.Minimum != nil && number < *schema.Minimum {
+			add("min", "must be at least %v", *schema.Minimum)
+		}
+		if schema.Maximum != nil && number > *schema.Maximum {
+			add("max", "must be at most %v", *schema.Maximum)
+		}
+	case []any:
+		if schema.MinItems != nil && len(v) < *schema.MinItems {
+			add("min_items", "must have at least %d items", *schema.MinItems)
+		}
+		if schema.MaxItems != nil && len(v) > *schema.MaxItems {
+			add("max_items", "must have at most %d items", *schema.MaxItems)
+		}
+		for i, item := range v {
+			d.validate(schema.Items, item, fmt.Sprintf("%s[%d]", path, i), violations)
+		}
+	case map[string]any:
+		for _, name := range schema.Required {
+			if _, ok := v[name]; !ok {
+				*violations = append(*violations, Violation{Path: join(path, name), Rule: "required", Message: "is required"})
+			}
+		}
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		for _, name := range names {
+			if property, ok := schema.Properties[name]; ok {
+				d.validate(property, v[name], join(path, name), violations)
+				continue
+			}
+			additional := bytes.TrimSpace(schema.AdditionalProperties)
+			if string(additional) == "false" {
+				*violations = append(*violations, Violation{Path: join(path, name), Rule: "unknown", Message: "is not a known field"})
+			} else if len(additional) > 0 && additional[0] == '{' {
+				var extra Schema
+				if json.Unmarshal(additional, &extra) == nil {
+					d.validate(&extra, v[name], join(path, name), violations)
+				}
+			}
+		}
+	}
+}
+
+func join(path string, name string) string {
+	if path == "" {
+		return name
+	}
+
+	return path + "." + name
+}
+
+func hasType(value any, kind string) bool {
+	switch v := value.(type) {
+	case string:
+		return kind == "string"
+	case bool:
+		return kind == "boolean"
+	case json.Number:
+		if kind == "integer" {
+			number, err := v.Float64()
+			return err == nil && number == math.Trunc(number)
+		}
+		return kind == "number"
+	case []any:
+		return kind == "array"
+	case map[string]any:
+		return kind == "object"
+	}
+
+	return false
+}
+
+func sameValue(allowed any, value any) bool {
+	if number, ok := value.(json.Number); ok {
+		allowedNumber, ok := allowed.(float64)
+		parsed, err := number.Float64()
+		return ok && err == nil && allowedNumber == parsed
+	}
+
+	return allowed == value
+}
+
+func enumList(values []any) string {
+	list := make([]string, len(values))
+	for i, value := range values {
+		list[i] = fmt.Sprint(value)
+	}
+
+	return strings.Join(list, ", ")
+}
+
+func withArticle(kind string) string {
+	if kind == "integer" || kind == "object" || kind == "array" {
+		return "an " + kind
+	}
+
+	return "a " + kind
+}
+
+// the formats the API uses, others are not checked
+func validFormat(format string, value string) bool {
+	var err error
+	switch format {
+	case "date-time":
+		_, err = time.Parse(time.RFC3339Nano, value)
+	case "date":
+		_, err = time.Parse("2006-01-02", value)
+	}
+
+	return err == nil
+}
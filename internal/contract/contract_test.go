@@ -0,0 +1,113 @@
+package contract
+
+import (
+	"slices"
+	"testing"
+)
+
+const testDocument = `{
+	"openapi": "3.0.3",
+	"paths": {
+		"/v1/schedules/{id}": {
+			"put": {
+				"requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Schedule"}}}},
+				"responses": {"200": {"content": {"application/json": {"schema": {"$ref": "#/components/schemas/Schedule"}}}}}
+			}
+		},
+		"/v1/schedules/search": {
+			"get": {
+				"responses": {"2XX": {"content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/Schedule"}}}}}}
+			}
+		}
+	},
+	"components": {
+		"schemas": {
+			"Schedule": {
+				"type": "object",
+				"required": ["medicine", "duration"],
+				"additionalProperties": false,
+				"properties": {
+					"medicine": {"type": "string", "minLength": 1},
+					"duration": {"type": "integer", "minimum": 1},
+					"status": {"type": "string", "enum": ["active", "paused"]},
+					"tags": {"type": "array", "maxItems": 2, "items": {"type": "string"}},
+					"pill": {"type": "object", "nullable": true, "properties": {"shape": {"type": "string", "enum": ["round", "oval"]}}}
+				}
+			}
+		}
+	}
+}`
+
+func TestFind(t *testing.T) {
+	doc, err := Parse([]byte(testDocument))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if op, ok := doc.Find("GET", "/v1/schedules/search"); !ok || op.Path != "/v1/schedules/search" {
+		t.Errorf("search not found: %v", op)
+	}
+	if op, ok := doc.Find("PUT", "/v1/schedules/42"); !ok || op.Path != "/v1/schedules/{id}" {
+		t.Errorf("schedule not found: %v", op)
+	}
+	if _, ok := doc.Find("DELETE", "/v1/schedules/42"); ok {
+		t.Errorf("found an undocumented method")
+	}
+}
+
+func TestCheckBodies(t *testing.T) {
+	doc, err := Parse([]byte(testDocument))
+	if err != nil {
+		t.Fatal(err)
+	}
+	op, _ := doc.Find("PUT", "/v1/schedules/42")
+
+	tests := []struct {
+		body string
+		want []string
+	}{
+		{`{"medicine": "Aspirin", "duration": 2, "status": "active", "pill": null}`, nil},
+		{``, []string{":required"}},
+		{`{"duration": 0}`, []string{"medicine:required", "duration:min"}},
+		{`{"medicine": "Aspirin", "duration": 1.5}`, []string{"duration:type"}},
+		{`{"medicine": "Aspirin", "duration": 1, "status": "done", "color": "red"}`, []string{"color:unknown", "status:enum"}},
+		{`{"medicine": "Aspirin", "duration": 1, "tags": ["a", 2, "c"], "pill": {"shape": "star"}}`, []string{"pill.shape:enum", "tags:max_items", "tags[1]:type"}},
+	}
+	for _, test := range tests {
+		var got []string
+		for _, violation := range op.CheckRequest([]byte(test.body)) {
+			got = append(got, violation.Path+":"+violation.Rule)
+		}
+		if !slices.Equal(got, test.want) {
+			t.Errorf("CheckRequest(%s) = %v, want %v", test.body, got, test.want)
+		}
+	}
+
+	search, _ := doc.Find("GET", "/v1/schedules/search")
+	if violations := search.CheckResponse(200, []byte(`[{"medicine": "Aspirin"}]`)); len(violations) != 1 || violations[0].Path != "[0].duration" {
+		t.Errorf("CheckResponse = %v, want the missing duration", violations)
+	}
+	if violations := search.CheckResponse(500, []byte(`{"error": "internal server error"}`)); violations != nil {
+		t.Errorf("undocumented status checked: %v", violations)
+	}
+}
+
+func TestExample(t *testing.T) {
+	doc, err := Parse([]byte(testDocument))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	op, _ := doc.Find("GET", "/v1/schedules/search")
+	status, body, ok := op.Example()
+	if !ok || status != 200 {
+		t.Fatalf("Example() = %d, %t", status, ok)
+	}
+	if violations := op.CheckResponse(status, body); violations != nil {
+		t.Errorf("example %s breaks its own schema: %v", body, violations)
+	}
+	want := `[{"duration":1,"medicine":"string","pill":{"shape":"round"},"status":"active","tags":["string"]}]`
+	if string(body) != want {
+		t.Errorf("example = %s, want %s", body, want)
+	}
+}
@@ -0,0 +1,137 @@
+// Package features decides who gets a feature that ships dark: nobody, chosen users, a share
+// of all users that grows during the rollout, or everyone.
+package features
+
+import (
+	"fmt"
+	"hash/fnv"
+	"maps"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var namePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]{0,63}$`)
+
+type Flag struct {
+	Name string `json:"name"`
+	// on for everyone
+	Enabled bool `json:"enabled"`
+	// the share of users it is on for, 0 to 100
+	Percentage int `json:"percentage"`
+	// on for these users whatever the percentage
+	Users       []string `json:"users"`
+	Description string   `json:"description,omitempty"`
+}
+
+func ValidName(name string) bool {
+	return namePattern.MatchString(name)
+}
+
+// whether the flag is on for the user. Work that is not done for one user, like most jobs,
+// passes no user and only gets flags that are on for everyone.
+func (f Flag) EnabledFor(userID string) bool {
+	if f.Enabled {
+		return true
+	}
+	if userID == "" {
+		return false
+	}
+
+	return slices.Contains(f.Users, userID) || bucket(f.Name, userID) < f.Percentage
+}
+
+// the place 0 to 99 of the user in the rollout of a flag. It is stable, so raising the percentage
+// only adds users, and differs per flag, so the same users are not first for every feature.
+func bucket(name string, userID string) int {
+	h := fnv.New32a()
+	h.Write([]byte(name + "\x00" + userID))
+
+	return int(h.Sum32() % 100)
+}
+
+// flags from configuration like "recurrence-v2=25%,search=on,export=off"
+func Parse(config string) (map[string]Flag, error) {
+	flags := map[string]Flag{}
+	for _, entry := range strings.Split(config, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, value, _ := strings.Cut(entry, "=")
+		name = strings.TrimSpace(name)
+		if !ValidName(name) {
+			return nil, fmt.Errorf("invalid feature flag name %q", name)
+		}
+
+		flag := Flag{Name: name}
+		switch value = strings.TrimSpace(value); value {
+		case "on", "true":
+			flag.Enabled = true
+		case "off", "false":
+		default:
+			percentage, err := strconv.Atoi(strings.TrimSuffix(value, "%"))
+			if err != nil || percentage < 0 || percentage > 100 {
+				return nil, fmt.Errorf("invalid value %q of feature flag %s, expected on, off or a percentage", value, name)
+			}
+			flag.Percentage = percentage
+		}
+		flags[name] = flag
+	}
+
+	return flags, nil
+}
+
+// the flags of an instance: the configured ones, and the stored ones that take their place
+type Set struct {
+	mu         sync.RWMutex
+	configured map[string]Flag
+	stored     map[string]Flag
+}
+
+func NewSet(configured map[string]Flag) *Set {
+	return &Set{configured: configured, stored: map[string]Flag{}}
+}
+
+// replaces all stored flags, as read from the database
+func (s *Set) Replace(stored []Flag) {
+	flags := make(map[string]Flag, len(stored))
+	for _, flag := range stored {
+		flags[flag.Name] = flag
+	}
+
+	s.mu.Lock()
+	s.stored = flags
+	s.mu.Unlock()
+}
+
+// a flag nobody configured or stored is off
+func (s *Set) Enabled(name string, userID string) bool {
+	flag, ok := s.Get(name)
+	return ok && flag.EnabledFor(userID)
+}
+
+func (s *Set) Get(name string) (Flag, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	flag, ok := s.stored[name]
+	if !ok {
+		flag, ok = s.configured[name]
+	}
+	return flag, ok
+}
+
+// every flag by name, stored ones in place of configured ones
+func (s *Set) All() []Flag {
+	s.mu.RLock()
+	flags := maps.Clone(s.configured)
+	maps.Copy(flags, s.stored)
+	s.mu.RUnlock()
+
+	return slices.SortedFunc(maps.Values(flags), func(a, b Flag) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+}
@@ -0,0 +1,59 @@
+package features
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	flags, err := Parse("recurrence-v2=25%, search=on,export=off")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flags["recurrence-v2"].Percentage != 25 || !flags["search"].Enabled || flags["export"].Enabled {
+		t.Errorf("Parse = %+v", flags)
+	}
+
+	for _, config := range []string{"Search=on", "search=sometimes", "search=101%"} {
+		if _, err := Parse(config); err == nil {
+			t.Errorf("Parse(%q) accepted", config)
+		}
+	}
+}
+
+func TestRollout(t *testing.T) {
+	flag := Flag{Name: "recurrence-v2", Percentage: 30, Users: []string{"tester"}}
+
+	on := 0
+	for i := range 1000 {
+		userID := fmt.Sprintf("user-%d", i)
+		if flag.EnabledFor(userID) {
+			on++
+		}
+		// raising the percentage keeps everyone who had the feature
+		wider := flag
+		wider.Percentage = 60
+		if flag.EnabledFor(userID) && !wider.EnabledFor(userID) {
+			t.Fatalf("%s lost the feature when the rollout grew", userID)
+		}
+	}
+	if on < 250 || on > 350 {
+		t.Errorf("%d of 1000 users at 30%%", on)
+	}
+
+	if !flag.EnabledFor("tester") || flag.EnabledFor("") {
+		t.Errorf("listed users get the feature, work without a user only gets what is on for everyone")
+	}
+}
+
+func TestStoredWinsOverConfigured(t *testing.T) {
+	set := NewSet(map[string]Flag{"search": {Name: "search", Enabled: true}, "export": {Name: "export"}})
+	set.Replace([]Flag{{Name: "search"}})
+
+	if set.Enabled("search", "user") || set.Enabled("unknown", "user") {
+		t.Errorf("stored flag or unknown flag on")
+	}
+	if all := set.All(); len(all) != 2 || all[0].Name != "export" || all[1].Enabled {
+		t.Errorf("All = %+v", all)
+	}
+}
@@ -0,0 +1,197 @@
+package http
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"kode_test/internal/schedule"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// in compliance mode every read of patient data is written to access_log
+func complianceMode() bool {
+	return os.Getenv("COMPLIANCE_MODE") == "true"
+}
+
+// logs reads of the resource before serving them, a read that can not be logged is refused.
+// It goes inside scoped so the caller is already known and user_id names the patient.
+func (srv *Server) accessLogged(resource string, next http.HandlerFunc) http.HandlerFunc {
+	return srv.logAccess(resource, func(r *http.Request) string { return r.URL.Query().Get("user_id") }, next)
+}
+
+// logs reads of the patient in the path by their clinicians and caregivers, it goes inside authenticated
+func (srv *Server) patientAccessLogged(resource string, next http.HandlerFunc) http.HandlerFunc {
+	return srv.logAccess(resource, func(r *http.Request) string { return r.PathValue("id") }, next)
+}
+
+func (srv *Server) logAccess(resource string, subject func(r *http.Request) string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !complianceMode() || (r.Method != http.MethodGet && r.Method != http.MethodHead) {
+			next(w, r)
+			return
+		}
+
+		urlParams := r.URL.Query()
+		recordID := r.PathValue("id")
+		if recordID == "" {
+			recordID = urlParams.Get("schedule_id")
+		}
+		entry := accessEntry{
+			actor: currentUserID(r), subject: subject(r), resource: resource, recordID: recordID,
+			method: r.Method, path: r.URL.RequestURI(), ip: clientIP(r), userAgent: r.UserAgent(), at: time.Now(),
+		}
+		if srv.inMaintenance() {
+			// reads write nothing in maintenance, the entry is kept in memory and written by
+			// RunAccessLogJob once maintenance ends. A restart before that loses it.
+			srv.accessLog.add(entry)
+			next(w, r)
+			return
+		}
+
+		_, err := srv.db.Exec(context.Background(), accessLogInsert, entry.args()...)
+		if err != nil {
+			writeError(w, r, fmt.Errorf("failed write access log: %w", err))
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+const accessLogInsert = `INSERT INTO access_log (actor, subject_user_id, resource, record_id, method, path, ip, user_agent, accessed_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`
+
+// deferred access log entries are written this often once maintenance is over
+const accessLogFlushInterval = time.Minute
+
+type accessEntry struct {
+	actor, subject, resource, recordID string
+	method, path, ip, userAgent        string
+	at                                 time.Time
+}
+
+func (e accessEntry) args() []any {
+	return []any{e.actor, e.subject, e.resource, e.recordID, e.method, e.path, e.ip, e.userAgent, e.at}
+}
+
+// the reads served in maintenance, in the order they were made
+type deferredAccessLog struct {
+	mu      sync.Mutex
+	entries []accessEntry
+}
+
+func (d *deferredAccessLog) add(entries ...accessEntry) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.entries = append(d.entries, entries...)
+}
+
+func (d *deferredAccessLog) take() []accessEntry {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	entries := d.entries
+	d.entries = nil
+	return entries
+}
+
+// writes the reads logged during maintenance, they are kept for the next flush when that fails
+// and while maintenance goes on
+func (srv *Server) FlushAccessLog(ctx context.Context) error {
+	if srv.inMaintenance() {
+		return nil
+	}
+	entries := srv.accessLog.take()
+	if len(entries) == 0 {
+		return nil
+	}
+
+	batch := &pgx.Batch{}
+	for _, entry := range entries {
+		batch.Queue(accessLogInsert, entry.args()...)
+	}
+	err := srv.db.InTx(ctx, func(tx pgx.Tx) error {
+		return tx.SendBatch(ctx, batch).Close()
+	})
+	if err != nil {
+		srv.accessLog.add(entries...)
+		return err
+	}
+
+	return nil
+}
+
+func (srv *Server) RunAccessLogJob(ctx context.Context) {
+	ticker := time.NewTicker(accessLogFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			err := srv.FlushAccessLog(context.WithoutCancel(ctx))
+			if err != nil {
+				log.Printf("access log job: %v", err)
+			}
+			return
+		case <-ticker.C:
+		}
+
+		err := srv.FlushAccessLog(ctx)
+		if err != nil {
+			log.Printf("access log job: %v", err)
+		}
+	}
+}
+
+// exports the access log as CSV, optionally limited by time range and patient
+func (srv *Server) exportAccessLogHandler(w http.ResponseWriter, r *http.Request) error {
+	urlParams := r.URL.Query()
+	var since, until time.Time
+	var err error
+	if value := urlParams.Get("since"); value != "" {
+		since, err = time.Parse(time.RFC3339, value)
+	}
+	if value := urlParams.Get("until"); value != "" && err == nil {
+		until, err = time.Parse(time.RFC3339, value)
+	}
+	if err != nil {
+		return schedule.Errorf(schedule.ErrValidation, "invalid since or until, expected RFC 3339 time")
+	}
+	if until.IsZero() {
+		until = time.Now()
+	}
+
+	query := `SELECT id, actor, subject_user_id, resource, record_id, method, path, ip, user_agent, accessed_at FROM access_log
+		WHERE accessed_at >= $1 AND accessed_at < $2 AND ($3 = '' OR subject_user_id = $3) ORDER BY id`
+	rows, err := srv.db.QueryRead(context.Background(), query, since, until, urlParams.Get("user_id"))
+	if err != nil {
+		return fmt.Errorf("failed get access log from database: %w", err)
+	}
+	defer rows.Close()
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", `attachment; filename="access_log.csv"`)
+	out := csv.NewWriter(w)
+	out.Write([]string{"id", "actor", "subject_user_id", "resource", "record_id", "method", "path", "ip", "user_agent", "accessed_at"})
+	for rows.Next() {
+		var id int64
+		var actor, subject, resource, recordID, method, path, ip, userAgent string
+		var accessedAt time.Time
+		err := rows.Scan(&id, &actor, &subject, &resource, &recordID, &method, &path, &ip, &userAgent, &accessedAt)
+		if err != nil {
+			// the header is gone already, a cut off export is all that can be signalled
+			log.Printf("access log export: %v", err)
+			break
+		}
+		out.Write([]string{strconv.FormatInt(id, 10), actor, subject, resource, recordID, method, path, ip, userAgent, accessedAt.UTC().Format(time.RFC3339)})
+	}
+	out.Flush()
+	return nil
+}
@@ -0,0 +1,252 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"golang.org/x/crypto/bcrypt"
+	"kode_test/internal/notify"
+	"kode_test/internal/schedule"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	verifyEmailTTL   = 48 * time.Hour
+	resetPasswordTTL = time.Hour
+)
+
+type User struct {
+	ID        string    `json:"id"`
+	Email     string    `json:"email"`
+	Timezone  string    `json:"timezone"`
+	Role      string    `json:"role"`
+	WeightKg  *float64  `json:"weight_kg,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (srv *Server) getUserHandler(w http.ResponseWriter, r *http.Request) error {
+	userID, err := pathUserID(r)
+	if err != nil {
+		return err
+	}
+
+	var user User
+	query := "SELECT id, email, timezone, role, weight_kg, created_at FROM users WHERE id = $1"
+	err = srv.db.QueryRow(context.Background(), query, userID).Scan(&user.ID, &user.Email, &user.Timezone, &user.Role, &user.WeightKg, &user.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return schedule.Errorf(schedule.ErrNotFound, "user not found")
+	}
+	if err != nil {
+		return fmt.Errorf("failed get user from database: %w", err)
+	}
+
+	writeResource(w, r, user)
+	return nil
+}
+
+func appURL() string {
+	if u := os.Getenv("APP_URL"); u != "" {
+		return strings.TrimSuffix(u, "/")
+	}
+
+	return "http://localhost:3333"
+}
+
+// stores a single use token for the user and emails it to their address, following the link
+// proves the user reads that mailbox. Nothing is stored when email is not configured.
+func (srv *Server) sendUserToken(ctx context.Context, userID string, purpose string, ttl time.Duration, subject string, path string) error {
+	if !srv.notifier.CanEmail() {
+		return notify.ErrNoEmail
+	}
+	var email string
+	err := srv.db.QueryRow(ctx, "SELECT email FROM users WHERE id = $1", userID).Scan(&email)
+	if err != nil {
+		return err
+	}
+
+	token := randomHex(32)
+	query := "INSERT INTO user_token (token_hash, user_id, purpose, expires_at) VALUES ($1, $2, $3, $4)"
+	_, err = srv.db.Exec(ctx, query, hashToken(token), userID, purpose, time.Now().Add(ttl))
+	if err != nil {
+		return err
+	}
+
+	link := appURL() + path + "?token=" + url.QueryEscape(token)
+	return srv.notifier.NotifyEmail(ctx, email, subject, fmt.Sprintf("%s\n\nThe link is valid for %s.", link, ttl))
+}
+
+// marks the token used and returns its user, tokens work only once and only for their purpose
+func consumeUserToken(ctx context.Context, tx pgx.Tx, token string, purpose string) (string, error) {
+	var userID string
+	query := `UPDATE user_token SET used_at = now()
+		WHERE token_hash = $1 AND purpose = $2 AND used_at IS NULL AND expires_at > now()
+		RETURNING user_id`
+	err := tx.QueryRow(ctx, query, hashToken(token), purpose).Scan(&userID)
+
+	return userID, err
+}
+
+func (srv *Server) sendVerificationEmail(ctx context.Context, userID string) error {
+	return srv.sendUserToken(ctx, userID, "verify_email", verifyEmailTTL, "Confirm your email address", "/verify-email")
+}
+
+func (srv *Server) verifyEmailHandler(w http.ResponseWriter, r *http.Request) error {
+	if !srv.checkRateLimit(w, "verify-email-ip:"+clientIP(r), 20, time.Hour) {
+		return nil
+	}
+
+	var body struct {
+		Token string `json:"token"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil || body.Token == "" {
+		return schedule.Errorf(schedule.ErrValidation, "invalid verification format")
+	}
+
+	ctx := context.Background()
+	err = srv.db.InTx(ctx, func(tx pgx.Tx) error {
+		userID, err := consumeUserToken(ctx, tx, body.Token, "verify_email")
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(ctx, "UPDATE users SET email_verified_at = now() WHERE id = $1 AND email_verified_at IS NULL", userID)
+		return err
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return schedule.Errorf(schedule.ErrValidation, "invalid or expired token")
+	}
+	if err != nil {
+		return fmt.Errorf("failed verify email: %w", err)
+	}
+
+	fmt.Fprintf(w, "email verified")
+	return nil
+}
+
+func (srv *Server) resendVerificationHandler(w http.ResponseWriter, r *http.Request) error {
+	if !srv.notifier.CanEmail() {
+		http.Error(w, "email is not configured", http.StatusNotImplemented)
+		return nil
+	}
+
+	userID := currentUserID(r)
+	if !srv.checkRateLimit(w, "verify-email:"+userID, 3, time.Hour) {
+		return nil
+	}
+
+	var verified bool
+	err := srv.db.QueryRow(context.Background(), "SELECT email_verified_at IS NOT NULL FROM users WHERE id = $1", userID).Scan(&verified)
+	if err != nil {
+		return fmt.Errorf("failed get user from database: %w", err)
+	}
+	if verified {
+		return schedule.Errorf(schedule.ErrConflict, "email is already verified")
+	}
+
+	err = srv.sendVerificationEmail(context.Background(), userID)
+	if err != nil {
+		return fmt.Errorf("failed send verification email: %w", err)
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	fmt.Fprintf(w, "verification email sent")
+	return nil
+}
+
+// always answers 202 so the endpoint can not be used to find registered emails
+func (srv *Server) requestPasswordResetHandler(w http.ResponseWriter, r *http.Request) error {
+	// before the lookup, so the answer is the same for registered and unknown emails
+	if !srv.notifier.CanEmail() {
+		http.Error(w, "email is not configured", http.StatusNotImplemented)
+		return nil
+	}
+	if !srv.checkRateLimit(w, "password-reset-ip:"+clientIP(r), 10, time.Hour) {
+		return nil
+	}
+
+	var body struct {
+		Email string `json:"email"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil || body.Email == "" {
+		return schedule.Errorf(schedule.ErrValidation, "invalid password reset format")
+	}
+
+	email := strings.ToLower(strings.TrimSpace(body.Email))
+	if !srv.checkRateLimit(w, "password-reset:"+email, 3, time.Hour) {
+		return nil
+	}
+
+	var userID string
+	err = srv.db.QueryRow(context.Background(), "SELECT id FROM users WHERE email = $1", email).Scan(&userID)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return fmt.Errorf("failed get user from database: %w", err)
+	}
+	if err == nil {
+		err = srv.sendUserToken(context.Background(), userID, "reset_password", resetPasswordTTL, "Reset your password", "/reset-password")
+		if err != nil {
+			return fmt.Errorf("failed send password reset email: %w", err)
+		}
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	fmt.Fprintf(w, "if the email is registered a reset link was sent")
+	return nil
+}
+
+// sets the new password and signs the user out everywhere
+func (srv *Server) confirmPasswordResetHandler(w http.ResponseWriter, r *http.Request) error {
+	if !srv.checkRateLimit(w, "password-reset-confirm-ip:"+clientIP(r), 10, time.Hour) {
+		return nil
+	}
+
+	var body struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil || body.Token == "" {
+		return schedule.Errorf(schedule.ErrValidation, "invalid password reset format")
+	}
+	if len(body.Password) < 8 {
+		return schedule.Errorf(schedule.ErrValidation, "password must have at least 8 characters")
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(body.Password), bcrypt.DefaultCost)
+	if err != nil {
+		return fmt.Errorf("failed hash password: %w", err)
+	}
+
+	ctx := context.Background()
+	err = srv.db.InTx(ctx, func(tx pgx.Tx) error {
+		userID, err := consumeUserToken(ctx, tx, body.Token, "reset_password")
+		if err != nil {
+			return err
+		}
+
+		// the reset link proves control of the email as well
+		_, err = tx.Exec(ctx, "UPDATE users SET password_hash = $1, email_verified_at = COALESCE(email_verified_at, now()) WHERE id = $2", string(hash), userID)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(ctx, "UPDATE auth_session SET revoked_at = now() WHERE user_id = $1 AND revoked_at IS NULL", userID)
+		return err
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return schedule.Errorf(schedule.ErrValidation, "invalid or expired token")
+	}
+	if err != nil {
+		return fmt.Errorf("failed reset password: %w", err)
+	}
+
+	fmt.Fprintf(w, "password changed")
+	return nil
+}
@@ -0,0 +1,32 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"kode_test/internal/notify"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// verification and reset links prove the address, they are only ever emailed
+func TestUserTokensRequireEmail(t *testing.T) {
+	srv := &Server{notifier: notify.WithBreakers(notify.Log{})}
+	err := srv.sendUserToken(context.Background(), "ada", "verify_email", verifyEmailTTL, "Confirm your email address", "/verify-email")
+	if !errors.Is(err, notify.ErrNoEmail) {
+		t.Errorf("sendUserToken without email = %v", err)
+	}
+
+	handlers := map[string]errorHandler{
+		"/v1/auth/verify-email/resend": srv.resendVerificationHandler,
+		"/v1/auth/password-reset":      srv.requestPasswordResetHandler,
+	}
+	for path, handler := range handlers {
+		recorder := httptest.NewRecorder()
+		err := handler(recorder, httptest.NewRequest(http.MethodPost, path, strings.NewReader(`{"email": "ada@example.com"}`)))
+		if err != nil || recorder.Code != http.StatusNotImplemented {
+			t.Errorf("%s without email = %d, %v", path, recorder.Code, err)
+		}
+	}
+}
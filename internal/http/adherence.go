@@ -0,0 +1,411 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"io"
+	"kode_test/internal/schedule"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+const maxSkipReasonLength = 500
+
+// the doses of all schedules of one medicine over a period. Delays are from the planned
+// time to the logged intake, early intakes count as negative delays.
+type MedicineAdherence struct {
+	Medicine    string `json:"medicine"`
+	ScheduleIDs []int  `json:"schedule_ids"`
+	Due         int    `json:"due"`
+	Taken       int    `json:"taken"`
+	OnTime      int    `json:"on_time"`
+	// taken doses the user logged afterwards or corrected
+	Backfilled          int      `json:"backfilled"`
+	Missed              int      `json:"missed"`
+	Skipped             int      `json:"skipped"`
+	AverageDelayMinutes *float64 `json:"average_delay_minutes"`
+}
+
+type MedicineAdherenceReport struct {
+	From          string              `json:"from"`
+	To            string              `json:"to"`
+	OnTimeMinutes int                 `json:"on_time_minutes"`
+	Medicines     []MedicineAdherence `json:"medicines"`
+}
+
+type ScheduleTiming struct {
+	ScheduleID int    `json:"schedule_id"`
+	Medicine   string `json:"medicine"`
+	schedule.Timing
+}
+
+type TimingReport struct {
+	From      string           `json:"from"`
+	To        string           `json:"to"`
+	Schedules []ScheduleTiming `json:"schedules"`
+}
+
+type DoseSkip struct {
+	DoseID    string    `json:"dose_id"`
+	Reason    string    `json:"reason,omitempty"`
+	SkippedAt time.Time `json:"skipped_at"`
+}
+
+// taken, missed and skipped doses per medicine over from and to, days in the user's timezone,
+// the last 30 by default. Doses still ahead are not due yet.
+func (srv *Server) getAdherenceByMedicineHandler(w http.ResponseWriter, r *http.Request) error {
+	userID, err := pathUserID(r)
+	if err != nil {
+		return err
+	}
+	from, to, err := adherencePeriod(r)
+	if err != nil {
+		return err
+	}
+	loc, err := srv.userLocation(r, userID)
+	if err != nil {
+		return err
+	}
+
+	report, err := srv.adherenceByMedicine(r.Context(), userID, from, to, loc)
+	if err != nil {
+		return err
+	}
+
+	if format := acceptedListFormat(r); format == "text/csv" || format == "text/plain" {
+		writeList(w, r, report.Medicines)
+		return nil
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, convertToJson(report))
+	return nil
+}
+
+// how late the doses of each schedule were taken over from and to, as for the breakdown by medicine
+func (srv *Server) getDoseTimingHandler(w http.ResponseWriter, r *http.Request) error {
+	userID, err := pathUserID(r)
+	if err != nil {
+		return err
+	}
+
+	return srv.writeDoseTiming(w, r, userID)
+}
+
+// the timing report of a patient for their clinician or caregiver
+func (srv *Server) getPatientDoseTimingHandler(w http.ResponseWriter, r *http.Request) error {
+	patientID, err := srv.linkedPatientID(r.Context(), r)
+	if err != nil {
+		return err
+	}
+
+	return srv.writeDoseTiming(w, r, patientID)
+}
+
+func (srv *Server) writeDoseTiming(w http.ResponseWriter, r *http.Request, userID string) error {
+	from, to, err := adherencePeriod(r)
+	if err != nil {
+		return err
+	}
+	loc, err := srv.userLocation(r, userID)
+	if err != nil {
+		return err
+	}
+
+	window, err := srv.onTimeWindow(r.Context(), userID)
+	if err != nil {
+		return err
+	}
+	schedules, tallies, err := srv.tallyUserDoses(r.Context(), userID, from, to, loc, window.duration())
+	if err != nil {
+		return err
+	}
+	report := TimingReport{From: from.Format("2006-01-02"), To: to.Format("2006-01-02"), Schedules: []ScheduleTiming{}}
+	for _, s := range schedules {
+		if tallies[s.ID].due == 0 {
+			continue
+		}
+		report.Schedules = append(report.Schedules, ScheduleTiming{ScheduleID: s.ID, Medicine: s.Medicine, Timing: schedule.DoseTiming(tallies[s.ID].delays, window.duration())})
+	}
+
+	// as a table the distribution stays JSON in its cell
+	if format := acceptedListFormat(r); format == "text/csv" || format == "text/plain" {
+		writeList(w, r, report.Schedules)
+		return nil
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, convertToJson(report))
+	return nil
+}
+
+// from and to as for the usage, at most a year apart
+func adherencePeriod(r *http.Request) (time.Time, time.Time, error) {
+	from, to, err := usagePeriod(r)
+	if err == nil && to.Sub(from) > 365*24*time.Hour {
+		err = schedule.Errorf(schedule.ErrValidation, "the period is longer than a year")
+	}
+
+	return from, to, err
+}
+
+func (srv *Server) adherenceByMedicine(ctx context.Context, userID string, from time.Time, to time.Time, loc *time.Location) (MedicineAdherenceReport, error) {
+	report := MedicineAdherenceReport{From: from.Format("2006-01-02"), To: to.Format("2006-01-02"), Medicines: []MedicineAdherence{}}
+	window, err := srv.onTimeWindow(ctx, userID)
+	if err != nil {
+		return report, err
+	}
+	report.OnTimeMinutes = *window.Minutes
+	schedules, tallies, err := srv.tallyUserDoses(ctx, userID, from, to, loc, window.duration())
+	if err != nil {
+		return report, err
+	}
+
+	medicines := map[string]*MedicineAdherence{}
+	var order []string
+	delays := map[string]time.Duration{}
+	for _, s := range schedules {
+		key := strings.ToLower(strings.TrimSpace(s.Medicine))
+		medicine, ok := medicines[key]
+		if !ok {
+			medicine = &MedicineAdherence{Medicine: s.Medicine}
+			medicines[key] = medicine
+			order = append(order, key)
+		}
+		medicine.ScheduleIDs = append(medicine.ScheduleIDs, s.ID)
+
+		tally := tallies[s.ID]
+		medicine.Due += tally.due
+		medicine.Taken += len(tally.delays)
+		medicine.OnTime += tally.onTime
+		medicine.Backfilled += tally.backfilled
+		medicine.Missed += tally.missed
+		medicine.Skipped += tally.skipped
+		for _, delay := range tally.delays {
+			delays[key] += delay
+		}
+	}
+
+	for _, key := range order {
+		medicine := medicines[key]
+		if medicine.Due == 0 {
+			continue
+		}
+		if medicine.Taken > 0 {
+			average := (delays[key] / time.Duration(medicine.Taken)).Minutes()
+			medicine.AverageDelayMinutes = &average
+		}
+		report.Medicines = append(report.Medicines, *medicine)
+	}
+	slices.SortFunc(report.Medicines, func(a, b MedicineAdherence) int {
+		return strings.Compare(strings.ToLower(a.Medicine), strings.ToLower(b.Medicine))
+	})
+
+	return report, nil
+}
+
+// what became of the planned doses of a schedule, there is a delay for every taken dose
+type doseTally struct {
+	due        int
+	onTime     int
+	backfilled int
+	missed     int
+	skipped    int
+	delays     []time.Duration
+}
+
+// the schedules of the user and the tallies of their doses planned on the days from and to in loc.
+// Intakes within onTime of the planned time are on time, doses still ahead are not due yet.
+func (srv *Server) tallyUserDoses(ctx context.Context, userID string, from time.Time, to time.Time, loc *time.Location, onTime time.Duration) ([]schedule.Schedule, map[int]*doseTally, error) {
+	start := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, loc)
+	end := time.Date(to.Year(), to.Month(), to.Day()+1, 0, 0, 0, 0, loc)
+	now := time.Now()
+
+	schedules, err := srv.ListUserSchedules(ctx, userID, "", time.Time{}, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed get schedules from database: %w", err)
+	}
+	plan, err := srv.userDayPlan(ctx, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// intakes a little before the first day can confirm its first doses
+	query := `SELECT schedule_id, COALESCE(dose_id, ''), taken_at, backfilled FROM intake_log
+		WHERE user_id = $1 AND schedule_id IS NOT NULL AND taken_at >= $2 AND taken_at < $3 ORDER BY taken_at`
+	rows, err := srv.db.QueryRead(ctx, query, userID, start.AddDate(0, 0, -1), end.AddDate(0, 0, 1))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed get intakes from database: %w", err)
+	}
+	confirmed := map[string]loggedIntake{}
+	loose := map[int][]loggedIntake{}
+	var scheduleID int
+	var doseID string
+	var logged loggedIntake
+	_, err = pgx.ForEachRow(rows, []any{&scheduleID, &doseID, &logged.takenAt, &logged.backfilled}, func() error {
+		if doseID == "" {
+			loose[scheduleID] = append(loose[scheduleID], logged)
+		} else if _, ok := confirmed[doseID]; !ok {
+			confirmed[doseID] = logged
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed get intakes from database: %w", err)
+	}
+
+	rows, err = srv.db.QueryRead(ctx, "SELECT dose_id FROM dose_skip WHERE user_id = $1", userID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed get skipped doses from database: %w", err)
+	}
+	skipped := map[string]bool{}
+	_, err = pgx.ForEachRow(rows, []any{&doseID}, func() error {
+		skipped[doseID] = true
+		return nil
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed get skipped doses from database: %w", err)
+	}
+
+	tallies := map[int]*doseTally{}
+	for _, s := range schedules {
+		tally := &doseTally{}
+		tallies[s.ID] = tally
+		intakes := loose[s.ID]
+		for day := start; day.Before(end); day = day.AddDate(0, 0, 1) {
+			// noon keeps the day the same across daylight saving changes
+			noon := time.Date(day.Year(), day.Month(), day.Day(), 12, 0, 0, 0, loc)
+			local, doseTimes := plan.doseTimes(s, noon, loc)
+			if !schedule.CheckDay(s, local, local.Location()) {
+				continue
+			}
+			date := schedule.LocalDate(local, local.Location())
+			for i, doseTime := range doseTimes {
+				if doseTime.Before(start) || !doseTime.Before(end) || doseTime.After(now) {
+					continue
+				}
+				id := schedule.DoseID(s.ID, date, i+1)
+				intake, taken := confirmed[id]
+				if !taken && !skipped[id] {
+					intake, taken = takeNearest(&intakes, doseTime)
+				}
+				switch {
+				case taken:
+					delay := intake.takenAt.Sub(doseTime)
+					tally.due++
+					tally.delays = append(tally.delays, delay)
+					if delay.Abs() <= onTime {
+						tally.onTime++
+					}
+					if intake.backfilled {
+						tally.backfilled++
+					}
+				case skipped[id]:
+					tally.due++
+					tally.skipped++
+				case doseTime.Add(onTime).Before(now):
+					// a dose is missed once its on time window is over, until then it is not due yet
+					tally.due++
+					tally.missed++
+				}
+			}
+		}
+	}
+
+	return schedules, tallies, nil
+}
+
+type loggedIntake struct {
+	takenAt    time.Time
+	backfilled bool
+}
+
+// an intake logged without a dose_id confirms the planned dose it is closest to, within half a day
+func takeNearest(intakes *[]loggedIntake, doseTime time.Time) (loggedIntake, bool) {
+	nearest := -1
+	for i, intake := range *intakes {
+		distance := intake.takenAt.Sub(doseTime).Abs()
+		if distance < 12*time.Hour && (nearest < 0 || distance < (*intakes)[nearest].takenAt.Sub(doseTime).Abs()) {
+			nearest = i
+		}
+	}
+	if nearest < 0 {
+		return loggedIntake{}, false
+	}
+
+	intake := (*intakes)[nearest]
+	*intakes = slices.Delete(*intakes, nearest, nearest+1)
+	return intake, true
+}
+
+// marks a planned dose as deliberately not taken, it gets no more reminders and counts
+// as skipped instead of missed. A reason is optional.
+func (srv *Server) skipDoseHandler(w http.ResponseWriter, r *http.Request) error {
+	userID, err := pathUserID(r)
+	if err != nil {
+		return err
+	}
+	skip := DoseSkip{DoseID: r.PathValue("dose_id")}
+	scheduleID, _, slot, err := schedule.ParseDoseID(skip.DoseID)
+	if err != nil {
+		return err
+	}
+	err = json.NewDecoder(r.Body).Decode(&skip)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return schedule.Errorf(schedule.ErrValidation, "invalid skip format")
+	}
+	skip.DoseID = r.PathValue("dose_id")
+	skip.Reason = strings.TrimSpace(skip.Reason)
+	if len(skip.Reason) > maxSkipReasonLength {
+		return schedule.FieldErrorf("reason", "max_length", "reason is longer than %d characters", maxSkipReasonLength)
+	}
+
+	ctx := r.Context()
+	s, err := srv.getUserSchedule(ctx, userID, scheduleID)
+	if err != nil {
+		return err
+	}
+	if slot > s.Duration {
+		return schedule.Errorf(schedule.ErrValidation, "the schedule has only %d doses a day", s.Duration)
+	}
+	var taken bool
+	query := "SELECT EXISTS (SELECT 1 FROM intake_log WHERE schedule_id = $1 AND dose_id = $2)"
+	err = srv.db.QueryRow(ctx, query, scheduleID, skip.DoseID).Scan(&taken)
+	if err != nil {
+		return fmt.Errorf("failed get intakes from database: %w", err)
+	}
+	if taken {
+		return schedule.Errorf(schedule.ErrConflict, "dose %s is already confirmed", skip.DoseID)
+	}
+
+	query = `INSERT INTO dose_skip (schedule_id, dose_id, user_id, reason) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (schedule_id, dose_id) DO UPDATE SET reason = excluded.reason RETURNING skipped_at`
+	err = srv.db.QueryRow(ctx, query, scheduleID, skip.DoseID, userID, skip.Reason).Scan(&skip.SkippedAt)
+	if err != nil {
+		return fmt.Errorf("failed save skipped dose in database: %w", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, convertToJson(skip))
+	return nil
+}
+
+func (srv *Server) unskipDoseHandler(w http.ResponseWriter, r *http.Request) error {
+	userID, err := pathUserID(r)
+	if err != nil {
+		return err
+	}
+	tag, err := srv.db.Exec(r.Context(), "DELETE FROM dose_skip WHERE user_id = $1 AND dose_id = $2", userID, r.PathValue("dose_id"))
+	if err != nil {
+		return fmt.Errorf("failed delete skipped dose: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return schedule.Errorf(schedule.ErrNotFound, "skipped dose not found")
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
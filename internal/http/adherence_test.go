@@ -0,0 +1,52 @@
+package http
+
+import (
+	"errors"
+	"kode_test/internal/schedule"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTakeNearest(t *testing.T) {
+	dose := time.Date(2026, 10, 16, 8, 0, 0, 0, time.UTC)
+	intakes := []loggedIntake{
+		{takenAt: dose.Add(-13 * time.Hour)},
+		{takenAt: dose.Add(40 * time.Minute), backfilled: true},
+		{takenAt: dose.Add(-10 * time.Minute)},
+	}
+
+	intake, ok := takeNearest(&intakes, dose)
+	if !ok || !intake.takenAt.Equal(dose.Add(-10*time.Minute)) {
+		t.Fatalf("nearest intake = %+v, %v", intake, ok)
+	}
+	// a taken intake confirms no other dose
+	intake, ok = takeNearest(&intakes, dose)
+	if !ok || !intake.backfilled || len(intakes) != 1 {
+		t.Fatalf("next nearest intake = %+v, %v with %d left", intake, ok, len(intakes))
+	}
+	// more than half a day away is another dose
+	intake, ok = takeNearest(&intakes, dose)
+	if ok || len(intakes) != 1 {
+		t.Errorf("intake 13 hours away taken: %+v", intake)
+	}
+}
+
+func TestAdherencePeriod(t *testing.T) {
+	tests := []struct {
+		query   string
+		wantErr bool
+	}{
+		{"", false},
+		{"from=2025-10-16&to=2026-10-16", false},
+		{"from=2025-10-15&to=2026-10-16", true},
+		{"from=2026-10-17&to=2026-10-16", true},
+	}
+	for _, test := range tests {
+		_, _, err := adherencePeriod(httptest.NewRequest(http.MethodGet, "/v1/users/ada/adherence/by-medicine?"+test.query, nil))
+		if (err != nil) != test.wantErr || (err != nil && !errors.Is(err, schedule.ErrValidation)) {
+			t.Errorf("adherencePeriod with %q = %v", test.query, err)
+		}
+	}
+}
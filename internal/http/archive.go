@@ -0,0 +1,294 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"io"
+	"kode_test/internal/schedule"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"time"
+)
+
+const (
+	archiveJobInterval = 24 * time.Hour
+	// courses exported per run, the rest follow on the next runs
+	archiveBatchSize = 500
+)
+
+// the rows that belong to a schedule and go into its archive with it, restored in this order
+var archivedTables = []string{"intake_log", "dose_skip", "refill", "attachment", "reminder", "schedule_variant", "schedule_notification_settings"}
+
+// one row of a course archive, the schedule comes first. Rows are kept as stored,
+// so an encrypted medicine stays encrypted in the object store.
+type archiveLine struct {
+	Table string          `json:"table"`
+	Row   json.RawMessage `json:"row"`
+}
+
+type ArchivedCourse struct {
+	ScheduleID int        `json:"schedule_id"`
+	Intakes    int        `json:"intakes"`
+	ArchivedAt time.Time  `json:"archived_at"`
+	PrunedAt   *time.Time `json:"pruned_at,omitempty"`
+	RestoredAt *time.Time `json:"restored_at,omitempty"`
+}
+
+// ARCHIVE_COMPLETED_AFTER_DAYS exports courses completed that long ago, unset or 0 turns archiving off.
+// ARCHIVE_PRUNE=true deletes them from the database once they are exported.
+func archiveConfig() (int, bool, error) {
+	days := 0
+	if value := os.Getenv("ARCHIVE_COMPLETED_AFTER_DAYS"); value != "" {
+		var err error
+		days, err = strconv.Atoi(value)
+		if err != nil || days < 0 {
+			return 0, false, fmt.Errorf("invalid ARCHIVE_COMPLETED_AFTER_DAYS %q", value)
+		}
+	}
+
+	return days, os.Getenv("ARCHIVE_PRUNE") == "true", nil
+}
+
+// exports the courses completed before the configured age that have no current export, and prunes
+// exported ones when configured. Courses restored on demand stay in the database for another period.
+func (srv *Server) ArchiveCompletedCourses(ctx context.Context) (int, int64, error) {
+	days, prune, err := archiveConfig()
+	if err != nil || days == 0 {
+		return 0, 0, err
+	}
+	if srv.objects == nil {
+		return 0, 0, errors.New("archiving completed courses needs the object store")
+	}
+	cutoff := time.Now().AddDate(0, 0, -days)
+
+	// a course changed after its export is exported again
+	query := `SELECT s.id FROM schedule s LEFT JOIN course_archive a ON a.schedule_id = s.id
+		WHERE s.status = 'completed' AND s.updated_at < $1 AND (a.schedule_id IS NULL OR a.archived_at < s.updated_at)
+		ORDER BY s.id LIMIT $2`
+	rows, err := srv.db.Query(ctx, query, cutoff, archiveBatchSize)
+	if err != nil {
+		return 0, 0, err
+	}
+	ids, err := pgx.CollectRows(rows, pgx.RowTo[int])
+	if err != nil {
+		return 0, 0, err
+	}
+
+	archived := 0
+	for _, id := range ids {
+		if stopped(ctx) {
+			return archived, 0, nil
+		}
+		err := srv.archiveCourse(ctx, id)
+		if err != nil {
+			return archived, 0, fmt.Errorf("schedule %d: %w", id, err)
+		}
+		archived++
+	}
+	if !prune {
+		return archived, 0, nil
+	}
+
+	// only when the export is current, intakes logged late would otherwise be lost
+	query = `WITH pruned AS (
+			DELETE FROM schedule s USING course_archive a
+			WHERE a.schedule_id = s.id AND s.status = 'completed' AND a.pruned_at IS NULL AND a.archived_at >= s.updated_at
+			AND (a.restored_at IS NULL OR a.restored_at < $1)
+			AND a.intakes = (SELECT count(*) FROM intake_log i WHERE i.schedule_id = s.id)
+			RETURNING s.id
+		)
+		UPDATE course_archive SET pruned_at = now() WHERE schedule_id IN (SELECT id FROM pruned)`
+	tag, err := srv.db.Exec(ctx, query, cutoff)
+	if err != nil {
+		return archived, 0, fmt.Errorf("failed prune archived courses: %w", err)
+	}
+
+	return archived, tag.RowsAffected(), nil
+}
+
+// writes the schedule and its rows to archive/<user>/<schedule uuid>.jsonl
+func (srv *Server) archiveCourse(ctx context.Context, scheduleID int) error {
+	var userID, uuid string
+	var row json.RawMessage
+	err := srv.db.QueryRow(ctx, "SELECT user_id, uuid::text, row_to_json(s) FROM schedule s WHERE id = $1", scheduleID).Scan(&userID, &uuid, &row)
+	if err != nil {
+		return err
+	}
+
+	var body bytes.Buffer
+	encoder := json.NewEncoder(&body)
+	err = encoder.Encode(archiveLine{Table: "schedule", Row: row})
+	if err != nil {
+		return err
+	}
+	intakes := 0
+	for _, table := range archivedTables {
+		rows, err := srv.db.Query(ctx, "SELECT row_to_json(t) FROM "+table+" t WHERE schedule_id = $1", scheduleID)
+		if err != nil {
+			return err
+		}
+		tableRows, err := pgx.CollectRows(rows, pgx.RowTo[json.RawMessage])
+		if err != nil {
+			return err
+		}
+		if table == "intake_log" {
+			intakes = len(tableRows)
+		}
+		for _, tableRow := range tableRows {
+			err = encoder.Encode(archiveLine{Table: table, Row: tableRow})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	key := "archive/" + userID + "/" + uuid + ".jsonl"
+	err = srv.objects.Put(ctx, key, "application/x-ndjson", body.Bytes())
+	if err != nil {
+		return err
+	}
+
+	query := `INSERT INTO course_archive (schedule_id, user_id, object_key, intakes) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (schedule_id) DO UPDATE SET object_key = excluded.object_key, intakes = excluded.intakes, archived_at = now(), pruned_at = NULL`
+	_, err = srv.db.Exec(ctx, query, scheduleID, userID, key, intakes)
+
+	return err
+}
+
+// puts a pruned course back into the database from its archive, a course that was not pruned is left alone
+func (srv *Server) RestoreCourse(ctx context.Context, userID string, scheduleID int) (ArchivedCourse, error) {
+	course, key, err := srv.archivedCourse(ctx, userID, scheduleID)
+	if err != nil || course.PrunedAt == nil {
+		return course, err
+	}
+	if srv.objects == nil {
+		return course, errors.New("restoring archived courses needs the object store")
+	}
+
+	body, err := srv.objects.Get(ctx, key)
+	if err != nil {
+		return course, fmt.Errorf("failed get archive: %w", err)
+	}
+	var lines []archiveLine
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	for {
+		var line archiveLine
+		err := decoder.Decode(&line)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		// table names go into the statements, only the known ones are accepted
+		if err != nil || (line.Table != "schedule" && !slices.Contains(archivedTables, line.Table)) {
+			return course, fmt.Errorf("invalid archive %s", key)
+		}
+		lines = append(lines, line)
+	}
+
+	err = srv.db.InTx(ctx, func(tx pgx.Tx) error {
+		for _, line := range lines {
+			query := "INSERT INTO " + line.Table + " SELECT * FROM json_populate_record(NULL::" + line.Table + ", $1) ON CONFLICT DO NOTHING"
+			_, err := tx.Exec(ctx, query, line.Row)
+			if err != nil {
+				return fmt.Errorf("failed restore %s: %w", line.Table, err)
+			}
+		}
+		_, err := tx.Exec(ctx, "UPDATE course_archive SET pruned_at = NULL, restored_at = now() WHERE schedule_id = $1", scheduleID)
+		return err
+	})
+	if err != nil {
+		return course, err
+	}
+
+	course, _, err = srv.archivedCourse(ctx, userID, scheduleID)
+	return course, err
+}
+
+func (srv *Server) archivedCourse(ctx context.Context, userID string, scheduleID int) (ArchivedCourse, string, error) {
+	var course ArchivedCourse
+	var key string
+	query := "SELECT schedule_id, intakes, archived_at, pruned_at, restored_at, object_key FROM course_archive WHERE schedule_id = $1 AND user_id = $2"
+	err := srv.db.QueryRow(ctx, query, scheduleID, userID).Scan(&course.ScheduleID, &course.Intakes, &course.ArchivedAt, &course.PrunedAt, &course.RestoredAt, &key)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return course, "", schedule.Errorf(schedule.ErrNotFound, "archived course not found")
+	}
+
+	return course, key, err
+}
+
+func (srv *Server) getArchivedCoursesHandler(w http.ResponseWriter, r *http.Request) error {
+	userID, err := pathUserID(r)
+	if err != nil {
+		return err
+	}
+
+	query := "SELECT schedule_id, intakes, archived_at, pruned_at, restored_at FROM course_archive WHERE user_id = $1 ORDER BY archived_at DESC"
+	rows, err := srv.db.Query(r.Context(), query, userID)
+	if err != nil {
+		return fmt.Errorf("failed get archived courses from database: %w", err)
+	}
+	courses, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (ArchivedCourse, error) {
+		var course ArchivedCourse
+		err := row.Scan(&course.ScheduleID, &course.Intakes, &course.ArchivedAt, &course.PrunedAt, &course.RestoredAt)
+		return course, err
+	})
+	if err != nil {
+		return fmt.Errorf("failed get archived courses from database: %w", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, convertToJson(courses))
+	return nil
+}
+
+func (srv *Server) restoreArchivedCourseHandler(w http.ResponseWriter, r *http.Request) error {
+	userID, err := pathUserID(r)
+	if err != nil {
+		return err
+	}
+	scheduleID, err := strconv.Atoi(r.PathValue("schedule_id"))
+	if err != nil {
+		return schedule.Errorf(schedule.ErrValidation, "invalid schedule id")
+	}
+
+	course, err := srv.RestoreCourse(r.Context(), userID, scheduleID)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, convertToJson(course))
+	return nil
+}
+
+func (srv *Server) RunArchiveJob(ctx context.Context) {
+	ticker := time.NewTicker(archiveJobInterval)
+	defer ticker.Stop()
+
+	for {
+		if !srv.inMaintenance() {
+			err := srv.exclusively(drainable(ctx), "archive", func(ctx context.Context) error {
+				archived, pruned, err := srv.ArchiveCompletedCourses(ctx)
+				if archived > 0 || pruned > 0 {
+					log.Printf("archive job: %d courses archived, %d pruned", archived, pruned)
+				}
+				return err
+			})
+			if err != nil {
+				log.Printf("archive job: %v", err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package http
+
+import (
+	"context"
+	"testing"
+)
+
+func TestArchiveConfig(t *testing.T) {
+	tests := []struct {
+		days, prune string
+		wantDays    int
+		wantPrune   bool
+		wantErr     bool
+	}{
+		{"", "", 0, false, false},
+		{"90", "", 90, false, false},
+		{"90", "true", 90, true, false},
+		{"90", "yes", 90, false, false},
+		{"-1", "", 0, false, true},
+		{"ninety", "true", 0, false, true},
+	}
+	for _, test := range tests {
+		t.Setenv("ARCHIVE_COMPLETED_AFTER_DAYS", test.days)
+		t.Setenv("ARCHIVE_PRUNE", test.prune)
+		days, prune, err := archiveConfig()
+		if days != test.wantDays || prune != test.wantPrune || (err != nil) != test.wantErr {
+			t.Errorf("archiveConfig with %q, %q = %d, %v, %v", test.days, test.prune, days, prune, err)
+		}
+	}
+}
+
+func TestArchiveWithoutObjectStore(t *testing.T) {
+	// archiving is off by default and needs no object store then
+	t.Setenv("ARCHIVE_COMPLETED_AFTER_DAYS", "")
+	srv := &Server{}
+	archived, pruned, err := srv.ArchiveCompletedCourses(context.Background())
+	if archived != 0 || pruned != 0 || err != nil {
+		t.Errorf("ArchiveCompletedCourses turned off = %d, %d, %v", archived, pruned, err)
+	}
+
+	t.Setenv("ARCHIVE_COMPLETED_AFTER_DAYS", "30")
+	_, _, err = srv.ArchiveCompletedCourses(context.Background())
+	if err == nil {
+		t.Error("archiving without an object store did not fail")
+	}
+}
@@ -0,0 +1,232 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"kode_test/internal/objectstore"
+	"kode_test/internal/schedule"
+	"net/http"
+	"time"
+)
+
+const (
+	maxAttachmentSize = 20 << 20
+	// how long signed upload and download URLs are valid
+	attachmentURLExpiry = 15 * time.Minute
+)
+
+// content types accepted per kind of attachment
+var attachmentTypes = map[string]map[string]string{
+	"photo":        {"image/jpeg": "jpg", "image/png": "png", "image/heic": "heic", "image/webp": "webp"},
+	"leaflet":      {"application/pdf": "pdf", "image/jpeg": "jpg", "image/png": "png"},
+	"prescription": {"application/pdf": "pdf", "image/jpeg": "jpg", "image/png": "png", "image/heic": "heic"},
+}
+
+type Attachment struct {
+	ID          string     `json:"id"`
+	ScheduleID  int        `json:"schedule_id"`
+	Kind        string     `json:"kind"`
+	ContentType string     `json:"content_type"`
+	SizeBytes   int64      `json:"size_bytes"`
+	Status      string     `json:"status"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UploadURL   string     `json:"upload_url,omitempty"`
+	DownloadURL string     `json:"download_url,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+}
+
+// the id of a schedule of the user, foreign schedules are not found
+func (srv *Server) ownScheduleID(ctx context.Context, ref string, userID string) (int, error) {
+	scheduleID, err := srv.ResolveScheduleID(ctx, ref)
+	if err != nil {
+		return 0, err
+	}
+
+	err = srv.db.QueryRow(ctx, "SELECT id FROM schedule WHERE id = $1 AND user_id = $2", scheduleID, userID).Scan(&scheduleID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, schedule.Errorf(schedule.ErrNotFound, "schedule not found")
+	}
+
+	return scheduleID, err
+}
+
+// registers an attachment and answers with a signed URL the client PUTs the file to,
+// with exactly the announced content type and size
+func (srv *Server) createAttachmentHandler(w http.ResponseWriter, r *http.Request) error {
+	if srv.objects == nil {
+		http.Error(w, "attachments are not configured", http.StatusNotImplemented)
+		return nil
+	}
+
+	var attachment Attachment
+	err := json.NewDecoder(r.Body).Decode(&attachment)
+	if err != nil {
+		return schedule.Errorf(schedule.ErrValidation, "invalid attachment format")
+	}
+	types, ok := attachmentTypes[attachment.Kind]
+	if !ok {
+		return schedule.Errorf(schedule.ErrValidation, "invalid kind, expected photo, leaflet or prescription")
+	}
+	extension, ok := types[attachment.ContentType]
+	if !ok {
+		return schedule.Errorf(schedule.ErrValidation, "content type %q is not accepted for a %s", attachment.ContentType, attachment.Kind)
+	}
+	if attachment.SizeBytes < 1 || attachment.SizeBytes > maxAttachmentSize {
+		return schedule.Errorf(schedule.ErrValidation, "size_bytes must be between 1 and %d", maxAttachmentSize)
+	}
+
+	userID, err := requestUserID(r)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	attachment.ScheduleID, err = srv.ownScheduleID(ctx, r.PathValue("id"), userID)
+	if err != nil {
+		return err
+	}
+
+	// the key is only known with the id, so the row is inserted with the key computed from it
+	query := `INSERT INTO attachment (id, schedule_id, user_id, kind, object_key, content_type, size_bytes)
+		SELECT id, $1::integer, $2::text, $3::text, format('attachments/%s/%s/%s.%s', $2::text, $1::integer, id, $4::text), $5::text, $6::integer FROM (SELECT gen_random_uuid() AS id) new
+		RETURNING id::text, object_key, status, created_at`
+	var key string
+	err = srv.db.QueryRow(ctx, query, attachment.ScheduleID, userID, attachment.Kind, extension, attachment.ContentType, attachment.SizeBytes).Scan(&attachment.ID, &key, &attachment.Status, &attachment.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed save attachment: %w", err)
+	}
+
+	attachment.UploadURL, err = srv.objects.UploadURL(key, attachment.ContentType, attachment.SizeBytes, attachmentURLExpiry)
+	if err != nil {
+		return fmt.Errorf("failed sign upload url: %w", err)
+	}
+	expiresAt := time.Now().Add(attachmentURLExpiry)
+	attachment.ExpiresAt = &expiresAt
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	fmt.Fprint(w, convertToJson(attachment))
+	return nil
+}
+
+// called by the client after its upload, the object must match what was announced
+func (srv *Server) completeAttachmentHandler(w http.ResponseWriter, r *http.Request) error {
+	if srv.objects == nil {
+		http.Error(w, "attachments are not configured", http.StatusNotImplemented)
+		return nil
+	}
+
+	userID, err := requestUserID(r)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	var key string
+	var attachment Attachment
+	query := "SELECT id::text, schedule_id, kind, object_key, content_type, size_bytes, status, created_at FROM attachment WHERE id::text = $1 AND user_id = $2"
+	err = srv.db.QueryRow(ctx, query, r.PathValue("id"), userID).Scan(&attachment.ID, &attachment.ScheduleID, &attachment.Kind, &key, &attachment.ContentType, &attachment.SizeBytes, &attachment.Status, &attachment.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return schedule.Errorf(schedule.ErrNotFound, "attachment not found")
+	}
+	if err != nil {
+		return fmt.Errorf("failed get attachment from database: %w", err)
+	}
+
+	object, err := srv.objects.Head(ctx, key)
+	if errors.Is(err, objectstore.ErrNotFound) {
+		return schedule.Errorf(schedule.ErrConflict, "the file was not uploaded yet")
+	}
+	if err != nil {
+		return fmt.Errorf("failed check upload: %w", err)
+	}
+	if object.Size != attachment.SizeBytes || object.ContentType != attachment.ContentType {
+		srv.objects.Delete(ctx, key)
+		return schedule.Errorf(schedule.ErrValidation, "the uploaded file does not match the announced type and size")
+	}
+
+	_, err = srv.db.Exec(ctx, "UPDATE attachment SET status = 'uploaded' WHERE id = $1::uuid", attachment.ID)
+	if err != nil {
+		return fmt.Errorf("failed update attachment: %w", err)
+	}
+	attachment.Status = "uploaded"
+
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, convertToJson(attachment))
+	return nil
+}
+
+// the uploaded attachments of a schedule, each with a signed download URL
+func (srv *Server) getAttachmentsHandler(w http.ResponseWriter, r *http.Request) error {
+	if srv.objects == nil {
+		http.Error(w, "attachments are not configured", http.StatusNotImplemented)
+		return nil
+	}
+
+	userID, err := requestUserID(r)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	scheduleID, err := srv.ownScheduleID(ctx, r.PathValue("id"), userID)
+	if err != nil {
+		return err
+	}
+
+	query := "SELECT id::text, schedule_id, kind, object_key, content_type, size_bytes, status, created_at FROM attachment WHERE schedule_id = $1 AND status = 'uploaded' ORDER BY created_at"
+	rows, err := srv.db.Query(ctx, query, scheduleID)
+	if err != nil {
+		return fmt.Errorf("failed get attachments from database: %w", err)
+	}
+	expiresAt := time.Now().Add(attachmentURLExpiry)
+	attachments, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (Attachment, error) {
+		var a Attachment
+		var key string
+		err := row.Scan(&a.ID, &a.ScheduleID, &a.Kind, &key, &a.ContentType, &a.SizeBytes, &a.Status, &a.CreatedAt)
+		if err != nil {
+			return a, err
+		}
+		a.DownloadURL, err = srv.objects.DownloadURL(key, attachmentURLExpiry)
+		a.ExpiresAt = &expiresAt
+		return a, err
+	})
+	if err != nil {
+		return fmt.Errorf("failed get attachments from database: %w", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, convertToJson(attachments))
+	return nil
+}
+
+func (srv *Server) deleteAttachmentHandler(w http.ResponseWriter, r *http.Request) error {
+	if srv.objects == nil {
+		http.Error(w, "attachments are not configured", http.StatusNotImplemented)
+		return nil
+	}
+
+	userID, err := requestUserID(r)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	var key string
+	err = srv.db.QueryRow(ctx, "DELETE FROM attachment WHERE id::text = $1 AND user_id = $2 RETURNING object_key", r.PathValue("id"), userID).Scan(&key)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return schedule.Errorf(schedule.ErrNotFound, "attachment not found")
+	}
+	if err != nil {
+		return fmt.Errorf("failed delete attachment from database: %w", err)
+	}
+
+	err = srv.objects.Delete(ctx, key)
+	if err != nil {
+		return fmt.Errorf("failed delete attachment from object store: %w", err)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
@@ -0,0 +1,308 @@
+package http
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"golang.org/x/crypto/bcrypt"
+	"kode_test/internal/notify"
+	"kode_test/internal/pii"
+	"kode_test/internal/schedule"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const accessTokenTTL = 15 * time.Minute
+
+type contextKey string
+
+const (
+	userIDKey    contextKey = "user_id"
+	sessionIDKey contextKey = "session_id"
+)
+
+type Credentials struct {
+	Email        string `json:"email"`
+	Password     string `json:"password"`
+	OTP          string `json:"otp"`
+	RecoveryCode string `json:"recovery_code"`
+	// IANA timezone for planning doses, only read on signup
+	Timezone string `json:"timezone"`
+}
+
+type TokenClaims struct {
+	Subject   string `json:"sub"`
+	SessionID string `json:"sid"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+func (srv *Server) signupHandler(w http.ResponseWriter, r *http.Request) error {
+	var credentials Credentials
+	err := json.NewDecoder(r.Body).Decode(&credentials)
+	if err != nil {
+		return schedule.Errorf(schedule.ErrValidation, "invalid signup format")
+	}
+
+	if message := ValidateSignup(&credentials); message != "" {
+		return schedule.Errorf(schedule.ErrValidation, "%s", message)
+	}
+
+	userID, err := srv.CreateUser(context.Background(), credentials)
+	if errors.Is(err, ErrEmailTaken) {
+		return schedule.Errorf(schedule.ErrConflict, "email is already registered")
+	}
+	if err != nil {
+		return fmt.Errorf("error adding data to database: %w", err)
+	}
+
+	// the account is usable already, the user can ask for another email if this one fails
+	err = srv.sendVerificationEmail(context.Background(), userID)
+	if err != nil && !errors.Is(err, notify.ErrNoEmail) {
+		log.Printf("signup: send verification email to %s: %v", pii.MaskUserID(userID), err)
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	fmt.Fprint(w, convertToJson(map[string]string{"id": userID}))
+	return nil
+}
+
+// normalizes the signup credentials and returns what is wrong with them
+func ValidateSignup(credentials *Credentials) string {
+	credentials.Email = strings.ToLower(strings.TrimSpace(credentials.Email))
+	if !strings.Contains(credentials.Email, "@") || len(credentials.Password) < 8 {
+		return "a valid email and a password of at least 8 characters are required"
+	}
+
+	if credentials.Timezone == "" {
+		credentials.Timezone = "UTC"
+	}
+	if _, err := time.LoadLocation(credentials.Timezone); err != nil {
+		return "invalid timezone: " + credentials.Timezone
+	}
+
+	return ""
+}
+
+var ErrEmailTaken = errors.New("email is already registered")
+
+func (srv *Server) CreateUser(ctx context.Context, credentials Credentials) (string, error) {
+	return createUser(ctx, srv.db, credentials)
+}
+
+// creates the user with db, which can be a transaction the account is part of
+func createUser(ctx context.Context, db rowQuerier, credentials Credentials) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(credentials.Password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+
+	userID := randomHex(16)
+	query := "INSERT INTO users (id, email, password_hash, timezone) VALUES ($1, $2, $3, $4) ON CONFLICT (email) DO NOTHING RETURNING id"
+	err = db.QueryRow(ctx, query, userID, credentials.Email, string(hash), credentials.Timezone).Scan(&userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", ErrEmailTaken
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return userID, nil
+}
+
+func (srv *Server) loginHandler(w http.ResponseWriter, r *http.Request) error {
+	var credentials Credentials
+	err := json.NewDecoder(r.Body).Decode(&credentials)
+	if err != nil {
+		return schedule.Errorf(schedule.ErrValidation, "invalid login format")
+	}
+
+	userID, err := srv.checkLogin(context.Background(), credentials)
+	if errors.Is(err, errInvalidLogin) || errors.Is(err, errSecondFactor) {
+		return schedule.Errorf(schedule.ErrUnauthorized, "%s", err.Error())
+	}
+	if errors.Is(err, schedule.ErrTooManyAttempts) {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("failed check login: %w", err)
+	}
+
+	return srv.startSession(w, r, userID)
+}
+
+var (
+	errInvalidLogin = errors.New("invalid email or password")
+	errSecondFactor = errors.New("a valid otp or recovery_code is required")
+)
+
+// the user the credentials belong to, including the second factor when the user enabled it
+func (srv *Server) checkLogin(ctx context.Context, credentials Credentials) (string, error) {
+	var userID, passwordHash string
+	var totpEnabled bool
+	query := "SELECT id, password_hash, totp_enabled FROM users WHERE email = $1"
+	err := srv.db.QueryRow(ctx, query, strings.ToLower(strings.TrimSpace(credentials.Email))).Scan(&userID, &passwordHash, &totpEnabled)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return "", errors.New("failed get user from database")
+	}
+	if err != nil || bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(credentials.Password)) != nil {
+		return "", errInvalidLogin
+	}
+
+	if totpEnabled {
+		ok, err := srv.verifySecondFactor(userID, credentials.OTP, credentials.RecoveryCode)
+		if errors.Is(err, schedule.ErrTooManyAttempts) {
+			return "", err
+		}
+		if err != nil {
+			return "", errors.New("failed verify second factor")
+		}
+		if !ok {
+			return "", errSecondFactor
+		}
+	}
+
+	return userID, nil
+}
+
+func jwtSecret() ([]byte, error) {
+	secret := os.Getenv("JWT_SECRET")
+	if secret == "" {
+		return nil, errors.New("JWT_SECRET is not set")
+	}
+
+	return []byte(secret), nil
+}
+
+// issues an HS256 signed JWT for the user's session
+func issueAccessToken(userID string, sessionID string) (string, error) {
+	secret, err := jwtSecret()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+	claims, err := json.Marshal(TokenClaims{Subject: userID, SessionID: sessionID, IssuedAt: now.Unix(), ExpiresAt: now.Add(accessTokenTTL).Unix()})
+	if err != nil {
+		return "", err
+	}
+
+	unsigned := header + "." + base64.RawURLEncoding.EncodeToString(claims)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(unsigned))
+
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+func parseAccessToken(token string) (TokenClaims, error) {
+	var claims TokenClaims
+	secret, err := jwtSecret()
+	if err != nil {
+		return claims, err
+	}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return claims, schedule.Errorf(schedule.ErrUnauthorized, "malformed token")
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return claims, schedule.Errorf(schedule.ErrUnauthorized, "malformed token signature")
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return claims, schedule.Errorf(schedule.ErrUnauthorized, "invalid token signature")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return claims, schedule.Errorf(schedule.ErrUnauthorized, "malformed token claims")
+	}
+	err = json.Unmarshal(payload, &claims)
+	if err != nil {
+		return claims, schedule.Errorf(schedule.ErrUnauthorized, "malformed token claims")
+	}
+	if time.Now().Unix() >= claims.ExpiresAt {
+		return claims, schedule.Errorf(schedule.ErrUnauthorized, "token expired")
+	}
+
+	return claims, nil
+}
+
+// requires a valid bearer access token and puts the user ID into the request context
+func authenticated(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if localRequest(r) {
+			next(w, r)
+			return
+		}
+
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			writeError(w, r, schedule.Errorf(schedule.ErrUnauthorized, "missing bearer token"))
+			return
+		}
+
+		claims, err := parseAccessToken(token)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		recordCaller(r.Context(), claims.Subject, "")
+		ctx := context.WithValue(r.Context(), userIDKey, claims.Subject)
+		ctx = context.WithValue(ctx, sessionIDKey, claims.SessionID)
+		next(w, r.WithContext(ctx))
+	}
+}
+
+func currentUserID(r *http.Request) string {
+	userID, _ := r.Context().Value(userIDKey).(string)
+	return userID
+}
+
+// the authenticated user, or the user_id parameter of legacy clients without credentials
+func requestUserID(r *http.Request) (string, error) {
+	userID := currentUserID(r)
+	if userID == "" {
+		userID = r.URL.Query().Get("user_id")
+	}
+	if userID == "" {
+		return "", schedule.Errorf(schedule.ErrValidation, "missing required parameter: user_id")
+	}
+
+	return userID, nil
+}
+
+func currentSessionID(r *http.Request) string {
+	sessionID, _ := r.Context().Value(sessionIDKey).(string)
+	return sessionID
+}
+
+func randomHex(size int) string {
+	b := make([]byte, size)
+	_, err := rand.Read(b)
+	if err != nil {
+		panic(err)
+	}
+
+	return hex.EncodeToString(b)
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
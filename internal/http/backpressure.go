@@ -0,0 +1,74 @@
+package http
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// Retry-After sent with shed requests unless LOAD_SHED_RETRY_AFTER gives another one
+const defaultLoadShedRetryAfter = 2
+
+// sheds requests with 503 once MAX_INFLIGHT_REQUESTS are being served or MAX_DB_WAITERS calls
+// wait for a database connection, so a saturated Postgres answers fast instead of queueing
+// every request. A limit of 0 or unset is no limit. Held long polls count as in flight.
+type loadShedder struct {
+	maxInFlight  int64
+	maxDBWaiters int64
+	retryAfter   int
+
+	inFlight atomic.Int64
+	shed     atomic.Int64
+}
+
+func envLimit(name string, fallback int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit < 0 {
+		log.Printf("invalid %s %q, using %d", name, value, fallback)
+		return fallback
+	}
+
+	return limit
+}
+
+func newLoadShedder() *loadShedder {
+	return &loadShedder{
+		maxInFlight:  int64(envLimit("MAX_INFLIGHT_REQUESTS", 0)),
+		maxDBWaiters: int64(envLimit("MAX_DB_WAITERS", 0)),
+		retryAfter:   envLimit("LOAD_SHED_RETRY_AFTER", defaultLoadShedRetryAfter),
+	}
+}
+
+// admin and debug routes are always served, so operators can look into an overloaded instance
+func (srv *Server) shedLoad(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/v1/admin/") || strings.HasPrefix(r.URL.Path, "/debug/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		shedder := srv.shedder
+		inFlight := shedder.inFlight.Add(1)
+		defer shedder.inFlight.Add(-1)
+
+		overloaded := shedder.maxInFlight > 0 && inFlight > shedder.maxInFlight
+		if !overloaded && shedder.maxDBWaiters > 0 {
+			overloaded = srv.db.Waiting() > shedder.maxDBWaiters
+		}
+		if overloaded {
+			shedder.shed.Add(1)
+			w.Header().Set("Retry-After", strconv.Itoa(shedder.retryAfter))
+			http.Error(w, "the service is overloaded, try again shortly", http.StatusServiceUnavailable)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,67 @@
+package http
+
+import (
+	"kode_test/internal/storage"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestShedLoad(t *testing.T) {
+	srv := &Server{db: &storage.DB{}, shedder: &loadShedder{maxInFlight: 1, maxDBWaiters: 5, retryAfter: 7}}
+	entered, release := make(chan struct{}), make(chan struct{})
+	handler := srv.shedLoad(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/next-dose" {
+			entered <- struct{}{}
+			<-release
+		}
+		w.Write([]byte("ok"))
+	}))
+	serve := func(path string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+		return recorder
+	}
+
+	if recorder := serve("/schedules"); recorder.Code != http.StatusOK {
+		t.Fatalf("idle instance answered %d", recorder.Code)
+	}
+
+	// a held long poll takes the only slot
+	done := make(chan struct{})
+	go func() {
+		serve("/v1/next-dose")
+		close(done)
+	}()
+	<-entered
+
+	shed := serve("/schedules")
+	if shed.Code != http.StatusServiceUnavailable || shed.Header().Get("Retry-After") != "7" {
+		t.Errorf("over the limit = %d with Retry-After %q, want 503 and 7", shed.Code, shed.Header().Get("Retry-After"))
+	}
+	for _, path := range []string{"/v1/admin/stats", "/debug/runtime"} {
+		if recorder := serve(path); recorder.Code != http.StatusOK {
+			t.Errorf("%s over the limit = %d, want 200", path, recorder.Code)
+		}
+	}
+
+	close(release)
+	<-done
+	if recorder := serve("/schedules"); recorder.Code != http.StatusOK {
+		t.Errorf("after the long poll = %d, want 200", recorder.Code)
+	}
+	if inFlight, count := srv.shedder.inFlight.Load(), srv.shedder.shed.Load(); inFlight != 0 || count != 1 {
+		t.Errorf("%d in flight and %d shed, want 0 and 1", inFlight, count)
+	}
+}
+
+func TestNewLoadShedder(t *testing.T) {
+	t.Setenv("MAX_INFLIGHT_REQUESTS", "200")
+	t.Setenv("MAX_DB_WAITERS", "-1")
+	t.Setenv("LOAD_SHED_RETRY_AFTER", "")
+
+	shedder := newLoadShedder()
+	if shedder.maxInFlight != 200 || shedder.maxDBWaiters != 0 || shedder.retryAfter != defaultLoadShedRetryAfter {
+		t.Errorf("limits %d, %d and Retry-After %d", shedder.maxInFlight, shedder.maxDBWaiters, shedder.retryAfter)
+	}
+}
@@ -0,0 +1,112 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"kode_test/internal/schedule"
+	"net/http"
+	"slices"
+)
+
+// upper bound of ids in one bulk request
+const bulkMaxIDs = 500
+
+type BulkRequest struct {
+	IDs []int `json:"ids"`
+	// delete, pause, resume or archive
+	Operation string `json:"operation"`
+}
+
+type BulkResult struct {
+	ID int `json:"id"`
+	// done, skipped or not_found
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+}
+
+// the status change of every operation and the statuses it applies to
+var bulkTransitions = map[string]struct {
+	to   string
+	from []string
+}{
+	"pause":   {"paused", []string{"active"}},
+	"resume":  {"active", []string{"paused"}},
+	"archive": {"archived", []string{"active", "paused", "completed"}},
+}
+
+// applies one operation to many schedules in a single transaction with a result per id
+func (srv *Server) bulkSchedulesHandler(w http.ResponseWriter, r *http.Request) error {
+	var bulk BulkRequest
+	err := json.NewDecoder(r.Body).Decode(&bulk)
+	if err != nil {
+		return schedule.Errorf(schedule.ErrValidation, "invalid bulk format")
+	}
+
+	var errs []error
+	_, isTransition := bulkTransitions[bulk.Operation]
+	if bulk.Operation != "delete" && !isTransition {
+		errs = append(errs, schedule.FieldErrorf("operation", "enum", "invalid operation, expected delete, pause, resume or archive"))
+	}
+	if len(bulk.IDs) == 0 {
+		errs = append(errs, schedule.FieldErrorf("ids", "required", "ids must contain 1 to %d schedule ids", bulkMaxIDs))
+	}
+	if len(bulk.IDs) > bulkMaxIDs {
+		errs = append(errs, schedule.FieldErrorf("ids", "max_items", "ids must contain 1 to %d schedule ids", bulkMaxIDs))
+	}
+	err = schedule.JoinFields(errs...)
+	if err != nil {
+		return err
+	}
+
+	userID := currentUserID(r)
+	ctx := context.Background()
+	results := make([]BulkResult, 0, len(bulk.IDs))
+	err = srv.db.InTx(ctx, func(tx pgx.Tx) error {
+		for _, id := range bulk.IDs {
+			result := BulkResult{ID: id, Status: "done"}
+
+			var owner, status string
+			query := "SELECT user_id, status FROM schedule WHERE id = $1 AND ($2 = '' OR user_id = $2) FOR UPDATE"
+			err := tx.QueryRow(ctx, query, id, userID).Scan(&owner, &status)
+			if errors.Is(err, pgx.ErrNoRows) {
+				result.Status, result.Message = "not_found", "schedule not found"
+				results = append(results, result)
+				continue
+			}
+			if err != nil {
+				return err
+			}
+
+			if bulk.Operation == "delete" {
+				_, err = tx.Exec(ctx, "DELETE FROM schedule WHERE id = $1", id)
+			} else if transition := bulkTransitions[bulk.Operation]; !slices.Contains(transition.from, status) {
+				result.Status, result.Message = "skipped", "schedule is "+status
+			} else {
+				if transition.to == "active" {
+					err = lockActivation(ctx, tx, owner, []int{id})
+				}
+				var domainErr *schedule.Error
+				if errors.As(err, &domainErr) {
+					result.Status, result.Message, err = "skipped", err.Error(), nil
+				} else if err == nil {
+					_, err = tx.Exec(ctx, "UPDATE schedule SET status = $1 WHERE id = $2", transition.to, id)
+				}
+			}
+			if err != nil {
+				return err
+			}
+
+			results = append(results, result)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed update schedules in database: %w", err)
+	}
+
+	fmt.Fprint(w, convertToJson(results))
+	return nil
+}
@@ -0,0 +1,58 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"kode_test/internal/schedule"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// a Matrix room id or alias, !room:server or #alias:server, or an XMPP address, user@server
+var chatAddressPattern = regexp.MustCompile(`^([!#][^:\s]+:[^\s]+|[^@\s/]+@[^@\s/]+)$`)
+
+// where the Matrix or XMPP notifier of a self-hosted deployment delivers, an empty one stops it
+func (srv *Server) putChatAddressHandler(w http.ResponseWriter, r *http.Request) error {
+	userID, err := pathUserID(r)
+	if err != nil {
+		return err
+	}
+	var body struct {
+		Address string `json:"address"`
+	}
+	err = json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		return schedule.Errorf(schedule.ErrValidation, "invalid chat address format")
+	}
+	body.Address = strings.TrimSpace(body.Address)
+	if body.Address != "" && (len(body.Address) > 255 || !chatAddressPattern.MatchString(body.Address)) {
+		return schedule.Errorf(schedule.ErrValidation, "address must be a Matrix room like !room:example.org or an XMPP address like user@example.org")
+	}
+
+	tag, err := srv.db.Exec(context.Background(), "UPDATE users SET chat_address = $2 WHERE id = $1", userID, body.Address)
+	if err != nil {
+		return fmt.Errorf("failed save chat address: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return schedule.Errorf(schedule.ErrNotFound, "user not found")
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, convertToJson(body))
+	return nil
+}
+
+// the chat address of a user for the chat notifiers, empty for unknown users
+func (srv *Server) ChatAddress(ctx context.Context, userID string) (string, error) {
+	var address string
+	err := srv.db.QueryRow(ctx, "SELECT chat_address FROM users WHERE id = $1", userID).Scan(&address)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", nil
+	}
+
+	return address, err
+}
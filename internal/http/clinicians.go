@@ -0,0 +1,367 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"kode_test/internal/schedule"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultAdherenceDays = 30
+
+// the other side of a clinician or caregiver link, by their account
+type ClinicianLink struct {
+	UserID   string    `json:"user_id"`
+	Email    string    `json:"email"`
+	LinkedAt time.Time `json:"linked_at"`
+	// clinician or caregiver
+	Kind string `json:"kind"`
+}
+
+type Adherence struct {
+	ScheduleID int      `json:"schedule_id"`
+	Medicine   string   `json:"medicine"`
+	Tags       []string `json:"tags,omitempty"`
+	Due        int      `json:"due"`
+	Taken      int      `json:"taken"`
+	Rate       float64  `json:"rate"`
+}
+
+// the schedules with one tag together, a schedule with several tags counts in each of them
+type TagAdherence struct {
+	// empty for the schedules without tags
+	Tag   string  `json:"tag"`
+	Due   int     `json:"due"`
+	Taken int     `json:"taken"`
+	Rate  float64 `json:"rate"`
+}
+
+type AdherenceReport struct {
+	From      time.Time      `json:"from"`
+	To        time.Time      `json:"to"`
+	Rate      float64        `json:"rate"`
+	Schedules []Adherence    `json:"schedules"`
+	Tags      []TagAdherence `json:"tags,omitempty"`
+}
+
+func (srv *Server) putUserRoleHandler(w http.ResponseWriter, r *http.Request) error {
+	var body struct {
+		Role string `json:"role"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		return schedule.Errorf(schedule.ErrValidation, "invalid role format")
+	}
+	if body.Role != "patient" && body.Role != "clinician" {
+		return schedule.FieldErrorf("role", "enum", "role must be patient or clinician")
+	}
+
+	tag, err := srv.db.Exec(context.Background(), "UPDATE users SET role = $2 WHERE id = $1", r.PathValue("id"), body.Role)
+	if err != nil {
+		return fmt.Errorf("failed save role in database: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return schedule.Errorf(schedule.ErrNotFound, "user not found")
+	}
+
+	fmt.Fprintf(w, "role saved")
+	return nil
+}
+
+func (srv *Server) collectLinks(ctx context.Context, query string, userID string) ([]ClinicianLink, error) {
+	rows, err := srv.db.QueryRead(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (ClinicianLink, error) {
+		var link ClinicianLink
+		err := row.Scan(&link.UserID, &link.Email, &link.LinkedAt, &link.Kind)
+		return link, err
+	})
+}
+
+// the clinicians the user shares their data with
+func (srv *Server) getCliniciansHandler(w http.ResponseWriter, r *http.Request) error {
+	query := `SELECT u.id, u.email, l.created_at, 'clinician' FROM clinician_link l JOIN users u ON u.id = l.clinician_id
+		WHERE l.patient_id = $1 ORDER BY l.created_at`
+	links, err := srv.collectLinks(context.Background(), query, currentUserID(r))
+	if err != nil {
+		return fmt.Errorf("failed get clinicians from database: %w", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, convertToJson(links))
+	return nil
+}
+
+// gives a clinician, found by the email of their account, read access to the user's data
+func (srv *Server) linkClinicianHandler(w http.ResponseWriter, r *http.Request) error {
+	var body struct {
+		Email string `json:"email"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil || strings.TrimSpace(body.Email) == "" {
+		return schedule.Errorf(schedule.ErrValidation, "email is required")
+	}
+
+	var link ClinicianLink
+	query := `INSERT INTO clinician_link (patient_id, clinician_id)
+		SELECT $1, id FROM users WHERE email = $2 AND role = 'clinician' AND id <> $1
+		ON CONFLICT (patient_id, clinician_id) DO UPDATE SET patient_id = EXCLUDED.patient_id
+		RETURNING clinician_id, created_at`
+	err = srv.db.QueryRow(context.Background(), query, currentUserID(r), strings.TrimSpace(body.Email)).Scan(&link.UserID, &link.LinkedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return schedule.Errorf(schedule.ErrNotFound, "clinician not found")
+	}
+	if err != nil {
+		return fmt.Errorf("failed link clinician: %w", err)
+	}
+	link.Email, link.Kind = strings.TrimSpace(body.Email), "clinician"
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	fmt.Fprint(w, convertToJson(link))
+	return nil
+}
+
+func (srv *Server) unlinkClinicianHandler(w http.ResponseWriter, r *http.Request) error {
+	query := "DELETE FROM clinician_link WHERE patient_id = $1 AND clinician_id = $2"
+	tag, err := srv.db.Exec(context.Background(), query, currentUserID(r), r.PathValue("id"))
+	if err != nil {
+		return fmt.Errorf("failed unlink clinician: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return schedule.Errorf(schedule.ErrNotFound, "clinician not found")
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// the caregivers who accepted an invite of the user
+func (srv *Server) getCaregiversHandler(w http.ResponseWriter, r *http.Request) error {
+	query := `SELECT u.id, u.email, l.created_at, 'caregiver' FROM caregiver_link l JOIN users u ON u.id = l.caregiver_id
+		WHERE l.patient_id = $1 ORDER BY l.created_at`
+	links, err := srv.collectLinks(context.Background(), query, currentUserID(r))
+	if err != nil {
+		return fmt.Errorf("failed get caregivers from database: %w", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, convertToJson(links))
+	return nil
+}
+
+func (srv *Server) unlinkCaregiverHandler(w http.ResponseWriter, r *http.Request) error {
+	query := "DELETE FROM caregiver_link WHERE patient_id = $1 AND caregiver_id = $2"
+	tag, err := srv.db.Exec(context.Background(), query, currentUserID(r), r.PathValue("id"))
+	if err != nil {
+		return fmt.Errorf("failed unlink caregiver: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return schedule.Errorf(schedule.ErrNotFound, "caregiver not found")
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// the patient of a portal path, patients that did not link the clinician or caregiver are not found
+func (srv *Server) linkedPatientID(ctx context.Context, r *http.Request) (string, error) {
+	patientID := r.PathValue("id")
+	var linked bool
+	query := `SELECT EXISTS (SELECT 1 FROM clinician_link l JOIN users u ON u.id = l.clinician_id
+			WHERE l.patient_id = $1 AND l.clinician_id = $2 AND u.role = 'clinician')
+		OR EXISTS (SELECT 1 FROM caregiver_link WHERE patient_id = $1 AND caregiver_id = $2)`
+	err := srv.db.QueryRow(ctx, query, patientID, currentUserID(r)).Scan(&linked)
+	if err != nil {
+		return "", fmt.Errorf("failed get clinician link from database: %w", err)
+	}
+	if !linked {
+		return "", schedule.Errorf(schedule.ErrNotFound, "patient not found")
+	}
+
+	return patientID, nil
+}
+
+// the patients who linked the user as their clinician or caregiver
+func (srv *Server) getPatientsHandler(w http.ResponseWriter, r *http.Request) error {
+	query := `SELECT u.id, u.email, l.created_at, 'clinician' FROM clinician_link l
+			JOIN users u ON u.id = l.patient_id JOIN users c ON c.id = l.clinician_id
+			WHERE l.clinician_id = $1 AND c.role = 'clinician'
+		UNION ALL
+		SELECT u.id, u.email, l.created_at, 'caregiver' FROM caregiver_link l JOIN users u ON u.id = l.patient_id
+			WHERE l.caregiver_id = $1
+		ORDER BY 3`
+	links, err := srv.collectLinks(context.Background(), query, currentUserID(r))
+	if err != nil {
+		return fmt.Errorf("failed get patients from database: %w", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, convertToJson(links))
+	return nil
+}
+
+// the active schedules of the patient with their course progress
+func (srv *Server) getPatientSchedulesHandler(w http.ResponseWriter, r *http.Request) error {
+	ctx := context.Background()
+	patientID, err := srv.linkedPatientID(ctx, r)
+	if err != nil {
+		return err
+	}
+
+	schedules, err := srv.ListUserSchedules(ctx, patientID, "active", time.Time{}, nil)
+	if err != nil {
+		return fmt.Errorf("failed get schedules from database: %w", err)
+	}
+	loc, err := srv.userLocation(r, patientID)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for i := range schedules {
+		progress := schedule.CourseProgress(schedules[i], now, loc)
+		schedules[i].Progress = &progress
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, convertToJson(schedules))
+	return nil
+}
+
+// logged intakes against planned doses over the last days, 30 by default.
+// tag= limits it to schedules with all the tags, group_by=tag adds the rates per tag.
+func (srv *Server) getPatientAdherenceHandler(w http.ResponseWriter, r *http.Request) error {
+	ctx := context.Background()
+	patientID, err := srv.linkedPatientID(ctx, r)
+	if err != nil {
+		return err
+	}
+
+	days := defaultAdherenceDays
+	if value := r.URL.Query().Get("days"); value != "" {
+		days, err = strconv.Atoi(value)
+		if err != nil || days < 1 || days > 365 {
+			return schedule.Errorf(schedule.ErrValidation, "days must be between 1 and 365")
+		}
+	}
+	tags, err := schedule.NormalizeTags(r.URL.Query()["tag"])
+	if err != nil {
+		return err
+	}
+	groupBy := r.URL.Query().Get("group_by")
+	if groupBy != "" && groupBy != "tag" {
+		return schedule.Errorf(schedule.ErrValidation, "invalid group_by, expected tag")
+	}
+	loc, err := srv.userLocation(r, patientID)
+	if err != nil {
+		return err
+	}
+
+	report := AdherenceReport{To: time.Now().Truncate(time.Second), Schedules: []Adherence{}}
+	report.From = report.To.AddDate(0, 0, -days)
+
+	schedules, err := srv.ListUserSchedules(ctx, patientID, "", time.Time{}, tags)
+	if err != nil {
+		return fmt.Errorf("failed get schedules from database: %w", err)
+	}
+	query := `SELECT schedule_id, count(*) FROM intake_log
+		WHERE user_id = $1 AND taken_at >= $2 AND taken_at < $3 GROUP BY schedule_id`
+	rows, err := srv.db.QueryRead(ctx, query, patientID, report.From, report.To)
+	if err != nil {
+		return fmt.Errorf("failed get intakes from database: %w", err)
+	}
+	taken := map[int]int{}
+	for rows.Next() {
+		var scheduleID, count int
+		err = rows.Scan(&scheduleID, &count)
+		if err != nil {
+			rows.Close()
+			return fmt.Errorf("failed get intakes from database: %w", err)
+		}
+		taken[scheduleID] = count
+	}
+	rows.Close()
+	if rows.Err() != nil {
+		return fmt.Errorf("failed get intakes from database: %w", rows.Err())
+	}
+
+	var totalDue, totalTaken int
+	for _, s := range schedules {
+		due := schedule.DosesDue(s, report.From, report.To, loc)
+		if due == 0 {
+			continue
+		}
+		// extra intakes do not make up for missed ones
+		adherence := Adherence{ScheduleID: s.ID, Medicine: s.Medicine, Tags: s.Tags, Due: due, Taken: min(taken[s.ID], due)}
+		adherence.Rate = float64(adherence.Taken) / float64(due)
+		report.Schedules = append(report.Schedules, adherence)
+		totalDue += due
+		totalTaken += adherence.Taken
+	}
+	if totalDue > 0 {
+		report.Rate = float64(totalTaken) / float64(totalDue)
+	}
+	if groupBy == "tag" {
+		report.Tags = adherenceByTag(report.Schedules)
+	}
+
+	// as a table the report is its rows per schedule, or per tag when grouped
+	if format := acceptedListFormat(r); format == "text/csv" || format == "text/plain" {
+		if groupBy == "tag" {
+			writeList(w, r, report.Tags)
+			return nil
+		}
+		writeList(w, r, report.Schedules)
+		return nil
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, convertToJson(report))
+	return nil
+}
+
+func adherenceByTag(schedules []Adherence) []TagAdherence {
+	groups := []TagAdherence{}
+	index := map[string]int{}
+	for _, adherence := range schedules {
+		tags := adherence.Tags
+		if len(tags) == 0 {
+			tags = []string{""}
+		}
+		for _, tag := range tags {
+			i, ok := index[tag]
+			if !ok {
+				i = len(groups)
+				index[tag] = i
+				groups = append(groups, TagAdherence{Tag: tag})
+			}
+			groups[i].Due += adherence.Due
+			groups[i].Taken += adherence.Taken
+		}
+	}
+
+	for i := range groups {
+		groups[i].Rate = float64(groups[i].Taken) / float64(groups[i].Due)
+	}
+	slices.SortFunc(groups, func(a, b TagAdherence) int { return strings.Compare(a.Tag, b.Tag) })
+
+	return groups
+}
+
+func (srv *Server) getPatientSideEffectsHandler(w http.ResponseWriter, r *http.Request) error {
+	patientID, err := srv.linkedPatientID(context.Background(), r)
+	if err != nil {
+		return err
+	}
+
+	return srv.writeSideEffects(w, r, patientID)
+}
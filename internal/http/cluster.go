@@ -0,0 +1,75 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// CLUSTER_MODE=true is for running several instances against one database. The HTTP
+// layer keeps no state of its own that matters across requests: sessions, caches and
+// the maintenance switch live in the database and are invalidated by notifications,
+// rate limits are shared through Redis. Jobs that must not run twice at once take a
+// database lock in this mode, the queue jobs claim their rows with SKIP LOCKED anyway.
+func clusterMode() bool {
+	return os.Getenv("CLUSTER_MODE") == "true"
+}
+
+// fails when the configuration only works for a single instance, or Redis can not be
+// reached so every instance would start out counting rate limits on its own
+func CheckClusterConfig(ctx context.Context) error {
+	if !clusterMode() {
+		return nil
+	}
+	address := os.Getenv("RATE_LIMIT_REDIS_URL")
+	if address == "" {
+		return errors.New("CLUSTER_MODE needs RATE_LIMIT_REDIS_URL, rate limits would be counted per instance")
+	}
+	if localMode() {
+		return errors.New("CLUSTER_MODE can not be combined with LOCAL_MODE")
+	}
+
+	limiter, err := newRedisLimiter(address)
+	if err != nil {
+		return fmt.Errorf("invalid RATE_LIMIT_REDIS_URL: %w", err)
+	}
+	defer limiter.close()
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	err = limiter.ping(ctx)
+	if err != nil {
+		return fmt.Errorf("CLUSTER_MODE needs Redis at RATE_LIMIT_REDIS_URL: %w", err)
+	}
+
+	return nil
+}
+
+// runs job unless another instance is running it, in cluster mode only, a single
+// instance runs it right away
+func (srv *Server) exclusively(ctx context.Context, name string, job func(ctx context.Context) error) error {
+	if !clusterMode() {
+		return job(ctx)
+	}
+
+	// advisory locks belong to a connection, so the same one has to unlock
+	conn, err := srv.db.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	var locked bool
+	err = conn.QueryRow(ctx, "SELECT pg_try_advisory_lock(hashtext($1))", "job:"+name).Scan(&locked)
+	if err != nil {
+		return err
+	}
+	if !locked {
+		return nil
+	}
+	defer conn.Exec(context.Background(), "SELECT pg_advisory_unlock(hashtext($1))", "job:"+name)
+
+	return job(ctx)
+}
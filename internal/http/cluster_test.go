@@ -0,0 +1,42 @@
+package http
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestCheckClusterConfig(t *testing.T) {
+	redis := startFakeRedis(t, &fakeRedis{password: "secret"})
+	down := startFakeRedis(t, &fakeRedis{refuse: true})
+
+	tests := []struct {
+		cluster string
+		local   string
+		url     string
+		wantErr string
+	}{
+		{"", "", "", ""},
+		{"", "", "http://redis.internal", ""},
+		{"true", "", redis.url(), ""},
+		{"true", "", "", "needs RATE_LIMIT_REDIS_URL"},
+		{"true", "true", redis.url(), "LOCAL_MODE"},
+		{"true", "", "http://redis.internal", "invalid RATE_LIMIT_REDIS_URL"},
+		{"true", "", "redis://redis.internal/db", "invalid RATE_LIMIT_REDIS_URL"},
+		{"true", "", down.url(), "needs Redis"},
+		{"true", "", "redis://:guess@" + redis.listener.Addr().String(), "WRONGPASS"},
+	}
+	for _, test := range tests {
+		t.Setenv("CLUSTER_MODE", test.cluster)
+		t.Setenv("LOCAL_MODE", test.local)
+		t.Setenv("RATE_LIMIT_REDIS_URL", test.url)
+
+		err := CheckClusterConfig(context.Background())
+		if test.wantErr == "" && err != nil {
+			t.Errorf("CLUSTER_MODE=%q RATE_LIMIT_REDIS_URL=%q: %v", test.cluster, test.url, err)
+		}
+		if test.wantErr != "" && (err == nil || !strings.Contains(err.Error(), test.wantErr)) {
+			t.Errorf("CLUSTER_MODE=%q RATE_LIMIT_REDIS_URL=%q = %v, want %q", test.cluster, test.url, err, test.wantErr)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package http
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+const completionJobInterval = time.Hour
+
+// marks schedules whose course (created_at + frequency days) is over as completed,
+// schedules with frequency 0 never end
+func (srv *Server) CompleteFinishedSchedules(ctx context.Context) (int64, error) {
+	query := "UPDATE schedule SET status = 'completed' WHERE status IN ('active', 'paused') AND frequency > 0 AND created_at::date + frequency <= current_date"
+	tag, err := srv.db.Exec(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+
+	return tag.RowsAffected(), nil
+}
+
+func (srv *Server) RunCompletionJob(ctx context.Context) {
+	ticker := time.NewTicker(completionJobInterval)
+	defer ticker.Stop()
+
+	for {
+		// the next run after the maintenance window catches up
+		if !srv.inMaintenance() {
+			err := srv.exclusively(drainable(ctx), "completion", func(ctx context.Context) error {
+				completed, err := srv.CompleteFinishedSchedules(ctx)
+				if completed > 0 {
+					log.Printf("completion job: %d schedules completed", completed)
+				}
+				return err
+			})
+			if err != nil {
+				log.Printf("completion job: %v", err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
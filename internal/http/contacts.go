@@ -0,0 +1,125 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"kode_test/internal/schedule"
+	"kode_test/internal/storage"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// suggestions per autocomplete request
+const contactSuggestions = 10
+
+type DirectoryContact struct {
+	ID   string `json:"id"`
+	Kind string `json:"kind"`
+	schedule.Contact
+	UseCount int `json:"use_count"`
+}
+
+func validContacts(s schedule.Schedule) error {
+	var errs []error
+	for _, kind := range []string{"prescriber", "pharmacy"} {
+		contact := s.Prescriber
+		if kind == "pharmacy" {
+			contact = s.Pharmacy
+		}
+		if contact != nil && strings.TrimSpace(contact.Name) == "" {
+			errs = append(errs, schedule.FieldErrorf(kind+".name", "required", "%s needs a name", kind))
+		}
+	}
+
+	return schedule.JoinFields(errs...)
+}
+
+// the name of a stored prescriber or pharmacy, empty without one
+func (srv *Server) contactName(raw []byte) (string, error) {
+	var contact *schedule.Contact
+	err := srv.cipher.DecryptJSON(raw, &contact)
+	if err != nil || contact == nil {
+		return "", err
+	}
+
+	return contact.Name, nil
+}
+
+// adds the prescriber and pharmacy of a saved schedule to the user's directory,
+// known names get the newer details and move up in the suggestions.
+// Entries are encrypted like the schedule and found by the hash of the name.
+func (srv *Server) rememberContacts(ctx context.Context, s schedule.Schedule) {
+	query := `INSERT INTO contact (user_id, kind, name, name_hash, phone, email, address) VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (user_id, kind, name_hash) DO UPDATE SET
+			phone = COALESCE(NULLIF(EXCLUDED.phone, ''), contact.phone),
+			email = COALESCE(NULLIF(EXCLUDED.email, ''), contact.email),
+			address = COALESCE(NULLIF(EXCLUDED.address, ''), contact.address),
+			use_count = contact.use_count + 1,
+			last_used_at = now()`
+	for kind, contact := range map[string]*schedule.Contact{"prescriber": s.Prescriber, "pharmacy": s.Pharmacy} {
+		if contact == nil {
+			continue
+		}
+		name := strings.TrimSpace(contact.Name)
+		fields := []string{name, contact.Phone, contact.Email, contact.Address}
+		var err error
+		for i := range fields {
+			if err == nil {
+				fields[i], err = srv.cipher.EncryptOptional(fields[i])
+			}
+		}
+		if err == nil {
+			_, err = srv.db.Exec(ctx, query, s.UserID, kind, fields[0], storage.ContactNameHash(name), fields[1], fields[2], fields[3])
+		}
+		if err != nil {
+			log.Printf("contacts: remember %s of schedule %d: %v", kind, s.ID, err)
+		}
+	}
+}
+
+// prescribers or pharmacies of the user whose name starts with q, most used first.
+// The names are encrypted, so the user's directory is filtered after decrypting it.
+func (srv *Server) getContactsHandler(w http.ResponseWriter, r *http.Request) error {
+	userID, err := requestUserID(r)
+	if err != nil {
+		return err
+	}
+	urlParams := r.URL.Query()
+	kind := urlParams.Get("kind")
+	if kind != "prescriber" && kind != "pharmacy" {
+		return schedule.Errorf(schedule.ErrValidation, "invalid kind, expected prescriber or pharmacy")
+	}
+	prefix := strings.ToLower(urlParams.Get("q"))
+
+	query := `SELECT id::text, kind, name, phone, email, address, use_count FROM contact
+		WHERE user_id = $1 AND kind = $2 ORDER BY use_count DESC, last_used_at DESC`
+	rows, err := srv.db.QueryRead(context.Background(), query, userID, kind)
+	if err != nil {
+		return fmt.Errorf("failed get contacts from database: %w", err)
+	}
+	all, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (DirectoryContact, error) {
+		var c DirectoryContact
+		err := row.Scan(&c.ID, &c.Kind, &c.Name, &c.Phone, &c.Email, &c.Address, &c.UseCount)
+		for _, field := range []*string{&c.Name, &c.Phone, &c.Email, &c.Address} {
+			if err == nil {
+				*field, err = srv.cipher.Decrypt(*field)
+			}
+		}
+		return c, err
+	})
+	if err != nil {
+		return fmt.Errorf("failed get contacts from database: %w", err)
+	}
+
+	contacts := []DirectoryContact{}
+	for _, c := range all {
+		if len(contacts) < contactSuggestions && strings.HasPrefix(strings.ToLower(c.Name), prefix) {
+			contacts = append(contacts, c)
+		}
+	}
+
+	writeList(w, r, contacts)
+	return nil
+}
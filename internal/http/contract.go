@@ -0,0 +1,164 @@
+package http
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"kode_test/internal/contract"
+	"kode_test/internal/schedule"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// checks JSON bodies against the OpenAPI document at OPENAPI_SPEC, meant for development and staging.
+// By default a mismatch is only logged, with OPENAPI_VALIDATION=enforce invalid requests get a 400
+// listing the fields and responses that drifted from the document a 500.
+type contractCheck struct {
+	doc     *contract.Document
+	enforce bool
+}
+
+// nil without OPENAPI_SPEC, a document that can not be loaded is logged and not checked against
+func newContractCheck() *contractCheck {
+	path := os.Getenv("OPENAPI_SPEC")
+	if path == "" {
+		return nil
+	}
+	doc, err := contract.Load(path)
+	if err != nil {
+		log.Printf("contract: %v, requests are not checked", err)
+		return nil
+	}
+
+	return &contractCheck{doc: doc, enforce: os.Getenv("OPENAPI_VALIDATION") == "enforce"}
+}
+
+// the document the checks run against, 404 when none is configured
+func (srv *Server) getOpenAPIHandler(w http.ResponseWriter, r *http.Request) {
+	if srv.contract == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(srv.contract.doc.Raw())
+}
+
+// routes the document does not describe and bodies that are not JSON pass unchecked
+func (srv *Server) checkContract(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		check := srv.contract
+		if check == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		op, ok := check.doc.Find(r.Method, r.URL.Path)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if contentType := r.Header.Get("Content-Type"); contentType == "" || strings.Contains(contentType, "json") {
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				http.Error(w, "failed read request body", http.StatusBadRequest)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			if violations := op.CheckRequest(body); len(violations) > 0 {
+				log.Printf("contract: request %s %s: %s", r.Method, op.Path, describeViolations(violations))
+				if check.enforce {
+					writeError(w, r, violationsError(violations))
+					return
+				}
+			}
+		}
+
+		recorder := &contractRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, r)
+		if recorder.buffer == nil {
+			return
+		}
+		if violations := op.CheckResponse(recorder.status, recorder.buffer.Bytes()); len(violations) > 0 {
+			log.Printf("contract: response %d of %s %s: %s", recorder.status, r.Method, op.Path, describeViolations(violations))
+			if check.enforce {
+				w.WriteHeader(http.StatusInternalServerError)
+				fmt.Fprint(w, convertToJson(ErrorEnvelope{Error: "the response does not match the API contract"}))
+				return
+			}
+		}
+		w.WriteHeader(recorder.status)
+		w.Write(recorder.buffer.Bytes())
+	})
+}
+
+func describeViolations(violations []contract.Violation) string {
+	described := make([]string, len(violations))
+	for i, violation := range violations {
+		described[i] = violation.String()
+	}
+
+	return strings.Join(described, "; ")
+}
+
+// the violations of a request as a validation error listing the fields
+func violationsError(violations []contract.Violation) error {
+	errs := make([]error, len(violations))
+	for i, violation := range violations {
+		field := violation.Path
+		if field == "" {
+			field = "body"
+		}
+		errs[i] = schedule.FieldErrorf(field, violation.Rule, "%s %s", field, violation.Message)
+	}
+
+	return schedule.JoinFields(errs...)
+}
+
+// holds back JSON responses until they are checked, everything else like event streams
+// and downloads goes straight through
+type contractRecorder struct {
+	http.ResponseWriter
+	status  int
+	decided bool
+	buffer  *bytes.Buffer
+}
+
+func (rec *contractRecorder) WriteHeader(status int) {
+	if rec.decided {
+		return
+	}
+	rec.decided = true
+	rec.status = status
+	if strings.HasPrefix(rec.Header().Get("Content-Type"), "application/json") {
+		rec.buffer = &bytes.Buffer{}
+		return
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *contractRecorder) Write(b []byte) (int, error) {
+	if !rec.decided {
+		rec.WriteHeader(http.StatusOK)
+	}
+	if rec.buffer != nil {
+		return rec.buffer.Write(b)
+	}
+
+	return rec.ResponseWriter.Write(b)
+}
+
+func (rec *contractRecorder) Flush() {
+	if !rec.decided {
+		rec.WriteHeader(http.StatusOK)
+	}
+	if flusher, ok := rec.ResponseWriter.(http.Flusher); ok && rec.buffer == nil {
+		flusher.Flush()
+	}
+}
+
+func (rec *contractRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
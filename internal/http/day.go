@@ -0,0 +1,125 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"kode_test/internal/schedule"
+	"net/http"
+	"time"
+)
+
+// the timezone and waking window doses are planned in, and the shift they follow after a change
+type DaySettings struct {
+	Timezone string `json:"timezone"`
+	schedule.Window
+	Shift *schedule.TimeShift `json:"shift,omitempty"`
+	// on a change, moves doses at most this many minutes a day instead of jumping to the new times
+	MaxShiftMinutesPerDay int `json:"max_shift_minutes_per_day,omitempty"`
+}
+
+func (srv *Server) getDaySettingsHandler(w http.ResponseWriter, r *http.Request) error {
+	userID, err := pathUserID(r)
+	if err != nil {
+		return err
+	}
+
+	var settings DaySettings
+	query := "SELECT u.timezone, u.wake_time, u.sleep_time, t.shift FROM users u LEFT JOIN time_shift t ON t.user_id = u.id WHERE u.id = $1"
+	err = srv.db.QueryRow(context.Background(), query, userID).Scan(&settings.Timezone, &settings.Wake, &settings.Sleep, &settings.Shift)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return schedule.Errorf(schedule.ErrNotFound, "user not found")
+	}
+	if err != nil {
+		return fmt.Errorf("failed get waking window from database: %w", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, convertToJson(settings))
+	return nil
+}
+
+// changes the timezone and waking window, fields left out keep their value
+func (srv *Server) putDaySettingsHandler(w http.ResponseWriter, r *http.Request) error {
+	userID, err := pathUserID(r)
+	if err != nil {
+		return err
+	}
+	var change DaySettings
+	err = json.NewDecoder(r.Body).Decode(&change)
+	if err != nil {
+		return schedule.Errorf(schedule.ErrValidation, "invalid day settings format")
+	}
+	if change.MaxShiftMinutesPerDay < 0 {
+		return schedule.FieldErrorf("max_shift_minutes_per_day", "min", "max_shift_minutes_per_day must be between 0 and 180")
+	}
+	if change.MaxShiftMinutesPerDay > 180 {
+		return schedule.FieldErrorf("max_shift_minutes_per_day", "max", "max_shift_minutes_per_day must be between 0 and 180")
+	}
+
+	ctx := context.Background()
+	var settings DaySettings
+	err = srv.db.InTx(ctx, func(tx pgx.Tx) error {
+		var before DaySettings
+		err := tx.QueryRow(ctx, "SELECT timezone, wake_time, sleep_time FROM users WHERE id = $1 FOR UPDATE", userID).Scan(&before.Timezone, &before.Wake, &before.Sleep)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return schedule.Errorf(schedule.ErrNotFound, "user not found")
+		}
+		if err != nil {
+			return err
+		}
+
+		settings = before
+		if change.Timezone != "" {
+			settings.Timezone = change.Timezone
+		}
+		if change.Wake != "" {
+			settings.Wake = change.Wake
+		}
+		if change.Sleep != "" {
+			settings.Sleep = change.Sleep
+		}
+		loc, err := time.LoadLocation(settings.Timezone)
+		if err != nil {
+			return schedule.FieldErrorf("timezone", "format", "invalid timezone: %s", settings.Timezone)
+		}
+		err = settings.Window.Validate()
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(ctx, "UPDATE users SET timezone = $2, wake_time = $3, sleep_time = $4 WHERE id = $1", userID, settings.Timezone, settings.Wake, settings.Sleep)
+		if err != nil {
+			return err
+		}
+		// without a shift doses jump to the new times, a change back to the same day has nothing to shift
+		if change.MaxShiftMinutesPerDay == 0 || settings == before {
+			_, err = tx.Exec(ctx, "DELETE FROM time_shift WHERE user_id = $1", userID)
+			return err
+		}
+
+		settings.Shift = &schedule.TimeShift{
+			FromTimezone:     before.Timezone,
+			From:             before.Window,
+			StartDate:        schedule.LocalDate(time.Now(), loc).Format("2006-01-02"),
+			MaxMinutesPerDay: change.MaxShiftMinutesPerDay,
+		}
+		query := `INSERT INTO time_shift (user_id, shift) VALUES ($1, $2)
+			ON CONFLICT (user_id) DO UPDATE SET shift = EXCLUDED.shift, created_at = now()`
+		_, err = tx.Exec(ctx, query, userID, settings.Shift)
+		return err
+	})
+	var domainErr *schedule.Error
+	if errors.As(err, &domainErr) {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("failed save day settings: %w", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, convertToJson(settings))
+	return nil
+}
@@ -0,0 +1,137 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"kode_test/internal/schedule"
+	"time"
+)
+
+// everything that moves the doses of a user away from the default times: a trip,
+// the waking window with the shift after a change, and the variants for days off
+type dayPlan struct {
+	travel   *schedule.Travel
+	window   schedule.Window
+	shift    *schedule.TimeShift
+	holidays []string
+	variants map[int]schedule.Variants
+}
+
+func newDayPlan() *dayPlan {
+	return &dayPlan{window: schedule.DefaultWindow, variants: map[int]schedule.Variants{}}
+}
+
+// t in the location the doses of s are planned in on its day at home, and the times of the doses that day
+func (p *dayPlan) doseTimes(s schedule.Schedule, t time.Time, home *time.Location) (time.Time, []time.Time) {
+	local := t.In(p.travel.Location(home, s.ID, schedule.LocalDate(t, home)))
+	window := p.variants[s.ID].Window(local, p.window, p.holidays)
+
+	return local, schedule.ShiftedDoseTimes(s, local, window, p.shift)
+}
+
+// an empty $1 selects every user
+const (
+	queryDayWindows  = "SELECT u.id, u.wake_time, u.sleep_time, t.shift FROM users u LEFT JOIN time_shift t ON t.user_id = u.id WHERE $1 IN ('', u.id)"
+	queryDayTravels  = "SELECT user_id, plan FROM travel_plan WHERE $1 IN ('', user_id)"
+	queryDayHolidays = "SELECT user_id, to_char(day, 'YYYY-MM-DD') FROM holiday WHERE $1 IN ('', user_id)"
+	queryDayVariants = "SELECT s.user_id, v.schedule_id, v.variants FROM schedule_variant v JOIN schedule s ON s.id = v.schedule_id WHERE $1 IN ('', s.user_id)"
+)
+
+// the plan of one user, the default one for unknown users
+func (srv *Server) userDayPlan(ctx context.Context, userID string) (*dayPlan, error) {
+	plans, err := srv.loadDayPlans(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if plan, ok := plans[userID]; ok {
+		return plan, nil
+	}
+
+	return newDayPlan(), nil
+}
+
+// the plans of every user, for the reminder worker
+func (srv *Server) collectDayPlans(ctx context.Context) (map[string]*dayPlan, error) {
+	return srv.loadDayPlans(ctx, "")
+}
+
+func (srv *Server) loadDayPlans(ctx context.Context, userID string) (map[string]*dayPlan, error) {
+	plans := map[string]*dayPlan{}
+	plan := func(owner string) *dayPlan {
+		if plans[owner] == nil {
+			plans[owner] = newDayPlan()
+		}
+		return plans[owner]
+	}
+
+	rows, err := srv.db.Query(ctx, queryDayWindows, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed get waking windows from database: %w", err)
+	}
+	var owner string
+	var window schedule.Window
+	var shift *schedule.TimeShift
+	_, err = pgx.ForEachRow(rows, []any{&owner, &window.Wake, &window.Sleep, &shift}, func() error {
+		plan(owner).window, plan(owner).shift, shift = window, shift, nil
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed get waking windows from database: %w", err)
+	}
+
+	rows, err = srv.db.Query(ctx, queryDayTravels, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed get travel plans from database: %w", err)
+	}
+	// JSON is decoded into the existing value, every row gets a new one
+	var travel *schedule.Travel
+	_, err = pgx.ForEachRow(rows, []any{&owner, &travel}, func() error {
+		plan(owner).travel, travel = travel, nil
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed get travel plans from database: %w", err)
+	}
+
+	rows, err = srv.db.Query(ctx, queryDayHolidays, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed get holidays from database: %w", err)
+	}
+	var day string
+	_, err = pgx.ForEachRow(rows, []any{&owner, &day}, func() error {
+		plan(owner).holidays = append(plan(owner).holidays, day)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed get holidays from database: %w", err)
+	}
+
+	rows, err = srv.db.Query(ctx, queryDayVariants, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed get schedule variants from database: %w", err)
+	}
+	var scheduleID int
+	var variants schedule.Variants
+	_, err = pgx.ForEachRow(rows, []any{&owner, &scheduleID, &variants}, func() error {
+		plan(owner).variants[scheduleID], variants = variants, schedule.Variants{}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed get schedule variants from database: %w", err)
+	}
+
+	return plans, nil
+}
+
+// the variants of a schedule, none when it has no row
+func (srv *Server) scheduleVariants(ctx context.Context, scheduleID int) (schedule.Variants, error) {
+	var variants schedule.Variants
+	err := srv.db.QueryRow(ctx, "SELECT variants FROM schedule_variant WHERE schedule_id = $1", scheduleID).Scan(&variants)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return variants, nil
+	}
+
+	return variants, err
+}
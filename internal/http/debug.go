@@ -0,0 +1,96 @@
+package http
+
+import (
+	"fmt"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"kode_test/internal/breaker"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"time"
+)
+
+type PoolStats struct {
+	MaxConns          int32         `json:"max_conns"`
+	TotalConns        int32         `json:"total_conns"`
+	AcquiredConns     int32         `json:"acquired_conns"`
+	IdleConns         int32         `json:"idle_conns"`
+	AcquireCount      int64         `json:"acquire_count"`
+	EmptyAcquireCount int64         `json:"empty_acquire_count"`
+	AcquireDuration   time.Duration `json:"acquire_duration_ns"`
+}
+
+type RuntimeStats struct {
+	Goroutines     int        `json:"goroutines"`
+	GOMAXPROCS     int        `json:"gomaxprocs"`
+	HeapAllocBytes uint64     `json:"heap_alloc_bytes"`
+	SysBytes       uint64     `json:"sys_bytes"`
+	NumGC          uint32     `json:"num_gc"`
+	Primary        PoolStats  `json:"primary_pool"`
+	Replica        *PoolStats `json:"replica_pool,omitempty"`
+	ReplicaHealthy bool       `json:"replica_healthy"`
+	// clients with an open rate limit window
+	RateLimitWindows int `json:"rate_limit_windows"`
+	// notification providers and external APIs
+	Breakers []breaker.Stats `json:"breakers"`
+	// requests being served, calls waiting for a database connection and requests shed with 503
+	InFlightRequests int64 `json:"in_flight_requests"`
+	DBWaiters        int64 `json:"db_waiters"`
+	ShedRequests     int64 `json:"shed_requests"`
+}
+
+// profiling and runtime endpoints, mounted behind admin auth. The pprof paths are
+// fixed by net/http/pprof, so they stay under /debug/pprof/.
+func (srv *Server) debugMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.HandleFunc("GET /debug/runtime", srv.runtimeStatsHandler)
+
+	return mux
+}
+
+func poolStats(pool *pgxpool.Pool) PoolStats {
+	stat := pool.Stat()
+	return PoolStats{
+		MaxConns:          stat.MaxConns(),
+		TotalConns:        stat.TotalConns(),
+		AcquiredConns:     stat.AcquiredConns(),
+		IdleConns:         stat.IdleConns(),
+		AcquireCount:      stat.AcquireCount(),
+		EmptyAcquireCount: stat.EmptyAcquireCount(),
+		AcquireDuration:   stat.AcquireDuration(),
+	}
+}
+
+func (srv *Server) runtimeStatsHandler(w http.ResponseWriter, r *http.Request) {
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+
+	stats := RuntimeStats{
+		Goroutines:     runtime.NumGoroutine(),
+		GOMAXPROCS:     runtime.GOMAXPROCS(0),
+		HeapAllocBytes: memStats.HeapAlloc,
+		SysBytes:       memStats.Sys,
+		NumGC:          memStats.NumGC,
+		Primary:        poolStats(srv.db.Pool),
+	}
+	if srv.db.Replica != nil {
+		replica := poolStats(srv.db.Replica)
+		stats.Replica, stats.ReplicaHealthy = &replica, srv.db.ReplicaHealthy()
+	}
+
+	// windows kept in Redis are not counted here
+	if limiter, ok := srv.limiter.(*memoryLimiter); ok {
+		limiter.mu.Lock()
+		stats.RateLimitWindows = len(limiter.windows)
+		limiter.mu.Unlock()
+	}
+	stats.Breakers = breaker.All()
+	stats.InFlightRequests, stats.DBWaiters, stats.ShedRequests = srv.shedder.inFlight.Load(), srv.db.Waiting(), srv.shedder.shed.Load()
+
+	fmt.Fprint(w, convertToJson(stats))
+}
@@ -0,0 +1,145 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"kode_test/internal/schedule"
+	"math"
+	"net/http"
+)
+
+// the dose of a medicine for the user's weight, from the mg per kg rule of the medicine
+type WeightDosing struct {
+	Medicine string  `json:"medicine"`
+	WeightKg float64 `json:"weight_kg"`
+	// the safe range of one dose, the suggested dose is the low end
+	MinMg       float64         `json:"min_mg"`
+	MaxMg       float64         `json:"max_mg"`
+	SuggestedMg schedule.Amount `json:"suggested"`
+}
+
+// nil when the user did not enter a weight
+func (srv *Server) userWeight(ctx context.Context, userID string) (*float64, error) {
+	var weight *float64
+	err := srv.db.QueryRow(ctx, "SELECT weight_kg FROM users WHERE id = $1", userID).Scan(&weight)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+
+	return weight, err
+}
+
+func (rule SafetyRule) weightRule() (schedule.WeightRule, bool) {
+	if rule.MgPerKgMin == nil {
+		return schedule.WeightRule{}, false
+	}
+
+	return schedule.WeightRule{MinMgPerKg: *rule.MgPerKgMin, MaxMgPerKg: rule.MgPerKgMax, CapMg: rule.MaxMgPerDose}, true
+}
+
+// the dosing for the user's weight, ok is false without a weight or a weight rule for the medicine
+func (srv *Server) weightDosing(ctx context.Context, userID string, medicine string) (WeightDosing, bool, error) {
+	rule, found, err := srv.getSafetyRule(medicine)
+	if err != nil || !found {
+		return WeightDosing{}, false, err
+	}
+	weightRule, ok := rule.weightRule()
+	if !ok {
+		return WeightDosing{}, false, nil
+	}
+	weight, err := srv.userWeight(ctx, userID)
+	if err != nil || weight == nil {
+		return WeightDosing{}, false, err
+	}
+
+	dosing := WeightDosing{Medicine: rule.Medicine, WeightKg: *weight}
+	dosing.MinMg, dosing.MaxMg = schedule.WeightDoseRange(weightRule, *weight)
+	dosing.MinMg, dosing.MaxMg = math.Round(dosing.MinMg*10)/10, math.Round(dosing.MaxMg*10)/10
+	dosing.SuggestedMg = schedule.Amount{Value: dosing.MinMg, Unit: "mg"}
+
+	return dosing, true, nil
+}
+
+// a dose outside the range for the user's weight, the rule decides whether it is an error
+func (srv *Server) checkWeightDose(ctx context.Context, rule SafetyRule, s schedule.Schedule) ([]SafetyIssue, error) {
+	if s.Dose == nil || s.UserID == "" {
+		return nil, nil
+	}
+	dosing, ok, err := srv.weightDosing(ctx, s.UserID, s.Medicine)
+	if err != nil || !ok {
+		return nil, err
+	}
+	dose, err := schedule.Convert(*s.Dose, "mg", s.Strength)
+	if err != nil {
+		return []SafetyIssue{rule.issue("mg_per_kg", fmt.Sprintf("the dose of %s can not be checked against its weight based range without a strength in mg", s.Medicine))}, nil
+	}
+
+	if dose.Value > dosing.MaxMg {
+		return []SafetyIssue{rule.issue("mg_per_kg", fmt.Sprintf("a dose of %g mg is above the %g mg of %s for %g kg", dose.Value, dosing.MaxMg, s.Medicine, dosing.WeightKg))}, nil
+	}
+	if dose.Value < dosing.MinMg {
+		return []SafetyIssue{rule.issue("mg_per_kg", fmt.Sprintf("a dose of %g mg is below the %g mg of %s for %g kg", dose.Value, dosing.MinMg, s.Medicine, dosing.WeightKg))}, nil
+	}
+
+	return nil, nil
+}
+
+// the dose helper: what one dose of medicine= is for the user's weight
+func (srv *Server) getDosingHandler(w http.ResponseWriter, r *http.Request) error {
+	userID, err := pathUserID(r)
+	if err != nil {
+		return err
+	}
+	medicine := r.URL.Query().Get("medicine")
+	if medicine == "" {
+		return schedule.Errorf(schedule.ErrValidation, "missing medicine")
+	}
+
+	dosing, ok, err := srv.weightDosing(context.Background(), userID, medicine)
+	if err != nil {
+		return fmt.Errorf("failed get weight dosing from database: %w", err)
+	}
+	if !ok {
+		return schedule.Errorf(schedule.ErrNotFound, "no weight based dosing, the user has no weight or the medicine no mg per kg rule")
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, convertToJson(dosing))
+	return nil
+}
+
+// the weight doses are calculated with, null removes it
+func (srv *Server) putWeightHandler(w http.ResponseWriter, r *http.Request) error {
+	userID, err := pathUserID(r)
+	if err != nil {
+		return err
+	}
+	var body struct {
+		WeightKg *float64 `json:"weight_kg"`
+	}
+	err = json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		return schedule.Errorf(schedule.ErrValidation, "invalid weight format")
+	}
+	if body.WeightKg != nil && *body.WeightKg <= 0 {
+		return schedule.FieldErrorf("weight_kg", "min", "weight_kg must be between 0 and 500")
+	}
+	if body.WeightKg != nil && *body.WeightKg > 500 {
+		return schedule.FieldErrorf("weight_kg", "max", "weight_kg must be between 0 and 500")
+	}
+
+	tag, err := srv.db.Exec(context.Background(), "UPDATE users SET weight_kg = $2 WHERE id = $1", userID, body.WeightKg)
+	if err != nil {
+		return fmt.Errorf("failed save weight: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return schedule.Errorf(schedule.ErrNotFound, "user not found")
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, convertToJson(body))
+	return nil
+}
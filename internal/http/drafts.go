@@ -0,0 +1,32 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"kode_test/internal/schedule"
+	"net/http"
+	"strings"
+)
+
+// longer texts are not prescriptions
+const maxDraftTextLength = 500
+
+// turns free text into a schedule draft, nothing is saved, the app lets the user
+// confirm or complete the draft and submits it to POST /schedule
+func parseScheduleHandler(w http.ResponseWriter, r *http.Request) error {
+	var body struct {
+		Text string `json:"text"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		return schedule.Errorf(schedule.ErrValidation, "invalid parse format")
+	}
+	body.Text = strings.TrimSpace(body.Text)
+	if body.Text == "" || len(body.Text) > maxDraftTextLength {
+		return schedule.Errorf(schedule.ErrValidation, "text is required and at most %d characters", maxDraftTextLength)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, convertToJson(schedule.ParseDraft(body.Text)))
+	return nil
+}
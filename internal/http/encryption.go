@@ -0,0 +1,27 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"kode_test/internal/schedule"
+	"kode_test/internal/storage"
+	"net/http"
+)
+
+// starts encrypting new values with a fresh data key, rewraps the existing data keys
+// with the current master key and re-encrypts stored values in the background
+func (srv *Server) rotateDataKeyHandler(w http.ResponseWriter, r *http.Request) error {
+	if !storage.EncryptionEnabled() {
+		return schedule.Errorf(schedule.ErrConflict, "field encryption is not configured")
+	}
+
+	err := srv.cipher.Rotate(context.Background())
+	if err != nil {
+		return fmt.Errorf("failed rotate data key: %w", err)
+	}
+
+	go srv.cipher.EncryptSchedules(context.Background())
+
+	fmt.Fprintf(w, "data key rotated")
+	return nil
+}
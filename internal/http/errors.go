@@ -0,0 +1,88 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"kode_test/internal/schedule"
+	"log"
+	"net/http"
+)
+
+// a handler that returns its failure instead of writing it
+type errorHandler func(w http.ResponseWriter, r *http.Request) error
+
+// the body of every error response, validation errors list the invalid fields,
+// safety violations their issues and overlaps the schedules they overlap
+type ErrorEnvelope struct {
+	Error     string                `json:"error"`
+	Fields    []schedule.FieldError `json:"fields,omitempty"`
+	Issues    []SafetyIssue         `json:"issues,omitempty"`
+	Conflicts []ScheduleConflict    `json:"conflicts,omitempty"`
+}
+
+// the one place where domain errors become statuses, everything else is logged and answered with 500
+func handleErrors(next errorHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := next(w, r)
+		if err != nil {
+			writeError(w, r, err)
+		}
+	}
+}
+
+// for handlers that answer several methods on one route
+var errMethodNotAllowed = errors.New("method not allowed")
+
+func errorStatus(err error) int {
+	var unsafe *safetyError
+	switch {
+	case errors.As(err, &unsafe):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, schedule.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, schedule.ErrForbidden):
+		return http.StatusForbidden
+	case errors.Is(err, schedule.ErrValidation):
+		return http.StatusBadRequest
+	case errors.Is(err, schedule.ErrConflict):
+		return http.StatusConflict
+	case errors.Is(err, schedule.ErrUnauthorized):
+		return http.StatusUnauthorized
+	case errors.Is(err, schedule.ErrStale):
+		return http.StatusPreconditionFailed
+	case errors.Is(err, schedule.ErrVersionRequired):
+		return http.StatusPreconditionRequired
+	case errors.Is(err, schedule.ErrTooManyAttempts):
+		return http.StatusTooManyRequests
+	case errors.Is(err, errMethodNotAllowed):
+		return http.StatusMethodNotAllowed
+	case errors.Is(err, errMaintenance):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	status := errorStatus(err)
+	message := err.Error()
+	if status == http.StatusInternalServerError {
+		// database errors can carry query details, the client only learns that it failed
+		log.Printf("%s %s: %v", r.Method, r.URL.Path, err)
+		message = "internal server error"
+	}
+
+	envelope := ErrorEnvelope{Error: message, Fields: schedule.Fields(err)}
+	var unsafe *safetyError
+	if errors.As(err, &unsafe) {
+		envelope.Issues = unsafe.issues
+	}
+	var overlap *overlapError
+	if errors.As(err, &overlap) {
+		envelope.Conflicts = overlap.conflicts
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	fmt.Fprint(w, convertToJson(envelope))
+}
@@ -0,0 +1,102 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"kode_test/internal/schedule"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHandleErrors(t *testing.T) {
+	tests := []struct {
+		err         error
+		wantStatus  int
+		wantMessage string
+	}{
+		{schedule.Errorf(schedule.ErrValidation, "invalid regimen format"), http.StatusBadRequest, "invalid regimen format"},
+		{schedule.Errorf(schedule.ErrNotFound, "template not found"), http.StatusNotFound, "template not found"},
+		{schedule.Errorf(schedule.ErrForbidden, "token lacks scope read:sync"), http.StatusForbidden, "token lacks scope read:sync"},
+		{schedule.Errorf(schedule.ErrConflict, "email is already verified"), http.StatusConflict, "email is already verified"},
+		{schedule.Errorf(schedule.ErrUnauthorized, "invalid otp"), http.StatusUnauthorized, "invalid otp"},
+		{schedule.Errorf(schedule.ErrStale, "schedule was changed by someone else"), http.StatusPreconditionFailed, "schedule was changed by someone else"},
+		{schedule.Errorf(schedule.ErrVersionRequired, "missing If-Match header"), http.StatusPreconditionRequired, "missing If-Match header"},
+		{schedule.Errorf(schedule.ErrTooManyAttempts, "too many invalid codes"), http.StatusTooManyRequests, "too many invalid codes"},
+		{errMethodNotAllowed, http.StatusMethodNotAllowed, "method not allowed"},
+		{errMaintenance, http.StatusServiceUnavailable, "the service is in maintenance, only reads are available"},
+		// the kind survives wrapping, anything else stays on the server
+		{fmt.Errorf("failed get regimen from database: %w", schedule.Errorf(schedule.ErrNotFound, "regimen not found")), http.StatusNotFound, "failed get regimen from database: regimen not found"},
+		{fmt.Errorf("failed commit transaction: %w", errors.New("conn closed")), http.StatusInternalServerError, "internal server error"},
+	}
+	for _, test := range tests {
+		handler := handleErrors(func(w http.ResponseWriter, r *http.Request) error { return test.err })
+		recorder := httptest.NewRecorder()
+		handler(recorder, httptest.NewRequest(http.MethodGet, "/v1/regimens", nil))
+
+		var envelope ErrorEnvelope
+		err := json.Unmarshal(recorder.Body.Bytes(), &envelope)
+		if err != nil {
+			t.Fatalf("%v: body %q is no error envelope", test.err, recorder.Body)
+		}
+		if recorder.Code != test.wantStatus || envelope.Error != test.wantMessage {
+			t.Errorf("%v = %d %q, want %d %q", test.err, recorder.Code, envelope.Error, test.wantStatus, test.wantMessage)
+		}
+	}
+}
+
+func TestHandleErrorsListsFields(t *testing.T) {
+	invalid := schedule.JoinFields(
+		schedule.FieldErrorf("frequency", "min", "frequency must be positive"),
+		schedule.FieldErrorf("tags[2]", "max_length", "tag is longer than 40 characters"),
+	)
+	handler := handleErrors(func(w http.ResponseWriter, r *http.Request) error { return invalid })
+	recorder := httptest.NewRecorder()
+	handler(recorder, httptest.NewRequest(http.MethodPost, "/schedule", nil))
+
+	var envelope ErrorEnvelope
+	err := json.Unmarshal(recorder.Body.Bytes(), &envelope)
+	if err != nil || recorder.Code != http.StatusBadRequest {
+		t.Fatalf("%d %q: %v", recorder.Code, recorder.Body, err)
+	}
+	if len(envelope.Fields) != 2 || envelope.Fields[0].Field != "frequency" || envelope.Fields[1].Field != "tags[2]" || envelope.Fields[1].Rule != "max_length" {
+		t.Errorf("fields = %+v", envelope.Fields)
+	}
+
+	// other errors have no fields in their body
+	handler = handleErrors(func(w http.ResponseWriter, r *http.Request) error {
+		return schedule.Errorf(schedule.ErrNotFound, "schedule not found")
+	})
+	recorder = httptest.NewRecorder()
+	handler(recorder, httptest.NewRequest(http.MethodGet, "/schedule", nil))
+	if body := recorder.Body.String(); body != `{"error":"schedule not found"}` {
+		t.Errorf("not found body %s", body)
+	}
+}
+
+func TestHandleErrorsListsIssuesAndConflicts(t *testing.T) {
+	tests := []struct {
+		err           error
+		wantStatus    int
+		wantIssues    int
+		wantConflicts int
+	}{
+		{&safetyError{issues: []SafetyIssue{{Rule: "max_daily_mg", Severity: "block", Message: "over 4000 mg a day"}}}, http.StatusUnprocessableEntity, 1, 0},
+		{&overlapError{conflicts: []ScheduleConflict{{ID: 7, Medicine: "ibuprofen"}, {ID: 9, Medicine: "ibuprofen"}}}, http.StatusConflict, 0, 2},
+	}
+	for _, test := range tests {
+		handler := handleErrors(func(w http.ResponseWriter, r *http.Request) error { return test.err })
+		recorder := httptest.NewRecorder()
+		handler(recorder, httptest.NewRequest(http.MethodPost, "/schedule", nil))
+
+		var envelope ErrorEnvelope
+		err := json.Unmarshal(recorder.Body.Bytes(), &envelope)
+		if err != nil || recorder.Code != test.wantStatus || envelope.Error != test.err.Error() {
+			t.Fatalf("%v = %d %q: %v", test.err, recorder.Code, recorder.Body, err)
+		}
+		if len(envelope.Issues) != test.wantIssues || len(envelope.Conflicts) != test.wantConflicts {
+			t.Errorf("%v: issues %+v, conflicts %+v", test.err, envelope.Issues, envelope.Conflicts)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package http
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// holds a response back so a tag can be computed from its body
+type bufferedResponse struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponse) WriteHeader(status int) {
+	if b.status == 0 {
+		b.status = status
+	}
+}
+
+func (b *bufferedResponse) Write(p []byte) (int, error) {
+	b.WriteHeader(http.StatusOK)
+	return b.body.Write(p)
+}
+
+// gives successful GET answers a weak ETag from their body and answers 304 when the
+// client sends it back in If-None-Match, so clients polling the lists skip unchanged bodies
+func withETag(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			next(w, r)
+			return
+		}
+
+		buffered := &bufferedResponse{ResponseWriter: w}
+		next(buffered, r)
+		if buffered.status == 0 {
+			buffered.status = http.StatusOK
+		}
+		if buffered.status != http.StatusOK {
+			w.WriteHeader(buffered.status)
+			buffered.body.WriteTo(w)
+			return
+		}
+
+		sum := sha256.Sum256(buffered.body.Bytes())
+		etag := `W/"` + hex.EncodeToString(sum[:16]) + `"`
+		w.Header().Set("ETag", etag)
+		if etagMatches(r.Header.Get("If-None-Match"), etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		buffered.body.WriteTo(w)
+	}
+}
+
+// weak comparison, W/"x" and "x" are the same tag
+func etagMatches(header string, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,57 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWithETag(t *testing.T) {
+	body := `[{"id":1}]`
+	handler := withETag(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("missing") != "" {
+			http.Error(w, "schedule not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, body)
+	})
+	serve := func(method string, path string, ifNoneMatch string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, nil)
+		if ifNoneMatch != "" {
+			r.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		recorder := httptest.NewRecorder()
+		handler(recorder, r)
+		return recorder
+	}
+
+	first := serve(http.MethodGet, "/schedules", "")
+	etag := first.Header().Get("ETag")
+	if first.Code != http.StatusOK || first.Body.String() != body || len(etag) != len(`W/""`)+32 || etag[:3] != `W/"` {
+		t.Fatalf("first request = %d %q with ETag %q", first.Code, first.Body, etag)
+	}
+
+	for _, ifNoneMatch := range []string{etag, etag[2:], `"other", ` + etag, "*"} {
+		again := serve(http.MethodGet, "/schedules", ifNoneMatch)
+		if again.Code != http.StatusNotModified || again.Body.Len() != 0 || again.Header().Get("ETag") != etag {
+			t.Errorf("If-None-Match %s = %d %q with ETag %q, want 304 without a body", ifNoneMatch, again.Code, again.Body, again.Header().Get("ETag"))
+		}
+	}
+
+	changed := serve(http.MethodGet, "/schedules", `W/"other"`)
+	if changed.Code != http.StatusOK || changed.Body.String() != body {
+		t.Errorf("other tag = %d %q, want the body", changed.Code, changed.Body)
+	}
+
+	// errors and writes pass through untagged
+	missing := serve(http.MethodGet, "/schedules?missing=1", "*")
+	if missing.Code != http.StatusNotFound || missing.Header().Get("ETag") != "" {
+		t.Errorf("error response = %d with ETag %q", missing.Code, missing.Header().Get("ETag"))
+	}
+	post := serve(http.MethodPost, "/schedules", etag)
+	if post.Code != http.StatusOK || post.Header().Get("ETag") != "" || post.Body.String() != body {
+		t.Errorf("POST = %d %q with ETag %q", post.Code, post.Body, post.Header().Get("ETag"))
+	}
+}
@@ -0,0 +1,157 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"kode_test/internal/schedule"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const maxExternalIDLength = 200
+
+type ExternalScheduleSaved struct {
+	ID         int           `json:"id"`
+	ExternalID string        `json:"external_id"`
+	Version    int           `json:"version"`
+	Created    bool          `json:"created"`
+	Changed    bool          `json:"changed"`
+	Warnings   []SafetyIssue `json:"warnings,omitempty"`
+}
+
+// creates or replaces the user's schedule with the external id, so an EHR or pharmacy
+// integration can push the same prescription again and again. Answers 201 when it was created.
+// A push that changes nothing leaves the schedule and its version alone.
+func (srv *Server) putScheduleByExternalIDHandler(w http.ResponseWriter, r *http.Request) error {
+	externalID := strings.TrimSpace(r.PathValue("id"))
+	if externalID == "" || len(externalID) > maxExternalIDLength {
+		return schedule.Errorf(schedule.ErrValidation, "invalid external id, expected 1 to %d characters", maxExternalIDLength)
+	}
+
+	var s schedule.Schedule
+	err := json.NewDecoder(r.Body).Decode(&s)
+	if err != nil {
+		return schedule.Errorf(schedule.ErrValidation, "invalid schedule format")
+	}
+	if userID := currentUserID(r); userID != "" {
+		s.UserID = userID
+	}
+	if s.Status == "" {
+		s.Status = "active"
+	}
+	var userErr error
+	if s.UserID == "" {
+		userErr = schedule.FieldErrorf("user_id", "required", "user_id is required")
+	}
+	s.ExternalID = externalID
+	err = schedule.JoinFields(userErr, schedule.ValidateSchedule(s), normalizeScheduleDetails(&s))
+	if err != nil {
+		return err
+	}
+
+	// the stored schedule keeps its id and start, and does not overlap itself
+	var existing *schedule.Schedule
+	var id int
+	err = srv.db.QueryRow(context.Background(), "SELECT id FROM schedule WHERE user_id = $1 AND external_id = $2", s.UserID, externalID).Scan(&id)
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		s.CreatedAt = time.Now()
+	case err != nil:
+		return fmt.Errorf("failed get schedule from database: %w", err)
+	default:
+		stored, err := srv.getUserSchedule(context.Background(), s.UserID, id)
+		if err != nil {
+			return fmt.Errorf("failed get schedule from database: %w", err)
+		}
+		existing = &stored
+		s.ID, s.UUID, s.CreatedAt = stored.ID, stored.UUID, stored.CreatedAt
+	}
+
+	if existing != nil && sameScheduleContent(*existing, s) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("ETag", versionETag(existing.Version))
+		fmt.Fprint(w, convertToJson(ExternalScheduleSaved{ID: existing.ID, ExternalID: externalID, Version: existing.Version}))
+		return nil
+	}
+
+	issues, err := srv.checkScheduleSafety(s)
+	err = checkSafety(issues, err)
+	if err != nil {
+		return err
+	}
+	if s.Status == "active" {
+		err = srv.checkOverlap(r, s, s.CreatedAt)
+		if err != nil {
+			return err
+		}
+	}
+
+	medicine, hash, err := srv.cipher.SealMedicine(s.Medicine)
+	if err != nil {
+		return fmt.Errorf("failed encrypt schedule: %w", err)
+	}
+	instructions, err := srv.sealInstructions(s.Instructions)
+	if err != nil {
+		return fmt.Errorf("failed encrypt schedule: %w", err)
+	}
+	prescriber, pharmacy, err := srv.sealContacts(s)
+	if err != nil {
+		return fmt.Errorf("failed encrypt schedule: %w", err)
+	}
+
+	// a concurrent push of a new id ends up in the update instead of a duplicate
+	query := `INSERT INTO schedule (medicine, medicine_hash, frequency, duration, user_id, status, prescriber, pharmacy, tags, color, icon, instructions, pill, dose, strength, external_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)
+		ON CONFLICT (user_id, external_id) WHERE external_id <> '' DO UPDATE SET medicine = excluded.medicine, medicine_hash = excluded.medicine_hash,
+			frequency = excluded.frequency, duration = excluded.duration, status = excluded.status, prescriber = excluded.prescriber, pharmacy = excluded.pharmacy,
+			tags = excluded.tags, color = excluded.color, icon = excluded.icon, instructions = excluded.instructions, pill = excluded.pill, dose = excluded.dose, strength = excluded.strength
+		RETURNING id, version, xmax = 0`
+	saved := ExternalScheduleSaved{ExternalID: externalID, Changed: true, Warnings: issues}
+	ctx := context.Background()
+	err = srv.db.InTx(ctx, func(tx pgx.Tx) error {
+		var err error
+		switch {
+		case s.Status != "active":
+		case existing == nil:
+			err = lockScheduleQuota(ctx, tx, s.UserID, 1)
+		default:
+			err = lockActivation(ctx, tx, s.UserID, []int{existing.ID})
+		}
+		if err != nil {
+			return err
+		}
+
+		return tx.QueryRow(ctx, query, medicine, hash, s.Frequency, s.Duration, s.UserID, s.Status, prescriber, pharmacy, s.Tags, s.Color, s.Icon, instructions, s.Pill, s.Dose, s.Strength, externalID).Scan(&saved.ID, &saved.Version, &saved.Created)
+	})
+	var domainErr *schedule.Error
+	if errors.As(err, &domainErr) {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("failed save schedule in database: %w", err)
+	}
+	s.ID = saved.ID
+	srv.rememberContacts(context.Background(), s)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", versionETag(saved.Version))
+	if saved.Created {
+		w.WriteHeader(http.StatusCreated)
+	}
+	fmt.Fprint(w, convertToJson(saved))
+	return nil
+}
+
+// compares what a push can set, ids, versions and times are left out
+func sameScheduleContent(stored schedule.Schedule, pushed schedule.Schedule) bool {
+	content := func(s schedule.Schedule) string {
+		s.ID, s.UUID, s.Version, s.CreatedAt, s.UpdatedAt, s.Progress = 0, "", 0, time.Time{}, time.Time{}, nil
+		return convertToJson(s)
+	}
+
+	return content(stored) == content(pushed)
+}
@@ -0,0 +1,60 @@
+package http
+
+import (
+	"errors"
+	"kode_test/internal/schedule"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSameScheduleContent(t *testing.T) {
+	stored := schedule.Schedule{
+		ID: 4, UUID: "b7c1", Medicine: "Lisinopril", Frequency: 30, Duration: 1, UserID: "ada", Status: "active", Version: 3,
+		CreatedAt: time.Date(2026, 10, 1, 8, 0, 0, 0, time.UTC), UpdatedAt: time.Date(2026, 10, 2, 8, 0, 0, 0, time.UTC),
+		Progress: &schedule.Progress{Dose: 2, Total: 30}, Tags: []string{"heart"}, ExternalID: "rx-1001",
+	}
+	pushed := schedule.Schedule{Medicine: "Lisinopril", Frequency: 30, Duration: 1, UserID: "ada", Status: "active", Tags: []string{"heart"}, ExternalID: "rx-1001"}
+	if !sameScheduleContent(stored, pushed) {
+		t.Error("a push of the same prescription counts as a change")
+	}
+
+	changes := map[string]func(s *schedule.Schedule){
+		"duration":     func(s *schedule.Schedule) { s.Duration = 2 },
+		"status":       func(s *schedule.Schedule) { s.Status = "paused" },
+		"tags":         func(s *schedule.Schedule) { s.Tags = nil },
+		"instructions": func(s *schedule.Schedule) { s.Instructions = "with food" },
+		"prescriber":   func(s *schedule.Schedule) { s.Prescriber = &schedule.Contact{Name: "Dr. Weber"} },
+	}
+	for name, change := range changes {
+		changed := pushed
+		change(&changed)
+		if sameScheduleContent(stored, changed) {
+			t.Errorf("a push with another %s counts as the same", name)
+		}
+	}
+}
+
+func TestPutScheduleByExternalIDValidation(t *testing.T) {
+	srv := &Server{}
+	tests := []struct {
+		externalID string
+		body       string
+		wantErr    string
+	}{
+		{" ", `{}`, "invalid external id"},
+		{strings.Repeat("x", maxExternalIDLength+1), `{}`, "invalid external id"},
+		{"rx-1001", `{"medicine":`, "invalid schedule format"},
+		{"rx-1001", `{"medicine": "Lisinopril", "frequency": 30, "duration": 1}`, "user_id is required"},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodPut, "/v1/schedules/by-external-id/x", strings.NewReader(test.body))
+		r.SetPathValue("id", test.externalID)
+		err := srv.putScheduleByExternalIDHandler(httptest.NewRecorder(), r)
+		if !errors.Is(err, schedule.ErrValidation) || !strings.Contains(err.Error(), test.wantErr) {
+			t.Errorf("external id %.10q with %s = %v, want %q", test.externalID, test.body, err, test.wantErr)
+		}
+	}
+}
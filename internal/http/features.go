@@ -0,0 +1,154 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"kode_test/internal/features"
+	"kode_test/internal/schedule"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+)
+
+const maxFlagUsers = 1000
+
+type FeatureList struct {
+	Features []string `json:"features"`
+}
+
+// handlers and jobs ask srv.flags.Enabled(name, userID) before taking a path that ships dark,
+// jobs pass no user. The flags of FEATURE_FLAGS apply until an admin stores one of the same name,
+// an invalid configuration is logged and leaves every flag off until an admin sets it
+func newFeatureFlags() *features.Set {
+	configured, err := features.Parse(os.Getenv("FEATURE_FLAGS"))
+	if err != nil {
+		log.Printf("feature flags: %v", err)
+		configured = map[string]features.Flag{}
+	}
+
+	return features.NewSet(configured)
+}
+
+// applies the flags an admin stored, on start and after every change on any instance
+func (srv *Server) loadFeatureFlags(ctx context.Context) error {
+	rows, err := srv.db.Query(ctx, "SELECT name, enabled, percentage, users, description FROM feature_flag")
+	if err != nil {
+		return fmt.Errorf("failed get feature flags from database: %w", err)
+	}
+	stored, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (features.Flag, error) {
+		var flag features.Flag
+		err := row.Scan(&flag.Name, &flag.Enabled, &flag.Percentage, &flag.Users, &flag.Description)
+		return flag, err
+	})
+	if err != nil {
+		return fmt.Errorf("failed get feature flags from database: %w", err)
+	}
+
+	srv.flags.Replace(stored)
+	return nil
+}
+
+func (srv *Server) reloadFeatureFlags(string) {
+	err := srv.loadFeatureFlags(context.Background())
+	if err != nil {
+		log.Printf("feature flags: %v", err)
+	}
+}
+
+// the features that are on for the caller, so clients can show what ships dark only to them
+func (srv *Server) getFeaturesHandler(w http.ResponseWriter, r *http.Request) error {
+	userID, err := requestUserID(r)
+	if err != nil {
+		return err
+	}
+
+	list := FeatureList{Features: []string{}}
+	for _, flag := range srv.flags.All() {
+		if flag.EnabledFor(userID) {
+			list.Features = append(list.Features, flag.Name)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, convertToJson(list))
+	return nil
+}
+
+func (srv *Server) getFeatureFlagsHandler(w http.ResponseWriter, r *http.Request) error {
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, convertToJson(srv.flags.All()))
+	return nil
+}
+
+// stores a flag in place of the configured one, every instance applies it on the notification
+func (srv *Server) putFeatureFlagHandler(w http.ResponseWriter, r *http.Request) error {
+	var flag features.Flag
+	err := json.NewDecoder(r.Body).Decode(&flag)
+	if err != nil {
+		return schedule.Errorf(schedule.ErrValidation, "invalid feature flag format")
+	}
+	flag.Name = r.PathValue("name")
+	flag.Description = strings.TrimSpace(flag.Description)
+	if flag.Users == nil {
+		flag.Users = []string{}
+	}
+	slices.Sort(flag.Users)
+	flag.Users = slices.Compact(flag.Users)
+
+	var errs []error
+	if !features.ValidName(flag.Name) {
+		errs = append(errs, schedule.FieldErrorf("name", "format", "name must be 1 to 64 lower case letters, digits, dots, dashes or underscores"))
+	}
+	if flag.Percentage < 0 {
+		errs = append(errs, schedule.FieldErrorf("percentage", "min", "percentage must be between 0 and 100"))
+	} else if flag.Percentage > 100 {
+		errs = append(errs, schedule.FieldErrorf("percentage", "max", "percentage must be between 0 and 100"))
+	}
+	if len(flag.Users) > maxFlagUsers {
+		errs = append(errs, schedule.FieldErrorf("users", "max_items", "a flag can name at most %d users", maxFlagUsers))
+	}
+	err = schedule.JoinFields(errs...)
+	if err != nil {
+		return err
+	}
+
+	query := `INSERT INTO feature_flag (name, enabled, percentage, users, description) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (name) DO UPDATE SET enabled = excluded.enabled, percentage = excluded.percentage,
+			users = excluded.users, description = excluded.description, updated_at = now()`
+	_, err = srv.db.Exec(r.Context(), query, flag.Name, flag.Enabled, flag.Percentage, flag.Users, flag.Description)
+	if err != nil {
+		return fmt.Errorf("failed save feature flag: %w", err)
+	}
+	srv.announceFeatureFlags(r.Context())
+
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, convertToJson(flag))
+	return nil
+}
+
+// removes the stored flag, a configured flag of the same name applies again
+func (srv *Server) deleteFeatureFlagHandler(w http.ResponseWriter, r *http.Request) error {
+	tag, err := srv.db.Exec(r.Context(), "DELETE FROM feature_flag WHERE name = $1", r.PathValue("name"))
+	if err != nil {
+		return fmt.Errorf("failed delete feature flag: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return schedule.Errorf(schedule.ErrNotFound, "feature flag not found")
+	}
+	srv.announceFeatureFlags(r.Context())
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (srv *Server) announceFeatureFlags(ctx context.Context) {
+	srv.reloadFeatureFlags("")
+	_, err := srv.db.Exec(ctx, "SELECT pg_notify('feature_flags_changed', '')")
+	if err != nil {
+		log.Printf("feature flags: failed notify other instances: %v", err)
+	}
+}
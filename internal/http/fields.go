@@ -0,0 +1,61 @@
+package http
+
+import (
+	"encoding/json"
+	"kode_test/internal/schedule"
+	"net/http"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// the fields= parameter, comma separated JSON names of item's type that the client wants, nil for all of them
+func parseFields(r *http.Request, item interface{}) (map[string]bool, error) {
+	value := r.URL.Query().Get("fields")
+	if value == "" {
+		return nil, nil
+	}
+
+	var known []string
+	for _, column := range tableColumns(reflect.TypeOf(item), "", nil) {
+		name, _, _ := strings.Cut(column.name, ".")
+		if !slices.Contains(known, name) {
+			known = append(known, name)
+		}
+	}
+
+	fields := map[string]bool{}
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if !slices.Contains(known, name) {
+			return nil, schedule.Errorf(schedule.ErrValidation, "unknown field %q, expected some of %s", name, strings.Join(known, ", "))
+		}
+		fields[name] = true
+	}
+
+	return fields, nil
+}
+
+// v with only the top level fields asked for, v itself when fields is nil
+func sparse(v interface{}, fields map[string]bool) interface{} {
+	if fields == nil {
+		return v
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return v
+	}
+	var object map[string]json.RawMessage
+	err = json.Unmarshal(b, &object)
+	if err != nil {
+		return v
+	}
+	for name := range object {
+		if !fields[name] {
+			delete(object, name)
+		}
+	}
+
+	return object
+}
@@ -0,0 +1,64 @@
+package http
+
+import (
+	"errors"
+	"kode_test/internal/schedule"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseFields(t *testing.T) {
+	tests := []struct {
+		fields  string
+		want    int
+		wantErr string
+	}{
+		{"", -1, ""},
+		{"id,name", 2, ""},
+		{" id , dose ", 2, ""},
+		{"dose.unit", 0, `unknown field "dose.unit", expected some of id, name, note, tags, dose, created_at`},
+		{"id,secret", 0, `unknown field "secret", expected some of id, name, note, tags, dose, created_at`},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/schedules", nil)
+		r.URL.RawQuery = "fields=" + test.fields
+		fields, err := parseFields(r, listItem{})
+		if test.wantErr != "" {
+			if !errors.Is(err, schedule.ErrValidation) || err.Error() != test.wantErr {
+				t.Errorf("fields=%s error = %v, want %q", test.fields, err, test.wantErr)
+			}
+			continue
+		}
+		if err != nil || (test.want < 0 && fields != nil) || (test.want >= 0 && len(fields) != test.want) {
+			t.Errorf("fields=%s = %v, %v", test.fields, fields, err)
+		}
+	}
+}
+
+func TestWriteListFields(t *testing.T) {
+	items := []listItem{{ID: 1, Name: "Ibuprofen", Tags: []string{"pain"}, Dose: &listDose{400, "mg"}}}
+
+	tests := []struct {
+		accept string
+		fields string
+		status int
+		want   string
+	}{
+		{"", "id,dose", http.StatusOK, `[{"dose":{"value":400,"unit":"mg"},"id":1}]`},
+		// a nested object keeps all of its columns
+		{"text/csv", "dose,name", http.StatusOK, "name,dose.value,dose.unit\nIbuprofen,400,mg\n"},
+		{"text/plain", "id", http.StatusOK, "id\n1\n"},
+		{"", "color", http.StatusBadRequest, `{"error":"unknown field \"color\", expected some of id, name, note, tags, dose, created_at"}`},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/schedules?fields="+test.fields, nil)
+		r.Header.Set("Accept", test.accept)
+		recorder := httptest.NewRecorder()
+		writeList(recorder, r, items)
+
+		if recorder.Code != test.status || recorder.Body.String() != test.want {
+			t.Errorf("Accept %q fields=%s = %d %s, want %d %s", test.accept, test.fields, recorder.Code, recorder.Body, test.status, test.want)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"kode_test/internal/schedule"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+)
+
+// the opt-in hypermedia format, resources carry _links and lists embed their items
+const halMediaType = "application/hal+json"
+
+type halLink struct {
+	Href string `json:"href"`
+}
+
+// the related resources of v, userID is the requesting user for resources that do not name their owner
+func resourceLinks(v interface{}, userID string) map[string]halLink {
+	owner := func(id string) url.Values {
+		return url.Values{"user_id": {id}}
+	}
+
+	switch v := v.(type) {
+	case schedule.Schedule:
+		query := owner(v.UserID)
+		query.Set("schedule_id", strconv.Itoa(v.ID))
+		return map[string]halLink{
+			"self":    {Href: "/schedule?" + query.Encode()},
+			"intakes": {Href: "/v1/intakes?" + query.Encode()},
+			"owner":   {Href: "/v1/users/" + url.PathEscape(v.UserID)},
+		}
+	case schedule.TakeSchedule:
+		scheduleID, _, _, err := schedule.ParseDoseID(v.DoseID)
+		if err != nil {
+			return nil
+		}
+		query := owner(userID)
+		query.Set("schedule_id", strconv.Itoa(scheduleID))
+		return map[string]halLink{
+			"schedule": {Href: "/schedule?" + query.Encode()},
+			"intakes":  {Href: "/v1/intakes?" + query.Encode()},
+			"owner":    {Href: "/v1/users/" + url.PathEscape(userID)},
+		}
+	case Intake:
+		query := owner(v.UserID)
+		query.Set("schedule_id", strconv.Itoa(v.ScheduleID))
+		return map[string]halLink{
+			"schedule": {Href: "/schedule?" + query.Encode()},
+			"intakes":  {Href: "/v1/intakes?" + query.Encode()},
+			"owner":    {Href: "/v1/users/" + url.PathEscape(v.UserID)},
+		}
+	case User:
+		query := owner(v.ID).Encode()
+		return map[string]halLink{
+			"self":         {Href: "/v1/users/" + url.PathEscape(v.ID)},
+			"schedules":    {Href: "/schedules?" + query},
+			"next_takings": {Href: "/next_takings?" + query},
+			"intakes":      {Href: "/v1/intakes?" + query},
+		}
+	}
+
+	return nil
+}
+
+// v as a HAL object, with only the fields asked for
+func halResource(v interface{}, fields map[string]bool, userID string) interface{} {
+	b, err := json.Marshal(sparse(v, fields))
+	if err != nil {
+		return v
+	}
+	var object map[string]interface{}
+	err = json.Unmarshal(b, &object)
+	if err != nil {
+		return v
+	}
+	if links := resourceLinks(v, userID); links != nil {
+		object["_links"] = links
+	}
+
+	return object
+}
+
+// items, a slice, as a HAL collection linking to itself with the items embedded
+func halCollection(r *http.Request, items interface{}, fields map[string]bool) interface{} {
+	list := reflect.ValueOf(items)
+	userID := r.URL.Query().Get("user_id")
+	embedded := make([]interface{}, list.Len())
+	for i := range embedded {
+		embedded[i] = halResource(list.Index(i).Interface(), fields, userID)
+	}
+
+	return map[string]interface{}{
+		"_links":    map[string]halLink{"self": {Href: r.URL.RequestURI()}},
+		"_embedded": map[string]interface{}{"items": embedded},
+		"count":     len(embedded),
+	}
+}
+
+// like convertToJson, but & in the query strings of links stays readable
+func writeHAL(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", halMediaType)
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(v)
+}
+
+// writes one resource as JSON, or as HAL with its links when the client asks for it
+func writeResource(w http.ResponseWriter, r *http.Request, v interface{}) {
+	fields, err := parseFields(r, v)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	if acceptedListFormat(r) == halMediaType {
+		writeHAL(w, halResource(v, fields, r.URL.Query().Get("user_id")))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, convertToJson(sparse(v, fields)))
+}
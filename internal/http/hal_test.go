@@ -0,0 +1,86 @@
+package http
+
+import (
+	"encoding/json"
+	"kode_test/internal/schedule"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestWriteResourceHAL(t *testing.T) {
+	s := schedule.Schedule{ID: 7, UserID: "ada&bob", Medicine: "Ibuprofen"}
+
+	r := httptest.NewRequest(http.MethodGet, "/schedule?fields=id,medicine", nil)
+	r.Header.Set("Accept", halMediaType)
+	recorder := httptest.NewRecorder()
+	writeResource(recorder, r, s)
+
+	if got := recorder.Header().Get("Content-Type"); got != halMediaType {
+		t.Errorf("Content-Type %q, want %q", got, halMediaType)
+	}
+	var got map[string]interface{}
+	err := json.Unmarshal(recorder.Body.Bytes(), &got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"id":       7.0,
+		"medicine": "Ibuprofen",
+		"_links": map[string]interface{}{
+			"self":    map[string]interface{}{"href": "/schedule?schedule_id=7&user_id=ada%26bob"},
+			"intakes": map[string]interface{}{"href": "/v1/intakes?schedule_id=7&user_id=ada%26bob"},
+			"owner":   map[string]interface{}{"href": "/v1/users/ada&bob"},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("HAL schedule = %v, want %v", got, want)
+	}
+
+	// plain JSON has no links
+	r.Header.Set("Accept", "application/json")
+	recorder = httptest.NewRecorder()
+	writeResource(recorder, r, s)
+	if body := recorder.Body.String(); body != `{"id":7,"medicine":"Ibuprofen"}` {
+		t.Errorf("JSON schedule = %s", body)
+	}
+}
+
+func TestWriteListHAL(t *testing.T) {
+	takings := []schedule.TakeSchedule{
+		{DoseID: "7-20261016-2", Medicine: "Ibuprofen", TakeTime: "15:00"},
+		{DoseID: "malformed", Medicine: "Vitamin D", TakeTime: "15:30"},
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/next_takings?user_id=ada&fields=dose_id", nil)
+	r.Header.Set("Accept", "application/json;q=0.5, "+halMediaType)
+	recorder := httptest.NewRecorder()
+	writeList(recorder, r, takings)
+
+	var got struct {
+		Links    map[string]halLink `json:"_links"`
+		Embedded struct {
+			Items []map[string]interface{} `json:"items"`
+		} `json:"_embedded"`
+		Count int `json:"count"`
+	}
+	err := json.Unmarshal(recorder.Body.Bytes(), &got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Links["self"].Href != "/next_takings?user_id=ada&fields=dose_id" || got.Count != 2 || len(got.Embedded.Items) != 2 {
+		t.Fatalf("HAL collection = %s", recorder.Body)
+	}
+
+	first, second := got.Embedded.Items[0], got.Embedded.Items[1]
+	links, _ := first["_links"].(map[string]interface{})
+	scheduleLink, _ := links["schedule"].(map[string]interface{})
+	if first["dose_id"] != "7-20261016-2" || first["medicine"] != nil || scheduleLink["href"] != "/schedule?schedule_id=7&user_id=ada" {
+		t.Errorf("first item = %v", first)
+	}
+	// an item without links is still embedded
+	if _, ok := second["_links"]; ok || second["dose_id"] != "malformed" {
+		t.Errorf("second item = %v", second)
+	}
+}
@@ -0,0 +1,31 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"github.com/jackc/pgx/v5"
+	"kode_test/internal/schedule"
+	"regexp"
+	"strconv"
+)
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// while clients move to UUIDs a schedule can be referenced by its integer id or its uuid,
+// unknown references come back as schedule.ErrNotFound
+func (srv *Server) ResolveScheduleID(ctx context.Context, ref string) (int, error) {
+	if id, err := strconv.Atoi(ref); err == nil {
+		return id, nil
+	}
+	if !uuidPattern.MatchString(ref) {
+		return 0, schedule.Errorf(schedule.ErrNotFound, "schedule not found")
+	}
+
+	var id int
+	err := srv.db.QueryRow(ctx, "SELECT id FROM schedule WHERE uuid = $1::uuid", ref).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, schedule.Errorf(schedule.ErrNotFound, "schedule not found")
+	}
+
+	return id, err
+}
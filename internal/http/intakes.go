@@ -0,0 +1,551 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"kode_test/internal/schedule"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+const (
+	// an intake logged this long after it was taken is backfilled
+	backfillAfter = 15 * time.Minute
+	// INTAKE_BACKFILL_HOURS overrides how far back intakes can be logged or moved
+	defaultBackfillHours = 72
+	// INTAKE_UNDO_MINUTES overrides how long after logging an intake can be removed again
+	defaultUndoMinutes = 10
+	// the longest window of doses confirmed in one call
+	maxConfirmWindow = 24 * time.Hour
+)
+
+type Intake struct {
+	ID         int       `json:"id"`
+	UUID       string    `json:"uuid,omitempty"`
+	ScheduleID int       `json:"schedule_id"`
+	DoseID     string    `json:"dose_id,omitempty"`
+	UserID     string    `json:"user_id"`
+	TakenAt    time.Time `json:"taken_at"`
+	// logged afterwards or moved, the time is what the user remembered
+	Backfilled bool      `json:"backfilled"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// the fields of an intake that can be corrected
+type IntakeChange struct {
+	TakenAt *time.Time `json:"taken_at"`
+}
+
+// one entry of the audit trail of an intake, before and after are the intake as JSON
+type IntakeAudit struct {
+	ID       int64           `json:"id"`
+	IntakeID int             `json:"intake_id"`
+	Action   string          `json:"action"`
+	Actor    string          `json:"actor"`
+	Before   json.RawMessage `json:"before,omitempty"`
+	After    json.RawMessage `json:"after,omitempty"`
+	At       time.Time       `json:"at"`
+}
+
+// confirms every planned dose from From to To, at their planned times unless TakenAt is given
+type ConfirmWindowRequest struct {
+	UserID  string     `json:"user_id"`
+	From    time.Time  `json:"from"`
+	To      time.Time  `json:"to"`
+	TakenAt *time.Time `json:"taken_at,omitempty"`
+}
+
+type ConfirmedDose struct {
+	DoseID     string    `json:"dose_id"`
+	ScheduleID int       `json:"schedule_id"`
+	Medicine   string    `json:"medicine"`
+	PlannedAt  time.Time `json:"planned_at"`
+	// confirmed, already_confirmed, skipped or unsafe
+	Status   string        `json:"status"`
+	IntakeID int           `json:"intake_id,omitempty"`
+	Warnings []SafetyIssue `json:"warnings,omitempty"`
+}
+
+var errUnsafeIntake = errors.New("intake violates medicine safety rules")
+
+func backfillWindow() time.Duration {
+	return time.Duration(envLimit("INTAKE_BACKFILL_HOURS", defaultBackfillHours)) * time.Hour
+}
+
+// intakes are taken at most backfillWindow ago and not in the future
+func checkTakenAt(takenAt time.Time) error {
+	now := time.Now()
+	if takenAt.After(now.Add(time.Minute)) {
+		return schedule.FieldErrorf("taken_at", "max", "taken_at is in the future")
+	}
+	if window := backfillWindow(); now.Sub(takenAt) > window {
+		return schedule.FieldErrorf("taken_at", "min", "intakes can be logged at most %d hours back", int(window.Hours()))
+	}
+
+	return nil
+}
+
+// an intake can name the planned dose it confirms with a dose_id from next_takings,
+// the schedule is then taken from the dose and each dose can only be confirmed once
+func (srv *Server) createIntakeHandler(w http.ResponseWriter, r *http.Request) error {
+	var intake Intake
+	err := json.NewDecoder(r.Body).Decode(&intake)
+	if err != nil {
+		return schedule.Errorf(schedule.ErrValidation, "invalid intake format")
+	}
+	if userID := currentUserID(r); userID != "" {
+		intake.UserID = userID
+	}
+
+	slot := 0
+	if intake.DoseID != "" {
+		var scheduleID int
+		scheduleID, _, slot, err = schedule.ParseDoseID(intake.DoseID)
+		if err != nil {
+			return err
+		}
+		if intake.ScheduleID != 0 && intake.ScheduleID != scheduleID {
+			return schedule.Errorf(schedule.ErrValidation, "dose_id belongs to another schedule")
+		}
+		intake.ScheduleID = scheduleID
+	}
+
+	if intake.ScheduleID == 0 || intake.UserID == "" {
+		return schedule.Errorf(schedule.ErrValidation, "schedule_id and user_id are required")
+	}
+	if intake.TakenAt.IsZero() {
+		intake.TakenAt = time.Now()
+	}
+	err = checkTakenAt(intake.TakenAt)
+	if err != nil {
+		return err
+	}
+
+	intakeID, issues, err := srv.recordIntake(context.Background(), intake, slot)
+	if errors.Is(err, errUnsafeIntake) {
+		return checkSafety(issues, nil)
+	}
+	var domainErr *schedule.Error
+	if errors.As(err, &domainErr) {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("error adding data to database: %w", err)
+	}
+
+	writeSaved(w, "intake", intakeID, issues)
+	return nil
+}
+
+// saves an intake, confirming the dose in slot when it has a dose_id. Safety errors
+// are returned as errUnsafeIntake together with the issues. An intake taken a while ago
+// is backfilled and goes into the audit trail.
+func (srv *Server) recordIntake(ctx context.Context, intake Intake, slot int) (int, []SafetyIssue, error) {
+	intake.Backfilled = time.Since(intake.TakenAt) > backfillAfter
+	// the schedule row is locked so concurrent intakes are checked against each other
+	var intakeID int
+	var issues []SafetyIssue
+	err := srv.db.InTx(ctx, func(tx pgx.Tx) error {
+		var medicine string
+		var duration int
+		query := "SELECT medicine, duration FROM schedule WHERE id = $1 AND user_id = $2 FOR UPDATE"
+		err := tx.QueryRow(ctx, query, intake.ScheduleID, intake.UserID).Scan(&medicine, &duration)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return schedule.Errorf(schedule.ErrNotFound, "schedule not found")
+		}
+		if err != nil {
+			return err
+		}
+		medicine, err = srv.cipher.Decrypt(medicine)
+		if err != nil {
+			return err
+		}
+
+		if intake.DoseID != "" {
+			if slot > duration {
+				return schedule.Errorf(schedule.ErrValidation, "the schedule has only %d doses a day", duration)
+			}
+			var taken bool
+			query = "SELECT EXISTS (SELECT 1 FROM intake_log WHERE schedule_id = $1 AND dose_id = $2)"
+			err = tx.QueryRow(ctx, query, intake.ScheduleID, intake.DoseID).Scan(&taken)
+			if err != nil {
+				return err
+			}
+			if taken {
+				return schedule.Errorf(schedule.ErrConflict, "dose %s is already confirmed", intake.DoseID)
+			}
+		}
+
+		issues, err = srv.checkIntakeSafety(ctx, tx, intake.UserID, medicine, intake.TakenAt, 0)
+		if err != nil {
+			return err
+		}
+		for _, issue := range issues {
+			if issue.Severity == "error" {
+				return errUnsafeIntake
+			}
+		}
+
+		query = `INSERT INTO intake_log (schedule_id, user_id, taken_at, dose_id, backfilled) VALUES ($1, $2, $3, NULLIF($4, ''), $5) RETURNING id`
+		err = tx.QueryRow(ctx, query, intake.ScheduleID, intake.UserID, intake.TakenAt, intake.DoseID, intake.Backfilled).Scan(&intakeID)
+		if err != nil || !intake.Backfilled {
+			return err
+		}
+		after, err := getIntake(ctx, tx, intakeID, intake.UserID)
+		if err != nil {
+			return err
+		}
+		return auditIntake(ctx, tx, "backfill", intake.UserID, nil, &after)
+	})
+
+	return intakeID, issues, err
+}
+
+func (srv *Server) getIntakesHandler(w http.ResponseWriter, r *http.Request) error {
+	requiredParams := []string{"user_id"}
+	urlParams := r.URL.Query()
+	missingParamMessage := checkRequiredParams(requiredParams, urlParams)
+	if missingParamMessage != "" {
+		return schedule.Errorf(schedule.ErrValidation, "%s", missingParamMessage)
+	}
+
+	updatedSince, err := parseUpdatedSince(urlParams)
+	if err != nil {
+		return err
+	}
+
+	// schedule_id narrows the log to one schedule
+	query := "SELECT " + intakeColumns + " FROM intake_log WHERE user_id = $1 AND updated_at > $2 AND $3 IN ('', schedule_id::text) ORDER BY taken_at DESC"
+	rows, err := srv.db.Query(context.Background(), query, urlParams.Get("user_id"), updatedSince, urlParams.Get("schedule_id"))
+	if err != nil {
+		return fmt.Errorf("failed get intakes from database: %w", err)
+	}
+	defer rows.Close()
+
+	intakes := []Intake{}
+	for rows.Next() {
+		var intake Intake
+		err := scanIntake(rows, &intake)
+		if err != nil {
+			return fmt.Errorf("failed get intake: %w", err)
+		}
+		intakes = append(intakes, intake)
+	}
+
+	writeList(w, r, intakes)
+	return nil
+}
+
+const intakeColumns = "id, uuid::text, schedule_id, COALESCE(dose_id, ''), user_id, taken_at, backfilled, created_at, updated_at"
+
+func scanIntake(row pgx.Row, intake *Intake) error {
+	return row.Scan(&intake.ID, &intake.UUID, &intake.ScheduleID, &intake.DoseID, &intake.UserID, &intake.TakenAt, &intake.Backfilled, &intake.CreatedAt, &intake.UpdatedAt)
+}
+
+// the intake of the user, locked for the rest of the transaction
+func getIntake(ctx context.Context, tx pgx.Tx, id int, userID string) (Intake, error) {
+	var intake Intake
+	err := scanIntake(tx.QueryRow(ctx, "SELECT "+intakeColumns+" FROM intake_log WHERE id = $1 AND user_id = $2 FOR UPDATE", id, userID), &intake)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return intake, schedule.Errorf(schedule.ErrNotFound, "intake not found")
+	}
+
+	return intake, err
+}
+
+func auditIntake(ctx context.Context, tx pgx.Tx, action string, actor string, before *Intake, after *Intake) error {
+	intake := after
+	if intake == nil {
+		intake = before
+	}
+	query := "INSERT INTO intake_audit (intake_id, user_id, action, actor, before, after) VALUES ($1, $2, $3, $4, $5, $6)"
+	_, err := tx.Exec(ctx, query, intake.ID, intake.UserID, action, actor, before, after)
+
+	return err
+}
+
+// moves an intake the user logged at the wrong time, within the backfill window.
+// The intake becomes backfilled and the change goes into the audit trail.
+func (srv *Server) updateIntakeHandler(w http.ResponseWriter, r *http.Request) error {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		return schedule.Errorf(schedule.ErrNotFound, "intake not found")
+	}
+	userID, err := requestUserID(r)
+	if err != nil {
+		return err
+	}
+	var change IntakeChange
+	err = json.NewDecoder(r.Body).Decode(&change)
+	if err != nil || change.TakenAt == nil {
+		return schedule.Errorf(schedule.ErrValidation, "invalid intake change, expected taken_at")
+	}
+	err = checkTakenAt(*change.TakenAt)
+	if err != nil {
+		return err
+	}
+
+	ctx := r.Context()
+	var updated Intake
+	var issues []SafetyIssue
+	err = srv.db.InTx(ctx, func(tx pgx.Tx) error {
+		before, err := getIntake(ctx, tx, id, userID)
+		if err != nil {
+			return err
+		}
+		if time.Since(before.CreatedAt) > backfillWindow() {
+			return schedule.Errorf(schedule.ErrConflict, "intakes can only be changed within %d hours of logging", int(backfillWindow().Hours()))
+		}
+
+		// the new time is checked like a new intake, under the same schedule lock as recordIntake
+		var medicine string
+		err = tx.QueryRow(ctx, "SELECT medicine FROM schedule WHERE id = $1 FOR UPDATE", before.ScheduleID).Scan(&medicine)
+		if err != nil {
+			return err
+		}
+		medicine, err = srv.cipher.Decrypt(medicine)
+		if err != nil {
+			return err
+		}
+		issues, err = srv.checkIntakeSafety(ctx, tx, userID, medicine, *change.TakenAt, id)
+		if err != nil {
+			return err
+		}
+		for _, issue := range issues {
+			if issue.Severity == "error" {
+				return errUnsafeIntake
+			}
+		}
+
+		// taken_at is part of the partition key, the row may move to another partition
+		_, err = tx.Exec(ctx, "UPDATE intake_log SET taken_at = $2, backfilled = true WHERE id = $1 AND taken_at = $3", id, *change.TakenAt, before.TakenAt)
+		if err != nil {
+			return err
+		}
+		updated, err = getIntake(ctx, tx, id, userID)
+		if err != nil {
+			return err
+		}
+		return auditIntake(ctx, tx, "update", auditActor(r, userID), &before, &updated)
+	})
+	if errors.Is(err, errUnsafeIntake) {
+		return checkSafety(issues, nil)
+	}
+	if err != nil {
+		var domainErr *schedule.Error
+		if errors.As(err, &domainErr) {
+			return err
+		}
+		return fmt.Errorf("failed update intake: %w", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, convertToJson(updated))
+	return nil
+}
+
+// the signed in user, or the user of the request when it is made without a session
+func auditActor(r *http.Request, userID string) string {
+	if actor := currentUserID(r); actor != "" {
+		return actor
+	}
+
+	return userID
+}
+
+func (srv *Server) getIntakeAuditHandler(w http.ResponseWriter, r *http.Request) error {
+	userID, err := requestUserID(r)
+	if err != nil {
+		return err
+	}
+
+	query := `SELECT id, intake_id, action, actor, before, after, at FROM intake_audit
+		WHERE intake_id::text = $1 AND user_id = $2 ORDER BY id`
+	rows, err := srv.db.Query(r.Context(), query, r.PathValue("id"), userID)
+	if err != nil {
+		return fmt.Errorf("failed get intake audit from database: %w", err)
+	}
+	entries, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (IntakeAudit, error) {
+		var entry IntakeAudit
+		err := row.Scan(&entry.ID, &entry.IntakeID, &entry.Action, &entry.Actor, &entry.Before, &entry.After, &entry.At)
+		return entry, err
+	})
+	if err != nil {
+		return fmt.Errorf("failed get intake audit from database: %w", err)
+	}
+
+	writeList(w, r, entries)
+	return nil
+}
+
+// removes an intake logged by mistake, only shortly after it was logged so the adherence
+// record can not be rewritten later. The removed intake stays in the audit trail.
+func (srv *Server) deleteIntakeHandler(w http.ResponseWriter, r *http.Request) error {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		return schedule.Errorf(schedule.ErrNotFound, "intake not found")
+	}
+	userID, err := requestUserID(r)
+	if err != nil {
+		return err
+	}
+
+	ctx := r.Context()
+	undo := time.Duration(envLimit("INTAKE_UNDO_MINUTES", defaultUndoMinutes)) * time.Minute
+	err = srv.db.InTx(ctx, func(tx pgx.Tx) error {
+		before, err := getIntake(ctx, tx, id, userID)
+		if err != nil {
+			return err
+		}
+		if time.Since(before.CreatedAt) > undo {
+			return schedule.Errorf(schedule.ErrConflict, "intakes can only be removed within %d minutes of logging", int(undo.Minutes()))
+		}
+
+		_, err = tx.Exec(ctx, "DELETE FROM intake_log WHERE id = $1 AND taken_at = $2", id, before.TakenAt)
+		if err != nil {
+			return err
+		}
+		return auditIntake(ctx, tx, "delete", auditActor(r, userID), &before, nil)
+	})
+	if err != nil {
+		var domainErr *schedule.Error
+		if errors.As(err, &domainErr) {
+			return err
+		}
+		return fmt.Errorf("failed delete intake: %w", err)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// "took everything this morning": confirms the open doses of the active schedules planned within
+// the window, each on its own so one unsafe dose does not hold back the others. Answers the
+// planned doses of the window and what became of each.
+func (srv *Server) confirmWindowHandler(w http.ResponseWriter, r *http.Request) error {
+	var body ConfirmWindowRequest
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		return schedule.Errorf(schedule.ErrValidation, "invalid confirmation format")
+	}
+	if userID := currentUserID(r); userID != "" {
+		body.UserID = userID
+	}
+	if body.UserID == "" || body.From.IsZero() || body.To.IsZero() {
+		return schedule.Errorf(schedule.ErrValidation, "user_id, from and to are required")
+	}
+	if !body.From.Before(body.To) || body.To.Sub(body.From) > maxConfirmWindow {
+		return schedule.Errorf(schedule.ErrValidation, "from must be before to and at most %d hours apart", int(maxConfirmWindow.Hours()))
+	}
+	for _, at := range []time.Time{body.From, body.To} {
+		err = checkTakenAt(at)
+		if err != nil {
+			return err
+		}
+	}
+	if body.TakenAt != nil {
+		err = checkTakenAt(*body.TakenAt)
+		if err != nil {
+			return err
+		}
+	}
+
+	ctx := r.Context()
+	doses, err := srv.plannedDoses(ctx, body.UserID, body.From, body.To)
+	if err != nil {
+		return err
+	}
+	var skipped []string
+	if len(doses) > 0 {
+		ids := make([]string, len(doses))
+		for i, dose := range doses {
+			ids[i] = dose.doseID
+		}
+		rows, err := srv.db.Query(ctx, "SELECT dose_id FROM dose_skip WHERE user_id = $1 AND dose_id = ANY($2)", body.UserID, ids)
+		if err != nil {
+			return fmt.Errorf("failed get skipped doses from database: %w", err)
+		}
+		skipped, err = pgx.CollectRows(rows, pgx.RowTo[string])
+		if err != nil {
+			return fmt.Errorf("failed get skipped doses from database: %w", err)
+		}
+	}
+
+	results := make([]ConfirmedDose, 0, len(doses))
+	for _, dose := range doses {
+		result := ConfirmedDose{DoseID: dose.doseID, ScheduleID: dose.scheduleID, Medicine: dose.medicine, PlannedAt: dose.at, Status: "confirmed"}
+		if slices.Contains(skipped, dose.doseID) {
+			result.Status = "skipped"
+			results = append(results, result)
+			continue
+		}
+
+		intake := Intake{ScheduleID: dose.scheduleID, DoseID: dose.doseID, UserID: body.UserID, TakenAt: dose.at}
+		if body.TakenAt != nil {
+			intake.TakenAt = *body.TakenAt
+		}
+		intakeID, issues, err := srv.recordIntake(ctx, intake, dose.slot)
+		result.IntakeID, result.Warnings = intakeID, issues
+		switch {
+		case errors.Is(err, errUnsafeIntake):
+			result.Status = "unsafe"
+		case errors.Is(err, schedule.ErrConflict):
+			result.Status, result.Warnings = "already_confirmed", nil
+		case err != nil:
+			return fmt.Errorf("error adding data to database: %w", err)
+		}
+		results = append(results, result)
+	}
+
+	writeList(w, r, results)
+	return nil
+}
+
+// the doses of the user's active schedules planned from from until to, in planned order
+func (srv *Server) plannedDoses(ctx context.Context, userID string, from time.Time, to time.Time) ([]plannedDose, error) {
+	var timezone string
+	err := srv.db.QueryRow(ctx, "SELECT timezone FROM users WHERE id = $1", userID).Scan(&timezone)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("failed get user timezone: %w", err)
+	}
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	schedules, err := srv.ListUserSchedules(ctx, userID, "active", time.Time{}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed get schedules from database: %w", err)
+	}
+	plan, err := srv.userDayPlan(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var doses []plannedDose
+	for _, s := range schedules {
+		// the window spans at most two days, a trip can move a day's doses across midnight
+		for day := schedule.LocalDate(from, loc).AddDate(0, 0, -1); !day.After(schedule.LocalDate(to, loc)); day = day.AddDate(0, 0, 1) {
+			noon := time.Date(day.Year(), day.Month(), day.Day(), 12, 0, 0, 0, loc)
+			local, doseTimes := plan.doseTimes(s, noon, loc)
+			if !schedule.CheckDay(s, local, local.Location()) {
+				continue
+			}
+			date := schedule.LocalDate(local, local.Location())
+			for i, at := range doseTimes {
+				if at.Before(from) || at.After(to) {
+					continue
+				}
+				doses = append(doses, plannedDose{doseID: schedule.DoseID(s.ID, date, i+1), scheduleID: s.ID, slot: i + 1, medicine: s.Medicine, at: at})
+			}
+		}
+	}
+	slices.SortStableFunc(doses, func(a, b plannedDose) int { return a.at.Compare(b.at) })
+
+	return doses, nil
+}
@@ -0,0 +1,39 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"kode_test/internal/schedule"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConfirmWindowValidation(t *testing.T) {
+	t.Setenv("INTAKE_BACKFILL_HOURS", "")
+	srv := &Server{}
+	now := time.Now().UTC()
+	at := func(d time.Duration) string { return now.Add(d).Format(time.RFC3339) }
+	tests := []struct {
+		body    string
+		wantErr string
+	}{
+		{`{"user_id": "ada", "from":`, "invalid confirmation format"},
+		{fmt.Sprintf(`{"from": %q, "to": %q}`, at(-time.Hour), at(0)), "user_id, from and to are required"},
+		{fmt.Sprintf(`{"user_id": "ada", "to": %q}`, at(0)), "user_id, from and to are required"},
+		{fmt.Sprintf(`{"user_id": "ada", "from": %q, "to": %q}`, at(0), at(-time.Hour)), "from must be before to"},
+		{fmt.Sprintf(`{"user_id": "ada", "from": %q, "to": %q}`, at(-25*time.Hour), at(0)), "at most 24 hours apart"},
+		{fmt.Sprintf(`{"user_id": "ada", "from": %q, "to": %q}`, at(time.Hour), at(2*time.Hour)), "taken_at is in the future"},
+		{fmt.Sprintf(`{"user_id": "ada", "from": %q, "to": %q}`, at(-100*time.Hour), at(-90*time.Hour)), "at most 72 hours back"},
+		{fmt.Sprintf(`{"user_id": "ada", "from": %q, "to": %q, "taken_at": %q}`, at(-2*time.Hour), at(-time.Hour), at(time.Hour)), "taken_at is in the future"},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/v1/intakes/confirm-window", strings.NewReader(test.body))
+		err := srv.confirmWindowHandler(httptest.NewRecorder(), r)
+		if !errors.Is(err, schedule.ErrValidation) || !strings.Contains(err.Error(), test.wantErr) {
+			t.Errorf("confirm %s = %v, want %q", test.body, err, test.wantErr)
+		}
+	}
+}
@@ -0,0 +1,875 @@
+//go:build integration
+
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/modules/postgres"
+	"github.com/testcontainers/testcontainers-go/wait"
+	"io"
+	"kode_test/internal/features"
+	"kode_test/internal/notify"
+	"kode_test/internal/schedule"
+	"kode_test/internal/storage"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// the suite runs against a throwaway Postgres: go test -tags integration ./...
+// Docker must be reachable, the image can be changed with INTEGRATION_POSTGRES_IMAGE.
+var server *httptest.Server
+
+// the server behind it, for tests of jobs that have no route
+var testServer *Server
+
+func TestMain(m *testing.M) {
+	os.Exit(runIntegration(m))
+}
+
+func runIntegration(m *testing.M) int {
+	ctx := context.Background()
+	image := os.Getenv("INTEGRATION_POSTGRES_IMAGE")
+	if image == "" {
+		image = "postgres:16-alpine"
+	}
+
+	container, err := postgres.Run(ctx, image,
+		postgres.WithDatabase("scheduler"),
+		postgres.WithUsername("scheduler"),
+		postgres.WithPassword("scheduler"),
+		testcontainers.WithWaitStrategy(wait.ForLog("database system is ready to accept connections").WithOccurrence(2).WithStartupTimeout(time.Minute)),
+	)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed start postgres: %v\n", err)
+		return 1
+	}
+	defer container.Terminate(ctx)
+
+	connString, err := container.ConnectionString(ctx, "sslmode=disable")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed get connection string: %v\n", err)
+		return 1
+	}
+	os.Setenv("DATABASE_URL", connString)
+	os.Setenv("ADMIN_TOKEN", "integration-admin-token")
+	os.Setenv("JWT_SECRET", "integration-jwt-secret")
+	// most tests act as a user through the user_id parameter, TestScopesRequireCredentials turns it off
+	os.Setenv("LEGACY_USER_ID_PARAM", "true")
+
+	db, err := storage.Open()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed open database: %v\n", err)
+		return 1
+	}
+	defer db.Close()
+
+	_, err = db.Migrate(ctx, "")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed apply migrations: %v\n", err)
+		return 1
+	}
+
+	srv, err := NewServer(db, storage.NewCipher(db), notify.Log{}, nil, nil, nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed create server: %v\n", err)
+		return 1
+	}
+	testServer = srv
+	server = httptest.NewServer(srv.Handler())
+	defer server.Close()
+
+	return m.Run()
+}
+
+func request(t *testing.T, method string, path string, params url.Values, body interface{}) (int, string) {
+	t.Helper()
+
+	token := ""
+	if strings.Contains(path, "/admin/") {
+		token = os.Getenv("ADMIN_TOKEN")
+	}
+	return requestWithToken(t, method, path, params, body, token)
+}
+
+// the request with the bearer token, without credentials when it is empty
+func requestWithToken(t *testing.T, method string, path string, params url.Values, body interface{}, token string) (int, string) {
+	t.Helper()
+
+	var reader io.Reader
+	if body != nil {
+		reader = strings.NewReader(convertToJson(body))
+	}
+	target := server.URL + path
+	if params != nil {
+		target += "?" + params.Encode()
+	}
+	req, err := http.NewRequest(method, target, reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return resp.StatusCode, string(b)
+}
+
+func expectStatus(t *testing.T, got int, body string, want int) {
+	t.Helper()
+	if got != want {
+		t.Fatalf("status %d, want %d: %s", got, want, body)
+	}
+}
+
+func createTestUser(t *testing.T) string {
+	t.Helper()
+
+	credentials := Credentials{Email: fmt.Sprintf("%s-%d@example.com", strings.ToLower(t.Name()), time.Now().UnixNano()), Password: "integration"}
+	status, body := request(t, http.MethodPost, "/v1/auth/signup", nil, credentials)
+	expectStatus(t, status, body, http.StatusCreated)
+
+	var created map[string]string
+	err := json.Unmarshal([]byte(body), &created)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return created["id"]
+}
+
+func createTestSchedule(t *testing.T, userID string, s schedule.Schedule) int {
+	t.Helper()
+
+	s.UserID = userID
+	status, body := request(t, http.MethodPost, "/schedule", nil, s)
+	expectStatus(t, status, body, http.StatusOK)
+
+	var id int
+	_, err := fmt.Sscanf(body, "schedule saved with ID: %d", &id)
+	if err != nil {
+		t.Fatalf("unexpected create response %q", body)
+	}
+
+	return id
+}
+
+// a fixed offset zone where it is noon now, so the next takings of an hourly schedule are not empty
+func noonZone() string {
+	offset := 12 - time.Now().UTC().Hour()
+	if offset < -11 {
+		offset += 24
+	}
+	if offset > 12 {
+		offset -= 24
+	}
+	// Etc zones have the sign inverted
+	return fmt.Sprintf("Etc/GMT%+d", -offset)
+}
+
+func TestScheduleLifecycle(t *testing.T) {
+	userID := createTestUser(t)
+	scheduleID := createTestSchedule(t, userID, schedule.Schedule{Medicine: "Amoxicillin", Frequency: 7, Duration: 15})
+
+	status, body := request(t, http.MethodGet, "/schedule", url.Values{"user_id": {userID}, "schedule_id": {fmt.Sprint(scheduleID)}}, nil)
+	expectStatus(t, status, body, http.StatusOK)
+	var created schedule.Schedule
+	err := json.Unmarshal([]byte(body), &created)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.Medicine != "Amoxicillin" || created.Status != "active" || created.Version != 1 {
+		t.Fatalf("unexpected schedule %+v", created)
+	}
+
+	status, body = request(t, http.MethodGet, "/schedules", url.Values{"user_id": {userID}}, nil)
+	expectStatus(t, status, body, http.StatusOK)
+	if !strings.Contains(body, `"medicine":"Amoxicillin"`) {
+		t.Fatalf("schedule missing from list: %s", body)
+	}
+
+	status, body = request(t, http.MethodGet, "/next_takings", url.Values{"user_id": {userID}, "tz": {noonZone()}}, nil)
+	expectStatus(t, status, body, http.StatusOK)
+	if !strings.Contains(body, `"take_time"`) {
+		t.Fatalf("no next takings: %s", body)
+	}
+
+	status, body = request(t, http.MethodPost, "/v1/intakes", nil, Intake{ScheduleID: scheduleID, UserID: userID})
+	expectStatus(t, status, body, http.StatusOK)
+
+	status, body = request(t, http.MethodGet, "/v1/intakes", url.Values{"user_id": {userID}}, nil)
+	expectStatus(t, status, body, http.StatusOK)
+	var intakes []Intake
+	err = json.Unmarshal([]byte(body), &intakes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(intakes) != 1 || intakes[0].ScheduleID != scheduleID {
+		t.Fatalf("unexpected intakes %+v", intakes)
+	}
+
+	status, body = request(t, http.MethodGet, "/delete", url.Values{"user_id": {userID}, "schedule_id": {fmt.Sprint(scheduleID)}}, nil)
+	expectStatus(t, status, body, http.StatusOK)
+
+	status, body = request(t, http.MethodGet, "/v1/intakes", url.Values{"user_id": {userID}}, nil)
+	expectStatus(t, status, body, http.StatusOK)
+	if strings.TrimSpace(body) != "[]" {
+		t.Fatalf("intakes left after deleting the schedule: %s", body)
+	}
+}
+
+func TestMissingScheduleNotFound(t *testing.T) {
+	userID := createTestUser(t)
+	scheduleID := createTestSchedule(t, userID, schedule.Schedule{Medicine: "Cetirizine", Frequency: 5, Duration: 1})
+	params := url.Values{"user_id": {userID}, "schedule_id": {fmt.Sprint(scheduleID)}}
+
+	status, body := request(t, http.MethodGet, "/delete", params, nil)
+	expectStatus(t, status, body, http.StatusOK)
+
+	status, body = request(t, http.MethodGet, "/schedule", params, nil)
+	expectStatus(t, status, body, http.StatusNotFound)
+	if body != `{"error":"schedule not found"}` {
+		t.Fatalf("unexpected error body %s", body)
+	}
+
+	status, body = request(t, http.MethodGet, "/delete", params, nil)
+	expectStatus(t, status, body, http.StatusNotFound)
+}
+
+func TestConfirmPlannedDose(t *testing.T) {
+	userID := createTestUser(t)
+	scheduleID := createTestSchedule(t, userID, schedule.Schedule{Medicine: "Omeprazole", Frequency: 7, Duration: 15})
+
+	status, body := request(t, http.MethodGet, "/next_takings", url.Values{"user_id": {userID}, "tz": {noonZone()}}, nil)
+	expectStatus(t, status, body, http.StatusOK)
+	var next schedule.TakeSchedule
+	err := json.NewDecoder(strings.NewReader(body)).Decode(&next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(next.DoseID, fmt.Sprintf("%d-", scheduleID)) {
+		t.Fatalf("unexpected dose id in %s", body)
+	}
+
+	status, body = request(t, http.MethodPost, "/v1/intakes", nil, Intake{DoseID: next.DoseID, UserID: userID})
+	expectStatus(t, status, body, http.StatusOK)
+
+	status, body = request(t, http.MethodPost, "/v1/intakes", nil, Intake{DoseID: next.DoseID, UserID: userID})
+	expectStatus(t, status, body, http.StatusConflict)
+}
+
+func TestBackfillIntake(t *testing.T) {
+	userID := createTestUser(t)
+	scheduleID := createTestSchedule(t, userID, schedule.Schedule{Medicine: "Amoxicillin", Frequency: 7, Duration: 3})
+	params := url.Values{"user_id": {userID}}
+
+	status, body := request(t, http.MethodPost, "/v1/intakes", nil, Intake{ScheduleID: scheduleID, UserID: userID, TakenAt: time.Now().Add(-2 * time.Hour)})
+	expectStatus(t, status, body, http.StatusOK)
+	var id int
+	_, err := fmt.Sscanf(body, "intake saved with ID: %d", &id)
+	if err != nil {
+		t.Fatalf("unexpected create response %q", body)
+	}
+
+	status, body = request(t, http.MethodPost, "/v1/intakes", nil, Intake{ScheduleID: scheduleID, UserID: userID, TakenAt: time.Now().AddDate(0, 0, -30)})
+	expectStatus(t, status, body, http.StatusBadRequest)
+
+	path := fmt.Sprintf("/v1/intakes/%d", id)
+	earlier := time.Now().Add(-3 * time.Hour)
+	status, body = request(t, http.MethodPatch, path, params, IntakeChange{TakenAt: &earlier})
+	expectStatus(t, status, body, http.StatusOK)
+	if !strings.Contains(body, `"backfilled":true`) {
+		t.Fatalf("intake not flagged as backfilled: %s", body)
+	}
+
+	status, body = request(t, http.MethodGet, path+"/audit", params, nil)
+	expectStatus(t, status, body, http.StatusOK)
+	if !strings.Contains(body, `"action":"backfill"`) || !strings.Contains(body, `"action":"update"`) {
+		t.Fatalf("expected backfill and update in the audit trail: %s", body)
+	}
+}
+
+func TestMovedIntakeIsCheckedForSafety(t *testing.T) {
+	medicine := fmt.Sprintf("gapmovol-%d", time.Now().UnixNano())
+	status, body := request(t, http.MethodPut, "/v1/admin/medicines/"+medicine+"/safety", nil, map[string]any{"min_gap_hours": 4, "strict": true})
+	expectStatus(t, status, body, http.StatusOK)
+	userID := createTestUser(t)
+	scheduleID := createTestSchedule(t, userID, schedule.Schedule{Medicine: medicine, Frequency: 1, Duration: 2})
+	params := url.Values{"user_id": {userID}}
+
+	status, body = request(t, http.MethodPost, "/v1/intakes", nil, Intake{ScheduleID: scheduleID, UserID: userID, TakenAt: time.Now().Add(-6 * time.Hour)})
+	expectStatus(t, status, body, http.StatusOK)
+	status, body = request(t, http.MethodPost, "/v1/intakes", nil, Intake{ScheduleID: scheduleID, UserID: userID, TakenAt: time.Now().Add(-time.Hour)})
+	expectStatus(t, status, body, http.StatusOK)
+	var id int
+	_, err := fmt.Sscanf(body, "intake saved with ID: %d", &id)
+	if err != nil {
+		t.Fatalf("unexpected create response %q", body)
+	}
+
+	// a small move is not checked against the intake itself
+	path := fmt.Sprintf("/v1/intakes/%d", id)
+	later := time.Now().Add(-30 * time.Minute)
+	status, body = request(t, http.MethodPatch, path, params, IntakeChange{TakenAt: &later})
+	expectStatus(t, status, body, http.StatusOK)
+
+	closer := time.Now().Add(-4 * time.Hour)
+	status, body = request(t, http.MethodPatch, path, params, IntakeChange{TakenAt: &closer})
+	expectStatus(t, status, body, http.StatusUnprocessableEntity)
+	if !strings.Contains(body, `"rule":"min_gap_hours"`) {
+		t.Errorf("moved intake body %s", body)
+	}
+}
+
+func TestUndoIntake(t *testing.T) {
+	userID := createTestUser(t)
+	scheduleID := createTestSchedule(t, userID, schedule.Schedule{Medicine: "Levothyroxine", Frequency: 30, Duration: 1})
+	params := url.Values{"user_id": {userID}}
+
+	status, body := request(t, http.MethodPost, "/v1/intakes", nil, Intake{ScheduleID: scheduleID, UserID: userID})
+	expectStatus(t, status, body, http.StatusOK)
+	var id int
+	_, err := fmt.Sscanf(body, "intake saved with ID: %d", &id)
+	if err != nil {
+		t.Fatalf("unexpected create response %q", body)
+	}
+
+	path := fmt.Sprintf("/v1/intakes/%d", id)
+	status, body = request(t, http.MethodDelete, path, params, nil)
+	expectStatus(t, status, body, http.StatusNoContent)
+	status, body = request(t, http.MethodDelete, path, params, nil)
+	expectStatus(t, status, body, http.StatusNotFound)
+
+	status, body = request(t, http.MethodGet, path+"/audit", params, nil)
+	expectStatus(t, status, body, http.StatusOK)
+	if !strings.Contains(body, `"action":"delete"`) {
+		t.Fatalf("expected the removal in the audit trail: %s", body)
+	}
+}
+
+func TestSearchSchedules(t *testing.T) {
+	userID := createTestUser(t)
+	createTestSchedule(t, userID, schedule.Schedule{Medicine: "Metformin", Frequency: 30, Duration: 2, Tags: []string{"diabetes"}})
+	createTestSchedule(t, userID, schedule.Schedule{Medicine: "Atorvastatin", Frequency: 30, Duration: 1})
+	params := url.Values{"user_id": {userID}}
+
+	for _, q := range []string{"metformin", "metf", "metformn", "diabetes"} {
+		params.Set("q", q)
+		status, body := request(t, http.MethodGet, "/v1/schedules/search", params, nil)
+		expectStatus(t, status, body, http.StatusOK)
+		if !strings.Contains(body, `"medicine":"Metformin"`) || strings.Contains(body, "Atorvastatin") {
+			t.Fatalf("expected only Metformin for %q: %s", q, body)
+		}
+	}
+
+	params.Set("q", "Metformin")
+	params.Set("user_id", createTestUser(t))
+	status, body := request(t, http.MethodGet, "/v1/schedules/search", params, nil)
+	expectStatus(t, status, body, http.StatusOK)
+	if body != "[]" {
+		t.Fatalf("found schedules of another user: %s", body)
+	}
+}
+
+func TestFeatureFlags(t *testing.T) {
+	tester, other := createTestUser(t), createTestUser(t)
+	path := "/v1/admin/flags/recurrence-v2"
+
+	status, body := request(t, http.MethodPut, path, nil, features.Flag{Users: []string{tester}})
+	expectStatus(t, status, body, http.StatusOK)
+	status, body = request(t, http.MethodPut, "/v1/admin/flags/Recurrence", nil, features.Flag{Percentage: 101})
+	expectStatus(t, status, body, http.StatusBadRequest)
+
+	status, body = request(t, http.MethodGet, "/v1/features", url.Values{"user_id": {tester}}, nil)
+	expectStatus(t, status, body, http.StatusOK)
+	if body != `{"features":["recurrence-v2"]}` {
+		t.Fatalf("expected the flag for the listed user: %s", body)
+	}
+	status, body = request(t, http.MethodGet, "/v1/features", url.Values{"user_id": {other}}, nil)
+	expectStatus(t, status, body, http.StatusOK)
+	if body != `{"features":[]}` {
+		t.Fatalf("expected no flags for another user: %s", body)
+	}
+
+	status, body = request(t, http.MethodDelete, path, nil, nil)
+	expectStatus(t, status, body, http.StatusNoContent)
+}
+
+func TestUpdateScheduleRequiresVersion(t *testing.T) {
+	userID := createTestUser(t)
+	scheduleID := createTestSchedule(t, userID, schedule.Schedule{Medicine: "Ibuprofen", Frequency: 5, Duration: 3})
+	path := fmt.Sprintf("/v1/schedules/%d", scheduleID)
+	params := url.Values{"user_id": {userID}}
+	update := schedule.Schedule{Medicine: "Ibuprofen", Frequency: 10, Duration: 3, Status: "active"}
+
+	status, body := request(t, http.MethodPut, path, params, update)
+	expectStatus(t, status, body, http.StatusPreconditionRequired)
+}
+
+func TestQuotaCountsResumes(t *testing.T) {
+	userID := createTestUser(t)
+	status, body := request(t, http.MethodPut, "/v1/admin/users/"+userID+"/quota", nil, map[string]int{"max_active_schedules": 1})
+	expectStatus(t, status, body, http.StatusOK)
+	params := url.Values{"user_id": {userID}}
+
+	paused := createTestSchedule(t, userID, schedule.Schedule{Medicine: "Atorvastatin", Frequency: 24, Duration: 30})
+	status, body = request(t, http.MethodPost, "/v1/schedules/bulk", params, BulkRequest{IDs: []int{paused}, Operation: "pause"})
+	expectStatus(t, status, body, http.StatusOK)
+	createTestSchedule(t, userID, schedule.Schedule{Medicine: "Metoprolol", Frequency: 12, Duration: 30})
+
+	status, body = request(t, http.MethodPost, "/v1/schedules/bulk", params, BulkRequest{IDs: []int{paused}, Operation: "resume"})
+	expectStatus(t, status, body, http.StatusOK)
+	if !strings.Contains(body, `"status":"skipped"`) {
+		t.Errorf("resume over the quota was not skipped: %s", body)
+	}
+
+	var version int
+	err := testServer.db.QueryRow(context.Background(), "SELECT version FROM schedule WHERE id = $1", paused).Scan(&version)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest(http.MethodPut, fmt.Sprintf("%s/v1/schedules/%d?%s", server.URL, paused, params.Encode()),
+		strings.NewReader(convertToJson(schedule.Schedule{Medicine: "Atorvastatin", Frequency: 24, Duration: 30, Status: "active"})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("If-Match", versionETag(version))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("update to active over the quota = %d, want %d", resp.StatusCode, http.StatusForbidden)
+	}
+}
+
+func TestQuotaHoldsUnderConcurrentCreates(t *testing.T) {
+	userID := createTestUser(t)
+	status, body := request(t, http.MethodPut, "/v1/admin/users/"+userID+"/quota", nil, map[string]int{"max_active_schedules": 2})
+	expectStatus(t, status, body, http.StatusOK)
+
+	medicines := []string{"Amlodipine", "Losartan", "Simvastatin", "Omeprazole", "Levothyroxine", "Sertraline"}
+	statuses := make(chan int, len(medicines))
+	for _, medicine := range medicines {
+		go func() {
+			body := convertToJson(schedule.Schedule{Medicine: medicine, Frequency: 24, Duration: 30, UserID: userID})
+			resp, err := http.Post(server.URL+"/schedule", "application/json", strings.NewReader(body))
+			if err != nil {
+				statuses <- 0
+				return
+			}
+			resp.Body.Close()
+			statuses <- resp.StatusCode
+		}()
+	}
+	created := 0
+	for range medicines {
+		if <-statuses == http.StatusOK {
+			created++
+		}
+	}
+	if created != 2 {
+		t.Errorf("%d schedules created concurrently, the quota allows 2", created)
+	}
+}
+
+func TestUpsertByExternalID(t *testing.T) {
+	userID := createTestUser(t)
+	path := "/v1/schedules/by-external-id/rx-1001"
+	pushed := schedule.Schedule{Medicine: "Lisinopril", Frequency: 30, Duration: 1, UserID: userID}
+
+	status, body := request(t, http.MethodPut, path, nil, pushed)
+	expectStatus(t, status, body, http.StatusCreated)
+	var created ExternalScheduleSaved
+	err := json.Unmarshal([]byte(body), &created)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status, body = request(t, http.MethodPut, path, nil, pushed)
+	expectStatus(t, status, body, http.StatusOK)
+	var repeated ExternalScheduleSaved
+	err = json.Unmarshal([]byte(body), &repeated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if repeated.ID != created.ID || repeated.Changed || repeated.Version != created.Version {
+		t.Fatalf("repeated push changed the schedule: %s", body)
+	}
+
+	pushed.Duration = 2
+	status, body = request(t, http.MethodPut, path, nil, pushed)
+	expectStatus(t, status, body, http.StatusOK)
+
+	status, body = request(t, http.MethodGet, "/schedules", url.Values{"user_id": {userID}}, nil)
+	expectStatus(t, status, body, http.StatusOK)
+	if strings.Count(body, `"external_id":"rx-1001"`) != 1 || !strings.Contains(body, `"duration":2`) {
+		t.Fatalf("expected one updated schedule: %s", body)
+	}
+}
+
+func TestSyncReturnsChanges(t *testing.T) {
+	userID := createTestUser(t)
+
+	status, body := request(t, http.MethodGet, "/v1/sync", url.Values{"user_id": {userID}}, nil)
+	expectStatus(t, status, body, http.StatusOK)
+	var first SyncResponse
+	err := json.Unmarshal([]byte(body), &first)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	scheduleID := createTestSchedule(t, userID, schedule.Schedule{Medicine: "Metformin", Frequency: 0, Duration: 2})
+
+	status, body = request(t, http.MethodGet, "/v1/sync", url.Values{"user_id": {userID}, "since": {first.Cursor}}, nil)
+	expectStatus(t, status, body, http.StatusOK)
+	var next SyncResponse
+	err = json.Unmarshal([]byte(body), &next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(next.Schedules) != 1 || next.Schedules[0].ID != scheduleID {
+		t.Fatalf("created schedule not synced: %s", body)
+	}
+}
+
+func TestMaintenanceRejectsWrites(t *testing.T) {
+	status, body := request(t, http.MethodPut, "/v1/admin/maintenance", nil, MaintenanceState{Enabled: true})
+	expectStatus(t, status, body, http.StatusOK)
+	defer request(t, http.MethodPut, "/v1/admin/maintenance", nil, MaintenanceState{Enabled: false})
+
+	status, body = request(t, http.MethodPost, "/schedule", nil, schedule.Schedule{Medicine: "Paracetamol", Frequency: 3, Duration: 2, UserID: "maintenance"})
+	expectStatus(t, status, body, http.StatusServiceUnavailable)
+}
+
+func TestMaintenanceReadsWriteNothing(t *testing.T) {
+	ctx := context.Background()
+	userID := createTestUser(t)
+	createTestSchedule(t, userID, schedule.Schedule{Medicine: "Amoxicillin", Frequency: 8, Duration: 5})
+	accessToken, err := issueAccessToken(userID, "integration-session")
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, body := requestWithToken(t, http.MethodPost, "/v1/tokens", nil, APIToken{Name: "reader", Scopes: []string{"read:schedules"}}, accessToken)
+	expectStatus(t, status, body, http.StatusCreated)
+	var created struct {
+		Token string `json:"token"`
+	}
+	err = json.Unmarshal([]byte(body), &created)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, refreshToken, err := testServer.createSession(ctx, httptest.NewRequest(http.MethodPost, "/html/login", nil), userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, body = requestWithToken(t, http.MethodPost, "/v1/users/"+userID+"/share-links", nil, nil, accessToken)
+	expectStatus(t, status, body, http.StatusCreated)
+	var link struct {
+		URL string `json:"url"`
+	}
+	err = json.Unmarshal([]byte(body), &link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, shareToken, _ := strings.Cut(link.URL, "/v1/shared/")
+
+	// every transaction of this server is read only, a write fails the request
+	config, err := pgxpool.ParseConfig(os.Getenv("DATABASE_URL"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.ConnConfig.RuntimeParams["timezone"] = "UTC"
+	config.ConnConfig.RuntimeParams["default_transaction_read_only"] = "on"
+	pool, err := pgxpool.NewWithConfig(ctx, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+	db := &storage.DB{Pool: pool}
+	readOnly, err := NewServer(db, storage.NewCipher(db), notify.Log{}, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	readOnly.setMaintenanceState(MaintenanceState{Enabled: true, RetryAfterSeconds: defaultMaintenanceRetryAfter})
+	handler := readOnly.Handler()
+
+	req := httptest.NewRequest(http.MethodGet, "/schedules", nil)
+	req.Header.Set("Authorization", "Bearer "+created.Token)
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	expectStatus(t, recorder.Code, recorder.Body.String(), http.StatusOK)
+	if !strings.Contains(recorder.Body.String(), `"medicine":"Amoxicillin"`) {
+		t.Fatalf("schedule missing from list: %s", recorder.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/html/today", nil)
+	req.AddCookie(&http.Cookie{Name: pageCookie, Value: refreshToken})
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	expectStatus(t, recorder.Code, recorder.Body.String(), http.StatusOK)
+
+	req = httptest.NewRequest(http.MethodGet, "/v1/shared/"+shareToken, nil)
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	expectStatus(t, recorder.Code, recorder.Body.String(), http.StatusOK)
+
+	// reads that must be logged are served, their entries wait for the end of maintenance
+	t.Setenv("COMPLIANCE_MODE", "true")
+	req = httptest.NewRequest(http.MethodGet, "/schedules", nil)
+	req.Header.Set("Authorization", "Bearer "+created.Token)
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	expectStatus(t, recorder.Code, recorder.Body.String(), http.StatusOK)
+	err = readOnly.FlushAccessLog(ctx)
+	if err != nil {
+		t.Fatalf("access log flushed in maintenance: %v", err)
+	}
+	if len(readOnly.accessLog.take()) != 1 {
+		t.Error("access logged in maintenance was dropped")
+	}
+
+	// the usage counted so far waits for the end of maintenance
+	err = readOnly.FlushUsage(ctx)
+	if err != nil {
+		t.Fatalf("usage flushed in maintenance: %v", err)
+	}
+	if len(readOnly.usage.take()) == 0 {
+		t.Error("usage counted in maintenance was dropped")
+	}
+}
+
+func TestAccessLoggedAfterMaintenance(t *testing.T) {
+	t.Setenv("COMPLIANCE_MODE", "true")
+	userID := createTestUser(t)
+	createTestSchedule(t, userID, schedule.Schedule{Medicine: "Warfarin", Frequency: 24, Duration: 30})
+	testServer.setMaintenanceState(MaintenanceState{Enabled: true, RetryAfterSeconds: defaultMaintenanceRetryAfter})
+	defer testServer.setMaintenanceState(MaintenanceState{Enabled: false, RetryAfterSeconds: defaultMaintenanceRetryAfter})
+
+	status, body := request(t, http.MethodGet, "/schedules", url.Values{"user_id": {userID}}, nil)
+	expectStatus(t, status, body, http.StatusOK)
+	status, body = request(t, http.MethodGet, "/v1/admin/access-log", url.Values{"user_id": {userID}}, nil)
+	expectStatus(t, status, body, http.StatusOK)
+	if strings.Contains(body, "/schedules") {
+		t.Fatalf("read logged while in maintenance: %s", body)
+	}
+
+	testServer.setMaintenanceState(MaintenanceState{Enabled: false, RetryAfterSeconds: defaultMaintenanceRetryAfter})
+	err := testServer.FlushAccessLog(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, body = request(t, http.MethodGet, "/v1/admin/access-log", url.Values{"user_id": {userID}}, nil)
+	expectStatus(t, status, body, http.StatusOK)
+	if !strings.Contains(body, ","+userID+",schedule,") {
+		t.Errorf("read in maintenance missing from the access log: %s", body)
+	}
+}
+
+func TestClinicianReadsExportByPatient(t *testing.T) {
+	t.Setenv("COMPLIANCE_MODE", "true")
+	patientID := createTestUser(t)
+	createTestSchedule(t, patientID, schedule.Schedule{Medicine: "Lisinopril", Frequency: 24, Duration: 30})
+	clinicianID := createTestUser(t)
+	status, body := request(t, http.MethodPut, "/v1/admin/users/"+clinicianID+"/role", nil, map[string]string{"role": "admin"})
+	expectStatus(t, status, body, http.StatusBadRequest)
+	if !strings.Contains(body, `"field":"role","rule":"enum"`) {
+		t.Errorf("unknown role body %s", body)
+	}
+	status, body = request(t, http.MethodPut, "/v1/admin/users/"+clinicianID+"/role", nil, map[string]string{"role": "clinician"})
+	expectStatus(t, status, body, http.StatusOK)
+	_, err := testServer.db.Exec(context.Background(), "INSERT INTO clinician_link (patient_id, clinician_id) VALUES ($1, $2)", patientID, clinicianID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	accessToken, err := issueAccessToken(clinicianID, "integration-session")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status, body = requestWithToken(t, http.MethodGet, "/v1/clinician/patients/"+patientID+"/schedules", nil, nil, accessToken)
+	expectStatus(t, status, body, http.StatusOK)
+
+	status, body = request(t, http.MethodGet, "/v1/admin/access-log", url.Values{"user_id": {patientID}}, nil)
+	expectStatus(t, status, body, http.StatusOK)
+	if !strings.Contains(body, clinicianID+","+patientID+",schedule,") {
+		t.Errorf("clinician read missing from the export of the patient: %s", body)
+	}
+}
+
+func TestScopesRequireCredentials(t *testing.T) {
+	t.Setenv("LEGACY_USER_ID_PARAM", "false")
+	userID := createTestUser(t)
+	accessToken, err := issueAccessToken(userID, "integration-session")
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, body := requestWithToken(t, http.MethodPost, "/schedule", nil, schedule.Schedule{Medicine: "Ibuprofen", Frequency: 8, Duration: 5}, accessToken)
+	expectStatus(t, status, body, http.StatusOK)
+	var scheduleID int
+	_, err = fmt.Sscanf(body, "schedule saved with ID: %d", &scheduleID)
+	if err != nil {
+		t.Fatalf("unexpected create response %q", body)
+	}
+
+	// without credentials the user_id parameter no longer names the user
+	for _, path := range []string{"/schedules", "/v1/users/" + userID + "/plan.pdf", "/v1/intakes"} {
+		status, body = request(t, http.MethodGet, path, url.Values{"user_id": {userID}}, nil)
+		expectStatus(t, status, body, http.StatusUnauthorized)
+	}
+	status, body = request(t, http.MethodPost, "/v1/users/"+userID+"/share-links", url.Values{"user_id": {userID}}, nil)
+	expectStatus(t, status, body, http.StatusUnauthorized)
+
+	status, body = requestWithToken(t, http.MethodPost, "/v1/tokens", nil, APIToken{Name: "reader", Scopes: []string{"read:schedules"}}, accessToken)
+	expectStatus(t, status, body, http.StatusCreated)
+	var created struct {
+		Token string `json:"token"`
+	}
+	err = json.Unmarshal([]byte(body), &created)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status, body = requestWithToken(t, http.MethodGet, "/schedules", nil, nil, created.Token)
+	expectStatus(t, status, body, http.StatusOK)
+	if !strings.Contains(body, `"medicine":"Ibuprofen"`) {
+		t.Fatalf("schedule missing from list: %s", body)
+	}
+	status, body = requestWithToken(t, http.MethodGet, "/v1/intakes", nil, nil, created.Token)
+	expectStatus(t, status, body, http.StatusForbidden)
+	status, body = requestWithToken(t, http.MethodPost, fmt.Sprintf("/v1/schedules/%d/clone", scheduleID), nil, map[string]string{}, created.Token)
+	expectStatus(t, status, body, http.StatusForbidden)
+	status, body = requestWithToken(t, http.MethodPost, "/v1/users/"+userID+"/share-links", nil, nil, created.Token)
+	expectStatus(t, status, body, http.StatusForbidden)
+
+	// another user's token does not reach the schedule
+	otherToken, err := issueAccessToken(createTestUser(t), "integration-session")
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, body = requestWithToken(t, http.MethodGet, "/schedule", url.Values{"user_id": {userID}, "schedule_id": {fmt.Sprint(scheduleID)}}, nil, otherToken)
+	expectStatus(t, status, body, http.StatusNotFound)
+	status, body = requestWithToken(t, http.MethodGet, "/v1/users/"+userID+"/plan.pdf", nil, nil, otherToken)
+	expectStatus(t, status, body, http.StatusForbidden)
+}
+
+func TestSecondFactorLockout(t *testing.T) {
+	userID := createTestUser(t)
+	accessToken, err := issueAccessToken(userID, "integration-session")
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, body := requestWithToken(t, http.MethodPost, "/v1/auth/2fa/enroll", nil, nil, accessToken)
+	expectStatus(t, status, body, http.StatusOK)
+	var enrolled struct {
+		Secret string `json:"secret"`
+	}
+	err = json.Unmarshal([]byte(body), &enrolled)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, err := totpEncoding.DecodeString(enrolled.Secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// codes from outside the skew window are wrong
+	current := time.Now().Unix() / totpPeriod
+	for i := range totpMaxFailures {
+		wrong := map[string]string{"otp": totpCode(secret, current+10+int64(i))}
+		status, body = requestWithToken(t, http.MethodPost, "/v1/auth/2fa/verify", nil, wrong, accessToken)
+		expectStatus(t, status, body, http.StatusUnauthorized)
+	}
+
+	// the right code does not help while the second factor is locked
+	status, body = requestWithToken(t, http.MethodPost, "/v1/auth/2fa/verify", nil, map[string]string{"otp": totpCode(secret, current)}, accessToken)
+	expectStatus(t, status, body, http.StatusTooManyRequests)
+}
+
+func TestVerifyRequiresEnrollment(t *testing.T) {
+	userID := createTestUser(t)
+	accessToken, err := issueAccessToken(userID, "integration-session")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the code of an empty secret must not switch on two-factor authentication
+	current := time.Now().Unix() / totpPeriod
+	status, body := requestWithToken(t, http.MethodPost, "/v1/auth/2fa/verify", nil, map[string]string{"otp": totpCode(nil, current)}, accessToken)
+	expectStatus(t, status, body, http.StatusConflict)
+}
+
+func TestJobCheckpoint(t *testing.T) {
+	ctx := context.Background()
+	name := fmt.Sprintf("test-%d", time.Now().UnixNano())
+
+	at, err := testServer.loadCheckpoint(ctx, name)
+	if err != nil || !at.IsZero() {
+		t.Fatalf("checkpoint of a job that never ran = %s, %v", at, err)
+	}
+
+	later := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	for _, save := range []time.Time{later, later.Add(-time.Hour)} {
+		err = testServer.saveCheckpoint(ctx, name, save)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// an instance with an older run does not move the checkpoint back
+	at, err = testServer.loadCheckpoint(ctx, name)
+	if err != nil || !at.Equal(later) {
+		t.Errorf("checkpoint = %s, %v, want %s", at, err, later)
+	}
+}
+
+func TestCreateOrganizationNeedsName(t *testing.T) {
+	status, body := request(t, http.MethodPost, "/v1/admin/orgs", nil, map[string]string{"name": "  "})
+	expectStatus(t, status, body, http.StatusBadRequest)
+	if !strings.Contains(body, `"field":"name","rule":"required"`) {
+		t.Errorf("blank name body %s", body)
+	}
+
+	userID := createTestUser(t)
+	status, body = request(t, http.MethodPut, "/v1/admin/orgs/00000000-0000-0000-0000-000000000000/staff/"+userID, nil, nil)
+	expectStatus(t, status, body, http.StatusNotFound)
+}
+
+func TestSafetyGapFollowsShortestWindow(t *testing.T) {
+	medicine := fmt.Sprintf("gaptestol-%d", time.Now().UnixNano())
+	status, body := request(t, http.MethodPut, "/v1/admin/medicines/"+medicine+"/safety", nil, map[string]any{"min_gap_hours": 6, "strict": true})
+	expectStatus(t, status, body, http.StatusOK)
+	userID := createTestUser(t)
+	// 08:00 to 22:00 leaves 7 hours between three doses
+	scheduleID := createTestSchedule(t, userID, schedule.Schedule{Medicine: medicine, Frequency: 1, Duration: 3})
+
+	// from 10:00 to 20:00 on weekends they would be 5 hours apart
+	variants := schedule.Variants{Weekend: &schedule.Window{Wake: "10:00", Sleep: "20:00"}}
+	status, body = request(t, http.MethodPut, fmt.Sprintf("/v1/schedules/%d/variants", scheduleID), url.Values{"user_id": {userID}}, variants)
+	expectStatus(t, status, body, http.StatusUnprocessableEntity)
+	if !strings.Contains(body, `"rule":"min_gap_hours"`) {
+		t.Errorf("short weekend body %s", body)
+	}
+
+	variants.Weekend.Sleep = "22:00"
+	status, body = request(t, http.MethodPut, fmt.Sprintf("/v1/schedules/%d/variants", scheduleID), url.Values{"user_id": {userID}}, variants)
+	expectStatus(t, status, body, http.StatusOK)
+}
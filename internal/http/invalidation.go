@@ -0,0 +1,52 @@
+package http
+
+import (
+	"context"
+	"log"
+)
+
+// channels notified by the database or other instances and what they invalidate here.
+// Notifications are sent by the sending instance too, handlers have to be idempotent.
+func (srv *Server) invalidationHandlers() map[string]func(payload string) {
+	return map[string]func(payload string){
+		"data_key_changed":      func(string) { srv.cipher.Reset() },
+		"maintenance_changed":   srv.applyMaintenanceNotification,
+		"feature_flags_changed": srv.reloadFeatureFlags,
+		"schedule_changed":      srv.notifyScheduleListeners,
+		"intake_changed":        srv.doseWaiters.wake,
+	}
+}
+
+// listener is called with the user whose schedules changed on any instance
+func (srv *Server) OnScheduleChange(listener func(userID string)) {
+	srv.listenersMu.Lock()
+	srv.scheduleListeners = append(srv.scheduleListeners, listener)
+	srv.listenersMu.Unlock()
+}
+
+func (srv *Server) notifyScheduleListeners(userID string) {
+	srv.listenersMu.Lock()
+	listeners := srv.scheduleListeners
+	srv.listenersMu.Unlock()
+
+	for _, listener := range listeners {
+		listener(userID)
+	}
+}
+
+// notifications missed while the listener was down are made up for by invalidating
+// everything after reconnecting
+func (srv *Server) RunInvalidationListener(ctx context.Context) {
+	srv.db.Listen(ctx, srv.invalidationHandlers(), func() {
+		srv.cipher.Reset()
+		srv.doseWaiters.wakeAll()
+		err := srv.loadMaintenanceState(ctx)
+		if err != nil {
+			log.Printf("listener: %v", err)
+		}
+		err = srv.loadFeatureFlags(ctx)
+		if err != nil {
+			log.Printf("listener: %v", err)
+		}
+	})
+}
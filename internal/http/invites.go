@@ -0,0 +1,221 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"kode_test/internal/schedule"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const inviteTTL = 7 * 24 * time.Hour
+
+type Invite struct {
+	ID    string `json:"id"`
+	Email string `json:"email"`
+	// caregiver or clinician
+	Kind      string    `json:"kind"`
+	ExpiresAt time.Time `json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// emails a single use link that makes the invited person a caregiver or clinician of the user
+func (srv *Server) createInviteHandler(w http.ResponseWriter, r *http.Request) error {
+	// the link grants access to the user's data, it goes nowhere but the invited address
+	if !srv.notifier.CanEmail() {
+		http.Error(w, "email is not configured", http.StatusNotImplemented)
+		return nil
+	}
+
+	userID := currentUserID(r)
+	if !srv.checkRateLimit(w, "invite:"+userID, 20, 24*time.Hour) {
+		return nil
+	}
+
+	var invite Invite
+	err := json.NewDecoder(r.Body).Decode(&invite)
+	if err != nil {
+		return schedule.Errorf(schedule.ErrValidation, "invalid invite format")
+	}
+	invite.Email = strings.ToLower(strings.TrimSpace(invite.Email))
+	var errs []error
+	if !strings.Contains(invite.Email, "@") {
+		errs = append(errs, schedule.FieldErrorf("email", "format", "a valid email is required"))
+	}
+	if invite.Kind != "caregiver" && invite.Kind != "clinician" {
+		errs = append(errs, schedule.FieldErrorf("kind", "enum", "kind must be caregiver or clinician"))
+	}
+	err = schedule.JoinFields(errs...)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	token := randomHex(32)
+	query := "INSERT INTO invite (inviter_id, email, kind, token_hash, expires_at) VALUES ($1, $2, $3, $4, $5) RETURNING id::text, expires_at, created_at"
+	err = srv.db.QueryRow(ctx, query, userID, invite.Email, invite.Kind, hashToken(token), time.Now().Add(inviteTTL)).Scan(&invite.ID, &invite.ExpiresAt, &invite.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed save invite: %w", err)
+	}
+
+	link := appURL() + "/accept-invite?token=" + url.QueryEscape(token)
+	message := fmt.Sprintf("You have been invited to follow a medication plan as %s.\n\n%s\n\nThe link is valid for %s.", invite.Kind, link, inviteTTL)
+	err = srv.notifier.NotifyEmail(ctx, invite.Email, "You have been invited as "+invite.Kind, message)
+	if err != nil {
+		// nobody received the link, the invite would only sit in the list of open ones
+		srv.db.Exec(ctx, "DELETE FROM invite WHERE id::text = $1", invite.ID)
+		return fmt.Errorf("failed send invite: %w", err)
+	}
+	log.Printf("invite %s sent", invite.ID)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	fmt.Fprint(w, convertToJson(invite))
+	return nil
+}
+
+// the invites of the user that were not accepted yet
+func (srv *Server) getInvitesHandler(w http.ResponseWriter, r *http.Request) error {
+	query := `SELECT id::text, email, kind, expires_at, created_at FROM invite
+		WHERE inviter_id = $1 AND accepted_at IS NULL AND expires_at > now() ORDER BY created_at`
+	rows, err := srv.db.QueryRead(context.Background(), query, currentUserID(r))
+	if err != nil {
+		return fmt.Errorf("failed get invites from database: %w", err)
+	}
+	invites, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (Invite, error) {
+		var invite Invite
+		err := row.Scan(&invite.ID, &invite.Email, &invite.Kind, &invite.ExpiresAt, &invite.CreatedAt)
+		return invite, err
+	})
+	if err != nil {
+		return fmt.Errorf("failed get invites from database: %w", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, convertToJson(invites))
+	return nil
+}
+
+func (srv *Server) deleteInviteHandler(w http.ResponseWriter, r *http.Request) error {
+	query := "DELETE FROM invite WHERE id::text = $1 AND inviter_id = $2 AND accepted_at IS NULL"
+	tag, err := srv.db.Exec(context.Background(), query, r.PathValue("id"), currentUserID(r))
+	if err != nil {
+		return fmt.Errorf("failed delete invite: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return schedule.Errorf(schedule.ErrNotFound, "invite not found")
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// accepts an invite with the signed in account, or creates an account for the invited
+// email with the given password. The account, the invite and the link change together.
+func (srv *Server) acceptInviteHandler(w http.ResponseWriter, r *http.Request) error {
+	var body struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+		Timezone string `json:"timezone"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil || body.Token == "" {
+		return schedule.Errorf(schedule.ErrValidation, "invalid invite format")
+	}
+
+	// the bearer token is optional, without it a new account is created
+	userID := ""
+	if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		claims, err := parseAccessToken(bearer)
+		if err != nil {
+			return err
+		}
+		userID = claims.Subject
+	}
+
+	var accepted struct {
+		UserID    string `json:"user_id"`
+		PatientID string `json:"patient_id"`
+		Kind      string `json:"kind"`
+		Created   bool   `json:"created"`
+	}
+	ctx := context.Background()
+	err = srv.db.InTx(ctx, func(tx pgx.Tx) error {
+		var email string
+		query := `UPDATE invite SET accepted_at = now() WHERE token_hash = $1 AND accepted_at IS NULL AND expires_at > now()
+			RETURNING inviter_id, email, kind`
+		err := tx.QueryRow(ctx, query, hashToken(body.Token)).Scan(&accepted.PatientID, &email, &accepted.Kind)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return schedule.Errorf(schedule.ErrNotFound, "invite not found or expired")
+		}
+		if err != nil {
+			return err
+		}
+
+		if userID == "" {
+			credentials := Credentials{Email: email, Password: body.Password, Timezone: body.Timezone}
+			if message := ValidateSignup(&credentials); message != "" {
+				return schedule.Errorf(schedule.ErrValidation, "%s", message)
+			}
+			userID, err = createUser(ctx, tx, credentials)
+			if errors.Is(err, ErrEmailTaken) {
+				return schedule.Errorf(schedule.ErrConflict, "an account with this email exists, sign in to accept the invite")
+			}
+			if err != nil {
+				return err
+			}
+			// the invite reached the email, so it needs no further verification
+			role := "patient"
+			if accepted.Kind == "clinician" {
+				role = "clinician"
+			}
+			_, err = tx.Exec(ctx, "UPDATE users SET email_verified_at = now(), role = $2 WHERE id = $1", userID, role)
+			if err != nil {
+				return err
+			}
+			accepted.Created = true
+		}
+		if userID == accepted.PatientID {
+			return schedule.Errorf(schedule.ErrValidation, "the invite was sent by this account")
+		}
+
+		if accepted.Kind == "clinician" {
+			var role string
+			err = tx.QueryRow(ctx, "SELECT role FROM users WHERE id = $1", userID).Scan(&role)
+			if err != nil {
+				return err
+			}
+			if role != "clinician" {
+				return schedule.Errorf(schedule.ErrConflict, "the invite is for a clinician account")
+			}
+			query = "INSERT INTO clinician_link (patient_id, clinician_id) VALUES ($1, $2) ON CONFLICT DO NOTHING"
+		} else {
+			query = "INSERT INTO caregiver_link (patient_id, caregiver_id) VALUES ($1, $2) ON CONFLICT DO NOTHING"
+		}
+		_, err = tx.Exec(ctx, query, accepted.PatientID, userID)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(ctx, "UPDATE invite SET accepted_by = $2 WHERE token_hash = $1", hashToken(body.Token), userID)
+		return err
+	})
+	var domainErr *schedule.Error
+	if errors.As(err, &domainErr) {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("failed accept invite: %w", err)
+	}
+	accepted.UserID = userID
+
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, convertToJson(accepted))
+	return nil
+}
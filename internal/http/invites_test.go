@@ -0,0 +1,31 @@
+package http
+
+import (
+	"kode_test/internal/notify"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// without an email transport there is nowhere safe to send the link to
+func TestCreateInviteRequiresEmail(t *testing.T) {
+	srv := &Server{notifier: notify.WithBreakers(notify.Log{})}
+	recorder := httptest.NewRecorder()
+	body := strings.NewReader(`{"email": "carer@example.com", "kind": "caregiver"}`)
+	err := srv.createInviteHandler(recorder, httptest.NewRequest(http.MethodPost, "/v1/invites", body))
+	if err != nil || recorder.Code != http.StatusNotImplemented {
+		t.Errorf("invite without email = %d, %v", recorder.Code, err)
+	}
+}
+
+func TestRosterImportRequiresEmail(t *testing.T) {
+	srv := &Server{notifier: notify.WithBreakers(notify.Log{})}
+	recorder := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/v1/orgs/clinic/rosters", strings.NewReader("email\npatient@example.com\n"))
+	r.Header.Set("Content-Type", "text/csv")
+	err := srv.createRosterImportHandler(recorder, r)
+	if err != nil || recorder.Code != http.StatusNotImplemented {
+		t.Errorf("roster without email = %d, %v", recorder.Code, err)
+	}
+}
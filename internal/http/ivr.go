@@ -0,0 +1,190 @@
+package http
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"kode_test/internal/pii"
+	"kode_test/internal/schedule"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var phonePattern = regexp.MustCompile(`^\+[1-9][0-9]{6,14}$`)
+
+// the number voice call reminders ring, an empty one stops them
+func (srv *Server) putPhoneHandler(w http.ResponseWriter, r *http.Request) error {
+	userID, err := pathUserID(r)
+	if err != nil {
+		return err
+	}
+	var body struct {
+		Phone string `json:"phone"`
+	}
+	err = json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		return schedule.Errorf(schedule.ErrValidation, "invalid phone format")
+	}
+	body.Phone = strings.ReplaceAll(body.Phone, " ", "")
+	if body.Phone != "" && !phonePattern.MatchString(body.Phone) {
+		return schedule.Errorf(schedule.ErrValidation, "phone must be in international format, like +4930123456")
+	}
+
+	tag, err := srv.db.Exec(context.Background(), "UPDATE users SET phone = $2 WHERE id = $1", userID, body.Phone)
+	if err != nil {
+		return fmt.Errorf("failed save phone: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return schedule.Errorf(schedule.ErrNotFound, "user not found")
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, convertToJson(body))
+	return nil
+}
+
+// the call of a reminder is authorized by a signature over its id, the provider fetches the
+// instructions and posts the keypad answer to URLs carrying it
+func ivrToken(reminderID int64) (string, error) {
+	secret, err := jwtSecret()
+	if err != nil {
+		return "", err
+	}
+	id := strconv.FormatInt(reminderID, 10)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("ivr." + id))
+
+	return id + "." + hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+func parseIVRToken(token string) (int64, bool) {
+	id, _, ok := strings.Cut(token, ".")
+	if !ok {
+		return 0, false
+	}
+	reminderID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	expected, err := ivrToken(reminderID)
+	if err != nil || !hmac.Equal([]byte(token), []byte(expected)) {
+		return 0, false
+	}
+
+	return reminderID, true
+}
+
+// rings the user's phone for a reminder, the response is the id of the call
+func (srv *Server) callReminder(ctx context.Context, reminderID int64, userID string) (string, error) {
+	if srv.caller == nil {
+		return "", errors.New("voice calls are not configured")
+	}
+	var phone string
+	err := srv.db.QueryRow(ctx, "SELECT phone FROM users WHERE id = $1", userID).Scan(&phone)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return "", err
+	}
+	if phone == "" {
+		return "", errors.New("no phone number for voice calls")
+	}
+	token, err := ivrToken(reminderID)
+	if err != nil {
+		return "", err
+	}
+
+	return srv.caller.Call(ctx, phone, appURL()+"/v1/ivr/"+token)
+}
+
+// the reminder of a call and its dose, calls are only answered for a day
+func (srv *Server) ivrReminder(ctx context.Context, token string) (userID string, s schedule.Schedule, doseID string, doseTime time.Time, err error) {
+	reminderID, ok := parseIVRToken(token)
+	if !ok {
+		return "", s, "", doseTime, schedule.Errorf(schedule.ErrNotFound, "call not found")
+	}
+	var scheduleID int
+	query := "SELECT user_id, schedule_id, dose_id FROM reminder WHERE id = $1 AND sent_at > now() - interval '1 day'"
+	err = srv.db.QueryRow(ctx, query, reminderID).Scan(&userID, &scheduleID, &doseID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", s, "", doseTime, schedule.Errorf(schedule.ErrNotFound, "call not found")
+	}
+	if err != nil {
+		return "", s, "", doseTime, err
+	}
+	s, doseTime, err = srv.reminderDose(ctx, userID, scheduleID, doseID)
+
+	return userID, s, doseID, doseTime, err
+}
+
+// TwiML, the instructions Twilio follows during a call
+func writeTwiML(w http.ResponseWriter, verbs ...string) {
+	w.Header().Set("Content-Type", "text/xml")
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?><Response>%s</Response>`, strings.Join(verbs, ""))
+}
+
+func say(text string) string {
+	var escaped strings.Builder
+	xml.EscapeText(&escaped, []byte(text))
+	return "<Say>" + escaped.String() + "</Say>"
+}
+
+// what the call says when the user picks up
+func (srv *Server) ivrInstructionsHandler(w http.ResponseWriter, r *http.Request) {
+	_, s, _, doseTime, err := srv.ivrReminder(context.Background(), r.PathValue("token"))
+	if errors.Is(err, schedule.ErrNotFound) {
+		http.Error(w, "call not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("ivr: %v", err)
+		http.Error(w, "failed get reminder", http.StatusInternalServerError)
+		return
+	}
+
+	message := fmt.Sprintf("Hello, this is your medication reminder. It is time to take %s, due at %s. Press 1 once you have taken it.", s.Medicine, spokenTime(doseTime))
+	action := appURL() + "/v1/ivr/" + r.PathValue("token") + "/confirm"
+	gather := `<Gather numDigits="1" timeout="10" method="POST" action="` + action + `">` + say(message) + `</Gather>`
+	writeTwiML(w, gather, say("We did not get an answer. Goodbye."))
+}
+
+// the keypad answer, 1 confirms the dose in the intake log
+func (srv *Server) ivrConfirmHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := context.Background()
+	userID, s, doseID, _, err := srv.ivrReminder(ctx, r.PathValue("token"))
+	if errors.Is(err, schedule.ErrNotFound) {
+		http.Error(w, "call not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("ivr: %v", err)
+		http.Error(w, "failed get reminder", http.StatusInternalServerError)
+		return
+	}
+	if r.PostFormValue("Digits") != "1" {
+		writeTwiML(w, say("Your dose was not recorded. Goodbye."))
+		return
+	}
+
+	_, _, slot, _ := schedule.ParseDoseID(doseID)
+	_, issues, err := srv.recordIntake(ctx, Intake{ScheduleID: s.ID, DoseID: doseID, UserID: userID, TakenAt: time.Now()}, slot)
+	switch {
+	case errors.Is(err, errUnsafeIntake):
+		writeTwiML(w, say("Your dose was not recorded. "+issues[0].Message))
+	case errors.Is(err, schedule.ErrConflict):
+		writeTwiML(w, say("This dose was already recorded. Goodbye."))
+	case err != nil:
+		log.Printf("ivr: confirm dose for %s: %v", pii.MaskUserID(userID), err)
+		writeTwiML(w, say("Sorry, your dose could not be recorded. Please confirm it in the app."))
+	default:
+		writeTwiML(w, say("Thank you, your dose of "+s.Medicine+" is recorded. Goodbye."))
+	}
+}
@@ -0,0 +1,108 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"kode_test/internal/schedule"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+const maxScenarioRequests = 100000
+
+// a request of a load test scenario in vegeta's JSON target format,
+// k6 scripts can read the same lines into a SharedArray
+type ScenarioTarget struct {
+	Method string `json:"method"`
+	URL    string `json:"url"`
+}
+
+// share of the read traffic per endpoint, next_takings dominates as clients poll it
+var scenarioMix = []struct {
+	weight int
+	target func(base string, userID string, scheduleID int) ScenarioTarget
+}{
+	{60, func(base string, userID string, scheduleID int) ScenarioTarget {
+		return ScenarioTarget{Method: http.MethodGet, URL: base + "/next_takings?" + url.Values{"user_id": {userID}}.Encode()}
+	}},
+	{20, func(base string, userID string, scheduleID int) ScenarioTarget {
+		return ScenarioTarget{Method: http.MethodGet, URL: base + "/schedules?" + url.Values{"user_id": {userID}}.Encode()}
+	}},
+	{10, func(base string, userID string, scheduleID int) ScenarioTarget {
+		return ScenarioTarget{Method: http.MethodGet, URL: base + "/schedule?" + url.Values{"user_id": {userID}, "schedule_id": {strconv.Itoa(scheduleID)}}.Encode()}
+	}},
+	{10, func(base string, userID string, scheduleID int) ScenarioTarget {
+		return ScenarioTarget{Method: http.MethodGet, URL: base + "/v1/intakes?" + url.Values{"user_id": {userID}}.Encode()}
+	}},
+}
+
+// generates a load test scenario over the schedules in the database, best run after scheduler seed.
+// Parameters: requests (default 1000), base_url (default http://localhost:3333), seed (default 1).
+// The response has one target per line: vegeta attack -format=json, or JSON.parse per line in k6.
+func (srv *Server) getLoadTestScenarioHandler(w http.ResponseWriter, r *http.Request) error {
+	urlParams := r.URL.Query()
+	requests, seed := 1000, int64(1)
+	var err error
+	if value := urlParams.Get("requests"); value != "" {
+		requests, err = strconv.Atoi(value)
+		if err != nil || requests < 1 || requests > maxScenarioRequests {
+			return schedule.Errorf(schedule.ErrValidation, "requests must be between 1 and %d", maxScenarioRequests)
+		}
+	}
+	if value := urlParams.Get("seed"); value != "" {
+		seed, err = strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return schedule.Errorf(schedule.ErrValidation, "invalid seed")
+		}
+	}
+	base := urlParams.Get("base_url")
+	if base == "" {
+		base = "http://localhost:3333"
+	}
+
+	type scheduleRef struct {
+		id     int
+		userID string
+	}
+	var schedules []scheduleRef
+	rows, err := srv.db.QueryRead(context.Background(), "SELECT id, user_id FROM schedule WHERE status = 'active' ORDER BY id LIMIT 10000")
+	if err != nil {
+		return fmt.Errorf("failed get schedules from database: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var s scheduleRef
+		err := rows.Scan(&s.id, &s.userID)
+		if err != nil {
+			return fmt.Errorf("failed get schedule: %w", err)
+		}
+		schedules = append(schedules, s)
+	}
+	if len(schedules) == 0 {
+		return schedule.Errorf(schedule.ErrConflict, "no active schedules to build a scenario from, run scheduler seed first")
+	}
+
+	totalWeight := 0
+	for _, entry := range scenarioMix {
+		totalWeight += entry.weight
+	}
+
+	rng := rand.New(rand.NewSource(seed))
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	encoder := json.NewEncoder(w)
+	for i := 0; i < requests; i++ {
+		s := schedules[rng.Intn(len(schedules))]
+		pick := rng.Intn(totalWeight)
+		for _, entry := range scenarioMix {
+			if pick < entry.weight {
+				encoder.Encode(entry.target(base, s.userID, s.id))
+				break
+			}
+			pick -= entry.weight
+		}
+	}
+	return nil
+}
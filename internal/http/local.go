@@ -0,0 +1,71 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+// the implied user of local mode
+const localUserID = "local"
+
+// LOCAL_MODE=true turns authentication off for installs used by one person,
+// every request without credentials is made by the local user
+func localMode() bool {
+	return os.Getenv("LOCAL_MODE") == "true"
+}
+
+// makes requests without credentials the local user's in local mode
+func (srv *Server) localUser(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !localMode() || r.Header.Get("Authorization") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		r = r.Clone(context.WithValue(r.Context(), userIDKey, localUserID))
+		urlParams := r.URL.Query()
+		urlParams.Set("user_id", localUserID)
+		r.URL.RawQuery = urlParams.Encode()
+		recordCaller(r.Context(), localUserID, "")
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// in local mode the request was let in as the local user already
+func localRequest(r *http.Request) bool {
+	return localMode() && r.Header.Get("Authorization") == "" && currentUserID(r) == localUserID
+}
+
+// creates the local user in local mode, LOCAL_TIMEZONE sets its timezone on creation
+func (srv *Server) EnsureLocalUser(ctx context.Context) error {
+	if !localMode() {
+		return nil
+	}
+
+	timezone := os.Getenv("LOCAL_TIMEZONE")
+	if timezone == "" {
+		timezone = "UTC"
+	}
+	if _, err := time.LoadLocation(timezone); err != nil {
+		return fmt.Errorf("invalid LOCAL_TIMEZONE: %w", err)
+	}
+
+	// the empty password hash never matches, the user can not log in and does not need to
+	query := "INSERT INTO users (id, email, password_hash, timezone) VALUES ($1, $2, '', $3) ON CONFLICT DO NOTHING"
+	_, err := srv.db.Exec(ctx, query, localUserID, "local@localhost", timezone)
+	if err != nil {
+		return fmt.Errorf("failed create local user: %w", err)
+	}
+
+	return nil
+}
+
+// who the request is made by, lets the dashboard skip its login in local mode
+func (srv *Server) meHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, convertToJson(map[string]interface{}{"user_id": currentUserID(r), "local": localRequest(r)}))
+}
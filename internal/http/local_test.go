@@ -0,0 +1,69 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLocalUser(t *testing.T) {
+	t.Setenv("JWT_SECRET", "local-test-secret")
+	token, err := issueAccessToken("ada", "session")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &Server{}
+	handler := srv.localUser(authenticated(func(w http.ResponseWriter, r *http.Request) {
+		recordCaller(r.Context(), currentUserID(r), "")
+		fmt.Fprintf(w, "%s %s %v", currentUserID(r), r.URL.Query().Get("user_id"), localRequest(r))
+	}))
+
+	tests := []struct {
+		local         string
+		authorization string
+		wantStatus    int
+		wantBody      string
+	}{
+		{"", "", http.StatusUnauthorized, ""},
+		{"true", "", http.StatusOK, "local local true"},
+		// credentials are still checked, a wrong token does not fall back to the local user
+		{"true", "Bearer not-a-token", http.StatusUnauthorized, ""},
+		{"true", "Bearer " + token, http.StatusOK, "ada someone-else false"},
+		{"false", "", http.StatusUnauthorized, ""},
+	}
+	for _, test := range tests {
+		t.Setenv("LOCAL_MODE", test.local)
+		r := httptest.NewRequest(http.MethodGet, "/schedules?user_id=someone-else", nil)
+		if test.authorization != "" {
+			r.Header.Set("Authorization", test.authorization)
+		}
+		c := &caller{}
+		r = r.WithContext(context.WithValue(r.Context(), callerKey, c))
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, r)
+
+		if recorder.Code != test.wantStatus || (test.wantBody != "" && recorder.Body.String() != test.wantBody) {
+			t.Errorf("LOCAL_MODE=%q Authorization %q = %d %q, want %d %q", test.local, test.authorization, recorder.Code, recorder.Body, test.wantStatus, test.wantBody)
+		}
+		if user, _, _ := strings.Cut(test.wantBody, " "); c.userID != user {
+			t.Errorf("LOCAL_MODE=%q Authorization %q: usage attributed to %q, want %q", test.local, test.authorization, c.userID, user)
+		}
+	}
+}
+
+func TestMeHandler(t *testing.T) {
+	srv := &Server{}
+	handler := srv.localUser(http.HandlerFunc(srv.meHandler))
+
+	for local, want := range map[string]string{"true": `{"local":true,"user_id":"local"}`, "": `{"local":false,"user_id":""}`} {
+		t.Setenv("LOCAL_MODE", local)
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/v1/auth/me", nil))
+		if recorder.Body.String() != want {
+			t.Errorf("LOCAL_MODE=%q: %s, want %s", local, recorder.Body, want)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package http
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"kode_test/internal/schedule"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// the longest a client can hold a request for the next dose open
+const maxNextDoseWait = time.Minute
+
+// an open dose counts as the next one for this long after it was due
+const nextDoseOverdue = time.Hour
+
+// requests waiting for a change of a user's schedules or intakes
+type changeWaiters struct {
+	mu      sync.Mutex
+	waiting map[string][]chan struct{}
+}
+
+func newChangeWaiters() *changeWaiters {
+	return &changeWaiters{waiting: map[string][]chan struct{}{}}
+}
+
+// a channel closed on the next change for the user, cancel must be called unless it was closed
+func (c *changeWaiters) wait(userID string) chan struct{} {
+	changed := make(chan struct{})
+	c.mu.Lock()
+	c.waiting[userID] = append(c.waiting[userID], changed)
+	c.mu.Unlock()
+
+	return changed
+}
+
+func (c *changeWaiters) cancel(userID string, changed chan struct{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.waiting[userID] = slices.DeleteFunc(c.waiting[userID], func(ch chan struct{}) bool { return ch == changed })
+	if len(c.waiting[userID]) == 0 {
+		delete(c.waiting, userID)
+	}
+}
+
+func (c *changeWaiters) wake(userID string) {
+	c.mu.Lock()
+	waiting := c.waiting[userID]
+	delete(c.waiting, userID)
+	c.mu.Unlock()
+
+	for _, changed := range waiting {
+		close(changed)
+	}
+}
+
+// after the listener reconnected, changes may have been missed
+func (c *changeWaiters) wakeAll() {
+	c.mu.Lock()
+	waiting := c.waiting
+	c.waiting = map[string][]chan struct{}{}
+	c.mu.Unlock()
+
+	for _, channels := range waiting {
+		for _, changed := range channels {
+			close(changed)
+		}
+	}
+}
+
+// the earliest open doses, which are all due at the same time
+type NextDose struct {
+	At    *time.Time      `json:"at,omitempty"`
+	Doses []NextDoseEntry `json:"doses"`
+}
+
+type NextDoseEntry struct {
+	DoseID     string `json:"dose_id"`
+	ScheduleID int    `json:"schedule_id"`
+	Medicine   string `json:"medicine"`
+}
+
+// the next dose of the user and when that changes by itself, because the dose stops being open
+func (srv *Server) nextDose(ctx context.Context, userID string, loc *time.Location) (NextDose, time.Time, error) {
+	now := time.Now().In(loc)
+	doses, err := srv.openDoses(ctx, userID, loc, now, now.Add(-nextDoseOverdue))
+	if err != nil {
+		return NextDose{}, time.Time{}, err
+	}
+
+	next := NextDose{Doses: []NextDoseEntry{}}
+	if len(doses) == 0 {
+		return next, time.Time{}, nil
+	}
+	at := doses[0].at
+	next.At = &at
+	for _, dose := range doses {
+		if !dose.at.Equal(at) {
+			break
+		}
+		next.Doses = append(next.Doses, NextDoseEntry{DoseID: dose.doseID, ScheduleID: dose.scheduleID, Medicine: dose.medicine})
+	}
+
+	return next, at.Add(nextDoseOverdue), nil
+}
+
+// answers with the next dose, with wait=30s only once it differs from the one the client has,
+// the one in If-None-Match or otherwise the one at the time of the request, or when the wait is over
+func (srv *Server) getNextDoseHandler(w http.ResponseWriter, r *http.Request) error {
+	userID, err := requestUserID(r)
+	if err != nil {
+		return err
+	}
+	var wait time.Duration
+	if value := r.URL.Query().Get("wait"); value != "" {
+		wait, err = time.ParseDuration(value)
+		if err != nil || wait < 0 {
+			return schedule.Errorf(schedule.ErrValidation, "invalid wait, expected a duration like 30s")
+		}
+		wait = min(wait, maxNextDoseWait)
+	}
+	loc, err := srv.userLocation(r, userID)
+	if err != nil {
+		return err
+	}
+
+	ctx := r.Context()
+	deadline := time.NewTimer(wait)
+	defer deadline.Stop()
+	known := r.Header.Get("If-None-Match")
+	for {
+		// registered before reading so a change in between is not missed
+		changed := srv.doseWaiters.wait(userID)
+		next, expires, err := srv.nextDose(ctx, userID, loc)
+		if err != nil {
+			srv.doseWaiters.cancel(userID, changed)
+			return err
+		}
+		body := convertToJson(next)
+		sum := sha256.Sum256([]byte(body))
+		etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+		if known == "" {
+			known = etag
+		}
+
+		if etag == known && wait > 0 && srv.waitForDoseChange(ctx, userID, changed, deadline, expires) {
+			continue
+		}
+		srv.doseWaiters.cancel(userID, changed)
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		w.Header().Set("ETag", etag)
+		if etag == r.Header.Get("If-None-Match") {
+			w.WriteHeader(http.StatusNotModified)
+			return nil
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, body)
+		return nil
+	}
+}
+
+// true when the next dose may have changed, false when the wait is over or the client went away
+func (srv *Server) waitForDoseChange(ctx context.Context, userID string, changed chan struct{}, deadline *time.Timer, expires time.Time) bool {
+	var expired <-chan time.Time
+	if !expires.IsZero() {
+		timer := time.NewTimer(time.Until(expires))
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	select {
+	case <-changed:
+		return true
+	case <-expired:
+		srv.doseWaiters.cancel(userID, changed)
+		return true
+	case <-deadline.C:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
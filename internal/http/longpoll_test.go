@@ -0,0 +1,80 @@
+package http
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func closed(ch chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestChangeWaiters(t *testing.T) {
+	waiters := newChangeWaiters()
+	first, second, other := waiters.wait("ada"), waiters.wait("ada"), waiters.wait("bob")
+
+	waiters.cancel("ada", second)
+	waiters.wake("ada")
+	if !closed(first) || closed(second) || closed(other) {
+		t.Fatalf("after waking ada: first %v, cancelled %v, other user %v", closed(first), closed(second), closed(other))
+	}
+	// a woken waiter is gone, waking again does not close it twice
+	waiters.wake("ada")
+	if len(waiters.waiting) != 1 {
+		t.Errorf("%d users waiting, want 1", len(waiters.waiting))
+	}
+
+	waiters.cancel("bob", other)
+	if len(waiters.waiting) != 0 {
+		t.Errorf("%d users waiting after the last cancel, want 0", len(waiters.waiting))
+	}
+
+	third, fourth := waiters.wait("ada"), waiters.wait("bob")
+	waiters.wakeAll()
+	if !closed(third) || !closed(fourth) || len(waiters.waiting) != 0 {
+		t.Errorf("after waking everyone: %v %v with %d users waiting", closed(third), closed(fourth), len(waiters.waiting))
+	}
+}
+
+func TestWaitForDoseChange(t *testing.T) {
+	srv := &Server{doseWaiters: newChangeWaiters()}
+	never := time.Now().Add(time.Hour)
+
+	tests := []struct {
+		name    string
+		change  bool
+		cancel  bool
+		wait    time.Duration
+		expires time.Time
+		want    bool
+	}{
+		{"schedule changed", true, false, time.Minute, never, true},
+		{"open dose became overdue", false, false, time.Minute, time.Now().Add(10 * time.Millisecond), true},
+		{"wait is over", false, false, 10 * time.Millisecond, time.Time{}, false},
+		{"client went away", false, true, time.Minute, never, false},
+	}
+	for _, test := range tests {
+		ctx, cancel := context.WithCancel(context.Background())
+		changed := srv.doseWaiters.wait("ada")
+		if test.change {
+			go srv.doseWaiters.wake("ada")
+		}
+		if test.cancel {
+			cancel()
+		}
+
+		deadline := time.NewTimer(test.wait)
+		if got := srv.waitForDoseChange(ctx, "ada", changed, deadline, test.expires); got != test.want {
+			t.Errorf("%s: waitForDoseChange = %v, want %v", test.name, got, test.want)
+		}
+		deadline.Stop()
+		cancel()
+		srv.doseWaiters.cancel("ada", changed)
+	}
+}
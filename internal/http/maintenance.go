@@ -0,0 +1,139 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"kode_test/internal/schedule"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// Retry-After sent while in maintenance unless the admin gave another one
+const defaultMaintenanceRetryAfter = 300
+
+// starts from MAINTENANCE_MODE and the stored state, and is switched by the admin endpoint on every instance
+type maintenanceSwitch struct {
+	sync.RWMutex
+	enabled    bool
+	retryAfter int
+}
+
+func newMaintenanceSwitch() *maintenanceSwitch {
+	return &maintenanceSwitch{enabled: os.Getenv("MAINTENANCE_MODE") == "true", retryAfter: defaultMaintenanceRetryAfter}
+}
+
+type MaintenanceState struct {
+	Enabled           bool `json:"enabled"`
+	RetryAfterSeconds int  `json:"retry_after_seconds"`
+}
+
+func (srv *Server) maintenanceState() MaintenanceState {
+	srv.maintenance.RLock()
+	defer srv.maintenance.RUnlock()
+
+	return MaintenanceState{Enabled: srv.maintenance.enabled, RetryAfterSeconds: srv.maintenance.retryAfter}
+}
+
+func (srv *Server) inMaintenance() bool {
+	return srv.maintenanceState().Enabled
+}
+
+// the answer to writes while in maintenance
+var errMaintenance = errors.New("the service is in maintenance, only reads are available")
+
+// while in maintenance only reads are served, /delete changes data despite being a GET.
+// The reads skip their bookkeeping writes, see inMaintenance.
+func (srv *Server) maintenanceGuard(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		state := srv.maintenanceState()
+		read := (r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions) && r.URL.Path != "/delete"
+		if !state.Enabled || read || r.URL.Path == "/v1/admin/maintenance" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("Retry-After", strconv.Itoa(state.RetryAfterSeconds))
+		writeError(w, r, errMaintenance)
+	})
+}
+
+func (srv *Server) getMaintenanceHandler(w http.ResponseWriter, r *http.Request) error {
+	fmt.Fprint(w, convertToJson(srv.maintenanceState()))
+	return nil
+}
+
+func (srv *Server) putMaintenanceHandler(w http.ResponseWriter, r *http.Request) error {
+	var state MaintenanceState
+	err := json.NewDecoder(r.Body).Decode(&state)
+	if err != nil {
+		return schedule.Errorf(schedule.ErrValidation, "invalid maintenance format")
+	}
+	if state.RetryAfterSeconds < 0 {
+		return schedule.FieldErrorf("retry_after_seconds", "min", "retry_after_seconds can not be negative")
+	}
+	if state.RetryAfterSeconds == 0 {
+		state.RetryAfterSeconds = defaultMaintenanceRetryAfter
+	}
+
+	srv.setMaintenanceState(state)
+
+	// instances started later read the stored switch, running ones switch on the notification
+	query := `INSERT INTO maintenance_state (enabled, retry_after_seconds) VALUES ($1, $2)
+		ON CONFLICT (id) DO UPDATE SET enabled = excluded.enabled, retry_after_seconds = excluded.retry_after_seconds, updated_at = now()`
+	_, err = srv.db.Exec(context.Background(), query, state.Enabled, state.RetryAfterSeconds)
+	if err != nil {
+		log.Printf("maintenance: failed store state: %v", err)
+	}
+	_, err = srv.db.Exec(context.Background(), "SELECT pg_notify('maintenance_changed', $1)", convertToJson(state))
+	if err != nil {
+		log.Printf("maintenance: failed notify other instances: %v", err)
+	}
+
+	fmt.Fprint(w, convertToJson(state))
+	return nil
+}
+
+func (srv *Server) setMaintenanceState(state MaintenanceState) {
+	srv.maintenance.Lock()
+	changed := srv.maintenance.enabled != state.Enabled
+	srv.maintenance.enabled, srv.maintenance.retryAfter = state.Enabled, state.RetryAfterSeconds
+	srv.maintenance.Unlock()
+	if changed {
+		log.Printf("maintenance mode enabled: %t", state.Enabled)
+	}
+}
+
+// applies the switch an admin stored last, MAINTENANCE_MODE=true keeps maintenance on regardless
+func (srv *Server) loadMaintenanceState(ctx context.Context) error {
+	var state MaintenanceState
+	err := srv.db.QueryRow(ctx, "SELECT enabled, retry_after_seconds FROM maintenance_state").Scan(&state.Enabled, &state.RetryAfterSeconds)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed get maintenance state from database: %w", err)
+	}
+	if os.Getenv("MAINTENANCE_MODE") == "true" {
+		state.Enabled = true
+	}
+
+	srv.setMaintenanceState(state)
+	return nil
+}
+
+func (srv *Server) applyMaintenanceNotification(payload string) {
+	var state MaintenanceState
+	err := json.Unmarshal([]byte(payload), &state)
+	if err != nil {
+		log.Printf("maintenance: invalid notification %q", payload)
+		return
+	}
+
+	srv.setMaintenanceState(state)
+}
@@ -0,0 +1,213 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"kode_test/internal/contract"
+	"kode_test/internal/schedule"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// the user the seeded schedules of mock mode belong to
+const MockUserID = "mock-user"
+
+// a stand-in for the API without Postgres or sign in, for frontend development. The schedule
+// endpoints work on seeded schedules kept in memory, every other operation of the OpenAPI document
+// at OPENAPI_SPEC answers with an example built from its schema. Changes are lost on restart.
+type MockServer struct {
+	mu        sync.Mutex
+	schedules []schedule.Schedule
+	nextID    int
+	doc       *contract.Document
+}
+
+func NewMockServer(schedules []schedule.Schedule) *MockServer {
+	mock := &MockServer{schedules: slices.Clone(schedules), nextID: 1}
+	for _, s := range schedules {
+		mock.nextID = max(mock.nextID, s.ID+1)
+	}
+	if path := os.Getenv("OPENAPI_SPEC"); path != "" {
+		doc, err := contract.Load(path)
+		if err != nil {
+			log.Printf("mock: %v, only the schedule endpoints are served", err)
+		}
+		mock.doc = doc
+	}
+
+	return mock
+}
+
+func (mock *MockServer) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/schedule", handleErrors(mock.scheduleHandler))
+	mux.HandleFunc("GET /schedules", handleErrors(mock.getSchedulesHandler))
+	mux.HandleFunc("GET /next_takings", handleErrors(mock.getNextTakingsHandler))
+	mux.HandleFunc("/", mock.exampleHandler)
+
+	return mux
+}
+
+func (mock *MockServer) scheduleHandler(w http.ResponseWriter, r *http.Request) error {
+	switch r.Method {
+	case http.MethodPost:
+		var s schedule.Schedule
+		err := json.NewDecoder(r.Body).Decode(&s)
+		if err != nil {
+			return schedule.Errorf(schedule.ErrValidation, "invalid schedule format")
+		}
+		s.Status = "active"
+		err = schedule.JoinFields(schedule.ValidateSchedule(s), normalizeScheduleDetails(&s))
+		if err != nil {
+			return err
+		}
+
+		mock.mu.Lock()
+		s.ID = mock.nextID
+		mock.nextID++
+		s.UUID = fmt.Sprintf("00000000-0000-4000-8000-%012d", s.ID)
+		s.Version = 1
+		s.CreatedAt = time.Now()
+		s.UpdatedAt = s.CreatedAt
+		mock.schedules = append(mock.schedules, s)
+		mock.mu.Unlock()
+
+		writeSaved(w, "schedule", s.ID, nil)
+		return nil
+	case http.MethodGet:
+		urlParams := r.URL.Query()
+		missingParamMessage := checkRequiredParams([]string{"user_id", "schedule_id"}, urlParams)
+		if missingParamMessage != "" {
+			return schedule.Errorf(schedule.ErrValidation, "%s", missingParamMessage)
+		}
+		id, _ := strconv.Atoi(urlParams.Get("schedule_id"))
+		schedules := mock.userSchedules(urlParams.Get("user_id"), "")
+		i := slices.IndexFunc(schedules, func(s schedule.Schedule) bool { return s.ID == id })
+		if i < 0 {
+			return schedule.Errorf(schedule.ErrNotFound, "schedule not found")
+		}
+		s := schedules[i]
+		progress := schedule.CourseProgress(s, time.Now(), mockLocation(r))
+		s.Progress = &progress
+
+		w.Header().Set("ETag", versionETag(s.Version))
+		fmt.Fprint(w, convertToJson(s))
+		return nil
+	}
+
+	http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	return nil
+}
+
+func (mock *MockServer) getSchedulesHandler(w http.ResponseWriter, r *http.Request) error {
+	urlParams := r.URL.Query()
+	missingParamMessage := checkRequiredParams([]string{"user_id"}, urlParams)
+	if missingParamMessage != "" {
+		return schedule.Errorf(schedule.ErrValidation, "%s", missingParamMessage)
+	}
+
+	schedules := mock.userSchedules(urlParams.Get("user_id"), urlParams.Get("status"))
+	if acceptedListFormat(r) != "" {
+		writeList(w, r, schedules)
+		return nil
+	}
+	if len(schedules) == 0 {
+		fmt.Fprintf(w, "no schedules for this user")
+		return nil
+	}
+	for _, s := range schedules {
+		fmt.Fprint(w, convertToJson(s))
+	}
+	return nil
+}
+
+// the doses of the next hours with the default day plan, in the timezone of tz or UTC
+func (mock *MockServer) getNextTakingsHandler(w http.ResponseWriter, r *http.Request) error {
+	urlParams := r.URL.Query()
+	missingParamMessage := checkRequiredParams([]string{"user_id"}, urlParams)
+	if missingParamMessage != "" {
+		return schedule.Errorf(schedule.ErrValidation, "%s", missingParamMessage)
+	}
+
+	loc := mockLocation(r)
+	plan := newDayPlan()
+	now := time.Now()
+	var takeSchedules []schedule.TakeSchedule
+	for _, s := range mock.userSchedules(urlParams.Get("user_id"), "active") {
+		local, doseTimes := plan.doseTimes(s, now, loc)
+		if !schedule.CheckDay(s, local, local.Location()) {
+			continue
+		}
+		takeSchedules = append(takeSchedules, schedule.PlannedTakings(s, local, doseTimes)...)
+	}
+	if acceptedListFormat(r) != "" {
+		writeList(w, r, takeSchedules)
+		return nil
+	}
+	if len(takeSchedules) == 0 {
+		fmt.Fprintf(w, "no schedules for the next %d hour/hours", schedule.PPH)
+		return nil
+	}
+	for _, takeSchedule := range takeSchedules {
+		fmt.Fprint(w, convertToJson(takeSchedule))
+	}
+	return nil
+}
+
+// the documented example of any other operation, 501 for what the document does not describe
+func (mock *MockServer) exampleHandler(w http.ResponseWriter, r *http.Request) {
+	if mock.doc != nil && r.URL.Path == "/v1/openapi.json" {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(mock.doc.Raw())
+		return
+	}
+	var status int
+	var body []byte
+	ok := false
+	if mock.doc != nil {
+		if op, found := mock.doc.Find(r.Method, r.URL.Path); found {
+			status, body, ok = op.Example()
+		}
+	}
+	if !ok {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotImplemented)
+		fmt.Fprint(w, convertToJson(ErrorEnvelope{Error: "not available in mock mode"}))
+		return
+	}
+
+	if body != nil {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+// the schedules of the user in id order, of one status when it is set
+func (mock *MockServer) userSchedules(userID string, status string) []schedule.Schedule {
+	mock.mu.Lock()
+	defer mock.mu.Unlock()
+
+	var schedules []schedule.Schedule
+	for _, s := range mock.schedules {
+		if s.UserID == userID && (status == "" || s.Status == status) {
+			schedules = append(schedules, s)
+		}
+	}
+
+	return schedules
+}
+
+func mockLocation(r *http.Request) *time.Location {
+	loc, err := time.LoadLocation(r.URL.Query().Get("tz"))
+	if err != nil {
+		return time.UTC
+	}
+
+	return loc
+}
@@ -0,0 +1,195 @@
+package http
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// formats a list can be written in besides JSON, picked from the Accept header
+var listFormats = []string{"text/csv", "text/plain", halMediaType}
+
+// the preferred of text/csv, text/plain and HAL, "" for JSON, which stays the default for */* and anything else
+func acceptedListFormat(r *http.Request) string {
+	best, bestQuality := "", 0.0
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		quality := 1.0
+		if q, ok := params["q"]; ok {
+			quality, err = strconv.ParseFloat(q, 64)
+			if err != nil {
+				continue
+			}
+		}
+		if mediaType == "application/json" && quality >= bestQuality {
+			best, bestQuality = "", quality
+		}
+		for _, format := range listFormats {
+			if mediaType == format && quality > bestQuality {
+				best, bestQuality = format, quality
+			}
+		}
+	}
+
+	return best
+}
+
+// writes items, a slice of structs, as JSON, or as one row per item when the client
+// accepts CSV or tab separated plain text. The columns are the json names of the fields,
+// nested structs become columns named parent.field. fields= limits both to some fields.
+func writeList(w http.ResponseWriter, r *http.Request, items interface{}) {
+	list := reflect.ValueOf(items)
+	fields, err := parseFields(r, reflect.Zero(list.Type().Elem()).Interface())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Add("Vary", "Accept")
+	format := acceptedListFormat(r)
+	if format == halMediaType {
+		writeHAL(w, halCollection(r, items, fields))
+		return
+	}
+	if format == "" {
+		w.Header().Set("Content-Type", "application/json")
+		if fields == nil {
+			fmt.Fprint(w, convertToJson(items))
+			return
+		}
+		selected := make([]interface{}, list.Len())
+		for i := range selected {
+			selected[i] = sparse(list.Index(i).Interface(), fields)
+		}
+		fmt.Fprint(w, convertToJson(selected))
+		return
+	}
+
+	var columns []tableColumn
+	for _, column := range tableColumns(list.Type().Elem(), "", nil) {
+		name, _, _ := strings.Cut(column.name, ".")
+		if fields == nil || fields[name] {
+			columns = append(columns, column)
+		}
+	}
+	header := make([]string, len(columns))
+	for i, column := range columns {
+		header[i] = column.name
+	}
+
+	records := [][]string{header}
+	for i := 0; i < list.Len(); i++ {
+		record := make([]string, len(columns))
+		for j, column := range columns {
+			record[j] = cellValue(list.Index(i), column.index, format == "text/plain")
+		}
+		records = append(records, record)
+	}
+
+	w.Header().Set("Content-Type", format+"; charset=utf-8")
+	if format == "text/plain" {
+		for _, record := range records {
+			fmt.Fprintln(w, strings.Join(record, "\t"))
+		}
+		return
+	}
+	csv.NewWriter(w).WriteAll(records)
+}
+
+type tableColumn struct {
+	name  string
+	index []int
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func tableColumns(t reflect.Type, prefix string, index []int) []tableColumn {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	var columns []tableColumn
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if !field.IsExported() || name == "-" {
+			continue
+		}
+		fieldIndex := append(append([]int{}, index...), i)
+		fieldType := field.Type
+		if fieldType.Kind() == reflect.Pointer {
+			fieldType = fieldType.Elem()
+		}
+
+		if fieldType.Kind() == reflect.Struct && fieldType != timeType {
+			nested := prefix
+			if !field.Anonymous {
+				nested = prefix + name + "."
+			}
+			columns = append(columns, tableColumns(fieldType, nested, fieldIndex)...)
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		columns = append(columns, tableColumn{name: prefix + name, index: fieldIndex})
+	}
+
+	return columns
+}
+
+// the field at index as text, empty when a pointer on the way is nil
+func cellValue(v reflect.Value, index []int, plain bool) string {
+	for _, i := range index {
+		for v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				return ""
+			}
+			v = v.Elem()
+		}
+		v = v.Field(i)
+	}
+	for v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return ""
+		}
+		v = v.Elem()
+	}
+
+	var value string
+	switch v.Kind() {
+	case reflect.String:
+		value = v.String()
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Float32, reflect.Float64:
+		value = fmt.Sprint(v.Interface())
+	case reflect.Struct:
+		if t := v.Interface().(time.Time); !t.IsZero() {
+			value = t.Format(time.RFC3339)
+		}
+	case reflect.Slice, reflect.Map:
+		// lists and maps stay JSON inside their cell
+		if v.Len() > 0 {
+			b, _ := json.Marshal(v.Interface())
+			value = string(b)
+		}
+	default:
+		b, _ := json.Marshal(v.Interface())
+		value = string(b)
+	}
+
+	// one line per row and no tabs inside a cell, so cut and awk split plain text right
+	if plain {
+		value = strings.NewReplacer("\t", " ", "\r", " ", "\n", " ").Replace(value)
+	}
+	return value
+}
@@ -0,0 +1,92 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAcceptedListFormat(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   string
+	}{
+		{"", ""},
+		{"*/*", ""},
+		{"application/json", ""},
+		{"text/csv", "text/csv"},
+		{"text/plain; charset=utf-8", "text/plain"},
+		{"text/html, text/csv;q=0.5", "text/csv"},
+		{"text/csv;q=0.5, text/plain;q=0.8", "text/plain"},
+		// JSON wins a tie, it is the default
+		{"text/csv, application/json", ""},
+		{"application/json;q=0.5, text/csv", "text/csv"},
+		{"text/csv;q=x", ""},
+		{"image/png", ""},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/schedules", nil)
+		r.Header.Set("Accept", test.accept)
+		if got := acceptedListFormat(r); got != test.want {
+			t.Errorf("Accept %q = %q, want %q", test.accept, got, test.want)
+		}
+	}
+}
+
+type listItem struct {
+	ID      int       `json:"id"`
+	Name    string    `json:"name"`
+	Note    *string   `json:"note,omitempty"`
+	Tags    []string  `json:"tags"`
+	Dose    *listDose `json:"dose,omitempty"`
+	Created time.Time `json:"created_at"`
+	secret  string
+}
+
+type listDose struct {
+	Value float64 `json:"value"`
+	Unit  string  `json:"unit"`
+}
+
+func TestWriteList(t *testing.T) {
+	note := "with food,\tthen\nwater"
+	items := []listItem{
+		{ID: 1, Name: "Ibuprofen", Note: &note, Tags: []string{"pain"}, Dose: &listDose{400, "mg"}, Created: time.Date(2026, 10, 16, 8, 0, 0, 0, time.UTC), secret: "x"},
+		{ID: 2, Name: "Vitamin D"},
+	}
+
+	tests := []struct {
+		accept          string
+		wantContentType string
+		want            string
+	}{
+		{"", "application/json",
+			`[{"id":1,"name":"Ibuprofen","note":"with food,\tthen\nwater","tags":["pain"],"dose":{"value":400,"unit":"mg"},"created_at":"2026-10-16T08:00:00Z"},` +
+				`{"id":2,"name":"Vitamin D","tags":null,"created_at":"0001-01-01T00:00:00Z"}]`},
+		{"text/csv", "text/csv; charset=utf-8",
+			"id,name,note,tags,dose.value,dose.unit,created_at\n" +
+				"1,Ibuprofen,\"with food,\tthen\nwater\",\"[\"\"pain\"\"]\",400,mg,2026-10-16T08:00:00Z\n" +
+				"2,Vitamin D,,,,,\n"},
+		{"text/plain", "text/plain; charset=utf-8",
+			"id\tname\tnote\ttags\tdose.value\tdose.unit\tcreated_at\n" +
+				"1\tIbuprofen\twith food, then water\t[\"pain\"]\t400\tmg\t2026-10-16T08:00:00Z\n" +
+				"2\tVitamin D\t\t\t\t\t\n"},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/schedules", nil)
+		r.Header.Set("Accept", test.accept)
+		recorder := httptest.NewRecorder()
+		writeList(recorder, r, items)
+
+		if got := recorder.Header().Get("Content-Type"); got != test.wantContentType {
+			t.Errorf("Accept %q: Content-Type %q, want %q", test.accept, got, test.wantContentType)
+		}
+		if got := recorder.Header().Get("Vary"); got != "Accept" {
+			t.Errorf("Accept %q: Vary %q, want Accept", test.accept, got)
+		}
+		if got := recorder.Body.String(); got != test.want {
+			t.Errorf("Accept %q: body\n%s\nwant\n%s", test.accept, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"kode_test/internal/schedule"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type ScheduleNotificationSettings struct {
+	ScheduleID int                           `json:"schedule_id"`
+	Settings   schedule.NotificationSettings `json:"settings"`
+}
+
+// the settings of the user and the schedules that replace them
+func (srv *Server) getNotificationSettingsHandler(w http.ResponseWriter, r *http.Request) error {
+	userID, err := pathUserID(r)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	settings := schedule.DefaultNotificationSettings()
+	err = srv.db.QueryRow(ctx, "SELECT settings FROM user_notification_settings WHERE user_id = $1", userID).Scan(&settings)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return fmt.Errorf("failed get notification settings from database: %w", err)
+	}
+
+	query := `SELECT n.schedule_id, n.settings FROM schedule_notification_settings n JOIN schedule s ON s.id = n.schedule_id
+		WHERE s.user_id = $1 ORDER BY n.schedule_id`
+	rows, err := srv.db.QueryRead(ctx, query, userID)
+	if err != nil {
+		return fmt.Errorf("failed get notification settings from database: %w", err)
+	}
+	overrides, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (ScheduleNotificationSettings, error) {
+		var override ScheduleNotificationSettings
+		err := row.Scan(&override.ScheduleID, &override.Settings)
+		return override, err
+	})
+	if err != nil {
+		return fmt.Errorf("failed get notification settings from database: %w", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, convertToJson(struct {
+		Settings  schedule.NotificationSettings  `json:"settings"`
+		Schedules []ScheduleNotificationSettings `json:"schedules"`
+	}{settings, overrides}))
+	return nil
+}
+
+func decodeNotificationSettings(r *http.Request) (schedule.NotificationSettings, error) {
+	var settings schedule.NotificationSettings
+	err := json.NewDecoder(r.Body).Decode(&settings)
+	if err != nil {
+		return settings, schedule.Errorf(schedule.ErrValidation, "invalid notification settings format")
+	}
+	if settings.Channels == nil {
+		settings.Channels = []string{}
+	}
+
+	return settings, settings.Validate()
+}
+
+func (srv *Server) putNotificationSettingsHandler(w http.ResponseWriter, r *http.Request) error {
+	userID, err := pathUserID(r)
+	if err != nil {
+		return err
+	}
+	settings, err := decodeNotificationSettings(r)
+	if err != nil {
+		return err
+	}
+
+	query := `INSERT INTO user_notification_settings (user_id, settings) VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET settings = EXCLUDED.settings, updated_at = now()`
+	_, err = srv.db.Exec(context.Background(), query, userID, settings)
+	if err != nil {
+		return fmt.Errorf("failed save notification settings: %w", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, convertToJson(settings))
+	return nil
+}
+
+// settings of one schedule that replace the user's settings as a whole
+func (srv *Server) putScheduleNotificationSettingsHandler(w http.ResponseWriter, r *http.Request) error {
+	settings, err := decodeNotificationSettings(r)
+	if err != nil {
+		return err
+	}
+	userID, err := requestUserID(r)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	scheduleID, err := srv.ownScheduleID(ctx, r.PathValue("id"), userID)
+	if err != nil {
+		return err
+	}
+
+	query := `INSERT INTO schedule_notification_settings (schedule_id, settings) VALUES ($1, $2)
+		ON CONFLICT (schedule_id) DO UPDATE SET settings = EXCLUDED.settings, updated_at = now()`
+	_, err = srv.db.Exec(ctx, query, scheduleID, settings)
+	if err != nil {
+		return fmt.Errorf("failed save notification settings: %w", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, convertToJson(ScheduleNotificationSettings{ScheduleID: scheduleID, Settings: settings}))
+	return nil
+}
+
+// the schedule follows the user's settings again
+func (srv *Server) deleteScheduleNotificationSettingsHandler(w http.ResponseWriter, r *http.Request) error {
+	userID, err := requestUserID(r)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	scheduleID, err := srv.ownScheduleID(ctx, r.PathValue("id"), userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = srv.db.Exec(ctx, "DELETE FROM schedule_notification_settings WHERE schedule_id = $1", scheduleID)
+	if err != nil {
+		return fmt.Errorf("failed delete notification settings: %w", err)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+const (
+	defaultNotificationsLimit = 100
+	maxNotificationsLimit     = 500
+)
+
+// a reminder that went out and whether its dose was confirmed since
+type Notification struct {
+	ID int64 `json:"id"`
+	// only set in the dead letter list, which spans all users
+	UserID           string     `json:"user_id,omitempty"`
+	ScheduleID       int        `json:"schedule_id"`
+	DoseID           string     `json:"dose_id"`
+	Attempt          int        `json:"attempt"`
+	Channel          string     `json:"channel"`
+	Status           string     `json:"status"`
+	SentAt           time.Time  `json:"sent_at"`
+	ProviderResponse *string    `json:"provider_response,omitempty"`
+	Error            *string    `json:"error,omitempty"`
+	Confirmed        bool       `json:"confirmed"`
+	ConfirmedAt      *time.Time `json:"confirmed_at,omitempty"`
+}
+
+// the reminders sent to the user, newest first, older pages with before=<sent_at of the last one>
+func (srv *Server) getNotificationsHandler(w http.ResponseWriter, r *http.Request) error {
+	userID, err := pathUserID(r)
+	if err != nil {
+		return err
+	}
+
+	urlParams := r.URL.Query()
+	limit := defaultNotificationsLimit
+	if value := urlParams.Get("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxNotificationsLimit {
+			return schedule.Errorf(schedule.ErrValidation, "limit must be between 1 and %d", maxNotificationsLimit)
+		}
+	}
+	before := time.Now().Add(time.Minute)
+	if value := urlParams.Get("before"); value != "" {
+		before, err = time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			return schedule.Errorf(schedule.ErrValidation, "invalid before, expected an RFC 3339 time")
+		}
+	}
+
+	query := `SELECT r.id, r.schedule_id, r.dose_id, r.attempt, r.channel, r.status, r.sent_at, r.provider_response, r.error, i.taken_at
+		FROM reminder r
+		LEFT JOIN LATERAL (SELECT min(taken_at) AS taken_at FROM intake_log WHERE schedule_id = r.schedule_id AND dose_id = r.dose_id) i ON true
+		WHERE r.user_id = $1 AND r.sent_at < $2
+		ORDER BY r.sent_at DESC, r.id DESC LIMIT $3`
+	rows, err := srv.db.QueryRead(context.Background(), query, userID, before, limit)
+	if err != nil {
+		return fmt.Errorf("failed get notifications from database: %w", err)
+	}
+	notifications, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (Notification, error) {
+		var n Notification
+		err := row.Scan(&n.ID, &n.ScheduleID, &n.DoseID, &n.Attempt, &n.Channel, &n.Status, &n.SentAt, &n.ProviderResponse, &n.Error, &n.ConfirmedAt)
+		n.Confirmed = n.ConfirmedAt != nil
+		return n, err
+	})
+	if err != nil {
+		return fmt.Errorf("failed get notifications from database: %w", err)
+	}
+
+	writeList(w, r, notifications)
+	return nil
+}
+
+// reminders that failed every delivery, oldest first
+func (srv *Server) getDeadLetterHandler(w http.ResponseWriter, r *http.Request) error {
+	query := `SELECT id, user_id, schedule_id, dose_id, attempt, channel, status, sent_at, provider_response, error
+		FROM reminder WHERE status = 'dead' ORDER BY sent_at, id LIMIT $1`
+	rows, err := srv.db.Query(context.Background(), query, maxNotificationsLimit)
+	if err != nil {
+		return fmt.Errorf("failed get notifications from database: %w", err)
+	}
+	notifications, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (Notification, error) {
+		var n Notification
+		err := row.Scan(&n.ID, &n.UserID, &n.ScheduleID, &n.DoseID, &n.Attempt, &n.Channel, &n.Status, &n.SentAt, &n.ProviderResponse, &n.Error)
+		return n, err
+	})
+	if err != nil {
+		return fmt.Errorf("failed get notifications from database: %w", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, convertToJson(notifications))
+	return nil
+}
+
+// queues a dead reminder for the reminder worker with a fresh set of deliveries
+func (srv *Server) replayNotificationHandler(w http.ResponseWriter, r *http.Request) error {
+	query := "UPDATE reminder SET status = 'failed', deliveries = 0, next_attempt_at = now() WHERE id::text = $1 AND status = 'dead'"
+	tag, err := srv.db.Exec(context.Background(), query, r.PathValue("id"))
+	if err != nil {
+		return fmt.Errorf("failed replay notification: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return schedule.Errorf(schedule.ErrNotFound, "dead notification not found")
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	fmt.Fprint(w, convertToJson(map[string]int64{"replayed": tag.RowsAffected()}))
+	return nil
+}
+
+// queues every dead reminder for the reminder worker, a dry run only counts them
+func (srv *Server) ReplayDeadLetters(ctx context.Context, dryRun bool) (int64, error) {
+	if dryRun {
+		var dead int64
+		err := srv.db.QueryRow(ctx, "SELECT count(*) FROM reminder WHERE status = 'dead'").Scan(&dead)
+		return dead, err
+	}
+
+	query := "UPDATE reminder SET status = 'failed', deliveries = 0, next_attempt_at = now() WHERE status = 'dead'"
+	tag, err := srv.db.Exec(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+
+	return tag.RowsAffected(), nil
+}
+
+func (srv *Server) replayDeadLetterHandler(w http.ResponseWriter, r *http.Request) error {
+	replayed, err := srv.ReplayDeadLetters(context.Background(), false)
+	if err != nil {
+		return fmt.Errorf("failed replay notifications: %w", err)
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	fmt.Fprint(w, convertToJson(map[string]int64{"replayed": replayed}))
+	return nil
+}
@@ -0,0 +1,195 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"kode_test/internal/schedule"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+const oauthCodeTTL = 5 * time.Minute
+
+// a linked app, the secret is only returned when the client is created
+type OAuthClient struct {
+	ID           string    `json:"id"`
+	Name         string    `json:"name"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	Scopes       []string  `json:"scopes"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// registers an app that may link accounts, like a smart speaker skill
+func (srv *Server) createOAuthClientHandler(w http.ResponseWriter, r *http.Request) error {
+	var client OAuthClient
+	err := json.NewDecoder(r.Body).Decode(&client)
+	if err != nil {
+		return schedule.Errorf(schedule.ErrValidation, "invalid oauth client format")
+	}
+	var errs []error
+	if strings.TrimSpace(client.Name) == "" {
+		errs = append(errs, schedule.FieldErrorf("name", "required", "name is required"))
+	}
+	if len(client.RedirectURIs) == 0 {
+		errs = append(errs, schedule.FieldErrorf("redirect_uris", "required", "redirect_uris are required"))
+	}
+	for i, redirectURI := range client.RedirectURIs {
+		u, err := url.Parse(redirectURI)
+		if err != nil || u.Scheme != "https" || u.Host == "" {
+			errs = append(errs, schedule.FieldErrorf(fmt.Sprintf("redirect_uris[%d]", i), "format", "redirect_uris must be https URLs"))
+		}
+	}
+	errs = append(errs, validScopes(client.Scopes))
+	err = schedule.JoinFields(errs...)
+	if err != nil {
+		return err
+	}
+
+	client.ID = randomHex(12)
+	secret := randomHex(32)
+	query := "INSERT INTO oauth_client (id, name, secret_hash, redirect_uris, scopes) VALUES ($1, $2, $3, $4, $5) RETURNING created_at"
+	err = srv.db.QueryRow(context.Background(), query, client.ID, client.Name, hashToken(secret), client.RedirectURIs, client.Scopes).Scan(&client.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed save oauth client: %w", err)
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	fmt.Fprint(w, convertToJson(struct {
+		OAuthClient
+		Secret string `json:"secret"`
+	}{client, secret}))
+	return nil
+}
+
+// the consent step of the authorization code grant. The web app shows the consent page to the
+// signed in user and calls this, then sends the browser on to the returned redirect_uri.
+func (srv *Server) authorizeOAuthHandler(w http.ResponseWriter, r *http.Request) error {
+	var body struct {
+		ClientID     string `json:"client_id"`
+		RedirectURI  string `json:"redirect_uri"`
+		ResponseType string `json:"response_type"`
+		Scope        string `json:"scope"`
+		State        string `json:"state"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil || body.ResponseType != "code" {
+		return schedule.Errorf(schedule.ErrValidation, "invalid authorization request, only response_type code is supported")
+	}
+
+	var redirectURIs, allowed []string
+	query := "SELECT redirect_uris, scopes FROM oauth_client WHERE id = $1"
+	err = srv.db.QueryRow(context.Background(), query, body.ClientID).Scan(&redirectURIs, &allowed)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return schedule.Errorf(schedule.ErrValidation, "unknown client_id")
+	}
+	if err != nil {
+		return fmt.Errorf("failed get oauth client from database: %w", err)
+	}
+	// an unregistered redirect would hand the code to someone else, so it is never followed
+	if !slices.Contains(redirectURIs, body.RedirectURI) {
+		return schedule.Errorf(schedule.ErrValidation, "redirect_uri is not registered for the client")
+	}
+	scopes := allowed
+	if body.Scope != "" {
+		scopes = strings.Fields(body.Scope)
+		for _, scope := range scopes {
+			if !slices.Contains(allowed, scope) {
+				return schedule.Errorf(schedule.ErrValidation, "scope not allowed for the client: %s", scope)
+			}
+		}
+	}
+
+	code := randomHex(32)
+	query = "INSERT INTO oauth_code (code_hash, client_id, user_id, redirect_uri, scopes, expires_at) VALUES ($1, $2, $3, $4, $5, $6)"
+	_, err = srv.db.Exec(context.Background(), query, hashToken(code), body.ClientID, currentUserID(r), body.RedirectURI, scopes, time.Now().Add(oauthCodeTTL))
+	if err != nil {
+		return fmt.Errorf("failed save authorization code: %w", err)
+	}
+
+	redirect, _ := url.Parse(body.RedirectURI)
+	params := redirect.Query()
+	params.Set("code", code)
+	if body.State != "" {
+		params.Set("state", body.State)
+	}
+	redirect.RawQuery = params.Encode()
+
+	fmt.Fprint(w, convertToJson(map[string]string{"redirect_uri": redirect.String()}))
+	return nil
+}
+
+// errors of the token endpoint in the shape of RFC 6749, which skill platforms parse,
+// failures of the server itself get the usual envelope with the same error key
+func oauthError(w http.ResponseWriter, status int, code string, description string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	fmt.Fprint(w, convertToJson(map[string]string{"error": code, "error_description": description}))
+}
+
+// exchanges an authorization code for an API token with the granted scopes. The token does not
+// expire, unlinking the app or revoking it under /v1/tokens ends the link.
+func (srv *Server) oauthTokenHandler(w http.ResponseWriter, r *http.Request) error {
+	err := r.ParseForm()
+	if err != nil {
+		oauthError(w, http.StatusBadRequest, "invalid_request", "expected a form body")
+		return nil
+	}
+	if r.PostForm.Get("grant_type") != "authorization_code" {
+		oauthError(w, http.StatusBadRequest, "unsupported_grant_type", "only authorization_code is supported")
+		return nil
+	}
+	clientID, secret, ok := r.BasicAuth()
+	if !ok {
+		clientID, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+
+	ctx := context.Background()
+	var name string
+	err = srv.db.QueryRow(ctx, "SELECT name FROM oauth_client WHERE id = $1 AND secret_hash = $2", clientID, hashToken(secret)).Scan(&name)
+	if errors.Is(err, pgx.ErrNoRows) {
+		oauthError(w, http.StatusUnauthorized, "invalid_client", "unknown client or wrong secret")
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed get oauth client from database: %w", err)
+	}
+
+	apiToken := APIToken{ID: randomHex(8), Name: "Linked: " + name}
+	token := apiTokenPrefix + randomHex(32)
+	err = srv.db.InTx(ctx, func(tx pgx.Tx) error {
+		var userID string
+		query := `UPDATE oauth_code SET used_at = now()
+			WHERE code_hash = $1 AND client_id = $2 AND redirect_uri = $3 AND used_at IS NULL AND expires_at > now()
+			RETURNING user_id, scopes`
+		err := tx.QueryRow(ctx, query, hashToken(r.PostForm.Get("code")), clientID, r.PostForm.Get("redirect_uri")).Scan(&userID, &apiToken.Scopes)
+		if err != nil {
+			return err
+		}
+
+		query = "INSERT INTO api_token (id, user_id, name, token_hash, scopes) VALUES ($1, $2, $3, $4, $5)"
+		_, err = tx.Exec(ctx, query, apiToken.ID, userID, apiToken.Name, hashToken(token), apiToken.Scopes)
+		return err
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		oauthError(w, http.StatusBadRequest, "invalid_grant", "the code is invalid, expired or used")
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed issue token: %w", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	fmt.Fprint(w, convertToJson(map[string]string{
+		"access_token": token,
+		"token_type":   "Bearer",
+		"scope":        strings.Join(apiToken.Scopes, " "),
+	}))
+	return nil
+}
@@ -0,0 +1,128 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"kode_test/internal/schedule"
+	"net/http"
+	"time"
+)
+
+const (
+	// how far from its planned time an intake counts as on time, unless the user or their organization set it
+	defaultOnTimeMinutes = 60
+	maxOnTimeMinutes     = 720
+)
+
+// the on time window of a user and where it comes from: user, organization or default.
+// Minutes left out or null on a change go back to the organization's or the default window.
+type OnTimeWindow struct {
+	Minutes *int   `json:"minutes"`
+	Source  string `json:"source,omitempty"`
+}
+
+func validOnTimeMinutes(minutes *int) error {
+	if minutes != nil && *minutes < 0 {
+		return schedule.FieldErrorf("minutes", "min", "minutes must be between 0 and %d", maxOnTimeMinutes)
+	}
+	if minutes != nil && *minutes > maxOnTimeMinutes {
+		return schedule.FieldErrorf("minutes", "max", "minutes must be between 0 and %d", maxOnTimeMinutes)
+	}
+
+	return nil
+}
+
+// the window of the user, or of the first organization they joined as a patient that has one
+func (srv *Server) onTimeWindow(ctx context.Context, userID string) (OnTimeWindow, error) {
+	var own, org *int
+	query := `SELECT u.on_time_minutes, (SELECT o.on_time_minutes FROM org_member m JOIN organization o ON o.id = m.org_id
+			WHERE m.user_id = u.id AND m.role = 'patient' AND o.on_time_minutes IS NOT NULL ORDER BY m.created_at LIMIT 1)
+		FROM users u WHERE u.id = $1`
+	err := srv.db.QueryRow(ctx, query, userID).Scan(&own, &org)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return OnTimeWindow{}, fmt.Errorf("failed get on time window from database: %w", err)
+	}
+
+	switch {
+	case own != nil:
+		return OnTimeWindow{Minutes: own, Source: "user"}, nil
+	case org != nil:
+		return OnTimeWindow{Minutes: org, Source: "organization"}, nil
+	}
+	minutes := defaultOnTimeMinutes
+	return OnTimeWindow{Minutes: &minutes, Source: "default"}, nil
+}
+
+func (window OnTimeWindow) duration() time.Duration {
+	return time.Duration(*window.Minutes) * time.Minute
+}
+
+func (srv *Server) getOnTimeWindowHandler(w http.ResponseWriter, r *http.Request) error {
+	userID, err := pathUserID(r)
+	if err != nil {
+		return err
+	}
+	window, err := srv.onTimeWindow(r.Context(), userID)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, convertToJson(window))
+	return nil
+}
+
+func (srv *Server) putOnTimeWindowHandler(w http.ResponseWriter, r *http.Request) error {
+	userID, err := pathUserID(r)
+	if err != nil {
+		return err
+	}
+	var change OnTimeWindow
+	err = json.NewDecoder(r.Body).Decode(&change)
+	if err != nil {
+		return schedule.Errorf(schedule.ErrValidation, "invalid on time window format")
+	}
+	err = validOnTimeMinutes(change.Minutes)
+	if err != nil {
+		return err
+	}
+
+	tag, err := srv.db.Exec(r.Context(), "UPDATE users SET on_time_minutes = $2 WHERE id = $1", userID, change.Minutes)
+	if err != nil {
+		return fmt.Errorf("failed save on time window: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return schedule.Errorf(schedule.ErrNotFound, "user not found")
+	}
+
+	return srv.getOnTimeWindowHandler(w, r)
+}
+
+// the window of the organization's patients who did not set their own, staff only
+func (srv *Server) putOrganizationOnTimeWindowHandler(w http.ResponseWriter, r *http.Request) error {
+	org, err := srv.staffOrganization(r.Context(), r)
+	if err != nil {
+		return err
+	}
+	var change OnTimeWindow
+	err = json.NewDecoder(r.Body).Decode(&change)
+	if err != nil {
+		return schedule.Errorf(schedule.ErrValidation, "invalid on time window format")
+	}
+	err = validOnTimeMinutes(change.Minutes)
+	if err != nil {
+		return err
+	}
+
+	_, err = srv.db.Exec(r.Context(), "UPDATE organization SET on_time_minutes = $2 WHERE id::text = $1", org.ID, change.Minutes)
+	if err != nil {
+		return fmt.Errorf("failed save on time window: %w", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, convertToJson(OnTimeWindow{Minutes: change.Minutes, Source: "organization"}))
+	return nil
+}
@@ -0,0 +1,48 @@
+package http
+
+import (
+	"errors"
+	"kode_test/internal/schedule"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidOnTimeMinutes(t *testing.T) {
+	minutes := func(m int) *int { return &m }
+	tests := []struct {
+		minutes  *int
+		wantRule string
+	}{
+		{nil, ""},
+		{minutes(0), ""},
+		{minutes(maxOnTimeMinutes), ""},
+		{minutes(-1), "min"},
+		{minutes(maxOnTimeMinutes + 1), "max"},
+	}
+	for _, test := range tests {
+		err := validOnTimeMinutes(test.minutes)
+		fields := schedule.Fields(err)
+		if test.wantRule == "" && err != nil || test.wantRule != "" && (len(fields) != 1 || fields[0].Field != "minutes" || fields[0].Rule != test.wantRule) {
+			t.Errorf("validOnTimeMinutes(%v) = %v with %+v", test.minutes, err, fields)
+		}
+	}
+
+	if d := (OnTimeWindow{Minutes: minutes(45)}).duration(); d != 45*time.Minute {
+		t.Errorf("duration = %s", d)
+	}
+}
+
+func TestPutOnTimeWindowValidation(t *testing.T) {
+	srv := &Server{}
+	for _, body := range []string{`{"minutes":`, `{"minutes": "an hour"}`, `{"minutes": 721}`} {
+		r := httptest.NewRequest(http.MethodPut, "/v1/users/ada/on-time-window", strings.NewReader(body))
+		r.SetPathValue("id", "ada")
+		err := srv.putOnTimeWindowHandler(httptest.NewRecorder(), r)
+		if !errors.Is(err, schedule.ErrValidation) {
+			t.Errorf("put of %s = %v", body, err)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"kode_test/internal/schedule"
+	"net/http"
+	"strings"
+)
+
+type Organization struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+func (srv *Server) createOrganizationHandler(w http.ResponseWriter, r *http.Request) error {
+	var org Organization
+	err := json.NewDecoder(r.Body).Decode(&org)
+	if err != nil {
+		return schedule.Errorf(schedule.ErrValidation, "invalid organization format")
+	}
+	org.Name = strings.TrimSpace(org.Name)
+	if org.Name == "" {
+		return schedule.FieldErrorf("name", "required", "name is required")
+	}
+
+	err = srv.db.QueryRow(context.Background(), "INSERT INTO organization (name) VALUES ($1) RETURNING id::text", org.Name).Scan(&org.ID)
+	if err != nil {
+		return fmt.Errorf("failed save organization in database: %w", err)
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	fmt.Fprint(w, convertToJson(org))
+	return nil
+}
+
+// makes the user staff of the organization, staff onboard the organization's patients
+func (srv *Server) putOrganizationStaffHandler(w http.ResponseWriter, r *http.Request) error {
+	query := `INSERT INTO org_member (org_id, user_id, role) SELECT id, $2, 'staff' FROM organization WHERE id::text = $1
+		ON CONFLICT (org_id, user_id) DO UPDATE SET role = 'staff'`
+	tag, err := srv.db.Exec(context.Background(), query, r.PathValue("id"), r.PathValue("user_id"))
+	if err != nil {
+		return fmt.Errorf("failed save staff in database: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return schedule.Errorf(schedule.ErrNotFound, "organization not found")
+	}
+
+	fmt.Fprintf(w, "staff saved")
+	return nil
+}
+
+// the organization of the path, users who are not its staff do not find it
+func (srv *Server) staffOrganization(ctx context.Context, r *http.Request) (Organization, error) {
+	var org Organization
+	query := `SELECT o.id::text, o.name FROM organization o JOIN org_member m ON m.org_id = o.id
+		WHERE o.id::text = $1 AND m.user_id = $2 AND m.role = 'staff'`
+	err := srv.db.QueryRow(ctx, query, r.PathValue("id"), currentUserID(r)).Scan(&org.ID, &org.Name)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return org, schedule.Errorf(schedule.ErrNotFound, "organization not found")
+	}
+
+	return org, err
+}
@@ -0,0 +1,80 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"kode_test/internal/schedule"
+	"kode_test/internal/storage"
+	"net/http"
+	"time"
+)
+
+type ScheduleConflict struct {
+	ID        int       `json:"id"`
+	Medicine  string    `json:"medicine"`
+	Frequency int       `json:"frequency"`
+	Duration  int       `json:"duration"`
+	CreatedAt time.Time `json:"created_at"`
+	Identical bool      `json:"identical"`
+}
+
+// finds active schedules of the same medicine whose course overlaps the given one,
+// a frequency of 0 means the course never ends
+func (srv *Server) findOverlappingSchedules(schedule schedule.Schedule, start time.Time) ([]ScheduleConflict, error) {
+	query := `SELECT id, medicine, frequency, duration, created_at FROM schedule
+		WHERE user_id = $1 AND medicine_hash = $2 AND status = 'active'
+		AND ($4 = 0 OR created_at::date < $3::date + $4)
+		AND (frequency = 0 OR created_at::date + frequency > $3::date)
+		AND id <> $5`
+	rows, err := srv.db.Query(context.Background(), query, schedule.UserID, storage.MedicineHash(schedule.Medicine), start, schedule.Frequency, schedule.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var conflicts []ScheduleConflict
+	for rows.Next() {
+		var conflict ScheduleConflict
+		err := rows.Scan(&conflict.ID, &conflict.Medicine, &conflict.Frequency, &conflict.Duration, &conflict.CreatedAt)
+		if err == nil {
+			conflict.Medicine, err = srv.cipher.Decrypt(conflict.Medicine)
+		}
+		if err != nil {
+			return nil, err
+		}
+		conflict.Identical = conflict.Frequency == schedule.Frequency && conflict.Duration == schedule.Duration
+		conflicts = append(conflicts, conflict)
+	}
+
+	return conflicts, rows.Err()
+}
+
+// overlapping schedules of the same medicine, answered with 409 and the schedules
+type overlapError struct {
+	conflicts []ScheduleConflict
+}
+
+func (e *overlapError) Error() string {
+	return "overlapping schedule for this medicine already exists, repeat with force=true to save anyway"
+}
+
+func (e *overlapError) Unwrap() error {
+	return schedule.ErrConflict
+}
+
+// fails with the conflicting schedules unless the request has force=true
+func (srv *Server) checkOverlap(r *http.Request, s schedule.Schedule, start time.Time) error {
+	if r.URL.Query().Get("force") == "true" {
+		return nil
+	}
+
+	conflicts, err := srv.findOverlappingSchedules(s, start)
+	if err != nil {
+		return fmt.Errorf("failed check existing schedules: %w", err)
+	}
+	if len(conflicts) > 0 {
+		return &overlapError{conflicts: conflicts}
+	}
+
+	return nil
+}
@@ -0,0 +1,278 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"errors"
+	"github.com/jackc/pgx/v5"
+	"html/template"
+	"kode_test/internal/schedule"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// the plain HTML pages work without JavaScript, they are signed in with a cookie
+// that holds the refresh token of a session of their own
+const pageCookie = "scheduler_session"
+
+//go:embed templates
+var templateFiles embed.FS
+
+var pageTemplates = map[string]*template.Template{
+	"login":     parsePage("login.html"),
+	"today":     parsePage("today.html"),
+	"schedules": parsePage("schedules.html"),
+	"error":     parsePage("error.html"),
+}
+
+func parsePage(name string) *template.Template {
+	funcs := template.FuncMap{"plain": schedule.PlainInstructions}
+	return template.Must(template.New(name).Funcs(funcs).ParseFS(templateFiles, "templates/layout.html", "templates/"+name))
+}
+
+type pageData struct {
+	Title     string
+	SignedIn  bool
+	Notice    string
+	Error     string
+	Email     string
+	Date      string
+	Doses     []pageDose
+	Schedules []schedule.Schedule
+}
+
+type pageDose struct {
+	DoseID   string
+	Medicine string
+	Time     string
+	Due      bool
+}
+
+type pageHandler func(w http.ResponseWriter, r *http.Request, userID string) error
+
+// runs next for the user of the page cookie, anyone else is sent to the login page
+func (srv *Server) page(next pageHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost && !sameOrigin(r) {
+			renderPageError(w, r, schedule.Errorf(schedule.ErrForbidden, "the form was sent from another site"))
+			return
+		}
+
+		userID, _, err := srv.pageSession(r.Context(), r)
+		if err != nil {
+			renderPageError(w, r, err)
+			return
+		}
+		if userID == "" && localRequest(r) {
+			userID = localUserID
+		}
+		if userID == "" {
+			http.Redirect(w, r, "/html/login", http.StatusSeeOther)
+			return
+		}
+		recordCaller(r.Context(), userID, "")
+
+		err = next(w, r, userID)
+		if err != nil {
+			renderPageError(w, r, err)
+		}
+	}
+}
+
+// the user and session of the page cookie, empty when there is none or it was revoked
+func (srv *Server) pageSession(ctx context.Context, r *http.Request) (string, string, error) {
+	cookie, err := r.Cookie(pageCookie)
+	if err != nil {
+		return "", "", nil
+	}
+
+	var userID, sessionID string
+	query := `UPDATE auth_session SET last_used_at = now() WHERE refresh_token_hash = $1 AND revoked_at IS NULL AND expires_at > now() RETURNING user_id, id`
+	if srv.inMaintenance() {
+		// reads write nothing in maintenance, last_used_at is only a hint and moves on the next use
+		query = `SELECT user_id, id FROM auth_session WHERE refresh_token_hash = $1 AND revoked_at IS NULL AND expires_at > now()`
+	}
+	err = srv.db.QueryRow(ctx, query, hashToken(cookie.Value)).Scan(&userID, &sessionID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", "", nil
+	}
+	if err != nil {
+		return "", "", err
+	}
+
+	return userID, sessionID, nil
+}
+
+// SameSite keeps the cookie off cross-site posts, browsers too old for it still send Origin
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == r.Host
+}
+
+func setPageCookie(w http.ResponseWriter, value string, maxAge int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     pageCookie,
+		Value:    value,
+		Path:     "/html",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(appURL(), "https://"),
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+func renderPage(w http.ResponseWriter, status int, name string, data pageData) {
+	var page bytes.Buffer
+	err := pageTemplates[name].ExecuteTemplate(&page, "layout.html", data)
+	if err != nil {
+		log.Printf("render page %s: %v", name, err)
+		http.Error(w, "failed render page", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	page.WriteTo(w)
+}
+
+func renderPageError(w http.ResponseWriter, r *http.Request, err error) {
+	status := errorStatus(err)
+	message := err.Error()
+	if status == http.StatusInternalServerError {
+		log.Printf("%s %s: %v", r.Method, r.URL.Path, err)
+		message = "Something went wrong, please try again."
+	}
+
+	renderPage(w, status, "error", pageData{Title: "Error", SignedIn: true, Error: message})
+}
+
+func (srv *Server) loginPageHandler(w http.ResponseWriter, r *http.Request) {
+	renderPage(w, http.StatusOK, "login", pageData{Title: "Log in"})
+}
+
+func (srv *Server) loginFormHandler(w http.ResponseWriter, r *http.Request) {
+	credentials := Credentials{Email: r.PostFormValue("email"), Password: r.PostFormValue("password"), OTP: r.PostFormValue("otp")}
+	ctx := context.Background()
+
+	userID, err := srv.checkLogin(ctx, credentials)
+	if errors.Is(err, errInvalidLogin) || errors.Is(err, errSecondFactor) {
+		message := "The email or password is wrong."
+		if errors.Is(err, errSecondFactor) {
+			message = "Enter the code from your authenticator app."
+		}
+		renderPage(w, http.StatusUnauthorized, "login", pageData{Title: "Log in", Error: message, Email: credentials.Email})
+		return
+	}
+	if errors.Is(err, schedule.ErrTooManyAttempts) {
+		message := "Too many wrong codes, try again in a few minutes."
+		renderPage(w, http.StatusTooManyRequests, "login", pageData{Title: "Log in", Error: message, Email: credentials.Email})
+		return
+	}
+	if err != nil {
+		renderPageError(w, r, err)
+		return
+	}
+
+	_, refreshToken, err := srv.createSession(ctx, r, userID)
+	if err != nil {
+		renderPageError(w, r, err)
+		return
+	}
+
+	setPageCookie(w, refreshToken, int(refreshTokenTTL.Seconds()))
+	http.Redirect(w, r, "/html/today", http.StatusSeeOther)
+}
+
+func (srv *Server) logoutPageHandler(w http.ResponseWriter, r *http.Request, userID string) error {
+	_, sessionID, err := srv.pageSession(r.Context(), r)
+	if err != nil {
+		return err
+	}
+	_, err = srv.db.Exec(context.Background(), "UPDATE auth_session SET revoked_at = now() WHERE id = $1 AND revoked_at IS NULL", sessionID)
+	if err != nil {
+		return err
+	}
+
+	setPageCookie(w, "", -1)
+	http.Redirect(w, r, "/html/login", http.StatusSeeOther)
+	return nil
+}
+
+// the doses of today that are not taken yet, each with a button to record it
+func (srv *Server) todayPageHandler(w http.ResponseWriter, r *http.Request, userID string) error {
+	loc, err := srv.userLocation(r, userID)
+	if err != nil {
+		return err
+	}
+	now := time.Now().In(loc)
+	year, month, day := now.Date()
+	midnight := time.Date(year, month, day, 0, 0, 0, 0, loc)
+
+	doses, err := srv.openDoses(context.Background(), userID, loc, now, midnight)
+	if err != nil {
+		return err
+	}
+
+	data := pageData{Title: "Today", SignedIn: true, Date: now.Format("Monday 2 January")}
+	for _, dose := range doses {
+		if !dose.at.Before(midnight.AddDate(0, 0, 1)) {
+			break
+		}
+		data.Doses = append(data.Doses, pageDose{DoseID: dose.doseID, Medicine: dose.medicine, Time: dose.at.Format("15:04"), Due: !dose.at.After(now)})
+	}
+	if r.URL.Query().Get("recorded") != "" {
+		data.Notice = "The dose was recorded."
+	}
+
+	renderPage(w, http.StatusOK, "today", data)
+	return nil
+}
+
+func (srv *Server) intakePageHandler(w http.ResponseWriter, r *http.Request, userID string) error {
+	doseID := r.PostFormValue("dose_id")
+	scheduleID, _, slot, err := schedule.ParseDoseID(doseID)
+	if err != nil {
+		return err
+	}
+
+	intake := Intake{ScheduleID: scheduleID, DoseID: doseID, UserID: userID, TakenAt: time.Now()}
+	_, issues, err := srv.recordIntake(context.Background(), intake, slot)
+	if errors.Is(err, errUnsafeIntake) {
+		return schedule.Errorf(schedule.ErrConflict, "%s", issues[0].Message)
+	}
+	if err != nil {
+		return err
+	}
+
+	http.Redirect(w, r, "/html/today?recorded=1", http.StatusSeeOther)
+	return nil
+}
+
+func (srv *Server) schedulesPageHandler(w http.ResponseWriter, r *http.Request, userID string) error {
+	loc, err := srv.userLocation(r, userID)
+	if err != nil {
+		return err
+	}
+
+	schedules, err := srv.ListUserSchedules(context.Background(), userID, "", time.Time{}, nil)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for i := range schedules {
+		progress := schedule.CourseProgress(schedules[i], now, loc)
+		schedules[i].Progress = &progress
+	}
+
+	renderPage(w, http.StatusOK, "schedules", pageData{Title: "Schedules", SignedIn: true, Schedules: schedules})
+	return nil
+}
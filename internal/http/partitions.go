@@ -0,0 +1,131 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+const partitionJobInterval = 24 * time.Hour
+
+// monthly intake_log partitions are created this many months ahead
+const intakePartitionsAhead = 3
+
+// default age in days after which notifications of finished recalls are deleted
+const defaultNotificationRetentionDays = 365
+
+// retention settings:
+// INTAKE_RETENTION_MONTHS keeps that many whole months of intakes, 0 or unset keeps everything,
+// INTAKE_RETENTION_ARCHIVE=true detaches old partitions as standalone tables instead of dropping them,
+// NOTIFICATION_RETENTION_DAYS is the age after which notifications of finished recalls are deleted
+func retentionConfig() (int, bool, int, error) {
+	months, days := 0, defaultNotificationRetentionDays
+	var err error
+	if value := os.Getenv("INTAKE_RETENTION_MONTHS"); value != "" {
+		months, err = strconv.Atoi(value)
+		if err != nil || months < 0 {
+			return 0, false, 0, fmt.Errorf("invalid INTAKE_RETENTION_MONTHS %q", value)
+		}
+	}
+	if value := os.Getenv("NOTIFICATION_RETENTION_DAYS"); value != "" {
+		days, err = strconv.Atoi(value)
+		if err != nil || days < 1 {
+			return 0, false, 0, fmt.Errorf("invalid NOTIFICATION_RETENTION_DAYS %q", value)
+		}
+	}
+
+	return months, os.Getenv("INTAKE_RETENTION_ARCHIVE") == "true", days, nil
+}
+
+// drops or detaches the intake partitions whose whole month is older than the retention
+func (srv *Server) expireIntakePartitions(ctx context.Context, months int, archive bool) error {
+	year, month, _ := time.Now().UTC().Date()
+	cutoff := time.Date(year, month, 1, 0, 0, 0, 0, time.UTC).AddDate(0, -months, 0)
+
+	query := `SELECT c.relname FROM pg_inherits i
+		JOIN pg_class c ON c.oid = i.inhrelid JOIN pg_class p ON p.oid = i.inhparent
+		WHERE p.relname = 'intake_log' AND c.relname LIKE 'intake_log_y%'`
+	rows, err := srv.db.Query(ctx, query)
+	if err != nil {
+		return err
+	}
+	var partitions []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		partitions = append(partitions, name)
+	}
+	rows.Close()
+
+	for _, name := range partitions {
+		start, err := time.Parse("intake_log_y2006m01", name)
+		if err != nil || start.AddDate(0, 1, 0).After(cutoff) {
+			continue
+		}
+
+		statement, action := "DROP TABLE "+name, "dropped"
+		if archive {
+			// the detached table stays for archiving outside of the service
+			statement, action = "ALTER TABLE intake_log DETACH PARTITION "+name, "detached"
+		}
+		_, err = srv.db.Exec(ctx, statement)
+		if err != nil {
+			return err
+		}
+		log.Printf("partition job: %s %s", action, name)
+	}
+
+	return nil
+}
+
+func (srv *Server) MaintainPartitions(ctx context.Context) error {
+	months, archive, notificationDays, err := retentionConfig()
+	if err != nil {
+		return err
+	}
+
+	_, err = srv.db.Exec(ctx, "SELECT ensure_intake_partitions(now()::date, $1)", intakePartitionsAhead)
+	if err != nil {
+		return err
+	}
+
+	if months > 0 {
+		err = srv.expireIntakePartitions(ctx, months, archive)
+		if err != nil {
+			return err
+		}
+	}
+
+	// a notification of a still ongoing recall has to stay or the user would be notified again
+	query := `DELETE FROM drug_recall_notification n WHERE notified_at < now() - make_interval(days => $1)
+		AND NOT EXISTS (SELECT 1 FROM drug_recall r WHERE r.recall_number = n.recall_number AND r.status = 'Ongoing')`
+	_, err = srv.db.Exec(ctx, query, notificationDays)
+
+	return err
+}
+
+func (srv *Server) RunPartitionJob(ctx context.Context) {
+	ticker := time.NewTicker(partitionJobInterval)
+	defer ticker.Stop()
+
+	for {
+		if !srv.inMaintenance() {
+			err := srv.exclusively(drainable(ctx), "partition", srv.MaintainPartitions)
+			if err != nil {
+				log.Printf("partition job: %v", err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
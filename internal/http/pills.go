@@ -0,0 +1,63 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"kode_test/internal/schedule"
+	"net/http"
+	"time"
+)
+
+// the answer to "is this the right pill?"
+type PillCheck struct {
+	// whether the pill matches one of the active schedules
+	Match     bool        `json:"match"`
+	Schedules []PillMatch `json:"schedules"`
+}
+
+type PillMatch struct {
+	ScheduleID int           `json:"schedule_id"`
+	Medicine   string        `json:"medicine"`
+	Pill       schedule.Pill `json:"pill"`
+	// the details that were compared and agree
+	Matched []string `json:"matched"`
+}
+
+// compares the pill described by shape, color and imprint with the pills of the user's
+// active schedules, schedules without a recorded pill can not match
+func (srv *Server) matchPillHandler(w http.ResponseWriter, r *http.Request) error {
+	userID, err := pathUserID(r)
+	if err != nil {
+		return err
+	}
+	urlParams := r.URL.Query()
+	seen := schedule.Pill{Shape: urlParams.Get("shape"), Color: urlParams.Get("color"), Imprint: urlParams.Get("imprint")}
+	err = schedule.NormalizePill(&seen)
+	if err != nil {
+		return err
+	}
+	if seen == (schedule.Pill{}) {
+		return schedule.Errorf(schedule.ErrValidation, "describe the pill with at least one of shape, color or imprint")
+	}
+
+	schedules, err := srv.ListUserSchedules(context.Background(), userID, "active", time.Time{}, nil)
+	if err != nil {
+		return fmt.Errorf("failed get schedules from database: %w", err)
+	}
+
+	check := PillCheck{Schedules: []PillMatch{}}
+	for _, s := range schedules {
+		if s.Pill == nil {
+			continue
+		}
+		matched, ok := schedule.MatchPill(*s.Pill, seen)
+		if ok {
+			check.Schedules = append(check.Schedules, PillMatch{ScheduleID: s.ID, Medicine: s.Medicine, Pill: *s.Pill, Matched: matched})
+		}
+	}
+	check.Match = len(check.Schedules) > 0
+
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, convertToJson(check))
+	return nil
+}
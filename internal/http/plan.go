@@ -0,0 +1,95 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"kode_test/internal/pdf"
+	"kode_test/internal/schedule"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// the user of a /v1/users/{id} path, authenticated callers can only ask for themselves
+func pathUserID(r *http.Request) (string, error) {
+	userID := r.PathValue("id")
+	if current := currentUserID(r); current != "" && current != userID {
+		return "", schedule.Errorf(schedule.ErrForbidden, "forbidden")
+	}
+
+	return userID, nil
+}
+
+// the active schedules as a printable page with the times of the doses,
+// for the fridge door or handing over to a caregiver
+func (srv *Server) getPlanPDFHandler(w http.ResponseWriter, r *http.Request) error {
+	userID, err := pathUserID(r)
+	if err != nil {
+		return err
+	}
+
+	return srv.writePlanPDF(w, r, userID, nil)
+}
+
+// the plan of the given schedules, or of all active ones without scheduleIDs
+func (srv *Server) writePlanPDF(w http.ResponseWriter, r *http.Request, userID string, scheduleIDs []int) error {
+	schedules, err := srv.ListUserSchedules(context.Background(), userID, "active", time.Time{}, nil)
+	if err != nil {
+		return fmt.Errorf("failed get schedules from database: %w", err)
+	}
+	if scheduleIDs != nil {
+		schedules = slices.DeleteFunc(schedules, func(s schedule.Schedule) bool {
+			return !slices.Contains(scheduleIDs, s.ID)
+		})
+	}
+	loc, err := srv.userLocation(r, userID)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", `inline; filename="plan.pdf"`)
+	w.Write(planPDF(schedules, time.Now().In(loc)))
+	return nil
+}
+
+func planPDF(schedules []schedule.Schedule, now time.Time) []byte {
+	loc := now.Location()
+	doc := pdf.New()
+	doc.Heading("Medication plan")
+	doc.Text(fmt.Sprintf("As of %s, times in %s.", now.Format("2 January 2006"), loc))
+	doc.Space()
+
+	widths := []float64{130, 45, 160, 70, 90}
+	doc.Row([]string{"Medicine", "Doses", "Times", "Until", "Prescriber"}, widths, true)
+	rows := 0
+	for _, s := range schedules {
+		if !schedule.CheckDay(s, now, loc) {
+			continue
+		}
+
+		var times []string
+		for _, doseTime := range schedule.DoseTimes(s, now) {
+			times = append(times, doseTime.Format("15:04"))
+		}
+		until := "ongoing"
+		if s.Frequency > 0 {
+			until = schedule.LocalDate(s.CreatedAt, loc).AddDate(0, 0, s.Frequency-1).Format("2 Jan 2006")
+		}
+		prescriber := ""
+		if s.Prescriber != nil {
+			prescriber = s.Prescriber.Name
+		}
+		doc.Row([]string{s.Medicine, fmt.Sprintf("%d a day", s.Duration), strings.Join(times, " "), until, prescriber}, widths, false)
+		if s.Instructions != "" {
+			doc.Text(schedule.PlainInstructions(s.Instructions))
+		}
+		rows++
+	}
+	if rows == 0 {
+		doc.Text("No active medication.")
+	}
+
+	return doc.Bytes()
+}
@@ -0,0 +1,92 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"kode_test/internal/schedule"
+	"net/http"
+)
+
+// photos from phones, larger uploads are rejected before they are read completely
+const maxPrescriptionImageSize = 10 << 20
+
+var prescriptionImageTypes = map[string]string{
+	"image/jpeg": "jpg",
+	"image/png":  "png",
+	"image/heic": "heic",
+	"image/webp": "webp",
+}
+
+type PrescriptionScan struct {
+	ID    string         `json:"id"`
+	Text  string         `json:"text"`
+	Draft schedule.Draft `json:"draft"`
+}
+
+// takes the image of a prescription as the request body, keeps it in the object store and
+// answers with the schedule draft read from it, which the app shows for confirmation
+func (srv *Server) scanPrescriptionHandler(w http.ResponseWriter, r *http.Request) error {
+	if srv.objects == nil || srv.extractor == nil {
+		http.Error(w, "prescription scanning is not configured", http.StatusNotImplemented)
+		return nil
+	}
+
+	userID, err := requestUserID(r)
+	if err != nil {
+		return err
+	}
+
+	contentType := r.Header.Get("Content-Type")
+	extension, ok := prescriptionImageTypes[contentType]
+	if !ok {
+		return schedule.Errorf(schedule.ErrValidation, "unsupported image type, expected jpeg, png, heic or webp")
+	}
+
+	image, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxPrescriptionImageSize))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return schedule.Errorf(schedule.ErrValidation, "image is larger than %d MB", maxPrescriptionImageSize>>20)
+	}
+	if err != nil {
+		return fmt.Errorf("failed read image: %w", err)
+	}
+	if len(image) == 0 {
+		return schedule.Errorf(schedule.ErrValidation, "image is empty")
+	}
+
+	ctx := context.Background()
+	var scan PrescriptionScan
+	query := "INSERT INTO prescription_scan (user_id, object_key, content_type, size_bytes) VALUES ($1, '', $2, $3) RETURNING id::text"
+	err = srv.db.QueryRow(ctx, query, userID, contentType, len(image)).Scan(&scan.ID)
+	if err != nil {
+		return fmt.Errorf("failed save prescription scan: %w", err)
+	}
+
+	key := fmt.Sprintf("prescriptions/%s/%s.%s", userID, scan.ID, extension)
+	err = srv.objects.Put(ctx, key, contentType, image)
+	if err != nil {
+		srv.db.Exec(ctx, "DELETE FROM prescription_scan WHERE id = $1", scan.ID)
+		return fmt.Errorf("failed store image: %w", err)
+	}
+	_, err = srv.db.Exec(ctx, "UPDATE prescription_scan SET object_key = $1 WHERE id = $2", key, scan.ID)
+	if err != nil {
+		return fmt.Errorf("failed save prescription scan: %w", err)
+	}
+
+	scan.Text, err = srv.extractor.Extract(ctx, image, contentType)
+	if err != nil {
+		return fmt.Errorf("failed extract prescription: %w", err)
+	}
+	scan.Draft = schedule.ParseDraft(scan.Text)
+
+	_, err = srv.db.Exec(ctx, "UPDATE prescription_scan SET extracted_text = $1 WHERE id = $2", scan.Text, scan.ID)
+	if err != nil {
+		return fmt.Errorf("failed save prescription scan: %w", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, convertToJson(scan))
+	return nil
+}
@@ -0,0 +1,129 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"kode_test/internal/notify"
+	"kode_test/internal/schedule"
+	"net/http"
+	"net/url"
+	"regexp"
+)
+
+var ntfyTopicPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// a push target as the API shows it, the token is never returned
+type PushTargetSettings struct {
+	Provider string `json:"provider"`
+	Server   string `json:"server"`
+	Topic    string `json:"topic,omitempty"`
+	Token    string `json:"token,omitempty"`
+	HasToken bool   `json:"has_token"`
+}
+
+// the push target of a user with the token decrypted, for the notifier
+func (srv *Server) PushTarget(ctx context.Context, userID string) (*notify.PushTarget, error) {
+	var target notify.PushTarget
+	query := "SELECT provider, server_url, topic, token FROM push_target WHERE user_id = $1"
+	err := srv.db.QueryRow(ctx, query, userID).Scan(&target.Provider, &target.Server, &target.Topic, &target.Token)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err == nil && target.Token != "" {
+		target.Token, err = srv.cipher.Decrypt(target.Token)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed get push target from database: %w", err)
+	}
+
+	return &target, nil
+}
+
+func (srv *Server) getPushTargetHandler(w http.ResponseWriter, r *http.Request) error {
+	userID, err := pathUserID(r)
+	if err != nil {
+		return err
+	}
+	target, err := srv.PushTarget(context.Background(), userID)
+	if err != nil {
+		return err
+	}
+	if target == nil {
+		return schedule.Errorf(schedule.ErrNotFound, "no push target")
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, convertToJson(PushTargetSettings{Provider: target.Provider, Server: target.Server, Topic: target.Topic, HasToken: target.Token != ""}))
+	return nil
+}
+
+// sends the user's push reminders to ntfy or Gotify instead of the deployment's push service
+func (srv *Server) putPushTargetHandler(w http.ResponseWriter, r *http.Request) error {
+	userID, err := pathUserID(r)
+	if err != nil {
+		return err
+	}
+	var settings PushTargetSettings
+	err = json.NewDecoder(r.Body).Decode(&settings)
+	if err != nil {
+		return schedule.Errorf(schedule.ErrValidation, "invalid push target format")
+	}
+
+	switch settings.Provider {
+	case "ntfy":
+		if settings.Server == "" {
+			settings.Server = "https://ntfy.sh"
+		}
+		if !ntfyTopicPattern.MatchString(settings.Topic) {
+			return schedule.FieldErrorf("topic", "format", "topic must be 1 to 64 letters, digits, - or _")
+		}
+	case "gotify":
+		if settings.Token == "" {
+			return schedule.FieldErrorf("token", "required", "token of the Gotify application is required")
+		}
+		settings.Topic = ""
+	default:
+		return schedule.FieldErrorf("provider", "enum", "provider must be ntfy or gotify")
+	}
+	server, err := url.Parse(settings.Server)
+	if err != nil || (server.Scheme != "https" && server.Scheme != "http") || server.Host == "" {
+		return schedule.FieldErrorf("server", "format", "server must be an http or https URL")
+	}
+
+	token := ""
+	if settings.Token != "" {
+		token, err = srv.cipher.Encrypt(settings.Token)
+		if err != nil {
+			return fmt.Errorf("failed encrypt push token: %w", err)
+		}
+	}
+	query := `INSERT INTO push_target (user_id, provider, server_url, topic, token) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (user_id) DO UPDATE SET provider = EXCLUDED.provider, server_url = EXCLUDED.server_url,
+			topic = EXCLUDED.topic, token = EXCLUDED.token, updated_at = now()`
+	_, err = srv.db.Exec(context.Background(), query, userID, settings.Provider, settings.Server, settings.Topic, token)
+	if err != nil {
+		return fmt.Errorf("failed save push target: %w", err)
+	}
+
+	settings.HasToken, settings.Token = settings.Token != "", ""
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, convertToJson(settings))
+	return nil
+}
+
+func (srv *Server) deletePushTargetHandler(w http.ResponseWriter, r *http.Request) error {
+	userID, err := pathUserID(r)
+	if err != nil {
+		return err
+	}
+	_, err = srv.db.Exec(context.Background(), "DELETE FROM push_target WHERE user_id = $1", userID)
+	if err != nil {
+		return fmt.Errorf("failed delete push target: %w", err)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
@@ -0,0 +1,89 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"github.com/jackc/pgx/v5"
+	"kode_test/internal/schedule"
+	"time"
+)
+
+// every schedule read goes through these statements and scanSchedule, pgx prepares
+// each statement once per connection and reuses it from the statement cache
+const scheduleColumns = "id, uuid::text, medicine, frequency, duration, user_id, status, version, created_at, updated_at, prescriber, pharmacy, tags, color, icon, instructions, pill, dose, strength, external_id"
+
+const (
+	queryUserSchedule     = "SELECT " + scheduleColumns + " FROM schedule WHERE user_id = $1 AND id = $2"
+	queryUserSchedules    = "SELECT " + scheduleColumns + " FROM schedule WHERE user_id = $1 AND ($2 = '' OR status = $2) AND updated_at > $3 AND tags @> $4 ORDER BY id"
+	queryRegimenSchedules = "SELECT " + scheduleColumns + " FROM schedule WHERE regimen_id = $1 AND status = 'active' AND ($2 = '' OR user_id = $2) ORDER BY id"
+	querySyncSchedules    = "SELECT " + scheduleColumns + " FROM schedule WHERE user_id = $1 AND ($2 OR id = ANY($3)) ORDER BY id"
+)
+
+func (srv *Server) scanSchedule(row pgx.Row) (schedule.Schedule, error) {
+	var s schedule.Schedule
+	var prescriber, pharmacy []byte
+	err := row.Scan(&s.ID, &s.UUID, &s.Medicine, &s.Frequency, &s.Duration, &s.UserID, &s.Status, &s.Version, &s.CreatedAt, &s.UpdatedAt, &prescriber, &pharmacy, &s.Tags, &s.Color, &s.Icon, &s.Instructions, &s.Pill, &s.Dose, &s.Strength, &s.ExternalID)
+	if err == nil {
+		s.Medicine, err = srv.cipher.Decrypt(s.Medicine)
+	}
+	if err == nil {
+		s.Instructions, err = srv.cipher.Decrypt(s.Instructions)
+	}
+	if err == nil {
+		err = srv.cipher.DecryptJSON(prescriber, &s.Prescriber)
+	}
+	if err == nil {
+		err = srv.cipher.DecryptJSON(pharmacy, &s.Pharmacy)
+	}
+
+	return s, err
+}
+
+// instructions are health data like the medicine, encrypted the same way. No instructions stay empty.
+func (srv *Server) sealInstructions(instructions string) (string, error) {
+	return srv.cipher.EncryptOptional(instructions)
+}
+
+// so are the prescriber and pharmacy, each is encrypted as a whole
+func (srv *Server) sealContacts(s schedule.Schedule) ([]byte, []byte, error) {
+	prescriber, err := srv.cipher.EncryptJSON(s.Prescriber)
+	if err != nil {
+		return nil, nil, err
+	}
+	pharmacy, err := srv.cipher.EncryptJSON(s.Pharmacy)
+
+	return prescriber, pharmacy, err
+}
+
+func (srv *Server) collectSchedules(rows pgx.Rows, err error) ([]schedule.Schedule, error) {
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (schedule.Schedule, error) {
+		return srv.scanSchedule(row)
+	})
+}
+
+// a schedule of another user is reported as not found as well
+func (srv *Server) getUserSchedule(ctx context.Context, userID string, scheduleID int) (schedule.Schedule, error) {
+	s, err := srv.scanSchedule(srv.db.QueryRow(ctx, queryUserSchedule, userID, scheduleID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return s, schedule.Errorf(schedule.ErrNotFound, "schedule not found")
+	}
+
+	return s, err
+}
+
+// schedules of a user, optionally only those with the status, changed after updatedSince or with all of tags
+func (srv *Server) ListUserSchedules(ctx context.Context, userID string, status string, updatedSince time.Time, tags []string) ([]schedule.Schedule, error) {
+	if tags == nil {
+		tags = []string{}
+	}
+
+	return srv.collectSchedules(srv.db.QueryRead(ctx, queryUserSchedules, userID, status, updatedSince, tags))
+}
+
+func (srv *Server) listRegimenSchedules(ctx context.Context, regimenID string, userID string) ([]schedule.Schedule, error) {
+	return srv.collectSchedules(srv.db.Query(ctx, queryRegimenSchedules, regimenID, userID))
+}
@@ -0,0 +1,141 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"kode_test/internal/schedule"
+	"net/http"
+)
+
+type Quota struct {
+	Plan string `json:"plan"`
+	// nil means unlimited
+	MaxActiveSchedules *int `json:"max_active_schedules"`
+	ActiveSchedules    int  `json:"active_schedules"`
+}
+
+type rowQuerier interface {
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+}
+
+// the plan limits of a user with the admin overrides applied, user ids without
+// an account are on the free plan
+func userQuota(ctx context.Context, db rowQuerier, userID string) (Quota, error) {
+	var quota Quota
+	query := `SELECT p.name, CASE WHEN q.user_id IS NULL THEN p.max_active_schedules ELSE q.max_active_schedules END,
+			(SELECT count(*) FROM schedule WHERE user_id = $1 AND status = 'active')
+		FROM plan p
+		LEFT JOIN users u ON u.id = $1
+		LEFT JOIN user_quota q ON q.user_id = $1
+		WHERE p.name = COALESCE(u.plan, 'free')`
+	err := db.QueryRow(ctx, query, userID).Scan(&quota.Plan, &quota.MaxActiveSchedules, &quota.ActiveSchedules)
+
+	return quota, err
+}
+
+// locks the user for the rest of the transaction and fails when adding more active schedules would
+// go over the plan limit. Creates and resumes of the same user wait for each other, so the count
+// stays right until the transaction writes.
+func lockScheduleQuota(ctx context.Context, tx pgx.Tx, userID string, adding int) error {
+	err := lockQuotaUser(ctx, tx, userID)
+	if err != nil {
+		return err
+	}
+
+	return checkScheduleQuota(ctx, tx, userID, adding)
+}
+
+// lockScheduleQuota for making existing schedules active, those that already are do not count
+func lockActivation(ctx context.Context, tx pgx.Tx, userID string, scheduleIDs []int) error {
+	err := lockQuotaUser(ctx, tx, userID)
+	if err != nil {
+		return err
+	}
+
+	var adding int
+	err = tx.QueryRow(ctx, "SELECT count(*) FROM schedule WHERE id = ANY($1) AND status <> 'active'", scheduleIDs).Scan(&adding)
+	if err != nil {
+		return fmt.Errorf("failed check quota: %w", err)
+	}
+
+	return checkScheduleQuota(ctx, tx, userID, adding)
+}
+
+func lockQuotaUser(ctx context.Context, tx pgx.Tx, userID string) error {
+	var id string
+	err := tx.QueryRow(ctx, "SELECT id FROM users WHERE id = $1 FOR UPDATE", userID).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		// user ids without an account have no row to lock
+		_, err = tx.Exec(ctx, "SELECT pg_advisory_xact_lock(hashtext('schedule_quota'), hashtext($1))", userID)
+	}
+	if err != nil {
+		return fmt.Errorf("failed lock user: %w", err)
+	}
+
+	return nil
+}
+
+// a user over the limit after it was lowered can still edit what is active, only additions are refused
+func checkScheduleQuota(ctx context.Context, db rowQuerier, userID string, adding int) error {
+	if adding == 0 {
+		return nil
+	}
+	quota, err := userQuota(ctx, db, userID)
+	if err != nil {
+		return fmt.Errorf("failed check quota: %w", err)
+	}
+	if quota.MaxActiveSchedules != nil && quota.ActiveSchedules+adding > *quota.MaxActiveSchedules {
+		return schedule.Errorf(schedule.ErrForbidden, "the %s plan allows %d active schedules, pause or delete one first", quota.Plan, *quota.MaxActiveSchedules)
+	}
+
+	return nil
+}
+
+func (srv *Server) getQuotaHandler(w http.ResponseWriter, r *http.Request) error {
+	requiredParams := []string{"user_id"}
+	urlParams := r.URL.Query()
+	missingParamMessage := checkRequiredParams(requiredParams, urlParams)
+	if missingParamMessage != "" {
+		return schedule.Errorf(schedule.ErrValidation, "%s", missingParamMessage)
+	}
+
+	quota, err := userQuota(context.Background(), srv.db, urlParams.Get("user_id"))
+	if err != nil {
+		return fmt.Errorf("failed get quota from database: %w", err)
+	}
+
+	fmt.Fprint(w, convertToJson(quota))
+	return nil
+}
+
+// sets or, with an empty body, removes the limits of one user regardless of plan
+func (srv *Server) putUserQuotaHandler(w http.ResponseWriter, r *http.Request) error {
+	var quota Quota
+	if r.ContentLength != 0 {
+		err := json.NewDecoder(r.Body).Decode(&quota)
+		if err != nil {
+			return schedule.Errorf(schedule.ErrValidation, "invalid quota format")
+		}
+	}
+	if quota.MaxActiveSchedules != nil && *quota.MaxActiveSchedules < 0 {
+		return schedule.FieldErrorf("max_active_schedules", "min", "max_active_schedules can not be negative")
+	}
+
+	var err error
+	if r.ContentLength == 0 {
+		_, err = srv.db.Exec(context.Background(), "DELETE FROM user_quota WHERE user_id = $1", r.PathValue("id"))
+	} else {
+		query := `INSERT INTO user_quota (user_id, max_active_schedules) VALUES ($1, $2)
+			ON CONFLICT (user_id) DO UPDATE SET max_active_schedules = EXCLUDED.max_active_schedules, updated_at = now()`
+		_, err = srv.db.Exec(context.Background(), query, r.PathValue("id"), quota.MaxActiveSchedules)
+	}
+	if err != nil {
+		return fmt.Errorf("failed save quota in database: %w", err)
+	}
+
+	fmt.Fprintf(w, "quota saved")
+	return nil
+}
@@ -0,0 +1,90 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// counts requests per key in fixed windows, allow returns what is left of the limit,
+// when the window resets and whether the request may go ahead
+type rateLimiter interface {
+	allow(key string, limit int, window time.Duration) (int, time.Time, bool)
+}
+
+// RATE_LIMIT_REDIS_URL shares the counters between instances, without it every
+// instance counts on its own. An invalid URL is an error, falling back would quietly
+// multiply the limits by the number of instances
+func newRateLimiter() (rateLimiter, error) {
+	address := os.Getenv("RATE_LIMIT_REDIS_URL")
+	if address == "" {
+		return newMemoryLimiter(), nil
+	}
+
+	limiter, err := newRedisLimiter(address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid RATE_LIMIT_REDIS_URL: %w", err)
+	}
+
+	return limiter, nil
+}
+
+type rateWindow struct {
+	count int
+	reset time.Time
+}
+
+// fixed window counters kept in process memory
+type memoryLimiter struct {
+	mu      sync.Mutex
+	windows map[string]*rateWindow
+}
+
+func newMemoryLimiter() *memoryLimiter {
+	return &memoryLimiter{windows: map[string]*rateWindow{}}
+}
+
+func (l *memoryLimiter) allow(key string, limit int, window time.Duration) (int, time.Time, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	current, ok := l.windows[key]
+	if !ok || now.After(current.reset) {
+		// drop expired windows while we hold the lock anyway
+		for k, w := range l.windows {
+			if now.After(w.reset) {
+				delete(l.windows, k)
+			}
+		}
+		current = &rateWindow{reset: now.Add(window)}
+		l.windows[key] = current
+	}
+
+	if current.count >= limit {
+		return 0, current.reset, false
+	}
+	current.count++
+
+	return limit - current.count, current.reset, true
+}
+
+// sets the rate limit headers and writes 429 when the key is over its limit,
+// returns false when the caller must stop
+func (srv *Server) checkRateLimit(w http.ResponseWriter, key string, limit int, window time.Duration) bool {
+	remaining, reset, ok := srv.limiter.allow(key, limit, window)
+	w.Header().Set("X-RateLimit-Limit", strconv.Itoa(limit))
+	w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+	w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(reset.Unix(), 10))
+	if ok {
+		return true
+	}
+
+	w.Header().Set("Retry-After", fmt.Sprint(int(time.Until(reset).Seconds())+1))
+	http.Error(w, "too many requests", http.StatusTooManyRequests)
+
+	return false
+}
@@ -0,0 +1,235 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"kode_test/internal/breaker"
+	"kode_test/internal/pii"
+	"kode_test/internal/schedule"
+	"kode_test/internal/storage"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const recallJobInterval = 24 * time.Hour
+
+const defaultOpenFDAURL = "https://api.fda.gov/drug/enforcement.json"
+
+type DrugRecall struct {
+	RecallNumber         string `json:"recall_number"`
+	Medicine             string `json:"medicine"`
+	ProductDescription   string `json:"product_description"`
+	ReasonForRecall      string `json:"reason_for_recall"`
+	Classification       string `json:"classification"`
+	Status               string `json:"status"`
+	RecallInitiationDate string `json:"recall_initiation_date"`
+}
+
+var openFDAClient = &http.Client{Timeout: 30 * time.Second}
+
+// the recall job checks every medicine, an unreachable openFDA fails them fast
+var openFDABreaker = breaker.New("openfda", 3, 5*time.Minute, 30*time.Second)
+
+// fetches ongoing enforcement reports mentioning the medicine from openFDA
+func fetchRecalls(ctx context.Context, medicine string) ([]DrugRecall, error) {
+	endpoint := os.Getenv("OPENFDA_URL")
+	if endpoint == "" {
+		endpoint = defaultOpenFDAURL
+	}
+
+	params := url.Values{}
+	params.Set("search", fmt.Sprintf(`product_description:"%s" AND status:"Ongoing"`, strings.ReplaceAll(medicine, `"`, "")))
+	params.Set("limit", "100")
+	if key := os.Getenv("OPENFDA_API_KEY"); key != "" {
+		params.Set("api_key", key)
+	}
+
+	var body struct {
+		Results []DrugRecall `json:"results"`
+	}
+	err := openFDABreaker.Do(ctx, func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint+"?"+params.Encode(), nil)
+		if err != nil {
+			return err
+		}
+
+		resp, err := openFDAClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		// openFDA answers 404 when nothing matches the search
+		if resp.StatusCode == http.StatusNotFound {
+			return nil
+		}
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("openfda returned %s", resp.Status)
+		}
+
+		return json.NewDecoder(resp.Body).Decode(&body)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range body.Results {
+		body.Results[i].Medicine = medicine
+	}
+
+	return body.Results, nil
+}
+
+// refreshes recalls for every medicine in an active schedule and notifies the affected users once per recall
+func (srv *Server) CheckRecalls(ctx context.Context) error {
+	// medicine is encrypted, one row per distinct name is found through the hash
+	rows, err := srv.db.Query(ctx, "SELECT DISTINCT ON (medicine_hash) medicine FROM schedule WHERE status = 'active'")
+	if err != nil {
+		return err
+	}
+
+	var medicines []string
+	for rows.Next() {
+		var medicine string
+		err := rows.Scan(&medicine)
+		if err == nil {
+			medicine, err = srv.cipher.Decrypt(medicine)
+		}
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		medicines = append(medicines, strings.ToLower(strings.TrimSpace(medicine)))
+	}
+	rows.Close()
+
+	complete := true
+	for _, medicine := range medicines {
+		recalls, err := fetchRecalls(ctx, medicine)
+		if err != nil {
+			log.Printf("recall job: %s: %v", pii.MaskMedicine(medicine), err)
+			complete = false
+			continue
+		}
+
+		for _, recall := range recalls {
+			query := `INSERT INTO drug_recall (recall_number, medicine, medicine_hash, product_description, reason_for_recall, classification, status, recall_initiation_date)
+				VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+				ON CONFLICT (recall_number, medicine) DO UPDATE SET medicine_hash = $3, status = $7, fetched_at = now()`
+			_, err := srv.db.Exec(ctx, query, recall.RecallNumber, recall.Medicine, storage.MedicineHash(recall.Medicine), recall.ProductDescription, recall.ReasonForRecall, recall.Classification, recall.Status, recall.RecallInitiationDate)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// recalls not seen in this run are no longer ongoing, unless openFDA could not be asked about every medicine
+	if complete {
+		_, err = srv.db.Exec(ctx, "UPDATE drug_recall SET status = 'Terminated' WHERE fetched_at < now() - $1::interval", recallJobInterval.String())
+		if err != nil {
+			return err
+		}
+	}
+
+	return srv.notifyRecalls(ctx)
+}
+
+func (srv *Server) notifyRecalls(ctx context.Context) error {
+	query := `SELECT DISTINCT s.user_id, r.recall_number, r.medicine, r.reason_for_recall
+		FROM drug_recall r JOIN schedule s ON s.medicine_hash = r.medicine_hash AND s.status = 'active'
+		WHERE r.status = 'Ongoing' AND NOT EXISTS (
+			SELECT 1 FROM drug_recall_notification n WHERE n.recall_number = r.recall_number AND n.user_id = s.user_id)`
+	rows, err := srv.db.Query(ctx, query)
+	if err != nil {
+		return err
+	}
+
+	type pending struct {
+		userID, recallNumber, medicine, reason string
+	}
+	var notifications []pending
+	for rows.Next() {
+		var p pending
+		err := rows.Scan(&p.userID, &p.recallNumber, &p.medicine, &p.reason)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		notifications = append(notifications, p)
+	}
+	rows.Close()
+
+	for _, p := range notifications {
+		subject := "Recall of " + p.medicine
+		message := fmt.Sprintf("A product matching %s you are taking was recalled (%s): %s", p.medicine, p.recallNumber, p.reason)
+		err := srv.notifier.Notify(ctx, p.userID, subject, message)
+		if err != nil {
+			log.Printf("recall job: notify %s: %v", pii.MaskUserID(p.userID), err)
+			continue
+		}
+
+		_, err = srv.db.Exec(ctx, "INSERT INTO drug_recall_notification (recall_number, user_id) VALUES ($1, $2) ON CONFLICT DO NOTHING", p.recallNumber, p.userID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (srv *Server) RunRecallJob(ctx context.Context) {
+	ticker := time.NewTicker(recallJobInterval)
+	defer ticker.Stop()
+
+	for {
+		if !srv.inMaintenance() {
+			err := srv.exclusively(drainable(ctx), "recall", srv.CheckRecalls)
+			if err != nil {
+				log.Printf("recall job: %v", err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (srv *Server) getUserRecallsHandler(w http.ResponseWriter, r *http.Request) error {
+	requiredParams := []string{"user_id"}
+	urlParams := r.URL.Query()
+	missingParamMessage := checkRequiredParams(requiredParams, urlParams)
+	if missingParamMessage != "" {
+		return schedule.Errorf(schedule.ErrValidation, "%s", missingParamMessage)
+	}
+
+	query := `SELECT DISTINCT r.recall_number, r.medicine, r.product_description, r.reason_for_recall, r.classification, r.status, r.recall_initiation_date
+		FROM drug_recall r JOIN schedule s ON s.medicine_hash = r.medicine_hash AND s.status = 'active'
+		WHERE s.user_id = $1 AND r.status = 'Ongoing'
+		ORDER BY r.recall_initiation_date DESC`
+	rows, err := srv.db.Query(context.Background(), query, urlParams.Get("user_id"))
+	if err != nil {
+		return fmt.Errorf("failed get recalls from database: %w", err)
+	}
+	defer rows.Close()
+
+	recalls := []DrugRecall{}
+	for rows.Next() {
+		var recall DrugRecall
+		err := rows.Scan(&recall.RecallNumber, &recall.Medicine, &recall.ProductDescription, &recall.ReasonForRecall, &recall.Classification, &recall.Status, &recall.RecallInitiationDate)
+		if err != nil {
+			return fmt.Errorf("failed get recall: %w", err)
+		}
+		recalls = append(recalls, recall)
+	}
+
+	writeList(w, r, recalls)
+	return nil
+}
@@ -0,0 +1,333 @@
+package http
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"kode_test/internal/breaker"
+	"log"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// counts and expires a window in one step, so instances never see a key without expiry
+const redisWindowScript = `local count = redis.call('INCR', KEYS[1])
+if count == 1 then redis.call('PEXPIRE', KEYS[1], ARGV[1]) end
+return {count, redis.call('PTTL', KEYS[1])}`
+
+const (
+	// connections open to Redis at once per instance, callers beyond wait for one to be free
+	redisMaxConns = 16
+	// the wait before connecting again after Redis failed, doubled on every failure up to the maximum
+	redisMinBackoff = 100 * time.Millisecond
+	redisMaxBackoff = 10 * time.Second
+)
+
+var errRedisBackoff = errors.New("redis: waiting to reconnect")
+
+// an error reply of Redis, the connection it came over is still good
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+// fixed window counters kept in Redis and shared by every instance. While Redis can not be
+// reached each instance falls back to counting in its own memory, so limits stay in place.
+type redisLimiter struct {
+	address  string
+	useTLS   bool
+	password string
+	username string
+	database int
+
+	breaker  *breaker.Breaker
+	fallback *memoryLimiter
+
+	// a slot is taken for every open connection, idle ones are kept for the next command
+	slots   chan struct{}
+	mu      sync.Mutex
+	idle    []*redisConn
+	backoff time.Duration
+	retryAt time.Time
+}
+
+type redisConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// address is redis://[user:password@]host:port[/db], rediss:// for TLS
+func newRedisLimiter(address string) (*redisLimiter, error) {
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "redis" && u.Scheme != "rediss" {
+		return nil, fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return nil, errors.New("missing host")
+	}
+
+	limiter := &redisLimiter{
+		address:  u.Host,
+		useTLS:   u.Scheme == "rediss",
+		username: u.User.Username(),
+		breaker:  breaker.New("rate_limit_redis", 3, 30*time.Second, time.Second),
+		fallback: newMemoryLimiter(),
+		slots:    make(chan struct{}, redisMaxConns),
+	}
+	if u.Port() == "" {
+		limiter.address = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	limiter.password, _ = u.User.Password()
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		limiter.database, err = strconv.Atoi(db)
+		if err != nil || limiter.database < 0 {
+			return nil, fmt.Errorf("invalid database %q", db)
+		}
+	}
+
+	return limiter, nil
+}
+
+func (l *redisLimiter) allow(key string, limit int, window time.Duration) (int, time.Time, bool) {
+	var count, ttl int64
+	err := l.breaker.Do(context.Background(), func(ctx context.Context) error {
+		reply, err := l.do(ctx, "EVAL", redisWindowScript, "1", "ratelimit:"+key, strconv.FormatInt(window.Milliseconds(), 10))
+		if err != nil {
+			return err
+		}
+		values, ok := reply.([]interface{})
+		if !ok || len(values) != 2 {
+			return fmt.Errorf("redis: unexpected reply %v", reply)
+		}
+		count, _ = values[0].(int64)
+		ttl, _ = values[1].(int64)
+		return nil
+	})
+	if err != nil {
+		if !errors.Is(err, breaker.ErrOpen) && !errors.Is(err, errRedisBackoff) {
+			log.Printf("rate limit redis: %v", err)
+		}
+		return l.fallback.allow(key, limit, window)
+	}
+
+	if ttl < 0 {
+		ttl = window.Milliseconds()
+	}
+	reset := time.Now().Add(time.Duration(ttl) * time.Millisecond)
+	if count > int64(limit) {
+		return 0, reset, false
+	}
+
+	return limit - int(count), reset, true
+}
+
+// sends one command over a pooled connection. A connection that failed is closed and
+// nobody connects again until the backoff is over, an error reply leaves it in the pool.
+func (l *redisLimiter) do(ctx context.Context, args ...string) (interface{}, error) {
+	select {
+	case l.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	defer func() { <-l.slots }()
+
+	conn, err := l.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := conn.command(ctx, args...)
+	var replyErr redisError
+	if err != nil && !errors.As(err, &replyErr) {
+		conn.conn.Close()
+		l.failed()
+		return nil, err
+	}
+	l.put(conn)
+
+	return reply, err
+}
+
+func (l *redisLimiter) ping(ctx context.Context) error {
+	reply, err := l.do(ctx, "PING")
+	if err != nil {
+		return err
+	}
+	if reply != "PONG" {
+		return fmt.Errorf("redis: unexpected reply %v", reply)
+	}
+
+	return nil
+}
+
+func (l *redisLimiter) close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, conn := range l.idle {
+		conn.conn.Close()
+	}
+	l.idle = nil
+}
+
+// an idle connection, or a new one unless Redis failed recently
+func (l *redisLimiter) get(ctx context.Context) (*redisConn, error) {
+	l.mu.Lock()
+	if n := len(l.idle); n > 0 {
+		conn := l.idle[n-1]
+		l.idle = l.idle[:n-1]
+		l.mu.Unlock()
+		return conn, nil
+	}
+	waiting := time.Now().Before(l.retryAt)
+	l.mu.Unlock()
+	if waiting {
+		return nil, errRedisBackoff
+	}
+
+	conn, err := l.dial(ctx)
+	if err != nil {
+		l.failed()
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+func (l *redisLimiter) put(conn *redisConn) {
+	l.mu.Lock()
+	l.idle = append(l.idle, conn)
+	l.backoff, l.retryAt = 0, time.Time{}
+	l.mu.Unlock()
+}
+
+// the pooled connections most likely failed as well, they are dropped with it
+func (l *redisLimiter) failed() {
+	l.close()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.backoff = min(max(2*l.backoff, redisMinBackoff), redisMaxBackoff)
+	l.retryAt = time.Now().Add(l.backoff)
+}
+
+func (l *redisLimiter) dial(ctx context.Context) (*redisConn, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", l.address)
+	if err != nil {
+		return nil, err
+	}
+	if l.useTLS {
+		host, _, _ := net.SplitHostPort(l.address)
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: host})
+		err = tlsConn.HandshakeContext(ctx)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+	c := &redisConn{conn: conn, reader: bufio.NewReader(conn)}
+
+	if l.password != "" {
+		auth := []string{"AUTH", l.password}
+		if l.username != "" {
+			auth = []string{"AUTH", l.username, l.password}
+		}
+		_, err = c.command(ctx, auth...)
+	}
+	if err == nil && l.database != 0 {
+		_, err = c.command(ctx, "SELECT", strconv.Itoa(l.database))
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (c *redisConn) command(ctx context.Context, args ...string) (interface{}, error) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(time.Second)
+	}
+	c.conn.SetDeadline(deadline)
+
+	var request strings.Builder
+	fmt.Fprintf(&request, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&request, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	_, err := io.WriteString(c.conn, request.String())
+	if err != nil {
+		return nil, err
+	}
+
+	return readRedisReply(c.reader)
+}
+
+// one RESP2 reply: strings, integers, bulk strings and arrays of them
+func readRedisReply(reader *bufio.Reader) (interface{}, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("redis: empty reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		size, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("redis: malformed reply %q", line)
+		}
+		// a null bulk string
+		if size < 0 {
+			return nil, nil
+		}
+		data := make([]byte, size+2)
+		_, err = io.ReadFull(reader, data)
+		if err != nil {
+			return nil, err
+		}
+		return string(data[:size]), nil
+	case '*':
+		count, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("redis: malformed reply %q", line)
+		}
+		if count < 0 {
+			return nil, nil
+		}
+		values := make([]interface{}, count)
+		for i := range values {
+			values[i], err = readRedisReply(reader)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return values, nil
+	}
+
+	return nil, fmt.Errorf("redis: unexpected reply %q", line)
+}
@@ -0,0 +1,173 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"kode_test/internal/schedule"
+	"net/http"
+	"regexp"
+	"time"
+)
+
+var currencyPattern = regexp.MustCompile(`^[A-Z]{3}$`)
+
+// amounts are in cents of the currency, copay is the part the user paid
+type Refill struct {
+	ID         string `json:"id"`
+	ScheduleID int    `json:"schedule_id"`
+	FilledOn   string `json:"filled_on"`
+	Quantity   int    `json:"quantity"`
+	CostCents  int64  `json:"cost_cents"`
+	CopayCents int64  `json:"copay_cents"`
+	Currency   string `json:"currency"`
+}
+
+// spend on one medicine in one month and currency
+type MonthlySpend struct {
+	Month      string `json:"month"`
+	Medicine   string `json:"medicine"`
+	Currency   string `json:"currency"`
+	Refills    int    `json:"refills"`
+	CostCents  int64  `json:"cost_cents"`
+	CopayCents int64  `json:"copay_cents"`
+}
+
+func (srv *Server) createRefillHandler(w http.ResponseWriter, r *http.Request) error {
+	var refill Refill
+	err := json.NewDecoder(r.Body).Decode(&refill)
+	if err != nil {
+		return schedule.Errorf(schedule.ErrValidation, "invalid refill format")
+	}
+	if refill.Currency == "" {
+		refill.Currency = "USD"
+	}
+	if refill.FilledOn == "" {
+		refill.FilledOn = time.Now().Format("2006-01-02")
+	}
+	var errs []error
+	_, err = time.Parse("2006-01-02", refill.FilledOn)
+	if err != nil {
+		errs = append(errs, schedule.FieldErrorf("filled_on", "format", "invalid filled_on, expected YYYY-MM-DD"))
+	}
+	if refill.CostCents < 0 {
+		errs = append(errs, schedule.FieldErrorf("cost_cents", "min", "cost_cents must not be negative"))
+	}
+	if refill.CopayCents < 0 {
+		errs = append(errs, schedule.FieldErrorf("copay_cents", "min", "copay_cents must not be negative"))
+	}
+	if refill.Quantity < 0 {
+		errs = append(errs, schedule.FieldErrorf("quantity", "min", "quantity must not be negative"))
+	}
+	if !currencyPattern.MatchString(refill.Currency) {
+		errs = append(errs, schedule.FieldErrorf("currency", "format", "currency must be an ISO 4217 code"))
+	}
+	err = schedule.JoinFields(errs...)
+	if err != nil {
+		return err
+	}
+
+	userID, err := requestUserID(r)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	refill.ScheduleID, err = srv.ownScheduleID(ctx, r.PathValue("id"), userID)
+	if err != nil {
+		return err
+	}
+
+	query := `INSERT INTO refill (schedule_id, user_id, filled_on, quantity, cost_cents, copay_cents, currency) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id::text`
+	err = srv.db.QueryRow(ctx, query, refill.ScheduleID, userID, refill.FilledOn, refill.Quantity, refill.CostCents, refill.CopayCents, refill.Currency).Scan(&refill.ID)
+	if err != nil {
+		return fmt.Errorf("failed save refill: %w", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	fmt.Fprint(w, convertToJson(refill))
+	return nil
+}
+
+func (srv *Server) getRefillsHandler(w http.ResponseWriter, r *http.Request) error {
+	userID, err := requestUserID(r)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	scheduleID, err := srv.ownScheduleID(ctx, r.PathValue("id"), userID)
+	if err != nil {
+		return err
+	}
+
+	query := "SELECT id::text, schedule_id, to_char(filled_on, 'YYYY-MM-DD'), quantity, cost_cents, copay_cents, currency FROM refill WHERE schedule_id = $1 ORDER BY filled_on DESC"
+	rows, err := srv.db.QueryRead(ctx, query, scheduleID)
+	if err != nil {
+		return fmt.Errorf("failed get refills from database: %w", err)
+	}
+	refills, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (Refill, error) {
+		var refill Refill
+		err := row.Scan(&refill.ID, &refill.ScheduleID, &refill.FilledOn, &refill.Quantity, &refill.CostCents, &refill.CopayCents, &refill.Currency)
+		return refill, err
+	})
+	if err != nil {
+		return fmt.Errorf("failed get refills from database: %w", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, convertToJson(refills))
+	return nil
+}
+
+// spend per month and medicine between the months from and to, both YYYY-MM and included,
+// by default the last twelve months. Schedules of the same medicine are added up.
+func (srv *Server) getSpendReportHandler(w http.ResponseWriter, r *http.Request) error {
+	userID, err := requestUserID(r)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	thisMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	from, to := thisMonth.AddDate(0, -11, 0), thisMonth
+	for name, month := range map[string]*time.Time{"from": &from, "to": &to} {
+		value := r.URL.Query().Get(name)
+		if value == "" {
+			continue
+		}
+		*month, err = time.Parse("2006-01", value)
+		if err != nil {
+			return schedule.Errorf(schedule.ErrValidation, "invalid %s, expected YYYY-MM", name)
+		}
+	}
+	if to.Before(from) {
+		return schedule.Errorf(schedule.ErrValidation, "to is before from")
+	}
+
+	// medicines are encrypted, they are grouped by their hash and one of the values is decrypted
+	query := `SELECT to_char(date_trunc('month', f.filled_on), 'YYYY-MM') AS month, (array_agg(s.medicine))[1], f.currency,
+			count(*), sum(f.cost_cents), sum(f.copay_cents)
+		FROM refill f JOIN schedule s ON s.id = f.schedule_id
+		WHERE f.user_id = $1 AND f.filled_on >= $2 AND f.filled_on < $3
+		GROUP BY month, s.medicine_hash, f.currency
+		ORDER BY month, f.currency`
+	rows, err := srv.db.QueryRead(context.Background(), query, userID, from, to.AddDate(0, 1, 0))
+	if err != nil {
+		return fmt.Errorf("failed get spend from database: %w", err)
+	}
+	report, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (MonthlySpend, error) {
+		var spend MonthlySpend
+		err := row.Scan(&spend.Month, &spend.Medicine, &spend.Currency, &spend.Refills, &spend.CostCents, &spend.CopayCents)
+		if err == nil {
+			spend.Medicine, err = srv.cipher.Decrypt(spend.Medicine)
+		}
+		return spend, err
+	})
+	if err != nil {
+		return fmt.Errorf("failed get spend from database: %w", err)
+	}
+
+	writeList(w, r, report)
+	return nil
+}
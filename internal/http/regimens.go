@@ -0,0 +1,220 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"kode_test/internal/schedule"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type Regimen struct {
+	ID          int       `json:"id"`
+	Name        string    `json:"name"`
+	UserID      string    `json:"user_id"`
+	ScheduleIDs []int     `json:"schedule_ids"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+func (srv *Server) createRegimenHandler(w http.ResponseWriter, r *http.Request) error {
+	var regimen Regimen
+	err := json.NewDecoder(r.Body).Decode(&regimen)
+	if err != nil {
+		return schedule.Errorf(schedule.ErrValidation, "invalid regimen format")
+	}
+	if userID := currentUserID(r); userID != "" {
+		regimen.UserID = userID
+	}
+
+	if strings.TrimSpace(regimen.Name) == "" || regimen.UserID == "" || len(regimen.ScheduleIDs) == 0 {
+		return schedule.Errorf(schedule.ErrValidation, "name, user_id and schedule_ids are required")
+	}
+
+	ctx := context.Background()
+	var regimenID int
+	err = srv.db.InTx(ctx, func(tx pgx.Tx) error {
+		query := `INSERT INTO regimen (name, user_id) VALUES ($1, $2) RETURNING id`
+		err := tx.QueryRow(ctx, query, regimen.Name, regimen.UserID).Scan(&regimenID)
+		if err != nil {
+			return err
+		}
+
+		query = "UPDATE schedule SET regimen_id = $1 WHERE id = ANY($2) AND user_id = $3"
+		tag, err := tx.Exec(ctx, query, regimenID, regimen.ScheduleIDs, regimen.UserID)
+		if err != nil {
+			return err
+		}
+		if int(tag.RowsAffected()) != len(regimen.ScheduleIDs) {
+			return schedule.Errorf(schedule.ErrValidation, "some schedules do not exist or belong to another user")
+		}
+
+		return nil
+	})
+	var domainErr *schedule.Error
+	if errors.As(err, &domainErr) {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("error adding data to database: %w", err)
+	}
+
+	fmt.Fprintf(w, "regimen saved with ID: %d\n", regimenID)
+	return nil
+}
+
+func (srv *Server) getRegimensHandler(w http.ResponseWriter, r *http.Request) error {
+	requiredParams := []string{"user_id"}
+	urlParams := r.URL.Query()
+	missingParamMessage := checkRequiredParams(requiredParams, urlParams)
+	if missingParamMessage != "" {
+		return schedule.Errorf(schedule.ErrValidation, "%s", missingParamMessage)
+	}
+
+	updatedSince, err := parseUpdatedSince(urlParams)
+	if err != nil {
+		return err
+	}
+
+	userID := urlParams.Get("user_id")
+	query := `SELECT r.id, r.name, r.user_id, r.created_at, r.updated_at, COALESCE(array_agg(s.id) FILTER (WHERE s.id IS NOT NULL), '{}')
+		FROM regimen r LEFT JOIN schedule s ON s.regimen_id = r.id
+		WHERE r.user_id = $1 AND r.updated_at > $2 GROUP BY r.id ORDER BY r.id`
+	rows, err := srv.db.Query(context.Background(), query, userID, updatedSince)
+	if err != nil {
+		return fmt.Errorf("failed get regimens from database: %w", err)
+	}
+	defer rows.Close()
+
+	regimens := []Regimen{}
+	for rows.Next() {
+		var regimen Regimen
+		err := rows.Scan(&regimen.ID, &regimen.Name, &regimen.UserID, &regimen.CreatedAt, &regimen.UpdatedAt, &regimen.ScheduleIDs)
+		if err != nil {
+			return fmt.Errorf("failed get regimen: %w", err)
+		}
+		regimens = append(regimens, regimen)
+	}
+
+	writeList(w, r, regimens)
+	return nil
+}
+
+func (srv *Server) pauseRegimenHandler(w http.ResponseWriter, r *http.Request) error {
+	return srv.setRegimenStatus(w, r, "paused")
+}
+
+func (srv *Server) resumeRegimenHandler(w http.ResponseWriter, r *http.Request) error {
+	return srv.setRegimenStatus(w, r, "active")
+}
+
+func (srv *Server) setRegimenStatus(w http.ResponseWriter, r *http.Request, status string) error {
+	regimenID := r.PathValue("id")
+
+	ctx := context.Background()
+	err := srv.db.InTx(ctx, func(tx pgx.Tx) error {
+		err := lockRegimen(tx, regimenID, currentUserID(r))
+		if err != nil {
+			return err
+		}
+		if status == "active" {
+			var owner string
+			var scheduleIDs []int
+			query := "SELECT r.user_id, COALESCE(array_agg(s.id) FILTER (WHERE s.id IS NOT NULL), '{}') FROM regimen r LEFT JOIN schedule s ON s.regimen_id = r.id AND s.status <> 'completed' WHERE r.id = $1 GROUP BY r.id"
+			err = tx.QueryRow(ctx, query, regimenID).Scan(&owner, &scheduleIDs)
+			if err != nil {
+				return err
+			}
+			err = lockActivation(ctx, tx, owner, scheduleIDs)
+			if err != nil {
+				return err
+			}
+		}
+
+		query := "UPDATE schedule SET status = $1 WHERE regimen_id = $2 AND status <> 'completed'"
+		_, err = tx.Exec(ctx, query, status, regimenID)
+		return err
+	})
+	var domainErr *schedule.Error
+	if errors.As(err, &domainErr) {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("failed update regimen schedules: %w", err)
+	}
+
+	fmt.Fprintf(w, "regimen is %s now", status)
+	return nil
+}
+
+func (srv *Server) deleteRegimenHandler(w http.ResponseWriter, r *http.Request) error {
+	regimenID := r.PathValue("id")
+
+	ctx := context.Background()
+	err := srv.db.InTx(ctx, func(tx pgx.Tx) error {
+		err := lockRegimen(tx, regimenID, currentUserID(r))
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(ctx, "DELETE FROM schedule WHERE regimen_id = $1", regimenID)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, "DELETE FROM regimen WHERE id = $1", regimenID)
+		return err
+	})
+	var domainErr *schedule.Error
+	if errors.As(err, &domainErr) {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("failed delete regimen from database: %w", err)
+	}
+
+	fmt.Fprintf(w, "delete regimen from database success")
+	return nil
+}
+
+// locks the regimen row for the rest of the transaction, not found when it does not exist
+// or does not belong to the authenticated user
+func lockRegimen(tx pgx.Tx, regimenID string, userID string) error {
+	var id int
+	err := tx.QueryRow(context.Background(), "SELECT id FROM regimen WHERE id = $1 AND ($2 = '' OR user_id = $2) FOR UPDATE", regimenID, userID).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return schedule.Errorf(schedule.ErrNotFound, "regimen not found")
+	}
+	if err != nil {
+		return fmt.Errorf("failed get regimen from database: %w", err)
+	}
+
+	return nil
+}
+
+func (srv *Server) getRegimenNextTakingsHandler(w http.ResponseWriter, r *http.Request) error {
+	schedules, err := srv.listRegimenSchedules(context.Background(), r.PathValue("id"), currentUserID(r))
+	if err != nil {
+		return fmt.Errorf("failed get schedules from database: %w", err)
+	}
+
+	// the schedules of a regimen all belong to one user
+	loc := time.UTC
+	if len(schedules) > 0 {
+		loc, err = srv.userLocation(r, schedules[0].UserID)
+		if err != nil {
+			return err
+		}
+	}
+
+	takeSchedules := schedule.NextTakings(schedules, time.Now(), loc)
+	if takeSchedules == nil {
+		takeSchedules = []schedule.TakeSchedule{}
+	}
+
+	writeList(w, r, takeSchedules)
+	return nil
+}
@@ -0,0 +1,383 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"kode_test/internal/pii"
+	"kode_test/internal/schedule"
+	"log"
+	"time"
+)
+
+const (
+	reminderJobInterval = time.Minute
+	// reminders that are later than this, because no instance was running, are dropped
+	reminderWindow = 5 * time.Minute
+	// a reminder still sending after this was left behind by a stopped instance
+	reminderStaleAfter = 10 * time.Minute
+)
+
+// delays before the second, third and fourth delivery of a failed reminder
+var reminderRetryDelays = []time.Duration{time.Minute, 5 * time.Minute, 15 * time.Minute}
+
+const queryActiveSchedules = "SELECT " + scheduleColumns + " FROM schedule WHERE status = 'active' ORDER BY id"
+
+// sends the reminders that are due at now, as the notification settings of the user or schedule say
+func (srv *Server) SendDueReminders(ctx context.Context, now time.Time) (int, error) {
+	return srv.sendRemindersBetween(ctx, now.Add(-reminderWindow), now)
+}
+
+// sends the reminders due after from up to now, a stopped run returns before the next dose
+func (srv *Server) sendRemindersBetween(ctx context.Context, from time.Time, now time.Time) (int, error) {
+	schedules, err := srv.collectSchedules(srv.db.Query(ctx, queryActiveSchedules))
+	if err != nil {
+		return 0, fmt.Errorf("failed get schedules from database: %w", err)
+	}
+
+	timezones := map[string]string{}
+	userSettings := map[string]schedule.NotificationSettings{}
+	scheduleSettings := map[int]schedule.NotificationSettings{}
+	err = srv.collectReminderSettings(ctx, timezones, userSettings, scheduleSettings)
+	if err != nil {
+		return 0, err
+	}
+	plans, err := srv.collectDayPlans(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	for _, s := range schedules {
+		settings, ok := scheduleSettings[s.ID]
+		if !ok {
+			settings, ok = userSettings[s.UserID]
+		}
+		if !ok {
+			settings = schedule.DefaultNotificationSettings()
+		}
+		if len(settings.Channels) == 0 {
+			continue
+		}
+		loc, err := time.LoadLocation(timezones[s.UserID])
+		if err != nil {
+			loc = time.UTC
+		}
+
+		plan, ok := plans[s.UserID]
+		if !ok {
+			plan = newDayPlan()
+		}
+
+		// repeats of the last doses of yesterday can still be due after midnight
+		local := now.In(loc)
+		for _, dayTime := range []time.Time{local.AddDate(0, 0, -1), local} {
+			dayTime, doseTimes := plan.doseTimes(s, dayTime, loc)
+			if !schedule.CheckDay(s, dayTime, dayTime.Location()) {
+				continue
+			}
+			day := schedule.LocalDate(dayTime, dayTime.Location())
+			for i, doseTime := range doseTimes {
+				doseID := schedule.DoseID(s.ID, day, i+1)
+				for attempt, at := range schedule.ReminderTimes(doseTime, settings) {
+					if at.After(now) || !at.After(from) || settings.QuietHours.Contains(at) {
+						continue
+					}
+					if stopped(ctx) {
+						return sent, nil
+					}
+					n, err := srv.sendReminder(ctx, s, doseID, doseTime, attempt, settings.Channels)
+					if err != nil {
+						return sent, err
+					}
+					sent += n
+				}
+			}
+		}
+	}
+
+	return sent, nil
+}
+
+func (srv *Server) collectReminderSettings(ctx context.Context, timezones map[string]string, userSettings map[string]schedule.NotificationSettings, scheduleSettings map[int]schedule.NotificationSettings) error {
+	rows, err := srv.db.Query(ctx, "SELECT id, timezone FROM users")
+	if err != nil {
+		return fmt.Errorf("failed get timezones from database: %w", err)
+	}
+	var userID, timezone string
+	_, err = pgx.ForEachRow(rows, []any{&userID, &timezone}, func() error {
+		timezones[userID] = timezone
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed get timezones from database: %w", err)
+	}
+
+	rows, err = srv.db.Query(ctx, "SELECT user_id, settings FROM user_notification_settings")
+	if err != nil {
+		return fmt.Errorf("failed get notification settings from database: %w", err)
+	}
+	// JSON is decoded into the existing value, it is reset so no field carries over to the next row
+	var settings schedule.NotificationSettings
+	_, err = pgx.ForEachRow(rows, []any{&userID, &settings}, func() error {
+		userSettings[userID], settings = settings, schedule.NotificationSettings{}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed get notification settings from database: %w", err)
+	}
+
+	rows, err = srv.db.Query(ctx, "SELECT schedule_id, settings FROM schedule_notification_settings")
+	if err != nil {
+		return fmt.Errorf("failed get notification settings from database: %w", err)
+	}
+	var scheduleID int
+	_, err = pgx.ForEachRow(rows, []any{&scheduleID, &settings}, func() error {
+		scheduleSettings[scheduleID], settings = settings, schedule.NotificationSettings{}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed get notification settings from database: %w", err)
+	}
+
+	return nil
+}
+
+// sends one reminder of a dose on every channel, unless the dose is confirmed already
+// or another instance sent it. Returns how many went out.
+func (srv *Server) sendReminder(ctx context.Context, s schedule.Schedule, doseID string, doseTime time.Time, attempt int, channels []string) (int, error) {
+	confirmed, err := srv.doseConfirmed(ctx, s.ID, doseID)
+	if err != nil || confirmed {
+		return 0, err
+	}
+
+	sent := 0
+	for _, channel := range channels {
+		var id int64
+		query := `INSERT INTO reminder (user_id, schedule_id, dose_id, attempt, channel, status, next_attempt_at)
+			VALUES ($1, $2, $3, $4, $5, 'sending', now() + make_interval(secs => $6))
+			ON CONFLICT (dose_id, attempt, channel) DO NOTHING RETURNING id`
+		err := srv.db.QueryRow(ctx, query, s.UserID, s.ID, doseID, attempt, channel, reminderStaleAfter.Seconds()).Scan(&id)
+		if errors.Is(err, pgx.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return sent, err
+		}
+
+		delivered, err := srv.deliverReminder(ctx, id, s, doseID, doseTime, attempt, channel, 1)
+		if err != nil {
+			return sent, err
+		}
+		if delivered {
+			sent++
+		}
+	}
+
+	return sent, nil
+}
+
+// a skipped dose needs no reminder either
+func (srv *Server) doseConfirmed(ctx context.Context, scheduleID int, doseID string) (bool, error) {
+	var confirmed bool
+	query := `SELECT EXISTS (SELECT 1 FROM intake_log WHERE schedule_id = $1 AND dose_id = $2)
+		OR EXISTS (SELECT 1 FROM dose_skip WHERE schedule_id = $1 AND dose_id = $2)`
+	err := srv.db.QueryRow(ctx, query, scheduleID, doseID).Scan(&confirmed)
+
+	return confirmed, err
+}
+
+// sends a claimed reminder and records the outcome. A failed delivery is tried again after the
+// delay of its number, after the last one the reminder is dead and waits for an admin to replay it.
+func (srv *Server) deliverReminder(ctx context.Context, id int64, s schedule.Schedule, doseID string, doseTime time.Time, attempt int, channel string, deliveries int) (bool, error) {
+	subject := "Time to take " + s.Medicine
+	if attempt > 0 {
+		subject = "Reminder: " + s.Medicine + " is not confirmed yet"
+	}
+	message := fmt.Sprintf("%s is due at %s. Confirm it with dose %s.", s.Medicine, doseTime.Format("15:04"), doseID)
+	if s.Dose != nil {
+		message += "\nTake " + schedule.FormatDose(*s.Dose, s.Strength) + "."
+	}
+	if s.Instructions != "" {
+		message += "\n" + schedule.PlainInstructions(s.Instructions)
+	}
+
+	var response string
+	var err error
+	if channel == "voice" {
+		response, err = srv.callReminder(ctx, id, s.UserID)
+	} else {
+		response, err = srv.notifier.NotifyOn(ctx, channel, s.UserID, subject, message)
+	}
+	if err == nil {
+		_, err = srv.db.Exec(ctx, "UPDATE reminder SET status = 'sent', provider_response = $2, error = NULL, next_attempt_at = NULL, sent_at = now() WHERE id = $1", id, response)
+		return true, err
+	}
+
+	log.Printf("reminder job: send %s reminder to %s: %v", channel, pii.MaskUserID(s.UserID), err)
+	status, nextAttempt := "dead", (*time.Time)(nil)
+	if deliveries <= len(reminderRetryDelays) {
+		at := time.Now().Add(reminderRetryDelays[deliveries-1])
+		status, nextAttempt = "failed", &at
+	}
+	_, err = srv.db.Exec(ctx, "UPDATE reminder SET status = $2, error = $3, next_attempt_at = $4 WHERE id = $1", id, status, pii.Redact(err.Error()), nextAttempt)
+	return false, err
+}
+
+// tries failed reminders again whose delay is over, and reminders an instance stopped sending
+func (srv *Server) RetryFailedReminders(ctx context.Context) (int, error) {
+	query := `UPDATE reminder SET status = 'sending', deliveries = deliveries + 1, next_attempt_at = now() + make_interval(secs => $1) WHERE id IN (
+			SELECT id FROM reminder WHERE status IN ('failed', 'sending') AND next_attempt_at <= now()
+			ORDER BY next_attempt_at LIMIT 100 FOR UPDATE SKIP LOCKED
+		) RETURNING id, user_id, schedule_id, dose_id, attempt, channel, deliveries`
+	rows, err := srv.db.Query(ctx, query, reminderStaleAfter.Seconds())
+	if err != nil {
+		return 0, err
+	}
+	type claimed struct {
+		id                  int64
+		userID, doseID      string
+		scheduleID, attempt int
+		channel             string
+		deliveries          int
+	}
+	reminders, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (claimed, error) {
+		var c claimed
+		err := row.Scan(&c.id, &c.userID, &c.scheduleID, &c.doseID, &c.attempt, &c.channel, &c.deliveries)
+		return c, err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	for i, c := range reminders {
+		if stopped(ctx) {
+			// what is left goes back to the queue for another instance right away
+			ids := make([]int64, 0, len(reminders)-i)
+			for _, left := range reminders[i:] {
+				ids = append(ids, left.id)
+			}
+			_, err := srv.db.Exec(ctx, "UPDATE reminder SET deliveries = deliveries - 1, next_attempt_at = now() WHERE id = ANY($1) AND status = 'sending'", ids)
+			return sent, err
+		}
+		s, doseTime, err := srv.reminderDose(ctx, c.userID, c.scheduleID, c.doseID)
+		var domainErr *schedule.Error
+		if errors.As(err, &domainErr) {
+			// nothing left to remind of, the reminder is parked for an admin to look at
+			_, err = srv.db.Exec(ctx, "UPDATE reminder SET status = 'dead', error = $2, next_attempt_at = NULL WHERE id = $1", c.id, domainErr.Error())
+			if err != nil {
+				return sent, err
+			}
+			continue
+		}
+		if err != nil {
+			return sent, err
+		}
+		confirmed, err := srv.doseConfirmed(ctx, c.scheduleID, c.doseID)
+		if err != nil {
+			return sent, err
+		}
+		if confirmed {
+			_, err = srv.db.Exec(ctx, "UPDATE reminder SET status = 'cancelled', next_attempt_at = NULL WHERE id = $1", c.id)
+			if err != nil {
+				return sent, err
+			}
+			continue
+		}
+
+		delivered, err := srv.deliverReminder(ctx, c.id, s, c.doseID, doseTime, c.attempt, c.channel, c.deliveries)
+		if err != nil {
+			return sent, err
+		}
+		if delivered {
+			sent++
+		}
+	}
+
+	return sent, nil
+}
+
+// the schedule of a reminder and the planned time of its dose in the user's timezone
+func (srv *Server) reminderDose(ctx context.Context, userID string, scheduleID int, doseID string) (schedule.Schedule, time.Time, error) {
+	s, err := srv.getUserSchedule(ctx, userID, scheduleID)
+	if err != nil {
+		return s, time.Time{}, err
+	}
+	_, day, slot, err := schedule.ParseDoseID(doseID)
+	if err != nil {
+		return s, time.Time{}, err
+	}
+
+	var timezone string
+	err = srv.db.QueryRow(ctx, "SELECT timezone FROM users WHERE id = $1", userID).Scan(&timezone)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return s, time.Time{}, err
+	}
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	plan, err := srv.userDayPlan(ctx, userID)
+	if err != nil {
+		return s, time.Time{}, err
+	}
+
+	_, doseTimes := plan.doseTimes(s, time.Date(day.Year(), day.Month(), day.Day(), 12, 0, 0, 0, loc), loc)
+	if slot > len(doseTimes) {
+		// the schedule has fewer doses a day since, the reminder keeps the time of the last one
+		slot = len(doseTimes)
+	}
+	if slot == 0 {
+		return s, time.Time{}, schedule.Errorf(schedule.ErrNotFound, "dose not found")
+	}
+
+	return s, doseTimes[slot-1], nil
+}
+
+// a run that is stopped by shutdown finishes the delivery it started. The checkpoint only
+// moves after a complete run, so the next instance sends what this one did not get to.
+func (srv *Server) RunReminderJob(ctx context.Context) {
+	ticker := time.NewTicker(reminderJobInterval)
+	defer ticker.Stop()
+
+	work := drainable(ctx)
+	checkpoint, err := srv.loadCheckpoint(work, "reminders")
+	if err != nil {
+		log.Printf("reminder job: checkpoint: %v", err)
+	}
+
+	for {
+		if !srv.inMaintenance() {
+			// reminders later than the window are dropped even after a long pause
+			now := time.Now()
+			from := now.Add(-reminderWindow)
+			if checkpoint.After(from) {
+				from = checkpoint
+			}
+			_, err := srv.sendRemindersBetween(work, from, now)
+			if err != nil {
+				log.Printf("reminder job: %v", err)
+			} else if !stopped(work) {
+				checkpoint = now
+				err = srv.saveCheckpoint(work, "reminders", now)
+				if err != nil {
+					log.Printf("reminder job: checkpoint: %v", err)
+				}
+			}
+			if !stopped(work) {
+				_, err = srv.RetryFailedReminders(work)
+				if err != nil {
+					log.Printf("reminder job: retry: %v", err)
+				}
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,287 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"kode_test/internal/pdf"
+	"kode_test/internal/schedule"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	reportJobInterval = 5 * time.Second
+	// a report running longer was left behind by a stopped instance and is started again
+	reportStaleAfter = 10 * time.Minute
+	// the longest period of one report
+	maxReportDays = 366
+)
+
+var reportContentTypes = map[string]string{
+	"csv": "text/csv; charset=utf-8",
+	"pdf": "application/pdf",
+}
+
+type Report struct {
+	ID          string     `json:"id"`
+	Kind        string     `json:"kind"`
+	Format      string     `json:"format"`
+	From        string     `json:"from"`
+	To          string     `json:"to"`
+	Status      string     `json:"status"`
+	Error       string     `json:"error,omitempty"`
+	DownloadURL string     `json:"download_url,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty"`
+}
+
+// one purchase on a reimbursement report
+type reimbursementItem struct {
+	FilledOn   string
+	Medicine   string
+	Quantity   int
+	CostCents  int64
+	CopayCents int64
+	Currency   string
+	Prescriber string
+	Pharmacy   string
+}
+
+// queues an itemized report of the refills in a period for insurers,
+// the report is produced in the background and polled at the returned status URL
+func (srv *Server) createReimbursementReportHandler(w http.ResponseWriter, r *http.Request) error {
+	var report Report
+	err := json.NewDecoder(r.Body).Decode(&report)
+	if err != nil {
+		return schedule.Errorf(schedule.ErrValidation, "invalid report format")
+	}
+	if report.Format == "" {
+		report.Format = "pdf"
+	}
+	if _, ok := reportContentTypes[report.Format]; !ok {
+		return schedule.Errorf(schedule.ErrValidation, "invalid format, expected csv or pdf")
+	}
+	from, errFrom := time.Parse("2006-01-02", report.From)
+	to, errTo := time.Parse("2006-01-02", report.To)
+	if errFrom != nil || errTo != nil || to.Before(from) || to.Sub(from) > maxReportDays*24*time.Hour {
+		return schedule.Errorf(schedule.ErrValidation, "from and to are required as YYYY-MM-DD and span at most %d days", maxReportDays)
+	}
+
+	userID, err := requestUserID(r)
+	if err != nil {
+		return err
+	}
+
+	query := "INSERT INTO report (user_id, kind, format, period_from, period_to) VALUES ($1, 'reimbursement', $2, $3, $4) RETURNING id::text, kind, status, created_at"
+	err = srv.db.QueryRow(context.Background(), query, userID, report.Format, report.From, report.To).Scan(&report.ID, &report.Kind, &report.Status, &report.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed save report: %w", err)
+	}
+	srv.wakeReportJob()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/v1/reports/"+report.ID)
+	w.WriteHeader(http.StatusAccepted)
+	fmt.Fprint(w, convertToJson(report))
+	return nil
+}
+
+func (srv *Server) getReportHandler(w http.ResponseWriter, r *http.Request) error {
+	userID, err := requestUserID(r)
+	if err != nil {
+		return err
+	}
+
+	var report Report
+	var message *string
+	query := "SELECT id::text, kind, format, to_char(period_from, 'YYYY-MM-DD'), to_char(period_to, 'YYYY-MM-DD'), status, error, created_at, finished_at FROM report WHERE id::text = $1 AND user_id = $2"
+	err = srv.db.QueryRow(context.Background(), query, r.PathValue("id"), userID).Scan(&report.ID, &report.Kind, &report.Format, &report.From, &report.To, &report.Status, &message, &report.CreatedAt, &report.FinishedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return schedule.Errorf(schedule.ErrNotFound, "report not found")
+	}
+	if err != nil {
+		return fmt.Errorf("failed get report from database: %w", err)
+	}
+	if message != nil {
+		report.Error = *message
+	}
+	if report.Status == "done" {
+		report.DownloadURL = "/v1/reports/" + report.ID + "/download"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, convertToJson(report))
+	return nil
+}
+
+func (srv *Server) downloadReportHandler(w http.ResponseWriter, r *http.Request) error {
+	userID, err := requestUserID(r)
+	if err != nil {
+		return err
+	}
+
+	var format, from, to string
+	var content []byte
+	query := "SELECT format, to_char(period_from, 'YYYY-MM-DD'), to_char(period_to, 'YYYY-MM-DD'), content FROM report WHERE id::text = $1 AND user_id = $2 AND status = 'done'"
+	err = srv.db.QueryRow(context.Background(), query, r.PathValue("id"), userID).Scan(&format, &from, &to, &content)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return schedule.Errorf(schedule.ErrNotFound, "report not found or not finished yet")
+	}
+	if err != nil {
+		return fmt.Errorf("failed get report from database: %w", err)
+	}
+
+	w.Header().Set("Content-Type", reportContentTypes[format])
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="reimbursement-%s-%s.%s"`, from, to, format))
+	w.Write(content)
+	return nil
+}
+
+// starts the worker early after a report was queued, a full channel means it is awake already
+func (srv *Server) wakeReportJob() {
+	select {
+	case srv.reportWake <- struct{}{}:
+	default:
+	}
+}
+
+// produces queued reports one at a time, several instances share the queue
+func (srv *Server) RunReportJob(ctx context.Context) {
+	ticker := time.NewTicker(reportJobInterval)
+	defer ticker.Stop()
+
+	for {
+		for !srv.inMaintenance() && ctx.Err() == nil {
+			produced, err := srv.produceNextReport(drainable(ctx))
+			if err != nil {
+				log.Printf("report job: %v", err)
+			}
+			if !produced {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-srv.reportWake:
+		}
+	}
+}
+
+// claims the oldest queued report, false when there was none
+func (srv *Server) produceNextReport(ctx context.Context) (bool, error) {
+	var id, userID, format string
+	var from, to time.Time
+	query := `UPDATE report SET status = 'running', started_at = now() WHERE id = (
+			SELECT id FROM report WHERE status = 'pending' OR (status = 'running' AND started_at < $1)
+			ORDER BY created_at LIMIT 1 FOR UPDATE SKIP LOCKED
+		) RETURNING id::text, user_id, format, period_from, period_to`
+	err := srv.db.QueryRow(ctx, query, time.Now().Add(-reportStaleAfter)).Scan(&id, &userID, &format, &from, &to)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	content, err := srv.reimbursementReport(ctx, userID, format, from, to)
+	if err != nil {
+		log.Printf("report job: report %s: %v", id, err)
+		_, err = srv.db.Exec(ctx, "UPDATE report SET status = 'failed', error = 'failed produce report', finished_at = now() WHERE id = $1::uuid", id)
+		return true, err
+	}
+
+	_, err = srv.db.Exec(ctx, "UPDATE report SET status = 'done', content = $1, finished_at = now() WHERE id = $2::uuid", content, id)
+	return true, err
+}
+
+func (srv *Server) reimbursementReport(ctx context.Context, userID string, format string, from time.Time, to time.Time) ([]byte, error) {
+	query := `SELECT to_char(f.filled_on, 'YYYY-MM-DD'), s.medicine, f.quantity, f.cost_cents, f.copay_cents, f.currency,
+			s.prescriber, s.pharmacy
+		FROM refill f JOIN schedule s ON s.id = f.schedule_id
+		WHERE f.user_id = $1 AND f.filled_on BETWEEN $2 AND $3
+		ORDER BY f.filled_on, f.created_at`
+	rows, err := srv.db.QueryRead(ctx, query, userID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	items, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (reimbursementItem, error) {
+		var item reimbursementItem
+		var prescriber, pharmacy []byte
+		err := row.Scan(&item.FilledOn, &item.Medicine, &item.Quantity, &item.CostCents, &item.CopayCents, &item.Currency, &prescriber, &pharmacy)
+		if err == nil {
+			item.Medicine, err = srv.cipher.Decrypt(item.Medicine)
+		}
+		if err == nil {
+			item.Prescriber, err = srv.contactName(prescriber)
+		}
+		if err == nil {
+			item.Pharmacy, err = srv.contactName(pharmacy)
+		}
+		return item, err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	period := from.Format("2006-01-02") + " to " + to.Format("2006-01-02")
+	if format == "csv" {
+		return reimbursementCSV(items)
+	}
+	return reimbursementPDF(items, period), nil
+}
+
+func formatCents(cents int64) string {
+	return fmt.Sprintf("%d.%02d", cents/100, cents%100)
+}
+
+func reimbursementCSV(items []reimbursementItem) ([]byte, error) {
+	var out bytes.Buffer
+	writer := csv.NewWriter(&out)
+	writer.Write([]string{"date", "medicine", "quantity", "cost", "copay", "currency", "prescriber", "pharmacy"})
+	for _, item := range items {
+		writer.Write([]string{item.FilledOn, item.Medicine, strconv.Itoa(item.Quantity), formatCents(item.CostCents), formatCents(item.CopayCents), item.Currency, item.Prescriber, item.Pharmacy})
+	}
+	writer.Flush()
+
+	return out.Bytes(), writer.Error()
+}
+
+func reimbursementPDF(items []reimbursementItem, period string) []byte {
+	doc := pdf.New()
+	doc.Heading("Medication expenses")
+	doc.Text("Period: " + period)
+	doc.Space()
+
+	widths := []float64{65, 120, 35, 55, 55, 30, 70, 65}
+	doc.Row([]string{"Date", "Medicine", "Qty", "Cost", "Copay", "Cur.", "Prescriber", "Pharmacy"}, widths, true)
+	totals := map[string][2]int64{}
+	var currencies []string
+	for _, item := range items {
+		doc.Row([]string{item.FilledOn, item.Medicine, strconv.Itoa(item.Quantity), formatCents(item.CostCents), formatCents(item.CopayCents), item.Currency, item.Prescriber, item.Pharmacy}, widths, false)
+		total, seen := totals[item.Currency]
+		if !seen {
+			currencies = append(currencies, item.Currency)
+		}
+		totals[item.Currency] = [2]int64{total[0] + item.CostCents, total[1] + item.CopayCents}
+	}
+	if len(items) == 0 {
+		doc.Text("No refills were recorded in this period.")
+	}
+
+	doc.Space()
+	for _, currency := range currencies {
+		doc.Row([]string{"Total", "", "", formatCents(totals[currency][0]), formatCents(totals[currency][1]), currency}, widths, true)
+	}
+
+	return doc.Bytes()
+}
@@ -0,0 +1,215 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"kode_test/internal/schedule"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+const retentionJobInterval = 24 * time.Hour
+
+type RetentionRule struct {
+	Target     string    `json:"target"`
+	Action     string    `json:"action"`
+	MaxAgeDays int       `json:"max_age_days"`
+	Enabled    bool      `json:"enabled"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+type RetentionReport struct {
+	Target   string    `json:"target"`
+	Action   string    `json:"action"`
+	DryRun   bool      `json:"dry_run"`
+	Cutoff   time.Time `json:"cutoff"`
+	Affected int64     `json:"affected"`
+}
+
+// rows a rule applies to, $1 is the cutoff
+type retentionTarget struct {
+	table   string
+	where   string
+	actions map[string]string
+}
+
+var retentionTargets = map[string]retentionTarget{
+	"archived_schedules": {
+		table: "schedule",
+		where: "status = 'archived' AND updated_at < $1",
+		actions: map[string]string{
+			"purge": "DELETE FROM schedule",
+		},
+	},
+	"reports": {
+		table: "report",
+		where: "created_at < $1",
+		actions: map[string]string{
+			"purge": "DELETE FROM report",
+		},
+	},
+	"reminders": {
+		table: "reminder",
+		where: "sent_at < $1",
+		actions: map[string]string{
+			"purge": "DELETE FROM reminder",
+		},
+	},
+	"intakes": {
+		table: "intake_log",
+		where: "user_id <> '' AND taken_at < $1",
+		actions: map[string]string{
+			"purge":     "DELETE FROM intake_log",
+			"anonymize": "UPDATE intake_log SET user_id = '', schedule_id = NULL",
+		},
+	},
+}
+
+func (srv *Server) getRetentionRules(ctx context.Context) ([]RetentionRule, error) {
+	rows, err := srv.db.Query(ctx, "SELECT target, action, max_age_days, enabled, updated_at FROM retention_rule ORDER BY target")
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (RetentionRule, error) {
+		var rule RetentionRule
+		err := row.Scan(&rule.Target, &rule.Action, &rule.MaxAgeDays, &rule.Enabled, &rule.UpdatedAt)
+		return rule, err
+	})
+}
+
+// applies every enabled rule in its own transaction together with its audit entry,
+// a dry run only counts the rows the rule would change
+func (srv *Server) ApplyRetention(ctx context.Context, dryRun bool) ([]RetentionReport, error) {
+	rules, err := srv.getRetentionRules(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	reports := []RetentionReport{}
+	for _, rule := range rules {
+		target, ok := retentionTargets[rule.Target]
+		statement := target.actions[rule.Action]
+		if !ok || statement == "" {
+			log.Printf("retention: skipping unsupported rule %s %s", rule.Action, rule.Target)
+			continue
+		}
+		if !rule.Enabled {
+			continue
+		}
+
+		report := RetentionReport{Target: rule.Target, Action: rule.Action, DryRun: dryRun, Cutoff: time.Now().AddDate(0, 0, -rule.MaxAgeDays)}
+		err := srv.db.InTx(ctx, func(tx pgx.Tx) error {
+			if dryRun {
+				err := tx.QueryRow(ctx, "SELECT count(*) FROM "+target.table+" WHERE "+target.where, report.Cutoff).Scan(&report.Affected)
+				if err != nil {
+					return err
+				}
+			} else {
+				tag, err := tx.Exec(ctx, statement+" WHERE "+target.where, report.Cutoff)
+				if err != nil {
+					return err
+				}
+				report.Affected = tag.RowsAffected()
+			}
+
+			query := "INSERT INTO retention_audit (target, action, dry_run, cutoff, affected) VALUES ($1, $2, $3, $4, $5)"
+			_, err := tx.Exec(ctx, query, report.Target, report.Action, report.DryRun, report.Cutoff, report.Affected)
+			return err
+		})
+		if err != nil {
+			return reports, fmt.Errorf("%s %s: %w", rule.Action, rule.Target, err)
+		}
+		reports = append(reports, report)
+	}
+
+	return reports, nil
+}
+
+// RETENTION_DRY_RUN=true makes the worker only report what the rules would change
+func (srv *Server) RunRetentionJob(ctx context.Context) {
+	ticker := time.NewTicker(retentionJobInterval)
+	defer ticker.Stop()
+
+	for {
+		if !srv.inMaintenance() {
+			err := srv.exclusively(drainable(ctx), "retention", func(ctx context.Context) error {
+				reports, err := srv.ApplyRetention(ctx, os.Getenv("RETENTION_DRY_RUN") == "true")
+				for _, report := range reports {
+					log.Printf("retention job: %s %s before %s: %d rows (dry run: %t)", report.Action, report.Target, report.Cutoff.Format(time.RFC3339), report.Affected, report.DryRun)
+				}
+				return err
+			})
+			if err != nil {
+				log.Printf("retention job: %v", err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (srv *Server) getRetentionRulesHandler(w http.ResponseWriter, r *http.Request) error {
+	rules, err := srv.getRetentionRules(context.Background())
+	if err != nil {
+		return fmt.Errorf("failed get retention rules from database: %w", err)
+	}
+
+	fmt.Fprint(w, convertToJson(rules))
+	return nil
+}
+
+func (srv *Server) putRetentionRuleHandler(w http.ResponseWriter, r *http.Request) error {
+	var rule RetentionRule
+	err := json.NewDecoder(r.Body).Decode(&rule)
+	if err != nil {
+		return schedule.Errorf(schedule.ErrValidation, "invalid retention rule format")
+	}
+
+	rule.Target = r.PathValue("target")
+	target, ok := retentionTargets[rule.Target]
+	if !ok {
+		return schedule.Errorf(schedule.ErrNotFound, "unknown retention target: %s", rule.Target)
+	}
+	var errs []error
+	if _, ok := target.actions[rule.Action]; !ok {
+		errs = append(errs, schedule.FieldErrorf("action", "enum", "unsupported action for %s: %s", rule.Target, rule.Action))
+	}
+	if rule.MaxAgeDays < 1 {
+		errs = append(errs, schedule.FieldErrorf("max_age_days", "min", "max_age_days must be positive"))
+	}
+	err = schedule.JoinFields(errs...)
+	if err != nil {
+		return err
+	}
+
+	query := `INSERT INTO retention_rule (target, action, max_age_days, enabled) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (target) DO UPDATE SET action = EXCLUDED.action, max_age_days = EXCLUDED.max_age_days, enabled = EXCLUDED.enabled, updated_at = now()
+		RETURNING updated_at`
+	err = srv.db.QueryRow(context.Background(), query, rule.Target, rule.Action, rule.MaxAgeDays, rule.Enabled).Scan(&rule.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed save retention rule in database: %w", err)
+	}
+
+	fmt.Fprint(w, convertToJson(rule))
+	return nil
+}
+
+// runs the rules now, dry_run=true reports without changing anything
+func (srv *Server) runRetentionHandler(w http.ResponseWriter, r *http.Request) error {
+	reports, err := srv.ApplyRetention(context.Background(), r.URL.Query().Get("dry_run") == "true")
+	if err != nil {
+		return fmt.Errorf("failed apply retention rules: %w", err)
+	}
+
+	fmt.Fprint(w, convertToJson(reports))
+	return nil
+}
@@ -0,0 +1,323 @@
+package http
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"io"
+	"kode_test/internal/schedule"
+	"log"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	rosterJobInterval = 5 * time.Second
+	// an import running longer was left behind by a stopped instance and is started again
+	rosterStaleAfter = 10 * time.Minute
+	maxRosterBytes   = 5 << 20
+	maxRosterSize    = 1000
+	// onboarded patients choose their password through the reset link
+	rosterInviteTTL = 7 * 24 * time.Hour
+)
+
+// a patient of a roster, a CSV roster has a row per schedule and the rows of an email are joined
+type RosterPatient struct {
+	Email     string           `json:"email"`
+	Timezone  string           `json:"timezone,omitempty"`
+	Schedules []RosterSchedule `json:"schedules,omitempty"`
+}
+
+type RosterSchedule struct {
+	Medicine  string `json:"medicine"`
+	Frequency int    `json:"frequency"`
+	Duration  int    `json:"duration"`
+}
+
+type RosterResult struct {
+	// position of the patient in the roster, counted from 1
+	Row   int    `json:"row"`
+	Email string `json:"email"`
+	// created or failed
+	Status    string `json:"status"`
+	UserID    string `json:"user_id,omitempty"`
+	Schedules int    `json:"schedules"`
+	Error     string `json:"error,omitempty"`
+}
+
+type RosterImport struct {
+	ID         string         `json:"id"`
+	Status     string         `json:"status"`
+	Patients   int            `json:"patients"`
+	Results    []RosterResult `json:"results,omitempty"`
+	CreatedAt  time.Time      `json:"created_at"`
+	FinishedAt *time.Time     `json:"finished_at,omitempty"`
+}
+
+// queues the accounts of a clinic's patients from a CSV or JSON roster, every patient is invited
+// by email and the import is polled at the returned status URL for a result per patient
+func (srv *Server) createRosterImportHandler(w http.ResponseWriter, r *http.Request) error {
+	// patients sign in through the emailed link, without email the accounts would be unreachable
+	if !srv.notifier.CanEmail() {
+		http.Error(w, "email is not configured", http.StatusNotImplemented)
+		return nil
+	}
+
+	ctx := context.Background()
+	org, err := srv.staffOrganization(ctx, r)
+	if err != nil {
+		return err
+	}
+
+	body := http.MaxBytesReader(w, r.Body, maxRosterBytes)
+	var patients []RosterPatient
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "text/csv":
+		patients, err = parseRosterCSV(body)
+	case "application/json":
+		err = json.NewDecoder(body).Decode(&patients)
+		if err != nil {
+			err = schedule.Errorf(schedule.ErrValidation, "invalid roster format")
+		}
+	default:
+		err = schedule.Errorf(schedule.ErrValidation, "the roster must be text/csv or application/json")
+	}
+	if err != nil {
+		return err
+	}
+	if len(patients) == 0 || len(patients) > maxRosterSize {
+		return schedule.Errorf(schedule.ErrValidation, "a roster has 1 to %d patients", maxRosterSize)
+	}
+
+	roster := RosterImport{Patients: len(patients)}
+	query := "INSERT INTO roster_import (org_id, created_by, patients) VALUES ($1::uuid, $2, $3) RETURNING id::text, status, created_at"
+	err = srv.db.QueryRow(ctx, query, org.ID, currentUserID(r), patients).Scan(&roster.ID, &roster.Status, &roster.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed save roster: %w", err)
+	}
+	srv.wakeRosterJob()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/v1/orgs/"+org.ID+"/rosters/"+roster.ID)
+	w.WriteHeader(http.StatusAccepted)
+	fmt.Fprint(w, convertToJson(roster))
+	return nil
+}
+
+// reads a roster with the columns email, timezone, medicine, frequency and duration,
+// the header names the columns and only email is required
+func parseRosterCSV(body io.Reader) ([]RosterPatient, error) {
+	reader := csv.NewReader(body)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, schedule.Errorf(schedule.ErrValidation, "invalid roster csv: %v", err)
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	columns := map[string]int{}
+	for i, name := range records[0] {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, ok := columns["email"]; !ok {
+		return nil, schedule.Errorf(schedule.ErrValidation, "the roster csv needs an email column")
+	}
+	field := func(record []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	var patients []RosterPatient
+	byEmail := map[string]int{}
+	for line, record := range records[1:] {
+		email := strings.ToLower(field(record, "email"))
+		i, ok := byEmail[email]
+		if !ok {
+			i = len(patients)
+			byEmail[email] = i
+			patients = append(patients, RosterPatient{Email: email, Timezone: field(record, "timezone")})
+		}
+
+		medicine := field(record, "medicine")
+		if medicine == "" {
+			continue
+		}
+		frequency, errFrequency := strconv.Atoi(field(record, "frequency"))
+		duration, errDuration := strconv.Atoi(field(record, "duration"))
+		if errFrequency != nil || errDuration != nil {
+			return nil, schedule.Errorf(schedule.ErrValidation, "line %d: frequency and duration must be numbers", line+2)
+		}
+		patients[i].Schedules = append(patients[i].Schedules, RosterSchedule{Medicine: medicine, Frequency: frequency, Duration: duration})
+	}
+
+	return patients, nil
+}
+
+func (srv *Server) getRosterImportHandler(w http.ResponseWriter, r *http.Request) error {
+	ctx := context.Background()
+	org, err := srv.staffOrganization(ctx, r)
+	if err != nil {
+		return err
+	}
+
+	var roster RosterImport
+	query := `SELECT id::text, status, jsonb_array_length(patients), COALESCE(results, '[]'), created_at, finished_at
+		FROM roster_import WHERE id::text = $1 AND org_id = $2::uuid`
+	err = srv.db.QueryRow(ctx, query, r.PathValue("roster_id"), org.ID).Scan(&roster.ID, &roster.Status, &roster.Patients, &roster.Results, &roster.CreatedAt, &roster.FinishedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return schedule.Errorf(schedule.ErrNotFound, "roster not found")
+	}
+	if err != nil {
+		return fmt.Errorf("failed get roster from database: %w", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, convertToJson(roster))
+	return nil
+}
+
+// starts the worker early after a roster was queued, a full channel means it is awake already
+func (srv *Server) wakeRosterJob() {
+	select {
+	case srv.rosterWake <- struct{}{}:
+	default:
+	}
+}
+
+// imports queued rosters one at a time, several instances share the queue
+func (srv *Server) RunRosterJob(ctx context.Context) {
+	ticker := time.NewTicker(rosterJobInterval)
+	defer ticker.Stop()
+
+	for {
+		for !srv.inMaintenance() && ctx.Err() == nil {
+			imported, err := srv.importNextRoster(drainable(ctx))
+			if err != nil {
+				log.Printf("roster job: %v", err)
+			}
+			if !imported {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-srv.rosterWake:
+		}
+	}
+}
+
+// claims the oldest queued roster, false when there was none
+func (srv *Server) importNextRoster(ctx context.Context) (bool, error) {
+	var id, orgID, orgName string
+	var patients []RosterPatient
+	query := `UPDATE roster_import r SET status = 'running', started_at = now() FROM organization o
+		WHERE o.id = r.org_id AND r.id = (
+			SELECT id FROM roster_import WHERE status = 'pending' OR (status = 'running' AND started_at < $1)
+			ORDER BY created_at LIMIT 1 FOR UPDATE SKIP LOCKED
+		) RETURNING r.id::text, r.org_id::text, o.name, r.patients`
+	err := srv.db.QueryRow(ctx, query, time.Now().Add(-rosterStaleAfter)).Scan(&id, &orgID, &orgName, &patients)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	results := make([]RosterResult, len(patients))
+	for i, patient := range patients {
+		results[i] = srv.onboardPatient(ctx, orgID, orgName, patient)
+		results[i].Row = i + 1
+	}
+
+	_, err = srv.db.Exec(ctx, "UPDATE roster_import SET status = 'done', results = $1, finished_at = now() WHERE id = $2::uuid", results, id)
+	return true, err
+}
+
+// creates the account, its organization membership and schedules together, then sends the invite
+func (srv *Server) onboardPatient(ctx context.Context, orgID string, orgName string, patient RosterPatient) RosterResult {
+	result := RosterResult{Email: patient.Email, Status: "failed", Schedules: len(patient.Schedules)}
+	fail := func(message string) RosterResult {
+		result.Error = message
+		return result
+	}
+
+	credentials := Credentials{Email: patient.Email, Password: randomHex(32), Timezone: patient.Timezone}
+	if message := ValidateSignup(&credentials); message != "" {
+		return fail(message)
+	}
+	result.Email = credentials.Email
+	for _, s := range patient.Schedules {
+		if strings.TrimSpace(s.Medicine) == "" || s.Frequency < 0 || s.Duration < 1 {
+			return fail("every schedule needs a medicine, a frequency of 0 or more days and at least 1 dose a day")
+		}
+		issues, err := srv.checkScheduleSafety(schedule.Schedule{Medicine: s.Medicine, Frequency: s.Frequency, Duration: s.Duration})
+		if err != nil {
+			log.Printf("roster job: check safety: %v", err)
+			return fail("failed check schedule safety")
+		}
+		if i := slices.IndexFunc(issues, func(issue SafetyIssue) bool { return issue.Severity == "error" }); i >= 0 {
+			return fail(issues[i].Message)
+		}
+	}
+
+	err := srv.db.InTx(ctx, func(tx pgx.Tx) error {
+		userID, err := createUser(ctx, tx, credentials)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, "INSERT INTO org_member (org_id, user_id, role) VALUES ($1::uuid, $2, 'patient')", orgID, userID)
+		if err != nil {
+			return err
+		}
+		err = lockScheduleQuota(ctx, tx, userID, len(patient.Schedules))
+		if err != nil {
+			return err
+		}
+		for _, s := range patient.Schedules {
+			medicine, hash, err := srv.cipher.SealMedicine(strings.TrimSpace(s.Medicine))
+			if err != nil {
+				return err
+			}
+			query := "INSERT INTO schedule (medicine, medicine_hash, frequency, duration, user_id) VALUES ($1, $2, $3, $4, $5)"
+			_, err = tx.Exec(ctx, query, medicine, hash, s.Frequency, s.Duration, userID)
+			if err != nil {
+				return err
+			}
+		}
+		result.UserID = userID
+		return nil
+	})
+	var domainErr *schedule.Error
+	if errors.Is(err, ErrEmailTaken) || errors.As(err, &domainErr) {
+		return fail(err.Error())
+	}
+	if err != nil {
+		log.Printf("roster job: onboard patient: %v", err)
+		return fail("failed create account")
+	}
+
+	// the account exists either way, staff can have the patient use the password reset
+	err = srv.sendUserToken(ctx, result.UserID, "reset_password", rosterInviteTTL, "You have been invited by "+orgName, "/reset-password")
+	if err != nil {
+		log.Printf("roster job: send invite: %v", err)
+		result.Error = "account created but the invite email failed"
+	}
+	result.Status = "created"
+	return result
+}
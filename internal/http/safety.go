@@ -0,0 +1,212 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"kode_test/internal/schedule"
+	"kode_test/internal/storage"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type SafetyRule struct {
+	Medicine      string   `json:"medicine"`
+	MinGapHours   *float64 `json:"min_gap_hours"`
+	MaxDailyDoses *int     `json:"max_daily_doses"`
+	// weight based dosing, per dose
+	MgPerKgMin   *float64 `json:"mg_per_kg_min"`
+	MgPerKgMax   *float64 `json:"mg_per_kg_max"`
+	MaxMgPerDose *float64 `json:"max_mg_per_dose"`
+	Strict       bool     `json:"strict"`
+}
+
+type SafetyIssue struct {
+	Rule     string `json:"rule"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+func (srv *Server) getSafetyRule(medicine string) (SafetyRule, bool, error) {
+	rule := SafetyRule{Medicine: strings.ToLower(strings.TrimSpace(medicine))}
+	query := "SELECT min_gap_hours, max_daily_doses, mg_per_kg_min, mg_per_kg_max, max_mg_per_dose, strict FROM medicine_safety WHERE medicine = $1"
+	err := srv.db.QueryRow(context.Background(), query, rule.Medicine).Scan(&rule.MinGapHours, &rule.MaxDailyDoses, &rule.MgPerKgMin, &rule.MgPerKgMax, &rule.MaxMgPerDose, &rule.Strict)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return rule, false, nil
+	}
+	if err != nil {
+		return rule, false, err
+	}
+
+	return rule, true, nil
+}
+
+func (rule SafetyRule) issue(name string, message string) SafetyIssue {
+	severity := "warning"
+	if rule.Strict {
+		severity = "error"
+	}
+
+	return SafetyIssue{Rule: name, Severity: severity, Message: message}
+}
+
+// validates the doses a schedule produces per day against the medicine rule
+func (srv *Server) checkScheduleSafety(s schedule.Schedule) ([]SafetyIssue, error) {
+	return srv.checkScheduleSafetyWith(s, nil)
+}
+
+// the doses are closest together in the shortest of the user's waking window and the
+// weekend and holiday windows, nil variants are the ones stored for the schedule
+func (srv *Server) checkScheduleSafetyWith(s schedule.Schedule, variants *schedule.Variants) ([]SafetyIssue, error) {
+	rule, ok, err := srv.getSafetyRule(s.Medicine)
+	if err != nil || !ok {
+		return nil, err
+	}
+
+	var issues []SafetyIssue
+	if rule.MaxDailyDoses != nil && s.Duration > *rule.MaxDailyDoses {
+		issues = append(issues, rule.issue("max_daily_doses", fmt.Sprintf("%d doses per day exceeds the maximum of %d for %s", s.Duration, *rule.MaxDailyDoses, s.Medicine)))
+	}
+	if rule.MinGapHours != nil && s.Duration > 1 {
+		plan, err := srv.userDayPlan(context.Background(), s.UserID)
+		if err != nil {
+			return nil, err
+		}
+		if variants == nil {
+			stored := plan.variants[s.ID]
+			variants = &stored
+		}
+		window := variants.Shortest(plan.window)
+		gap := window.Length().Hours() / float64(s.Duration-1)
+		if gap < *rule.MinGapHours {
+			issues = append(issues, rule.issue("min_gap_hours", fmt.Sprintf("doses would be %.1f hours apart between %s and %s, %s requires at least %.1f", gap, window.Wake, window.Sleep, s.Medicine, *rule.MinGapHours)))
+		}
+	}
+	weightIssues, err := srv.checkWeightDose(context.Background(), rule, s)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(issues, weightIssues...), nil
+}
+
+// validates an intake against the user's other intakes of the same medicine, intakeID is
+// the intake being moved so it is not checked against itself, 0 for a new one
+func (srv *Server) checkIntakeSafety(ctx context.Context, db rowQuerier, userID string, medicine string, takenAt time.Time, intakeID int) ([]SafetyIssue, error) {
+	rule, ok, err := srv.getSafetyRule(medicine)
+	if err != nil || !ok {
+		return nil, err
+	}
+
+	var issues []SafetyIssue
+	if rule.MaxDailyDoses != nil {
+		var count int
+		query := `SELECT count(*) FROM intake_log i JOIN schedule s ON s.id = i.schedule_id
+			WHERE i.user_id = $1 AND s.medicine_hash = $2 AND i.taken_at > $3::timestamptz - interval '24 hours' AND i.taken_at <= $3 AND i.id <> $4`
+		err := db.QueryRow(ctx, query, userID, storage.MedicineHash(rule.Medicine), takenAt, intakeID).Scan(&count)
+		if err != nil {
+			return nil, err
+		}
+		if count+1 > *rule.MaxDailyDoses {
+			issues = append(issues, rule.issue("max_daily_doses", fmt.Sprintf("this would be dose %d of %s within 24 hours, the maximum is %d", count+1, medicine, *rule.MaxDailyDoses)))
+		}
+	}
+	if rule.MinGapHours != nil {
+		var closest *time.Time
+		query := `SELECT i.taken_at FROM intake_log i JOIN schedule s ON s.id = i.schedule_id
+			WHERE i.user_id = $1 AND s.medicine_hash = $2 AND i.id <> $4
+			ORDER BY abs(extract(epoch FROM i.taken_at - $3::timestamptz)) LIMIT 1`
+		err := db.QueryRow(ctx, query, userID, storage.MedicineHash(rule.Medicine), takenAt, intakeID).Scan(&closest)
+		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			return nil, err
+		}
+		if closest != nil {
+			gap := takenAt.Sub(*closest).Abs().Hours()
+			if gap < *rule.MinGapHours {
+				issues = append(issues, rule.issue("min_gap_hours", fmt.Sprintf("%.1f hours since the closest dose of %s, at least %.1f required", gap, medicine, *rule.MinGapHours)))
+			}
+		}
+	}
+
+	return issues, nil
+}
+
+// an issue of error severity, answered with 422 and all the issues
+type safetyError struct {
+	issues []SafetyIssue
+}
+
+func (e *safetyError) Error() string {
+	return "medicine safety rules violated"
+}
+
+// fails when the check failed or any issue is an error, warnings are left to the caller
+func checkSafety(issues []SafetyIssue, err error) error {
+	if err != nil {
+		return fmt.Errorf("failed check medicine safety rules: %w", err)
+	}
+
+	for _, issue := range issues {
+		if issue.Severity == "error" {
+			return &safetyError{issues: issues}
+		}
+	}
+
+	return nil
+}
+
+// keeps the plain text answer unless there are warnings to report
+func writeSaved(w http.ResponseWriter, kind string, id int, warnings []SafetyIssue) {
+	if len(warnings) == 0 {
+		fmt.Fprintf(w, "%s saved with ID: %d\n", kind, id)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, convertToJson(map[string]interface{}{
+		"id":       id,
+		"warnings": warnings,
+	}))
+}
+
+func (srv *Server) getSafetyRuleHandler(w http.ResponseWriter, r *http.Request) error {
+	rule, ok, err := srv.getSafetyRule(r.PathValue("medicine"))
+	if err != nil {
+		return fmt.Errorf("failed get safety rule from database: %w", err)
+	}
+	if !ok {
+		return schedule.Errorf(schedule.ErrNotFound, "no safety rule for this medicine")
+	}
+
+	fmt.Fprint(w, convertToJson(rule))
+	return nil
+}
+
+func (srv *Server) putSafetyRuleHandler(w http.ResponseWriter, r *http.Request) error {
+	var rule SafetyRule
+	err := json.NewDecoder(r.Body).Decode(&rule)
+	if err != nil {
+		return schedule.Errorf(schedule.ErrValidation, "invalid safety rule format")
+	}
+
+	if rule.MgPerKgMin == nil && (rule.MgPerKgMax != nil || rule.MaxMgPerDose != nil) {
+		return schedule.FieldErrorf("mg_per_kg_min", "required", "mg_per_kg_max and max_mg_per_dose need mg_per_kg_min")
+	}
+	if rule.MgPerKgMin != nil && rule.MgPerKgMax != nil && *rule.MgPerKgMax < *rule.MgPerKgMin {
+		return schedule.FieldErrorf("mg_per_kg_max", "min", "mg_per_kg_max can not be below mg_per_kg_min")
+	}
+
+	rule.Medicine = strings.ToLower(strings.TrimSpace(r.PathValue("medicine")))
+	query := `INSERT INTO medicine_safety (medicine, min_gap_hours, max_daily_doses, mg_per_kg_min, mg_per_kg_max, max_mg_per_dose, strict) VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (medicine) DO UPDATE SET min_gap_hours = $2, max_daily_doses = $3, mg_per_kg_min = $4, mg_per_kg_max = $5, max_mg_per_dose = $6, strict = $7`
+	_, err = srv.db.Exec(context.Background(), query, rule.Medicine, rule.MinGapHours, rule.MaxDailyDoses, rule.MgPerKgMin, rule.MgPerKgMax, rule.MaxMgPerDose, rule.Strict)
+	if err != nil {
+		return fmt.Errorf("failed save safety rule: %w", err)
+	}
+
+	fmt.Fprint(w, convertToJson(rule))
+	return nil
+}
@@ -0,0 +1,781 @@
+package http
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"kode_test/internal/schedule"
+	"kode_test/internal/web"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func (srv *Server) routes() *http.ServeMux {
+	// a mux of its own, net/http/pprof registers itself on the default one
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/", adminOnly(srv.debugMux().ServeHTTP))
+	mux.HandleFunc("GET /v1/openapi.json", srv.getOpenAPIHandler)
+
+	mux.HandleFunc("/schedule", srv.scoped("schedules", srv.accessLogged("schedule", handleErrors(srv.scheduleHandler))))
+	mux.HandleFunc("/schedules", srv.scoped("schedules", srv.accessLogged("schedule", withETag(handleErrors(srv.getAllUserSchedulesHandler)))))
+	mux.HandleFunc("/next_takings", srv.scoped("schedules", srv.accessLogged("schedule", withETag(handleErrors(srv.getNextTakingsHandler)))))
+	mux.HandleFunc("GET /v1/takings/next", srv.scoped("schedules", handleErrors(srv.getNextDoseHandler)))
+	mux.HandleFunc("GET /v1/features", handleErrors(srv.getFeaturesHandler))
+	mux.HandleFunc("/delete", srv.requireScope("write:schedules", handleErrors(srv.deleteScheduleHandler)))
+
+	mux.HandleFunc("POST /v1/auth/signup", handleErrors(srv.signupHandler))
+	mux.HandleFunc("POST /v1/auth/login", handleErrors(srv.loginHandler))
+	mux.HandleFunc("POST /v1/auth/refresh", handleErrors(srv.refreshHandler))
+	mux.HandleFunc("POST /v1/auth/verify-email", handleErrors(srv.verifyEmailHandler))
+	mux.HandleFunc("POST /v1/auth/verify-email/resend", authenticated(handleErrors(srv.resendVerificationHandler)))
+	mux.HandleFunc("POST /v1/auth/password-reset", handleErrors(srv.requestPasswordResetHandler))
+	mux.HandleFunc("POST /v1/auth/password-reset/confirm", handleErrors(srv.confirmPasswordResetHandler))
+	mux.HandleFunc("GET /v1/auth/me", authenticated(srv.meHandler))
+	mux.HandleFunc("POST /v1/auth/logout", authenticated(handleErrors(srv.logoutHandler)))
+	mux.HandleFunc("GET /v1/auth/sessions", authenticated(handleErrors(srv.getSessionsHandler)))
+	mux.HandleFunc("DELETE /v1/auth/sessions/{id}", authenticated(handleErrors(srv.revokeSessionHandler)))
+	mux.HandleFunc("POST /v1/auth/2fa/enroll", authenticated(handleErrors(srv.enrollTOTPHandler)))
+	mux.HandleFunc("POST /v1/auth/2fa/verify", authenticated(handleErrors(srv.verifyTOTPHandler)))
+	mux.HandleFunc("POST /v1/auth/2fa/disable", authenticated(handleErrors(srv.disableTOTPHandler)))
+
+	mux.HandleFunc("POST /v1/oauth/authorize", authenticated(handleErrors(srv.authorizeOAuthHandler)))
+	mux.HandleFunc("POST /v1/oauth/token", handleErrors(srv.oauthTokenHandler))
+	mux.HandleFunc("POST /v1/ivr/{token}", srv.ivrInstructionsHandler)
+	mux.HandleFunc("POST /v1/ivr/{token}/confirm", srv.ivrConfirmHandler)
+	mux.HandleFunc("GET /v1/voice/next", srv.linked("read:schedules", handleErrors(srv.voiceNextHandler)))
+	mux.HandleFunc("POST /v1/voice/taken", srv.linked("write:intakes", handleErrors(srv.voiceTakenHandler)))
+
+	mux.HandleFunc("GET /v1/tokens", authenticated(handleErrors(srv.getAPITokensHandler)))
+	mux.HandleFunc("POST /v1/tokens", authenticated(handleErrors(srv.createAPITokenHandler)))
+	mux.HandleFunc("DELETE /v1/tokens/{id}", authenticated(handleErrors(srv.deleteAPITokenHandler)))
+
+	mux.HandleFunc("POST /v1/schedules/bulk", srv.scoped("schedules", handleErrors(srv.bulkSchedulesHandler)))
+	mux.HandleFunc("POST /v1/schedules/parse", srv.scoped("schedules", handleErrors(parseScheduleHandler)))
+	mux.HandleFunc("GET /v1/schedules/search", srv.scoped("schedules", handleErrors(srv.searchSchedulesHandler)))
+	mux.HandleFunc("GET /v1/schedules/{id}/attachments", srv.scoped("schedules", handleErrors(srv.getAttachmentsHandler)))
+	mux.HandleFunc("POST /v1/schedules/{id}/attachments", srv.scoped("schedules", handleErrors(srv.createAttachmentHandler)))
+	mux.HandleFunc("POST /v1/attachments/{id}/complete", srv.scoped("schedules", handleErrors(srv.completeAttachmentHandler)))
+	mux.HandleFunc("DELETE /v1/attachments/{id}", srv.scoped("schedules", handleErrors(srv.deleteAttachmentHandler)))
+	mux.HandleFunc("GET /v1/schedules/{id}/refills", srv.scoped("schedules", handleErrors(srv.getRefillsHandler)))
+	mux.HandleFunc("POST /v1/schedules/{id}/refills", srv.scoped("schedules", handleErrors(srv.createRefillHandler)))
+	mux.HandleFunc("GET /v1/spend", srv.scoped("schedules", handleErrors(srv.getSpendReportHandler)))
+	mux.HandleFunc("POST /v1/reports/reimbursement", srv.scoped("schedules", handleErrors(srv.createReimbursementReportHandler)))
+	mux.HandleFunc("GET /v1/reports/{id}", srv.scoped("schedules", handleErrors(srv.getReportHandler)))
+	mux.HandleFunc("GET /v1/reports/{id}/download", srv.scoped("schedules", handleErrors(srv.downloadReportHandler)))
+	mux.HandleFunc("GET /v1/users/{id}", srv.scoped("schedules", handleErrors(srv.getUserHandler)))
+	mux.HandleFunc("GET /v1/users/{id}/usage", srv.scoped("schedules", handleErrors(srv.getUsageHandler)))
+	mux.HandleFunc("PUT /v1/users/{id}/weight", srv.scoped("schedules", handleErrors(srv.putWeightHandler)))
+	mux.HandleFunc("GET /v1/users/{id}/archived-courses", srv.scoped("schedules", handleErrors(srv.getArchivedCoursesHandler)))
+	mux.HandleFunc("POST /v1/users/{id}/archived-courses/{schedule_id}/restore", srv.scoped("schedules", handleErrors(srv.restoreArchivedCourseHandler)))
+	mux.HandleFunc("GET /v1/users/{id}/dosing", srv.scoped("schedules", handleErrors(srv.getDosingHandler)))
+	mux.HandleFunc("GET /v1/users/{id}/pills/match", srv.scoped("schedules", srv.accessLogged("schedule", handleErrors(srv.matchPillHandler))))
+	mux.HandleFunc("GET /v1/users/{id}/plan.pdf", srv.scoped("schedules", srv.accessLogged("schedule", handleErrors(srv.getPlanPDFHandler))))
+	mux.HandleFunc("GET /v1/users/{id}/share-links", srv.scoped("schedules", handleErrors(srv.getShareLinksHandler)))
+	mux.HandleFunc("POST /v1/users/{id}/share-links", srv.scoped("schedules", handleErrors(srv.createShareLinkHandler)))
+	mux.HandleFunc("DELETE /v1/users/{id}/share-links/{link_id}", srv.scoped("schedules", handleErrors(srv.revokeShareLinkHandler)))
+	mux.HandleFunc("GET /v1/shared/{token}", handleErrors(srv.getSharedPlanHandler))
+	mux.HandleFunc("GET /v1/side-effects", srv.scoped("schedules", handleErrors(srv.getSideEffectsHandler)))
+	mux.HandleFunc("POST /v1/side-effects", srv.scoped("schedules", handleErrors(srv.createSideEffectHandler)))
+	mux.HandleFunc("GET /v1/users/{id}/notification-settings", srv.scoped("schedules", handleErrors(srv.getNotificationSettingsHandler)))
+	mux.HandleFunc("PUT /v1/users/{id}/notification-settings", srv.scoped("schedules", handleErrors(srv.putNotificationSettingsHandler)))
+	mux.HandleFunc("GET /v1/users/{id}/push-target", srv.scoped("schedules", handleErrors(srv.getPushTargetHandler)))
+	mux.HandleFunc("PUT /v1/users/{id}/push-target", srv.scoped("schedules", handleErrors(srv.putPushTargetHandler)))
+	mux.HandleFunc("DELETE /v1/users/{id}/push-target", srv.scoped("schedules", handleErrors(srv.deletePushTargetHandler)))
+	mux.HandleFunc("PUT /v1/users/{id}/chat-address", srv.scoped("schedules", handleErrors(srv.putChatAddressHandler)))
+	mux.HandleFunc("PUT /v1/users/{id}/phone", srv.scoped("schedules", handleErrors(srv.putPhoneHandler)))
+	mux.HandleFunc("GET /v1/users/{id}/day-settings", srv.scoped("schedules", handleErrors(srv.getDaySettingsHandler)))
+	mux.HandleFunc("PUT /v1/users/{id}/day-settings", srv.scoped("schedules", handleErrors(srv.putDaySettingsHandler)))
+	mux.HandleFunc("GET /v1/users/{id}/on-time-window", srv.scoped("schedules", handleErrors(srv.getOnTimeWindowHandler)))
+	mux.HandleFunc("PUT /v1/users/{id}/on-time-window", srv.scoped("schedules", handleErrors(srv.putOnTimeWindowHandler)))
+	mux.HandleFunc("GET /v1/users/{id}/holidays", srv.scoped("schedules", handleErrors(srv.getHolidaysHandler)))
+	mux.HandleFunc("PUT /v1/users/{id}/holidays/{date}", srv.scoped("schedules", handleErrors(srv.putHolidayHandler)))
+	mux.HandleFunc("DELETE /v1/users/{id}/holidays/{date}", srv.scoped("schedules", handleErrors(srv.deleteHolidayHandler)))
+	mux.HandleFunc("GET /v1/users/{id}/travel", srv.scoped("schedules", handleErrors(srv.getTravelHandler)))
+	mux.HandleFunc("PUT /v1/users/{id}/travel", srv.scoped("schedules", handleErrors(srv.putTravelHandler)))
+	mux.HandleFunc("DELETE /v1/users/{id}/travel", srv.scoped("schedules", handleErrors(srv.deleteTravelHandler)))
+	mux.HandleFunc("GET /v1/users/{id}/notifications", srv.scoped("schedules", handleErrors(srv.getNotificationsHandler)))
+	mux.HandleFunc("PUT /v1/schedules/{id}/notification-settings", srv.scoped("schedules", handleErrors(srv.putScheduleNotificationSettingsHandler)))
+	mux.HandleFunc("GET /v1/schedules/{id}/variants", srv.scoped("schedules", handleErrors(srv.getScheduleVariantsHandler)))
+	mux.HandleFunc("PUT /v1/schedules/{id}/variants", srv.scoped("schedules", handleErrors(srv.putScheduleVariantsHandler)))
+	mux.HandleFunc("DELETE /v1/schedules/{id}/notification-settings", srv.scoped("schedules", handleErrors(srv.deleteScheduleNotificationSettingsHandler)))
+	mux.HandleFunc("GET /v1/contacts", srv.scoped("schedules", handleErrors(srv.getContactsHandler)))
+	mux.HandleFunc("POST /v1/prescriptions/scan", srv.scoped("schedules", handleErrors(srv.scanPrescriptionHandler)))
+	mux.HandleFunc("PUT /v1/schedules/{id}", srv.scoped("schedules", handleErrors(srv.updateScheduleHandler)))
+	mux.HandleFunc("POST /v1/schedules/{id}/clone", srv.scoped("schedules", handleErrors(srv.cloneScheduleHandler)))
+	mux.HandleFunc("PUT /v1/schedules/by-external-id/{id}", srv.scoped("schedules", handleErrors(srv.putScheduleByExternalIDHandler)))
+
+	mux.HandleFunc("GET /v1/clinicians", authenticated(handleErrors(srv.getCliniciansHandler)))
+	mux.HandleFunc("POST /v1/clinicians", authenticated(handleErrors(srv.linkClinicianHandler)))
+	mux.HandleFunc("DELETE /v1/clinicians/{id}", authenticated(handleErrors(srv.unlinkClinicianHandler)))
+	mux.HandleFunc("GET /v1/caregivers", authenticated(handleErrors(srv.getCaregiversHandler)))
+	mux.HandleFunc("DELETE /v1/caregivers/{id}", authenticated(handleErrors(srv.unlinkCaregiverHandler)))
+	mux.HandleFunc("GET /v1/invites", authenticated(handleErrors(srv.getInvitesHandler)))
+	mux.HandleFunc("POST /v1/invites", authenticated(handleErrors(srv.createInviteHandler)))
+	mux.HandleFunc("DELETE /v1/invites/{id}", authenticated(handleErrors(srv.deleteInviteHandler)))
+	mux.HandleFunc("POST /v1/invites/accept", handleErrors(srv.acceptInviteHandler))
+	mux.HandleFunc("GET /v1/clinician/patients", authenticated(handleErrors(srv.getPatientsHandler)))
+	mux.HandleFunc("GET /v1/clinician/patients/{id}/schedules", authenticated(srv.patientAccessLogged("schedule", handleErrors(srv.getPatientSchedulesHandler))))
+	mux.HandleFunc("GET /v1/clinician/patients/{id}/adherence", authenticated(srv.patientAccessLogged("intake", handleErrors(srv.getPatientAdherenceHandler))))
+	mux.HandleFunc("GET /v1/clinician/patients/{id}/adherence/timing", authenticated(srv.patientAccessLogged("intake", handleErrors(srv.getPatientDoseTimingHandler))))
+	mux.HandleFunc("PUT /v1/clinician/patients/{id}/weekly-summary", authenticated(handleErrors(srv.putWeeklySummaryHandler)))
+	mux.HandleFunc("GET /v1/clinician/patients/{id}/side-effects", authenticated(srv.patientAccessLogged("side_effect", handleErrors(srv.getPatientSideEffectsHandler))))
+
+	mux.HandleFunc("POST /v1/orgs/{id}/rosters", authenticated(handleErrors(srv.createRosterImportHandler)))
+	mux.HandleFunc("GET /v1/orgs/{id}/rosters/{roster_id}", authenticated(handleErrors(srv.getRosterImportHandler)))
+	mux.HandleFunc("PUT /v1/orgs/{id}/on-time-window", authenticated(handleErrors(srv.putOrganizationOnTimeWindowHandler)))
+
+	mux.HandleFunc("GET /v1/admin/maintenance", adminOnly(handleErrors(srv.getMaintenanceHandler)))
+	mux.HandleFunc("PUT /v1/admin/maintenance", adminOnly(handleErrors(srv.putMaintenanceHandler)))
+	mux.HandleFunc("GET /v1/admin/flags", adminOnly(handleErrors(srv.getFeatureFlagsHandler)))
+	mux.HandleFunc("PUT /v1/admin/flags/{name}", adminOnly(handleErrors(srv.putFeatureFlagHandler)))
+	mux.HandleFunc("DELETE /v1/admin/flags/{name}", adminOnly(handleErrors(srv.deleteFeatureFlagHandler)))
+	mux.HandleFunc("POST /v1/admin/encryption/rotate", adminOnly(handleErrors(srv.rotateDataKeyHandler)))
+	mux.HandleFunc("GET /v1/admin/access-log", adminOnly(handleErrors(srv.exportAccessLogHandler)))
+	mux.HandleFunc("GET /v1/admin/loadtest/scenario", adminOnly(handleErrors(srv.getLoadTestScenarioHandler)))
+	mux.HandleFunc("GET /v1/admin/retention/rules", adminOnly(handleErrors(srv.getRetentionRulesHandler)))
+	mux.HandleFunc("PUT /v1/admin/retention/rules/{target}", adminOnly(handleErrors(srv.putRetentionRuleHandler)))
+	mux.HandleFunc("POST /v1/admin/retention/run", adminOnly(handleErrors(srv.runRetentionHandler)))
+
+	mux.HandleFunc("GET /v1/quota", srv.scoped("schedules", handleErrors(srv.getQuotaHandler)))
+	mux.HandleFunc("PUT /v1/admin/users/{id}/quota", adminOnly(handleErrors(srv.putUserQuotaHandler)))
+	mux.HandleFunc("PUT /v1/admin/users/{id}/role", adminOnly(handleErrors(srv.putUserRoleHandler)))
+	mux.HandleFunc("GET /v1/admin/users/{id}/notifications", adminOnly(handleErrors(srv.getNotificationsHandler)))
+	mux.HandleFunc("GET /v1/admin/usage", adminOnly(handleErrors(srv.getAdminUsageHandler)))
+	mux.HandleFunc("GET /v1/admin/stats", adminOnly(handleErrors(srv.getAdminStatsHandler)))
+	mux.HandleFunc("GET /v1/admin/notifications/dead-letter", adminOnly(handleErrors(srv.getDeadLetterHandler)))
+	mux.HandleFunc("POST /v1/admin/notifications/dead-letter/replay", adminOnly(handleErrors(srv.replayDeadLetterHandler)))
+	mux.HandleFunc("POST /v1/admin/notifications/{id}/replay", adminOnly(handleErrors(srv.replayNotificationHandler)))
+	mux.HandleFunc("POST /v1/admin/oauth/clients", adminOnly(handleErrors(srv.createOAuthClientHandler)))
+	mux.HandleFunc("POST /v1/admin/orgs", adminOnly(handleErrors(srv.createOrganizationHandler)))
+	mux.HandleFunc("PUT /v1/admin/orgs/{id}/staff/{user_id}", adminOnly(handleErrors(srv.putOrganizationStaffHandler)))
+
+	mux.HandleFunc("GET /v1/intakes", srv.scoped("intakes", srv.accessLogged("intake", handleErrors(srv.getIntakesHandler))))
+	mux.HandleFunc("POST /v1/intakes", srv.scoped("intakes", handleErrors(srv.createIntakeHandler)))
+	mux.HandleFunc("POST /v1/intakes/confirm-window", srv.scoped("intakes", handleErrors(srv.confirmWindowHandler)))
+	mux.HandleFunc("PATCH /v1/intakes/{id}", srv.scoped("intakes", handleErrors(srv.updateIntakeHandler)))
+	mux.HandleFunc("DELETE /v1/intakes/{id}", srv.scoped("intakes", handleErrors(srv.deleteIntakeHandler)))
+	mux.HandleFunc("GET /v1/intakes/{id}/audit", srv.scoped("intakes", srv.accessLogged("intake", handleErrors(srv.getIntakeAuditHandler))))
+	mux.HandleFunc("GET /v1/users/{id}/adherence/by-medicine", srv.scoped("intakes", srv.accessLogged("intake", handleErrors(srv.getAdherenceByMedicineHandler))))
+	mux.HandleFunc("GET /v1/users/{id}/adherence/timing", srv.scoped("intakes", srv.accessLogged("intake", handleErrors(srv.getDoseTimingHandler))))
+	mux.HandleFunc("PUT /v1/users/{id}/doses/{dose_id}/skip", srv.scoped("intakes", handleErrors(srv.skipDoseHandler)))
+	mux.HandleFunc("DELETE /v1/users/{id}/doses/{dose_id}/skip", srv.scoped("intakes", handleErrors(srv.unskipDoseHandler)))
+
+	mux.HandleFunc("GET /v1/medicines/{medicine}/safety", handleErrors(srv.getSafetyRuleHandler))
+	mux.HandleFunc("PUT /v1/admin/medicines/{medicine}/safety", adminOnly(handleErrors(srv.putSafetyRuleHandler)))
+
+	mux.HandleFunc("GET /v1/recalls", srv.scoped("recalls", srv.accessLogged("recall", handleErrors(srv.getUserRecallsHandler))))
+
+	mux.HandleFunc("GET /v1/regimens", srv.scoped("regimens", srv.accessLogged("regimen", handleErrors(srv.getRegimensHandler))))
+	mux.HandleFunc("POST /v1/regimens", srv.scoped("regimens", handleErrors(srv.createRegimenHandler)))
+	mux.HandleFunc("POST /v1/regimens/{id}/pause", srv.scoped("regimens", handleErrors(srv.pauseRegimenHandler)))
+	mux.HandleFunc("POST /v1/regimens/{id}/resume", srv.scoped("regimens", handleErrors(srv.resumeRegimenHandler)))
+	mux.HandleFunc("DELETE /v1/regimens/{id}", srv.scoped("regimens", handleErrors(srv.deleteRegimenHandler)))
+	mux.HandleFunc("GET /v1/regimens/{id}/next_takings", srv.scoped("regimens", srv.accessLogged("regimen", withETag(handleErrors(srv.getRegimenNextTakingsHandler)))))
+
+	mux.HandleFunc("GET /v1/sync", srv.scoped("sync", srv.accessLogged("sync", handleErrors(srv.getSyncHandler))))
+	mux.HandleFunc("POST /v1/sync", srv.scoped("sync", handleErrors(srv.uploadSyncHandler)))
+
+	mux.HandleFunc("GET /v1/templates", srv.scoped("templates", srv.accessLogged("template", handleErrors(srv.getTemplatesHandler))))
+	mux.HandleFunc("POST /v1/templates", srv.scoped("templates", handleErrors(srv.createTemplateHandler)))
+	mux.HandleFunc("POST /v1/templates/{id}/schedule", srv.scoped("templates", handleErrors(srv.createScheduleFromTemplateHandler)))
+	mux.HandleFunc("POST /v1/admin/templates", adminOnly(handleErrors(srv.createSharedTemplateHandler)))
+	mux.HandleFunc("PUT /v1/admin/templates/{id}", adminOnly(handleErrors(srv.updateSharedTemplateHandler)))
+	mux.HandleFunc("DELETE /v1/admin/templates/{id}", adminOnly(handleErrors(srv.deleteSharedTemplateHandler)))
+
+	mux.HandleFunc("GET /html/login", srv.loginPageHandler)
+	mux.HandleFunc("POST /html/login", srv.loginFormHandler)
+	mux.HandleFunc("POST /html/logout", srv.page(srv.logoutPageHandler))
+	mux.HandleFunc("GET /html/today", srv.page(srv.todayPageHandler))
+	mux.HandleFunc("POST /html/intakes", srv.page(srv.intakePageHandler))
+	mux.HandleFunc("GET /html/schedules", srv.page(srv.schedulesPageHandler))
+
+	// the dashboard takes every path no API route matched
+	mux.Handle("/", web.Handler())
+
+	return mux
+}
+
+func (srv *Server) scheduleHandler(w http.ResponseWriter, r *http.Request) error {
+	if r.Method == http.MethodPost {
+		return srv.createScheduleHandler(w, r)
+	} else if r.Method == http.MethodGet {
+		return srv.getOneUserScheduleHandler(w, r)
+	}
+
+	return errMethodNotAllowed
+}
+
+// checks and normalizes the optional details of a schedule, reporting every invalid one
+func normalizeScheduleDetails(s *schedule.Schedule) error {
+	errs := []error{validContacts(*s)}
+	tags, err := schedule.NormalizeTags(s.Tags)
+	if err == nil {
+		s.Tags = tags
+	}
+	errs = append(errs, err, schedule.NormalizeAppearance(s))
+	instructions, err := schedule.SanitizeInstructions(s.Instructions)
+	if err == nil {
+		s.Instructions = instructions
+	}
+	errs = append(errs, err)
+	if s.Dose != nil && s.Dose.Value <= 0 {
+		errs = append(errs, schedule.FieldErrorf("dose.value", "min", "invalid dose, expected a positive amount in mcg, mg, g, ml, l, tsp, tbsp, units, tablet, capsule, puff or drop"))
+	} else if s.Dose != nil && !schedule.ValidUnit(s.Dose.Unit) {
+		errs = append(errs, schedule.FieldErrorf("dose.unit", "enum", "invalid dose, expected a positive amount in mcg, mg, g, ml, l, tsp, tbsp, units, tablet, capsule, puff or drop"))
+	}
+	if s.Strength != nil && !schedule.ValidStrength(*s.Strength) {
+		errs = append(errs, schedule.FieldErrorf("strength", "format", "invalid strength, expected a positive amount per form like 500 mg/tablet or 50 mg/ml"))
+	}
+	if s.Pill != nil {
+		errs = append(errs, schedule.NormalizePill(s.Pill))
+	}
+
+	return schedule.JoinFields(errs...)
+}
+
+func (srv *Server) createScheduleHandler(w http.ResponseWriter, r *http.Request) error {
+	var s schedule.Schedule
+	err := json.NewDecoder(r.Body).Decode(&s)
+	if err != nil {
+		return schedule.Errorf(schedule.ErrValidation, "invalid schedule format")
+	}
+	if userID := currentUserID(r); userID != "" {
+		s.UserID = userID
+	}
+	// new schedules start active whatever status they name
+	s.Status = "active"
+	err = schedule.JoinFields(schedule.ValidateSchedule(s), normalizeScheduleDetails(&s))
+	if err != nil {
+		return err
+	}
+	// without a dose the medicine's dose for the user's weight is taken, when there is one
+	if s.Dose == nil {
+		dosing, ok, err := srv.weightDosing(context.Background(), s.UserID, s.Medicine)
+		if err != nil {
+			return fmt.Errorf("failed get weight dosing from database: %w", err)
+		}
+		if ok {
+			s.Dose = &dosing.SuggestedMg
+		}
+	}
+
+	issues, err := srv.checkScheduleSafety(s)
+	err = checkSafety(issues, err)
+	if err != nil {
+		return err
+	}
+
+	err = srv.checkOverlap(r, s, time.Now())
+	if err != nil {
+		return err
+	}
+
+	medicine, hash, err := srv.cipher.SealMedicine(s.Medicine)
+	if err != nil {
+		return fmt.Errorf("failed encrypt schedule: %w", err)
+	}
+	instructions, err := srv.sealInstructions(s.Instructions)
+	if err != nil {
+		return fmt.Errorf("failed encrypt schedule: %w", err)
+	}
+	prescriber, pharmacy, err := srv.sealContacts(s)
+	if err != nil {
+		return fmt.Errorf("failed encrypt schedule: %w", err)
+	}
+
+	ctx := context.Background()
+	err = srv.db.InTx(ctx, func(tx pgx.Tx) error {
+		err := lockScheduleQuota(ctx, tx, s.UserID, 1)
+		if err != nil {
+			return err
+		}
+
+		query := `INSERT INTO schedule (medicine, medicine_hash, frequency, duration, user_id, prescriber, pharmacy, tags, color, icon, instructions, pill, dose, strength)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14) RETURNING id`
+		return tx.QueryRow(ctx, query, medicine, hash, s.Frequency, s.Duration, s.UserID, prescriber, pharmacy, s.Tags, s.Color, s.Icon, instructions, s.Pill, s.Dose, s.Strength).Scan(&s.ID)
+	})
+	var domainErr *schedule.Error
+	if errors.As(err, &domainErr) {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("error adding data to database: %w", err)
+	}
+	srv.rememberContacts(context.Background(), s)
+
+	writeSaved(w, "schedule", s.ID, issues)
+	return nil
+}
+
+// copies a schedule, optionally to another user and with a new start date
+func (srv *Server) cloneScheduleHandler(w http.ResponseWriter, r *http.Request) error {
+	var overrides struct {
+		UserID    string `json:"user_id"`
+		StartDate string `json:"start_date"`
+	}
+	if r.ContentLength != 0 {
+		err := json.NewDecoder(r.Body).Decode(&overrides)
+		if err != nil {
+			return schedule.Errorf(schedule.ErrValidation, "invalid clone format")
+		}
+	}
+
+	// authenticated callers can only clone their own schedules and only to themselves
+	if userID := currentUserID(r); userID != "" {
+		overrides.UserID = userID
+	}
+
+	scheduleID, err := srv.ResolveScheduleID(context.Background(), r.PathValue("id"))
+	if err != nil {
+		return err
+	}
+	var s schedule.Schedule
+	// the instructions, prescriber and pharmacy are copied as stored, encrypted or not
+	var instructions string
+	var prescriber, pharmacy []byte
+	query := "SELECT medicine, frequency, duration, user_id, created_at, prescriber, pharmacy, tags, color, icon, instructions, pill, dose, strength FROM schedule WHERE id = $1 AND ($2 = '' OR user_id = $2)"
+	err = srv.db.QueryRow(context.Background(), query, scheduleID, currentUserID(r)).Scan(&s.Medicine, &s.Frequency, &s.Duration, &s.UserID, &s.CreatedAt, &prescriber, &pharmacy, &s.Tags, &s.Color, &s.Icon, &instructions, &s.Pill, &s.Dose, &s.Strength)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return schedule.Errorf(schedule.ErrNotFound, "schedule not found")
+	}
+	if err == nil {
+		s.Medicine, err = srv.cipher.Decrypt(s.Medicine)
+	}
+	if err != nil {
+		return fmt.Errorf("failed get schedule from database: %w", err)
+	}
+
+	if overrides.UserID != "" {
+		s.UserID = overrides.UserID
+	}
+	s.CreatedAt = time.Now()
+	if overrides.StartDate != "" {
+		loc, err := srv.userLocation(r, s.UserID)
+		if err != nil {
+			return err
+		}
+		s.CreatedAt, err = time.ParseInLocation("2006-01-02", overrides.StartDate, loc)
+		if err != nil {
+			return schedule.Errorf(schedule.ErrValidation, "invalid start_date, expected YYYY-MM-DD")
+		}
+	}
+
+	issues, err := srv.checkScheduleSafety(s)
+	err = checkSafety(issues, err)
+	if err != nil {
+		return err
+	}
+
+	err = srv.checkOverlap(r, s, s.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	medicine, hash, err := srv.cipher.SealMedicine(s.Medicine)
+	if err != nil {
+		return fmt.Errorf("failed encrypt schedule: %w", err)
+	}
+
+	ctx := context.Background()
+	err = srv.db.InTx(ctx, func(tx pgx.Tx) error {
+		err := lockScheduleQuota(ctx, tx, s.UserID, 1)
+		if err != nil {
+			return err
+		}
+
+		query := `INSERT INTO schedule (medicine, medicine_hash, frequency, duration, user_id, created_at, prescriber, pharmacy, tags, color, icon, instructions, pill, dose, strength)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15) RETURNING id`
+		return tx.QueryRow(ctx, query, medicine, hash, s.Frequency, s.Duration, s.UserID, s.CreatedAt, prescriber, pharmacy, s.Tags, s.Color, s.Icon, instructions, s.Pill, s.Dose, s.Strength).Scan(&scheduleID)
+	})
+	var domainErr *schedule.Error
+	if errors.As(err, &domainErr) {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("error adding data to database: %w", err)
+	}
+
+	writeSaved(w, "schedule", scheduleID, issues)
+	return nil
+}
+
+// replaces a schedule, the caller must send the ETag it read in If-Match
+// so concurrent edits by different caregivers are not silently lost
+func (srv *Server) updateScheduleHandler(w http.ResponseWriter, r *http.Request) error {
+	version, err := ifMatchVersion(r)
+	if err != nil {
+		return err
+	}
+
+	var updated schedule.Schedule
+	err = json.NewDecoder(r.Body).Decode(&updated)
+	if err != nil {
+		return schedule.Errorf(schedule.ErrValidation, "invalid schedule format")
+	}
+	if updated.Status == "" {
+		updated.Status = "active"
+	}
+	err = schedule.JoinFields(schedule.ValidateSchedule(updated), normalizeScheduleDetails(&updated))
+	if err != nil {
+		return err
+	}
+
+	updated.ID, err = srv.ResolveScheduleID(context.Background(), r.PathValue("id"))
+	if err != nil {
+		return err
+	}
+	var current int
+	query := "SELECT id, uuid::text, user_id, version, created_at FROM schedule WHERE id = $1 AND ($2 = '' OR user_id = $2)"
+	err = srv.db.QueryRow(context.Background(), query, updated.ID, currentUserID(r)).Scan(&updated.ID, &updated.UUID, &updated.UserID, &current, &updated.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return schedule.Errorf(schedule.ErrNotFound, "schedule not found")
+	}
+	if err != nil {
+		return fmt.Errorf("failed get schedule from database: %w", err)
+	}
+	if current != version {
+		w.Header().Set("ETag", versionETag(current))
+		return schedule.Errorf(schedule.ErrStale, "schedule was changed by someone else, reload and retry")
+	}
+
+	issues, err := srv.checkScheduleSafety(updated)
+	err = checkSafety(issues, err)
+	if err != nil {
+		return err
+	}
+
+	if updated.Status == "active" {
+		err = srv.checkOverlap(r, updated, updated.CreatedAt)
+		if err != nil {
+			return err
+		}
+	}
+
+	medicine, hash, err := srv.cipher.SealMedicine(updated.Medicine)
+	if err != nil {
+		return fmt.Errorf("failed encrypt schedule: %w", err)
+	}
+	instructions, err := srv.sealInstructions(updated.Instructions)
+	if err != nil {
+		return fmt.Errorf("failed encrypt schedule: %w", err)
+	}
+	prescriber, pharmacy, err := srv.sealContacts(updated)
+	if err != nil {
+		return fmt.Errorf("failed encrypt schedule: %w", err)
+	}
+
+	ctx := context.Background()
+	err = srv.db.InTx(ctx, func(tx pgx.Tx) error {
+		if updated.Status == "active" {
+			err := lockActivation(ctx, tx, updated.UserID, []int{updated.ID})
+			if err != nil {
+				return err
+			}
+		}
+
+		// the version check is repeated in the update in case of a concurrent write since the read
+		query := `UPDATE schedule SET medicine = $1, medicine_hash = $2, frequency = $3, duration = $4, status = $5, prescriber = $6, pharmacy = $7, tags = $8, color = $9, icon = $10, instructions = $11, pill = $12, dose = $13, strength = $14
+			WHERE id = $15 AND version = $16 RETURNING version`
+		return tx.QueryRow(ctx, query, medicine, hash, updated.Frequency, updated.Duration, updated.Status, prescriber, pharmacy, updated.Tags, updated.Color, updated.Icon, instructions, updated.Pill, updated.Dose, updated.Strength, updated.ID, version).Scan(&updated.Version)
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return schedule.Errorf(schedule.ErrStale, "schedule was changed by someone else, reload and retry")
+	}
+	var domainErr *schedule.Error
+	if errors.As(err, &domainErr) {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("failed update schedule in database: %w", err)
+	}
+	srv.rememberContacts(context.Background(), updated)
+
+	w.Header().Set("ETag", versionETag(updated.Version))
+	if len(issues) > 0 {
+		writeSaved(w, "schedule", updated.ID, issues)
+		return nil
+	}
+	fmt.Fprintf(w, "update schedule success")
+	return nil
+}
+
+func (srv *Server) getOneUserScheduleHandler(w http.ResponseWriter, r *http.Request) error {
+	requiredParams := []string{"user_id", "schedule_id"}
+	urlParams := r.URL.Query()
+	missingParamMessage := checkRequiredParams(requiredParams, urlParams)
+	if missingParamMessage != "" {
+		return schedule.Errorf(schedule.ErrValidation, "%s", missingParamMessage)
+	}
+
+	fields, err := parseFields(r, schedule.Schedule{})
+	if err != nil {
+		return err
+	}
+
+	userID := urlParams.Get("user_id")
+	scheduleID, err := srv.ResolveScheduleID(context.Background(), urlParams.Get("schedule_id"))
+	if err != nil {
+		return err
+	}
+	s, err := srv.getUserSchedule(context.Background(), userID, scheduleID)
+	if errors.Is(err, schedule.ErrNotFound) {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("failed get schedule from database: %w", err)
+	}
+
+	loc, err := srv.userLocation(r, userID)
+	if err != nil {
+		return err
+	}
+	progress := schedule.CourseProgress(s, time.Now(), loc)
+	s.Progress = &progress
+
+	w.Header().Set("ETag", versionETag(s.Version))
+	if notModified(w, r, s.UpdatedAt) {
+		return nil
+	}
+	if acceptedListFormat(r) == halMediaType {
+		writeResource(w, r, s)
+		return nil
+	}
+	fmt.Fprintf(w, convertToJson(sparse(s, fields)))
+	return nil
+}
+
+func (srv *Server) getAllUserSchedulesHandler(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return errMethodNotAllowed
+	}
+
+	requiredParams := []string{"user_id"}
+	urlParams := r.URL.Query()
+	missingParamMessage := checkRequiredParams(requiredParams, urlParams)
+	if missingParamMessage != "" {
+		return schedule.Errorf(schedule.ErrValidation, "%s", missingParamMessage)
+	}
+
+	fields, err := parseFields(r, schedule.Schedule{})
+	if err != nil {
+		return err
+	}
+
+	userID := urlParams.Get("user_id")
+	status := urlParams.Get("status")
+	if status != "" && !schedule.ValidStatus(status) {
+		return schedule.FieldErrorf("status", "enum", "invalid status, expected active, paused, completed or archived")
+	}
+
+	updatedSince, err := parseUpdatedSince(urlParams)
+	if err != nil {
+		return err
+	}
+
+	// tag= may repeat, schedules need all of them
+	tags, err := schedule.NormalizeTags(urlParams["tag"])
+	if err != nil {
+		return err
+	}
+
+	schedules, err := srv.ListUserSchedules(context.Background(), userID, status, updatedSince, tags)
+	if err != nil {
+		return fmt.Errorf("failed get schedules from database: %w", err)
+	}
+
+	if acceptedListFormat(r) != "" {
+		writeList(w, r, schedules)
+		return nil
+	}
+	if len(schedules) == 0 {
+		fmt.Fprintf(w, "no schedules for this user")
+		return nil
+	}
+
+	for _, s := range schedules {
+		fmt.Fprintf(w, convertToJson(sparse(s, fields)))
+	}
+	return nil
+}
+
+func (srv *Server) getNextTakingsHandler(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return errMethodNotAllowed
+	}
+
+	requiredParams := []string{"user_id"}
+	urlParams := r.URL.Query()
+	missingParamMessage := checkRequiredParams(requiredParams, urlParams)
+	if missingParamMessage != "" {
+		return schedule.Errorf(schedule.ErrValidation, "%s", missingParamMessage)
+	}
+
+	fields, err := parseFields(r, schedule.TakeSchedule{})
+	if err != nil {
+		return err
+	}
+
+	userID := urlParams.Get("user_id")
+	schedules, err := srv.ListUserSchedules(context.Background(), userID, "active", time.Time{}, nil)
+	if err != nil {
+		return fmt.Errorf("failed get schedules from database: %w", err)
+	}
+
+	if len(schedules) == 0 && acceptedListFormat(r) == "" {
+		fmt.Fprintf(w, "no schedules for this user")
+		return nil
+	}
+
+	loc, err := srv.userLocation(r, userID)
+	if err != nil {
+		return err
+	}
+
+	plan, err := srv.userDayPlan(context.Background(), userID)
+	if err != nil {
+		return err
+	}
+	// an explicit tz wins over a planned trip
+	if urlParams.Get("tz") != "" {
+		plan.travel = nil
+	}
+	now := time.Now()
+	var takeSchedules []schedule.TakeSchedule
+	for _, s := range schedules {
+		local, doseTimes := plan.doseTimes(s, now, loc)
+		if !schedule.CheckDay(s, local, local.Location()) {
+			continue
+		}
+		takeSchedules = append(takeSchedules, schedule.PlannedTakings(s, local, doseTimes)...)
+	}
+	if acceptedListFormat(r) != "" {
+		writeList(w, r, takeSchedules)
+		return nil
+	}
+	if len(takeSchedules) > 0 {
+		for _, takeSchedule := range takeSchedules {
+			fmt.Fprintf(w, convertToJson(sparse(takeSchedule, fields)))
+		}
+	} else {
+		fmt.Fprintf(w, "no schedules for the next %d hour/hours", schedule.PPH)
+	}
+	return nil
+}
+
+func (srv *Server) deleteScheduleHandler(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return errMethodNotAllowed
+	}
+
+	requiredParams := []string{"schedule_id"}
+	urlParams := r.URL.Query()
+	missingParamMessage := checkRequiredParams(requiredParams, urlParams)
+	if missingParamMessage != "" {
+		return schedule.Errorf(schedule.ErrValidation, "%s", missingParamMessage)
+	}
+
+	scheduleID, err := srv.ResolveScheduleID(context.Background(), urlParams.Get("schedule_id"))
+	if err != nil {
+		return err
+	}
+
+	query := "DELETE FROM schedule WHERE id = $1 AND ($2 = '' OR user_id = $2)"
+	tag, err := srv.db.Exec(context.Background(), query, scheduleID, currentUserID(r))
+	if err != nil {
+		return fmt.Errorf("failed delete schedule from database: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return schedule.Errorf(schedule.ErrNotFound, "schedule not found")
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, convertToJson(map[string]interface{}{"deleted": true, "id": scheduleID}))
+	return nil
+}
+
+func convertToJson(schedule interface{}) string {
+	b, err := json.Marshal(schedule)
+	if err != nil {
+		return "failed convert schedule to json"
+	}
+
+	return string(b)
+}
+
+func checkRequiredParams(reqParams []string, urlParams url.Values) string {
+	for _, param := range reqParams {
+		if _, ok := urlParams[param]; !ok {
+			return "missing required parameter: " + param
+		}
+	}
+
+	return ""
+}
+
+func versionETag(version int) string {
+	return fmt.Sprintf(`"%d"`, version)
+}
+
+// reads the version from the If-Match header, which is required
+func ifMatchVersion(r *http.Request) (int, error) {
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch == "" {
+		return 0, schedule.Errorf(schedule.ErrVersionRequired, "missing If-Match header")
+	}
+
+	version, err := strconv.Atoi(strings.Trim(strings.TrimPrefix(ifMatch, "W/"), `"`))
+	if err != nil {
+		return 0, schedule.Errorf(schedule.ErrValidation, "invalid If-Match header")
+	}
+
+	return version, nil
+}
+
+// optional updated_since filter of list endpoints, the zero time matches everything
+func parseUpdatedSince(urlParams url.Values) (time.Time, error) {
+	updatedSince := urlParams.Get("updated_since")
+	if updatedSince == "" {
+		return time.Time{}, nil
+	}
+
+	since, err := time.Parse(time.RFC3339, updatedSince)
+	if err != nil {
+		return time.Time{}, schedule.FieldErrorf("updated_since", "format", "invalid updated_since, expected RFC 3339 time")
+	}
+
+	return since, nil
+}
+
+// sets Last-Modified and answers 304 when the client copy is still current
+func notModified(w http.ResponseWriter, r *http.Request, updatedAt time.Time) bool {
+	w.Header().Set("Last-Modified", updatedAt.UTC().Format(http.TimeFormat))
+
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil || updatedAt.Truncate(time.Second).After(since) {
+		return false
+	}
+
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// admin endpoints are protected by the ADMIN_TOKEN bearer token
+func adminOnly(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := os.Getenv("ADMIN_TOKEN")
+		given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(given)) != 1 {
+			writeError(w, r, schedule.Errorf(schedule.ErrForbidden, "forbidden"))
+			return
+		}
+
+		next(w, r)
+	}
+}
@@ -0,0 +1,103 @@
+package http
+
+import (
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"kode_test/internal/schedule"
+	"kode_test/internal/storage"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultSearchLimit = 50
+	maxSearchLimit     = 200
+	maxSearchLength    = 200
+)
+
+// words anywhere in the medicine, tags, instructions, prescriber or pharmacy, and partial or misspelled
+// medicine names. With encryption on the medicine, instructions, prescriber and pharmacy are not indexed,
+// a search finds those schedules by the whole medicine name only.
+const querySearchSchedules = "SELECT " + scheduleColumns + ` FROM schedule
+	WHERE user_id = ANY($1) AND (search @@ websearch_to_tsquery('simple', $2) OR medicine_hash = $4
+		OR (medicine NOT LIKE 'enc:%' AND (medicine ILIKE $3 OR $2 <% medicine)))
+	ORDER BY medicine_hash = $4 DESC, ts_rank(search, websearch_to_tsquery('simple', $2)) DESC,
+		CASE WHEN medicine LIKE 'enc:%' THEN 0 ELSE word_similarity($2, medicine) END DESC, id
+	LIMIT $5`
+
+// searches the schedules of the user, or with org_id those of all patients of an organization
+// the caller is staff of. Best matches first, limit=50 by default.
+func (srv *Server) searchSchedulesHandler(w http.ResponseWriter, r *http.Request) error {
+	urlParams := r.URL.Query()
+	q := strings.TrimSpace(urlParams.Get("q"))
+	if q == "" {
+		return schedule.Errorf(schedule.ErrValidation, "missing required parameter: q")
+	}
+	if len(q) > maxSearchLength {
+		return schedule.Errorf(schedule.ErrValidation, "q is longer than %d characters", maxSearchLength)
+	}
+	limit := defaultSearchLimit
+	if value := urlParams.Get("limit"); value != "" {
+		var err error
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxSearchLimit {
+			return schedule.Errorf(schedule.ErrValidation, "limit must be between 1 and %d", maxSearchLimit)
+		}
+	}
+
+	userIDs, err := srv.searchScope(r)
+	if err != nil {
+		return err
+	}
+
+	like := "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(q) + "%"
+	schedules, err := srv.collectSchedules(srv.db.QueryRead(r.Context(), querySearchSchedules, userIDs, q, like, storage.MedicineHash(q), limit))
+	if err != nil {
+		return fmt.Errorf("failed search schedules in database: %w", err)
+	}
+	if schedules == nil {
+		schedules = []schedule.Schedule{}
+	}
+
+	if acceptedListFormat(r) != "" {
+		writeList(w, r, schedules)
+		return nil
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, convertToJson(schedules))
+	return nil
+}
+
+// the users whose schedules a search covers
+func (srv *Server) searchScope(r *http.Request) ([]string, error) {
+	orgID := r.URL.Query().Get("org_id")
+	if orgID == "" {
+		userID, err := requestUserID(r)
+		return []string{userID}, err
+	}
+	if currentUserID(r) == "" {
+		return nil, schedule.Errorf(schedule.ErrForbidden, "forbidden")
+	}
+
+	var staff bool
+	query := "SELECT EXISTS (SELECT 1 FROM org_member WHERE org_id::text = $1 AND user_id = $2 AND role = 'staff')"
+	err := srv.db.QueryRow(r.Context(), query, orgID, currentUserID(r)).Scan(&staff)
+	if err != nil {
+		return nil, fmt.Errorf("failed get organization from database: %w", err)
+	}
+	if !staff {
+		return nil, schedule.Errorf(schedule.ErrNotFound, "organization not found")
+	}
+
+	rows, err := srv.db.QueryRead(r.Context(), "SELECT user_id FROM org_member WHERE org_id::text = $1 AND role = 'patient'", orgID)
+	if err != nil {
+		return nil, fmt.Errorf("failed get organization patients from database: %w", err)
+	}
+	userIDs, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return nil, fmt.Errorf("failed get organization patients from database: %w", err)
+	}
+
+	return userIDs, nil
+}
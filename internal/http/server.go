@@ -0,0 +1,73 @@
+// Package http serves the scheduler API and runs its background jobs.
+package http
+
+import (
+	"kode_test/internal/features"
+	"kode_test/internal/notify"
+	"kode_test/internal/objectstore"
+	"kode_test/internal/prescription"
+	"kode_test/internal/storage"
+	"net/http"
+	"sync"
+)
+
+// the API with everything it depends on, handlers and jobs are its methods
+type Server struct {
+	db          *storage.DB
+	cipher      *storage.Cipher
+	notifier    notify.Notifier
+	caller      notify.Caller
+	objects     objectstore.Store
+	extractor   prescription.Extractor
+	limiter     rateLimiter
+	maintenance *maintenanceSwitch
+	reportWake  chan struct{}
+	rosterWake  chan struct{}
+	doseWaiters *changeWaiters
+	usage       *usageCounter
+	accessLog   *deferredAccessLog
+	shedder     *loadShedder
+	stats       *statsCache
+	contract    *contractCheck
+	flags       *features.Set
+
+	listenersMu       sync.Mutex
+	scheduleListeners []func(userID string)
+}
+
+// caller, objects and extractor may be nil when voice calls, uploads or prescription scanning are not configured
+func NewServer(db *storage.DB, cipher *storage.Cipher, notifier notify.Notifier, caller notify.Caller, objects objectstore.Store, extractor prescription.Extractor) (*Server, error) {
+	limiter, err := newRateLimiter()
+	if err != nil {
+		return nil, err
+	}
+
+	srv := &Server{
+		db:          db,
+		cipher:      cipher,
+		notifier:    notifier,
+		caller:      caller,
+		objects:     objects,
+		extractor:   extractor,
+		limiter:     limiter,
+		maintenance: newMaintenanceSwitch(),
+		reportWake:  make(chan struct{}, 1),
+		rosterWake:  make(chan struct{}, 1),
+		doseWaiters: newChangeWaiters(),
+		usage:       newUsageCounter(),
+		accessLog:   &deferredAccessLog{},
+		shedder:     newLoadShedder(),
+		stats:       newStatsCache(),
+		contract:    newContractCheck(),
+		flags:       newFeatureFlags(),
+	}
+	srv.OnScheduleChange(srv.doseWaiters.wake)
+
+	return srv, nil
+}
+
+// all routes behind the maintenance guard, counted per caller unless shed for load,
+// checked against the OpenAPI document when one is configured
+func (srv *Server) Handler() http.Handler {
+	return srv.shedLoad(srv.countUsage(srv.localUser(srv.maintenanceGuard(srv.checkContract(srv.routes())))))
+}
@@ -0,0 +1,151 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"kode_test/internal/schedule"
+	"net"
+	"net/http"
+	"time"
+)
+
+const refreshTokenTTL = 30 * 24 * time.Hour
+
+type Session struct {
+	ID         string    `json:"id"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Current    bool      `json:"current"`
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// creates a server-side session and answers with an access and refresh token pair
+func (srv *Server) startSession(w http.ResponseWriter, r *http.Request, userID string) error {
+	sessionID, refreshToken, err := srv.createSession(context.Background(), r, userID)
+	if err != nil {
+		return fmt.Errorf("failed create session: %w", err)
+	}
+
+	return writeTokens(w, userID, sessionID, refreshToken)
+}
+
+func (srv *Server) createSession(ctx context.Context, r *http.Request, userID string) (string, string, error) {
+	sessionID := randomHex(16)
+	refreshToken := randomHex(32)
+
+	query := `INSERT INTO auth_session (id, user_id, refresh_token_hash, user_agent, ip, expires_at) VALUES ($1, $2, $3, $4, $5, $6)`
+	_, err := srv.db.Exec(ctx, query, sessionID, userID, hashToken(refreshToken), r.UserAgent(), clientIP(r), time.Now().Add(refreshTokenTTL))
+	if err != nil {
+		return "", "", err
+	}
+
+	return sessionID, refreshToken, nil
+}
+
+func writeTokens(w http.ResponseWriter, userID string, sessionID string, refreshToken string) error {
+	accessToken, err := issueAccessToken(userID, sessionID)
+	if err != nil {
+		return fmt.Errorf("failed issue access token: %w", err)
+	}
+
+	fmt.Fprint(w, convertToJson(map[string]interface{}{
+		"access_token":  accessToken,
+		"refresh_token": refreshToken,
+		"token_type":    "Bearer",
+		"expires_in":    int(accessTokenTTL.Seconds()),
+	}))
+	return nil
+}
+
+// exchanges a refresh token for a new pair, the presented refresh token stops working
+func (srv *Server) refreshHandler(w http.ResponseWriter, r *http.Request) error {
+	var body struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil || body.RefreshToken == "" {
+		return schedule.Errorf(schedule.ErrValidation, "invalid refresh format")
+	}
+
+	presented := hashToken(body.RefreshToken)
+	refreshToken := randomHex(32)
+
+	var sessionID, userID string
+	query := `UPDATE auth_session SET refresh_token_hash = $1, previous_token_hash = $2, last_used_at = now()
+		WHERE refresh_token_hash = $2 AND revoked_at IS NULL AND expires_at > now()
+		RETURNING id, user_id`
+	err = srv.db.QueryRow(context.Background(), query, hashToken(refreshToken), presented).Scan(&sessionID, &userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		// a rotated token used again means it leaked, so the whole session goes
+		_, err = srv.db.Exec(context.Background(), "UPDATE auth_session SET revoked_at = now() WHERE previous_token_hash = $1 AND revoked_at IS NULL", presented)
+		if err != nil {
+			return fmt.Errorf("failed revoke session: %w", err)
+		}
+		return schedule.Errorf(schedule.ErrUnauthorized, "invalid or expired refresh token")
+	}
+	if err != nil {
+		return fmt.Errorf("failed refresh session: %w", err)
+	}
+
+	return writeTokens(w, userID, sessionID, refreshToken)
+}
+
+func (srv *Server) getSessionsHandler(w http.ResponseWriter, r *http.Request) error {
+	query := `SELECT id, user_agent, ip, created_at, last_used_at, expires_at FROM auth_session
+		WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > now() ORDER BY last_used_at DESC`
+	rows, err := srv.db.Query(context.Background(), query, currentUserID(r))
+	if err != nil {
+		return fmt.Errorf("failed get sessions from database: %w", err)
+	}
+	defer rows.Close()
+
+	sessions := []Session{}
+	for rows.Next() {
+		var session Session
+		err := rows.Scan(&session.ID, &session.UserAgent, &session.IP, &session.CreatedAt, &session.LastUsedAt, &session.ExpiresAt)
+		if err != nil {
+			return fmt.Errorf("failed get session: %w", err)
+		}
+		session.Current = session.ID == currentSessionID(r)
+		sessions = append(sessions, session)
+	}
+
+	fmt.Fprint(w, convertToJson(sessions))
+	return nil
+}
+
+func (srv *Server) revokeSessionHandler(w http.ResponseWriter, r *http.Request) error {
+	return srv.revokeSession(w, currentUserID(r), r.PathValue("id"))
+}
+
+func (srv *Server) logoutHandler(w http.ResponseWriter, r *http.Request) error {
+	return srv.revokeSession(w, currentUserID(r), currentSessionID(r))
+}
+
+func (srv *Server) revokeSession(w http.ResponseWriter, userID string, sessionID string) error {
+	query := "UPDATE auth_session SET revoked_at = now() WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL"
+	tag, err := srv.db.Exec(context.Background(), query, sessionID, userID)
+	if err != nil {
+		return fmt.Errorf("failed revoke session: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return schedule.Errorf(schedule.ErrNotFound, "session not found")
+	}
+
+	fmt.Fprintf(w, "session revoked")
+	return nil
+}
@@ -0,0 +1,240 @@
+package http
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"github.com/skip2/go-qrcode"
+	"kode_test/internal/schedule"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	defaultShareLinkTTL = 72 * time.Hour
+	maxShareLinkTTL     = 30 * 24 * time.Hour
+)
+
+type shareClaims struct {
+	LinkID    string `json:"jti"`
+	Subject   string `json:"sub"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// share tokens are signed with the JWT secret under their own prefix,
+// so they can never pass as an access token or the other way round
+func signShareToken(claims shareClaims) (string, error) {
+	secret, err := jwtSecret()
+	if err != nil {
+		return "", err
+	}
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("share." + encoded))
+
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+func parseShareToken(token string) (shareClaims, error) {
+	var claims shareClaims
+	secret, err := jwtSecret()
+	if err != nil {
+		return claims, err
+	}
+
+	encoded, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return claims, errors.New("malformed share token")
+	}
+	sum, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil {
+		return claims, errors.New("malformed share token")
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("share." + encoded))
+	if !hmac.Equal(sum, mac.Sum(nil)) {
+		return claims, errors.New("invalid share token signature")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return claims, errors.New("malformed share token")
+	}
+	err = json.Unmarshal(payload, &claims)
+	if err != nil {
+		return claims, errors.New("malformed share token")
+	}
+	if time.Now().Unix() >= claims.ExpiresAt {
+		return claims, errors.New("share link expired")
+	}
+
+	return claims, nil
+}
+
+type ShareLink struct {
+	ID string `json:"id"`
+	// empty for all active schedules of the user
+	ScheduleIDs  []int      `json:"schedule_ids"`
+	ExpiresAt    time.Time  `json:"expires_at"`
+	LastViewedAt *time.Time `json:"last_viewed_at"`
+	CreatedAt    time.Time  `json:"created_at"`
+}
+
+// a link anyone can open to see the current plan, for a doctor or caregiver without an account
+func (srv *Server) createShareLinkHandler(w http.ResponseWriter, r *http.Request) error {
+	userID, err := pathUserID(r)
+	if err != nil {
+		return err
+	}
+
+	var body struct {
+		ExpiresInHours int   `json:"expires_in_hours"`
+		ScheduleIDs    []int `json:"schedule_ids"`
+	}
+	if r.ContentLength != 0 {
+		err = json.NewDecoder(r.Body).Decode(&body)
+		if err != nil {
+			return schedule.Errorf(schedule.ErrValidation, "invalid share link format")
+		}
+	}
+	ttl := defaultShareLinkTTL
+	if body.ExpiresInHours != 0 {
+		ttl = time.Duration(body.ExpiresInHours) * time.Hour
+	}
+	if ttl <= 0 || ttl > maxShareLinkTTL {
+		return schedule.Errorf(schedule.ErrValidation, "expires_in_hours must be between 1 and %d", int(maxShareLinkTTL.Hours()))
+	}
+
+	ctx := context.Background()
+	var scheduleIDs []int
+	if len(body.ScheduleIDs) > 0 {
+		slices.Sort(body.ScheduleIDs)
+		scheduleIDs = slices.Compact(body.ScheduleIDs)
+		var owned int
+		err = srv.db.QueryRow(ctx, "SELECT count(*) FROM schedule WHERE id = ANY($1) AND user_id = $2", scheduleIDs, userID).Scan(&owned)
+		if err != nil {
+			return fmt.Errorf("failed get schedules from database: %w", err)
+		}
+		if owned != len(scheduleIDs) {
+			return schedule.Errorf(schedule.ErrNotFound, "schedule not found")
+		}
+	}
+
+	link := ShareLink{ID: randomHex(8), ScheduleIDs: scheduleIDs, ExpiresAt: time.Now().Add(ttl).Truncate(time.Second)}
+	token, err := signShareToken(shareClaims{LinkID: link.ID, Subject: userID, ExpiresAt: link.ExpiresAt.Unix()})
+	if err != nil {
+		return fmt.Errorf("failed sign share token: %w", err)
+	}
+	url := appURL() + "/v1/shared/" + token
+	png, err := qrcode.Encode(url, qrcode.Medium, 256)
+	if err != nil {
+		return fmt.Errorf("failed encode qr code: %w", err)
+	}
+
+	query := "INSERT INTO share_link (id, user_id, schedule_ids, expires_at) VALUES ($1, $2, $3, $4) RETURNING created_at"
+	err = srv.db.QueryRow(ctx, query, link.ID, userID, scheduleIDs, link.ExpiresAt).Scan(&link.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed add share link to database: %w", err)
+	}
+
+	// the URL is shown only once, a lost link is revoked and a new one created
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	fmt.Fprint(w, convertToJson(struct {
+		ShareLink
+		URL string `json:"url"`
+		// PNG of the URL as a data URI, for showing on screen to whoever scans it
+		QRCode string `json:"qr_code"`
+	}{link, url, "data:image/png;base64," + base64.StdEncoding.EncodeToString(png)}))
+	return nil
+}
+
+// the links of the user that still work
+func (srv *Server) getShareLinksHandler(w http.ResponseWriter, r *http.Request) error {
+	userID, err := pathUserID(r)
+	if err != nil {
+		return err
+	}
+
+	query := `SELECT id, schedule_ids, expires_at, last_viewed_at, created_at FROM share_link
+		WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > now() ORDER BY created_at`
+	rows, err := srv.db.Query(context.Background(), query, userID)
+	if err != nil {
+		return fmt.Errorf("failed get share links from database: %w", err)
+	}
+	defer rows.Close()
+
+	links := []ShareLink{}
+	for rows.Next() {
+		var link ShareLink
+		err := rows.Scan(&link.ID, &link.ScheduleIDs, &link.ExpiresAt, &link.LastViewedAt, &link.CreatedAt)
+		if err != nil {
+			return fmt.Errorf("failed get share link: %w", err)
+		}
+		links = append(links, link)
+	}
+	if rows.Err() != nil {
+		return fmt.Errorf("failed get share links from database: %w", rows.Err())
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, convertToJson(links))
+	return nil
+}
+
+func (srv *Server) revokeShareLinkHandler(w http.ResponseWriter, r *http.Request) error {
+	userID, err := pathUserID(r)
+	if err != nil {
+		return err
+	}
+
+	query := "UPDATE share_link SET revoked_at = now() WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL"
+	tag, err := srv.db.Exec(context.Background(), query, r.PathValue("link_id"), userID)
+	if err != nil {
+		return fmt.Errorf("failed revoke share link: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return schedule.Errorf(schedule.ErrNotFound, "share link not found")
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// the plan behind a share link, read-only and without an account
+func (srv *Server) getSharedPlanHandler(w http.ResponseWriter, r *http.Request) error {
+	claims, err := parseShareToken(r.PathValue("token"))
+	if err != nil {
+		return schedule.Errorf(schedule.ErrNotFound, "share link not found or expired")
+	}
+
+	var scheduleIDs []int
+	query := `UPDATE share_link SET last_viewed_at = now()
+		WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL AND expires_at > now()
+		RETURNING schedule_ids`
+	if srv.inMaintenance() {
+		// reads write nothing in maintenance, last_viewed_at moves on the next view
+		query = `SELECT schedule_ids FROM share_link WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL AND expires_at > now()`
+	}
+	err = srv.db.QueryRow(context.Background(), query, claims.LinkID, claims.Subject).Scan(&scheduleIDs)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return schedule.Errorf(schedule.ErrNotFound, "share link not found or expired")
+	}
+	if err != nil {
+		return fmt.Errorf("failed get share link from database: %w", err)
+	}
+
+	return srv.writePlanPDF(w, r, claims.Subject, scheduleIDs)
+}
@@ -0,0 +1,102 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"kode_test/internal/schedule"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+var sideEffectSeverities = []string{"mild", "moderate", "severe"}
+
+type SideEffect struct {
+	ID          string    `json:"id"`
+	ScheduleID  *int      `json:"schedule_id"`
+	Description string    `json:"description"`
+	Severity    string    `json:"severity"`
+	OccurredAt  time.Time `json:"occurred_at"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// an entry of the user's side-effect diary, optionally about one of their schedules
+func (srv *Server) createSideEffectHandler(w http.ResponseWriter, r *http.Request) error {
+	var sideEffect SideEffect
+	err := json.NewDecoder(r.Body).Decode(&sideEffect)
+	if err != nil {
+		return schedule.Errorf(schedule.ErrValidation, "invalid side effect format")
+	}
+	sideEffect.Description = strings.TrimSpace(sideEffect.Description)
+	if sideEffect.Description == "" || !slices.Contains(sideEffectSeverities, sideEffect.Severity) {
+		return schedule.Errorf(schedule.ErrValidation, "description and a severity of mild, moderate or severe are required")
+	}
+	if sideEffect.OccurredAt.IsZero() {
+		sideEffect.OccurredAt = time.Now()
+	}
+
+	userID, err := requestUserID(r)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	if sideEffect.ScheduleID != nil {
+		scheduleID, err := srv.ownScheduleID(ctx, fmt.Sprint(*sideEffect.ScheduleID), userID)
+		if err != nil {
+			return err
+		}
+		sideEffect.ScheduleID = &scheduleID
+	}
+
+	description, err := srv.cipher.Encrypt(sideEffect.Description)
+	if err != nil {
+		return fmt.Errorf("failed encrypt side effect: %w", err)
+	}
+	query := `INSERT INTO side_effect (user_id, schedule_id, description, severity, occurred_at) VALUES ($1, $2, $3, $4, $5)
+		RETURNING id::text, created_at`
+	err = srv.db.QueryRow(ctx, query, userID, sideEffect.ScheduleID, description, sideEffect.Severity, sideEffect.OccurredAt).Scan(&sideEffect.ID, &sideEffect.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed save side effect: %w", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	fmt.Fprint(w, convertToJson(sideEffect))
+	return nil
+}
+
+func (srv *Server) getSideEffectsHandler(w http.ResponseWriter, r *http.Request) error {
+	userID, err := requestUserID(r)
+	if err != nil {
+		return err
+	}
+
+	return srv.writeSideEffects(w, r, userID)
+}
+
+// the diary of the user, newest first
+func (srv *Server) writeSideEffects(w http.ResponseWriter, r *http.Request, userID string) error {
+	query := `SELECT id::text, schedule_id, description, severity, occurred_at, created_at FROM side_effect
+		WHERE user_id = $1 ORDER BY occurred_at DESC`
+	rows, err := srv.db.QueryRead(context.Background(), query, userID)
+	if err != nil {
+		return fmt.Errorf("failed get side effects from database: %w", err)
+	}
+	sideEffects, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (SideEffect, error) {
+		var sideEffect SideEffect
+		err := row.Scan(&sideEffect.ID, &sideEffect.ScheduleID, &sideEffect.Description, &sideEffect.Severity, &sideEffect.OccurredAt, &sideEffect.CreatedAt)
+		if err == nil {
+			sideEffect.Description, err = srv.cipher.Decrypt(sideEffect.Description)
+		}
+		return sideEffect, err
+	})
+	if err != nil {
+		return fmt.Errorf("failed get side effects from database: %w", err)
+	}
+
+	writeList(w, r, sideEffects)
+	return nil
+}
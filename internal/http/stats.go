@@ -0,0 +1,148 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// the stats are aggregates over whole tables, operators get the same answer for this long
+const statsCacheTTL = 5 * time.Minute
+
+type StatsDay struct {
+	Day                 string `json:"day"`
+	Signups             int64  `json:"signups"`
+	Intakes             int64  `json:"intakes"`
+	NotificationsSent   int64  `json:"notifications_sent"`
+	NotificationsFailed int64  `json:"notifications_failed"`
+}
+
+type AdminStats struct {
+	From            string     `json:"from"`
+	To              string     `json:"to"`
+	Users           int64      `json:"users"`
+	ActiveSchedules int64      `json:"active_schedules"`
+	Days            []StatsDay `json:"days"`
+	GeneratedAt     time.Time  `json:"generated_at"`
+}
+
+type statsCache struct {
+	mu      sync.Mutex
+	entries map[string]AdminStats
+}
+
+func newStatsCache() *statsCache {
+	return &statsCache{entries: map[string]AdminStats{}}
+}
+
+func (c *statsCache) get(key string) (AdminStats, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats, ok := c.entries[key]
+	return stats, ok && time.Since(stats.GeneratedAt) < statsCacheTTL
+}
+
+func (c *statsCache) put(key string, stats AdminStats) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for k, cached := range c.entries {
+		if time.Since(cached.GeneratedAt) >= statsCacheTTL {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = stats
+}
+
+// growth and activity per UTC day from the replica when there is one, days are from and to inclusive
+func (srv *Server) adminStats(ctx context.Context, from time.Time, to time.Time) (AdminStats, error) {
+	stats := AdminStats{From: from.Format("2006-01-02"), To: to.Format("2006-01-02"), Days: []StatsDay{}, GeneratedAt: time.Now()}
+	rows, err := srv.db.QueryRead(ctx, "SELECT (SELECT count(*) FROM users), (SELECT count(*) FROM schedule WHERE status = 'active')")
+	if err != nil {
+		return stats, err
+	}
+	_, err = pgx.ForEachRow(rows, []any{&stats.Users, &stats.ActiveSchedules}, func() error { return nil })
+	if err != nil {
+		return stats, err
+	}
+
+	for day := from; !day.After(to); day = day.AddDate(0, 0, 1) {
+		stats.Days = append(stats.Days, StatsDay{Day: day.Format("2006-01-02")})
+	}
+	days := map[string]*StatsDay{}
+	for i := range stats.Days {
+		days[stats.Days[i].Day] = &stats.Days[i]
+	}
+
+	// one grouped scan per table, the session runs in UTC so the days are UTC days
+	counts := []struct {
+		query string
+		apply func(day *StatsDay, values []int64)
+		width int
+	}{
+		{
+			"SELECT to_char(created_at, 'YYYY-MM-DD'), count(*) FROM users WHERE created_at >= $1 AND created_at < $2 GROUP BY 1",
+			func(day *StatsDay, values []int64) { day.Signups = values[0] }, 1,
+		},
+		{
+			"SELECT to_char(taken_at, 'YYYY-MM-DD'), count(*) FROM intake_log WHERE taken_at >= $1 AND taken_at < $2 GROUP BY 1",
+			func(day *StatsDay, values []int64) { day.Intakes = values[0] }, 1,
+		},
+		{
+			`SELECT to_char(sent_at, 'YYYY-MM-DD'), count(*) FILTER (WHERE status = 'sent'), count(*) FILTER (WHERE status IN ('failed', 'dead'))
+				FROM reminder WHERE sent_at >= $1 AND sent_at < $2 GROUP BY 1`,
+			func(day *StatsDay, values []int64) {
+				day.NotificationsSent, day.NotificationsFailed = values[0], values[1]
+			}, 2,
+		},
+	}
+	for _, count := range counts {
+		rows, err := srv.db.QueryRead(ctx, count.query, from, to.AddDate(0, 0, 1))
+		if err != nil {
+			return stats, err
+		}
+		var day string
+		values := make([]int64, count.width)
+		dest := []any{&day}
+		for i := range values {
+			dest = append(dest, &values[i])
+		}
+		_, err = pgx.ForEachRow(rows, dest, func() error {
+			if d, ok := days[day]; ok {
+				count.apply(d, values)
+			}
+			return nil
+		})
+		if err != nil {
+			return stats, err
+		}
+	}
+
+	return stats, nil
+}
+
+// users, active schedules and the activity per day over from and to, 30 days by default
+func (srv *Server) getAdminStatsHandler(w http.ResponseWriter, r *http.Request) error {
+	from, to, err := usagePeriod(r)
+	if err != nil {
+		return err
+	}
+
+	key := from.Format("2006-01-02") + "/" + to.Format("2006-01-02")
+	stats, ok := srv.stats.get(key)
+	if !ok {
+		stats, err = srv.adminStats(r.Context(), from, to)
+		if err != nil {
+			return fmt.Errorf("failed get stats from database: %w", err)
+		}
+		srv.stats.put(key, stats)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, convertToJson(stats))
+	return nil
+}
@@ -0,0 +1,30 @@
+package http
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStatsCache(t *testing.T) {
+	cache := newStatsCache()
+	if _, ok := cache.get("2026-09-16/2026-10-16"); ok {
+		t.Fatal("hit in an empty cache")
+	}
+
+	stale := AdminStats{Users: 1, GeneratedAt: time.Now().Add(-statsCacheTTL)}
+	cache.put("2026-08-01/2026-08-31", stale)
+	if _, ok := cache.get("2026-08-01/2026-08-31"); ok {
+		t.Error("hit for stats older than the TTL")
+	}
+
+	fresh := AdminStats{Users: 2, GeneratedAt: time.Now()}
+	cache.put("2026-09-16/2026-10-16", fresh)
+	stats, ok := cache.get("2026-09-16/2026-10-16")
+	if !ok || stats.Users != 2 {
+		t.Errorf("get after put = %+v, %v", stats, ok)
+	}
+	// a put drops the expired entries, so periods nobody asks for again do not pile up
+	if _, ok := cache.entries["2026-08-01/2026-08-31"]; ok || len(cache.entries) != 1 {
+		t.Errorf("expired stats kept: %v", cache.entries)
+	}
+}
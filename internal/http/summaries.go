@@ -0,0 +1,222 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"kode_test/internal/pii"
+	"kode_test/internal/schedule"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	caregiverSummaryJobInterval = time.Hour
+	caregiverSummaryPeriod      = 7 * 24 * time.Hour
+	// supply for fewer days than this is reported as low
+	lowSupplyDays = 7
+)
+
+type WeeklySummarySetting struct {
+	Enabled bool `json:"enabled"`
+}
+
+// a medicine that runs out soon, by the refills logged against its schedule
+type lowSupply struct {
+	medicine string
+	daysLeft float64
+}
+
+// a caregiver opts in or out of the weekly summary of a patient who linked them
+func (srv *Server) putWeeklySummaryHandler(w http.ResponseWriter, r *http.Request) error {
+	var setting WeeklySummarySetting
+	err := json.NewDecoder(r.Body).Decode(&setting)
+	if err != nil {
+		return schedule.Errorf(schedule.ErrValidation, "invalid weekly summary format")
+	}
+
+	query := "UPDATE caregiver_link SET weekly_summary = $3 WHERE patient_id = $1 AND caregiver_id = $2"
+	tag, err := srv.db.Exec(r.Context(), query, r.PathValue("id"), currentUserID(r), setting.Enabled)
+	if err != nil {
+		return fmt.Errorf("failed save weekly summary setting: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return schedule.Errorf(schedule.ErrNotFound, "patient not found")
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, convertToJson(setting))
+	return nil
+}
+
+// sends the summary of the last seven days to every caregiver who opted in and did not get one
+// for a week. A failed delivery is tried again on the next run.
+func (srv *Server) SendCaregiverSummaries(ctx context.Context) (int, error) {
+	query := `SELECT l.patient_id, l.caregiver_id, u.email, u.timezone FROM caregiver_link l JOIN users u ON u.id = l.patient_id
+		WHERE l.weekly_summary AND (l.summary_sent_at IS NULL OR l.summary_sent_at <= now() - $1::interval)`
+	rows, err := srv.db.Query(ctx, query, caregiverSummaryPeriod.String())
+	if err != nil {
+		return 0, err
+	}
+	type due struct {
+		patientID, caregiverID, email, timezone string
+	}
+	summaries, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (due, error) {
+		var d due
+		err := row.Scan(&d.patientID, &d.caregiverID, &d.email, &d.timezone)
+		return d, err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	for _, d := range summaries {
+		if stopped(ctx) {
+			return sent, nil
+		}
+		loc, err := time.LoadLocation(d.timezone)
+		if err != nil {
+			loc = time.UTC
+		}
+		message, err := srv.caregiverSummary(ctx, d.patientID, d.email, loc)
+		if err != nil {
+			return sent, fmt.Errorf("summary of %s: %w", pii.MaskUserID(d.patientID), err)
+		}
+		err = srv.notifier.Notify(ctx, d.caregiverID, "Weekly summary for "+d.email, message)
+		if err != nil {
+			log.Printf("caregiver summary job: notify %s: %v", pii.MaskUserID(d.caregiverID), err)
+			continue
+		}
+
+		_, err = srv.db.Exec(ctx, "UPDATE caregiver_link SET summary_sent_at = now() WHERE patient_id = $1 AND caregiver_id = $2", d.patientID, d.caregiverID)
+		if err != nil {
+			return sent, err
+		}
+		sent++
+	}
+
+	return sent, nil
+}
+
+// adherence, missed doses per medicine and low supply of the patient over the last seven whole days
+func (srv *Server) caregiverSummary(ctx context.Context, patientID string, email string, loc *time.Location) (string, error) {
+	to := schedule.LocalDate(time.Now(), loc).AddDate(0, 0, -1)
+	from := to.AddDate(0, 0, -6)
+	window, err := srv.onTimeWindow(ctx, patientID)
+	if err != nil {
+		return "", err
+	}
+	schedules, tallies, err := srv.tallyUserDoses(ctx, patientID, from, to, loc, window.duration())
+	if err != nil {
+		return "", err
+	}
+
+	var due, taken, onTime, skipped int
+	var missed []string
+	for _, s := range schedules {
+		tally := tallies[s.ID]
+		due += tally.due
+		taken += len(tally.delays)
+		onTime += tally.onTime
+		skipped += tally.skipped
+		if tally.missed > 0 {
+			missed = append(missed, fmt.Sprintf("- %s: %d missed", s.Medicine, tally.missed))
+		}
+	}
+
+	var message strings.Builder
+	fmt.Fprintf(&message, "%s, %s to %s\n", email, from.Format("Jan 2"), to.Format("Jan 2"))
+	if due == 0 {
+		message.WriteString("No doses were planned.\n")
+	} else {
+		fmt.Fprintf(&message, "%d of %d doses taken (%.0f%%), %d of them on time, %d skipped.\n", taken, due, float64(taken)/float64(due)*100, onTime, skipped)
+	}
+	if len(missed) > 0 {
+		message.WriteString("\nMissed doses:\n" + strings.Join(missed, "\n") + "\n")
+	}
+
+	supplies, err := srv.lowSupplies(ctx, patientID, schedules)
+	if err != nil {
+		return "", err
+	}
+	if len(supplies) > 0 {
+		message.WriteString("\nRunning low:\n")
+		for _, supply := range supplies {
+			fmt.Fprintf(&message, "- %s: about %.0f days left\n", supply.medicine, supply.daysLeft)
+		}
+	}
+
+	return message.String(), nil
+}
+
+// active schedules whose refills last fewer than lowSupplyDays more days, counting the intakes since the first refill
+func (srv *Server) lowSupplies(ctx context.Context, userID string, schedules []schedule.Schedule) ([]lowSupply, error) {
+	query := `SELECT r.schedule_id, sum(r.quantity),
+			(SELECT count(*) FROM intake_log i WHERE i.schedule_id = r.schedule_id AND i.taken_at >= min(r.filled_on))
+		FROM refill r WHERE r.user_id = $1 GROUP BY r.schedule_id`
+	rows, err := srv.db.QueryRead(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed get refills from database: %w", err)
+	}
+	type stock struct{ quantity, intakes int }
+	stocks := map[int]stock{}
+	var scheduleID int
+	var s stock
+	_, err = pgx.ForEachRow(rows, []any{&scheduleID, &s.quantity, &s.intakes}, func() error {
+		stocks[scheduleID] = s
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed get refills from database: %w", err)
+	}
+
+	var supplies []lowSupply
+	for _, sch := range schedules {
+		stock, ok := stocks[sch.ID]
+		if !ok || sch.Status != "active" || sch.Duration == 0 {
+			continue
+		}
+		perDose := schedule.UnitsPerDose(sch)
+		if perDose <= 0 {
+			continue
+		}
+		remaining := float64(stock.quantity) - float64(stock.intakes)*perDose
+		daysLeft := math.Max(remaining, 0) / (perDose * float64(sch.Duration))
+		if daysLeft < lowSupplyDays {
+			supplies = append(supplies, lowSupply{medicine: sch.Medicine, daysLeft: math.Floor(daysLeft)})
+		}
+	}
+
+	return supplies, nil
+}
+
+func (srv *Server) RunCaregiverSummaryJob(ctx context.Context) {
+	ticker := time.NewTicker(caregiverSummaryJobInterval)
+	defer ticker.Stop()
+
+	for {
+		if !srv.inMaintenance() {
+			err := srv.exclusively(drainable(ctx), "caregiver-summary", func(ctx context.Context) error {
+				sent, err := srv.SendCaregiverSummaries(ctx)
+				if sent > 0 {
+					log.Printf("caregiver summary job: %d summaries sent", sent)
+				}
+				return err
+			})
+			if err != nil {
+				log.Printf("caregiver summary job: %v", err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,353 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"kode_test/internal/pii"
+	"kode_test/internal/schedule"
+	"net/http"
+	"strconv"
+)
+
+// changes returned by one sync call, clients keep pulling while has_more is set
+const syncPageSize = 500
+
+type SyncResponse struct {
+	Cursor           string              `json:"cursor"`
+	HasMore          bool                `json:"has_more"`
+	Schedules        []schedule.Schedule `json:"schedules"`
+	DeletedSchedules []int               `json:"deleted_schedules"`
+	Intakes          []Intake            `json:"intakes"`
+	DeletedIntakes   []int               `json:"deleted_intakes"`
+}
+
+type SyncUpload struct {
+	Cursor  string       `json:"cursor"`
+	Changes []SyncChange `json:"changes"`
+}
+
+type SyncChange struct {
+	ClientID string             `json:"client_id"`
+	Entity   string             `json:"entity"`
+	Op       string             `json:"op"`
+	ID       int                `json:"id"`
+	Schedule *schedule.Schedule `json:"schedule"`
+	Intake   *Intake            `json:"intake"`
+}
+
+type SyncResult struct {
+	ClientID string `json:"client_id"`
+	Entity   string `json:"entity"`
+	ID       int    `json:"id"`
+	// applied, conflict or rejected
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+}
+
+func parseCursor(cursor string) (int64, error) {
+	if cursor == "" {
+		return 0, nil
+	}
+
+	return strconv.ParseInt(cursor, 10, 64)
+}
+
+func (srv *Server) latestCursor(ctx context.Context, userID string) (int64, error) {
+	var cursor int64
+	err := srv.db.QueryRow(ctx, "SELECT COALESCE(max(id), 0) FROM change_log WHERE user_id = $1", userID).Scan(&cursor)
+
+	return cursor, err
+}
+
+func (srv *Server) getSyncHandler(w http.ResponseWriter, r *http.Request) error {
+	requiredParams := []string{"user_id"}
+	urlParams := r.URL.Query()
+	missingParamMessage := checkRequiredParams(requiredParams, urlParams)
+	if missingParamMessage != "" {
+		return schedule.Errorf(schedule.ErrValidation, "%s", missingParamMessage)
+	}
+
+	since, err := parseCursor(urlParams.Get("since"))
+	if err != nil {
+		return schedule.Errorf(schedule.ErrValidation, "invalid since cursor")
+	}
+
+	userID := urlParams.Get("user_id")
+	response := SyncResponse{Schedules: []schedule.Schedule{}, DeletedSchedules: []int{}, Intakes: []Intake{}, DeletedIntakes: []int{}}
+	ctx := context.Background()
+
+	var scheduleIDs, intakeIDs []int
+	if since == 0 {
+		// first sync sends the full state, the cursor is taken first so nothing written meanwhile is lost
+		cursor, err := srv.latestCursor(ctx, userID)
+		if err != nil {
+			return fmt.Errorf("failed get sync cursor: %w", err)
+		}
+		response.Cursor = strconv.FormatInt(cursor, 10)
+	} else {
+		scheduleIDs, intakeIDs, err = srv.collectChanges(ctx, userID, since, &response)
+		if err != nil {
+			return fmt.Errorf("failed get changes from database: %w", err)
+		}
+	}
+
+	if since == 0 || len(scheduleIDs) > 0 {
+		schedules, err := srv.collectSchedules(srv.db.Query(ctx, querySyncSchedules, userID, since == 0, scheduleIDs))
+		if err != nil {
+			return fmt.Errorf("failed get schedules from database: %w", err)
+		}
+		response.Schedules = append(response.Schedules, schedules...)
+	}
+
+	if since == 0 || len(intakeIDs) > 0 {
+		query := "SELECT " + intakeColumns + " FROM intake_log WHERE user_id = $1 AND ($2 OR id = ANY($3))"
+		rows, err := srv.db.Query(ctx, query, userID, since == 0, intakeIDs)
+		if err != nil {
+			return fmt.Errorf("failed get intakes from database: %w", err)
+		}
+		response.Intakes, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) (Intake, error) {
+			var i Intake
+			err := scanIntake(row, &i)
+			return i, err
+		})
+		if err != nil {
+			return fmt.Errorf("failed get intake: %w", err)
+		}
+	}
+
+	fmt.Fprint(w, convertToJson(response))
+	return nil
+}
+
+// reads one page of the change log, only the last change of every record counts
+func (srv *Server) collectChanges(ctx context.Context, userID string, since int64, response *SyncResponse) ([]int, []int, error) {
+	query := "SELECT id, entity, entity_id, op FROM change_log WHERE user_id = $1 AND id > $2 ORDER BY id LIMIT $3"
+	rows, err := srv.db.Query(ctx, query, userID, since, syncPageSize+1)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	type key struct {
+		entity string
+		id     int
+	}
+	last := map[key]string{}
+	var order []key
+	cursor := since
+	count := 0
+	for rows.Next() {
+		count++
+		if count > syncPageSize {
+			response.HasMore = true
+			break
+		}
+
+		var k key
+		var op string
+		err := rows.Scan(&cursor, &k.entity, &k.id, &op)
+		if err != nil {
+			return nil, nil, err
+		}
+		if _, ok := last[k]; !ok {
+			order = append(order, k)
+		}
+		last[k] = op
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+	response.Cursor = strconv.FormatInt(cursor, 10)
+
+	var scheduleIDs, intakeIDs []int
+	for _, k := range order {
+		deleted := last[k] == "delete"
+		switch {
+		case k.entity == "schedule" && deleted:
+			response.DeletedSchedules = append(response.DeletedSchedules, k.id)
+		case k.entity == "schedule":
+			scheduleIDs = append(scheduleIDs, k.id)
+		case k.entity == "intake" && deleted:
+			response.DeletedIntakes = append(response.DeletedIntakes, k.id)
+		case k.entity == "intake":
+			intakeIDs = append(intakeIDs, k.id)
+		}
+	}
+
+	return scheduleIDs, intakeIDs, nil
+}
+
+// applies a batch of offline changes in one transaction with a result per change.
+// A change to a record that was modified on the server after the client's cursor is a conflict.
+func (srv *Server) uploadSyncHandler(w http.ResponseWriter, r *http.Request) error {
+	var upload SyncUpload
+	err := json.NewDecoder(r.Body).Decode(&upload)
+	if err != nil {
+		return schedule.Errorf(schedule.ErrValidation, "invalid sync format")
+	}
+
+	userID := r.URL.Query().Get("user_id")
+	if userID == "" {
+		return schedule.Errorf(schedule.ErrValidation, "missing required parameter: user_id")
+	}
+
+	cursor, err := parseCursor(upload.Cursor)
+	if err != nil {
+		return schedule.Errorf(schedule.ErrValidation, "invalid cursor")
+	}
+
+	ctx := context.Background()
+	results := make([]SyncResult, 0, len(upload.Changes))
+	err = srv.db.InTx(ctx, func(tx pgx.Tx) error {
+		touched := map[string]bool{}
+		for _, change := range upload.Changes {
+			result := SyncResult{ClientID: change.ClientID, Entity: change.Entity, ID: change.ID, Status: "applied"}
+
+			savepoint, err := tx.Begin(ctx)
+			if err != nil {
+				return err
+			}
+
+			key := fmt.Sprintf("%s:%d", change.Entity, change.ID)
+			if change.Op != "create" && !touched[key] {
+				var conflict bool
+				query := "SELECT EXISTS (SELECT 1 FROM change_log WHERE entity = $1 AND entity_id = $2 AND id > $3)"
+				err = savepoint.QueryRow(ctx, query, change.Entity, change.ID, cursor).Scan(&conflict)
+				if err == nil && conflict {
+					err = errSyncConflict
+				}
+			}
+			if err == nil {
+				result.ID, err = srv.applySyncChange(ctx, savepoint, userID, change)
+			}
+
+			switch {
+			case err == nil:
+				err = savepoint.Commit(ctx)
+				touched[fmt.Sprintf("%s:%d", change.Entity, result.ID)] = true
+			case errors.Is(err, errSyncConflict):
+				result.Status, result.Message = "conflict", "changed on the server after the cursor, pull and retry"
+				err = savepoint.Rollback(ctx)
+			default:
+				result.Status, result.Message = "rejected", pii.Redact(err.Error())
+				err = savepoint.Rollback(ctx)
+			}
+			if err != nil {
+				return err
+			}
+
+			results = append(results, result)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed apply changes: %w", err)
+	}
+
+	latest, err := srv.latestCursor(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("failed get sync cursor: %w", err)
+	}
+
+	fmt.Fprint(w, convertToJson(map[string]interface{}{
+		"cursor":  strconv.FormatInt(latest, 10),
+		"results": results,
+	}))
+	return nil
+}
+
+var errSyncConflict = errors.New("sync conflict")
+
+var errSyncNotFound = errors.New("record not found")
+
+func (srv *Server) applySyncChange(ctx context.Context, tx pgx.Tx, userID string, change SyncChange) (int, error) {
+	id := change.ID
+
+	switch {
+	case change.Entity == "schedule" && (change.Op == "create" || change.Op == "update"):
+		if change.Schedule == nil {
+			return id, errors.New("schedule is required")
+		}
+		s := *change.Schedule
+		s.UserID = userID
+		if change.Op == "update" {
+			s.ID = id
+		}
+		if s.Status == "" {
+			s.Status = "active"
+		}
+		if !schedule.ValidStatus(s.Status) || s.Medicine == "" || s.Duration < 1 || s.Frequency < 0 {
+			return id, errors.New("invalid schedule")
+		}
+		issues, err := srv.checkScheduleSafety(s)
+		if err != nil {
+			return id, err
+		}
+		for _, issue := range issues {
+			if issue.Severity == "error" {
+				return id, errors.New(issue.Message)
+			}
+		}
+		medicine, hash, err := srv.cipher.SealMedicine(s.Medicine)
+		if err != nil {
+			return id, err
+		}
+
+		if change.Op == "create" {
+			if s.Status == "active" {
+				err = lockScheduleQuota(ctx, tx, userID, 1)
+				if err != nil {
+					return id, err
+				}
+			}
+
+			query := "INSERT INTO schedule (medicine, medicine_hash, frequency, duration, user_id, status) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id"
+			err = tx.QueryRow(ctx, query, medicine, hash, s.Frequency, s.Duration, s.UserID, s.Status).Scan(&id)
+			return id, err
+		}
+		if s.Status == "active" {
+			err = lockActivation(ctx, tx, userID, []int{id})
+			if err != nil {
+				return id, err
+			}
+		}
+		query := "UPDATE schedule SET medicine = $1, medicine_hash = $2, frequency = $3, duration = $4, status = $5 WHERE id = $6 AND user_id = $7"
+		return id, execOne(ctx, tx, query, medicine, hash, s.Frequency, s.Duration, s.Status, id, userID)
+
+	case change.Entity == "schedule" && change.Op == "delete":
+		return id, execOne(ctx, tx, "DELETE FROM schedule WHERE id = $1 AND user_id = $2", id, userID)
+
+	case change.Entity == "intake" && change.Op == "create":
+		if change.Intake == nil || change.Intake.TakenAt.IsZero() {
+			return id, errors.New("intake with taken_at is required")
+		}
+		query := `INSERT INTO intake_log (schedule_id, user_id, taken_at)
+			SELECT id, user_id, $3 FROM schedule WHERE id = $1 AND user_id = $2 RETURNING id`
+		err := tx.QueryRow(ctx, query, change.Intake.ScheduleID, userID, change.Intake.TakenAt).Scan(&id)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return id, errors.New("schedule not found")
+		}
+		return id, err
+
+	case change.Entity == "intake" && change.Op == "delete":
+		return id, execOne(ctx, tx, "DELETE FROM intake_log WHERE id = $1 AND user_id = $2", id, userID)
+	}
+
+	return id, fmt.Errorf("unsupported change %s %s", change.Op, change.Entity)
+}
+
+func execOne(ctx context.Context, tx pgx.Tx, query string, args ...interface{}) error {
+	tag, err := tx.Exec(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return errSyncNotFound
+	}
+
+	return nil
+}
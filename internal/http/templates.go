@@ -0,0 +1,227 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"kode_test/internal/schedule"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type ScheduleTemplate struct {
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	Medicine  string    `json:"medicine"`
+	Frequency int       `json:"frequency"`
+	Duration  int       `json:"duration"`
+	UserID    string    `json:"user_id,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (srv *Server) getTemplatesHandler(w http.ResponseWriter, r *http.Request) error {
+	requiredParams := []string{"user_id"}
+	urlParams := r.URL.Query()
+	missingParamMessage := checkRequiredParams(requiredParams, urlParams)
+	if missingParamMessage != "" {
+		return schedule.Errorf(schedule.ErrValidation, "%s", missingParamMessage)
+	}
+
+	updatedSince, err := parseUpdatedSince(urlParams)
+	if err != nil {
+		return err
+	}
+
+	userID := urlParams.Get("user_id")
+	query := "SELECT id, name, medicine, frequency, duration, COALESCE(user_id, ''), created_at, updated_at FROM schedule_template WHERE (user_id IS NULL OR user_id = $1) AND updated_at > $2 ORDER BY name"
+	rows, err := srv.db.Query(context.Background(), query, userID, updatedSince)
+	if err != nil {
+		return fmt.Errorf("failed get templates from database: %w", err)
+	}
+	defer rows.Close()
+
+	templates := []ScheduleTemplate{}
+	for rows.Next() {
+		var template ScheduleTemplate
+		err := rows.Scan(&template.ID, &template.Name, &template.Medicine, &template.Frequency, &template.Duration, &template.UserID, &template.CreatedAt, &template.UpdatedAt)
+		if err != nil {
+			return fmt.Errorf("failed get template: %w", err)
+		}
+		templates = append(templates, template)
+	}
+
+	writeList(w, r, templates)
+	return nil
+}
+
+func (srv *Server) createTemplateHandler(w http.ResponseWriter, r *http.Request) error {
+	var template ScheduleTemplate
+	err := json.NewDecoder(r.Body).Decode(&template)
+	if err != nil {
+		return schedule.Errorf(schedule.ErrValidation, "invalid template format")
+	}
+	if userID := currentUserID(r); userID != "" {
+		template.UserID = userID
+	}
+
+	if template.UserID == "" {
+		return schedule.Errorf(schedule.ErrValidation, "missing required field: user_id")
+	}
+
+	return srv.saveTemplate(w, template)
+}
+
+func (srv *Server) createSharedTemplateHandler(w http.ResponseWriter, r *http.Request) error {
+	var template ScheduleTemplate
+	err := json.NewDecoder(r.Body).Decode(&template)
+	if err != nil {
+		return schedule.Errorf(schedule.ErrValidation, "invalid template format")
+	}
+
+	template.UserID = ""
+	return srv.saveTemplate(w, template)
+}
+
+func (srv *Server) saveTemplate(w http.ResponseWriter, template ScheduleTemplate) error {
+	err := validateTemplate(template)
+	if err != nil {
+		return err
+	}
+
+	var templateID int
+	query := `INSERT INTO schedule_template (name, medicine, frequency, duration, user_id) VALUES ($1, $2, $3, $4, NULLIF($5, '')) RETURNING id`
+	err = srv.db.QueryRow(context.Background(), query, template.Name, template.Medicine, template.Frequency, template.Duration, template.UserID).Scan(&templateID)
+	if err != nil {
+		return fmt.Errorf("error adding data to database: %w", err)
+	}
+
+	fmt.Fprintf(w, "template saved with ID: %d\n", templateID)
+	return nil
+}
+
+func (srv *Server) updateSharedTemplateHandler(w http.ResponseWriter, r *http.Request) error {
+	var template ScheduleTemplate
+	err := json.NewDecoder(r.Body).Decode(&template)
+	if err != nil {
+		return schedule.Errorf(schedule.ErrValidation, "invalid template format")
+	}
+
+	err = validateTemplate(template)
+	if err != nil {
+		return err
+	}
+
+	query := "UPDATE schedule_template SET name = $1, medicine = $2, frequency = $3, duration = $4 WHERE id = $5 AND user_id IS NULL"
+	tag, err := srv.db.Exec(context.Background(), query, template.Name, template.Medicine, template.Frequency, template.Duration, r.PathValue("id"))
+	if err != nil {
+		return fmt.Errorf("failed update template in database: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return schedule.Errorf(schedule.ErrNotFound, "template not found")
+	}
+
+	fmt.Fprintf(w, "update template success")
+	return nil
+}
+
+func (srv *Server) deleteSharedTemplateHandler(w http.ResponseWriter, r *http.Request) error {
+	query := "DELETE FROM schedule_template WHERE id = $1 AND user_id IS NULL"
+	tag, err := srv.db.Exec(context.Background(), query, r.PathValue("id"))
+	if err != nil {
+		return fmt.Errorf("failed delete template from database: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return schedule.Errorf(schedule.ErrNotFound, "template not found")
+	}
+
+	fmt.Fprintf(w, "delete template from database success")
+	return nil
+}
+
+// creates a schedule for the user from a shared template or one of their own
+func (srv *Server) createScheduleFromTemplateHandler(w http.ResponseWriter, r *http.Request) error {
+	var body struct {
+		UserID string `json:"user_id"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		return schedule.Errorf(schedule.ErrValidation, "invalid request format")
+	}
+	if userID := currentUserID(r); userID != "" {
+		body.UserID = userID
+	}
+	if body.UserID == "" {
+		return schedule.Errorf(schedule.ErrValidation, "invalid request format, user_id is required")
+	}
+
+	var template ScheduleTemplate
+	query := "SELECT medicine, frequency, duration FROM schedule_template WHERE id = $1 AND (user_id IS NULL OR user_id = $2)"
+	err = srv.db.QueryRow(context.Background(), query, r.PathValue("id"), body.UserID).Scan(&template.Medicine, &template.Frequency, &template.Duration)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return schedule.Errorf(schedule.ErrNotFound, "template not found")
+	}
+	if err != nil {
+		return fmt.Errorf("failed get template from database: %w", err)
+	}
+
+	s := schedule.Schedule{Medicine: template.Medicine, Frequency: template.Frequency, Duration: template.Duration, UserID: body.UserID}
+	issues, err := srv.checkScheduleSafety(s)
+	err = checkSafety(issues, err)
+	if err != nil {
+		return err
+	}
+
+	err = srv.checkOverlap(r, s, time.Now())
+	if err != nil {
+		return err
+	}
+
+	medicine, hash, err := srv.cipher.SealMedicine(s.Medicine)
+	if err != nil {
+		return fmt.Errorf("failed encrypt schedule: %w", err)
+	}
+
+	var scheduleID int
+	ctx := context.Background()
+	err = srv.db.InTx(ctx, func(tx pgx.Tx) error {
+		err := lockScheduleQuota(ctx, tx, s.UserID, 1)
+		if err != nil {
+			return err
+		}
+
+		query := `INSERT INTO schedule (medicine, medicine_hash, frequency, duration, user_id) VALUES ($1, $2, $3, $4, $5) RETURNING id`
+		return tx.QueryRow(ctx, query, medicine, hash, s.Frequency, s.Duration, s.UserID).Scan(&scheduleID)
+	})
+	var domainErr *schedule.Error
+	if errors.As(err, &domainErr) {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("error adding data to database: %w", err)
+	}
+
+	writeSaved(w, "schedule", scheduleID, issues)
+	return nil
+}
+
+func validateTemplate(template ScheduleTemplate) error {
+	var errs []error
+	if strings.TrimSpace(template.Name) == "" {
+		errs = append(errs, schedule.FieldErrorf("name", "required", "missing required field: name"))
+	}
+	if strings.TrimSpace(template.Medicine) == "" {
+		errs = append(errs, schedule.FieldErrorf("medicine", "required", "missing required field: medicine"))
+	}
+	if template.Frequency < 0 {
+		errs = append(errs, schedule.FieldErrorf("frequency", "min", "frequency can not be negative"))
+	}
+	if template.Duration < 1 {
+		errs = append(errs, schedule.FieldErrorf("duration", "min", "duration must be positive"))
+	}
+
+	return schedule.JoinFields(errs...)
+}
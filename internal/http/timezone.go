@@ -0,0 +1,33 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"kode_test/internal/schedule"
+	"net/http"
+	"time"
+)
+
+// the timezone doses are planned in: the tz parameter, the user's saved timezone or UTC.
+// Times are stored in UTC and only converted here at the edge.
+func (srv *Server) userLocation(r *http.Request, userID string) (*time.Location, error) {
+	name := r.URL.Query().Get("tz")
+	if name == "" {
+		err := srv.db.QueryRow(context.Background(), "SELECT timezone FROM users WHERE id = $1", userID).Scan(&name)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return time.UTC, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed get user timezone: %w", err)
+		}
+	}
+
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, schedule.FieldErrorf("tz", "format", "invalid timezone: %s", name)
+	}
+
+	return loc, nil
+}
@@ -0,0 +1,44 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestUserLocationParameter(t *testing.T) {
+	// with the tz parameter the saved timezone is not looked up
+	srv := &Server{}
+
+	loc, err := srv.userLocation(httptest.NewRequest(http.MethodGet, "/schedules?tz=Asia/Tokyo", nil), "user")
+	if err != nil || loc.String() != "Asia/Tokyo" {
+		t.Errorf("tz=Asia/Tokyo = %v, %v", loc, err)
+	}
+
+	_, err = srv.userLocation(httptest.NewRequest(http.MethodGet, "/schedules?tz=Mars/Olympus", nil), "user")
+	if err == nil || errorStatus(err) != http.StatusBadRequest || !strings.Contains(err.Error(), "invalid timezone") {
+		t.Errorf("tz=Mars/Olympus = %v", err)
+	}
+}
+
+func TestValidateSignupTimezone(t *testing.T) {
+	tests := []struct {
+		timezone string
+		want     string
+		wantErr  string
+	}{
+		{"", "UTC", ""},
+		{"Europe/Berlin", "Europe/Berlin", ""},
+		{"Mars/Olympus", "Mars/Olympus", "invalid timezone: Mars/Olympus"},
+	}
+	for _, test := range tests {
+		credentials := Credentials{Email: " Ada@Example.com", Password: "long enough", Timezone: test.timezone}
+		if got := ValidateSignup(&credentials); got != test.wantErr {
+			t.Errorf("timezone %q: ValidateSignup = %q, want %q", test.timezone, got, test.wantErr)
+		}
+		if credentials.Timezone != test.want || credentials.Email != "ada@example.com" {
+			t.Errorf("timezone %q: normalized to %q %q", test.timezone, credentials.Email, credentials.Timezone)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"kode_test/internal/schedule"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// API tokens are told apart from access tokens by this prefix
+const apiTokenPrefix = "sch_"
+
+var apiTokenScopes = []string{
+	"read:schedules", "write:schedules",
+	"read:intakes", "write:intakes",
+	"read:regimens", "write:regimens",
+	"read:templates", "write:templates",
+	"read:recalls",
+	"read:sync", "write:sync",
+}
+
+type APIToken struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// the scopes of an API token or OAuth client, at least one and each a known one
+func validScopes(scopes []string) error {
+	if len(scopes) == 0 {
+		return schedule.FieldErrorf("scopes", "required", "scopes are required")
+	}
+	var errs []error
+	for i, scope := range scopes {
+		if !slices.Contains(apiTokenScopes, scope) {
+			errs = append(errs, schedule.FieldErrorf(fmt.Sprintf("scopes[%d]", i), "enum", "unknown scope: %s", scope))
+		}
+	}
+
+	return schedule.JoinFields(errs...)
+}
+
+func (srv *Server) createAPITokenHandler(w http.ResponseWriter, r *http.Request) error {
+	var apiToken APIToken
+	err := json.NewDecoder(r.Body).Decode(&apiToken)
+	if err != nil {
+		return schedule.Errorf(schedule.ErrValidation, "invalid token format")
+	}
+
+	var errs []error
+	if strings.TrimSpace(apiToken.Name) == "" {
+		errs = append(errs, schedule.FieldErrorf("name", "required", "name is required"))
+	}
+	errs = append(errs, validScopes(apiToken.Scopes))
+	if apiToken.ExpiresAt != nil && apiToken.ExpiresAt.Before(time.Now()) {
+		errs = append(errs, schedule.FieldErrorf("expires_at", "min", "expires_at must be in the future"))
+	}
+	err = schedule.JoinFields(errs...)
+	if err != nil {
+		return err
+	}
+
+	apiToken.ID = randomHex(8)
+	secret := apiTokenPrefix + randomHex(32)
+	query := `INSERT INTO api_token (id, user_id, name, token_hash, scopes, expires_at) VALUES ($1, $2, $3, $4, $5, $6) RETURNING created_at`
+	err = srv.db.QueryRow(context.Background(), query, apiToken.ID, currentUserID(r), apiToken.Name, hashToken(secret), apiToken.Scopes, apiToken.ExpiresAt).Scan(&apiToken.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("error adding data to database: %w", err)
+	}
+
+	// the secret is shown only once
+	w.WriteHeader(http.StatusCreated)
+	fmt.Fprint(w, convertToJson(struct {
+		APIToken
+		Token string `json:"token"`
+	}{apiToken, secret}))
+	return nil
+}
+
+func (srv *Server) getAPITokensHandler(w http.ResponseWriter, r *http.Request) error {
+	query := `SELECT id, name, scopes, expires_at, last_used_at, created_at FROM api_token
+		WHERE user_id = $1 AND revoked_at IS NULL ORDER BY created_at`
+	rows, err := srv.db.Query(context.Background(), query, currentUserID(r))
+	if err != nil {
+		return fmt.Errorf("failed get tokens from database: %w", err)
+	}
+	defer rows.Close()
+
+	apiTokens := []APIToken{}
+	for rows.Next() {
+		var apiToken APIToken
+		err := rows.Scan(&apiToken.ID, &apiToken.Name, &apiToken.Scopes, &apiToken.ExpiresAt, &apiToken.LastUsedAt, &apiToken.CreatedAt)
+		if err != nil {
+			return fmt.Errorf("failed get token: %w", err)
+		}
+		apiTokens = append(apiTokens, apiToken)
+	}
+
+	fmt.Fprint(w, convertToJson(apiTokens))
+	return nil
+}
+
+func (srv *Server) deleteAPITokenHandler(w http.ResponseWriter, r *http.Request) error {
+	query := "UPDATE api_token SET revoked_at = now() WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL"
+	tag, err := srv.db.Exec(context.Background(), query, r.PathValue("id"), currentUserID(r))
+	if err != nil {
+		return fmt.Errorf("failed revoke token: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return schedule.Errorf(schedule.ErrNotFound, "token not found")
+	}
+
+	fmt.Fprintf(w, "token revoked")
+	return nil
+}
+
+// the user, ID and scopes of the token
+func (srv *Server) lookupAPIToken(secret string) (string, string, []string, error) {
+	var userID, tokenID string
+	var scopes []string
+	query := `UPDATE api_token SET last_used_at = now()
+		WHERE token_hash = $1 AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > now())
+		RETURNING user_id, id, scopes`
+	if srv.inMaintenance() {
+		// reads write nothing in maintenance, last_used_at is only a hint and moves on the next use
+		query = `SELECT user_id, id, scopes FROM api_token
+			WHERE token_hash = $1 AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > now())`
+	}
+	err := srv.db.QueryRow(context.Background(), query, hashToken(secret)).Scan(&userID, &tokenID, &scopes)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", "", nil, schedule.Errorf(schedule.ErrUnauthorized, "invalid or expired api token")
+	}
+
+	return userID, tokenID, scopes, err
+}
+
+// LEGACY_USER_ID_PARAM=true lets requests without credentials name their user with the user_id
+// parameter, as clients did before tokens existed. It is off by default, anyone could act as any user.
+func legacyUserIDParam() bool {
+	return os.Getenv("LEGACY_USER_ID_PARAM") == "true"
+}
+
+// protects a data endpoint with the read or write scope of the resource.
+// Requests are limited to the user of their token, requests without credentials
+// are only let in as the local user of local mode or with LEGACY_USER_ID_PARAM.
+func (srv *Server) scoped(resource string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		scope := "write:" + resource
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			scope = "read:" + resource
+		}
+
+		srv.requireScope(scope, next)(w, r)
+	}
+}
+
+func (srv *Server) requireScope(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		credential, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			if localRequest(r) || legacyUserIDParam() {
+				next(w, r)
+				return
+			}
+			writeError(w, r, schedule.Errorf(schedule.ErrUnauthorized, "missing bearer token"))
+			return
+		}
+
+		var userID, tokenID string
+		var scopes []string
+		if strings.HasPrefix(credential, apiTokenPrefix) {
+			var err error
+			userID, tokenID, scopes, err = srv.lookupAPIToken(credential)
+			if err != nil {
+				writeError(w, r, err)
+				return
+			}
+			recordCaller(r.Context(), userID, tokenID)
+			if !slices.Contains(scopes, scope) {
+				writeError(w, r, schedule.Errorf(schedule.ErrForbidden, "token lacks scope %s", scope))
+				return
+			}
+		} else {
+			claims, err := parseAccessToken(credential)
+			if err != nil {
+				writeError(w, r, err)
+				return
+			}
+			userID = claims.Subject
+			recordCaller(r.Context(), userID, "")
+		}
+
+		r = r.Clone(context.WithValue(r.Context(), userIDKey, userID))
+		urlParams := r.URL.Query()
+		urlParams.Set("user_id", userID)
+		r.URL.RawQuery = urlParams.Encode()
+
+		next(w, r)
+	}
+}
@@ -0,0 +1,290 @@
+package http
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"kode_test/internal/schedule"
+	"math"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// RFC 6238 parameters understood by every authenticator app
+const (
+	totpPeriod        = 30
+	totpDigits        = 6
+	totpSkew          = 1
+	totpIssuer        = "Scheduler"
+	recoveryCodeCount = 10
+	// wrong codes in a row before the second factor is locked, and for how long
+	totpMaxFailures = 5
+	totpLockout     = 15 * time.Minute
+	// RFC 4226 asks for at least 128 bits, enrollment generates 160
+	totpMinSecretBytes = 16
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+func totpCode(secret []byte, step int64) string {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(step))
+
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%0*d", totpDigits, value%uint32(math.Pow10(totpDigits)))
+}
+
+// returns the matched time step, codes at or before lastStep are rejected as replays
+func validateTOTP(encodedSecret string, code string, lastStep int64, now time.Time) (int64, bool) {
+	secret, err := totpEncoding.DecodeString(encodedSecret)
+	// an empty key gives codes anyone can compute, a user who never enrolled has none
+	if err != nil || len(secret) < totpMinSecretBytes || len(code) != totpDigits {
+		return 0, false
+	}
+
+	current := now.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step > lastStep && hmac.Equal([]byte(totpCode(secret, step)), []byte(code)) {
+			return step, true
+		}
+	}
+
+	return 0, false
+}
+
+func (srv *Server) checkTOTP(ctx context.Context, userID string, code string) (bool, error) {
+	var secret string
+	var lastStep int64
+	query := "SELECT COALESCE(totp_secret, ''), totp_last_step FROM users WHERE id = $1"
+	err := srv.db.QueryRow(ctx, query, userID).Scan(&secret, &lastStep)
+	if err != nil {
+		return false, err
+	}
+	secret, err = srv.cipher.Decrypt(secret)
+	if err != nil {
+		return false, err
+	}
+
+	step, ok := validateTOTP(secret, code, lastStep, time.Now())
+	if !ok {
+		return false, nil
+	}
+
+	tag, err := srv.db.Exec(ctx, "UPDATE users SET totp_last_step = $1 WHERE id = $2 AND totp_last_step < $1", step, userID)
+	if err != nil {
+		return false, err
+	}
+
+	return tag.RowsAffected() == 1, nil
+}
+
+func (srv *Server) checkRecoveryCode(ctx context.Context, userID string, recoveryCode string) (bool, error) {
+	query := "UPDATE user_recovery_code SET used_at = now() WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL"
+	tag, err := srv.db.Exec(ctx, query, userID, hashToken(strings.ToLower(strings.TrimSpace(recoveryCode))))
+	if err != nil {
+		return false, err
+	}
+
+	return tag.RowsAffected() == 1, nil
+}
+
+var errSecondFactorLocked = schedule.Errorf(schedule.ErrTooManyAttempts, "too many invalid codes, try again later")
+
+// accepts either a current TOTP code or an unused recovery code. After totpMaxFailures wrong
+// codes in a row the second factor is locked for totpLockout, so the codes can not be guessed.
+func (srv *Server) verifySecondFactor(userID string, otp string, recoveryCode string) (bool, error) {
+	if otp == "" && recoveryCode == "" {
+		return false, nil
+	}
+
+	ctx := context.Background()
+	var locked bool
+	err := srv.db.QueryRow(ctx, "SELECT COALESCE(totp_locked_until > now(), false) FROM users WHERE id = $1", userID).Scan(&locked)
+	if err != nil {
+		return false, err
+	}
+	if locked {
+		return false, errSecondFactorLocked
+	}
+
+	var ok bool
+	if otp != "" {
+		ok, err = srv.checkTOTP(ctx, userID, otp)
+	} else {
+		ok, err = srv.checkRecoveryCode(ctx, userID, recoveryCode)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if ok {
+		_, err = srv.db.Exec(ctx, "UPDATE users SET totp_failures = 0 WHERE id = $1 AND totp_failures > 0", userID)
+		return err == nil, err
+	}
+
+	query := `UPDATE users SET
+			totp_failures = CASE WHEN totp_failures + 1 >= $2 THEN 0 ELSE totp_failures + 1 END,
+			totp_locked_until = CASE WHEN totp_failures + 1 >= $2 THEN now() + make_interval(secs => $3) ELSE totp_locked_until END
+		WHERE id = $1`
+	_, err = srv.db.Exec(ctx, query, userID, totpMaxFailures, totpLockout.Seconds())
+	return false, err
+}
+
+// stores a fresh secret, 2FA is only switched on once a code is verified
+func (srv *Server) enrollTOTPHandler(w http.ResponseWriter, r *http.Request) error {
+	userID := currentUserID(r)
+
+	secretBytes := make([]byte, 20)
+	_, err := rand.Read(secretBytes)
+	if err != nil {
+		return fmt.Errorf("failed generate secret: %w", err)
+	}
+	secret := totpEncoding.EncodeToString(secretBytes)
+	encrypted, err := srv.cipher.Encrypt(secret)
+	if err != nil {
+		return fmt.Errorf("failed encrypt secret: %w", err)
+	}
+
+	var email string
+	query := "UPDATE users SET totp_secret = $1 WHERE id = $2 AND NOT totp_enabled RETURNING email"
+	err = srv.db.QueryRow(context.Background(), query, encrypted, userID).Scan(&email)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return schedule.Errorf(schedule.ErrConflict, "two-factor authentication is already enabled")
+	}
+	if err != nil {
+		return fmt.Errorf("failed save secret: %w", err)
+	}
+
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", totpIssuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", fmt.Sprint(totpDigits))
+	params.Set("period", fmt.Sprint(totpPeriod))
+	uri := "otpauth://totp/" + url.PathEscape(totpIssuer+":"+email) + "?" + params.Encode()
+
+	fmt.Fprint(w, convertToJson(map[string]string{
+		"secret":      secret,
+		"otpauth_uri": uri,
+	}))
+	return nil
+}
+
+func (srv *Server) verifyTOTPHandler(w http.ResponseWriter, r *http.Request) error {
+	userID := currentUserID(r)
+
+	var body struct {
+		OTP string `json:"otp"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		return schedule.Errorf(schedule.ErrValidation, "invalid otp format")
+	}
+
+	var enrolled bool
+	err = srv.db.QueryRow(context.Background(), "SELECT totp_secret IS NOT NULL FROM users WHERE id = $1", userID).Scan(&enrolled)
+	if err != nil {
+		return fmt.Errorf("failed get user from database: %w", err)
+	}
+	if !enrolled {
+		return schedule.Errorf(schedule.ErrConflict, "two-factor authentication is not enrolled, call enroll first")
+	}
+
+	ok, err := srv.verifySecondFactor(userID, body.OTP, "")
+	if errors.Is(err, schedule.ErrTooManyAttempts) {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("failed verify otp: %w", err)
+	}
+	if !ok {
+		return schedule.Errorf(schedule.ErrUnauthorized, "invalid otp")
+	}
+
+	ctx := context.Background()
+	codes := make([]string, recoveryCodeCount)
+	err = srv.db.InTx(ctx, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, "UPDATE users SET totp_enabled = true WHERE id = $1", userID)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(ctx, "DELETE FROM user_recovery_code WHERE user_id = $1", userID)
+		if err != nil {
+			return err
+		}
+
+		for i := range codes {
+			code := randomHex(5)
+			codes[i] = code[:5] + "-" + code[5:]
+			_, err = tx.Exec(ctx, "INSERT INTO user_recovery_code (user_id, code_hash) VALUES ($1, $2)", userID, hashToken(codes[i]))
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed enable two-factor authentication: %w", err)
+	}
+
+	fmt.Fprint(w, convertToJson(map[string][]string{"recovery_codes": codes}))
+	return nil
+}
+
+func (srv *Server) disableTOTPHandler(w http.ResponseWriter, r *http.Request) error {
+	userID := currentUserID(r)
+
+	var body struct {
+		OTP          string `json:"otp"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		return schedule.Errorf(schedule.ErrValidation, "invalid otp format")
+	}
+
+	ok, err := srv.verifySecondFactor(userID, body.OTP, body.RecoveryCode)
+	if errors.Is(err, schedule.ErrTooManyAttempts) {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("failed verify second factor: %w", err)
+	}
+	if !ok {
+		return schedule.Errorf(schedule.ErrUnauthorized, "a valid otp or recovery_code is required")
+	}
+
+	ctx := context.Background()
+	err = srv.db.InTx(ctx, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, "UPDATE users SET totp_enabled = false, totp_secret = NULL WHERE id = $1", userID)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(ctx, "DELETE FROM user_recovery_code WHERE user_id = $1", userID)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed disable two-factor authentication: %w", err)
+	}
+
+	fmt.Fprintf(w, "two-factor authentication disabled")
+	return nil
+}
@@ -0,0 +1,76 @@
+package http
+
+import (
+	"testing"
+	"time"
+)
+
+// the SHA1 secret of RFC 6238 appendix B
+var rfc6238Secret = []byte("12345678901234567890")
+
+func TestTOTPCode(t *testing.T) {
+	// appendix B lists 8 digit codes, the 6 digit code of the same step is their tail
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+	for _, test := range tests {
+		want := test.want[len(test.want)-totpDigits:]
+		if got := totpCode(rfc6238Secret, test.unix/totpPeriod); got != want {
+			t.Errorf("totpCode at %d = %s, want %s", test.unix, got, want)
+		}
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	secret := totpEncoding.EncodeToString(rfc6238Secret)
+	now := time.Unix(1111111111, 0)
+	current := now.Unix() / totpPeriod
+
+	tests := []struct {
+		name     string
+		step     int64
+		lastStep int64
+		wantOK   bool
+	}{
+		{"current step", current, 0, true},
+		{"one step behind", current - 1, 0, true},
+		{"one step ahead", current + 1, 0, true},
+		{"two steps behind", current - 2, 0, false},
+		{"two steps ahead", current + 2, 0, false},
+		{"replayed step", current, current, false},
+		{"step before the last", current - 1, current - 1, false},
+		{"newer than the last", current + 1, current, true},
+	}
+	for _, test := range tests {
+		step, ok := validateTOTP(secret, totpCode(rfc6238Secret, test.step), test.lastStep, now)
+		if ok != test.wantOK {
+			t.Errorf("%s: ok = %v, want %v", test.name, ok, test.wantOK)
+		}
+		if ok && step != test.step {
+			t.Errorf("%s: matched step %d, want %d", test.name, step, test.step)
+		}
+	}
+
+	for _, code := range []string{"", "81804", "0708180", "abcdef"} {
+		if _, ok := validateTOTP(secret, code, 0, now); ok {
+			t.Errorf("code %q accepted", code)
+		}
+	}
+	if _, ok := validateTOTP("not base32!", totpCode(rfc6238Secret, current), 0, now); ok {
+		t.Error("code accepted for a malformed secret")
+	}
+	// a user who never enrolled has an empty secret, its codes are public
+	for _, short := range [][]byte{nil, rfc6238Secret[:totpMinSecretBytes-1]} {
+		if _, ok := validateTOTP(totpEncoding.EncodeToString(short), totpCode(short, current), 0, now); ok {
+			t.Errorf("code accepted for a %d byte secret", len(short))
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"kode_test/internal/schedule"
+	"net/http"
+)
+
+// the trip of the user, nil when none is planned
+func (srv *Server) userTravel(ctx context.Context, userID string) (*schedule.Travel, error) {
+	var travel schedule.Travel
+	err := srv.db.QueryRow(ctx, "SELECT plan FROM travel_plan WHERE user_id = $1", userID).Scan(&travel)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed get travel plan from database: %w", err)
+	}
+
+	return &travel, nil
+}
+
+func (srv *Server) getTravelHandler(w http.ResponseWriter, r *http.Request) error {
+	userID, err := pathUserID(r)
+	if err != nil {
+		return err
+	}
+	travel, err := srv.userTravel(context.Background(), userID)
+	if err != nil {
+		return err
+	}
+	if travel == nil {
+		return schedule.Errorf(schedule.ErrNotFound, "no trip planned")
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, convertToJson(travel))
+	return nil
+}
+
+func (srv *Server) putTravelHandler(w http.ResponseWriter, r *http.Request) error {
+	userID, err := pathUserID(r)
+	if err != nil {
+		return err
+	}
+	var travel schedule.Travel
+	err = json.NewDecoder(r.Body).Decode(&travel)
+	if err != nil {
+		return schedule.Errorf(schedule.ErrValidation, "invalid travel plan format")
+	}
+	err = travel.Validate()
+	if err != nil {
+		return err
+	}
+	if travel.KeepAbsolute == nil {
+		travel.KeepAbsolute = []int{}
+	}
+
+	ctx := context.Background()
+	for _, scheduleID := range travel.KeepAbsolute {
+		_, err = srv.getUserSchedule(ctx, userID, scheduleID)
+		if err != nil {
+			return err
+		}
+	}
+
+	query := `INSERT INTO travel_plan (user_id, plan) VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET plan = EXCLUDED.plan, updated_at = now()`
+	_, err = srv.db.Exec(ctx, query, userID, travel)
+	if err != nil {
+		return fmt.Errorf("failed save travel plan: %w", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, convertToJson(travel))
+	return nil
+}
+
+// ends the trip early, doses are back on home time right away
+func (srv *Server) deleteTravelHandler(w http.ResponseWriter, r *http.Request) error {
+	userID, err := pathUserID(r)
+	if err != nil {
+		return err
+	}
+	_, err = srv.db.Exec(context.Background(), "DELETE FROM travel_plan WHERE user_id = $1", userID)
+	if err != nil {
+		return fmt.Errorf("failed delete travel plan: %w", err)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
@@ -0,0 +1,268 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"kode_test/internal/schedule"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// counts are kept in memory and added to the database this often
+const usageFlushInterval = time.Minute
+
+const callerKey contextKey = "caller"
+
+// who made a request, filled in by the authentication of the route
+type caller struct {
+	userID  string
+	tokenID string
+}
+
+// remembers the user and API token of the request for the usage statistics
+func recordCaller(ctx context.Context, userID string, tokenID string) {
+	if c, ok := ctx.Value(callerKey).(*caller); ok {
+		c.userID, c.tokenID = userID, tokenID
+	}
+}
+
+type usageKey struct {
+	userID  string
+	tokenID string
+	day     string
+}
+
+type usageCount struct {
+	requests int64
+	errors   int64
+}
+
+type usageCounter struct {
+	mu     sync.Mutex
+	counts map[usageKey]*usageCount
+}
+
+func newUsageCounter() *usageCounter {
+	return &usageCounter{counts: map[usageKey]*usageCount{}}
+}
+
+func (c *usageCounter) add(key usageKey, count usageCount) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	current, ok := c.counts[key]
+	if !ok {
+		current = &usageCount{}
+		c.counts[key] = current
+	}
+	current.requests += count.requests
+	current.errors += count.errors
+}
+
+// the counts since the last call
+func (c *usageCounter) take() map[usageKey]*usageCount {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	counts := c.counts
+	c.counts = map[usageKey]*usageCount{}
+	return counts
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusWriter) WriteHeader(status int) {
+	if s.status == 0 {
+		s.status = status
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusWriter) Write(p []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	return s.ResponseWriter.Write(p)
+}
+
+// counts the requests of authenticated callers, anonymous ones are not attributed to anyone
+func (srv *Server) countUsage(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c := &caller{}
+		recorder := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(recorder, r.WithContext(context.WithValue(r.Context(), callerKey, c)))
+		if c.userID == "" {
+			return
+		}
+
+		count := usageCount{requests: 1}
+		if recorder.status >= 400 {
+			count.errors = 1
+		}
+		srv.usage.add(usageKey{userID: c.userID, tokenID: c.tokenID, day: time.Now().UTC().Format("2006-01-02")}, count)
+	})
+}
+
+// adds the counts to the database, they are kept for the next flush when that fails
+// and while in maintenance
+func (srv *Server) FlushUsage(ctx context.Context) error {
+	if srv.inMaintenance() {
+		return nil
+	}
+	counts := srv.usage.take()
+	if len(counts) == 0 {
+		return nil
+	}
+
+	batch := &pgx.Batch{}
+	for key, count := range counts {
+		batch.Queue(`INSERT INTO api_usage (user_id, token_id, day, requests, errors) VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT (user_id, token_id, day) DO UPDATE SET requests = api_usage.requests + EXCLUDED.requests, errors = api_usage.errors + EXCLUDED.errors`,
+			key.userID, key.tokenID, key.day, count.requests, count.errors)
+	}
+	err := srv.db.InTx(ctx, func(tx pgx.Tx) error {
+		return tx.SendBatch(ctx, batch).Close()
+	})
+	if err != nil {
+		for key, count := range counts {
+			srv.usage.add(key, *count)
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (srv *Server) RunUsageJob(ctx context.Context) {
+	ticker := time.NewTicker(usageFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			// counts since the last flush would be lost with the process
+			err := srv.FlushUsage(context.WithoutCancel(ctx))
+			if err != nil {
+				log.Printf("usage job: %v", err)
+			}
+			return
+		case <-ticker.C:
+		}
+
+		err := srv.FlushUsage(ctx)
+		if err != nil {
+			log.Printf("usage job: %v", err)
+		}
+	}
+}
+
+type UsageDay struct {
+	Day       string `json:"day"`
+	TokenID   string `json:"token_id,omitempty"`
+	TokenName string `json:"token_name"`
+	Requests  int64  `json:"requests"`
+	Errors    int64  `json:"errors"`
+}
+
+type UserUsage struct {
+	UserID   string `json:"user_id"`
+	Requests int64  `json:"requests"`
+	Errors   int64  `json:"errors"`
+	Tokens   int    `json:"tokens"`
+	LastDay  string `json:"last_day"`
+}
+
+// from and to are days, the last 30 days up to today by default
+func usagePeriod(r *http.Request) (time.Time, time.Time, error) {
+	to := time.Now().UTC().Truncate(24 * time.Hour)
+	from := to.AddDate(0, 0, -29)
+	for name, day := range map[string]*time.Time{"from": &from, "to": &to} {
+		value := r.URL.Query().Get(name)
+		if value == "" {
+			continue
+		}
+		parsed, err := time.Parse("2006-01-02", value)
+		if err != nil {
+			return from, to, schedule.Errorf(schedule.ErrValidation, "invalid %s, expected YYYY-MM-DD", name)
+		}
+		*day = parsed
+	}
+	if to.Before(from) {
+		return from, to, schedule.Errorf(schedule.ErrValidation, "to is before from")
+	}
+
+	return from, to, nil
+}
+
+// the requests of the user per day and token, counts of the last minute are not in yet
+func (srv *Server) getUsageHandler(w http.ResponseWriter, r *http.Request) error {
+	userID, err := pathUserID(r)
+	if err != nil {
+		return err
+	}
+	from, to, err := usagePeriod(r)
+	if err != nil {
+		return err
+	}
+
+	query := `SELECT to_char(u.day, 'YYYY-MM-DD'), u.token_id, COALESCE(t.name, CASE WHEN u.token_id = '' THEN 'session' ELSE 'deleted token' END), u.requests, u.errors
+		FROM api_usage u LEFT JOIN api_token t ON t.id = u.token_id
+		WHERE u.user_id = $1 AND u.day BETWEEN $2 AND $3
+		ORDER BY u.day, u.token_id`
+	rows, err := srv.db.QueryRead(context.Background(), query, userID, from, to)
+	if err != nil {
+		return fmt.Errorf("failed get usage from database: %w", err)
+	}
+	days, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (UsageDay, error) {
+		var day UsageDay
+		err := row.Scan(&day.Day, &day.TokenID, &day.TokenName, &day.Requests, &day.Errors)
+		return day, err
+	})
+	if err != nil {
+		return fmt.Errorf("failed get usage from database: %w", err)
+	}
+
+	writeList(w, r, days)
+	return nil
+}
+
+// the busiest users of the period, limit=50 by default
+func (srv *Server) getAdminUsageHandler(w http.ResponseWriter, r *http.Request) error {
+	from, to, err := usagePeriod(r)
+	if err != nil {
+		return err
+	}
+	limit := 50
+	if value := r.URL.Query().Get("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 {
+			return schedule.Errorf(schedule.ErrValidation, "invalid limit")
+		}
+	}
+
+	query := `SELECT user_id, sum(requests), sum(errors), count(DISTINCT token_id) FILTER (WHERE token_id <> ''), to_char(max(day), 'YYYY-MM-DD')
+		FROM api_usage WHERE day BETWEEN $1 AND $2
+		GROUP BY user_id ORDER BY sum(requests) DESC LIMIT $3`
+	rows, err := srv.db.QueryRead(context.Background(), query, from, to, limit)
+	if err != nil {
+		return fmt.Errorf("failed get usage from database: %w", err)
+	}
+	users, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (UserUsage, error) {
+		var usage UserUsage
+		err := row.Scan(&usage.UserID, &usage.Requests, &usage.Errors, &usage.Tokens, &usage.LastDay)
+		return usage, err
+	})
+	if err != nil {
+		return fmt.Errorf("failed get usage from database: %w", err)
+	}
+
+	writeList(w, r, users)
+	return nil
+}
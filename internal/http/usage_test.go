@@ -0,0 +1,74 @@
+package http
+
+import (
+	"errors"
+	"kode_test/internal/schedule"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCountUsage(t *testing.T) {
+	srv := &Server{usage: newUsageCounter()}
+	handler := srv.countUsage(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if userID := query.Get("user"); userID != "" {
+			recordCaller(r.Context(), userID, query.Get("token"))
+		}
+		if query.Get("fail") != "" {
+			http.Error(w, "schedule not found", http.StatusNotFound)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+
+	for _, target := range []string{"/?user=ada", "/?user=ada", "/?user=ada&fail=1", "/?user=ada&token=t1", "/", "/?fail=1"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, target, nil))
+	}
+
+	day := time.Now().UTC().Format("2006-01-02")
+	counts := srv.usage.take()
+	want := map[usageKey]usageCount{
+		{userID: "ada", day: day}:                {requests: 3, errors: 1},
+		{userID: "ada", tokenID: "t1", day: day}: {requests: 1},
+	}
+	if len(counts) != len(want) {
+		t.Fatalf("%d usage keys, want %d: %v", len(counts), len(want), counts)
+	}
+	for key, count := range want {
+		if got := counts[key]; got == nil || *got != count {
+			t.Errorf("%+v counted %+v, want %+v", key, got, count)
+		}
+	}
+	if len(srv.usage.take()) != 0 {
+		t.Error("counts are still there after take")
+	}
+}
+
+func TestUsagePeriod(t *testing.T) {
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	tests := []struct {
+		query    string
+		from, to time.Time
+		wantErr  bool
+	}{
+		{"", today.AddDate(0, 0, -29), today, false},
+		{"from=2026-10-01&to=2026-10-16", time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC), time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC), false},
+		{"from=2026-10-16&to=2026-10-16", time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC), time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC), false},
+		{"from=2026-10-17&to=2026-10-16", time.Time{}, time.Time{}, true},
+		{"from=16.10.2026", time.Time{}, time.Time{}, true},
+	}
+	for _, test := range tests {
+		from, to, err := usagePeriod(httptest.NewRequest(http.MethodGet, "/v1/usage?"+test.query, nil))
+		if test.wantErr {
+			if !errors.Is(err, schedule.ErrValidation) {
+				t.Errorf("%q: error = %v, want a validation error", test.query, err)
+			}
+			continue
+		}
+		if err != nil || !from.Equal(test.from) || !to.Equal(test.to) {
+			t.Errorf("%q = %s, %s, %v, want %s, %s", test.query, from, to, err, test.from, test.to)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package http
+
+import (
+	"kode_test/internal/schedule"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// body validators report every invalid field, not only the first
+func TestBodyValidationFields(t *testing.T) {
+	srv := &Server{}
+	tests := []struct {
+		handler errorHandler
+		path    string
+		id      string
+		body    string
+		want    []string
+	}{
+		{srv.createAPITokenHandler, "/v1/tokens", "", `{"scopes": ["read:schedules", "write:everything"], "expires_at": "2020-01-01T00:00:00Z"}`,
+			[]string{"name:required", "scopes[1]:enum", "expires_at:min"}},
+		{srv.createOAuthClientHandler, "/v1/admin/oauth/clients", "", `{"name": "Speaker", "redirect_uris": ["http://example.com/cb"]}`,
+			[]string{"redirect_uris[0]:format", "scopes:required"}},
+		{srv.createRefillHandler, "/v1/schedules/4/refills", "4", `{"filled_on": "16.10.2026", "cost_cents": -1, "currency": "dollars"}`,
+			[]string{"filled_on:format", "cost_cents:min", "currency:format"}},
+		{srv.putPushTargetHandler, "/v1/users/ada/push-target", "ada", `{"provider": "pushover"}`, []string{"provider:enum"}},
+		{srv.createSharedTemplateHandler, "/v1/admin/templates", "", `{"name": " ", "frequency": -1}`,
+			[]string{"name:required", "medicine:required", "frequency:min", "duration:min"}},
+		{srv.bulkSchedulesHandler, "/v1/schedules/bulk", "", `{"operation": "restart", "ids": []}`, []string{"operation:enum", "ids:required"}},
+		{srv.putUserQuotaHandler, "/v1/admin/users/ada/quota", "ada", `{"max_active_schedules": -1}`, []string{"max_active_schedules:min"}},
+		{srv.putSafetyRuleHandler, "/v1/admin/medicines/ibuprofen/safety", "", `{"mg_per_kg_max": 10}`, []string{"mg_per_kg_min:required"}},
+		{srv.putSafetyRuleHandler, "/v1/admin/medicines/ibuprofen/safety", "", `{"mg_per_kg_min": 10, "mg_per_kg_max": 5}`, []string{"mg_per_kg_max:min"}},
+		{srv.putRetentionRuleHandler, "/v1/admin/retention/rules/intakes", "intakes", `{"action": "shred", "max_age_days": 0}`,
+			[]string{"action:enum", "max_age_days:min"}},
+		{srv.putMaintenanceHandler, "/v1/admin/maintenance", "", `{"enabled": true, "retry_after_seconds": -5}`, []string{"retry_after_seconds:min"}},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodPost, test.path, strings.NewReader(test.body))
+		r.SetPathValue("id", test.id)
+		r.SetPathValue("target", test.id)
+		err := test.handler(httptest.NewRecorder(), r)
+		var got []string
+		for _, field := range schedule.Fields(err) {
+			got = append(got, field.Field+":"+field.Rule)
+		}
+		if !slices.Equal(got, test.want) {
+			t.Errorf("%s: fields = %v (%v), want %v", test.path, got, err, test.want)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"kode_test/internal/schedule"
+	"net/http"
+	"time"
+)
+
+type Holiday struct {
+	Day  string `json:"day"`
+	Name string `json:"name"`
+}
+
+func (srv *Server) getScheduleVariantsHandler(w http.ResponseWriter, r *http.Request) error {
+	userID, err := requestUserID(r)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	scheduleID, err := srv.ownScheduleID(ctx, r.PathValue("id"), userID)
+	if err != nil {
+		return err
+	}
+	variants, err := srv.scheduleVariants(ctx, scheduleID)
+	if err != nil {
+		return fmt.Errorf("failed get schedule variants from database: %w", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, convertToJson(variants))
+	return nil
+}
+
+// replaces the weekend and holiday windows of a schedule, leaving both out removes them
+func (srv *Server) putScheduleVariantsHandler(w http.ResponseWriter, r *http.Request) error {
+	var variants schedule.Variants
+	err := json.NewDecoder(r.Body).Decode(&variants)
+	if err != nil {
+		return schedule.Errorf(schedule.ErrValidation, "invalid schedule variants format")
+	}
+	err = variants.Validate()
+	if err != nil {
+		return err
+	}
+	userID, err := requestUserID(r)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	scheduleID, err := srv.ownScheduleID(ctx, r.PathValue("id"), userID)
+	if err != nil {
+		return err
+	}
+	// a shorter window brings the doses closer together
+	s, err := srv.getUserSchedule(ctx, userID, scheduleID)
+	if err != nil {
+		return fmt.Errorf("failed get schedule from database: %w", err)
+	}
+	issues, err := srv.checkScheduleSafetyWith(s, &variants)
+	err = checkSafety(issues, err)
+	if err != nil {
+		return err
+	}
+
+	if variants.Weekend == nil && variants.Holiday == nil {
+		_, err = srv.db.Exec(ctx, "DELETE FROM schedule_variant WHERE schedule_id = $1", scheduleID)
+	} else {
+		query := `INSERT INTO schedule_variant (schedule_id, variants) VALUES ($1, $2)
+			ON CONFLICT (schedule_id) DO UPDATE SET variants = EXCLUDED.variants, updated_at = now()`
+		_, err = srv.db.Exec(ctx, query, scheduleID, variants)
+	}
+	if err != nil {
+		return fmt.Errorf("failed save schedule variants: %w", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, convertToJson(variants))
+	return nil
+}
+
+func (srv *Server) getHolidaysHandler(w http.ResponseWriter, r *http.Request) error {
+	userID, err := pathUserID(r)
+	if err != nil {
+		return err
+	}
+
+	rows, err := srv.db.QueryRead(context.Background(), "SELECT to_char(day, 'YYYY-MM-DD'), name FROM holiday WHERE user_id = $1 ORDER BY day", userID)
+	if err != nil {
+		return fmt.Errorf("failed get holidays from database: %w", err)
+	}
+	holidays, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (Holiday, error) {
+		var holiday Holiday
+		err := row.Scan(&holiday.Day, &holiday.Name)
+		return holiday, err
+	})
+	if err != nil {
+		return fmt.Errorf("failed get holidays from database: %w", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, convertToJson(holidays))
+	return nil
+}
+
+// marks a day as a holiday, the body with its name is optional
+func (srv *Server) putHolidayHandler(w http.ResponseWriter, r *http.Request) error {
+	userID, err := pathUserID(r)
+	if err != nil {
+		return err
+	}
+	holiday := Holiday{Day: r.PathValue("date")}
+	if _, err := time.Parse("2006-01-02", holiday.Day); err != nil {
+		return schedule.Errorf(schedule.ErrValidation, "invalid date, expected YYYY-MM-DD")
+	}
+	if r.ContentLength != 0 {
+		err = json.NewDecoder(r.Body).Decode(&holiday)
+		if err != nil {
+			return schedule.Errorf(schedule.ErrValidation, "invalid holiday format")
+		}
+		holiday.Day = r.PathValue("date")
+	}
+
+	query := `INSERT INTO holiday (user_id, day, name) VALUES ($1, $2, $3)
+		ON CONFLICT (user_id, day) DO UPDATE SET name = EXCLUDED.name`
+	_, err = srv.db.Exec(context.Background(), query, userID, holiday.Day, holiday.Name)
+	if err != nil {
+		return fmt.Errorf("failed save holiday: %w", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, convertToJson(holiday))
+	return nil
+}
+
+func (srv *Server) deleteHolidayHandler(w http.ResponseWriter, r *http.Request) error {
+	userID, err := pathUserID(r)
+	if err != nil {
+		return err
+	}
+	tag, err := srv.db.Exec(context.Background(), "DELETE FROM holiday WHERE user_id = $1 AND day::text = $2", userID, r.PathValue("date"))
+	if err != nil {
+		return fmt.Errorf("failed delete holiday: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return schedule.Errorf(schedule.ErrNotFound, "holiday not found")
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
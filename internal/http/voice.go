@@ -0,0 +1,241 @@
+package http
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"kode_test/internal/schedule"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// a dose counts as open for this long after it was due
+const voiceOverdue = time.Hour
+
+// answers for smart speaker skills, one or two short sentences that read well aloud
+type VoiceResponse struct {
+	Speech string `json:"speech"`
+	// the doses the answer is about, for a card in the companion app
+	DoseIDs []string `json:"dose_ids,omitempty"`
+}
+
+type plannedDose struct {
+	doseID     string
+	scheduleID int
+	slot       int
+	medicine   string
+	at         time.Time
+}
+
+// like requireScope, but a linked account is needed, there is no user_id parameter to fall back to
+func (srv *Server) linked(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") && !localRequest(r) {
+			http.Error(w, "missing bearer token", http.StatusUnauthorized)
+			return
+		}
+
+		srv.requireScope(scope, next)(w, r)
+	}
+}
+
+// the unconfirmed doses of the active schedules from since until the end of tomorrow, by time
+func (srv *Server) openDoses(ctx context.Context, userID string, loc *time.Location, now time.Time, since time.Time) ([]plannedDose, error) {
+	schedules, err := srv.ListUserSchedules(ctx, userID, "active", time.Time{}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed get schedules from database: %w", err)
+	}
+	plan, err := srv.userDayPlan(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var doses []plannedDose
+	var doseIDs []string
+	for _, s := range schedules {
+		for _, t := range []time.Time{now, now.AddDate(0, 0, 1)} {
+			local, doseTimes := plan.doseTimes(s, t, loc)
+			if !schedule.CheckDay(s, local, local.Location()) {
+				continue
+			}
+			day := schedule.LocalDate(local, local.Location())
+			for i, at := range doseTimes {
+				if at.Before(since) {
+					continue
+				}
+				dose := plannedDose{doseID: schedule.DoseID(s.ID, day, i+1), scheduleID: s.ID, slot: i + 1, medicine: s.Medicine, at: at}
+				doses = append(doses, dose)
+				doseIDs = append(doseIDs, dose.doseID)
+			}
+		}
+	}
+
+	rows, err := srv.db.Query(ctx, "SELECT dose_id FROM intake_log WHERE user_id = $1 AND dose_id = ANY($2)", userID, doseIDs)
+	if err != nil {
+		return nil, fmt.Errorf("failed get intakes from database: %w", err)
+	}
+	confirmed := map[string]bool{}
+	for rows.Next() {
+		var doseID string
+		if err := rows.Scan(&doseID); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed get intakes from database: %w", err)
+		}
+		confirmed[doseID] = true
+	}
+	rows.Close()
+
+	doses = slices.DeleteFunc(doses, func(d plannedDose) bool { return confirmed[d.doseID] })
+	slices.SortStableFunc(doses, func(a, b plannedDose) int { return a.at.Compare(b.at) })
+
+	return doses, nil
+}
+
+// "what do I take next"
+func (srv *Server) voiceNextHandler(w http.ResponseWriter, r *http.Request) error {
+	userID := currentUserID(r)
+	loc, err := srv.userLocation(r, userID)
+	if err != nil {
+		return err
+	}
+	now := time.Now().In(loc)
+	doses, err := srv.openDoses(context.Background(), userID, loc, now, now.Add(-voiceOverdue))
+	if err != nil {
+		return err
+	}
+
+	response := VoiceResponse{Speech: "You have no doses coming up."}
+	if len(doses) > 0 {
+		next := slices.DeleteFunc(slices.Clone(doses), func(d plannedDose) bool { return !d.at.Equal(doses[0].at) })
+		var medicines []string
+		for _, dose := range next {
+			medicines = append(medicines, dose.medicine)
+			response.DoseIDs = append(response.DoseIDs, dose.doseID)
+		}
+
+		switch at := next[0].at; {
+		case !at.After(now):
+			response.Speech = fmt.Sprintf("Take %s now, it was due at %s.", spokenList(medicines), spokenTime(at))
+		case schedule.LocalDate(at, at.Location()).After(schedule.LocalDate(now, at.Location())):
+			response.Speech = fmt.Sprintf("Next is %s, tomorrow at %s.", spokenList(medicines), spokenTime(at))
+		default:
+			response.Speech = fmt.Sprintf("Next is %s at %s.", spokenList(medicines), spokenTime(at))
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, convertToJson(response))
+	return nil
+}
+
+// "mark my 2pm dose as taken", time is HH:MM. Without a time the doses that are due now are marked.
+func (srv *Server) voiceTakenHandler(w http.ResponseWriter, r *http.Request) error {
+	var body struct {
+		Time string `json:"time"`
+	}
+	if r.ContentLength != 0 {
+		err := json.NewDecoder(r.Body).Decode(&body)
+		if err != nil {
+			return schedule.Errorf(schedule.ErrValidation, "invalid voice request format")
+		}
+	}
+	var clock time.Time
+	if body.Time != "" {
+		var err error
+		clock, err = time.Parse("15:04", body.Time)
+		if err != nil {
+			return schedule.Errorf(schedule.ErrValidation, "invalid time, expected HH:MM")
+		}
+	}
+
+	userID := currentUserID(r)
+	loc, err := srv.userLocation(r, userID)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	// any dose of today can be named by its time
+	now := time.Now().In(loc)
+	since := now.Add(-voiceOverdue)
+	if body.Time != "" {
+		since = now.AddDate(0, 0, -1)
+	}
+	doses, err := srv.openDoses(ctx, userID, loc, now, since)
+	if err != nil {
+		return err
+	}
+
+	// the doses of today within half an hour of the time, or those already due
+	var matched []plannedDose
+	for _, dose := range doses {
+		if body.Time == "" {
+			if !dose.at.After(now.Add(voiceOverdue / 2)) {
+				matched = append(matched, dose)
+			}
+			continue
+		}
+		asked := time.Date(dose.at.Year(), dose.at.Month(), dose.at.Day(), clock.Hour(), clock.Minute(), 0, 0, dose.at.Location())
+		if !schedule.LocalDate(dose.at, dose.at.Location()).After(schedule.LocalDate(now, dose.at.Location())) && dose.at.Sub(asked).Abs() <= 30*time.Minute {
+			matched = append(matched, dose)
+		}
+	}
+
+	response := VoiceResponse{Speech: "I could not find an open dose right now."}
+	if body.Time != "" {
+		response.Speech = fmt.Sprintf("I could not find an open dose at %s.", spokenTime(clock))
+	}
+
+	var taken, refused []string
+	for _, dose := range matched {
+		intake := Intake{ScheduleID: dose.scheduleID, DoseID: dose.doseID, UserID: userID, TakenAt: time.Now()}
+		_, issues, err := srv.recordIntake(ctx, intake, dose.slot)
+		if errors.Is(err, errUnsafeIntake) {
+			refused = append(refused, fmt.Sprintf("I did not mark %s, %s.", dose.medicine, strings.TrimSuffix(issues[0].Message, ".")))
+			continue
+		}
+		// confirmed by another device in the meantime
+		if errors.Is(err, schedule.ErrConflict) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("error adding data to database: %w", err)
+		}
+		taken = append(taken, dose.medicine)
+		response.DoseIDs = append(response.DoseIDs, dose.doseID)
+	}
+
+	var sentences []string
+	if len(taken) > 0 {
+		sentences = append(sentences, fmt.Sprintf("Got it, %s marked as taken.", spokenList(taken)))
+	}
+	sentences = append(sentences, refused...)
+	if len(sentences) > 0 {
+		response.Speech = strings.Join(sentences, " ")
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, convertToJson(response))
+	return nil
+}
+
+// 2 PM or 2:30 PM
+func spokenTime(t time.Time) string {
+	if t.Minute() == 0 {
+		return t.Format("3 PM")
+	}
+	return t.Format("3:04 PM")
+}
+
+// A, B and C
+func spokenList(items []string) string {
+	items = slices.Compact(slices.SortedFunc(slices.Values(items), cmp.Compare[string]))
+	if len(items) < 2 {
+		return strings.Join(items, "")
+	}
+
+	return strings.Join(items[:len(items)-1], ", ") + " and " + items[len(items)-1]
+}
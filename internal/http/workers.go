@@ -0,0 +1,46 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"github.com/jackc/pgx/v5"
+	"time"
+)
+
+type shutdownKey struct{}
+
+// the context for the work of one job run. It is not cancelled when shutdown starts, so a
+// started delivery is sent and recorded and locks are released on a live connection,
+// the run checks stopped between items to not start anything new.
+func drainable(ctx context.Context) context.Context {
+	return context.WithValue(context.WithoutCancel(ctx), shutdownKey{}, ctx)
+}
+
+// true once shutdown started for a drainable context, or when ctx itself is done
+func stopped(ctx context.Context) bool {
+	if shutdown, ok := ctx.Value(shutdownKey{}).(context.Context); ok {
+		return shutdown.Err() != nil
+	}
+
+	return ctx.Err() != nil
+}
+
+// the time a job finished its work up to, zero when it never ran
+func (srv *Server) loadCheckpoint(ctx context.Context, name string) (time.Time, error) {
+	var at time.Time
+	err := srv.db.QueryRow(ctx, "SELECT at FROM job_checkpoint WHERE name = $1", name).Scan(&at)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return time.Time{}, nil
+	}
+
+	return at, err
+}
+
+// instances running the same job only move the checkpoint forward
+func (srv *Server) saveCheckpoint(ctx context.Context, name string, at time.Time) error {
+	query := `INSERT INTO job_checkpoint (name, at) VALUES ($1, $2)
+		ON CONFLICT (name) DO UPDATE SET at = GREATEST(job_checkpoint.at, excluded.at), updated_at = now()`
+	_, err := srv.db.Exec(ctx, query, name, at)
+
+	return err
+}
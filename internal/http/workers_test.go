@@ -0,0 +1,31 @@
+package http
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestDrainable(t *testing.T) {
+	ctx, shutdown := context.WithCancel(context.Background())
+	work := drainable(ctx)
+	if stopped(work) || stopped(ctx) {
+		t.Fatal("stopped before shutdown")
+	}
+
+	shutdown()
+	// started work keeps a live context, but sees that it should not start more
+	if work.Err() != nil {
+		t.Errorf("work context cancelled with shutdown: %v", work.Err())
+	}
+	if !stopped(work) || !stopped(ctx) {
+		t.Errorf("stopped after shutdown = %v for the work and %v for the job", stopped(work), stopped(ctx))
+	}
+
+	timeout, cancel := context.WithTimeout(context.Background(), time.Nanosecond)
+	defer cancel()
+	<-timeout.Done()
+	if !stopped(timeout) {
+		t.Error("a plain context that is done is not stopped")
+	}
+}
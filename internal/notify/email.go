@@ -0,0 +1,103 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"strings"
+	"time"
+)
+
+// returned by NotifyEmail of notifiers that can not reach email addresses
+var ErrNoEmail = errors.New("email is not configured")
+
+// sends email through an SMTP relay with STARTTLS when the relay offers it
+type SMTP struct {
+	Addr, Username, Password string
+	From                     mail.Address
+}
+
+// the relay configured by SMTP_HOST, nil when email is not configured. SMTP_PORT defaults
+// to 587, SMTP_USERNAME and SMTP_PASSWORD are optional and SMTP_FROM is required.
+func EmailFromEnv() (*SMTP, error) {
+	host := os.Getenv("SMTP_HOST")
+	if host == "" {
+		return nil, nil
+	}
+
+	port := os.Getenv("SMTP_PORT")
+	if port == "" {
+		port = "587"
+	}
+	from, err := mail.ParseAddress(os.Getenv("SMTP_FROM"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid SMTP_FROM: %w", err)
+	}
+
+	return &SMTP{Addr: net.JoinHostPort(host, port), Username: os.Getenv("SMTP_USERNAME"), Password: os.Getenv("SMTP_PASSWORD"), From: *from}, nil
+}
+
+func (s *SMTP) Send(ctx context.Context, address string, subject string, message string) error {
+	to, err := mail.ParseAddress(address)
+	if err != nil {
+		return fmt.Errorf("invalid email address: %w", err)
+	}
+	body, err := emailMessage(s.From, *to, subject, message, time.Now())
+	if err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if s.Username != "" {
+		host, _, _ := net.SplitHostPort(s.Addr)
+		auth = smtp.PlainAuth("", s.Username, s.Password, host)
+	}
+	// net/smtp takes no context, the send runs on and only its result is dropped
+	done := make(chan error, 1)
+	go func() {
+		done <- smtp.SendMail(s.Addr, auth, s.From.Address, []string{to.Address}, body)
+	}()
+	select {
+	case err = <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// a plain text message, the subject is encoded so it can not add headers
+func emailMessage(from mail.Address, to mail.Address, subject string, message string, at time.Time) ([]byte, error) {
+	if strings.ContainsAny(subject, "\r\n") {
+		return nil, errors.New("the subject has a line break")
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", from.String())
+	fmt.Fprintf(&b, "To: %s\r\n", to.String())
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", at.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\nContent-Transfer-Encoding: 8bit\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(strings.ReplaceAll(message, "\r\n", "\n"), "\n", "\r\n"))
+	b.WriteString("\r\n")
+
+	return []byte(b.String()), nil
+}
+
+// sends NotifyEmail through the relay, everything else goes to the wrapped notifier
+type Email struct {
+	Notifier
+	Relay *SMTP
+}
+
+func (e Email) NotifyEmail(ctx context.Context, address string, subject string, message string) error {
+	return e.Relay.Send(ctx, address, subject, message)
+}
+
+func (e Email) CanEmail() bool {
+	return true
+}
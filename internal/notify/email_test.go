@@ -0,0 +1,78 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEmailMessage(t *testing.T) {
+	from := mail.Address{Name: "Scheduler", Address: "noreply@example.com"}
+	to := mail.Address{Address: "carer@example.com"}
+	at := time.Date(2026, 10, 16, 8, 0, 0, 0, time.UTC)
+
+	body, err := emailMessage(from, to, "You have been invited as caregiver", "Open the link:\nhttps://app.example.com/accept-invite?token=abc", at)
+	if err != nil {
+		t.Fatal(err)
+	}
+	message := string(body)
+	for _, want := range []string{"From: \"Scheduler\" <noreply@example.com>\r\n", "To: <carer@example.com>\r\n", "Subject: You have been invited as caregiver\r\n", "\r\n\r\nOpen the link:\r\nhttps://"} {
+		if !strings.Contains(message, want) {
+			t.Errorf("message lacks %q:\n%s", want, message)
+		}
+	}
+
+	_, err = emailMessage(from, to, "Invite\r\nBcc: someone@example.com", "", at)
+	if err == nil {
+		t.Error("a subject with a line break was accepted")
+	}
+}
+
+func TestEmailFromEnv(t *testing.T) {
+	t.Setenv("SMTP_HOST", "")
+	relay, err := EmailFromEnv()
+	if relay != nil || err != nil {
+		t.Errorf("EmailFromEnv without SMTP_HOST = %v, %v", relay, err)
+	}
+
+	t.Setenv("SMTP_HOST", "smtp.example.com")
+	t.Setenv("SMTP_PORT", "")
+	t.Setenv("SMTP_FROM", "")
+	_, err = EmailFromEnv()
+	if err == nil {
+		t.Error("EmailFromEnv without SMTP_FROM did not fail")
+	}
+
+	t.Setenv("SMTP_FROM", "Scheduler <noreply@example.com>")
+	relay, err = EmailFromEnv()
+	if err != nil || relay.Addr != "smtp.example.com:587" || relay.From.Address != "noreply@example.com" {
+		t.Errorf("EmailFromEnv = %+v, %v", relay, err)
+	}
+}
+
+// single use links must never reach the log, the log notifier refuses email
+func TestLogDoesNotEmail(t *testing.T) {
+	var logged bytes.Buffer
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(&logged)
+
+	notifiers := []Notifier{Log{}, XMPP{}, NewMatrix("", "", nil), WithBreakers(Log{}), SelfHostedPush{Notifier: Log{}}}
+	for _, n := range notifiers {
+		err := n.NotifyEmail(context.Background(), "carer@example.com", "Invite", "https://app.example.com/accept-invite?token=secret")
+		if !errors.Is(err, ErrNoEmail) || n.CanEmail() {
+			t.Errorf("%T: NotifyEmail = %v, CanEmail = %v", n, err, n.CanEmail())
+		}
+	}
+	if strings.Contains(logged.String(), "secret") {
+		t.Errorf("token logged: %s", logged.String())
+	}
+
+	if !(Email{Notifier: Log{}, Relay: &SMTP{}}).CanEmail() {
+		t.Error("a notifier with a relay can not email")
+	}
+}
@@ -0,0 +1,125 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// the chat address of a user, a Matrix room or an XMPP address, empty when none is set
+type Directory func(ctx context.Context, userID string) (string, error)
+
+var errNoChatAddress = errors.New("no chat address for the user")
+
+var client = &http.Client{Timeout: 15 * time.Second}
+
+// posts messages as a bot account to the room each user gave as their chat address.
+// The bot joins the room before the first message, so inviting it is all a user does.
+type Matrix struct {
+	Homeserver, AccessToken string
+	Directory               Directory
+
+	mu     sync.Mutex
+	rooms  map[string]string
+	txnSeq atomic.Int64
+}
+
+func NewMatrix(homeserver string, accessToken string, directory Directory) *Matrix {
+	return &Matrix{Homeserver: homeserver, AccessToken: accessToken, Directory: directory, rooms: map[string]string{}}
+}
+
+func (m *Matrix) Notify(ctx context.Context, userID string, subject string, message string) error {
+	_, err := m.NotifyOn(ctx, "", userID, subject, message)
+	return err
+}
+
+// Matrix has no way to reach an email address
+func (m *Matrix) NotifyEmail(ctx context.Context, address string, subject string, message string) error {
+	return ErrNoEmail
+}
+
+func (m *Matrix) CanEmail() bool {
+	return false
+}
+
+// every channel goes to the user's room, the response is the id of the event
+func (m *Matrix) NotifyOn(ctx context.Context, channel string, userID string, subject string, message string) (string, error) {
+	address, err := m.Directory(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+	if address == "" {
+		return "", errNoChatAddress
+	}
+	roomID, err := m.join(ctx, address)
+	if err != nil {
+		return "", err
+	}
+
+	txnID := strconv.FormatInt(time.Now().UnixNano(), 36) + "." + strconv.FormatInt(m.txnSeq.Add(1), 36)
+	path := "/_matrix/client/v3/rooms/" + url.PathEscape(roomID) + "/send/m.room.message/" + txnID
+	var event struct {
+		EventID string `json:"event_id"`
+	}
+	err = m.request(ctx, http.MethodPut, path, map[string]string{"msgtype": "m.text", "body": subject + "\n" + message}, &event)
+
+	return event.EventID, err
+}
+
+// the id of the room behind a room id or alias, joining it once per address
+func (m *Matrix) join(ctx context.Context, address string) (string, error) {
+	m.mu.Lock()
+	roomID, ok := m.rooms[address]
+	m.mu.Unlock()
+	if ok {
+		return roomID, nil
+	}
+
+	var joined struct {
+		RoomID string `json:"room_id"`
+	}
+	err := m.request(ctx, http.MethodPost, "/_matrix/client/v3/join/"+url.PathEscape(address), struct{}{}, &joined)
+	if err != nil {
+		return "", err
+	}
+
+	m.mu.Lock()
+	m.rooms[address] = joined.RoomID
+	m.mu.Unlock()
+
+	return joined.RoomID, nil
+}
+
+func (m *Matrix) request(ctx context.Context, method string, path string, body any, result any) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, m.Homeserver+path, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+m.AccessToken)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("matrix: unexpected status %s: %s", resp.Status, message)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(result)
+}
@@ -0,0 +1,132 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"kode_test/internal/breaker"
+	"kode_test/internal/pii"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// delivers messages to users, the log notifier is used until a real channel is configured
+type Notifier interface {
+	Notify(ctx context.Context, userID string, subject string, message string) error
+	// for links that prove the address, and people without an account yet like invited caregivers.
+	// ErrNoEmail unless CanEmail.
+	NotifyEmail(ctx context.Context, address string, subject string, message string) error
+	CanEmail() bool
+	// dose reminders, over the push, sms or email channel the user chose, voice calls go
+	// through a Caller. The response of the provider, like a message id, is kept as proof of delivery.
+	NotifyOn(ctx context.Context, channel string, userID string, subject string, message string) (string, error)
+}
+
+// the notifier chosen by NOTIFIER: log, the default, matrix or xmpp. The chat notifiers
+// find the room or address of a user through directory.
+func FromEnv(directory Directory) (Notifier, error) {
+	switch notifier := os.Getenv("NOTIFIER"); notifier {
+	case "", "log":
+		return Log{}, nil
+	case "matrix":
+		homeserver, token := os.Getenv("MATRIX_HOMESERVER"), os.Getenv("MATRIX_ACCESS_TOKEN")
+		if homeserver == "" || token == "" {
+			return nil, fmt.Errorf("MATRIX_HOMESERVER and MATRIX_ACCESS_TOKEN are required")
+		}
+		return NewMatrix(strings.TrimSuffix(homeserver, "/"), token, directory), nil
+	case "xmpp":
+		x := XMPP{APIURL: strings.TrimSuffix(os.Getenv("XMPP_API_URL"), "/"), Token: os.Getenv("XMPP_API_TOKEN"), From: os.Getenv("XMPP_FROM"), Directory: directory}
+		if x.APIURL == "" || x.Token == "" || x.From == "" {
+			return nil, fmt.Errorf("XMPP_API_URL, XMPP_API_TOKEN and XMPP_FROM are required")
+		}
+		return x, nil
+	default:
+		return nil, fmt.Errorf("unknown NOTIFIER %q, expected log, matrix or xmpp", notifier)
+	}
+}
+
+// writes messages to the log
+type Log struct{}
+
+func (Log) Notify(ctx context.Context, userID string, subject string, message string) error {
+	// messages name medicines, they are only logged where medicine names may be
+	if !pii.Allowed("medicine") {
+		subject, message = "[redacted]", "[redacted]"
+	}
+	log.Printf("notify %s: %s: %s", pii.MaskUserID(userID), subject, message)
+	return nil
+}
+
+// emails carry single use links, which must not end up in the log
+func (Log) NotifyEmail(ctx context.Context, address string, subject string, message string) error {
+	return ErrNoEmail
+}
+
+func (Log) CanEmail() bool {
+	return false
+}
+
+func (Log) NotifyOn(ctx context.Context, channel string, userID string, subject string, message string) (string, error) {
+	if !pii.Allowed("medicine") {
+		subject, message = "[redacted]", "[redacted]"
+	}
+	log.Printf("notify %s via %s: %s: %s", pii.MaskUserID(userID), channel, subject, message)
+	return "logged", nil
+}
+
+// a notifier that fails fast while the provider behind a channel keeps failing,
+// so one broken provider does not hold up reminders on the other channels
+type Guarded struct {
+	Notifier Notifier
+	breakers map[string]*breaker.Breaker
+}
+
+const (
+	breakerThreshold = 5
+	breakerCooldown  = 30 * time.Second
+	sendTimeout      = 10 * time.Second
+)
+
+func WithBreakers(n Notifier) *Guarded {
+	g := &Guarded{Notifier: n, breakers: map[string]*breaker.Breaker{}}
+	for _, channel := range []string{"default", "push", "sms", "email"} {
+		g.breakers[channel] = breaker.New("notify_"+channel, breakerThreshold, breakerCooldown, sendTimeout)
+	}
+
+	return g
+}
+
+func (g *Guarded) breaker(channel string) *breaker.Breaker {
+	if b, ok := g.breakers[channel]; ok {
+		return b
+	}
+	return g.breakers["default"]
+}
+
+func (g *Guarded) Notify(ctx context.Context, userID string, subject string, message string) error {
+	return g.breaker("default").Do(ctx, func(ctx context.Context) error {
+		return g.Notifier.Notify(ctx, userID, subject, message)
+	})
+}
+
+func (g *Guarded) NotifyEmail(ctx context.Context, address string, subject string, message string) error {
+	return g.breaker("email").Do(ctx, func(ctx context.Context) error {
+		return g.Notifier.NotifyEmail(ctx, address, subject, message)
+	})
+}
+
+func (g *Guarded) CanEmail() bool {
+	return g.Notifier.CanEmail()
+}
+
+func (g *Guarded) NotifyOn(ctx context.Context, channel string, userID string, subject string, message string) (string, error) {
+	var response string
+	err := g.breaker(channel).Do(ctx, func(ctx context.Context) error {
+		var err error
+		response, err = g.Notifier.NotifyOn(ctx, channel, userID, subject, message)
+		return err
+	})
+
+	return response, err
+}
@@ -0,0 +1,110 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// an ntfy topic or a Gotify application a user receives push notifications on
+type PushTarget struct {
+	Provider string
+	Server   string
+	// the ntfy topic, Gotify has none
+	Topic string
+	// an ntfy access token or the Gotify application token
+	Token string
+}
+
+// the push target of a user, nil when the user has none
+type PushTargets func(ctx context.Context, userID string) (*PushTarget, error)
+
+// sends push notifications over ntfy or Gotify to users who set a target,
+// everything else goes to the wrapped notifier
+type SelfHostedPush struct {
+	Notifier
+	Targets PushTargets
+}
+
+func (p SelfHostedPush) Notify(ctx context.Context, userID string, subject string, message string) error {
+	target, err := p.Targets(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if target == nil {
+		return p.Notifier.Notify(ctx, userID, subject, message)
+	}
+
+	_, err = target.send(ctx, subject, message)
+	return err
+}
+
+func (p SelfHostedPush) NotifyOn(ctx context.Context, channel string, userID string, subject string, message string) (string, error) {
+	if channel != "push" {
+		return p.Notifier.NotifyOn(ctx, channel, userID, subject, message)
+	}
+	target, err := p.Targets(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+	if target == nil {
+		return p.Notifier.NotifyOn(ctx, channel, userID, subject, message)
+	}
+
+	return target.send(ctx, subject, message)
+}
+
+// posts the message and returns the id the server gave it
+func (t *PushTarget) send(ctx context.Context, subject string, message string) (string, error) {
+	var req *http.Request
+	var err error
+	switch t.Provider {
+	case "ntfy":
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(t.Server, "/")+"/"+t.Topic, strings.NewReader(message))
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("Title", subject)
+		if t.Token != "" {
+			req.Header.Set("Authorization", "Bearer "+t.Token)
+		}
+	case "gotify":
+		payload, err := json.Marshal(map[string]any{"title": subject, "message": message, "priority": 5})
+		if err != nil {
+			return "", err
+		}
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(t.Server, "/")+"/message", bytes.NewReader(payload))
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Gotify-Key", t.Token)
+	default:
+		return "", fmt.Errorf("unknown push provider %q", t.Provider)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return "", fmt.Errorf("%s: unexpected status %s: %s", t.Provider, resp.Status, body)
+	}
+
+	// ntfy answers with a string id, Gotify with a number
+	var sent struct {
+		ID any `json:"id"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&sent)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", t.Provider, err)
+	}
+
+	return fmt.Sprintf("%s %v", t.Provider, sent.ID), nil
+}
@@ -0,0 +1,98 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"kode_test/internal/breaker"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// places phone calls that fetch what to say from a URL, the way Twilio Voice works.
+// Returns an id of the call.
+type Caller interface {
+	Call(ctx context.Context, to string, instructionsURL string) (string, error)
+}
+
+var twilioClient = &http.Client{Timeout: 15 * time.Second}
+
+type TwilioVoice struct {
+	AccountSID, AuthToken, From string
+}
+
+// the Twilio account configured by TWILIO_ACCOUNT_SID, nil when voice calls are not configured
+func CallerFromEnv() (Caller, error) {
+	sid := os.Getenv("TWILIO_ACCOUNT_SID")
+	if sid == "" {
+		return nil, nil
+	}
+
+	voice := TwilioVoice{AccountSID: sid, AuthToken: os.Getenv("TWILIO_AUTH_TOKEN"), From: os.Getenv("TWILIO_VOICE_FROM")}
+	if voice.AuthToken == "" || voice.From == "" {
+		return nil, fmt.Errorf("TWILIO_AUTH_TOKEN and TWILIO_VOICE_FROM are required")
+	}
+
+	return GuardCaller(voice), nil
+}
+
+func (t TwilioVoice) Call(ctx context.Context, to string, instructionsURL string) (string, error) {
+	form := url.Values{}
+	form.Set("To", to)
+	form.Set("From", t.From)
+	form.Set("Url", instructionsURL)
+	form.Set("Method", http.MethodPost)
+
+	endpoint := "https://api.twilio.com/2010-04-01/Accounts/" + url.PathEscape(t.AccountSID) + "/Calls.json"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(t.AccountSID, t.AuthToken)
+
+	resp, err := twilioClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return "", fmt.Errorf("twilio: unexpected status %s: %s", resp.Status, message)
+	}
+
+	var call struct {
+		SID string `json:"sid"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&call)
+	if err != nil {
+		return "", fmt.Errorf("twilio: %w", err)
+	}
+
+	return call.SID, nil
+}
+
+type guardedCaller struct {
+	caller  Caller
+	breaker *breaker.Breaker
+}
+
+// a caller behind its own circuit breaker, like the channels of WithBreakers
+func GuardCaller(c Caller) Caller {
+	return guardedCaller{caller: c, breaker: breaker.New("notify_voice", breakerThreshold, breakerCooldown, sendTimeout)}
+}
+
+func (g guardedCaller) Call(ctx context.Context, to string, instructionsURL string) (string, error) {
+	var id string
+	err := g.breaker.Do(ctx, func(ctx context.Context) error {
+		var err error
+		id, err = g.caller.Call(ctx, to, instructionsURL)
+		return err
+	})
+
+	return id, err
+}
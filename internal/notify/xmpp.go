@@ -0,0 +1,65 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// sends chat messages through the HTTP API of the XMPP server, ejabberd's send_message,
+// so the service does not keep an XMPP connection of its own
+type XMPP struct {
+	APIURL, Token, From string
+	Directory           Directory
+}
+
+func (x XMPP) Notify(ctx context.Context, userID string, subject string, message string) error {
+	_, err := x.NotifyOn(ctx, "", userID, subject, message)
+	return err
+}
+
+// XMPP has no way to reach an email address
+func (x XMPP) NotifyEmail(ctx context.Context, address string, subject string, message string) error {
+	return ErrNoEmail
+}
+
+func (x XMPP) CanEmail() bool {
+	return false
+}
+
+// every channel goes to the user's XMPP address
+func (x XMPP) NotifyOn(ctx context.Context, channel string, userID string, subject string, message string) (string, error) {
+	address, err := x.Directory(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+	if address == "" {
+		return "", errNoChatAddress
+	}
+
+	payload, err := json.Marshal(map[string]string{"type": "chat", "from": x.From, "to": address, "subject": subject, "body": message})
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, x.APIURL+"/send_message", bytes.NewReader(payload))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+x.Token)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return "", fmt.Errorf("xmpp: unexpected status %s: %s", resp.Status, body)
+	}
+
+	return "sent to " + address, nil
+}
@@ -0,0 +1,257 @@
+// Package objectstore keeps uploaded files in an S3-compatible bucket.
+package objectstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Store interface {
+	Put(ctx context.Context, key string, contentType string, body []byte) error
+	Get(ctx context.Context, key string) ([]byte, error)
+	Head(ctx context.Context, key string) (Object, error)
+	Delete(ctx context.Context, key string) error
+	// signed URLs clients use directly, so file contents never pass through the API
+	UploadURL(key string, contentType string, size int64, expires time.Duration) (string, error)
+	DownloadURL(key string, expires time.Duration) (string, error)
+}
+
+type Object struct {
+	Size        int64
+	ContentType string
+}
+
+var ErrNotFound = errors.New("object not found")
+
+var client = &http.Client{Timeout: 30 * time.Second}
+
+// a bucket addressed path style, which AWS, MinIO and most other S3 implementations accept
+type S3 struct {
+	Endpoint, Region, Bucket, AccessKeyID, SecretKey string
+}
+
+// the bucket configured by OBJECT_STORE_BUCKET, nil when uploads are not configured
+func FromEnv() (Store, error) {
+	bucket := os.Getenv("OBJECT_STORE_BUCKET")
+	if bucket == "" {
+		return nil, nil
+	}
+
+	s3 := &S3{
+		Endpoint:    os.Getenv("OBJECT_STORE_ENDPOINT"),
+		Region:      os.Getenv("OBJECT_STORE_REGION"),
+		Bucket:      bucket,
+		AccessKeyID: os.Getenv("OBJECT_STORE_ACCESS_KEY_ID"),
+		SecretKey:   os.Getenv("OBJECT_STORE_SECRET_ACCESS_KEY"),
+	}
+	if s3.Region == "" {
+		s3.Region = "us-east-1"
+	}
+	if s3.Endpoint == "" {
+		s3.Endpoint = "https://s3." + s3.Region + ".amazonaws.com"
+	}
+	if s3.AccessKeyID == "" || s3.SecretKey == "" {
+		return nil, fmt.Errorf("OBJECT_STORE_ACCESS_KEY_ID and OBJECT_STORE_SECRET_ACCESS_KEY are required")
+	}
+
+	return s3, nil
+}
+
+func (s *S3) Put(ctx context.Context, key string, contentType string, body []byte) error {
+	req, err := s.request(ctx, http.MethodPut, key, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+
+	return s.do(req, body)
+}
+
+func (s *S3) Get(ctx context.Context, key string) ([]byte, error) {
+	req, err := s.request(ctx, http.MethodGet, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	s.sign(req, nil, time.Now().UTC())
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("object store: unexpected status %s", resp.Status)
+	}
+
+	return io.ReadAll(resp.Body)
+}
+
+func (s *S3) Head(ctx context.Context, key string) (Object, error) {
+	req, err := s.request(ctx, http.MethodHead, key, nil)
+	if err != nil {
+		return Object{}, err
+	}
+	s.sign(req, nil, time.Now().UTC())
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return Object{}, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return Object{}, ErrNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return Object{}, fmt.Errorf("object store: unexpected status %s", resp.Status)
+	}
+
+	return Object{Size: resp.ContentLength, ContentType: resp.Header.Get("Content-Type")}, nil
+}
+
+func (s *S3) Delete(ctx context.Context, key string) error {
+	req, err := s.request(ctx, http.MethodDelete, key, nil)
+	if err != nil {
+		return err
+	}
+
+	return s.do(req, nil)
+}
+
+// the upload must have exactly the content type and size that were signed
+func (s *S3) UploadURL(key string, contentType string, size int64, expires time.Duration) (string, error) {
+	return s.presign(http.MethodPut, key, map[string]string{"content-length": strconv.FormatInt(size, 10), "content-type": contentType}, expires, time.Now().UTC())
+}
+
+func (s *S3) DownloadURL(key string, expires time.Duration) (string, error) {
+	return s.presign(http.MethodGet, key, nil, expires, time.Now().UTC())
+}
+
+// a URL with the signature in the query string, headers are signed too and must be sent as given
+func (s *S3) presign(method string, key string, headers map[string]string, expires time.Duration, now time.Time) (string, error) {
+	req, err := s.request(context.Background(), method, key, nil)
+	if err != nil {
+		return "", err
+	}
+
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	scope := date + "/" + s.Region + "/s3/aws4_request"
+
+	names := []string{"host"}
+	for name := range headers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		value := headers[name]
+		if name == "host" {
+			value = req.URL.Host
+		}
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(value) + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	query := url.Values{}
+	query.Set("X-Amz-Algorithm", "AWS4-HMAC-SHA256")
+	query.Set("X-Amz-Credential", s.AccessKeyID+"/"+scope)
+	query.Set("X-Amz-Date", amzDate)
+	query.Set("X-Amz-Expires", strconv.Itoa(int(expires.Seconds())))
+	query.Set("X-Amz-SignedHeaders", signedHeaders)
+	// url.Values encodes spaces as +, signatures need %20
+	canonicalQuery := strings.ReplaceAll(query.Encode(), "+", "%20")
+
+	canonicalRequest := strings.Join([]string{method, req.URL.EscapedPath(), canonicalQuery, canonicalHeaders.String(), signedHeaders, "UNSIGNED-PAYLOAD"}, "\n")
+	req.URL.RawQuery = canonicalQuery + "&X-Amz-Signature=" + s.signature(date, amzDate, scope, canonicalRequest)
+
+	return req.URL.String(), nil
+}
+
+func (s *S3) request(ctx context.Context, method string, key string, body []byte) (*http.Request, error) {
+	target, err := url.Parse(strings.TrimSuffix(s.Endpoint, "/") + "/" + s.Bucket + "/" + key)
+	if err != nil {
+		return nil, err
+	}
+
+	return http.NewRequestWithContext(ctx, method, target.String(), bytes.NewReader(body))
+}
+
+func (s *S3) do(req *http.Request, body []byte) error {
+	s.sign(req, body, time.Now().UTC())
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("object store: unexpected status %s: %s", resp.Status, message)
+	}
+
+	return nil
+}
+
+// adds an AWS Signature Version 4 to the request
+func (s *S3) sign(req *http.Request, body []byte, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256.Sum256(body)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(payloadHash[:]))
+
+	headers := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	if req.Header.Get("Content-Type") != "" {
+		headers = append([]string{"content-type"}, headers...)
+	}
+
+	var canonicalHeaders strings.Builder
+	for _, name := range headers {
+		value := req.Header.Get(name)
+		if name == "host" {
+			value = req.URL.Host
+		}
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(value) + "\n")
+	}
+	signedHeaders := strings.Join(headers, ";")
+
+	canonicalRequest := strings.Join([]string{req.Method, req.URL.EscapedPath(), req.URL.RawQuery, canonicalHeaders.String(), signedHeaders, hex.EncodeToString(payloadHash[:])}, "\n")
+	scope := date + "/" + s.Region + "/s3/aws4_request"
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", s.AccessKeyID, scope, signedHeaders, s.signature(date, amzDate, scope, canonicalRequest)))
+}
+
+func (s *S3) signature(date string, amzDate string, scope string, canonicalRequest string) string {
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, hex.EncodeToString(requestHash[:])}, "\n")
+
+	key := []byte("AWS4" + s.SecretKey)
+	for _, part := range []string{date, s.Region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+
+	return mac.Sum(nil)
+}
@@ -0,0 +1,167 @@
+// Package pdf writes simple text documents: headings, paragraphs and table rows on A4 pages.
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+const (
+	pageWidth  = 595.0
+	pageHeight = 842.0
+	margin     = 50.0
+	fontSize   = 10.0
+	lineHeight = 14.0
+)
+
+// only the standard Helvetica fonts are used, so nothing has to be embedded
+const (
+	regular = "F1"
+	bold    = "F2"
+)
+
+type Document struct {
+	pages []*bytes.Buffer
+	y     float64
+}
+
+func New() *Document {
+	d := &Document{}
+	d.newPage()
+	return d
+}
+
+func (d *Document) newPage() {
+	d.pages = append(d.pages, &bytes.Buffer{})
+	d.y = pageHeight - margin
+}
+
+// moves down by height, starting a new page when it does not fit
+func (d *Document) advance(height float64) {
+	if d.y-height < margin {
+		d.newPage()
+	}
+	d.y -= height
+}
+
+func (d *Document) write(font string, size float64, x float64, text string) {
+	fmt.Fprintf(d.pages[len(d.pages)-1], "BT /%s %.1f Tf %.1f %.1f Td (%s) Tj ET\n", font, size, x, d.y, escape(text))
+}
+
+func (d *Document) Heading(text string) {
+	d.advance(lineHeight * 2)
+	d.write(bold, fontSize*1.6, margin, text)
+	d.advance(lineHeight / 2)
+}
+
+func (d *Document) Subheading(text string) {
+	d.advance(lineHeight * 1.5)
+	d.write(bold, fontSize*1.2, margin, text)
+}
+
+// a paragraph wrapped to the page width
+func (d *Document) Text(text string) {
+	for _, line := range wrap(text, int((pageWidth-2*margin)/(fontSize*0.5))) {
+		d.advance(lineHeight)
+		d.write(regular, fontSize, margin, line)
+	}
+}
+
+// cells at the given widths in points, a bold row is a table header
+func (d *Document) Row(cells []string, widths []float64, header bool) {
+	d.advance(lineHeight)
+	font := regular
+	if header {
+		font = bold
+	}
+	x := margin
+	for i, cell := range cells {
+		// about half the font size per character, longer cells are cut
+		if limit := int(widths[i]/(fontSize*0.5)) - 1; len(cell) > limit && limit > 1 {
+			cell = strings.TrimSpace(cell[:limit-1]) + "."
+		}
+		d.write(font, fontSize, x, cell)
+		x += widths[i]
+	}
+}
+
+func (d *Document) Space() {
+	d.advance(lineHeight / 2)
+}
+
+// the finished document, pages, fonts and the cross-reference table
+func (d *Document) Bytes() []byte {
+	var out bytes.Buffer
+	var offsets []int
+	object := func(body string) {
+		offsets = append(offsets, out.Len())
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	out.WriteString("%PDF-1.4\n")
+	// objects 1 to 4 are fixed, then a page and its content stream per page
+	kids := make([]string, len(d.pages))
+	for i := range d.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 5+2*i)
+	}
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(d.pages)))
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+	for i, page := range d.pages {
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.0f %.0f] /Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>", pageWidth, pageHeight, 6+2*i))
+		object(fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", page.Len(), page.String()))
+	}
+
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+
+	return out.Bytes()
+}
+
+// a string literal in WinAnsi, characters outside Latin-1 become ?
+func escape(text string) string {
+	var b strings.Builder
+	for _, r := range text {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r < 32:
+			b.WriteByte(' ')
+		case r > 255:
+			b.WriteByte('?')
+		case r > 127:
+			fmt.Fprintf(&b, "\\%03o", r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+
+	return b.String()
+}
+
+func wrap(text string, width int) []string {
+	var lines []string
+	line := ""
+	for _, word := range strings.Fields(text) {
+		if line != "" && len(line)+1+len(word) > width {
+			lines = append(lines, line)
+			line = ""
+		}
+		if line != "" {
+			line += " "
+		}
+		line += word
+	}
+	if line != "" || len(lines) == 0 {
+		lines = append(lines, line)
+	}
+
+	return lines
+}
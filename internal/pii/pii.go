@@ -0,0 +1,166 @@
+// Package pii keeps personal data out of the logs.
+package pii
+
+import (
+	"io"
+	"os"
+	"regexp"
+	"strings"
+)
+
+var (
+	// phone numbers as people write them: international with a +, an area code in parentheses,
+	// grouped 3-3-4, or national with a leading 0. Dates, times and dose ids have other shapes.
+	phonePattern = regexp.MustCompile(`\+\d[\d ().-]{6,18}\d|\(\d{2,5}\) ?\d{3,4}[ .-]?\d{3,4}|\b\d{3}[ .-]\d{3}[ .-]\d{4}\b|\b0\d{2,4}[ /-]?\d{5,8}\b`)
+	emailPattern = regexp.MustCompile(`[\w.+-]+@[\w-]+(\.[\w-]+)+`)
+	// user ids handed out on signup are 32 hex characters
+	userIDPattern = regexp.MustCompile(`\b[0-9a-f]{32}\b`)
+	// medicine names where free text names the field: JSON, parameters, paths and Postgres key details
+	medicineFieldPattern = regexp.MustCompile(`("medicine"\s*:\s*")(?:[^"\\]|\\.)*"`)
+	medicineParamPattern = regexp.MustCompile(`\b(medicine=)[^&\s,;)]+`)
+	medicinePathPattern  = regexp.MustCompile(`(/medicines/)[^/\s?]+`)
+	postgresKeyPattern   = regexp.MustCompile(`Key \(([^)]*)\)=\(([^)]*)\)`)
+)
+
+// kinds of personal data that a debug build may leave readable, set in PII_LOG_ALLOWLIST
+// as a comma separated list of user_id, medicine, phone and email
+func Allowed(kind string) bool {
+	if !debugBuild {
+		return false
+	}
+
+	for _, allowed := range strings.Split(os.Getenv("PII_LOG_ALLOWLIST"), ",") {
+		if strings.TrimSpace(allowed) == kind {
+			return true
+		}
+	}
+
+	return false
+}
+
+// keeps just enough of a user id to tell users apart in logs
+func MaskUserID(userID string) string {
+	if Allowed("user_id") || userID == "" {
+		return userID
+	}
+	if len(userID) <= 4 {
+		return "***"
+	}
+
+	return userID[:4] + "***"
+}
+
+func MaskMedicine(medicine string) string {
+	if Allowed("medicine") {
+		return medicine
+	}
+
+	return "[medicine]"
+}
+
+// removes phone numbers, emails, medicines and account user ids from free text such as database errors
+func Redact(text string) string {
+	if !Allowed("phone") {
+		text = redactPhones(text)
+	}
+	if !Allowed("medicine") {
+		text = redactMedicines(text)
+	}
+	if !Allowed("email") {
+		text = emailPattern.ReplaceAllString(text, "[email]")
+	}
+	if !Allowed("user_id") {
+		text = userIDPattern.ReplaceAllStringFunc(text, MaskUserID)
+	}
+
+	return text
+}
+
+func redactPhones(text string) string {
+	var b strings.Builder
+	last := 0
+	for _, match := range phonePattern.FindAllStringIndex(text, -1) {
+		start, end := match[0], match[1]
+		if glued(text, start, end) {
+			continue
+		}
+		if digits := countDigits(text[start:end]); digits < 8 || digits > 15 {
+			continue
+		}
+
+		b.WriteString(text[last:start])
+		b.WriteString("[phone]")
+		last = end
+	}
+	b.WriteString(text[last:])
+
+	return b.String()
+}
+
+// a number glued to more letters, digits or separators is part of something else, like an id or a timestamp.
+// A full stop or colon followed by a space only ends the sentence.
+func glued(text string, start int, end int) bool {
+	if start > 0 && (isWordByte(text[start-1]) || strings.IndexByte("+-./:_", text[start-1]) >= 0) {
+		return true
+	}
+	if end == len(text) {
+		return false
+	}
+	if text[end] == '.' || text[end] == ':' {
+		return end+1 < len(text) && isWordByte(text[end+1])
+	}
+
+	return isWordByte(text[end]) || strings.IndexByte("-/_", text[end]) >= 0
+}
+
+func redactMedicines(text string) string {
+	text = medicineFieldPattern.ReplaceAllString(text, `${1}[medicine]"`)
+	text = medicineParamPattern.ReplaceAllString(text, "${1}[medicine]")
+	text = medicinePathPattern.ReplaceAllString(text, "${1}[medicine]")
+
+	// Key (user_id, medicine)=(42, Aspirin) of unique and foreign key violations
+	return postgresKeyPattern.ReplaceAllStringFunc(text, func(detail string) string {
+		parts := postgresKeyPattern.FindStringSubmatch(detail)
+		columns, values := strings.Split(parts[1], ", "), strings.Split(parts[2], ", ")
+		if len(columns) != len(values) {
+			return detail
+		}
+		for i, column := range columns {
+			if column == "medicine" {
+				values[i] = "[medicine]"
+			}
+		}
+
+		return "Key (" + parts[1] + ")=(" + strings.Join(values, ", ") + ")"
+	})
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isWordByte(c byte) bool {
+	return isDigit(c) || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func countDigits(text string) int {
+	count := 0
+	for i := 0; i < len(text); i++ {
+		if isDigit(text[i]) {
+			count++
+		}
+	}
+
+	return count
+}
+
+// log output that passes every line through Redact
+type Writer struct {
+	Out io.Writer
+}
+
+func (w Writer) Write(p []byte) (int, error) {
+	_, err := io.WriteString(w.Out, Redact(string(p)))
+
+	return len(p), err
+}
@@ -0,0 +1,6 @@
+//go:build debug
+
+package pii
+
+// debug builds honour PII_LOG_ALLOWLIST
+const debugBuild = true
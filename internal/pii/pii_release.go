@@ -0,0 +1,5 @@
+//go:build !debug
+
+package pii
+
+const debugBuild = false
@@ -0,0 +1,41 @@
+package pii
+
+import "testing"
+
+func TestRedact(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		// redacted
+		{"sms to +49 30 1234567 failed", "sms to [phone] failed"},
+		{"sms to +1 (555) 123-4567 failed", "sms to [phone] failed"},
+		{"sms to +4915112345678: timeout", "sms to [phone]: timeout"},
+		{"call (555) 123-4567.", "call [phone]."},
+		{"call 555-123-4567 or 555.123.4567", "call [phone] or [phone]"},
+		{"call 030 12345678", "call [phone]"},
+		{"mail to jane.doe+meds@example.com bounced", "mail to [email] bounced"},
+		{"user 0123456789abcdef0123456789abcdef not found", "user 0123*** not found"},
+		{`body {"medicine":"Aspirin 100","duration":3}`, `body {"medicine":"[medicine]","duration":3}`},
+		{`body {"medicine": "Dr. \"Special\" Tea"}`, `body {"medicine": "[medicine]"}`},
+		{"GET /v1/schedules/search?medicine=Aspirin&limit=5", "GET /v1/schedules/search?medicine=[medicine]&limit=5"},
+		{"PUT /v1/admin/medicines/Ibuprofen/safety", "PUT /v1/admin/medicines/[medicine]/safety"},
+		{"duplicate key: Key (user_id, medicine)=(42, Aspirin) already exists", "duplicate key: Key (user_id, medicine)=(42, [medicine]) already exists"},
+
+		// left alone
+		{"purge intakes before 2026-10-16T14:05:59Z", "purge intakes before 2026-10-16T14:05:59Z"},
+		{"purge intakes before 2026-10-16 14:05:59+02:00", "purge intakes before 2026-10-16 14:05:59+02:00"},
+		{"confirm dose 42-20261016-2 failed", "confirm dose 42-20261016-2 failed"},
+		{"confirm dose 123456-20261016-12 failed", "confirm dose 123456-20261016-12 failed"},
+		{"schedule 1234567890 not found", "schedule 1234567890 not found"},
+		{"uuid 0191f2a4-7b3c-7d2e-8f00-1234567890ab", "uuid 0191f2a4-7b3c-7d2e-8f00-1234567890ab"},
+		{"took 12.345678 seconds, version 1.2.3", "took 12.345678 seconds, version 1.2.3"},
+		{"at unix 1760623559", "at unix 1760623559"},
+		{"key (user_id, external_id)=(42, ehr-7)", "key (user_id, external_id)=(42, ehr-7)"},
+	}
+	for _, test := range tests {
+		if got := Redact(test.text); got != test.want {
+			t.Errorf("Redact(%q) = %q, want %q", test.text, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+// Package prescription reads the text of photographed prescriptions through a configurable backend.
+package prescription
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+)
+
+// an OCR or document AI service that returns the text of a prescription image
+type Extractor interface {
+	Extract(ctx context.Context, image []byte, contentType string) (string, error)
+}
+
+var client = &http.Client{Timeout: time.Minute}
+
+// the backend chosen by PRESCRIPTION_EXTRACTOR, nil when photo intake is not configured
+func FromEnv() (Extractor, error) {
+	switch backend := os.Getenv("PRESCRIPTION_EXTRACTOR"); backend {
+	case "":
+		return nil, nil
+	case "http":
+		url := os.Getenv("PRESCRIPTION_EXTRACTOR_URL")
+		if url == "" {
+			return nil, fmt.Errorf("PRESCRIPTION_EXTRACTOR_URL is required")
+		}
+		return HTTPExtractor{URL: url, Token: os.Getenv("PRESCRIPTION_EXTRACTOR_TOKEN")}, nil
+	default:
+		return nil, fmt.Errorf("unknown PRESCRIPTION_EXTRACTOR %q, expected http", backend)
+	}
+}
+
+// posts the image as the request body and expects {"text": "..."} back,
+// the contract of a small adapter in front of whichever OCR service is used
+type HTTPExtractor struct {
+	URL, Token string
+}
+
+func (e HTTPExtractor) Extract(ctx context.Context, image []byte, contentType string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.URL, bytes.NewReader(image))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", contentType)
+	if e.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+e.Token)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return "", fmt.Errorf("extractor: unexpected status %s: %s", resp.Status, message)
+	}
+
+	var body struct {
+		Text string `json:"text"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&body)
+	if err != nil {
+		return "", fmt.Errorf("extractor: %w", err)
+	}
+
+	return body.Text, nil
+}
@@ -0,0 +1,26 @@
+package schedule
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// icons clients draw for a medicine, a client that does not know one falls back to pill
+var Icons = []string{"pill", "capsule", "tablet", "liquid", "drops", "injection", "inhaler", "spray", "cream", "patch"}
+
+var colorPattern = regexp.MustCompile(`^#[0-9a-f]{6}$`)
+
+// checks the optional color and icon of s, the color is lower cased as #rrggbb
+func NormalizeAppearance(s *Schedule) error {
+	var color, icon error
+	s.Color = strings.ToLower(s.Color)
+	if s.Color != "" && !colorPattern.MatchString(s.Color) {
+		color = FieldErrorf("color", "format", "invalid color, expected #rrggbb")
+	}
+	if s.Icon != "" && !slices.Contains(Icons, s.Icon) {
+		icon = FieldErrorf("icon", "enum", "invalid icon, expected one of %s", strings.Join(Icons, ", "))
+	}
+
+	return JoinFields(color, icon)
+}
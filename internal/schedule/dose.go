@@ -0,0 +1,38 @@
+package schedule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DoseID names a planned dose by its schedule, the calendar day in the user's timezone and
+// its slot of the day counted from 1, like 42-20261016-2. The same dose always gets the same
+// id, so a client can confirm the dose it was reminded of.
+func DoseID(scheduleID int, day time.Time, slot int) string {
+	return fmt.Sprintf("%d-%s-%d", scheduleID, day.Format("20060102"), slot)
+}
+
+func ParseDoseID(id string) (scheduleID int, day time.Time, slot int, err error) {
+	invalid := Errorf(ErrValidation, "invalid dose_id, expected <schedule id>-<YYYYMMDD>-<slot>")
+
+	parts := strings.Split(id, "-")
+	if len(parts) != 3 {
+		return 0, time.Time{}, 0, invalid
+	}
+	scheduleID, err = strconv.Atoi(parts[0])
+	if err != nil || scheduleID < 1 {
+		return 0, time.Time{}, 0, invalid
+	}
+	day, err = time.Parse("20060102", parts[1])
+	if err != nil {
+		return 0, time.Time{}, 0, invalid
+	}
+	slot, err = strconv.Atoi(parts[2])
+	if err != nil || slot < 1 {
+		return 0, time.Time{}, 0, invalid
+	}
+
+	return scheduleID, day, slot, nil
+}
@@ -0,0 +1,28 @@
+package schedule
+
+// an amount of medicine in one dose
+type Amount struct {
+	Value float64 `json:"value"`
+	Unit  string  `json:"unit"`
+}
+
+// a dose by body weight, per dose in mg per kg. Max may be nil when only the usual dose is
+// known, Cap is the most one dose may be regardless of weight, adult dosing takes over above it.
+type WeightRule struct {
+	MinMgPerKg float64
+	MaxMgPerKg *float64
+	CapMg      *float64
+}
+
+// the safe range of one dose in mg for a weight, the low end is the suggested dose
+func WeightDoseRange(rule WeightRule, weightKg float64) (float64, float64) {
+	low, high := weightKg*rule.MinMgPerKg, weightKg*rule.MinMgPerKg
+	if rule.MaxMgPerKg != nil {
+		high = weightKg * *rule.MaxMgPerKg
+	}
+	if rule.CapMg != nil {
+		low, high = min(low, *rule.CapMg), min(high, *rule.CapMg)
+	}
+
+	return low, high
+}
@@ -0,0 +1,103 @@
+package schedule
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// kinds of failures the service layer reports, the http layer turns them into statuses
+var (
+	ErrNotFound     = errors.New("not found")
+	ErrForbidden    = errors.New("forbidden")
+	ErrValidation   = errors.New("invalid")
+	ErrConflict     = errors.New("conflict")
+	ErrUnauthorized = errors.New("unauthorized")
+	// the client's copy is stale, or it did not say which version it changes
+	ErrStale           = errors.New("stale")
+	ErrVersionRequired = errors.New("version required")
+	// too many attempts, the client has to wait before trying again
+	ErrTooManyAttempts = errors.New("too many attempts")
+)
+
+// Error is a failure of one of the kinds with a message for the client, errors.Is matches the kind.
+// Validation errors list the inputs that failed, so client forms can highlight them.
+type Error struct {
+	Kind    error
+	Message string
+	Fields  []FieldError
+}
+
+// one invalid input: the JSON path of the field like pill.shape or tags[2], and the rule it broke,
+// one of required, min, max, format, enum, max_length or max_items
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Kind
+}
+
+func Errorf(kind error, format string, args ...interface{}) error {
+	return &Error{Kind: kind, Message: fmt.Sprintf(format, args...)}
+}
+
+// a validation error of one field, its message is the message of the error
+func FieldErrorf(field string, rule string, format string, args ...interface{}) error {
+	message := fmt.Sprintf(format, args...)
+	return &Error{Kind: ErrValidation, Message: message, Fields: []FieldError{{Field: field, Rule: rule, Message: message}}}
+}
+
+// the invalid fields of err, none when it is no validation error of fields
+func Fields(err error) []FieldError {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Fields
+	}
+
+	return nil
+}
+
+// combines the results of several checks into one validation error with all their fields, so a form
+// learns about every invalid input at once. Another error is returned as it is, nil when all passed.
+func JoinFields(errs ...error) error {
+	var fields []FieldError
+	var messages []string
+	for _, err := range errs {
+		if err == nil {
+			continue
+		}
+		found := Fields(err)
+		if len(found) == 0 {
+			return err
+		}
+		fields = append(fields, found...)
+		messages = append(messages, err.Error())
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+
+	return &Error{Kind: ErrValidation, Message: strings.Join(messages, "; "), Fields: fields}
+}
+
+// the validation error of a nested object with its field paths under prefix, like weekend.wake.
+// Other errors are returned as they are.
+func PrefixFields(prefix string, err error) error {
+	found := Fields(err)
+	if len(found) == 0 {
+		return err
+	}
+
+	fields := make([]FieldError, len(found))
+	for i, field := range found {
+		fields[i] = FieldError{Field: prefix + "." + field.Field, Rule: field.Rule, Message: prefix + ": " + field.Message}
+	}
+	return &Error{Kind: ErrValidation, Message: prefix + ": " + err.Error(), Fields: fields}
+}
@@ -0,0 +1,63 @@
+package schedule
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const maxInstructionsLength = 1000
+
+var (
+	htmlTagPattern  = regexp.MustCompile(`(?s)</?[a-zA-Z!][^>]*>`)
+	imagePattern    = regexp.MustCompile(`!\[([^\]]*)\]\(([^)]*)\)`)
+	linkPattern     = regexp.MustCompile(`\[([^\]]*)\]\(([^)]*)\)`)
+	emphasisPattern = regexp.MustCompile("\\*\\*|__|~~|`")
+	headingPattern  = regexp.MustCompile(`(?m)^#{1,6}\s+`)
+)
+
+// instructions are markdown that clients render. Raw HTML is dropped, images keep only their
+// text so no client loads a remote file, and links keep their target only when it is http,
+// https or mailto. Lines are \n, other control characters are removed.
+func SanitizeInstructions(text string) (string, error) {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	text = strings.Map(func(r rune) rune {
+		if r == utf8.RuneError || (unicode.IsControl(r) && r != '\n' && r != '\t') {
+			return -1
+		}
+		return r
+	}, text)
+	text = htmlTagPattern.ReplaceAllString(text, "")
+	text = imagePattern.ReplaceAllString(text, "$1")
+	text = linkPattern.ReplaceAllStringFunc(text, func(link string) string {
+		parts := linkPattern.FindStringSubmatch(link)
+		target := strings.ToLower(strings.TrimSpace(parts[2]))
+		if strings.HasPrefix(target, "https://") || strings.HasPrefix(target, "http://") || strings.HasPrefix(target, "mailto:") {
+			return link
+		}
+		return parts[1]
+	})
+	text = strings.TrimSpace(text)
+
+	if utf8.RuneCountInString(text) > maxInstructionsLength {
+		return "", FieldErrorf("instructions", "max_length", "instructions can be at most %d characters", maxInstructionsLength)
+	}
+
+	return text, nil
+}
+
+// sanitized instructions as plain text for reminders and printouts, links become "text (target)"
+func PlainInstructions(text string) string {
+	text = linkPattern.ReplaceAllStringFunc(text, func(link string) string {
+		parts := linkPattern.FindStringSubmatch(link)
+		if parts[1] == "" || parts[1] == parts[2] {
+			return parts[2]
+		}
+		return parts[1] + " (" + parts[2] + ")"
+	})
+	text = headingPattern.ReplaceAllString(text, "")
+	text = emphasisPattern.ReplaceAllString(text, "")
+
+	return strings.TrimSpace(text)
+}
@@ -0,0 +1,90 @@
+package schedule
+
+import (
+	"fmt"
+	"slices"
+	"time"
+)
+
+// the channels reminders can go out on
+var NotificationChannels = []string{"push", "sms", "email", "voice"}
+
+// how a user is reminded of doses, set for the user and optionally replaced per schedule
+type NotificationSettings struct {
+	// no channels mutes the reminders
+	Channels []string `json:"channels"`
+	// how long before the dose the first reminder goes out
+	LeadMinutes int `json:"lead_minutes"`
+	// reminders repeat until the dose is confirmed, at most RepeatTimes more
+	RepeatEveryMinutes int         `json:"repeat_every_minutes"`
+	RepeatTimes        int         `json:"repeat_times"`
+	QuietHours         *QuietHours `json:"quiet_hours,omitempty"`
+}
+
+// a daily window without reminders as HH:MM in the user's timezone, it may span midnight
+type QuietHours struct {
+	Start string `json:"start"`
+	End   string `json:"end"`
+}
+
+func DefaultNotificationSettings() NotificationSettings {
+	return NotificationSettings{Channels: []string{"push"}}
+}
+
+func (s NotificationSettings) Validate() error {
+	var errs []error
+	for i, channel := range s.Channels {
+		if !slices.Contains(NotificationChannels, channel) {
+			errs = append(errs, FieldErrorf(fmt.Sprintf("channels[%d]", i), "enum", "unknown channel %q, expected push, sms, email or voice", channel))
+		}
+	}
+	if s.LeadMinutes < 0 {
+		errs = append(errs, FieldErrorf("lead_minutes", "min", "lead_minutes must be between 0 and 240"))
+	} else if s.LeadMinutes > 240 {
+		errs = append(errs, FieldErrorf("lead_minutes", "max", "lead_minutes must be between 0 and 240"))
+	}
+	if s.RepeatTimes < 0 {
+		errs = append(errs, FieldErrorf("repeat_times", "min", "repeat_times must be between 0 and 5, repeating every 5 to 120 minutes"))
+	} else if s.RepeatTimes > 5 {
+		errs = append(errs, FieldErrorf("repeat_times", "max", "repeat_times must be between 0 and 5, repeating every 5 to 120 minutes"))
+	} else if s.RepeatTimes > 0 && s.RepeatEveryMinutes < 5 {
+		errs = append(errs, FieldErrorf("repeat_every_minutes", "min", "repeat_times must be between 0 and 5, repeating every 5 to 120 minutes"))
+	} else if s.RepeatTimes > 0 && s.RepeatEveryMinutes > 120 {
+		errs = append(errs, FieldErrorf("repeat_every_minutes", "max", "repeat_times must be between 0 and 5, repeating every 5 to 120 minutes"))
+	}
+	if s.QuietHours != nil {
+		if _, err := time.Parse("15:04", s.QuietHours.Start); err != nil {
+			errs = append(errs, FieldErrorf("quiet_hours.start", "format", "quiet_hours start must be HH:MM"))
+		}
+		if _, err := time.Parse("15:04", s.QuietHours.End); err != nil {
+			errs = append(errs, FieldErrorf("quiet_hours.end", "format", "quiet_hours end must be HH:MM"))
+		}
+	}
+
+	return JoinFields(errs...)
+}
+
+// whether t falls into the quiet hours, read in the timezone of t
+func (q *QuietHours) Contains(t time.Time) bool {
+	if q == nil || q.Start == q.End {
+		return false
+	}
+
+	clock := fmt.Sprintf("%02d:%02d", t.Hour(), t.Minute())
+	if q.Start < q.End {
+		return clock >= q.Start && clock < q.End
+	}
+
+	return clock >= q.Start || clock < q.End
+}
+
+// when the reminders of a dose go out, the first one and then the repeats
+func ReminderTimes(doseTime time.Time, settings NotificationSettings) []time.Time {
+	first := doseTime.Add(-time.Duration(settings.LeadMinutes) * time.Minute)
+	times := []time.Time{first}
+	for i := 1; i <= settings.RepeatTimes; i++ {
+		times = append(times, first.Add(time.Duration(i*settings.RepeatEveryMinutes)*time.Minute))
+	}
+
+	return times
+}
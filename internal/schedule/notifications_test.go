@@ -0,0 +1,43 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func TestQuietHours(t *testing.T) {
+	tests := []struct {
+		quiet *QuietHours
+		clock string
+		want  bool
+	}{
+		{nil, "23:00", false},
+		{&QuietHours{Start: "22:00", End: "07:00"}, "23:30", true},
+		{&QuietHours{Start: "22:00", End: "07:00"}, "06:59", true},
+		{&QuietHours{Start: "22:00", End: "07:00"}, "07:00", false},
+		{&QuietHours{Start: "13:00", End: "15:00"}, "14:00", true},
+		{&QuietHours{Start: "13:00", End: "15:00"}, "12:59", false},
+	}
+
+	for _, test := range tests {
+		clock, _ := time.Parse("15:04", test.clock)
+		if got := test.quiet.Contains(clock); got != test.want {
+			t.Errorf("%+v.Contains(%s) = %t, want %t", test.quiet, test.clock, got, test.want)
+		}
+	}
+}
+
+func TestReminderTimes(t *testing.T) {
+	dose := time.Date(2026, 10, 16, 8, 0, 0, 0, time.UTC)
+	got := ReminderTimes(dose, NotificationSettings{LeadMinutes: 10, RepeatEveryMinutes: 15, RepeatTimes: 2})
+
+	want := []string{"07:50", "08:05", "08:20"}
+	if len(got) != len(want) {
+		t.Fatalf("ReminderTimes = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i].Format("15:04") != want[i] {
+			t.Errorf("reminder %d at %s, want %s", i, got[i].Format("15:04"), want[i])
+		}
+	}
+}
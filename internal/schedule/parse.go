@@ -0,0 +1,123 @@
+package schedule
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Draft is a schedule read from free text, apps show it for confirmation before creating it
+type Draft struct {
+	Medicine  string `json:"medicine"`
+	Strength  string `json:"strength,omitempty"`
+	Frequency int    `json:"frequency"`
+	Duration  int    `json:"duration"`
+	// fields the text did not give, the app has to ask for them
+	Missing []string `json:"missing,omitempty"`
+}
+
+var numberWords = map[string]int{
+	"a": 1, "an": 1, "one": 1, "two": 2, "three": 3, "four": 4, "five": 5, "six": 6, "seven": 7,
+	"eight": 8, "nine": 9, "ten": 10, "eleven": 11, "twelve": 12, "fourteen": 14, "twenty": 20, "thirty": 30,
+}
+
+const numberPattern = `(\d+|a|an|one|two|three|four|five|six|seven|eight|nine|ten|eleven|twelve|fourteen|twenty|thirty)`
+
+var (
+	strengthPattern = regexp.MustCompile(`\b(\d+(?:[.,]\d+)?)\s*(mg|mcg|µg|g|ml|iu|units?)\b`)
+	timesPattern    = regexp.MustCompile(`\b` + numberPattern + `\s+times?\s+(?:a|per|each)\s+day\b|\b` + numberPattern + `\s+times?\s+daily\b`)
+	everyPattern    = regexp.MustCompile(`\bevery\s+(\d+)\s+hours?\b`)
+	coursePattern   = regexp.MustCompile(`\bfor\s+` + numberPattern + `\s+(days?|weeks?|months?)\b`)
+	// the medicine ends where the dosing instructions start
+	instructionPattern = regexp.MustCompile(`\b(?:\d|(?:once|twice|thrice|every|daily|for|at|in|before|after|with|take|bid|tid|qid|qd|od)\b)`)
+)
+
+// shorthand of prescriptions and everyday phrases, checked in order after the explicit counts
+var dosesPerDayPhrases = []struct {
+	pattern *regexp.Regexp
+	doses   int
+}{
+	{regexp.MustCompile(`\b(qid|four times)\b`), 4},
+	{regexp.MustCompile(`\b(tid|thrice)\b`), 3},
+	{regexp.MustCompile(`\b(bid|twice)\b|\bmorning and (evening|night)\b`), 2},
+	{regexp.MustCompile(`\b(once|daily|qd|od|at bedtime|at night|every morning|every evening)\b`), 1},
+}
+
+var ongoingPattern = regexp.MustCompile(`\b(ongoing|indefinitely|long[- ]term|until further notice)\b`)
+
+// reads a schedule draft from text like "amoxicillin 500mg three times a day for 7 days",
+// the frequency of the draft is 0 when the text says the course does not end
+func ParseDraft(text string) Draft {
+	lower := strings.ToLower(strings.Join(strings.Fields(text), " "))
+	var draft Draft
+
+	medicine := lower
+	for _, pattern := range []*regexp.Regexp{instructionPattern, timesPattern} {
+		if loc := pattern.FindStringIndex(medicine); loc != nil {
+			medicine = medicine[:loc[0]]
+		}
+	}
+	draft.Medicine = capitalize(strings.Trim(medicine, " ,.-:"))
+
+	if match := strengthPattern.FindStringSubmatch(lower); match != nil {
+		draft.Strength = strings.ReplaceAll(match[1], ",", ".") + match[2]
+	}
+
+	if match := timesPattern.FindStringSubmatch(lower); match != nil {
+		draft.Duration = parseNumber(match[1] + match[2])
+	} else if match := everyPattern.FindStringSubmatch(lower); match != nil {
+		// doses are spread over the waking day from 8:00 to 22:00
+		if hours, _ := strconv.Atoi(match[1]); hours > 0 && hours <= 24 {
+			draft.Duration = max(24/hours, 1)
+		}
+	} else {
+		for _, phrase := range dosesPerDayPhrases {
+			if phrase.pattern.MatchString(lower) {
+				draft.Duration = phrase.doses
+				break
+			}
+		}
+	}
+
+	if match := coursePattern.FindStringSubmatch(lower); match != nil {
+		days := parseNumber(match[1])
+		switch {
+		case strings.HasPrefix(match[2], "week"):
+			days *= 7
+		case strings.HasPrefix(match[2], "month"):
+			days *= 30
+		}
+		draft.Frequency = days
+	}
+
+	if draft.Medicine == "" {
+		draft.Missing = append(draft.Missing, "medicine")
+	}
+	if draft.Duration == 0 {
+		draft.Missing = append(draft.Missing, "duration")
+	}
+	if draft.Frequency == 0 && !ongoingPattern.MatchString(lower) {
+		draft.Missing = append(draft.Missing, "frequency")
+	}
+
+	return draft
+}
+
+func parseNumber(word string) int {
+	if n, err := strconv.Atoi(word); err == nil {
+		return n
+	}
+
+	return numberWords[word]
+}
+
+func capitalize(s string) string {
+	r, size := utf8.DecodeRuneInString(s)
+	if r == utf8.RuneError {
+		return s
+	}
+
+	return string(unicode.ToUpper(r)) + s[size:]
+}
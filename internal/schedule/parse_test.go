@@ -0,0 +1,28 @@
+package schedule
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseDraft(t *testing.T) {
+	tests := []struct {
+		text string
+		want Draft
+	}{
+		{"amoxicillin 500mg three times a day for 7 days", Draft{Medicine: "Amoxicillin", Strength: "500mg", Frequency: 7, Duration: 3}},
+		{"Ibuprofen 200 mg twice daily for two weeks", Draft{Medicine: "Ibuprofen", Strength: "200mg", Frequency: 14, Duration: 2}},
+		{"metformin every 12 hours ongoing", Draft{Medicine: "Metformin", Duration: 2}},
+		{"Vitamin B12 once a day for a month", Draft{Medicine: "Vitamin b12", Frequency: 30, Duration: 1}},
+		{"cetirizine 10mg at bedtime", Draft{Medicine: "Cetirizine", Strength: "10mg", Duration: 1, Missing: []string{"frequency"}}},
+		{"take 2 times a day", Draft{Duration: 2, Missing: []string{"medicine", "frequency"}}},
+	}
+
+	for _, test := range tests {
+		got := ParseDraft(test.text)
+		if got.Medicine != test.want.Medicine || got.Strength != test.want.Strength || got.Frequency != test.want.Frequency ||
+			got.Duration != test.want.Duration || !slices.Equal(got.Missing, test.want.Missing) {
+			t.Errorf("ParseDraft(%q) = %+v, want %+v", test.text, got, test.want)
+		}
+	}
+}
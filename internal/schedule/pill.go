@@ -0,0 +1,65 @@
+package schedule
+
+import (
+	"slices"
+	"strings"
+)
+
+var PillShapes = []string{"round", "oval", "oblong", "capsule", "square", "rectangle", "triangle", "diamond", "pentagon", "hexagon", "other"}
+
+// what a pill looks like, to tell it from the others. Every field is optional.
+type Pill struct {
+	Shape string `json:"shape,omitempty"`
+	Color string `json:"color,omitempty"`
+	// the letters and numbers pressed into it, e.g. "AB 12"
+	Imprint string `json:"imprint,omitempty"`
+}
+
+// lower cases shape and color and checks the lengths
+func NormalizePill(p *Pill) error {
+	p.Shape = strings.ToLower(strings.TrimSpace(p.Shape))
+	p.Color = strings.ToLower(strings.TrimSpace(p.Color))
+	p.Imprint = strings.TrimSpace(p.Imprint)
+	var errs []error
+	if p.Shape != "" && !slices.Contains(PillShapes, p.Shape) {
+		errs = append(errs, FieldErrorf("pill.shape", "enum", "invalid pill shape, expected one of %s", strings.Join(PillShapes, ", ")))
+	}
+	if len(p.Color) > 32 {
+		errs = append(errs, FieldErrorf("pill.color", "max_length", "pill color can be at most 32 characters"))
+	}
+	if len(p.Imprint) > 32 {
+		errs = append(errs, FieldErrorf("pill.imprint", "max_length", "pill imprint can be at most 32 characters"))
+	}
+
+	return JoinFields(errs...)
+}
+
+// imprints compare without case, spaces or dashes, "ab-12" is "AB 12"
+func normalImprint(imprint string) string {
+	return strings.ToUpper(strings.NewReplacer(" ", "", "-", "", "/", "").Replace(imprint))
+}
+
+// compares a pill someone holds with the one on record. It matches when nothing seen contradicts the
+// record and at least one detail was confirmed, matched lists the confirmed details.
+func MatchPill(record Pill, seen Pill) ([]string, bool) {
+	var matched []string
+	details := []struct {
+		name         string
+		record, seen string
+	}{
+		{"imprint", normalImprint(record.Imprint), normalImprint(seen.Imprint)},
+		{"shape", record.Shape, seen.Shape},
+		{"color", record.Color, seen.Color},
+	}
+	for _, detail := range details {
+		if detail.record == "" || detail.seen == "" {
+			continue
+		}
+		if detail.record != detail.seen {
+			return nil, false
+		}
+		matched = append(matched, detail.name)
+	}
+
+	return matched, len(matched) > 0
+}
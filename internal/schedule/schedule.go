@@ -0,0 +1,208 @@
+// Package schedule is the domain of medicine schedules: when their doses are due.
+package schedule
+
+import "time"
+
+type Schedule struct {
+	ID        int       `json:"id,omitempty"`
+	UUID      string    `json:"uuid,omitempty"`
+	Medicine  string    `json:"medicine"`
+	Frequency int       `json:"frequency"`
+	Duration  int       `json:"duration"`
+	UserID    string    `json:"user_id"`
+	Status    string    `json:"status"`
+	Version   int       `json:"version,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Progress  *Progress `json:"progress,omitempty"`
+	// who prescribed the medicine and where it is filled, both optional
+	Prescriber *Contact `json:"prescriber,omitempty"`
+	Pharmacy   *Contact `json:"pharmacy,omitempty"`
+	// lower case, see NormalizeTags
+	Tags []string `json:"tags,omitempty"`
+	// how clients draw the medicine, see NormalizeAppearance
+	Color string `json:"color,omitempty"`
+	Icon  string `json:"icon,omitempty"`
+	// how to take it, markdown, see SanitizeInstructions
+	Instructions string `json:"instructions,omitempty"`
+	Pill         *Pill  `json:"pill,omitempty"`
+	// the amount of one dose and what one tablet, ml or other form holds, both optional
+	Dose     *Amount `json:"dose,omitempty"`
+	Strength *Amount `json:"strength,omitempty"`
+	// the id in the EHR or pharmacy system that pushed the schedule, unique per user
+	ExternalID string `json:"external_id,omitempty"`
+}
+
+type Contact struct {
+	Name    string `json:"name"`
+	Phone   string `json:"phone,omitempty"`
+	Email   string `json:"email,omitempty"`
+	Address string `json:"address,omitempty"`
+}
+
+type TakeSchedule struct {
+	DoseID     string `json:"dose_id"`
+	Medicine   string `json:"medicine"`
+	TakeTime   string `json:"take_time"`
+	DoseNumber int    `json:"dose_number"`
+	TotalDoses int    `json:"total_doses,omitempty"`
+	Color      string `json:"color,omitempty"`
+	Icon       string `json:"icon,omitempty"`
+	// how much to take, see FormatDose
+	Dose string `json:"dose,omitempty"`
+}
+
+// how far through its course a schedule is, dose 5 of 21
+type Progress struct {
+	// the last dose that was due, 0 before the first one
+	Dose int `json:"dose"`
+	// 0 when the course never ends
+	Total int `json:"total,omitempty"`
+}
+
+// time period parameter
+const PPH = 2
+
+func ValidStatus(status string) bool {
+	return status == "active" || status == "paused" || status == "completed" || status == "archived"
+}
+
+// checks the fields every saved schedule needs, the details are checked where they are normalized
+func ValidateSchedule(s Schedule) error {
+	var errs []error
+	if s.Medicine == "" {
+		errs = append(errs, FieldErrorf("medicine", "required", "medicine is required"))
+	}
+	if s.Frequency < 0 {
+		errs = append(errs, FieldErrorf("frequency", "min", "frequency must be 0 for an ongoing schedule or a number of days"))
+	}
+	if s.Duration < 1 {
+		errs = append(errs, FieldErrorf("duration", "min", "duration must be at least 1 dose a day"))
+	}
+	if !ValidStatus(s.Status) {
+		errs = append(errs, FieldErrorf("status", "enum", "invalid status, expected active, paused, completed or archived"))
+	}
+
+	return JoinFields(errs...)
+}
+
+func NextTakings(schedules []Schedule, now time.Time, loc *time.Location) []TakeSchedule {
+	var takeSchedules []TakeSchedule
+	for _, schedule := range schedules {
+		if !CheckDay(schedule, now, loc) {
+			continue
+		}
+		takeSchedules = append(takeSchedules, CalculateTime(schedule, now.In(loc))...)
+	}
+
+	return takeSchedules
+}
+
+// plans the doses of the day in the timezone of now
+func CalculateTime(schedule Schedule, now time.Time) []TakeSchedule {
+	return PlannedTakings(schedule, now, DoseTimes(schedule, now))
+}
+
+// the doses of the day that are due within the next PPH hours
+func PlannedTakings(schedule Schedule, now time.Time, doses []time.Time) []TakeSchedule {
+	today := LocalDate(now, now.Location())
+
+	timeInterval := time.Duration(PPH) * time.Hour
+	later := now.Add(timeInterval)
+
+	var takeSchedules []TakeSchedule
+	for i, doseTime := range doses {
+		if doseTime.After(now) && doseTime.Before(later) {
+			var takeSchedule TakeSchedule
+			takeSchedule.DoseID = DoseID(schedule.ID, today, i+1)
+			takeSchedule.Medicine = schedule.Medicine
+			takeSchedule.TakeTime = doseTime.Format("15:04")
+			takeSchedule.DoseNumber = DoseNumber(schedule, today, i+1, now.Location())
+			takeSchedule.TotalDoses = TotalDoses(schedule)
+			takeSchedule.Color, takeSchedule.Icon = schedule.Color, schedule.Icon
+			if schedule.Dose != nil {
+				takeSchedule.Dose = FormatDose(*schedule.Dose, schedule.Strength)
+			}
+			takeSchedules = append(takeSchedules, takeSchedule)
+		}
+	}
+
+	return takeSchedules
+}
+
+// the times of the doses on the day of now, spread from 8:00 to 22:00 in the timezone of now
+func DoseTimes(schedule Schedule, now time.Time) []time.Time {
+	return DoseTimesIn(schedule, now, DefaultWindow)
+}
+
+// position of the dose in slot of day within the whole course, counted from 1
+func DoseNumber(schedule Schedule, day time.Time, slot int, loc *time.Location) int {
+	days := int(day.Sub(LocalDate(schedule.CreatedAt, loc)).Hours() / 24)
+	return days*schedule.Duration + slot
+}
+
+// doses of the whole course, 0 when it never ends
+func TotalDoses(schedule Schedule) int {
+	return schedule.Frequency * schedule.Duration
+}
+
+func CourseProgress(schedule Schedule, now time.Time, loc *time.Location) Progress {
+	progress := Progress{Total: TotalDoses(schedule)}
+	today := LocalDate(now, loc)
+	startDate := LocalDate(schedule.CreatedAt, loc)
+	if today.Before(startDate) {
+		return progress
+	}
+	if !CheckDay(schedule, now, loc) {
+		progress.Dose = progress.Total
+		return progress
+	}
+
+	progress.Dose = DoseNumber(schedule, today, 0, loc)
+	for _, doseTime := range DoseTimes(schedule, now.In(loc)) {
+		if !doseTime.After(now) {
+			progress.Dose++
+		}
+	}
+
+	return progress
+}
+
+// doses planned from from until before to, the base of adherence rates
+func DosesDue(schedule Schedule, from time.Time, to time.Time, loc *time.Location) int {
+	due := 0
+	for day := LocalDate(from, loc); !day.After(LocalDate(to, loc)); day = day.AddDate(0, 0, 1) {
+		// noon keeps the day the same across daylight saving changes
+		noon := time.Date(day.Year(), day.Month(), day.Day(), 12, 0, 0, 0, loc)
+		if !CheckDay(schedule, noon, loc) {
+			continue
+		}
+		for _, doseTime := range DoseTimes(schedule, noon) {
+			if !doseTime.Before(from) && doseTime.Before(to) {
+				due++
+			}
+		}
+	}
+
+	return due
+}
+
+// whether today is within the course, both days are calendar days in the user's timezone
+func CheckDay(schedule Schedule, now time.Time, loc *time.Location) bool {
+	today := LocalDate(now, loc)
+	startDate := LocalDate(schedule.CreatedAt, loc)
+	if today.Before(startDate) {
+		return false
+	}
+	if schedule.Frequency == 0 {
+		return true
+	}
+
+	return today.Before(startDate.AddDate(0, 0, schedule.Frequency))
+}
+
+// calendar date of t in loc as midnight UTC, so whole days can be compared and added safely
+func LocalDate(t time.Time, loc *time.Location) time.Time {
+	year, month, day := t.In(loc).Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,106 @@
+package schedule
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+// perf budget of the dose expansion, per call on a typical CI machine.
+// With PERF_BUDGET=1 TestDoseExpansionBudget fails when a benchmark gets slower than its budget,
+// raise a budget only together with the reason in the commit. Ordinary and race runs skip it,
+// their timings say nothing about the budget.
+var doseExpansionBudgets = map[string]time.Duration{
+	"CalculateTime/doses=4":          2 * time.Microsecond,
+	"CalculateTime/doses=24":         10 * time.Microsecond,
+	"NextTakings/schedules=10":       20 * time.Microsecond,
+	"NextTakings/schedules=500":      1 * time.Millisecond,
+	"NextTakings/schedules=500/Asia": 1 * time.Millisecond,
+}
+
+var benchmarkNow = time.Date(2025, 3, 14, 9, 30, 0, 0, time.UTC)
+
+func benchmarkSchedules(count int) []Schedule {
+	schedules := make([]Schedule, count)
+	for i := range schedules {
+		schedules[i] = Schedule{
+			ID:        i + 1,
+			Medicine:  fmt.Sprintf("medicine-%d", i),
+			Frequency: i % 30,
+			Duration:  1 + i%6,
+			Status:    "active",
+			CreatedAt: benchmarkNow.AddDate(0, 0, -(i % 20)),
+		}
+	}
+
+	return schedules
+}
+
+func benchmarkCalculateTime(doses int) func(b *testing.B) {
+	return func(b *testing.B) {
+		schedule := Schedule{Medicine: "medicine", Duration: doses}
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			CalculateTime(schedule, benchmarkNow)
+		}
+	}
+}
+
+func benchmarkNextTakings(count int, loc *time.Location) func(b *testing.B) {
+	return func(b *testing.B) {
+		schedules := benchmarkSchedules(count)
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			NextTakings(schedules, benchmarkNow, loc)
+		}
+	}
+}
+
+func doseExpansionBenchmarks() map[string]func(b *testing.B) {
+	jakarta, err := time.LoadLocation("Asia/Jakarta")
+	if err != nil {
+		jakarta = time.FixedZone("WIB", 7*60*60)
+	}
+
+	return map[string]func(b *testing.B){
+		"CalculateTime/doses=4":          benchmarkCalculateTime(4),
+		"CalculateTime/doses=24":         benchmarkCalculateTime(24),
+		"NextTakings/schedules=10":       benchmarkNextTakings(10, time.UTC),
+		"NextTakings/schedules=500":      benchmarkNextTakings(500, time.UTC),
+		"NextTakings/schedules=500/Asia": benchmarkNextTakings(500, jakarta),
+	}
+}
+
+func BenchmarkCalculateTime(b *testing.B) {
+	b.Run("doses=4", benchmarkCalculateTime(4))
+	b.Run("doses=24", benchmarkCalculateTime(24))
+}
+
+func BenchmarkNextTakings(b *testing.B) {
+	benchmarks := doseExpansionBenchmarks()
+	b.Run("schedules=10", benchmarks["NextTakings/schedules=10"])
+	b.Run("schedules=500", benchmarks["NextTakings/schedules=500"])
+	b.Run("schedules=500/Asia", benchmarks["NextTakings/schedules=500/Asia"])
+}
+
+func TestDoseExpansionBudget(t *testing.T) {
+	if os.Getenv("PERF_BUDGET") != "1" {
+		t.Skip("perf budget is only checked with PERF_BUDGET=1")
+	}
+
+	for name, benchmark := range doseExpansionBenchmarks() {
+		budget, ok := doseExpansionBudgets[name]
+		if !ok {
+			t.Errorf("%s has no perf budget", name)
+			continue
+		}
+
+		result := testing.Benchmark(benchmark)
+		perOp := time.Duration(result.NsPerOp())
+		if perOp > budget {
+			t.Errorf("%s takes %s per call, budget is %s", name, perOp, budget)
+		}
+	}
+}
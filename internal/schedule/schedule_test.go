@@ -0,0 +1,403 @@
+package schedule
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDosesDue(t *testing.T) {
+	loc, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip("no timezone data")
+	}
+	start := time.Date(2026, 10, 12, 9, 0, 0, 0, loc)
+	s := Schedule{Frequency: 5, Duration: 3, CreatedAt: start}
+
+	tests := []struct {
+		name     string
+		from, to time.Time
+		want     int
+	}{
+		{"whole course", start.AddDate(0, 0, -3), start.AddDate(0, 0, 10), 15},
+		{"before the course", start.AddDate(0, 0, -10), start.AddDate(0, 0, -3), 0},
+		{"first day until the afternoon", time.Date(2026, 10, 12, 0, 0, 0, 0, loc), time.Date(2026, 10, 12, 16, 0, 0, 0, loc), 2},
+		{"last two days", time.Date(2026, 10, 15, 0, 0, 0, 0, loc), time.Date(2026, 10, 20, 0, 0, 0, 0, loc), 6},
+	}
+
+	for _, test := range tests {
+		if got := DosesDue(s, test.from, test.to, loc); got != test.want {
+			t.Errorf("%s: DosesDue = %d, want %d", test.name, got, test.want)
+		}
+	}
+}
+
+func TestShiftedDoseTimes(t *testing.T) {
+	s := Schedule{Duration: 3}
+	shift := &TimeShift{FromTimezone: "UTC", From: DefaultWindow, StartDate: "2026-10-16", MaxMinutesPerDay: 30}
+	window := Window{Wake: "06:00", Sleep: "20:00"}
+
+	tests := []struct {
+		date string
+		want []string
+	}{
+		{"2026-10-15", []string{"06:00", "13:00", "20:00"}},
+		{"2026-10-16", []string{"07:30", "14:30", "21:30"}},
+		{"2026-10-18", []string{"06:30", "13:30", "20:30"}},
+		{"2026-10-19", []string{"06:00", "13:00", "20:00"}},
+	}
+
+	for _, test := range tests {
+		now, _ := time.Parse("2006-01-02", test.date)
+		doses := ShiftedDoseTimes(s, now.Add(12*time.Hour), window, shift)
+		for i, dose := range doses {
+			if got := dose.Format("15:04"); got != test.want[i] {
+				t.Errorf("%s: dose %d at %s, want %s", test.date, i+1, got, test.want[i])
+			}
+		}
+	}
+}
+
+func TestVariantsWindow(t *testing.T) {
+	variants := Variants{Weekend: &Window{Wake: "10:00", Sleep: "23:00"}, Holiday: &Window{Wake: "11:00", Sleep: "23:00"}}
+	holidays := []string{"2026-12-25"}
+
+	tests := []struct {
+		date string
+		want string
+	}{
+		{"2026-10-16", "08:00"},
+		{"2026-10-17", "10:00"},
+		{"2026-10-18", "10:00"},
+		{"2026-12-25", "11:00"},
+	}
+
+	for _, test := range tests {
+		day, _ := time.Parse("2006-01-02", test.date)
+		if got := variants.Window(day, DefaultWindow, holidays).Wake; got != test.want {
+			t.Errorf("%s: wake at %s, want %s", test.date, got, test.want)
+		}
+	}
+
+	if got := (Variants{}).Window(time.Date(2026, 10, 17, 0, 0, 0, 0, time.UTC), DefaultWindow, nil); got != DefaultWindow {
+		t.Errorf("no variants on a Saturday = %+v, want the default window", got)
+	}
+}
+
+func TestVariantsShortest(t *testing.T) {
+	base := Window{Wake: "07:00", Sleep: "23:00"}
+	tests := []struct {
+		variants Variants
+		want     time.Duration
+	}{
+		{Variants{}, 16 * time.Hour},
+		{Variants{Weekend: &Window{Wake: "10:00", Sleep: "22:00"}}, 12 * time.Hour},
+		{Variants{Weekend: &Window{Wake: "09:00", Sleep: "23:00"}, Holiday: &Window{Wake: "11:00", Sleep: "21:00"}}, 10 * time.Hour},
+		// an invalid variant counts as the default window
+		{Variants{Holiday: &Window{Wake: "late", Sleep: "23:00"}}, 14 * time.Hour},
+	}
+	for _, test := range tests {
+		if got := test.variants.Shortest(base).Length(); got != test.want {
+			t.Errorf("%+v: shortest window %v, want %v", test.variants, got, test.want)
+		}
+	}
+}
+
+func TestNormalizeTags(t *testing.T) {
+	tags, err := NormalizeTags([]string{" Heart", "trial-X", "heart", "vitamin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"heart", "trial-x", "vitamin"}; !slices.Equal(tags, want) {
+		t.Errorf("NormalizeTags = %q, want %q", tags, want)
+	}
+
+	for _, invalid := range []string{"", "-heart", "a,b", strings.Repeat("x", 33)} {
+		if _, err := NormalizeTags([]string{invalid}); !errors.Is(err, ErrValidation) {
+			t.Errorf("NormalizeTags(%q) = %v, want a validation error", invalid, err)
+		}
+	}
+}
+
+func TestNormalizeAppearance(t *testing.T) {
+	s := Schedule{Color: "#A0B1C2", Icon: "inhaler"}
+	if err := NormalizeAppearance(&s); err != nil || s.Color != "#a0b1c2" {
+		t.Errorf("NormalizeAppearance = %v with color %q, want #a0b1c2", err, s.Color)
+	}
+
+	for _, invalid := range []Schedule{{Color: "red"}, {Color: "#abc"}, {Icon: "rocket"}} {
+		if err := NormalizeAppearance(&invalid); !errors.Is(err, ErrValidation) {
+			t.Errorf("NormalizeAppearance(%+v) = %v, want a validation error", invalid, err)
+		}
+	}
+}
+
+func TestSanitizeInstructions(t *testing.T) {
+	tests := []struct {
+		in, want, plain string
+	}{
+		{"Take with a **full** glass of water", "Take with a **full** glass of water", "Take with a full glass of water"},
+		{"<script>alert(1)</script>Before <b>food</b>", "alert(1)Before food", "alert(1)Before food"},
+		{"Less than 2 if < 60 kg", "Less than 2 if < 60 kg", "Less than 2 if < 60 kg"},
+		{"See ![chart](https://tracker.example/x.png)", "See chart", "See chart"},
+		{"[leaflet](https://example.org/leaflet) or [this](javascript:steal)", "[leaflet](https://example.org/leaflet) or this", "leaflet (https://example.org/leaflet) or this"},
+		{"line one\r\nline\x00 two", "line one\nline two", "line one\nline two"},
+	}
+
+	for _, test := range tests {
+		got, err := SanitizeInstructions(test.in)
+		if err != nil || got != test.want {
+			t.Errorf("SanitizeInstructions(%q) = %q, %v, want %q", test.in, got, err, test.want)
+		}
+		if plain := PlainInstructions(got); plain != test.plain {
+			t.Errorf("PlainInstructions(%q) = %q, want %q", got, plain, test.plain)
+		}
+	}
+
+	if _, err := SanitizeInstructions(strings.Repeat("x", 1001)); !errors.Is(err, ErrValidation) {
+		t.Errorf("SanitizeInstructions of 1001 characters = %v, want a validation error", err)
+	}
+}
+
+func TestMatchPill(t *testing.T) {
+	record := Pill{Shape: "oval", Color: "white", Imprint: "AB 12"}
+
+	tests := []struct {
+		name    string
+		seen    Pill
+		matched []string
+		ok      bool
+	}{
+		{"everything", Pill{Shape: "oval", Color: "white", Imprint: "ab-12"}, []string{"imprint", "shape", "color"}, true},
+		{"only the imprint", Pill{Imprint: "AB12"}, []string{"imprint"}, true},
+		{"other color", Pill{Imprint: "AB 12", Color: "blue"}, nil, false},
+		{"nothing to compare", Pill{}, nil, false},
+	}
+
+	for _, test := range tests {
+		matched, ok := MatchPill(record, test.seen)
+		if ok != test.ok || !slices.Equal(matched, test.matched) {
+			t.Errorf("%s: MatchPill = %q, %t, want %q, %t", test.name, matched, ok, test.matched, test.ok)
+		}
+	}
+}
+
+func TestWeightDoseRange(t *testing.T) {
+	maxPerKg, capMg := 15.0, 500.0
+	rule := WeightRule{MinMgPerKg: 10, MaxMgPerKg: &maxPerKg, CapMg: &capMg}
+
+	tests := []struct {
+		weightKg  float64
+		low, high float64
+	}{
+		{12, 120, 180},
+		{40, 400, 500},
+		{80, 500, 500},
+	}
+
+	for _, test := range tests {
+		if low, high := WeightDoseRange(rule, test.weightKg); low != test.low || high != test.high {
+			t.Errorf("WeightDoseRange for %.0f kg = %.0f to %.0f, want %.0f to %.0f", test.weightKg, low, high, test.low, test.high)
+		}
+	}
+}
+
+func TestConvert(t *testing.T) {
+	tablets := &Amount{Value: 500, Unit: "mg/tablet"}
+	syrup := &Amount{Value: 50, Unit: "mg/ml"}
+
+	tests := []struct {
+		amount   Amount
+		to       string
+		strength *Amount
+		want     float64
+	}{
+		{Amount{Value: 1.5, Unit: "g"}, "mg", nil, 1500},
+		{Amount{Value: 250, Unit: "mcg"}, "mg", nil, 0.25},
+		{Amount{Value: 2, Unit: "tsp"}, "ml", nil, 10},
+		{Amount{Value: 1, Unit: "g"}, "tablet", tablets, 2},
+		{Amount{Value: 0.5, Unit: "tablet"}, "mg", tablets, 250},
+		{Amount{Value: 2, Unit: "tsp"}, "mg", syrup, 500},
+		{Amount{Value: 250, Unit: "mg"}, "tsp", syrup, 1},
+	}
+
+	for _, test := range tests {
+		got, err := Convert(test.amount, test.to, test.strength)
+		if err != nil || got.Value != test.want {
+			t.Errorf("Convert(%v, %s) = %v, %v, want %g", test.amount, test.to, got, err, test.want)
+		}
+	}
+
+	if _, err := Convert(Amount{Value: 1, Unit: "tablet"}, "mg", nil); !errors.Is(err, ErrValidation) {
+		t.Errorf("Convert without strength = %v, want a validation error", err)
+	}
+	if got := FormatDose(Amount{Value: 2, Unit: "tsp"}, syrup); got != "2 tsp (10 ml, 500 mg)" {
+		t.Errorf("FormatDose = %q", got)
+	}
+	if got := FormatDose(Amount{Value: 1000, Unit: "mg"}, tablets); got != "1000 mg (2 tablets)" {
+		t.Errorf("FormatDose = %q", got)
+	}
+}
+
+func TestDoseTiming(t *testing.T) {
+	minutes := func(values ...float64) []time.Duration {
+		delays := []time.Duration{}
+		for _, value := range values {
+			delays = append(delays, time.Duration(value*float64(time.Minute)))
+		}
+		return delays
+	}
+
+	timing := DoseTiming(minutes(-20, -15, 0, 14.5, 15, 45, 90, 180, 5, 10), time.Hour)
+	if timing.Intakes != 10 || timing.P50DelayMinutes != 10 || timing.P95DelayMinutes != 180 || timing.MeanDelayMinutes != 32.45 {
+		t.Errorf("DoseTiming = %+v", timing)
+	}
+	if timing.OnTimeMinutes != 60 || timing.OnTime != 8 || timing.Early != 0 || timing.Late != 2 {
+		t.Errorf("DoseTiming on time = %d, early %d, late %d within %d minutes", timing.OnTime, timing.Early, timing.Late, timing.OnTimeMinutes)
+	}
+	counts := []int{}
+	for _, bucket := range timing.Distribution {
+		counts = append(counts, bucket.Count)
+	}
+	if !slices.Equal(counts, []int{1, 5, 1, 1, 1, 1}) {
+		t.Errorf("distribution = %v, want [1 5 1 1 1 1]", counts)
+	}
+
+	if empty := DoseTiming(nil, time.Hour); empty.Intakes != 0 || empty.P95DelayMinutes != 0 || len(empty.Distribution) != 6 {
+		t.Errorf("DoseTiming(nil) = %+v", empty)
+	}
+}
+
+func TestUnitsPerDose(t *testing.T) {
+	tablets := &Amount{Value: 500, Unit: "mg/tablet"}
+	syrup := &Amount{Value: 50, Unit: "mg/ml"}
+	tests := []struct {
+		dose     *Amount
+		strength *Amount
+		want     float64
+	}{
+		{nil, nil, 1},
+		{&Amount{Value: 2, Unit: "tablet"}, nil, 2},
+		{&Amount{Value: 1, Unit: "g"}, tablets, 2},
+		{&Amount{Value: 500, Unit: "mg"}, nil, 1},
+		{&Amount{Value: 250, Unit: "mg"}, syrup, 1},
+	}
+
+	for _, test := range tests {
+		if got := UnitsPerDose(Schedule{Dose: test.dose, Strength: test.strength}); got != test.want {
+			t.Errorf("UnitsPerDose(%v, %v) = %g, want %g", test.dose, test.strength, got, test.want)
+		}
+	}
+}
+
+func TestValidateScheduleFields(t *testing.T) {
+	err := JoinFields(ValidateSchedule(Schedule{Frequency: -1, Duration: 0, Status: "active"}), NormalizeAppearance(&Schedule{Color: "red"}))
+	if !errors.Is(err, ErrValidation) {
+		t.Fatalf("expected a validation error, got %v", err)
+	}
+	var got []string
+	for _, field := range Fields(err) {
+		got = append(got, field.Field+":"+field.Rule)
+	}
+	want := []string{"medicine:required", "frequency:min", "duration:min", "color:format"}
+	if !slices.Equal(got, want) {
+		t.Errorf("fields = %v, want %v", got, want)
+	}
+
+	if err := ValidateSchedule(Schedule{Medicine: "Aspirin", Duration: 1, Status: "active"}); err != nil {
+		t.Errorf("valid schedule rejected: %v", err)
+	}
+	if plain := Errorf(ErrNotFound, "schedule not found"); JoinFields(nil, plain) != plain || Fields(plain) != nil {
+		t.Errorf("errors without fields must pass through unchanged")
+	}
+}
+
+func TestCheckDayAcrossTimezones(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip("no timezone data")
+	}
+	newYork, _ := time.LoadLocation("America/New_York")
+
+	// 23:30 UTC is already the next day in Berlin but still the same day in New York
+	twoDays := Schedule{Frequency: 2, CreatedAt: time.Date(2026, 10, 12, 23, 30, 0, 0, time.UTC)}
+	// the course spans the end of daylight saving time in Berlin on October 25
+	overDST := Schedule{Frequency: 2, CreatedAt: time.Date(2026, 10, 24, 10, 0, 0, 0, berlin)}
+	endless := Schedule{CreatedAt: twoDays.CreatedAt}
+
+	tests := []struct {
+		name     string
+		schedule Schedule
+		now      time.Time
+		loc      *time.Location
+		want     bool
+	}{
+		{"Berlin before the start day", twoDays, time.Date(2026, 10, 12, 21, 0, 0, 0, time.UTC), berlin, false},
+		{"Berlin on the start day", twoDays, time.Date(2026, 10, 12, 23, 45, 0, 0, time.UTC), berlin, true},
+		{"Berlin last minute of the course", twoDays, time.Date(2026, 10, 14, 21, 59, 0, 0, time.UTC), berlin, true},
+		{"Berlin after the course", twoDays, time.Date(2026, 10, 14, 22, 0, 0, 0, time.UTC), berlin, false},
+		{"New York on the start day", twoDays, time.Date(2026, 10, 12, 23, 45, 0, 0, time.UTC), newYork, true},
+		{"New York last minute of the course", twoDays, time.Date(2026, 10, 14, 3, 59, 0, 0, time.UTC), newYork, true},
+		{"New York after the course", twoDays, time.Date(2026, 10, 14, 4, 0, 0, 0, time.UTC), newYork, false},
+		{"UTC after the course", twoDays, time.Date(2026, 10, 14, 0, 0, 0, 0, time.UTC), time.UTC, false},
+		{"last day after the clocks go back", overDST, time.Date(2026, 10, 25, 22, 30, 0, 0, time.UTC), berlin, true},
+		{"day after the clocks went back", overDST, time.Date(2026, 10, 25, 23, 30, 0, 0, time.UTC), berlin, false},
+		{"endless course before the start", endless, time.Date(2026, 10, 12, 21, 0, 0, 0, time.UTC), berlin, false},
+		{"endless course years later", endless, time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC), newYork, true},
+	}
+
+	for _, test := range tests {
+		if got := CheckDay(test.schedule, test.now, test.loc); got != test.want {
+			t.Errorf("%s: CheckDay = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestNextTakingsInUserTimezone(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip("no timezone data")
+	}
+	newYork, _ := time.LoadLocation("America/New_York")
+	s := Schedule{ID: 1, Medicine: "Amoxicillin", Frequency: 1, Duration: 3, CreatedAt: time.Date(2026, 10, 12, 6, 0, 0, 0, time.UTC)}
+
+	tests := []struct {
+		name string
+		now  time.Time
+		loc  *time.Location
+		want []string
+	}{
+		{"afternoon dose in Berlin", time.Date(2026, 10, 12, 12, 30, 0, 0, time.UTC), berlin, []string{"1-20261012-2 15:00"}},
+		{"nothing due soon in New York", time.Date(2026, 10, 12, 12, 30, 0, 0, time.UTC), newYork, nil},
+		{"evening dose in New York after midnight UTC", time.Date(2026, 10, 13, 1, 30, 0, 0, time.UTC), newYork, []string{"1-20261012-3 22:00"}},
+		{"course over in Berlin", time.Date(2026, 10, 13, 1, 30, 0, 0, time.UTC), berlin, nil},
+	}
+
+	for _, test := range tests {
+		var got []string
+		for _, taking := range NextTakings([]Schedule{s}, test.now, test.loc) {
+			got = append(got, taking.DoseID+" "+taking.TakeTime)
+		}
+		if !slices.Equal(got, test.want) {
+			t.Errorf("%s: NextTakings = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestVariantsValidateFields(t *testing.T) {
+	variants := Variants{Weekend: &Window{Wake: "9", Sleep: "23:00"}, Holiday: &Window{Wake: "10:00", Sleep: "12:00"}}
+	var got []string
+	for _, field := range Fields(variants.Validate()) {
+		got = append(got, field.Field+":"+field.Rule)
+	}
+	want := []string{"weekend.wake:format", "holiday.sleep:min"}
+	if !slices.Equal(got, want) {
+		t.Errorf("fields = %v, want %v", got, want)
+	}
+
+	if err := (Variants{Weekend: &Window{Wake: "10:00", Sleep: "23:00"}}).Validate(); err != nil {
+		t.Errorf("valid variants rejected: %v", err)
+	}
+}
@@ -0,0 +1,32 @@
+package schedule
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+const maxTags = 20
+
+var tagPattern = regexp.MustCompile(`^[\p{L}\p{N}][\p{L}\p{N} _.-]{0,31}$`)
+
+// tags in lower case without duplicates and sorted, so "Heart" and "heart " group together
+func NormalizeTags(tags []string) ([]string, error) {
+	normalized := []string{}
+	for i, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if !tagPattern.MatchString(tag) {
+			return nil, FieldErrorf(fmt.Sprintf("tags[%d]", i), "format", "invalid tag %q, tags are 1 to 32 letters, digits, spaces, dots, dashes or underscores", tag)
+		}
+		if !slices.Contains(normalized, tag) {
+			normalized = append(normalized, tag)
+		}
+	}
+	if len(normalized) > maxTags {
+		return nil, FieldErrorf("tags", "max_items", "a schedule can have at most %d tags", maxTags)
+	}
+	slices.Sort(normalized)
+
+	return normalized, nil
+}
@@ -0,0 +1,86 @@
+package schedule
+
+import (
+	"math"
+	"slices"
+	"time"
+)
+
+// how far intakes are from their planned time, for drugs where timing matters as much as the dose
+type Timing struct {
+	Intakes          int     `json:"intakes"`
+	P50DelayMinutes  float64 `json:"p50_delay_minutes"`
+	P95DelayMinutes  float64 `json:"p95_delay_minutes"`
+	MeanDelayMinutes float64 `json:"mean_delay_minutes"`
+	// within the on time window of the user, before or after it
+	OnTimeMinutes int            `json:"on_time_minutes"`
+	OnTime        int            `json:"on_time"`
+	Early         int            `json:"early"`
+	Late          int            `json:"late"`
+	Distribution  []TimingBucket `json:"distribution"`
+}
+
+// intakes with a delay from From up to but not including To, open ended without one of them
+type TimingBucket struct {
+	Label       string `json:"label"`
+	FromMinutes *int   `json:"from_minutes,omitempty"`
+	ToMinutes   *int   `json:"to_minutes,omitempty"`
+	Count       int    `json:"count"`
+}
+
+// the bounds of the distribution in minutes, negative delays are early intakes
+var timingBounds = []int{-15, 15, 30, 60, 120}
+
+var timingLabels = []string{"over 15 min early", "within 15 min", "15-30 min late", "30-60 min late", "1-2 h late", "over 2 h late"}
+
+// the timing of intakes by their delays, the intake time minus the planned time.
+// A delay up to onTime either way is on time.
+func DoseTiming(delays []time.Duration, onTime time.Duration) Timing {
+	timing := Timing{Intakes: len(delays), OnTimeMinutes: int(onTime.Minutes()), Distribution: make([]TimingBucket, len(timingLabels))}
+	for i := range timing.Distribution {
+		bucket := &timing.Distribution[i]
+		bucket.Label = timingLabels[i]
+		if i > 0 {
+			bucket.FromMinutes = &timingBounds[i-1]
+		}
+		if i < len(timingBounds) {
+			bucket.ToMinutes = &timingBounds[i]
+		}
+	}
+	if len(delays) == 0 {
+		return timing
+	}
+
+	sorted := slices.Clone(delays)
+	slices.Sort(sorted)
+	var total time.Duration
+	for _, delay := range sorted {
+		total += delay
+		switch {
+		case delay < -onTime:
+			timing.Early++
+		case delay > onTime:
+			timing.Late++
+		default:
+			timing.OnTime++
+		}
+		// the number of bounds at or below the delay
+		bucket, _ := slices.BinarySearch(timingBounds, int(math.Floor(delay.Minutes())+1))
+		timing.Distribution[bucket].Count++
+	}
+	timing.P50DelayMinutes = DelayPercentile(sorted, 50).Minutes()
+	timing.P95DelayMinutes = DelayPercentile(sorted, 95).Minutes()
+	timing.MeanDelayMinutes = (total / time.Duration(len(sorted))).Minutes()
+
+	return timing
+}
+
+// the nearest rank percentile p of sorted delays
+func DelayPercentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+
+	return sorted[max(rank, 1)-1]
+}
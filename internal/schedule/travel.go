@@ -0,0 +1,96 @@
+package schedule
+
+import (
+	"fmt"
+	"slices"
+	"time"
+)
+
+// the longest trip, gradual shifts are worked out day by day from its start
+const maxTripDays = 365
+
+// a trip during which doses follow the destination's clock instead of home's
+type Travel struct {
+	Timezone string `json:"timezone"`
+	// calendar days in the home timezone, both included
+	StartDate string `json:"start_date"`
+	EndDate   string `json:"end_date"`
+	// moves doses by at most an hour a day, to the destination and back home after the trip,
+	// instead of switching to the destination's clock on the first day
+	Gradual bool `json:"gradual"`
+	// schedules that need a strict interval between doses stay on home time
+	KeepAbsolute []int `json:"keep_absolute"`
+}
+
+func (t Travel) Validate() error {
+	if _, err := time.LoadLocation(t.Timezone); err != nil || t.Timezone == "" {
+		return FieldErrorf("timezone", "enum", "invalid timezone: %s", t.Timezone)
+	}
+	start, errStart := time.Parse("2006-01-02", t.StartDate)
+	end, errEnd := time.Parse("2006-01-02", t.EndDate)
+	if errStart != nil || errEnd != nil {
+		var errs []error
+		if errStart != nil {
+			errs = append(errs, FieldErrorf("start_date", "format", "start_date must be YYYY-MM-DD"))
+		}
+		if errEnd != nil {
+			errs = append(errs, FieldErrorf("end_date", "format", "end_date must be YYYY-MM-DD"))
+		}
+		return JoinFields(errs...)
+	}
+	if end.Before(start) {
+		return FieldErrorf("end_date", "min", "end_date must be after start_date and the trip at most %d days", maxTripDays)
+	}
+	if end.Sub(start) > maxTripDays*24*time.Hour {
+		return FieldErrorf("end_date", "max", "end_date must be after start_date and the trip at most %d days", maxTripDays)
+	}
+
+	return nil
+}
+
+// the location the doses of a schedule are planned in on day, a calendar day in the home
+// timezone as LocalDate returns it. Outside the trip that is home.
+func (t *Travel) Location(home *time.Location, scheduleID int, day time.Time) *time.Location {
+	if t == nil || slices.Contains(t.KeepAbsolute, scheduleID) {
+		return home
+	}
+	dest, err := time.LoadLocation(t.Timezone)
+	start, errStart := time.Parse("2006-01-02", t.StartDate)
+	end, errEnd := time.Parse("2006-01-02", t.EndDate)
+	if err != nil || errStart != nil || errEnd != nil || day.Before(start) {
+		return home
+	}
+	if !t.Gradual {
+		if day.After(end) {
+			return home
+		}
+		return dest
+	}
+
+	// the offsets at noon of the day, so a daylight saving change during the trip is followed
+	noon := day.Add(12 * time.Hour)
+	_, homeOffset := noon.In(home).Zone()
+	_, destOffset := noon.In(dest).Zone()
+	difference := destOffset - homeOffset
+	// no gap of two timezones is more than 26 hours, the way back is over after as many days
+	if day.After(end.AddDate(0, 0, 27)) {
+		return home
+	}
+
+	shift := 0
+	for d := start; !d.After(day); d = d.AddDate(0, 0, 1) {
+		target := difference
+		if d.After(end) {
+			target = 0
+		}
+		shift += max(min(target-shift, 3600), -3600)
+	}
+
+	switch {
+	case shift == 0:
+		return home
+	case shift == difference && !day.After(end):
+		return dest
+	}
+	return time.FixedZone(fmt.Sprintf("%s%+.1fh", home.String(), float64(shift)/3600), homeOffset+shift)
+}
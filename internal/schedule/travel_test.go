@@ -0,0 +1,53 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTravelLocation(t *testing.T) {
+	home, _ := time.LoadLocation("Europe/Berlin")
+	travel := &Travel{Timezone: "America/New_York", StartDate: "2026-07-01", EndDate: "2026-07-10", KeepAbsolute: []int{7}}
+
+	day := func(date string) time.Time {
+		d, _ := time.Parse("2006-01-02", date)
+		return d
+	}
+	// the first dose of the day at 8:00 in the location of the day, as home time
+	firstDose := func(loc *time.Location, date string) string {
+		d := day(date)
+		return time.Date(d.Year(), d.Month(), d.Day(), 8, 0, 0, 0, loc).In(home).Format("15:04")
+	}
+
+	tests := []struct {
+		gradual    bool
+		scheduleID int
+		date       string
+		want       string
+	}{
+		{false, 1, "2026-06-30", "08:00"},
+		{false, 1, "2026-07-01", "14:00"},
+		{false, 1, "2026-07-10", "14:00"},
+		{false, 1, "2026-07-11", "08:00"},
+		{false, 7, "2026-07-05", "08:00"},
+		{true, 1, "2026-07-01", "09:00"},
+		{true, 1, "2026-07-03", "11:00"},
+		{true, 1, "2026-07-08", "14:00"},
+		{true, 1, "2026-07-11", "13:00"},
+		{true, 1, "2026-07-16", "08:00"},
+		{true, 7, "2026-07-05", "08:00"},
+	}
+
+	for _, test := range tests {
+		travel.Gradual = test.gradual
+		loc := travel.Location(home, test.scheduleID, day(test.date))
+		if got := firstDose(loc, test.date); got != test.want {
+			t.Errorf("gradual %t, schedule %d, %s: first dose at %s home time, want %s", test.gradual, test.scheduleID, test.date, got, test.want)
+		}
+	}
+
+	var none *Travel
+	if loc := none.Location(home, 1, day("2026-07-05")); loc != home {
+		t.Errorf("no travel plans in %s, want home", loc)
+	}
+}
@@ -0,0 +1,135 @@
+package schedule
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+type unit struct {
+	// units of one dimension convert into each other by their factors
+	dimension string
+	factor    float64
+}
+
+// mass in mg, volume in ml, the rest are counted
+var units = map[string]unit{
+	"mcg":     {"mass", 0.001},
+	"mg":      {"mass", 1},
+	"g":       {"mass", 1000},
+	"ml":      {"volume", 1},
+	"l":       {"volume", 1000},
+	"tsp":     {"volume", 5},
+	"tbsp":    {"volume", 15},
+	"units":   {"units", 1},
+	"tablet":  {"tablet", 1},
+	"capsule": {"capsule", 1},
+	"puff":    {"puff", 1},
+	"drop":    {"drop", 1},
+}
+
+func ValidUnit(name string) bool {
+	_, ok := units[name]
+	return ok
+}
+
+// a strength like 500 mg/tablet or 50 mg/ml, what one of the second unit holds of the first
+func ValidStrength(strength Amount) bool {
+	of, per, ok := strings.Cut(strength.Unit, "/")
+	return ok && strength.Value > 0 && ValidUnit(of) && ValidUnit(per) && units[of].dimension != units[per].dimension
+}
+
+// the amount in another unit. Units of different dimensions convert through the strength,
+// 2 tablet of 500 mg/tablet is 1 g, 10 ml of 50 mg/ml is 500 mg. The strength may be nil.
+func Convert(amount Amount, to string, strength *Amount) (Amount, error) {
+	from, ok := units[amount.Unit]
+	if !ok {
+		return Amount{}, Errorf(ErrValidation, "unknown unit %q", amount.Unit)
+	}
+	target, ok := units[to]
+	if !ok {
+		return Amount{}, Errorf(ErrValidation, "unknown unit %q", to)
+	}
+	if from.dimension == target.dimension {
+		return Amount{Value: round(amount.Value * from.factor / target.factor), Unit: to}, nil
+	}
+
+	if strength != nil && ValidStrength(*strength) {
+		ofName, perName, _ := strings.Cut(strength.Unit, "/")
+		of, per := units[ofName], units[perName]
+		switch {
+		case from.dimension == of.dimension && target.dimension == per.dimension:
+			// how many of the per unit hold the amount
+			count := amount.Value * from.factor / of.factor / strength.Value
+			return Amount{Value: round(count * per.factor / target.factor), Unit: to}, nil
+		case from.dimension == per.dimension && target.dimension == of.dimension:
+			held := amount.Value * from.factor / per.factor * strength.Value
+			return Amount{Value: round(held * of.factor / target.factor), Unit: to}, nil
+		}
+	}
+
+	return Amount{}, Errorf(ErrValidation, "can not convert %s to %s without a matching strength", amount.Unit, to)
+}
+
+// how many dispensed units, like tablets, one dose uses. A dose in mass or volume is counted
+// through the strength when it is per a counted unit, doses without a count use one unit.
+func UnitsPerDose(s Schedule) float64 {
+	if s.Dose == nil {
+		return 1
+	}
+	if dimension := units[s.Dose.Unit].dimension; dimension != "mass" && dimension != "volume" {
+		return s.Dose.Value
+	}
+	if s.Strength != nil && ValidStrength(*s.Strength) {
+		_, per, _ := strings.Cut(s.Strength.Unit, "/")
+		if dimension := units[per].dimension; dimension != "mass" && dimension != "volume" {
+			count, err := Convert(*s.Dose, per, s.Strength)
+			if err == nil {
+				return count.Value
+			}
+		}
+	}
+
+	return 1
+}
+
+// three decimals are enough for any dose and keep 0.1+0.2 from showing
+func round(value float64) float64 {
+	return math.Round(value*1000) / 1000
+}
+
+func (a Amount) String() string {
+	text := strconv.FormatFloat(a.Value, 'f', -1, 64) + " " + a.Unit
+	// counted forms are written in plural, 2 tablets
+	if a.Value != 1 && a.Unit != "units" && units[a.Unit].dimension == a.Unit {
+		text += "s"
+	}
+
+	return text
+}
+
+// the dose as written, with what it is in the other unit of the strength, "2 tsp (10 ml, 500 mg)".
+// Kitchen measures show their ml so they can be measured with a syringe too.
+func FormatDose(dose Amount, strength *Amount) string {
+	var equivalents []string
+	if dose.Unit == "tsp" || dose.Unit == "tbsp" {
+		ml, _ := Convert(dose, "ml", nil)
+		equivalents = append(equivalents, ml.String())
+	}
+	if strength != nil && ValidStrength(*strength) {
+		ofName, perName, _ := strings.Cut(strength.Unit, "/")
+		other := ofName
+		if units[dose.Unit].dimension == units[ofName].dimension {
+			other = perName
+		}
+		if converted, err := Convert(dose, other, strength); err == nil {
+			equivalents = append(equivalents, converted.String())
+		}
+	}
+
+	if len(equivalents) == 0 {
+		return dose.String()
+	}
+	return fmt.Sprintf("%s (%s)", dose, strings.Join(equivalents, ", "))
+}
@@ -0,0 +1,171 @@
+package schedule
+
+import (
+	"slices"
+	"time"
+)
+
+// the part of the day doses are spread over, HH:MM in the user's timezone
+type Window struct {
+	Wake  string `json:"wake"`
+	Sleep string `json:"sleep"`
+}
+
+var DefaultWindow = Window{Wake: "08:00", Sleep: "22:00"}
+
+func (w Window) Validate() error {
+	wake, errWake := time.Parse("15:04", w.Wake)
+	sleep, errSleep := time.Parse("15:04", w.Sleep)
+	if errWake != nil || errSleep != nil {
+		var errs []error
+		if errWake != nil {
+			errs = append(errs, FieldErrorf("wake", "format", "wake must be HH:MM"))
+		}
+		if errSleep != nil {
+			errs = append(errs, FieldErrorf("sleep", "format", "sleep must be HH:MM"))
+		}
+		return JoinFields(errs...)
+	}
+	if sleep.Sub(wake) < 4*time.Hour {
+		return FieldErrorf("sleep", "min", "sleep must be at least 4 hours after wake on the same day")
+	}
+
+	return nil
+}
+
+// wake and sleep on the day of now, an invalid window is the default one
+func (w Window) on(now time.Time) (time.Time, time.Time) {
+	if w.Validate() != nil {
+		w = DefaultWindow
+	}
+	wake, _ := time.Parse("15:04", w.Wake)
+	sleep, _ := time.Parse("15:04", w.Sleep)
+	year, month, day := now.Date()
+
+	return time.Date(year, month, day, wake.Hour(), wake.Minute(), 0, 0, now.Location()),
+		time.Date(year, month, day, sleep.Hour(), sleep.Minute(), 0, 0, now.Location())
+}
+
+// the length of the window, an invalid window is the default one
+func (w Window) Length() time.Duration {
+	wake, sleep := w.on(time.Time{})
+	return sleep.Sub(wake)
+}
+
+// the times of the doses on the day of now, spread over the window in the timezone of now
+func DoseTimesIn(schedule Schedule, now time.Time, window Window) []time.Time {
+	startTime, endTime := window.on(now)
+
+	totalMinutes := int(endTime.Sub(startTime).Minutes())
+	intervalDuration := 0
+	if schedule.Duration > 1 {
+		intervalDuration = totalMinutes / (schedule.Duration - 1)
+	}
+
+	doses := make([]time.Time, schedule.Duration)
+	currentTime := startTime
+
+	for i := 0; i < schedule.Duration; i++ {
+		minutes := currentTime.Minute()
+		if minutes%15 != 0 {
+			minutes = ((minutes / 15) + 1) * 15
+		}
+		roundedTime := time.Date(currentTime.Year(), currentTime.Month(), currentTime.Day(), currentTime.Hour(), minutes, 0, 0, currentTime.Location())
+		doses[i] = roundedTime
+		currentTime = currentTime.Add(time.Duration(intervalDuration) * time.Minute)
+	}
+
+	return doses
+}
+
+// a change of the waking window or timezone that doses follow a few minutes a day
+// instead of jumping to the new times
+type TimeShift struct {
+	FromTimezone string `json:"from_timezone"`
+	From         Window `json:"from"`
+	// the day of the change in the new timezone, doses move for the first time on it
+	StartDate        string `json:"start_date"`
+	MaxMinutesPerDay int    `json:"max_minutes_per_day"`
+}
+
+// the doses on the day of now in the window, moved from their times before the shift
+// by at most MaxMinutesPerDay for every day since it started
+func ShiftedDoseTimes(schedule Schedule, now time.Time, window Window, shift *TimeShift) []time.Time {
+	doses := DoseTimesIn(schedule, now, window)
+	if shift == nil || shift.MaxMinutesPerDay < 1 {
+		return doses
+	}
+	from, err := time.LoadLocation(shift.FromTimezone)
+	start, errStart := time.Parse("2006-01-02", shift.StartDate)
+	if err != nil || errStart != nil {
+		return doses
+	}
+
+	today := LocalDate(now, now.Location())
+	if today.Before(start) {
+		return doses
+	}
+	limit := time.Duration(shift.MaxMinutesPerDay) * time.Minute * time.Duration(today.Sub(start)/(24*time.Hour)+1)
+
+	year, month, day := now.Date()
+	before := DoseTimesIn(schedule, time.Date(year, month, day, 12, 0, 0, 0, from), shift.From)
+	for i := range doses {
+		// the clock wraps, going back 20 hours is going forward 4
+		delta := doses[i].Sub(before[i])
+		for delta > 12*time.Hour {
+			delta -= 24 * time.Hour
+		}
+		for delta <= -12*time.Hour {
+			delta += 24 * time.Hour
+		}
+		if delta > limit || delta < -limit {
+			doses[i] = before[i].Add(max(min(delta, limit), -limit)).In(now.Location())
+		}
+	}
+
+	return doses
+}
+
+// the waking windows of a schedule on days off, in place of the user's window
+type Variants struct {
+	Weekend *Window `json:"weekend,omitempty"`
+	Holiday *Window `json:"holiday,omitempty"`
+}
+
+// checks both windows, the field paths start with the variant
+func (v Variants) Validate() error {
+	var errs []error
+	if v.Weekend != nil {
+		errs = append(errs, PrefixFields("weekend", v.Weekend.Validate()))
+	}
+	if v.Holiday != nil {
+		errs = append(errs, PrefixFields("holiday", v.Holiday.Validate()))
+	}
+
+	return JoinFields(errs...)
+}
+
+// the window on the day of now: the holiday variant on one of the holidays, given as
+// YYYY-MM-DD, the weekend variant on Saturday and Sunday, otherwise base
+func (v Variants) Window(now time.Time, base Window, holidays []string) Window {
+	if v.Holiday != nil && slices.Contains(holidays, now.Format("2006-01-02")) {
+		return *v.Holiday
+	}
+	if v.Weekend != nil && (now.Weekday() == time.Saturday || now.Weekday() == time.Sunday) {
+		return *v.Weekend
+	}
+
+	return base
+}
+
+// the shortest of base and the variants, the doses are closest together on its days
+func (v Variants) Shortest(base Window) Window {
+	shortest := base
+	for _, variant := range []*Window{v.Weekend, v.Holiday} {
+		if variant != nil && variant.Length() < shortest.Length() {
+			shortest = *variant
+		}
+	}
+
+	return shortest
+}
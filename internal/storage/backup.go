@@ -0,0 +1,183 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"io"
+	"slices"
+	"time"
+)
+
+// the tables of a backup in an order that satisfies their foreign keys. Sessions, OAuth codes,
+// the change log, job state and the recall cache are left out, they are rebuilt or expire anyway.
+// data_key is in, encrypted fields can not be read without it and the same master keys.
+var backupTables = []string{
+	"plan", "users", "organization", "org_member", "oauth_client", "data_key",
+	"medicine_safety", "retention_rule", "feature_flag", "regimen", "schedule_template",
+	"schedule", "intake_log", "dose_skip", "refill", "attachment", "reminder", "schedule_variant", "schedule_notification_settings", "side_effect",
+	"user_notification_settings", "user_quota", "user_recovery_code", "user_token", "api_token",
+	"caregiver_link", "clinician_link", "invite", "contact", "holiday", "push_target", "time_shift", "travel_plan", "share_link",
+	"report", "roster_import", "prescription_scan", "drug_recall_notification", "course_archive",
+	"api_usage", "access_log", "retention_audit", "intake_audit",
+}
+
+// filled by the migrations with defaults, which the backup replaces
+var seededTables = []string{"plan", "retention_rule"}
+
+// the first line of a backup, a backup only restores into a database at the same migration
+type BackupHeader struct {
+	Version   string    `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// every following line is one row, as row_to_json gives it
+type backupLine struct {
+	Table string          `json:"table"`
+	Row   json.RawMessage `json:"row"`
+}
+
+func latestMigration(ctx context.Context, q interface {
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}) (string, error) {
+	var version *string
+	err := q.QueryRow(ctx, "SELECT max(version) FROM schema_migration").Scan(&version)
+	if err != nil {
+		return "", err
+	}
+	if version == nil {
+		return "", errors.New("the database is not migrated")
+	}
+
+	return *version, nil
+}
+
+// writes the application data as JSON lines from one snapshot, returns the rows per table
+func (db *DB) Backup(ctx context.Context, w io.Writer) (map[string]int, error) {
+	tx, err := db.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	version, err := latestMigration(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+	encoder := json.NewEncoder(w)
+	err = encoder.Encode(BackupHeader{Version: version, CreatedAt: time.Now().UTC()})
+	if err != nil {
+		return nil, err
+	}
+
+	counts := map[string]int{}
+	for _, table := range backupTables {
+		rows, err := tx.Query(ctx, "SELECT row_to_json(t) FROM "+table+" t")
+		if err != nil {
+			return counts, fmt.Errorf("%s: %w", table, err)
+		}
+		var row json.RawMessage
+		_, err = pgx.ForEachRow(rows, []any{&row}, func() error {
+			counts[table]++
+			return encoder.Encode(backupLine{Table: table, Row: row})
+		})
+		if err != nil {
+			return counts, fmt.Errorf("%s: %w", table, err)
+		}
+	}
+
+	return counts, nil
+}
+
+// loads a backup into a migrated database without users, in one transaction, and moves
+// the id sequences past the restored rows
+func (db *DB) Restore(ctx context.Context, r io.Reader) (map[string]int, error) {
+	decoder := json.NewDecoder(r)
+	var header BackupHeader
+	err := decoder.Decode(&header)
+	if err != nil || header.Version == "" {
+		return nil, errors.New("not a backup, the header is missing")
+	}
+
+	counts := map[string]int{}
+	err = db.InTx(ctx, func(tx pgx.Tx) error {
+		version, err := latestMigration(ctx, tx)
+		if err != nil {
+			return err
+		}
+		if version != header.Version {
+			return fmt.Errorf("the backup is at migration %s and the database at %s, migrate the database to the same version first", header.Version, version)
+		}
+		var existing bool
+		err = tx.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM users)").Scan(&existing)
+		if err != nil {
+			return err
+		}
+		if existing {
+			return errors.New("the database has users already, restore into an empty database")
+		}
+		for _, table := range seededTables {
+			_, err = tx.Exec(ctx, "DELETE FROM "+table)
+			if err != nil {
+				return err
+			}
+		}
+
+		for {
+			var line backupLine
+			err := decoder.Decode(&line)
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			// table names go into the statement, only the known ones are accepted
+			if err != nil || !slices.Contains(backupTables, line.Table) {
+				return fmt.Errorf("invalid backup line %d", sum(counts)+2)
+			}
+			_, err = tx.Exec(ctx, "INSERT INTO "+line.Table+" SELECT * FROM json_populate_record(NULL::"+line.Table+", $1)", line.Row)
+			if err != nil {
+				return fmt.Errorf("%s: %w", line.Table, err)
+			}
+			counts[line.Table]++
+		}
+
+		// serial columns of the restored tables continue after the highest id
+		query := `SELECT table_name, column_name FROM information_schema.columns
+			WHERE table_schema = current_schema() AND table_name = ANY($1) AND column_default LIKE 'nextval(%'`
+		rows, err := tx.Query(ctx, query, backupTables)
+		if err != nil {
+			return err
+		}
+		type serial struct{ table, column string }
+		serials, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (serial, error) {
+			var s serial
+			err := row.Scan(&s.table, &s.column)
+			return s, err
+		})
+		if err != nil {
+			return err
+		}
+		for _, s := range serials {
+			column := pgx.Identifier{s.column}.Sanitize()
+			query := "SELECT setval(pg_get_serial_sequence($1, $2), COALESCE(max(" + column + "), 1), max(" + column + ") IS NOT NULL) FROM " + pgx.Identifier{s.table}.Sanitize()
+			_, err = tx.Exec(ctx, query, s.table, s.column)
+			if err != nil {
+				return fmt.Errorf("%s: %w", s.table, err)
+			}
+		}
+
+		return nil
+	})
+
+	return counts, err
+}
+
+func sum(counts map[string]int) int {
+	total := 0
+	for _, count := range counts {
+		total += count
+	}
+
+	return total
+}
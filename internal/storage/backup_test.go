@@ -0,0 +1,55 @@
+package storage
+
+import (
+	"context"
+	"io/fs"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// a restore inserts the tables in backup order, so every table a backed up table references comes before it
+func TestBackupTablesFollowForeignKeys(t *testing.T) {
+	names, err := fs.Glob(migrationFiles, "migrations/*.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	references := map[string][]string{}
+	for _, name := range names {
+		script, err := migrationFiles.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		current := ""
+		for _, match := range tableStatement.FindAllStringSubmatch(sqlComment.ReplaceAllString(string(script), ""), -1) {
+			statement, table := strings.ToUpper(match[1]), match[2]
+			switch {
+			case strings.HasPrefix(statement, "CREATE TABLE"), strings.HasPrefix(statement, "ALTER TABLE"):
+				current = table
+			case statement == "REFERENCES" && current != table:
+				references[current] = append(references[current], table)
+			}
+		}
+	}
+
+	for i, table := range backupTables {
+		for _, referenced := range references[table] {
+			at := slices.Index(backupTables, referenced)
+			if at > i {
+				t.Errorf("%s is backed up before %s, which it references", table, referenced)
+			}
+		}
+	}
+}
+
+func TestRestoreRequiresHeader(t *testing.T) {
+	db := &DB{}
+	for _, backup := range []string{"", `{"table":"users","row":{}}`, "not json"} {
+		_, err := db.Restore(context.Background(), strings.NewReader(backup))
+		if err == nil || !strings.Contains(err.Error(), "header is missing") {
+			t.Errorf("Restore of %q = %v", backup, err)
+		}
+	}
+}
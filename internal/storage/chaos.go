@@ -0,0 +1,76 @@
+//go:build chaos
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"log"
+	"math/rand"
+	"os"
+	"strconv"
+	"time"
+)
+
+var errInjectedFault = errors.New("injected database fault")
+
+// faults injected into database calls in chaos builds (go build -tags chaos), rates are between 0 and 1:
+// CHAOS_ERROR_RATE fails the call, CHAOS_DROP_RATE closes the connection under it,
+// CHAOS_LATENCY delays the calls picked by CHAOS_LATENCY_RATE (default every call)
+type chaosTracer struct {
+	errorRate   float64
+	dropRate    float64
+	latency     time.Duration
+	latencyRate float64
+}
+
+func chaosRate(name string, fallback float64) float64 {
+	rate, err := strconv.ParseFloat(os.Getenv(name), 64)
+	if err != nil || rate < 0 || rate > 1 {
+		return fallback
+	}
+
+	return rate
+}
+
+func injectFaults(config *pgxpool.Config) {
+	latency, _ := time.ParseDuration(os.Getenv("CHAOS_LATENCY"))
+	tracer := chaosTracer{
+		errorRate:   chaosRate("CHAOS_ERROR_RATE", 0),
+		dropRate:    chaosRate("CHAOS_DROP_RATE", 0),
+		latency:     latency,
+		latencyRate: chaosRate("CHAOS_LATENCY_RATE", 1),
+	}
+	log.Printf("chaos: error rate %g, drop rate %g, latency %s at rate %g", tracer.errorRate, tracer.dropRate, tracer.latency, tracer.latencyRate)
+
+	config.ConnConfig.Tracer = tracer
+}
+
+// a call fails through its context, which pgx checks before sending the query
+func (c chaosTracer) TraceQueryStart(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	if c.latency > 0 && rand.Float64() < c.latencyRate {
+		select {
+		case <-ctx.Done():
+		case <-time.After(c.latency):
+		}
+	}
+
+	switch {
+	case rand.Float64() < c.dropRate:
+		conn.PgConn().Close(context.Background())
+	case rand.Float64() < c.errorRate:
+		ctx, cancel := context.WithCancelCause(ctx)
+		cancel(errInjectedFault)
+		return ctx
+	}
+
+	return ctx
+}
+
+func (c chaosTracer) TraceQueryEnd(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryEndData) {
+	if errors.Is(context.Cause(ctx), errInjectedFault) {
+		log.Printf("chaos: failed %v", data.Err)
+	}
+}
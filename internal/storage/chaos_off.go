@@ -0,0 +1,8 @@
+//go:build !chaos
+
+package storage
+
+import "github.com/jackc/pgx/v5/pgxpool"
+
+// faults are only injected in chaos builds
+func injectFaults(config *pgxpool.Config) {}
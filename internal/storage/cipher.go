@@ -0,0 +1,606 @@
+package storage
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// encrypted values look like enc:<data key id>:<base64 nonce and ciphertext>,
+// anything else is a plaintext value from before encryption was turned on
+const encryptedPrefix = "enc:"
+
+// encrypts personal fields with data keys stored wrapped in the data_key table
+type Cipher struct {
+	db     *DB
+	mu     sync.RWMutex
+	loaded bool
+	keys   map[int][]byte
+	active int
+}
+
+func NewCipher(db *DB) *Cipher {
+	return &Cipher{db: db, keys: map[int][]byte{}}
+}
+
+// drops the loaded data keys so the next use reads them again, after a rotation on another instance
+func (c *Cipher) Reset() {
+	c.mu.Lock()
+	c.loaded, c.keys, c.active = false, map[int][]byte{}, 0
+	c.mu.Unlock()
+}
+
+// master keys come from FIELD_ENCRYPTION_KEKS as id:base64 pairs separated by commas,
+// FIELD_ENCRYPTION_KEK_ID names the one new data keys are wrapped with
+func masterKeys() (map[string][]byte, string, error) {
+	keks := map[string][]byte{}
+	for _, pair := range strings.Split(os.Getenv("FIELD_ENCRYPTION_KEKS"), ",") {
+		if pair == "" {
+			continue
+		}
+		id, encoded, ok := strings.Cut(pair, ":")
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if !ok || err != nil || len(key) != 32 {
+			return nil, "", fmt.Errorf("invalid master key %q, expected id:base64 of 32 bytes", id)
+		}
+		keks[id] = key
+	}
+
+	current := os.Getenv("FIELD_ENCRYPTION_KEK_ID")
+	if len(keks) > 0 && keks[current] == nil {
+		return nil, "", fmt.Errorf("unknown FIELD_ENCRYPTION_KEK_ID %q", current)
+	}
+
+	return keks, current, nil
+}
+
+func EncryptionEnabled() bool {
+	return os.Getenv("FIELD_ENCRYPTION_KEKS") != ""
+}
+
+func seal(key []byte, plaintext []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func unseal(key []byte, sealed []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+
+	return gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+}
+
+// unwraps all data keys once, creating the first one if there is none yet
+func (c *Cipher) load(ctx context.Context) error {
+	c.mu.RLock()
+	loaded := c.loaded
+	c.mu.RUnlock()
+	if loaded {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.loaded {
+		return nil
+	}
+
+	keks, _, err := masterKeys()
+	if err != nil {
+		return err
+	}
+
+	rows, err := c.db.Query(ctx, "SELECT id, kek_id, wrapped_key, active FROM data_key")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int
+		var kekID string
+		var wrapped []byte
+		var active bool
+		err := rows.Scan(&id, &kekID, &wrapped, &active)
+		if err != nil {
+			return err
+		}
+		if keks[kekID] == nil {
+			return fmt.Errorf("data key %d is wrapped with unknown master key %q", id, kekID)
+		}
+		key, err := unseal(keks[kekID], wrapped)
+		if err != nil {
+			return fmt.Errorf("unwrap data key %d: %w", id, err)
+		}
+		c.keys[id] = key
+		if active {
+			c.active = id
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if c.active == 0 {
+		err = c.addKey(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	c.loaded = true
+
+	return nil
+}
+
+// creates a data key wrapped with the current master key and makes it the active one,
+// the caller holds the lock
+func (c *Cipher) addKey(ctx context.Context) error {
+	keks, current, err := masterKeys()
+	if err != nil {
+		return err
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return err
+	}
+	wrapped, err := seal(keks[current], key)
+	if err != nil {
+		return err
+	}
+
+	var id int
+	err = c.db.InTx(ctx, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, "UPDATE data_key SET active = false WHERE active")
+		if err != nil {
+			return err
+		}
+		err = tx.QueryRow(ctx, "INSERT INTO data_key (kek_id, wrapped_key) VALUES ($1, $2) RETURNING id", current, wrapped).Scan(&id)
+		if err != nil {
+			return err
+		}
+
+		// data keys under retired master keys move to the current one
+		for keyID, dataKey := range c.keys {
+			rewrapped, err := seal(keks[current], dataKey)
+			if err != nil {
+				return err
+			}
+			_, err = tx.Exec(ctx, "UPDATE data_key SET kek_id = $1, wrapped_key = $2 WHERE id = $3", current, rewrapped, keyID)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	c.keys[id] = key
+	c.active = id
+
+	return nil
+}
+
+func (c *Cipher) Encrypt(plaintext string) (string, error) {
+	if !EncryptionEnabled() {
+		return plaintext, nil
+	}
+	err := c.load(context.Background())
+	if err != nil {
+		return "", err
+	}
+
+	c.mu.RLock()
+	id, key := c.active, c.keys[c.active]
+	c.mu.RUnlock()
+
+	sealed, err := seal(key, []byte(plaintext))
+	if err != nil {
+		return "", err
+	}
+
+	return encryptedPrefix + strconv.Itoa(id) + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func (c *Cipher) Decrypt(value string) (string, error) {
+	rest, ok := strings.CutPrefix(value, encryptedPrefix)
+	if !ok {
+		return value, nil
+	}
+	err := c.load(context.Background())
+	if err != nil {
+		return "", err
+	}
+
+	idText, encoded, _ := strings.Cut(rest, ":")
+	id, err := strconv.Atoi(idText)
+	if err != nil {
+		return "", errors.New("malformed encrypted value")
+	}
+	c.mu.RLock()
+	key := c.keys[id]
+	c.mu.RUnlock()
+	if key == nil {
+		return "", fmt.Errorf("unknown data key %d", id)
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", errors.New("malformed encrypted value")
+	}
+	plaintext, err := unseal(key, sealed)
+
+	return string(plaintext), err
+}
+
+// the blind index of encrypted values is only as good as its key, without one anyone
+// with the dump could hash a dictionary of medicine names and compare
+func CheckFieldEncryption() error {
+	_, _, err := masterKeys()
+	if err != nil {
+		return err
+	}
+	if EncryptionEnabled() && os.Getenv("FIELD_INDEX_KEY") == "" {
+		return errors.New("FIELD_INDEX_KEY must be set when FIELD_ENCRYPTION_KEKS is")
+	}
+
+	return nil
+}
+
+// keyed hash of a name for equality lookups on an encrypted column,
+// FIELD_INDEX_KEY must never change once set since all hashes depend on it
+func blindIndex(name string) string {
+	mac := hmac.New(sha256.New, []byte(os.Getenv("FIELD_INDEX_KEY")))
+	mac.Write([]byte(strings.ToLower(strings.TrimSpace(name))))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func MedicineHash(medicine string) string {
+	return blindIndex(medicine)
+}
+
+// the hash a prescriber or pharmacy of the user's directory is found by, whatever its case
+func ContactNameHash(name string) string {
+	return blindIndex(name)
+}
+
+// encrypted medicine and its lookup hash for writing a schedule
+func (c *Cipher) SealMedicine(medicine string) (string, string, error) {
+	encrypted, err := c.Encrypt(medicine)
+
+	return encrypted, MedicineHash(medicine), err
+}
+
+// empty optional fields stay empty, so they can still be told apart from set ones
+func (c *Cipher) EncryptOptional(plaintext string) (string, error) {
+	if plaintext == "" {
+		return "", nil
+	}
+
+	return c.Encrypt(plaintext)
+}
+
+// a value for a JSONB column encrypted as a whole. It is stored as a JSON string so the column
+// keeps its type, nil stays NULL and without master keys the value is stored as plain JSON.
+func (c *Cipher) EncryptJSON(value any) ([]byte, error) {
+	plaintext, err := json.Marshal(value)
+	if err != nil || string(plaintext) == "null" {
+		return nil, err
+	}
+	if !EncryptionEnabled() {
+		return plaintext, nil
+	}
+
+	encrypted, err := c.Encrypt(string(plaintext))
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(encrypted)
+}
+
+// reads a JSONB column written by EncryptJSON into value, plain JSON from before encryption as well
+func (c *Cipher) DecryptJSON(raw []byte, value any) error {
+	if raw == nil {
+		return nil
+	}
+
+	var encrypted string
+	if json.Unmarshal(raw, &encrypted) == nil && strings.HasPrefix(encrypted, encryptedPrefix) {
+		plaintext, err := c.Decrypt(encrypted)
+		if err != nil {
+			return err
+		}
+		raw = []byte(plaintext)
+	}
+
+	return json.Unmarshal(raw, value)
+}
+
+// encrypts a text value again under the active data key, empty stays empty
+func (c *Cipher) reencrypt(value string) (string, error) {
+	plaintext, err := c.Decrypt(value)
+	if err != nil {
+		return "", err
+	}
+
+	return c.EncryptOptional(plaintext)
+}
+
+func (c *Cipher) reencryptJSON(raw []byte) ([]byte, error) {
+	var value json.RawMessage
+	err := c.DecryptJSON(raw, &value)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.EncryptJSON(value)
+}
+
+// the prefix of values under the active data key, empty with encryption off
+func (c *Cipher) currentPrefix(ctx context.Context) (string, error) {
+	if !EncryptionEnabled() {
+		return "", nil
+	}
+	err := c.load(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return encryptedPrefix + strconv.Itoa(c.active) + ":", nil
+}
+
+// fills missing medicine hashes and, with encryption on, re-encrypts the medicine, instructions,
+// prescriber and pharmacy of schedules, the contact directory and the users' TOTP secrets where
+// they are in plaintext or under an older data key
+func (c *Cipher) EncryptSchedules(ctx context.Context) {
+	current, err := c.currentPrefix(ctx)
+	if err != nil {
+		log.Printf("encrypt schedules: %v", err)
+		return
+	}
+
+	query := `SELECT id, medicine, instructions, prescriber, pharmacy FROM schedule
+		WHERE ($1 <> '' AND (NOT starts_with(medicine, $1) OR (instructions <> '' AND NOT starts_with(instructions, $1))
+			OR NOT starts_with(COALESCE(prescriber #>> '{}', $1), $1) OR NOT starts_with(COALESCE(pharmacy #>> '{}', $1), $1)))
+			OR medicine_hash IS NULL
+		LIMIT 500`
+	count := 0
+	for {
+		rows, err := c.db.Query(ctx, query, current)
+		if err != nil {
+			log.Printf("encrypt schedules: %v", err)
+			return
+		}
+		type row struct {
+			id                   int
+			medicine             string
+			instructions         string
+			prescriber, pharmacy []byte
+		}
+		var batch []row
+		for rows.Next() {
+			var r row
+			if err := rows.Scan(&r.id, &r.medicine, &r.instructions, &r.prescriber, &r.pharmacy); err != nil {
+				rows.Close()
+				log.Printf("encrypt schedules: %v", err)
+				return
+			}
+			batch = append(batch, r)
+		}
+		rows.Close()
+		if len(batch) == 0 {
+			break
+		}
+
+		for _, r := range batch {
+			medicine, err := c.Decrypt(r.medicine)
+			var encrypted, hash, instructions string
+			var prescriber, pharmacy []byte
+			if err == nil {
+				encrypted, hash, err = c.SealMedicine(medicine)
+			}
+			if err == nil {
+				instructions, err = c.reencrypt(r.instructions)
+			}
+			if err == nil {
+				prescriber, err = c.reencryptJSON(r.prescriber)
+			}
+			if err == nil {
+				pharmacy, err = c.reencryptJSON(r.pharmacy)
+			}
+			if err == nil {
+				query := "UPDATE schedule SET medicine = $1, medicine_hash = $2, instructions = $3, prescriber = $4, pharmacy = $5 WHERE id = $6"
+				_, err = c.db.Exec(ctx, query, encrypted, hash, instructions, prescriber, pharmacy, r.id)
+			}
+			if err != nil {
+				log.Printf("encrypt schedules: schedule %d: %v", r.id, err)
+				return
+			}
+		}
+		count += len(batch)
+	}
+
+	if count > 0 {
+		log.Printf("encrypt schedules: %d schedules updated", count)
+	}
+
+	c.encryptContacts(ctx, current)
+	c.encryptTOTPSecrets(ctx, current)
+}
+
+// the directory holds the same prescribers and pharmacies as the schedules. Entries from before
+// the name hash are merged into the hashed entry of the same name when there is one.
+func (c *Cipher) encryptContacts(ctx context.Context, current string) {
+	query := `SELECT id::text, user_id, kind, name, phone, email, address, use_count FROM contact
+		WHERE name_hash IS NULL OR ($1 <> '' AND (NOT starts_with(name, $1)
+			OR (phone <> '' AND NOT starts_with(phone, $1)) OR (email <> '' AND NOT starts_with(email, $1)) OR (address <> '' AND NOT starts_with(address, $1))))
+		LIMIT 500`
+	count := 0
+	for {
+		rows, err := c.db.Query(ctx, query, current)
+		if err != nil {
+			log.Printf("encrypt contacts: %v", err)
+			return
+		}
+		type contactRow struct {
+			id, userID, kind            string
+			name, phone, email, address string
+			useCount                    int
+		}
+		batch, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (contactRow, error) {
+			var r contactRow
+			err := row.Scan(&r.id, &r.userID, &r.kind, &r.name, &r.phone, &r.email, &r.address, &r.useCount)
+			return r, err
+		})
+		if err != nil {
+			log.Printf("encrypt contacts: %v", err)
+			return
+		}
+		if len(batch) == 0 {
+			break
+		}
+
+		for _, r := range batch {
+			err := c.db.InTx(ctx, func(tx pgx.Tx) error {
+				name, err := c.Decrypt(r.name)
+				if err != nil {
+					return err
+				}
+				hash := ContactNameHash(name)
+				tag, err := tx.Exec(ctx, `UPDATE contact SET use_count = use_count + $1 WHERE user_id = $2 AND kind = $3 AND name_hash = $4 AND id <> $5::uuid`,
+					r.useCount, r.userID, r.kind, hash, r.id)
+				if err != nil {
+					return err
+				}
+				if tag.RowsAffected() > 0 {
+					_, err = tx.Exec(ctx, "DELETE FROM contact WHERE id = $1::uuid", r.id)
+					return err
+				}
+
+				fields := []string{r.name, r.phone, r.email, r.address}
+				for i := range fields {
+					fields[i], err = c.reencrypt(fields[i])
+					if err != nil {
+						return err
+					}
+				}
+				_, err = tx.Exec(ctx, "UPDATE contact SET name = $1, phone = $2, email = $3, address = $4, name_hash = $5 WHERE id = $6::uuid",
+					fields[0], fields[1], fields[2], fields[3], hash, r.id)
+				return err
+			})
+			if err != nil {
+				log.Printf("encrypt contacts: contact %s: %v", r.id, err)
+				return
+			}
+		}
+		count += len(batch)
+	}
+
+	if count > 0 {
+		log.Printf("encrypt contacts: %d contacts updated", count)
+	}
+}
+
+func (c *Cipher) encryptTOTPSecrets(ctx context.Context, current string) {
+	if current == "" {
+		return
+	}
+
+	query := "SELECT id, totp_secret FROM users WHERE NOT starts_with(totp_secret, $1) LIMIT 500"
+	count := 0
+	for {
+		rows, err := c.db.Query(ctx, query, current)
+		if err != nil {
+			log.Printf("encrypt totp secrets: %v", err)
+			return
+		}
+		type userRow struct {
+			id, secret string
+		}
+		batch, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (userRow, error) {
+			var r userRow
+			err := row.Scan(&r.id, &r.secret)
+			return r, err
+		})
+		if err != nil {
+			log.Printf("encrypt totp secrets: %v", err)
+			return
+		}
+		if len(batch) == 0 {
+			break
+		}
+
+		for _, r := range batch {
+			secret, err := c.reencrypt(r.secret)
+			if err == nil {
+				// a concurrent enrollment wins over the old secret
+				_, err = c.db.Exec(ctx, "UPDATE users SET totp_secret = $1 WHERE id = $2 AND totp_secret = $3", secret, r.id, r.secret)
+			}
+			if err != nil {
+				log.Printf("encrypt totp secrets: user %s: %v", r.id, err)
+				return
+			}
+		}
+		count += len(batch)
+	}
+
+	if count > 0 {
+		log.Printf("encrypt totp secrets: %d users updated", count)
+	}
+}
+
+// starts encrypting new values with a fresh data key and rewraps the existing data keys
+// with the current master key, stored values are re-encrypted by EncryptSchedules
+func (c *Cipher) Rotate(ctx context.Context) error {
+	err := c.load(ctx)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.addKey(ctx)
+}
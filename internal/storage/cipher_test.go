@@ -0,0 +1,174 @@
+package storage
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+type testContact struct {
+	Name  string `json:"name"`
+	Phone string `json:"phone"`
+}
+
+// a cipher with the data keys loaded already, as if read from the data_key table
+func testCipher(t *testing.T, keys map[int][]byte, active int) *Cipher {
+	t.Helper()
+	t.Setenv("FIELD_ENCRYPTION_KEKS", "k1:"+base64.StdEncoding.EncodeToString(testKey(9)))
+	t.Setenv("FIELD_ENCRYPTION_KEK_ID", "k1")
+	t.Setenv("FIELD_INDEX_KEY", "index-key")
+
+	return &Cipher{loaded: true, keys: keys, active: active}
+}
+
+func testKey(b byte) []byte {
+	return []byte(strings.Repeat(string(rune('a'+b)), 32))
+}
+
+func TestEncryptRoundTrip(t *testing.T) {
+	c := testCipher(t, map[int][]byte{1: testKey(1)}, 1)
+
+	encrypted, err := c.Encrypt("Amoxicillin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(encrypted, "enc:1:") || strings.Contains(encrypted, "Amoxicillin") {
+		t.Fatalf("Encrypt = %q", encrypted)
+	}
+	if again, _ := c.Encrypt("Amoxicillin"); again == encrypted {
+		t.Errorf("same ciphertext twice, the nonce is not random")
+	}
+	if decrypted, err := c.Decrypt(encrypted); err != nil || decrypted != "Amoxicillin" {
+		t.Errorf("Decrypt = %q, %v", decrypted, err)
+	}
+	if plain, err := c.Decrypt("Aspirin"); err != nil || plain != "Aspirin" {
+		t.Errorf("plaintext from before encryption = %q, %v", plain, err)
+	}
+
+	tampered := encrypted[:len(encrypted)-2] + "AA"
+	if _, err := c.Decrypt(tampered); err == nil {
+		t.Errorf("tampered value decrypted")
+	}
+	if empty, _ := c.EncryptOptional(""); empty != "" {
+		t.Errorf("EncryptOptional(\"\") = %q", empty)
+	}
+}
+
+func TestEncryptJSONRoundTrip(t *testing.T) {
+	c := testCipher(t, map[int][]byte{1: testKey(1)}, 1)
+
+	raw, err := c.EncryptJSON(&testContact{Name: "Dr. Who", Phone: "+44 20 7946 0000"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(raw), `"enc:1:`) || strings.Contains(string(raw), "Who") {
+		t.Fatalf("EncryptJSON = %s", raw)
+	}
+	var contact *testContact
+	if err := c.DecryptJSON(raw, &contact); err != nil || contact == nil || contact.Name != "Dr. Who" {
+		t.Errorf("DecryptJSON = %+v, %v", contact, err)
+	}
+
+	// plain JSON from before encryption and NULL are read as they are
+	contact = nil
+	if err := c.DecryptJSON([]byte(`{"name": "Corner Pharmacy"}`), &contact); err != nil || contact.Name != "Corner Pharmacy" {
+		t.Errorf("DecryptJSON of plain JSON = %+v, %v", contact, err)
+	}
+	contact = nil
+	if raw, err := c.EncryptJSON((*testContact)(nil)); raw != nil || err != nil {
+		t.Errorf("EncryptJSON(nil) = %s, %v", raw, err)
+	}
+	if err := c.DecryptJSON(nil, &contact); err != nil || contact != nil {
+		t.Errorf("DecryptJSON(nil) = %+v, %v", contact, err)
+	}
+}
+
+func TestRotatedKeysStillDecrypt(t *testing.T) {
+	old := testCipher(t, map[int][]byte{1: testKey(1)}, 1)
+	before, err := old.Encrypt("Ibuprofen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	beforeJSON, err := old.EncryptJSON(testContact{Name: "Dr. Who"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// after a rotation data key 2 is active and key 1 is kept for the values not re-encrypted yet
+	rotated := testCipher(t, map[int][]byte{1: testKey(1), 2: testKey(2)}, 2)
+	if plain, err := rotated.Decrypt(before); err != nil || plain != "Ibuprofen" {
+		t.Errorf("Decrypt under the old key = %q, %v", plain, err)
+	}
+
+	after, err := rotated.reencrypt(before)
+	if err != nil || !strings.HasPrefix(after, "enc:2:") {
+		t.Fatalf("reencrypt = %q, %v", after, err)
+	}
+	afterJSON, err := rotated.reencryptJSON(beforeJSON)
+	if err != nil || !strings.HasPrefix(string(afterJSON), `"enc:2:`) {
+		t.Fatalf("reencryptJSON = %s, %v", afterJSON, err)
+	}
+	var contact testContact
+	if err := rotated.DecryptJSON(afterJSON, &contact); err != nil || contact.Name != "Dr. Who" {
+		t.Errorf("DecryptJSON after rotation = %+v, %v", contact, err)
+	}
+
+	// an instance that never saw key 2 can not read the new values
+	if _, err := old.Decrypt(after); err == nil {
+		t.Errorf("value under an unknown data key decrypted")
+	}
+}
+
+func TestDataKeysRewrapUnderNewMasterKey(t *testing.T) {
+	dataKey := testKey(1)
+	wrapped, err := seal(testKey(10), dataKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unwrapped, err := unseal(testKey(10), wrapped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rewrapped, err := seal(testKey(11), unwrapped)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if key, err := unseal(testKey(11), rewrapped); err != nil || string(key) != string(dataKey) {
+		t.Errorf("rewrapped data key = %q, %v", key, err)
+	}
+	if _, err := unseal(testKey(10), rewrapped); err == nil {
+		t.Errorf("retired master key still unwraps the data key")
+	}
+}
+
+func TestCheckFieldEncryption(t *testing.T) {
+	t.Setenv("FIELD_ENCRYPTION_KEKS", "")
+	t.Setenv("FIELD_INDEX_KEY", "")
+	if err := CheckFieldEncryption(); err != nil {
+		t.Errorf("without encryption: %v", err)
+	}
+
+	t.Setenv("FIELD_ENCRYPTION_KEKS", "k1:"+base64.StdEncoding.EncodeToString(testKey(9)))
+	t.Setenv("FIELD_ENCRYPTION_KEK_ID", "k1")
+	if err := CheckFieldEncryption(); err == nil {
+		t.Errorf("encryption without FIELD_INDEX_KEY accepted")
+	}
+	t.Setenv("FIELD_INDEX_KEY", "index-key")
+	if err := CheckFieldEncryption(); err != nil {
+		t.Errorf("complete configuration: %v", err)
+	}
+	t.Setenv("FIELD_ENCRYPTION_KEK_ID", "k2")
+	if err := CheckFieldEncryption(); err == nil {
+		t.Errorf("unknown FIELD_ENCRYPTION_KEK_ID accepted")
+	}
+
+	if MedicineHash(" Aspirin") != MedicineHash("aspirin") {
+		t.Errorf("medicine hash depends on case or spaces")
+	}
+	hash := MedicineHash("aspirin")
+	t.Setenv("FIELD_INDEX_KEY", "other-key")
+	if MedicineHash("aspirin") == hash {
+		t.Errorf("medicine hash does not depend on FIELD_INDEX_KEY")
+	}
+}
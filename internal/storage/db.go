@@ -0,0 +1,160 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"log"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+const replicaHealthInterval = 10 * time.Second
+
+// the primary pool, whose methods DB has, and an optional read replica for heavy reads
+type DB struct {
+	*pgxpool.Pool
+	// nil when DATABASE_REPLICA_URL is not set
+	Replica        *pgxpool.Pool
+	replicaHealthy atomic.Bool
+	// calls waiting for a connection of either pool
+	waiting atomic.Int64
+}
+
+// opens the primary from DATABASE_URL and the replica from DATABASE_REPLICA_URL when it is set
+func Open() (*DB, error) {
+	db := &DB{}
+	primary, err := openPool("DATABASE_URL", &db.waiting)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	db.Pool = primary
+	if os.Getenv("DATABASE_REPLICA_URL") != "" {
+		db.Replica, err = openPool("DATABASE_REPLICA_URL", &db.waiting)
+		if err != nil {
+			primary.Close()
+			return nil, fmt.Errorf("failed to open replica database: %w", err)
+		}
+	}
+
+	return db, nil
+}
+
+func (db *DB) Close() {
+	db.Pool.Close()
+	if db.Replica != nil {
+		db.Replica.Close()
+	}
+}
+
+func (db *DB) ReplicaHealthy() bool {
+	return db.replicaHealthy.Load()
+}
+
+// how many calls are acquiring a connection right now, it only grows past a few once the pools are exhausted
+func (db *DB) Waiting() int64 {
+	return db.waiting.Load()
+}
+
+// counts acquires in progress and passes queries on to the tracer set before, if any
+type acquireTracer struct {
+	next    pgx.QueryTracer
+	waiting *atomic.Int64
+}
+
+func (t acquireTracer) TraceAcquireStart(ctx context.Context, pool *pgxpool.Pool, data pgxpool.TraceAcquireStartData) context.Context {
+	t.waiting.Add(1)
+	return ctx
+}
+
+func (t acquireTracer) TraceAcquireEnd(ctx context.Context, pool *pgxpool.Pool, data pgxpool.TraceAcquireEndData) {
+	t.waiting.Add(-1)
+}
+
+func (t acquireTracer) TraceQueryStart(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	if t.next == nil {
+		return ctx
+	}
+	return t.next.TraceQueryStart(ctx, conn, data)
+}
+
+func (t acquireTracer) TraceQueryEnd(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryEndData) {
+	if t.next != nil {
+		t.next.TraceQueryEnd(ctx, conn, data)
+	}
+}
+
+// opens a pool for the DSN in the environment variable, counting its acquires in waiting
+func openPool(envName string, waiting *atomic.Int64) (*pgxpool.Pool, error) {
+	config, err := pgxpool.ParseConfig(os.Getenv(envName))
+	if err != nil {
+		return nil, err
+	}
+	// all times are stored and compared in UTC, user timezones apply only at the edges
+	config.ConnConfig.RuntimeParams["timezone"] = "UTC"
+	// statements are prepared on first use per connection and kept in a cache,
+	// DATABASE_STATEMENT_CACHE=describe only caches descriptions for poolers like PgBouncer
+	config.ConnConfig.DefaultQueryExecMode = pgx.QueryExecModeCacheStatement
+	if os.Getenv("DATABASE_STATEMENT_CACHE") == "describe" {
+		config.ConnConfig.DefaultQueryExecMode = pgx.QueryExecModeCacheDescribe
+	}
+	config.ConnConfig.StatementCacheCapacity = 512
+	// new connections pick up database credentials rotated by a secrets refresh
+	config.BeforeConnect = func(ctx context.Context, connConfig *pgx.ConnConfig) error {
+		current, err := pgx.ParseConfig(os.Getenv(envName))
+		if err != nil {
+			return err
+		}
+		connConfig.User = current.User
+		connConfig.Password = current.Password
+		return nil
+	}
+
+	injectFaults(config)
+	config.ConnConfig.Tracer = acquireTracer{next: config.ConnConfig.Tracer, waiting: waiting}
+
+	return pgxpool.NewWithConfig(context.Background(), config)
+}
+
+// does nothing without a replica
+func (db *DB) RunReplicaHealthCheck(ctx context.Context) {
+	if db.Replica == nil {
+		return
+	}
+
+	ticker := time.NewTicker(replicaHealthInterval)
+	defer ticker.Stop()
+
+	for {
+		pingCtx, cancel := context.WithTimeout(ctx, replicaHealthInterval/2)
+		err := db.Replica.Ping(pingCtx)
+		cancel()
+		if healthy := err == nil; db.replicaHealthy.Swap(healthy) != healthy {
+			log.Printf("replica healthy: %t (%v)", healthy, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// runs a read on the replica when there is a healthy one and falls back to the primary
+// if the replica fails. Reads may lag slightly behind the latest writes.
+func (db *DB) QueryRead(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+	if db.Replica != nil && db.replicaHealthy.Load() {
+		rows, err := db.Replica.Query(ctx, sql, args...)
+		if err == nil {
+			return rows, nil
+		}
+		log.Printf("replica query failed, using primary: %v", err)
+		db.replicaHealthy.Store(false)
+	}
+
+	return db.Query(ctx, sql, args...)
+}
@@ -0,0 +1,49 @@
+package storage
+
+import (
+	"context"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"testing"
+)
+
+type countingTracer struct {
+	starts, ends int
+}
+
+func (c *countingTracer) TraceQueryStart(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	c.starts++
+	return ctx
+}
+
+func (c *countingTracer) TraceQueryEnd(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryEndData) {
+	c.ends++
+}
+
+func TestAcquireTracer(t *testing.T) {
+	db := &DB{}
+	next := &countingTracer{}
+	tracers := []acquireTracer{{next: next, waiting: &db.waiting}, {waiting: &db.waiting}}
+
+	ctx := context.Background()
+	for _, tracer := range tracers {
+		tracer.TraceAcquireStart(ctx, nil, pgxpool.TraceAcquireStartData{})
+	}
+	if db.Waiting() != 2 {
+		t.Errorf("%d waiting during two acquires, want 2", db.Waiting())
+	}
+	for _, tracer := range tracers {
+		tracer.TraceAcquireEnd(ctx, nil, pgxpool.TraceAcquireEndData{})
+	}
+	if db.Waiting() != 0 {
+		t.Errorf("%d waiting after the acquires, want 0", db.Waiting())
+	}
+
+	// queries reach the tracer that was set before, without one they are ignored
+	for _, tracer := range tracers {
+		tracer.TraceQueryEnd(tracer.TraceQueryStart(ctx, nil, pgx.TraceQueryStartData{}), nil, pgx.TraceQueryEndData{})
+	}
+	if next.starts != 1 || next.ends != 1 {
+		t.Errorf("wrapped tracer saw %d starts and %d ends, want 1 and 1", next.starts, next.ends)
+	}
+}
@@ -0,0 +1,56 @@
+package storage
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// delay before listening again after the connection was lost
+const listenRetryDelay = 5 * time.Second
+
+// keeps a connection listening on the channels of the handlers until ctx is done.
+// onConnect runs after every (re)connect so state that may have missed notifications is refreshed.
+func (db *DB) Listen(ctx context.Context, handlers map[string]func(payload string), onConnect func()) {
+	for {
+		err := db.listen(ctx, handlers, onConnect)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("listener: %v", err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(listenRetryDelay):
+		}
+	}
+}
+
+func (db *DB) listen(ctx context.Context, handlers map[string]func(payload string), onConnect func()) error {
+	pooled, err := db.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	// a listening connection must not go back to the pool
+	conn := pooled.Hijack()
+	defer conn.Close(context.Background())
+
+	for channel := range handlers {
+		_, err := conn.Exec(ctx, "LISTEN "+channel)
+		if err != nil {
+			return err
+		}
+	}
+	onConnect()
+
+	for {
+		notification, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		if handler, ok := handlers[notification.Channel]; ok {
+			handler(notification.Payload)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package storage
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// applies the migrations not applied yet in file order, each in its own transaction.
+// Databases migrated by hand before the runner existed are marked up to baseline without running anything.
+// MIGRATE_LEGACY_TIMEZONE names the zone of timestamps written before they were stored in UTC, the
+// connections run in UTC so migrations can not take it from the session.
+func (db *DB) Migrate(ctx context.Context, baseline string) ([]string, error) {
+	legacyZone := os.Getenv("MIGRATE_LEGACY_TIMEZONE")
+	if legacyZone != "" {
+		_, err := time.LoadLocation(legacyZone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid MIGRATE_LEGACY_TIMEZONE: %w", err)
+		}
+	}
+
+	_, err := db.Exec(ctx, "CREATE TABLE IF NOT EXISTS schema_migration (version TEXT PRIMARY KEY, applied_at TIMESTAMPTZ NOT NULL DEFAULT now())")
+	if err != nil {
+		return nil, err
+	}
+
+	names, err := fs.Glob(migrationFiles, "migrations/*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	applied := []string{}
+	for _, name := range names {
+		version := strings.TrimSuffix(path.Base(name), ".sql")
+		script, err := migrationFiles.ReadFile(name)
+		if err != nil {
+			return applied, err
+		}
+
+		var ran bool
+		err = db.InTx(ctx, func(tx pgx.Tx) error {
+			tag, err := tx.Exec(ctx, "INSERT INTO schema_migration (version) VALUES ($1) ON CONFLICT DO NOTHING", version)
+			if err != nil || tag.RowsAffected() == 0 {
+				return err
+			}
+			ran = true
+			if baseline != "" && version <= baseline {
+				return nil
+			}
+
+			// for this transaction only, an empty zone is read as not given
+			_, err = tx.Exec(ctx, "SELECT set_config('scheduler.legacy_timezone', $1, true)", legacyZone)
+			if err != nil {
+				return err
+			}
+			_, err = tx.Exec(ctx, string(script))
+			return err
+		})
+		if err != nil {
+			return applied, err
+		}
+		if ran {
+			applied = append(applied, version)
+		}
+	}
+
+	return applied, nil
+}
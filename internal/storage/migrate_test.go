@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"context"
+	"io/fs"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var (
+	sqlComment     = regexp.MustCompile(`--[^\n]*`)
+	tableStatement = regexp.MustCompile(`(?i)\b(CREATE TABLE(?: IF NOT EXISTS)?|ALTER TABLE(?: IF EXISTS)?(?: ONLY)?|RENAME TO|REFERENCES|INDEX(?: IF NOT EXISTS)? \w+ ON)\s+(\w+)`)
+	createsTable   = regexp.MustCompile(`(?i)^(CREATE TABLE|RENAME TO)`)
+	toTimestamptz  = regexp.MustCompile(`(?i)ALTER COLUMN (\w+) TYPE TIMESTAMPTZ([^,;]*)`)
+)
+
+// a fresh database runs every migration from the first, so none may use a table an earlier one did not create
+func TestMigrationsCreateTablesBeforeUse(t *testing.T) {
+	names, err := fs.Glob(migrationFiles, "migrations/*.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	created := map[string]bool{}
+	for _, name := range names {
+		script, err := migrationFiles.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, match := range tableStatement.FindAllStringSubmatch(sqlComment.ReplaceAllString(string(script), ""), -1) {
+			statement, table := match[1], match[2]
+			switch {
+			case createsTable.MatchString(statement):
+				created[table] = true
+			case !created[table]:
+				t.Errorf("%s: %s %s before a migration creates it", name, statement, table)
+			}
+		}
+	}
+}
+
+// the pool runs in UTC, a plain cast would read old wall clock times as UTC
+func TestTimestamptzConversionsNameTheZone(t *testing.T) {
+	names, err := fs.Glob(migrationFiles, "migrations/*.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range names {
+		script, err := migrationFiles.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, match := range toTimestamptz.FindAllStringSubmatch(string(script), -1) {
+			column, using := match[1], match[2]
+			if !strings.Contains(using, "USING "+column+" AT TIME ZONE current_setting('scheduler.legacy_timezone')") {
+				t.Errorf("%s: %s converts to TIMESTAMPTZ without the legacy zone", name, column)
+			}
+		}
+	}
+}
+
+func TestMigrateRejectsInvalidLegacyZone(t *testing.T) {
+	t.Setenv("MIGRATE_LEGACY_TIMEZONE", "Europe/Atlantis")
+	_, err := (&DB{}).Migrate(context.Background(), "")
+	if err == nil || !strings.Contains(err.Error(), "MIGRATE_LEGACY_TIMEZONE") {
+		t.Errorf("Migrate with an unknown zone = %v", err)
+	}
+}
@@ -0,0 +1,10 @@
+-- the schedule table as it was before migrations existed, later migrations build on it.
+-- Databases that already have it are left alone.
+CREATE TABLE IF NOT EXISTS schedule (
+    id         SERIAL PRIMARY KEY,
+    medicine   TEXT      NOT NULL,
+    frequency  INTEGER   NOT NULL DEFAULT 0,
+    duration   INTEGER   NOT NULL DEFAULT 1,
+    user_id    TEXT      NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT now()
+);
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS schedule_template (
+    id         SERIAL PRIMARY KEY,
+    name       TEXT      NOT NULL,
+    medicine   TEXT      NOT NULL,
+    frequency  INTEGER   NOT NULL DEFAULT 0,
+    duration   INTEGER   NOT NULL DEFAULT 1,
+    -- NULL user_id marks a shared template managed by admins
+    user_id    TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS schedule_template_user_id_idx ON schedule_template (user_id);
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS regimen (
+    id         SERIAL PRIMARY KEY,
+    name       TEXT      NOT NULL,
+    user_id    TEXT      NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT now()
+);
+
+ALTER TABLE schedule ADD COLUMN IF NOT EXISTS regimen_id INTEGER REFERENCES regimen (id) ON DELETE SET NULL;
+-- active or paused
+ALTER TABLE schedule ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT 'active';
+
+CREATE INDEX IF NOT EXISTS regimen_user_id_idx ON regimen (user_id);
+CREATE INDEX IF NOT EXISTS schedule_regimen_id_idx ON schedule (regimen_id);
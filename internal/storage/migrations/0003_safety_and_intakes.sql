@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS medicine_safety (
+    -- lower-cased medicine name
+    medicine        TEXT PRIMARY KEY,
+    min_gap_hours   NUMERIC,
+    max_daily_doses INTEGER,
+    -- strict rules reject violations, others only warn
+    strict          BOOLEAN NOT NULL DEFAULT false
+);
+
+CREATE TABLE IF NOT EXISTS intake_log (
+    id          SERIAL PRIMARY KEY,
+    schedule_id INTEGER   NOT NULL REFERENCES schedule (id) ON DELETE CASCADE,
+    user_id     TEXT      NOT NULL,
+    taken_at    TIMESTAMP NOT NULL,
+    created_at  TIMESTAMP NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS intake_log_user_id_taken_at_idx ON intake_log (user_id, taken_at);
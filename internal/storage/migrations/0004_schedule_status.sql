@@ -0,0 +1,3 @@
+ALTER TABLE schedule ADD CONSTRAINT schedule_status_check CHECK (status IN ('active', 'paused', 'completed'));
+
+CREATE INDEX IF NOT EXISTS schedule_user_id_status_idx ON schedule (user_id, status);
@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS drug_recall (
+    recall_number          TEXT      NOT NULL,
+    -- lower-cased medicine name the recall was found for
+    medicine               TEXT      NOT NULL,
+    product_description    TEXT      NOT NULL,
+    reason_for_recall      TEXT      NOT NULL,
+    classification         TEXT      NOT NULL,
+    status                 TEXT      NOT NULL,
+    recall_initiation_date TEXT      NOT NULL,
+    fetched_at             TIMESTAMP NOT NULL DEFAULT now(),
+    PRIMARY KEY (recall_number, medicine)
+);
+
+CREATE TABLE IF NOT EXISTS drug_recall_notification (
+    recall_number TEXT      NOT NULL,
+    user_id       TEXT      NOT NULL,
+    notified_at   TIMESTAMP NOT NULL DEFAULT now(),
+    PRIMARY KEY (recall_number, user_id)
+);
@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS users (
+    id             TEXT PRIMARY KEY,
+    email          TEXT      NOT NULL UNIQUE,
+    password_hash  TEXT      NOT NULL,
+    totp_secret    TEXT,
+    totp_enabled   BOOLEAN   NOT NULL DEFAULT false,
+    -- last accepted 30 second step, so a code can not be replayed
+    totp_last_step BIGINT    NOT NULL DEFAULT 0,
+    created_at     TIMESTAMP NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS user_recovery_code (
+    user_id   TEXT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    code_hash TEXT NOT NULL,
+    used_at   TIMESTAMP,
+    PRIMARY KEY (user_id, code_hash)
+);
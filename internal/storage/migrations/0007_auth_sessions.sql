@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS auth_session (
+    id                  TEXT PRIMARY KEY,
+    user_id             TEXT      NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    refresh_token_hash  TEXT      NOT NULL UNIQUE,
+    -- hash of the token replaced by the last rotation, presenting it again revokes the session
+    previous_token_hash TEXT,
+    user_agent          TEXT      NOT NULL DEFAULT '',
+    ip                  TEXT      NOT NULL DEFAULT '',
+    created_at          TIMESTAMP NOT NULL DEFAULT now(),
+    last_used_at        TIMESTAMP NOT NULL DEFAULT now(),
+    expires_at          TIMESTAMP NOT NULL,
+    revoked_at          TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS auth_session_user_id_idx ON auth_session (user_id);
+CREATE INDEX IF NOT EXISTS auth_session_previous_token_hash_idx ON auth_session (previous_token_hash);
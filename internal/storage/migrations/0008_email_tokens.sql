@@ -0,0 +1,13 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified_at TIMESTAMP;
+
+CREATE TABLE IF NOT EXISTS user_token (
+    token_hash TEXT PRIMARY KEY,
+    user_id    TEXT      NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    -- verify_email or reset_password
+    purpose    TEXT      NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    used_at    TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS user_token_user_id_idx ON user_token (user_id, purpose);
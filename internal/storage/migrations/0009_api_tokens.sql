@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS api_token (
+    id           TEXT PRIMARY KEY,
+    user_id      TEXT      NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    name         TEXT      NOT NULL,
+    token_hash   TEXT      NOT NULL UNIQUE,
+    scopes       TEXT[]    NOT NULL,
+    expires_at   TIMESTAMP,
+    last_used_at TIMESTAMP,
+    created_at   TIMESTAMP NOT NULL DEFAULT now(),
+    revoked_at   TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS api_token_user_id_idx ON api_token (user_id);
@@ -0,0 +1,39 @@
+-- every change to a user's schedules and intakes, the id is the sync cursor
+CREATE TABLE IF NOT EXISTS change_log (
+    id         BIGSERIAL PRIMARY KEY,
+    user_id    TEXT      NOT NULL,
+    -- schedule or intake
+    entity     TEXT      NOT NULL,
+    entity_id  INTEGER   NOT NULL,
+    -- upsert or delete
+    op         TEXT      NOT NULL,
+    changed_at TIMESTAMP NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS change_log_user_id_id_idx ON change_log (user_id, id);
+CREATE INDEX IF NOT EXISTS change_log_entity_idx ON change_log (entity, entity_id, id);
+
+CREATE OR REPLACE FUNCTION log_change() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        INSERT INTO change_log (user_id, entity, entity_id, op) VALUES (OLD.user_id, TG_ARGV[0], OLD.id, 'delete');
+        RETURN OLD;
+    END IF;
+
+    -- a row moved to another user disappears for the old one
+    IF TG_OP = 'UPDATE' AND OLD.user_id <> NEW.user_id THEN
+        INSERT INTO change_log (user_id, entity, entity_id, op) VALUES (OLD.user_id, TG_ARGV[0], OLD.id, 'delete');
+    END IF;
+
+    INSERT INTO change_log (user_id, entity, entity_id, op) VALUES (NEW.user_id, TG_ARGV[0], NEW.id, 'upsert');
+    RETURN NEW;
+END
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS schedule_change_log ON schedule;
+CREATE TRIGGER schedule_change_log AFTER INSERT OR UPDATE OR DELETE ON schedule
+    FOR EACH ROW EXECUTE FUNCTION log_change('schedule');
+
+DROP TRIGGER IF EXISTS intake_log_change_log ON intake_log;
+CREATE TRIGGER intake_log_change_log AFTER INSERT OR UPDATE OR DELETE ON intake_log
+    FOR EACH ROW EXECUTE FUNCTION log_change('intake');
@@ -0,0 +1,13 @@
+ALTER TABLE schedule ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1;
+
+-- every update bumps the version, whichever code path writes the row
+CREATE OR REPLACE FUNCTION bump_version() RETURNS trigger AS $$
+BEGIN
+    NEW.version = OLD.version + 1;
+    RETURN NEW;
+END
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS schedule_bump_version ON schedule;
+CREATE TRIGGER schedule_bump_version BEFORE UPDATE ON schedule
+    FOR EACH ROW EXECUTE FUNCTION bump_version();
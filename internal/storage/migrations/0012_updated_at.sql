@@ -0,0 +1,30 @@
+ALTER TABLE schedule ADD COLUMN IF NOT EXISTS updated_at TIMESTAMP NOT NULL DEFAULT now();
+ALTER TABLE intake_log ADD COLUMN IF NOT EXISTS updated_at TIMESTAMP NOT NULL DEFAULT now();
+ALTER TABLE regimen ADD COLUMN IF NOT EXISTS updated_at TIMESTAMP NOT NULL DEFAULT now();
+ALTER TABLE schedule_template ADD COLUMN IF NOT EXISTS updated_at TIMESTAMP NOT NULL DEFAULT now();
+
+CREATE OR REPLACE FUNCTION touch_updated_at() RETURNS trigger AS $$
+BEGIN
+    NEW.updated_at = now();
+    RETURN NEW;
+END
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS schedule_touch_updated_at ON schedule;
+CREATE TRIGGER schedule_touch_updated_at BEFORE UPDATE ON schedule
+    FOR EACH ROW EXECUTE FUNCTION touch_updated_at();
+
+DROP TRIGGER IF EXISTS intake_log_touch_updated_at ON intake_log;
+CREATE TRIGGER intake_log_touch_updated_at BEFORE UPDATE ON intake_log
+    FOR EACH ROW EXECUTE FUNCTION touch_updated_at();
+
+DROP TRIGGER IF EXISTS regimen_touch_updated_at ON regimen;
+CREATE TRIGGER regimen_touch_updated_at BEFORE UPDATE ON regimen
+    FOR EACH ROW EXECUTE FUNCTION touch_updated_at();
+
+DROP TRIGGER IF EXISTS schedule_template_touch_updated_at ON schedule_template;
+CREATE TRIGGER schedule_template_touch_updated_at BEFORE UPDATE ON schedule_template
+    FOR EACH ROW EXECUTE FUNCTION touch_updated_at();
+
+CREATE INDEX IF NOT EXISTS schedule_user_id_updated_at_idx ON schedule (user_id, updated_at);
+CREATE INDEX IF NOT EXISTS intake_log_user_id_updated_at_idx ON intake_log (user_id, updated_at);
@@ -0,0 +1,2 @@
+ALTER TABLE schedule DROP CONSTRAINT IF EXISTS schedule_status_check;
+ALTER TABLE schedule ADD CONSTRAINT schedule_status_check CHECK (status IN ('active', 'paused', 'completed', 'archived'));
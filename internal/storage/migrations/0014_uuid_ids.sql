@@ -0,0 +1,22 @@
+-- UUIDv7 built from a timestamp: 48 bits of unix milliseconds, version 7, random rest
+CREATE OR REPLACE FUNCTION uuid_v7(ts TIMESTAMP) RETURNS UUID AS $$
+    SELECT encode(
+        set_bit(set_bit(
+            overlay(uuid_send(gen_random_uuid()) PLACING substring(int8send((extract(epoch FROM ts) * 1000)::BIGINT) FROM 3) FROM 1 FOR 6),
+        52, 1), 53, 1),
+    'hex')::UUID
+$$ LANGUAGE sql VOLATILE;
+
+-- first step of the move away from serial ids: every row gets a uuid next to its id,
+-- both are accepted until clients have switched and the uuid becomes the primary key
+ALTER TABLE schedule ADD COLUMN IF NOT EXISTS uuid UUID;
+UPDATE schedule SET uuid = uuid_v7(created_at) WHERE uuid IS NULL;
+ALTER TABLE schedule ALTER COLUMN uuid SET DEFAULT uuid_v7(now()::TIMESTAMP);
+ALTER TABLE schedule ALTER COLUMN uuid SET NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS schedule_uuid_idx ON schedule (uuid);
+
+ALTER TABLE intake_log ADD COLUMN IF NOT EXISTS uuid UUID;
+UPDATE intake_log SET uuid = uuid_v7(created_at) WHERE uuid IS NULL;
+ALTER TABLE intake_log ALTER COLUMN uuid SET DEFAULT uuid_v7(now()::TIMESTAMP);
+ALTER TABLE intake_log ALTER COLUMN uuid SET NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS intake_log_uuid_idx ON intake_log (uuid);
@@ -0,0 +1,65 @@
+-- existing values are wall clock times of the server, they are read in the zone named by
+-- MIGRATE_LEGACY_TIMEZONE so they convert to the right instants. Without it the migration
+-- only runs on a database that has none of these rows yet.
+DO $$
+DECLARE
+    t TEXT;
+    found BOOLEAN;
+BEGIN
+    IF COALESCE(current_setting('scheduler.legacy_timezone', true), '') <> '' THEN
+        RETURN;
+    END IF;
+    FOREACH t IN ARRAY ARRAY['schedule', 'schedule_template', 'regimen', 'intake_log', 'drug_recall', 'drug_recall_notification',
+        'users', 'user_recovery_code', 'auth_session', 'user_token', 'api_token', 'change_log'] LOOP
+        EXECUTE format('SELECT EXISTS (SELECT 1 FROM %I)', t) INTO found;
+        IF found THEN
+            RAISE EXCEPTION 'table % has wall clock times, set MIGRATE_LEGACY_TIMEZONE to the zone they were written in', t;
+        END IF;
+    END LOOP;
+    PERFORM set_config('scheduler.legacy_timezone', 'UTC', true);
+END
+$$;
+
+ALTER TABLE schedule
+    ALTER COLUMN created_at TYPE TIMESTAMPTZ USING created_at AT TIME ZONE current_setting('scheduler.legacy_timezone'),
+    ALTER COLUMN updated_at TYPE TIMESTAMPTZ USING updated_at AT TIME ZONE current_setting('scheduler.legacy_timezone');
+ALTER TABLE schedule_template
+    ALTER COLUMN created_at TYPE TIMESTAMPTZ USING created_at AT TIME ZONE current_setting('scheduler.legacy_timezone'),
+    ALTER COLUMN updated_at TYPE TIMESTAMPTZ USING updated_at AT TIME ZONE current_setting('scheduler.legacy_timezone');
+ALTER TABLE regimen
+    ALTER COLUMN created_at TYPE TIMESTAMPTZ USING created_at AT TIME ZONE current_setting('scheduler.legacy_timezone'),
+    ALTER COLUMN updated_at TYPE TIMESTAMPTZ USING updated_at AT TIME ZONE current_setting('scheduler.legacy_timezone');
+ALTER TABLE intake_log
+    ALTER COLUMN taken_at TYPE TIMESTAMPTZ USING taken_at AT TIME ZONE current_setting('scheduler.legacy_timezone'),
+    ALTER COLUMN created_at TYPE TIMESTAMPTZ USING created_at AT TIME ZONE current_setting('scheduler.legacy_timezone'),
+    ALTER COLUMN updated_at TYPE TIMESTAMPTZ USING updated_at AT TIME ZONE current_setting('scheduler.legacy_timezone');
+ALTER TABLE drug_recall ALTER COLUMN fetched_at TYPE TIMESTAMPTZ USING fetched_at AT TIME ZONE current_setting('scheduler.legacy_timezone');
+ALTER TABLE drug_recall_notification ALTER COLUMN notified_at TYPE TIMESTAMPTZ USING notified_at AT TIME ZONE current_setting('scheduler.legacy_timezone');
+ALTER TABLE users
+    ALTER COLUMN created_at TYPE TIMESTAMPTZ USING created_at AT TIME ZONE current_setting('scheduler.legacy_timezone'),
+    ALTER COLUMN email_verified_at TYPE TIMESTAMPTZ USING email_verified_at AT TIME ZONE current_setting('scheduler.legacy_timezone');
+ALTER TABLE user_recovery_code ALTER COLUMN used_at TYPE TIMESTAMPTZ USING used_at AT TIME ZONE current_setting('scheduler.legacy_timezone');
+ALTER TABLE auth_session
+    ALTER COLUMN created_at TYPE TIMESTAMPTZ USING created_at AT TIME ZONE current_setting('scheduler.legacy_timezone'),
+    ALTER COLUMN last_used_at TYPE TIMESTAMPTZ USING last_used_at AT TIME ZONE current_setting('scheduler.legacy_timezone'),
+    ALTER COLUMN expires_at TYPE TIMESTAMPTZ USING expires_at AT TIME ZONE current_setting('scheduler.legacy_timezone'),
+    ALTER COLUMN revoked_at TYPE TIMESTAMPTZ USING revoked_at AT TIME ZONE current_setting('scheduler.legacy_timezone');
+ALTER TABLE user_token
+    ALTER COLUMN expires_at TYPE TIMESTAMPTZ USING expires_at AT TIME ZONE current_setting('scheduler.legacy_timezone'),
+    ALTER COLUMN used_at TYPE TIMESTAMPTZ USING used_at AT TIME ZONE current_setting('scheduler.legacy_timezone'),
+    ALTER COLUMN created_at TYPE TIMESTAMPTZ USING created_at AT TIME ZONE current_setting('scheduler.legacy_timezone');
+ALTER TABLE api_token
+    ALTER COLUMN expires_at TYPE TIMESTAMPTZ USING expires_at AT TIME ZONE current_setting('scheduler.legacy_timezone'),
+    ALTER COLUMN last_used_at TYPE TIMESTAMPTZ USING last_used_at AT TIME ZONE current_setting('scheduler.legacy_timezone'),
+    ALTER COLUMN created_at TYPE TIMESTAMPTZ USING created_at AT TIME ZONE current_setting('scheduler.legacy_timezone'),
+    ALTER COLUMN revoked_at TYPE TIMESTAMPTZ USING revoked_at AT TIME ZONE current_setting('scheduler.legacy_timezone');
+ALTER TABLE change_log ALTER COLUMN changed_at TYPE TIMESTAMPTZ USING changed_at AT TIME ZONE current_setting('scheduler.legacy_timezone');
+
+CREATE OR REPLACE FUNCTION uuid_v7(ts TIMESTAMPTZ) RETURNS UUID AS $$
+    SELECT uuid_v7(ts AT TIME ZONE 'UTC')
+$$ LANGUAGE sql VOLATILE;
+ALTER TABLE schedule ALTER COLUMN uuid SET DEFAULT uuid_v7(now());
+ALTER TABLE intake_log ALTER COLUMN uuid SET DEFAULT uuid_v7(now());
+
+-- IANA name used to plan a user's doses, e.g. Europe/Berlin
+ALTER TABLE users ADD COLUMN IF NOT EXISTS timezone TEXT NOT NULL DEFAULT 'UTC';
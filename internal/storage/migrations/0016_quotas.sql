@@ -0,0 +1,16 @@
+-- limits per plan, NULL means unlimited
+CREATE TABLE IF NOT EXISTS plan (
+    name                 TEXT PRIMARY KEY,
+    max_active_schedules INTEGER
+);
+
+INSERT INTO plan (name, max_active_schedules) VALUES ('free', 50), ('pro', 500) ON CONFLICT (name) DO NOTHING;
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS plan TEXT NOT NULL DEFAULT 'free' REFERENCES plan (name);
+
+-- admin overrides of the plan limits for single users
+CREATE TABLE IF NOT EXISTS user_quota (
+    user_id              TEXT PRIMARY KEY,
+    max_active_schedules INTEGER,
+    updated_at           TIMESTAMPTZ NOT NULL DEFAULT now()
+);
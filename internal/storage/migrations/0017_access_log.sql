@@ -0,0 +1,32 @@
+-- reads of patient data in compliance mode, separate from any change history
+CREATE TABLE IF NOT EXISTS access_log (
+    id              BIGSERIAL PRIMARY KEY,
+    -- user behind the request, empty for requests without credentials
+    actor           TEXT        NOT NULL,
+    -- whose data was read
+    subject_user_id TEXT        NOT NULL,
+    resource        TEXT        NOT NULL,
+    record_id       TEXT        NOT NULL,
+    method          TEXT        NOT NULL,
+    path            TEXT        NOT NULL,
+    ip              TEXT        NOT NULL,
+    user_agent      TEXT        NOT NULL,
+    accessed_at     TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS access_log_accessed_at_idx ON access_log (accessed_at);
+CREATE INDEX IF NOT EXISTS access_log_subject_user_id_idx ON access_log (subject_user_id, accessed_at);
+
+-- the log is append-only
+CREATE OR REPLACE FUNCTION reject_change() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION '% is append-only', TG_TABLE_NAME;
+END
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS access_log_append_only ON access_log;
+CREATE TRIGGER access_log_append_only BEFORE UPDATE OR DELETE ON access_log
+    FOR EACH ROW EXECUTE FUNCTION reject_change();
+DROP TRIGGER IF EXISTS access_log_no_truncate ON access_log;
+CREATE TRIGGER access_log_no_truncate BEFORE TRUNCATE ON access_log
+    FOR EACH STATEMENT EXECUTE FUNCTION reject_change();
@@ -0,0 +1,17 @@
+-- data keys encrypting sensitive columns, each wrapped by a master key from the environment
+CREATE TABLE IF NOT EXISTS data_key (
+    id          SERIAL PRIMARY KEY,
+    -- id of the master key the data key is wrapped with
+    kek_id      TEXT        NOT NULL,
+    wrapped_key BYTEA       NOT NULL,
+    -- new values are encrypted with the active key only
+    active      BOOLEAN     NOT NULL DEFAULT true,
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- medicine is stored encrypted, equality lookups go through a keyed hash of the lower-cased name
+ALTER TABLE schedule ADD COLUMN IF NOT EXISTS medicine_hash TEXT;
+CREATE INDEX IF NOT EXISTS schedule_user_id_medicine_hash_idx ON schedule (user_id, medicine_hash);
+
+ALTER TABLE drug_recall ADD COLUMN IF NOT EXISTS medicine_hash TEXT;
+CREATE INDEX IF NOT EXISTS drug_recall_medicine_hash_idx ON drug_recall (medicine_hash);
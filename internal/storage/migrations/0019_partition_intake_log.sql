@@ -0,0 +1,51 @@
+-- intake_log becomes partitioned by month of taken_at, old partitions are dropped
+-- or detached for archiving by the partition job
+ALTER TABLE intake_log RENAME TO intake_log_unpartitioned;
+ALTER TABLE intake_log_unpartitioned RENAME CONSTRAINT intake_log_pkey TO intake_log_unpartitioned_pkey;
+ALTER SEQUENCE intake_log_id_seq OWNED BY NONE;
+
+CREATE TABLE intake_log (
+    id          INTEGER     NOT NULL DEFAULT nextval('intake_log_id_seq'),
+    schedule_id INTEGER     NOT NULL REFERENCES schedule (id) ON DELETE CASCADE,
+    user_id     TEXT        NOT NULL,
+    taken_at    TIMESTAMPTZ NOT NULL,
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at  TIMESTAMPTZ NOT NULL DEFAULT now(),
+    uuid        UUID        NOT NULL DEFAULT uuid_v7(now()),
+    PRIMARY KEY (id, taken_at)
+) PARTITION BY RANGE (taken_at);
+
+ALTER SEQUENCE intake_log_id_seq OWNED BY intake_log.id;
+
+-- catches rows outside the monthly partitions so inserts never fail
+CREATE TABLE IF NOT EXISTS intake_log_default PARTITION OF intake_log DEFAULT;
+
+-- creates the partitions of the months from the given one up to ahead months after now
+CREATE OR REPLACE FUNCTION ensure_intake_partitions(since DATE, ahead INTEGER) RETURNS void AS $$
+DECLARE
+    month DATE := date_trunc('month', since);
+BEGIN
+    WHILE month <= date_trunc('month', now()) + make_interval(months => ahead) LOOP
+        EXECUTE format('CREATE TABLE IF NOT EXISTS %I PARTITION OF intake_log FOR VALUES FROM (%L) TO (%L)',
+            'intake_log_y' || to_char(month, 'YYYY') || 'm' || to_char(month, 'MM'), month, month + interval '1 month');
+        month := month + interval '1 month';
+    END LOOP;
+END
+$$ LANGUAGE plpgsql;
+
+SELECT ensure_intake_partitions(COALESCE((SELECT min(taken_at)::date FROM intake_log_unpartitioned), now()::date), 3);
+
+INSERT INTO intake_log (id, schedule_id, user_id, taken_at, created_at, updated_at, uuid)
+    SELECT id, schedule_id, user_id, taken_at, created_at, updated_at, uuid FROM intake_log_unpartitioned;
+
+DROP TABLE intake_log_unpartitioned;
+
+CREATE INDEX IF NOT EXISTS intake_log_user_id_taken_at_idx ON intake_log (user_id, taken_at);
+CREATE INDEX IF NOT EXISTS intake_log_user_id_updated_at_idx ON intake_log (user_id, updated_at);
+-- unique indexes on a partitioned table must contain the partition key
+CREATE UNIQUE INDEX IF NOT EXISTS intake_log_uuid_idx ON intake_log (uuid, taken_at);
+
+CREATE TRIGGER intake_log_touch_updated_at BEFORE UPDATE ON intake_log
+    FOR EACH ROW EXECUTE FUNCTION touch_updated_at();
+CREATE TRIGGER intake_log_change_log AFTER INSERT OR UPDATE OR DELETE ON intake_log
+    FOR EACH ROW EXECUTE FUNCTION log_change('intake');
@@ -0,0 +1,52 @@
+-- retention rules run by the retention worker, the targets are implemented in the service
+CREATE TABLE IF NOT EXISTS retention_rule (
+    target       TEXT PRIMARY KEY,
+    -- purge deletes the rows, anonymize detaches them from the user
+    action       TEXT        NOT NULL CHECK (action IN ('purge', 'anonymize')),
+    max_age_days INTEGER     NOT NULL CHECK (max_age_days > 0),
+    enabled      BOOLEAN     NOT NULL DEFAULT true,
+    updated_at   TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+INSERT INTO retention_rule (target, action, max_age_days) VALUES
+    ('archived_schedules', 'purge', 30),
+    ('intakes', 'anonymize', 730)
+ON CONFLICT (target) DO NOTHING;
+
+-- every execution of a rule, dry runs included
+CREATE TABLE IF NOT EXISTS retention_audit (
+    id       BIGSERIAL PRIMARY KEY,
+    target   TEXT        NOT NULL,
+    action   TEXT        NOT NULL,
+    dry_run  BOOLEAN     NOT NULL,
+    cutoff   TIMESTAMPTZ NOT NULL,
+    affected BIGINT      NOT NULL,
+    ran_at   TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+DROP TRIGGER IF EXISTS retention_audit_append_only ON retention_audit;
+CREATE TRIGGER retention_audit_append_only BEFORE UPDATE OR DELETE ON retention_audit
+    FOR EACH ROW EXECUTE FUNCTION reject_change();
+
+-- anonymized intakes belong to no user and no schedule
+ALTER TABLE intake_log ALTER COLUMN schedule_id DROP NOT NULL;
+
+CREATE OR REPLACE FUNCTION log_change() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        INSERT INTO change_log (user_id, entity, entity_id, op) VALUES (OLD.user_id, TG_ARGV[0], OLD.id, 'delete');
+        RETURN OLD;
+    END IF;
+
+    -- a row moved to another user disappears for the old one
+    IF TG_OP = 'UPDATE' AND OLD.user_id <> NEW.user_id THEN
+        INSERT INTO change_log (user_id, entity, entity_id, op) VALUES (OLD.user_id, TG_ARGV[0], OLD.id, 'delete');
+    END IF;
+
+    -- anonymized rows are synced to nobody
+    IF NEW.user_id <> '' THEN
+        INSERT INTO change_log (user_id, entity, entity_id, op) VALUES (NEW.user_id, TG_ARGV[0], NEW.id, 'upsert');
+    END IF;
+    RETURN NEW;
+END
+$$ LANGUAGE plpgsql;
@@ -0,0 +1,30 @@
+-- changes are announced so every instance can refresh what it keeps in memory
+CREATE OR REPLACE FUNCTION notify_schedule_change() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        PERFORM pg_notify('schedule_changed', OLD.user_id);
+        RETURN OLD;
+    END IF;
+
+    IF TG_OP = 'UPDATE' AND OLD.user_id <> NEW.user_id THEN
+        PERFORM pg_notify('schedule_changed', OLD.user_id);
+    END IF;
+    PERFORM pg_notify('schedule_changed', NEW.user_id);
+    RETURN NEW;
+END
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS schedule_notify_change ON schedule;
+CREATE TRIGGER schedule_notify_change AFTER INSERT OR UPDATE OR DELETE ON schedule
+    FOR EACH ROW EXECUTE FUNCTION notify_schedule_change();
+
+CREATE OR REPLACE FUNCTION notify_data_key_change() RETURNS trigger AS $$
+BEGIN
+    PERFORM pg_notify('data_key_changed', '');
+    RETURN NULL;
+END
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS data_key_notify_change ON data_key;
+CREATE TRIGGER data_key_notify_change AFTER INSERT OR UPDATE OR DELETE ON data_key
+    FOR EACH STATEMENT EXECUTE FUNCTION notify_data_key_change();
@@ -0,0 +1,4 @@
+-- intakes can confirm a planned dose from next_takings, a dose is confirmed at most once
+ALTER TABLE intake_log ADD COLUMN IF NOT EXISTS dose_id TEXT;
+
+CREATE INDEX IF NOT EXISTS intake_log_schedule_id_dose_id_idx ON intake_log (schedule_id, dose_id) WHERE dose_id IS NOT NULL;
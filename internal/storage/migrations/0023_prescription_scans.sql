@@ -0,0 +1,12 @@
+-- photographed prescriptions, the image is kept in the object store under object_key
+CREATE TABLE IF NOT EXISTS prescription_scan (
+    id             UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id        TEXT        NOT NULL,
+    object_key     TEXT        NOT NULL,
+    content_type   TEXT        NOT NULL,
+    size_bytes     INTEGER     NOT NULL,
+    extracted_text TEXT,
+    created_at     TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS prescription_scan_user_id_idx ON prescription_scan (user_id, created_at);
@@ -0,0 +1,14 @@
+-- files of a schedule kept in the object store, pending until the client finished the upload
+CREATE TABLE IF NOT EXISTS attachment (
+    id           UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    schedule_id  INTEGER     NOT NULL REFERENCES schedule (id) ON DELETE CASCADE,
+    user_id      TEXT        NOT NULL,
+    kind         TEXT        NOT NULL CHECK (kind IN ('photo', 'leaflet', 'prescription')),
+    object_key   TEXT        NOT NULL,
+    content_type TEXT        NOT NULL,
+    size_bytes   INTEGER     NOT NULL,
+    status       TEXT        NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'uploaded')),
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS attachment_schedule_id_idx ON attachment (schedule_id);
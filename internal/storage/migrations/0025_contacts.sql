@@ -0,0 +1,18 @@
+-- who prescribed a schedule and where it is filled, kept with the schedule
+ALTER TABLE schedule ADD COLUMN IF NOT EXISTS prescriber JSONB;
+ALTER TABLE schedule ADD COLUMN IF NOT EXISTS pharmacy JSONB;
+
+-- every prescriber and pharmacy a user entered, for autocompletion
+CREATE TABLE IF NOT EXISTS contact (
+    id           UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id      TEXT        NOT NULL,
+    kind         TEXT        NOT NULL CHECK (kind IN ('prescriber', 'pharmacy')),
+    name         TEXT        NOT NULL,
+    phone        TEXT        NOT NULL DEFAULT '',
+    email        TEXT        NOT NULL DEFAULT '',
+    address      TEXT        NOT NULL DEFAULT '',
+    use_count    INTEGER     NOT NULL DEFAULT 1,
+    last_used_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS contact_user_id_kind_name_idx ON contact (user_id, kind, lower(name));
@@ -0,0 +1,15 @@
+-- what a refill of a schedule's medicine cost, amounts are in cents of the currency
+CREATE TABLE IF NOT EXISTS refill (
+    id          UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    schedule_id INTEGER     NOT NULL REFERENCES schedule (id) ON DELETE CASCADE,
+    user_id     TEXT        NOT NULL,
+    filled_on   DATE        NOT NULL,
+    quantity    INTEGER     NOT NULL DEFAULT 0 CHECK (quantity >= 0),
+    cost_cents  BIGINT      NOT NULL CHECK (cost_cents >= 0),
+    copay_cents BIGINT      NOT NULL DEFAULT 0 CHECK (copay_cents >= 0),
+    currency    TEXT        NOT NULL DEFAULT 'USD',
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS refill_user_id_filled_on_idx ON refill (user_id, filled_on);
+CREATE INDEX IF NOT EXISTS refill_schedule_id_idx ON refill (schedule_id);
@@ -0,0 +1,19 @@
+-- reports produced in the background, the finished file is kept until retention purges it
+CREATE TABLE IF NOT EXISTS report (
+    id           UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id      TEXT        NOT NULL,
+    kind         TEXT        NOT NULL CHECK (kind IN ('reimbursement')),
+    format       TEXT        NOT NULL CHECK (format IN ('csv', 'pdf')),
+    period_from  DATE        NOT NULL,
+    period_to    DATE        NOT NULL,
+    status       TEXT        NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'running', 'done', 'failed')),
+    error        TEXT,
+    content      BYTEA,
+    started_at   TIMESTAMPTZ,
+    finished_at  TIMESTAMPTZ,
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS report_pending_idx ON report (created_at) WHERE status IN ('pending', 'running');
+
+INSERT INTO retention_rule (target, action, max_age_days) VALUES ('reports', 'purge', 7) ON CONFLICT (target) DO NOTHING;
@@ -0,0 +1,12 @@
+-- read-only links to a user's plan, without schedule_ids the link shows every active schedule
+CREATE TABLE IF NOT EXISTS share_link (
+    id             TEXT PRIMARY KEY,
+    user_id        TEXT        NOT NULL,
+    schedule_ids   INTEGER[],
+    expires_at     TIMESTAMPTZ NOT NULL,
+    revoked_at     TIMESTAMPTZ,
+    last_viewed_at TIMESTAMPTZ,
+    created_at     TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS share_link_user_id_idx ON share_link (user_id);
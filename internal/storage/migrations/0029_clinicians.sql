@@ -0,0 +1,24 @@
+-- clinicians are given their role by an admin, patients link them to their own data
+ALTER TABLE users ADD COLUMN IF NOT EXISTS role TEXT NOT NULL DEFAULT 'patient' CHECK (role IN ('patient', 'clinician'));
+
+CREATE TABLE IF NOT EXISTS clinician_link (
+    patient_id   TEXT        NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    clinician_id TEXT        NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (patient_id, clinician_id)
+);
+
+CREATE INDEX IF NOT EXISTS clinician_link_clinician_id_idx ON clinician_link (clinician_id);
+
+-- the side-effect diary, descriptions are encrypted like medicines
+CREATE TABLE IF NOT EXISTS side_effect (
+    id          UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id     TEXT        NOT NULL,
+    schedule_id INTEGER     REFERENCES schedule (id) ON DELETE SET NULL,
+    description TEXT        NOT NULL,
+    severity    TEXT        NOT NULL CHECK (severity IN ('mild', 'moderate', 'severe')),
+    occurred_at TIMESTAMPTZ NOT NULL,
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS side_effect_user_id_idx ON side_effect (user_id, occurred_at);
@@ -0,0 +1,29 @@
+-- clinics and their staff, patients onboarded by a clinic are members as well
+CREATE TABLE IF NOT EXISTS organization (
+    id         UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    name       TEXT        NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS org_member (
+    org_id     UUID        NOT NULL REFERENCES organization (id) ON DELETE CASCADE,
+    user_id    TEXT        NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    role       TEXT        NOT NULL CHECK (role IN ('staff', 'patient')),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (org_id, user_id)
+);
+
+-- an uploaded roster, imported in the background with a result per patient
+CREATE TABLE IF NOT EXISTS roster_import (
+    id          UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    org_id      UUID        NOT NULL REFERENCES organization (id) ON DELETE CASCADE,
+    created_by  TEXT        NOT NULL,
+    patients    JSONB       NOT NULL,
+    status      TEXT        NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'running', 'done', 'failed')),
+    results     JSONB,
+    started_at  TIMESTAMPTZ,
+    finished_at TIMESTAMPTZ,
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS roster_import_pending_idx ON roster_import (created_at) WHERE status IN ('pending', 'running');
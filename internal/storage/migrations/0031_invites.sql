@@ -0,0 +1,24 @@
+-- caregivers see a patient's data like linked clinicians, any account can be a caregiver
+CREATE TABLE IF NOT EXISTS caregiver_link (
+    patient_id   TEXT        NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    caregiver_id TEXT        NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (patient_id, caregiver_id)
+);
+
+CREATE INDEX IF NOT EXISTS caregiver_link_caregiver_id_idx ON caregiver_link (caregiver_id);
+
+-- an emailed invitation to become a caregiver or clinician of the inviter, accepted once
+CREATE TABLE IF NOT EXISTS invite (
+    id          UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    inviter_id  TEXT        NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    email       TEXT        NOT NULL,
+    kind        TEXT        NOT NULL CHECK (kind IN ('caregiver', 'clinician')),
+    token_hash  TEXT        NOT NULL UNIQUE,
+    expires_at  TIMESTAMPTZ NOT NULL,
+    accepted_at TIMESTAMPTZ,
+    accepted_by TEXT,
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS invite_inviter_id_idx ON invite (inviter_id);
@@ -0,0 +1,27 @@
+-- reminder settings of a user, and of single schedules replacing them
+CREATE TABLE IF NOT EXISTS user_notification_settings (
+    user_id    TEXT PRIMARY KEY,
+    settings   JSONB       NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS schedule_notification_settings (
+    schedule_id INTEGER PRIMARY KEY REFERENCES schedule (id) ON DELETE CASCADE,
+    settings    JSONB       NOT NULL,
+    updated_at  TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- reminders sent by the reminder worker, the unique key lets only one instance send each
+CREATE TABLE IF NOT EXISTS reminder (
+    id          BIGSERIAL PRIMARY KEY,
+    user_id     TEXT        NOT NULL,
+    schedule_id INTEGER     NOT NULL REFERENCES schedule (id) ON DELETE CASCADE,
+    dose_id     TEXT        NOT NULL,
+    -- 0 for the first reminder of the dose, then the repeats
+    attempt     INTEGER     NOT NULL,
+    channel     TEXT        NOT NULL,
+    sent_at     TIMESTAMPTZ NOT NULL DEFAULT now(),
+    UNIQUE (dose_id, attempt, channel)
+);
+
+CREATE INDEX IF NOT EXISTS reminder_user_id_idx ON reminder (user_id, sent_at);
@@ -0,0 +1,6 @@
+-- the outcome of every reminder, so users and support can check it was delivered
+ALTER TABLE reminder ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT 'sent' CHECK (status IN ('sending', 'sent', 'failed'));
+ALTER TABLE reminder ADD COLUMN IF NOT EXISTS provider_response TEXT;
+ALTER TABLE reminder ADD COLUMN IF NOT EXISTS error TEXT;
+
+INSERT INTO retention_rule (target, action, max_age_days) VALUES ('reminders', 'purge', 90) ON CONFLICT (target) DO NOTHING;
@@ -0,0 +1,9 @@
+-- failed reminders are retried with a delay, after the last try they are parked as dead
+-- until an admin replays them. Cancelled reminders had their dose confirmed before a retry.
+ALTER TABLE reminder DROP CONSTRAINT IF EXISTS reminder_status_check;
+ALTER TABLE reminder ADD CONSTRAINT reminder_status_check CHECK (status IN ('sending', 'sent', 'failed', 'dead', 'cancelled'));
+ALTER TABLE reminder ADD COLUMN IF NOT EXISTS deliveries INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE reminder ADD COLUMN IF NOT EXISTS next_attempt_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS reminder_retry_idx ON reminder (next_attempt_at) WHERE status IN ('failed', 'sending');
+CREATE INDEX IF NOT EXISTS reminder_dead_idx ON reminder (sent_at) WHERE status = 'dead';
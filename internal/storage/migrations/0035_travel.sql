@@ -0,0 +1,6 @@
+-- a trip during which reminders and next doses follow the destination's clock
+CREATE TABLE IF NOT EXISTS travel_plan (
+    user_id    TEXT PRIMARY KEY,
+    plan       JSONB       NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
@@ -0,0 +1,10 @@
+-- the part of the day doses are spread over
+ALTER TABLE users ADD COLUMN IF NOT EXISTS wake_time TEXT NOT NULL DEFAULT '08:00';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS sleep_time TEXT NOT NULL DEFAULT '22:00';
+
+-- a change of the window or timezone that doses follow gradually
+CREATE TABLE IF NOT EXISTS time_shift (
+    user_id    TEXT PRIMARY KEY,
+    shift      JSONB       NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
@@ -0,0 +1,14 @@
+-- days off of a user, doses follow the holiday variant of a schedule on them
+CREATE TABLE IF NOT EXISTS holiday (
+    user_id TEXT NOT NULL,
+    day     DATE NOT NULL,
+    name    TEXT NOT NULL DEFAULT '',
+    PRIMARY KEY (user_id, day)
+);
+
+-- waking windows of a schedule for weekends and holidays
+CREATE TABLE IF NOT EXISTS schedule_variant (
+    schedule_id INTEGER PRIMARY KEY REFERENCES schedule (id) ON DELETE CASCADE,
+    variants    JSONB       NOT NULL,
+    updated_at  TIMESTAMPTZ NOT NULL DEFAULT now()
+);
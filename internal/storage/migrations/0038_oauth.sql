@@ -0,0 +1,20 @@
+-- apps that link accounts over OAuth, like smart speaker skills
+CREATE TABLE IF NOT EXISTS oauth_client (
+    id            TEXT PRIMARY KEY,
+    name          TEXT        NOT NULL,
+    secret_hash   TEXT        NOT NULL,
+    redirect_uris TEXT[]      NOT NULL,
+    scopes        TEXT[]      NOT NULL,
+    created_at    TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- authorization codes, exchanged once for an API token
+CREATE TABLE IF NOT EXISTS oauth_code (
+    code_hash    TEXT PRIMARY KEY,
+    client_id    TEXT        NOT NULL REFERENCES oauth_client (id) ON DELETE CASCADE,
+    user_id      TEXT        NOT NULL,
+    redirect_uri TEXT        NOT NULL,
+    scopes       TEXT[]      NOT NULL,
+    expires_at   TIMESTAMPTZ NOT NULL,
+    used_at      TIMESTAMPTZ
+);
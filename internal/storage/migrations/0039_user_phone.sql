@@ -0,0 +1,2 @@
+-- the number voice call reminders ring, in E.164
+ALTER TABLE users ADD COLUMN IF NOT EXISTS phone TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,2 @@
+-- the Matrix room or XMPP address chat notifiers deliver to
+ALTER TABLE users ADD COLUMN IF NOT EXISTS chat_address TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,9 @@
+-- an ntfy topic or Gotify application a user gets push notifications on, the token is encrypted
+CREATE TABLE IF NOT EXISTS push_target (
+    user_id    TEXT PRIMARY KEY,
+    provider   TEXT        NOT NULL CHECK (provider IN ('ntfy', 'gotify')),
+    server_url TEXT        NOT NULL,
+    topic      TEXT        NOT NULL DEFAULT '',
+    token      TEXT        NOT NULL DEFAULT '',
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
@@ -0,0 +1,16 @@
+-- recorded intakes are announced too, they change which dose is due next
+CREATE OR REPLACE FUNCTION notify_intake_change() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        PERFORM pg_notify('intake_changed', OLD.user_id);
+        RETURN OLD;
+    END IF;
+
+    PERFORM pg_notify('intake_changed', NEW.user_id);
+    RETURN NEW;
+END
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS intake_log_notify_change ON intake_log;
+CREATE TRIGGER intake_log_notify_change AFTER INSERT OR UPDATE OR DELETE ON intake_log
+    FOR EACH ROW EXECUTE FUNCTION notify_intake_change();
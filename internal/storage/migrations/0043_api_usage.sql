@@ -0,0 +1,11 @@
+-- requests per user, API token and day, token_id is empty for sessions
+CREATE TABLE IF NOT EXISTS api_usage (
+    user_id  TEXT   NOT NULL,
+    token_id TEXT   NOT NULL DEFAULT '',
+    day      DATE   NOT NULL,
+    requests BIGINT NOT NULL DEFAULT 0,
+    errors   BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (user_id, token_id, day)
+);
+
+CREATE INDEX IF NOT EXISTS api_usage_day ON api_usage (day);
@@ -0,0 +1,7 @@
+-- the maintenance switch, one row, so instances that start or reconnect pick it up
+CREATE TABLE IF NOT EXISTS maintenance_state (
+    id                  BOOLEAN     PRIMARY KEY DEFAULT true CHECK (id),
+    enabled             BOOLEAN     NOT NULL,
+    retry_after_seconds INTEGER     NOT NULL,
+    updated_at          TIMESTAMPTZ NOT NULL DEFAULT now()
+);
@@ -0,0 +1,4 @@
+-- user defined tags like heart or trial-x, to filter schedules and group reports by
+ALTER TABLE schedule ADD COLUMN IF NOT EXISTS tags TEXT[] NOT NULL DEFAULT '{}';
+
+CREATE INDEX IF NOT EXISTS schedule_tags ON schedule USING gin (tags);
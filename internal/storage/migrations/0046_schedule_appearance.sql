@@ -0,0 +1,3 @@
+-- how clients draw the medicine, empty when the user did not pick one
+ALTER TABLE schedule ADD COLUMN IF NOT EXISTS color TEXT NOT NULL DEFAULT '';
+ALTER TABLE schedule ADD COLUMN IF NOT EXISTS icon TEXT NOT NULL DEFAULT '';
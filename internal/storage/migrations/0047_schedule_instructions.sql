@@ -0,0 +1,2 @@
+-- how to take the medicine, sanitized markdown, encrypted like the medicine when encryption is on
+ALTER TABLE schedule ADD COLUMN IF NOT EXISTS instructions TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,2 @@
+-- shape, color and imprint of the pill, so users can check they hold the right one
+ALTER TABLE schedule ADD COLUMN IF NOT EXISTS pill JSONB;
@@ -0,0 +1,8 @@
+-- weight based dosing: the rule per medicine, the weight of the user and the dose per schedule
+ALTER TABLE medicine_safety ADD COLUMN IF NOT EXISTS mg_per_kg_min NUMERIC;
+ALTER TABLE medicine_safety ADD COLUMN IF NOT EXISTS mg_per_kg_max NUMERIC;
+ALTER TABLE medicine_safety ADD COLUMN IF NOT EXISTS max_mg_per_dose NUMERIC;
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS weight_kg NUMERIC;
+
+ALTER TABLE schedule ADD COLUMN IF NOT EXISTS dose JSONB;
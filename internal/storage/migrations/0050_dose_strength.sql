@@ -0,0 +1,2 @@
+-- what one tablet, ml or other form holds, like 500 mg/tablet, to convert doses between units
+ALTER TABLE schedule ADD COLUMN IF NOT EXISTS strength JSONB;
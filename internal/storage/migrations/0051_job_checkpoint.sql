@@ -0,0 +1,6 @@
+-- how far a job got, so a restarted instance resumes where the last run finished
+CREATE TABLE IF NOT EXISTS job_checkpoint (
+    name TEXT PRIMARY KEY,
+    at TIMESTAMPTZ NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
@@ -0,0 +1,3 @@
+-- the id of the prescription in an EHR or pharmacy system, empty for schedules made in the app
+ALTER TABLE schedule ADD COLUMN IF NOT EXISTS external_id TEXT NOT NULL DEFAULT '';
+CREATE UNIQUE INDEX IF NOT EXISTS schedule_user_id_external_id_idx ON schedule (user_id, external_id) WHERE external_id <> '';
@@ -0,0 +1,13 @@
+-- completed courses exported to the object store as JSONL, pruned_at is set once the rows
+-- left the database and cleared when the course is restored
+CREATE TABLE IF NOT EXISTS course_archive (
+    schedule_id INTEGER     PRIMARY KEY,
+    user_id     TEXT        NOT NULL,
+    object_key  TEXT        NOT NULL,
+    intakes     INTEGER     NOT NULL,
+    archived_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    pruned_at   TIMESTAMPTZ,
+    restored_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS course_archive_user_id_idx ON course_archive (user_id);
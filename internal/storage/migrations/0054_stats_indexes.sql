@@ -0,0 +1,3 @@
+-- the operator stats count signups and notifications per day over all users
+CREATE INDEX IF NOT EXISTS users_created_at_idx ON users (created_at);
+CREATE INDEX IF NOT EXISTS reminder_sent_at_idx ON reminder (sent_at);
@@ -0,0 +1,11 @@
+-- planned doses the user chose not to take, they are neither reminded of nor counted as missed
+CREATE TABLE IF NOT EXISTS dose_skip (
+    schedule_id INTEGER     NOT NULL REFERENCES schedule (id) ON DELETE CASCADE,
+    dose_id     TEXT        NOT NULL,
+    user_id     TEXT        NOT NULL,
+    reason      TEXT        NOT NULL DEFAULT '',
+    skipped_at  TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (schedule_id, dose_id)
+);
+
+CREATE INDEX IF NOT EXISTS dose_skip_user_id_idx ON dose_skip (user_id);
@@ -0,0 +1,3 @@
+-- caregivers opt in to a weekly summary of each patient, sent again a week after summary_sent_at
+ALTER TABLE caregiver_link ADD COLUMN IF NOT EXISTS weekly_summary BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE caregiver_link ADD COLUMN IF NOT EXISTS summary_sent_at TIMESTAMPTZ;
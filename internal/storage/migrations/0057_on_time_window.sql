@@ -0,0 +1,4 @@
+-- minutes either side of the planned time an intake counts as on time, NULL inherits
+-- from the user's organization and then the default
+ALTER TABLE users ADD COLUMN IF NOT EXISTS on_time_minutes INTEGER CHECK (on_time_minutes BETWEEN 0 AND 720);
+ALTER TABLE organization ADD COLUMN IF NOT EXISTS on_time_minutes INTEGER CHECK (on_time_minutes BETWEEN 0 AND 720);
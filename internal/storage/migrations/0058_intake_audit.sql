@@ -0,0 +1,20 @@
+-- intakes logged afterwards or moved to another time, reports tell them apart from live ones
+ALTER TABLE intake_log ADD COLUMN IF NOT EXISTS backfilled BOOLEAN NOT NULL DEFAULT false;
+
+-- backfills and corrections of intakes, before and after are the intake as JSON
+CREATE TABLE IF NOT EXISTS intake_audit (
+    id        BIGSERIAL PRIMARY KEY,
+    intake_id INTEGER     NOT NULL,
+    user_id   TEXT        NOT NULL,
+    action    TEXT        NOT NULL CHECK (action IN ('backfill', 'update')),
+    actor     TEXT        NOT NULL,
+    before    JSONB,
+    after     JSONB,
+    at        TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS intake_audit_intake_id_idx ON intake_audit (intake_id);
+
+DROP TRIGGER IF EXISTS intake_audit_append_only ON intake_audit;
+CREATE TRIGGER intake_audit_append_only BEFORE UPDATE OR DELETE ON intake_audit
+    FOR EACH ROW EXECUTE FUNCTION reject_change();
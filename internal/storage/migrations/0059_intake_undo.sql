@@ -0,0 +1,3 @@
+-- intakes removed shortly after a mistaken confirmation stay in the audit trail
+ALTER TABLE intake_audit DROP CONSTRAINT IF EXISTS intake_audit_action_check;
+ALTER TABLE intake_audit ADD CONSTRAINT intake_audit_action_check CHECK (action IN ('backfill', 'update', 'delete'));
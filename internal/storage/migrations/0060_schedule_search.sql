@@ -0,0 +1,35 @@
+-- search over the schedules of a user or an organization's patients. Encrypted medicine and
+-- instructions are left out of the document, those schedules are found by the medicine hash.
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+ALTER TABLE schedule ADD COLUMN IF NOT EXISTS search tsvector;
+
+CREATE OR REPLACE FUNCTION schedule_search_document() RETURNS trigger AS $$
+BEGIN
+    NEW.search =
+        setweight(to_tsvector('simple', CASE WHEN NEW.medicine LIKE 'enc:%' THEN '' ELSE NEW.medicine END), 'A') ||
+        setweight(to_tsvector('simple', array_to_string(NEW.tags, ' ')), 'B') ||
+        setweight(to_tsvector('simple', CASE WHEN NEW.instructions LIKE 'enc:%' THEN '' ELSE NEW.instructions END), 'C') ||
+        setweight(to_tsvector('simple', concat_ws(' ', NEW.prescriber ->> 'name', NEW.pharmacy ->> 'name')), 'D');
+    RETURN NEW;
+END
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS schedule_search_document ON schedule;
+CREATE TRIGGER schedule_search_document BEFORE INSERT OR UPDATE OF medicine, tags, instructions, prescriber, pharmacy ON schedule
+    FOR EACH ROW EXECUTE FUNCTION schedule_search_document();
+
+-- filling the existing rows is no change of the schedules, versions and the change log stay as they are
+ALTER TABLE schedule DISABLE TRIGGER schedule_bump_version;
+ALTER TABLE schedule DISABLE TRIGGER schedule_touch_updated_at;
+ALTER TABLE schedule DISABLE TRIGGER schedule_change_log;
+ALTER TABLE schedule DISABLE TRIGGER schedule_notify_change;
+UPDATE schedule SET medicine = medicine;
+ALTER TABLE schedule ENABLE TRIGGER schedule_bump_version;
+ALTER TABLE schedule ENABLE TRIGGER schedule_touch_updated_at;
+ALTER TABLE schedule ENABLE TRIGGER schedule_change_log;
+ALTER TABLE schedule ENABLE TRIGGER schedule_notify_change;
+
+CREATE INDEX IF NOT EXISTS schedule_search_idx ON schedule USING gin (search);
+-- partial words and typos in plaintext medicine names
+CREATE INDEX IF NOT EXISTS schedule_medicine_trgm_idx ON schedule USING gin (medicine gin_trgm_ops);
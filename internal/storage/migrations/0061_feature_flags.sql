@@ -0,0 +1,9 @@
+-- flags an admin set, they take the place of flags of the same name in FEATURE_FLAGS
+CREATE TABLE IF NOT EXISTS feature_flag (
+    name        TEXT        PRIMARY KEY,
+    enabled     BOOLEAN     NOT NULL DEFAULT false,
+    percentage  INTEGER     NOT NULL DEFAULT 0 CHECK (percentage BETWEEN 0 AND 100),
+    users       TEXT[]      NOT NULL DEFAULT '{}',
+    description TEXT        NOT NULL DEFAULT '',
+    updated_at  TIMESTAMPTZ NOT NULL DEFAULT now()
+);
@@ -0,0 +1,5 @@
+-- directory entries are found by a keyed hash of the name once names are encrypted,
+-- the encryption pass hashes the entries from before and merges duplicates
+ALTER TABLE contact ADD COLUMN IF NOT EXISTS name_hash TEXT;
+CREATE UNIQUE INDEX IF NOT EXISTS contact_user_id_kind_name_hash_idx ON contact (user_id, kind, name_hash);
+DROP INDEX IF EXISTS contact_user_id_kind_name_idx;
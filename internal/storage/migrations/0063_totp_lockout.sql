@@ -0,0 +1,3 @@
+-- wrong second factors in a row, enough of them lock the second factor for a while
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_failures     INT NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_locked_until TIMESTAMP;
@@ -0,0 +1,23 @@
+package storage
+
+import (
+	"context"
+	"github.com/jackc/pgx/v5"
+)
+
+// runs fn as one unit of work: its writes through tx are committed together when it
+// returns nil and rolled back on any error, which is passed through unchanged
+func (db *DB) InTx(ctx context.Context, fn func(tx pgx.Tx) error) error {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	err = fn(tx)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
@@ -0,0 +1,330 @@
+"use strict";
+
+const pages = ["login", "today", "schedules", "calendar"];
+const $ = (id) => document.getElementById(id);
+
+let calendarMonth = new Date();
+calendarMonth.setDate(1);
+
+function show(message, failed) {
+	$("message").textContent = message || "";
+	$("message").className = failed ? "error" : "";
+}
+
+function session() {
+	return JSON.parse(localStorage.getItem("session") || "null");
+}
+
+function saveSession(tokens) {
+	if (tokens) {
+		localStorage.setItem("session", JSON.stringify(tokens));
+	} else {
+		localStorage.removeItem("session");
+	}
+}
+
+// the legacy list endpoints write JSON objects back to back, or a plain text note when there is nothing
+function parseObjects(text) {
+	const objects = [];
+	let depth = 0, start = -1, quoted = false, escaped = false;
+	for (let i = 0; i < text.length; i++) {
+		const c = text[i];
+		if (quoted) {
+			if (escaped) escaped = false;
+			else if (c === "\\") escaped = true;
+			else if (c === '"') quoted = false;
+			continue;
+		}
+		if (c === '"') quoted = true;
+		else if (c === "{") {
+			if (depth++ === 0) start = i;
+		} else if (c === "}" && --depth === 0) {
+			objects.push(JSON.parse(text.slice(start, i + 1)));
+		}
+	}
+	return objects;
+}
+
+async function refresh() {
+	const current = session();
+	if (!current || !current.refresh_token) return false;
+	const response = await fetch("/v1/auth/refresh", {
+		method: "POST",
+		headers: {"Content-Type": "application/json"},
+		body: JSON.stringify({refresh_token: current.refresh_token}),
+	});
+	if (!response.ok) {
+		saveSession(null);
+		return false;
+	}
+	saveSession(await response.json());
+	return true;
+}
+
+// the message of an error response, the API answers with {"error": ...} or plain text
+async function errorText(response) {
+	const text = (await response.text()).trim();
+	try {
+		return JSON.parse(text).error || response.statusText;
+	} catch {
+		return text || response.statusText;
+	}
+}
+
+// calls the API with the session's access token, refreshing it once when it expired
+async function api(path, options = {}, retried = false) {
+	const current = session();
+	const headers = Object.assign({}, options.headers);
+	if (current && current.access_token) headers.Authorization = "Bearer " + current.access_token;
+	if (options.body && !headers["Content-Type"]) headers["Content-Type"] = "application/json";
+
+	const response = await fetch(path, Object.assign({}, options, {headers}));
+	if (response.status === 401 && !retried && await refresh()) {
+		return api(path, options, true);
+	}
+	if (response.status === 401) {
+		saveSession(null);
+		route();
+		throw new Error("your session expired, log in again");
+	}
+	if (!response.ok) {
+		throw new Error(await errorText(response));
+	}
+	return response;
+}
+
+async function login(event) {
+	event.preventDefault();
+	const form = new FormData(event.target);
+	const response = await fetch("/v1/auth/login", {
+		method: "POST",
+		headers: {"Content-Type": "application/json"},
+		body: JSON.stringify({email: form.get("email"), password: form.get("password"), otp: form.get("otp")}),
+	});
+	if (!response.ok) {
+		show(await errorText(response), true);
+		return;
+	}
+	saveSession(await response.json());
+	event.target.reset();
+	show("");
+	location.hash = "#/today";
+	route();
+}
+
+async function logout() {
+	try {
+		await api("/v1/auth/logout", {method: "POST"});
+	} catch (err) {
+		// the local session goes either way
+	}
+	saveSession(null);
+	route();
+}
+
+async function schedules() {
+	const response = await api("/schedules");
+	return parseObjects(await response.text());
+}
+
+async function loadToday() {
+	const list = $("doses");
+	list.replaceChildren();
+	const doses = parseObjects(await (await api("/next_takings")).text());
+	if (doses.length === 0) {
+		list.append(item("Nothing due in the next hours."));
+		return;
+	}
+	for (const dose of doses) {
+		const row = item(`${dose.take_time} ${dose.medicine}, dose ${dose.dose_number}` + (dose.total_doses ? ` of ${dose.total_doses}` : ""));
+		const button = document.createElement("button");
+		button.textContent = "Taken";
+		button.addEventListener("click", () => confirmIntake(dose, button));
+		row.append(" ", button);
+		list.append(row);
+	}
+}
+
+async function confirmIntake(dose, button) {
+	button.disabled = true;
+	try {
+		await api("/v1/intakes", {method: "POST", body: JSON.stringify({dose_id: dose.dose_id})});
+		button.textContent = "Recorded";
+		show(`${dose.medicine} recorded`);
+	} catch (err) {
+		button.disabled = false;
+		show(err.message, true);
+	}
+}
+
+async function loadSchedules() {
+	const rows = $("schedule-rows");
+	rows.replaceChildren();
+	for (const s of await schedules()) {
+		const row = document.createElement("tr");
+		const progress = s.progress ? `${s.progress.dose}` + (s.progress.total ? ` of ${s.progress.total}` : "") : "";
+		for (const value of [s.medicine, s.frequency || "no end", s.duration, s.status, progress]) {
+			const cell = document.createElement("td");
+			cell.textContent = value;
+			row.append(cell);
+		}
+
+		const actions = document.createElement("td");
+		const edit = document.createElement("button");
+		edit.textContent = "Edit";
+		edit.addEventListener("click", () => editSchedule(s));
+		const remove = document.createElement("button");
+		remove.textContent = "Delete";
+		remove.addEventListener("click", () => deleteSchedule(s));
+		actions.append(edit, " ", remove);
+		row.append(actions);
+		rows.append(row);
+	}
+}
+
+function editSchedule(s) {
+	const form = $("schedule-form");
+	for (const name of ["id", "version", "medicine", "frequency", "duration", "status"]) {
+		form.elements[name].value = s[name] ?? "";
+	}
+	$("schedule-form-title").textContent = "Edit " + s.medicine;
+	form.elements.medicine.focus();
+}
+
+function resetScheduleForm() {
+	$("schedule-form").elements.id.value = "";
+	$("schedule-form").elements.version.value = "";
+	$("schedule-form-title").textContent = "New schedule";
+}
+
+async function saveSchedule(event) {
+	event.preventDefault();
+	const form = event.target;
+	const body = {
+		medicine: form.elements.medicine.value,
+		frequency: Number(form.elements.frequency.value),
+		duration: Number(form.elements.duration.value),
+		status: form.elements.status.value,
+	};
+
+	try {
+		if (form.elements.id.value) {
+			// updates carry the version they were made from so concurrent edits are not lost
+			await api("/v1/schedules/" + form.elements.id.value, {
+				method: "PUT",
+				headers: {"If-Match": `"${form.elements.version.value}"`},
+				body: JSON.stringify(body),
+			});
+		} else {
+			await api("/schedule", {method: "POST", body: JSON.stringify(body)});
+		}
+		form.reset();
+		resetScheduleForm();
+		show(body.medicine + " saved");
+		await loadSchedules();
+	} catch (err) {
+		show(err.message, true);
+	}
+}
+
+async function deleteSchedule(s) {
+	if (!confirm(`Delete ${s.medicine}?`)) return;
+	try {
+		await api("/delete?schedule_id=" + encodeURIComponent(s.id));
+		show(s.medicine + " deleted");
+		await loadSchedules();
+	} catch (err) {
+		show(err.message, true);
+	}
+}
+
+// a schedule runs from the day it was created for frequency days, or forever when frequency is 0
+function runsOn(s, day) {
+	const created = new Date(s.created_at);
+	const start = new Date(created.getFullYear(), created.getMonth(), created.getDate());
+	if (day < start) return false;
+	if (!s.frequency) return true;
+	const end = new Date(start);
+	end.setDate(end.getDate() + s.frequency);
+	return day < end;
+}
+
+async function loadCalendar() {
+	const grid = $("calendar-grid");
+	grid.replaceChildren();
+	$("calendar-title").textContent = calendarMonth.toLocaleDateString(undefined, {month: "long", year: "numeric"});
+
+	const active = (await schedules()).filter((s) => s.status === "active" || s.status === "paused");
+	const first = new Date(calendarMonth);
+	// weeks start on Monday
+	const offset = (first.getDay() + 6) % 7;
+	for (let i = 0; i < offset; i++) {
+		grid.append(document.createElement("div"));
+	}
+
+	const today = new Date().toDateString();
+	for (const day = new Date(first); day.getMonth() === first.getMonth(); day.setDate(day.getDate() + 1)) {
+		const cell = document.createElement("div");
+		cell.className = "day" + (day.toDateString() === today ? " today" : "");
+		const number = document.createElement("strong");
+		number.textContent = day.getDate();
+		cell.append(number);
+		for (const s of active.filter((s) => runsOn(s, day))) {
+			const entry = document.createElement("span");
+			entry.className = s.status;
+			entry.textContent = `${s.medicine} ×${s.duration}`;
+			cell.append(entry);
+		}
+		grid.append(cell);
+	}
+}
+
+function item(text) {
+	const li = document.createElement("li");
+	li.textContent = text;
+	return li;
+}
+
+// servers in local mode need no login, every request is the local user's
+async function detectLocalMode() {
+	const response = await fetch("/v1/auth/me");
+	if (response.ok && (await response.json()).local) {
+		saveSession({local: true});
+	}
+}
+
+async function route() {
+	let page = location.hash.replace("#/", "") || "today";
+	if (!pages.includes(page)) page = "today";
+	if (!session()) await detectLocalMode();
+	if (!session()) page = "login";
+
+	$("nav").hidden = page === "login";
+	$("logout").hidden = Boolean(session() && session().local);
+	for (const name of pages) {
+		$(name).hidden = name !== page;
+	}
+
+	try {
+		if (page === "today") await loadToday();
+		if (page === "schedules") await loadSchedules();
+		if (page === "calendar") await loadCalendar();
+	} catch (err) {
+		show(err.message, true);
+	}
+}
+
+$("login-form").addEventListener("submit", login);
+$("logout").addEventListener("click", logout);
+$("schedule-form").addEventListener("submit", saveSchedule);
+$("schedule-cancel").addEventListener("click", resetScheduleForm);
+$("calendar-prev").addEventListener("click", () => {
+	calendarMonth.setMonth(calendarMonth.getMonth() - 1);
+	loadCalendar().catch((err) => show(err.message, true));
+});
+$("calendar-next").addEventListener("click", () => {
+	calendarMonth.setMonth(calendarMonth.getMonth() + 1);
+	loadCalendar().catch((err) => show(err.message, true));
+});
+window.addEventListener("hashchange", route);
+route();